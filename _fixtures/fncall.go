@@ -0,0 +1,32 @@
+package main
+
+import "fmt"
+
+//go:noinline
+func add(a, b int) int {
+	return a + b
+}
+
+//go:noinline
+func greet(name string) string {
+	return "hello " + name
+}
+
+//go:noinline
+func divmod(a, b int) (int, int) {
+	return a / b, a % b
+}
+
+//go:noinline
+func boom(msg string) {
+	panic(msg)
+}
+
+func main() {
+	x, name := 40, "world"
+	fmt.Println(add(x, 2), greet(name))
+	fmt.Println(divmod(x, 3))
+	if x == 0 {
+		boom(name)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+/*
+// Stops at a hardcoded breakpoint in C code called from Go.
+__attribute__((noinline)) static void ctrap(void) {
+#if defined(__aarch64__)
+	__asm__ volatile("brk #0");
+#else
+	__asm__ volatile("int3");
+#endif
+}
+
+__attribute__((noinline)) void cfunc(void) {
+	ctrap();
+}
+*/
+import "C"
+
+func callC() {
+	C.cfunc()
+}
+
+func main() {
+	callC()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type key int
+
+const (
+	userKey key = iota
+	requestKey
+)
+
+func handle(ctx context.Context, started, done chan struct{}) {
+	fmt.Println(ctx.Value(userKey))
+	started <- struct{}{}
+	<-done
+}
+
+func main() {
+	ctx := context.WithValue(context.Background(), userKey, "gopher")
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+	ctx = context.WithValue(ctx, requestKey, 42)
+
+	started, done := make(chan struct{}), make(chan struct{})
+	go handle(ctx, started, done)
+	<-started
+	// Give handle time to block on done.
+	time.Sleep(50 * time.Millisecond)
+	panic("handled")
+}
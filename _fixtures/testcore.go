@@ -0,0 +1,15 @@
+package main
+
+import "fmt"
+
+var message string
+
+func crash(msg string) {
+	message = msg
+	fmt.Println(message)
+	panic(message)
+}
+
+func main() {
+	crash("post-mortem")
+}
@@ -0,0 +1,33 @@
+package main
+
+import "sync"
+
+var (
+	a, b    sync.Mutex
+	updates = make(chan int)
+	nilchan chan int
+)
+
+func second(started chan bool) {
+	b.Lock()
+	started <- true
+	a.Lock()
+}
+
+func consumer() {
+	<-updates
+}
+
+func forever() {
+	<-nilchan
+}
+
+func main() {
+	go consumer()
+	go forever()
+	a.Lock()
+	started := make(chan bool)
+	go second(started)
+	<-started
+	b.Lock()
+}
@@ -0,0 +1,34 @@
+package main
+
+import "fmt"
+
+func cleanup(n int) {
+	fmt.Println("cleanup", n)
+}
+
+func inner() {
+	for i := 0; i < 2; i++ {
+		defer cleanup(i)
+	}
+	panic("boom")
+}
+
+func recovering() {
+	defer func() {
+		fmt.Println("recovered", recover())
+	}()
+	inner()
+}
+
+func closing() (n int) {
+	defer func() {
+		n++
+	}()
+	n = 41
+	return n
+}
+
+func main() {
+	fmt.Println(closing())
+	recovering()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+func returning(wg *sync.WaitGroup) {
+	defer wg.Done()
+	fmt.Println("returning")
+}
+
+func exiting(wg *sync.WaitGroup) {
+	defer wg.Done()
+	runtime.Goexit()
+}
+
+func main() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go returning(&wg)
+	wg.Wait()
+	// Lets returning exit before exiting starts.
+	time.Sleep(100 * time.Millisecond)
+	wg.Add(1)
+	go exiting(&wg)
+	wg.Wait()
+	fmt.Println("done")
+}
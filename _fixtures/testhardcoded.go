@@ -0,0 +1,13 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func main() {
+	runtime.Breakpoint()
+	fmt.Println("first")
+	runtime.Breakpoint()
+	fmt.Println("second")
+}
@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+type Session struct {
+	ID      int
+	User    *string
+	History [80]int
+}
+
+type Blob struct {
+	Chunks [5000]*byte
+}
+
+var (
+	sessions []*Session
+	blobs    []*Blob
+)
+
+func allocated() {
+	fmt.Println(len(sessions), len(blobs))
+}
+
+func main() {
+	user := "gopher"
+	for i := 0; i < 20; i++ {
+		sessions = append(sessions, &Session{ID: i, User: &user})
+	}
+	for i := 0; i < 2; i++ {
+		blobs = append(blobs, new(Blob))
+	}
+	allocated()
+}
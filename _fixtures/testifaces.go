@@ -0,0 +1,30 @@
+package main
+
+import "fmt"
+
+type Shape interface {
+	Area() float64
+	Name() string
+}
+
+type Square struct{ side float64 }
+
+func (s Square) Area() float64 { return s.side * s.side }
+func (s Square) Name() string  { return "square" }
+
+type Circle struct{ r float64 }
+
+func (c *Circle) Area() float64 { return 3 * c.r * c.r }
+func (c *Circle) Name() string  { return "circle" }
+
+type Line struct{}
+
+func (Line) Area() float64 { return 0 }
+
+func main() {
+	shapes := []Shape{Square{2}, &Circle{1}}
+	for _, s := range shapes {
+		fmt.Println(s.Name(), s.Area())
+	}
+	fmt.Println(Line{}.Area())
+}
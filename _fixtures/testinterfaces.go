@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct{ side float64 }
+
+func (s Square) Area() float64 { return s.side * s.side }
+
+type Circle struct{ r float64 }
+
+func (c *Circle) Area() float64 { return 3 * c.r * c.r }
+
+var (
+	square  Shape = Square{2}
+	circle  Shape = &Circle{1}
+	none    Shape
+	number  interface{} = 42
+	text    interface{} = "hello"
+	failure error       = errors.New("failed")
+	success error
+)
+
+func inspect() {
+	fmt.Println(square, circle, none, number, text, failure, success)
+}
+
+func main() {
+	fmt.Println(square.Area(), circle.Area())
+	inspect()
+}
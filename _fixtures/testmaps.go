@@ -0,0 +1,26 @@
+package main
+
+import "fmt"
+
+func main() {
+	var (
+		small   = map[string]int{"one": 1, "two": 2, "three": 3}
+		large   = make(map[int]string)
+		split   = make(map[int]int)
+		nilmap  map[string]int
+		ch      = make(chan int, 10)
+		nilchan chan int
+		s       = make([]int, 3, 10)
+		str     = "hello"
+	)
+	// More entries than fit in a group, and than in a table.
+	for i := 0; i < 100; i++ {
+		large[i] = fmt.Sprint("v", i)
+	}
+	for i := 0; i < 5000; i++ {
+		split[i] = i * 2
+	}
+	ch <- 1
+	ch <- 2
+	fmt.Println(len(small), len(large), len(split), nilmap, len(ch), nilchan, s, str)
+}
@@ -0,0 +1,19 @@
+package main
+
+var total int
+
+// Busy for a while, without sleeping or allocating, so that
+// stepping over it only needs the thread running it.
+func work(i int) int {
+	s := 0
+	for j := 0; j < 1000000; j++ {
+		s += i * j
+	}
+	return s
+}
+
+func main() {
+	for i := 0; ; i++ {
+		total += work(i)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	var (
+		t1  = time.Date(2009, time.November, 10, 23, 0, 0, 5, time.UTC)
+		mu  = new(sync.Mutex)
+		err = errors.New("boom")
+		sb  = new(strings.Builder)
+		buf = bytes.NewBufferString("skip:rest")
+		n1  = new(big.Int).Lsh(big.NewInt(1), 100)
+		n2  = big.NewInt(-42)
+	)
+	sb.WriteString("built")
+	buf.Next(5)
+	mu.Lock()
+	mu.Unlock()
+	fmt.Println(t1, err, sb.String(), buf, n1, n2)
+}
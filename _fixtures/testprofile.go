@@ -0,0 +1,19 @@
+package main
+
+import "fmt"
+
+func spin(n int) int {
+	sum := 0
+	for i := 0; i < n; i++ {
+		sum += i * i
+	}
+	return sum
+}
+
+func main() {
+	for {
+		if spin(1000000) == 0 {
+			fmt.Println("overflow")
+		}
+	}
+}
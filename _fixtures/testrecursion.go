@@ -0,0 +1,18 @@
+package main
+
+import "fmt"
+
+func fact(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	r := n * fact(n-1)
+	return r
+}
+
+func main() {
+	fmt.Println(fact(5))
+	fmt.Println(down(3))
+}
+
+func down(n int) bool { return n == 0 || down(n-1) }
@@ -0,0 +1,11 @@
+package main
+
+import "fmt"
+
+func regargs(n int, s string) int {
+	return n + len(s)
+}
+
+func main() {
+	fmt.Println(regargs(42, "register"))
+}
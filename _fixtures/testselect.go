@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var (
+	requests = make(chan int)
+	replies  = make(chan string)
+	quit     chan bool
+)
+
+func serve() {
+	select {
+	case n := <-requests:
+		fmt.Println(n)
+	case replies <- "done":
+	case <-quit:
+	}
+}
+
+func waiting() {
+	fmt.Println("waiting")
+}
+
+func main() {
+	go serve()
+	time.Sleep(100 * time.Millisecond)
+	waiting()
+}
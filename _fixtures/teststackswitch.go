@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func grow(n int) int {
+	var buf [1024]byte
+	buf[n%len(buf)] = byte(n)
+	if n == 0 {
+		return 0
+	}
+	return grow(n-1) + int(buf[n%len(buf)])
+}
+
+func main() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	fmt.Println(grow(100), stats.NumGC)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// Each goroutine holds on to a thread while it sleeps, so the
+// runtime keeps starting new ones, and the thread exits with it.
+func churn() {
+	runtime.LockOSThread()
+	time.Sleep(time.Millisecond)
+}
+
+func main() {
+	for {
+		go churn()
+		time.Sleep(100 * time.Microsecond)
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+func fired() {
+	fmt.Println("fired")
+}
+
+func ready() {
+	fmt.Println("ready")
+}
+
+func main() {
+	time.AfterFunc(time.Hour, fired)
+	ticker := time.NewTicker(time.Minute)
+	// Channel timers are only kept by the runtime
+	// while a goroutine waits on them.
+	go func() {
+		for range ticker.C {
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	ready()
+}
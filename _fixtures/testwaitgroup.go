@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+var wg sync.WaitGroup
+
+func worker(release chan bool) {
+	<-release
+	wg.Done()
+}
+
+func waiting() {
+	fmt.Println("waiting")
+}
+
+func inspect(release chan bool) {
+	time.Sleep(100 * time.Millisecond)
+	waiting()
+	close(release)
+}
+
+func main() {
+	release := make(chan bool)
+	wg.Add(3)
+	for i := 0; i < 3; i++ {
+		go worker(release)
+	}
+	go inspect(release)
+	wg.Wait()
+}
@@ -3,12 +3,14 @@ package cli
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	sys "golang.org/x/sys/unix"
 
@@ -20,7 +22,15 @@ import (
 
 const historyFile string = ".dbg_history"
 
-func Run(args []string) {
+// Run at the start of the session when no other init file is given.
+const defaultInitFile string = ".dlvinit"
+
+// Runs the debugger on the program described by args, setting it
+// up according to cfg and then running the commands of initFile, if
+// any, before handing control to the user. Without initFile, those of
+// .dlvinit in the working directory are run if it exists. Programs
+// launched by the debugger are launched according to lcfg.
+func Run(args []string, lcfg proctl.LaunchConfig, cfg proctl.Config, initFile string) {
 	var (
 		dbp *proctl.DebuggedProcess
 		err error
@@ -30,61 +40,127 @@ func Run(args []string) {
 
 	switch args[0] {
 	case "run":
-		const debugname = "debug"
-		cmd := exec.Command("go", "build", "-o", debugname, "-gcflags", "-N -l")
-		err := cmd.Run()
-		if err != nil {
-			t.die(1, "Could not compile program:", err)
+		// The package to build, the one in the working directory by
+		// default, and the arguments of the program after --.
+		pkg, progargs := ".", args[1:]
+		if len(progargs) > 0 && progargs[0] != "--" {
+			pkg, progargs = progargs[0], progargs[1:]
 		}
-		defer os.Remove(debugname)
-
-		dbp, err = proctl.Launch(append([]string{"./" + debugname}, args...))
+		if len(progargs) > 0 && progargs[0] == "--" {
+			progargs = progargs[1:]
+		}
+		debugname, build := t.build("build", pkg)
+		lcfg.Build = build
+		dbp, err = proctl.LaunchWithConfig(append([]string{debugname}, progargs...), lcfg)
 		if err != nil {
 			t.die(1, "Could not launch program:", err)
 		}
 	case "test":
-		wd, err := os.Getwd()
-		if err != nil {
-			t.die(1, err)
-		}
-		base := filepath.Base(wd)
-		cmd := exec.Command("go", "test", "-c", "-gcflags", "-N -l")
-		err = cmd.Run()
-		if err != nil {
-			t.die(1, "Could not compile program:", err)
+		// The first argument, unless it is a flag, selects the tests
+		// to run, the others are given to the test binary.
+		debugname, build := t.build("test", "-c")
+		lcfg.Build = build
+		testargs := args[1:]
+		if len(testargs) > 0 && !strings.HasPrefix(testargs[0], "-") {
+			testargs = append([]string{"-test.run", testargs[0]}, testargs[1:]...)
 		}
-		debugname := "./" + base + ".test"
-		defer os.Remove(debugname)
-
-		dbp, err = proctl.Launch(append([]string{debugname}, args...))
+		dbp, err = proctl.LaunchWithConfig(append([]string{debugname}, testargs...), lcfg)
 		if err != nil {
 			t.die(1, "Could not launch program:", err)
 		}
 	case "attach":
+		// A pid, or the name of the executable.
 		pid, err := strconv.Atoi(args[1])
-		if err != nil {
-			t.die(1, "Invalid pid", args[1])
+		if err == nil {
+			dbp, err = proctl.Attach(pid)
+		} else {
+			dbp, err = proctl.AttachByName(args[1])
+		}
+		if tae, ok := err.(proctl.TaskAccessError); ok && tae.LaunchOnly {
+			t.die(1, "Could not attach to process:", err, "\nLaunching the program with dlv run or dlv <path> may still work.")
 		}
-		dbp, err = proctl.Attach(pid)
 		if err != nil {
 			t.die(1, "Could not attach to process:", err)
 		}
+	case "record":
+		tracedir, err := proctl.Record(args[1:])
+		if err != nil {
+			t.die(1, "Could not record program:", err)
+		}
+		dbp, err = proctl.Replay(tracedir)
+		if err != nil {
+			t.die(1, "Could not replay recording:", err)
+		}
+	case "core":
+		if len(args) < 3 {
+			t.die(1, "Usage: dlv core <path to binary> <path to core file>")
+		}
+		dbp, err = proctl.OpenCore(args[2], args[1])
+		if err != nil {
+			t.die(1, "Could not open core file:", err)
+		}
+	case "connect":
+		if len(args) < 2 {
+			t.die(1, "Usage: dlv connect <agent address> [<path to binary>] [<target path prefix>=<local path prefix>...]")
+		}
+		// The binary, or how to find it from its path on the target.
+		var scfg proctl.SymbolConfig
+		for _, arg := range args[2:] {
+			if i := strings.Index(arg, "="); i >= 0 {
+				scfg.PathMap = append(scfg.PathMap, proctl.PathMapping{From: arg[:i], To: arg[i+1:]})
+			} else {
+				scfg.Binary = arg
+			}
+		}
+		dbp, err = proctl.ConnectAgentWithConfig(args[1], scfg)
+		if err != nil {
+			t.die(1, "Could not connect to agent:", err)
+		}
+	case "trace":
+		if len(args) < 3 {
+			t.die(1, "Usage: dlv trace <regexp> <path to binary> [args...]")
+		}
+		dbp, err = proctl.LaunchWithConfig(args[2:], lcfg)
+		if err != nil {
+			t.die(1, "Could not launch program:", err)
+		}
+		trace(dbp, t, args[1])
+	case "diagnose":
+		if len(args) < 2 {
+			t.die(1, "Usage: dlv diagnose <path to binary>")
+		}
+		fmt.Print(proctl.DiagnoseBinary(args[1]))
+		os.Exit(0)
+	case "replay":
+		dbp, err = proctl.Replay(args[1])
+		if err != nil {
+			t.die(1, "Could not replay recording:", err)
+		}
 	default:
-		dbp, err = proctl.Launch(args)
+		dbp, err = proctl.LaunchWithConfig(args, lcfg)
 		if err != nil {
 			t.die(1, "Could not launch program:", err)
 		}
 	}
 
-	ch := make(chan os.Signal)
-	signal.Notify(ch, sys.SIGINT)
-	go func() {
-		for _ = range ch {
-			if dbp.Running() {
-				dbp.RequestManualStop()
-			}
-		}
-	}()
+	// Resume through the continue command, which
+	// reports where the process stopped.
+	resume := cfg.Resume
+	cfg.Resume = false
+	if err := dbp.Setup(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s, not resuming\n", err)
+		resume = false
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sys.SIGINT, sys.SIGTERM)
+	shutdown := handleSignals(dbp, ch)
+
+	// Thread events are reported once the command
+	// that caused them is done, the other events are
+	// already reported by the commands themselves.
+	events := make(chan proctl.Event, 64)
+	dbp.Notify(events)
 
 	cmds := command.DebugCommands()
 	f, err := os.Open(historyFile)
@@ -93,10 +169,46 @@ func Run(args []string) {
 	}
 	t.line.ReadHistory(f)
 	f.Close()
+	t.line.SetWordCompleter(func(line string, pos int) (string, []string, string) {
+		return completeLocation(dbp, line, pos)
+	})
 	fmt.Println("Type 'help' for list of commands.")
 
+	if initFile == "" {
+		if _, err := os.Stat(defaultInitFile); err == nil {
+			initFile = defaultInitFile
+		}
+	}
+	if initFile != "" {
+		if err := cmds.RunFile(dbp, initFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Init file %s failed: %s\n", initFile, err)
+		}
+		printThreadEvents(events)
+	}
+
+	if resume {
+		runCommand(dbp, cmds, "continue")
+		printThreadEvents(events)
+	}
+
+	// The prompt is read in another goroutine,
+	// so that signals can be handled meanwhile.
+	prompt, input := t.promptInBackground()
 	for {
-		cmdstr, err := t.promptForInput()
+		select {
+		case req := <-shutdown:
+			handleSignal(t, req)
+		default:
+		}
+
+		prompt <- struct{}{}
+		var in promptResult
+		select {
+		case req := <-shutdown:
+			handleSignal(t, req)
+		case in = <-input:
+		}
+		cmdstr, err := in.line, in.err
 		if err != nil {
 			if err == io.EOF {
 				handleExit(dbp, t, 0)
@@ -109,49 +221,162 @@ func Run(args []string) {
 			handleExit(dbp, t, 0)
 		}
 
-		if dbp.Exited() && cmdstr != "help" {
+		if dbp.Exited() && cmdstr != "help" && cmdstr != "restart" && cmdstr != "rebuild" {
 			fmt.Fprintf(os.Stderr, "Process has already exited.\n")
 			continue
 		}
 
-		cmd := cmds.Find(cmdstr)
-		if err := cmd(dbp, args...); err != nil {
-			switch err.(type) {
-			case proctl.ProcessExitedError:
-				pe := err.(proctl.ProcessExitedError)
-				fmt.Fprintf(os.Stderr, "Process exited with status %d\n", pe.Status)
-			default:
-				fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
-			}
+		runCommand(dbp, cmds, cmdstr, args...)
+		printThreadEvents(events)
+	}
+}
+
+func runCommand(dbp *proctl.DebuggedProcess, cmds *command.Commands, cmdstr string, args ...string) {
+	if cmdstr != "" {
+		cmds.Record(strings.Join(append([]string{cmdstr}, args...), " "))
+	}
+	cmd := cmds.Find(cmdstr)
+	if err := cmd(dbp, args...); err != nil {
+		switch err.(type) {
+		case proctl.ProcessExitedError:
+			pe := err.(proctl.ProcessExitedError)
+			fmt.Fprintf(os.Stderr, "Process exited with status %d\n", pe.Status)
+		default:
+			fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
 		}
 	}
 }
 
-func handleExit(dbp *proctl.DebuggedProcess, t *Term, status int) {
-	if f, err := os.OpenFile(historyFile, os.O_RDWR, 0666); err == nil {
-		_, err := t.line.WriteHistory(f)
-		if err != nil {
-			fmt.Println("readline history error: ", err)
+func printThreadEvents(events <-chan proctl.Event) {
+	for {
+		select {
+		case ev := <-events:
+			switch ev.Kind {
+			case proctl.EventThreadCreated, proctl.EventThreadExited, proctl.EventThreadSwitched:
+				fmt.Println(ev)
+			}
+		default:
+			return
 		}
-		f.Close()
 	}
+}
 
-	if !dbp.Exited() {
-		for _, bp := range dbp.HWBreakPoints {
-			if bp == nil {
+// A signal shutting the debugger down, once the process was detached.
+type shutdownRequest struct {
+	sig os.Signal
+	err error // Detaching failed
+}
+
+// Handles the signals of ch. SIGINT stops the process while it runs.
+// Otherwise, as does SIGTERM, it removes the breakpoints and detaches
+// from the process, or kills it if the debugger launched it, so it is
+// never left with traps nobody will handle, and then requests the
+// debugger to shut down. Detach stops the command running the process
+// first, as Kill does, so that they don't race.
+func handleSignals(dbp *proctl.DebuggedProcess, ch <-chan os.Signal) <-chan shutdownRequest {
+	shutdown := make(chan shutdownRequest, 1)
+	go func() {
+		for sig := range ch {
+			if sig == sys.SIGINT && dbp.Running() {
+				dbp.RequestManualStop()
 				continue
 			}
-			if _, err := dbp.Clear(bp.Addr); err != nil {
-				fmt.Printf("Can't clear breakpoint @%x: %s\n", bp.Addr, err)
+			var err error
+			if !dbp.Exited() {
+				fmt.Println("Detaching from process...")
+				err = dbp.Detach(true)
+				if _, ok := err.(proctl.ProcessExitedError); ok {
+					// It exited meanwhile.
+					err = nil
+				}
 			}
+			shutdown <- shutdownRequest{sig: sig, err: err}
+			return
 		}
+	}()
+	return shutdown
+}
+
+// Shuts the debugger down as requested by handleSignals.
+func handleSignal(t *Term, req shutdownRequest) {
+	fmt.Fprintf(os.Stderr, "\nReceived %s\n", req.sig)
+	if req.err != nil {
+		t.die(2, "Could not detach", req.err)
+	}
+	t.die(128+int(req.sig.(sys.Signal)), "Exiting on", req.sig)
+}
+
+// Runs the process to completion, printing the calls of the functions
+// matching re as they happen, and exits with its status. SIGINT and
+// SIGTERM kill it.
+func trace(dbp *proctl.DebuggedProcess, t *Term, re string) {
+	g, err := dbp.TraceFunctions(re, func(fc proctl.FunctionCall) { fmt.Println(fc) })
+	if err != nil {
+		dbp.Detach(true)
+		t.die(1, "Could not trace functions:", err)
+	}
+	fmt.Fprintf(os.Stderr, "Tracing %d functions\n", len(g.BreakPoints))
 
-		for pc := range dbp.BreakPoints {
-			if _, err := dbp.Clear(pc); err != nil {
-				fmt.Printf("Can't clear breakpoint @%x: %s\n", pc, err)
+	var interrupted int32
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sys.SIGINT, sys.SIGTERM)
+	go func() {
+		for range ch {
+			atomic.StoreInt32(&interrupted, 1)
+			dbp.RequestManualStop()
+		}
+	}()
+
+	// The process only stops for signals and hardcoded
+	// breakpoints, and goes on after them.
+	for atomic.LoadInt32(&interrupted) == 0 {
+		err := dbp.Continue()
+		if pe, ok := err.(proctl.ProcessExitedError); ok {
+			status := pe.Status
+			if pe.Signal != 0 {
+				status = 128 + int(pe.Signal)
 			}
+			t.die(status, pe)
+		}
+		if err != nil {
+			dbp.Detach(true)
+			t.die(1, "Tracing failed:", err)
+		}
+	}
+	dbp.Detach(true)
+	t.die(1, "Tracing interrupted")
+}
+
+// Builds a binary with the go subcommand and arguments given, into a
+// temporary directory removed on exit, with optimizations and inlining
+// disabled so that variables and lines aren't optimized away. Returns
+// the path of the binary and the command line building it.
+func (t *Term) build(subcmd string, args ...string) (string, []string) {
+	dir, err := ioutil.TempDir("", "dlv-")
+	if err != nil {
+		t.die(1, err)
+	}
+	t.onExit(func() { os.RemoveAll(dir) })
+	debugname := filepath.Join(dir, "debug")
+	build := append([]string{"go", subcmd, "-o", debugname, "-gcflags", "-N -l"}, args...)
+	cmd := exec.Command(build[0], build[1:]...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.die(1, "Could not compile program:", err)
+	}
+	return debugname, build
+}
+
+func handleExit(dbp *proctl.DebuggedProcess, t *Term, status int) {
+	if f, err := os.OpenFile(historyFile, os.O_RDWR, 0666); err == nil {
+		_, err := t.line.WriteHistory(f)
+		if err != nil {
+			fmt.Println("readline history error: ", err)
 		}
+		f.Close()
+	}
 
+	if !dbp.Exited() {
 		answer, err := t.line.Prompt("Would you like to kill the process? [y/n]")
 		if err != nil {
 			t.die(2, io.EOF)
@@ -159,36 +384,35 @@ func handleExit(dbp *proctl.DebuggedProcess, t *Term, status int) {
 		answer = strings.TrimSuffix(answer, "\n")
 
 		fmt.Println("Detaching from process...")
-		err = sys.PtraceDetach(dbp.Process.Pid)
-		if err != nil {
+		if err := dbp.Detach(answer == "y"); err != nil {
 			t.die(2, "Could not detach", err)
 		}
-
-		if answer == "y" {
-			fmt.Println("Killing process", dbp.Process.Pid)
-
-			err := dbp.Process.Kill()
-			if err != nil {
-				fmt.Println("Could not kill process", err)
-			}
-		}
 	}
 
 	t.die(status, "Hope I was of service hunting your bug!")
 }
 
 type Term struct {
-	prompt string
-	line   *liner.State
+	prompt  string
+	line    *liner.State
+	cleanup []func()
+}
+
+// Runs fn when the debugger exits, e.g. to remove the binary it built,
+// as the debugger exits through die rather than returning from Run.
+func (t *Term) onExit(fn func()) {
+	t.cleanup = append(t.cleanup, fn)
 }
 
 func (t *Term) die(status int, args ...interface{}) {
 	if t.line != nil {
 		t.line.Close()
 	}
+	for _, fn := range t.cleanup {
+		fn()
+	}
 
-	fmt.Fprint(os.Stderr, args)
-	fmt.Fprint(os.Stderr, "\n")
+	fmt.Fprintln(os.Stderr, args...)
 	os.Exit(status)
 }
 
@@ -206,6 +430,44 @@ func (t *Term) promptForInput() (string, error) {
 	return l, nil
 }
 
+type promptResult struct {
+	line string
+	err  error
+}
+
+// Starts reading input in a goroutine, prompting for a
+// line every time a value is sent to the returned channel.
+func (t *Term) promptInBackground() (chan<- struct{}, <-chan promptResult) {
+	prompt := make(chan struct{})
+	input := make(chan promptResult)
+	go func() {
+		for _ = range prompt {
+			line, err := t.promptForInput()
+			input <- promptResult{line, err}
+		}
+	}()
+	return prompt, input
+}
+
+// The commands whose first argument is a location.
+var locationCommands = map[string]bool{
+	"break": true, "b": true,
+	"trace": true,
+	"until": true, "u": true,
+	"list": true, "l": true,
+}
+
+// Completes the function name typed as the first argument of the
+// commands taking a location, the line being left as is otherwise.
+func completeLocation(dbp *proctl.DebuggedProcess, line string, pos int) (string, []string, string) {
+	head, tail := line[:pos], line[pos:]
+	i := strings.Index(head, " ")
+	if i < 0 || !locationCommands[head[:i]] || strings.ContainsAny(head[i+1:], " :") {
+		return head, nil, tail
+	}
+	return head[:i+1], dbp.CompleteFunction(head[i+1:]), tail
+}
+
 func parseCommand(cmdstr string) (string, []string) {
 	vals := strings.Split(cmdstr, " ")
 	return vals[0], vals[1:]
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"testing"
+	"time"
+
+	sys "golang.org/x/sys/unix"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+func withTestProcess(name string, t *testing.T, fn func(p *proctl.DebuggedProcess)) {
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", name, "../../_fixtures/"+name+".go").Run(); err != nil {
+		t.Fatalf("Could not compile %s due to %s", name, err)
+	}
+	defer os.Remove("./" + name)
+
+	p, err := proctl.Launch([]string{"./" + name})
+	if err != nil {
+		t.Fatal("Launch():", err)
+	}
+	defer p.Process.Kill()
+
+	fn(p)
+}
+
+// Continues p in the background, returning once it runs.
+func continueInBackground(p *proctl.DebuggedProcess, t *testing.T) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- p.Continue() }()
+	for deadline := time.Now().Add(5 * time.Second); !p.Running(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the process to run")
+		}
+	}
+	return done
+}
+
+func TestSignals(t *testing.T) {
+	withTestProcess("testprog", t, func(p *proctl.DebuggedProcess) {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, sys.SIGINT)
+		defer signal.Stop(ch)
+		shutdown := handleSignals(p, ch)
+
+		// SIGINT stops the process while it runs.
+		done := continueInBackground(p, t)
+		if err := sys.Kill(os.Getpid(), sys.SIGINT); err != nil {
+			t.Fatal("Kill():", err)
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal("Continue():", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected SIGINT to stop the process")
+		}
+		if p.StopReason() != proctl.StopManual || p.Exited() {
+			t.Fatalf("Expected the process to be stopped manually, stopped for %s", p.StopReason())
+		}
+		select {
+		case req := <-shutdown:
+			t.Fatalf("Unexpected shutdown on %s", req.sig)
+		default:
+		}
+
+		// Otherwise it shuts the debugger down, killing the
+		// process it launched.
+		if err := sys.Kill(os.Getpid(), sys.SIGINT); err != nil {
+			t.Fatal("Kill():", err)
+		}
+		select {
+		case req := <-shutdown:
+			if req.sig != sys.SIGINT || req.err != nil {
+				t.Fatalf("Unexpected shutdown on %s: %v", req.sig, req.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected SIGINT to shut the debugger down")
+		}
+		if !p.Exited() {
+			t.Fatal("Expected the process to be killed")
+		}
+	})
+
+	withTestProcess("testprog", t, func(p *proctl.DebuggedProcess) {
+		ch := make(chan os.Signal, 1)
+		shutdown := handleSignals(p, ch)
+
+		// SIGTERM stops the command running the process
+		// before detaching from it.
+		done := continueInBackground(p, t)
+		ch <- sys.SIGTERM
+		select {
+		case req := <-shutdown:
+			if req.sig != sys.SIGTERM || req.err != nil {
+				t.Fatalf("Unexpected shutdown on %s: %v", req.sig, req.err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected SIGTERM to shut the debugger down")
+		}
+		select {
+		case <-done:
+		default:
+			t.Fatal("Expected Continue to return before detaching")
+		}
+		if !p.Exited() {
+			t.Fatal("Expected the process to be killed")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+// Command dlv-agent serves a process to a Delve running on another
+// machine, for targets too small to run Delve itself. Symbols are
+// only read on the debugger's side, from its copy of the program.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/derekparker/delve/proctl"
+)
+
+const usage = `Usage:
+
+  dlv-agent [-listen <addr>] <path to program> [args...]
+  dlv-agent [-listen <addr>] -attach <pid>
+
+then connect to the agent from the machine with a copy of the program:
+
+  dlv connect <addr> <path to program>
+`
+
+func main() {
+	var (
+		addr string
+		pid  int
+	)
+	flag.StringVar(&addr, "listen", ":2345", "Address to serve the process on.")
+	flag.IntVar(&pid, "attach", 0, "Serve the running process with this pid.")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (pid == 0) == (flag.NArg() == 0) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "Serving on %s\n", l.Addr())
+
+	var cmd []string
+	if pid == 0 {
+		cmd = flag.Args()
+	}
+	if err := proctl.ServeAgent(l, cmd, pid); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
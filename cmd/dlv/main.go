@@ -4,40 +4,97 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"runtime"
+	"strings"
 
 	"github.com/derekparker/delve/client/cli"
+	"github.com/derekparker/delve/proctl"
 )
 
-const version string = "0.5.0.beta"
+const version string = proctl.Version
 
 var usage string = fmt.Sprintf(`Delve version %s
 
 flags:
   -v Print version
+  -break <location> Set a breakpoint before handing control over, can be repeated
+  -continue Resume the program once it is launched or attached to
+  -wd <dir> Working directory of launched programs
+  -env <name=value> Add to the environment of launched programs, can be repeated
+  -stdin <file> Redirect the standard input of launched programs from a file
+  -tty <path> Terminal for the input and output of launched programs
+  -pathmap <from=to> Map the source paths of the program to local ones, e.g. for programs built in a container, can be repeated
+  -log Trace the requests made to the process and its stops
+  -init <file> Run the commands of the file, one per line, before handing control over, instead of those of .dlvinit
 
 Invoke with the path to a binary:
 
   dlv ./path/to/prog
 
 or use the following commands:
-  run - Build, run, and attach to program
-  test - Build test binary, run and attach to it
-  attach - Attach to running process
+  run - Build the package in the working directory, or the one given, with optimizations disabled, run and attach to it, given the arguments of the program after --
+  test - Build the test binary of the package in the working directory, run and attach to it, given a regular expression selecting the tests to run and arguments of the test binary, both optional
+  attach - Attach to running process, given its pid or the name of its executable, in a container too
+  record - Record program with rr, then replay the recording
+  replay - Replay an rr recording, allowing reverse execution
+  trace - Print the calls and returns of the functions matching a regular expression as the program runs, given the expression and the path to a binary
+  core - Examine a core dump of a program, given the binary and the core file
+  connect - Debug a process served by dlv-agent, given its address and a copy of the binary, or prefix=replacement mappings of its path
+  diagnose - Print information about a binary to include in bug reports
 `, version)
 
-func init() {
-	// We must ensure here that we are running on the same thread during
-	// the execution of dbg. This is due to the fact that ptrace(2) expects
-	// all commands after PTRACE_ATTACH to come from the same thread.
-	runtime.LockOSThread()
+// The values of a flag which can be repeated, such as -break.
+type repeated []string
+
+func (l *repeated) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *repeated) Set(loc string) error {
+	*l = append(*l, loc)
+	return nil
 }
 
 func main() {
-	var printv bool
+	var (
+		printv   bool
+		debug    bool
+		initFile string
+		breaks   repeated
+		pending  repeated
+		env      repeated
+		paths    repeated
+		cfg      proctl.Config
+		lcfg     proctl.LaunchConfig
+	)
 
 	flag.BoolVar(&printv, "v", false, "Print version number and exit.")
+	flag.Var(&breaks, "break", "Set a breakpoint at the location before handing control over, can be repeated.")
+	flag.Var(&pending, "break-pending", "Same as -break, waiting for locations the program doesn't have yet rather than failing, can be repeated.")
+	flag.BoolVar(&cfg.Resume, "continue", false, "Resume the program once it is launched or attached to and its breakpoints are set.")
+	flag.StringVar(&lcfg.Dir, "wd", "", "Working directory of launched programs.")
+	flag.Var(&env, "env", "Add name=value to the environment of launched programs, can be repeated.")
+	flag.StringVar(&lcfg.Stdin, "stdin", "", "Redirect the standard input of launched programs from a file.")
+	flag.StringVar(&lcfg.TTY, "tty", "", "Terminal for the input and output of launched programs, e.g. the tty of another terminal window.")
+	flag.Var(&paths, "pathmap", "Map the source paths of the program, e.g. those of the container it was built in, from=to, can be repeated.")
+	flag.BoolVar(&debug, "log", false, "Trace the requests made to the process and its stops on the standard error.")
+	flag.StringVar(&initFile, "init", "", "Run the commands of the file, one per line, before handing control over, instead of those of .dlvinit in the working directory.")
 	flag.Parse()
+	cfg.Breakpoints = breaks
+	cfg.PendingBreakpoints = pending
+	for _, p := range paths {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "invalid path mapping %s, expected from=to\n", p)
+			os.Exit(1)
+		}
+		cfg.PathMap = append(cfg.PathMap, proctl.PathMapping{From: p[:i], To: p[i+1:]})
+	}
+	if debug {
+		cfg.Logger = proctl.NewLogger(os.Stdout, os.Stderr, true)
+	}
+	if len(env) > 0 {
+		lcfg.Env = append(os.Environ(), env...)
+	}
 
 	if flag.NFlag() == 0 && len(flag.Args()) == 0 {
 		fmt.Println(usage)
@@ -49,5 +106,5 @@ func main() {
 		os.Exit(0)
 	}
 
-	cli.Run(os.Args[1:])
+	cli.Run(flag.Args(), lcfg, cfg, initFile)
 }
@@ -4,13 +4,20 @@ package command
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"go/ast"
+	"go/parser"
 	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/derekparker/delve/proctl"
 )
@@ -34,8 +41,9 @@ func (c command) match(cmdstr string) bool {
 }
 
 type Commands struct {
-	cmds    []command
-	lastCmd cmdfunc
+	cmds       []command
+	lastCmd    cmdfunc
+	transcript []string // Command lines entered, see Record
 }
 
 // Returns a Commands struct with default commands defined.
@@ -44,17 +52,73 @@ func DebugCommands() *Commands {
 
 	c.cmds = []command{
 		command{aliases: []string{"help"}, cmdFn: c.help, helpMsg: "Prints the help message."},
-		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, or at a specific file/line. Example: break foo.go:13"},
-		command{aliases: []string{"continue", "c"}, cmdFn: cont, helpMsg: "Run until breakpoint or program termination."},
-		command{aliases: []string{"step", "si"}, cmdFn: step, helpMsg: "Single step through program."},
-		command{aliases: []string{"next", "n"}, cmdFn: next, helpMsg: "Step over to next source line."},
+		command{aliases: []string{"break", "b"}, cmdFn: breakpoint, helpMsg: "Set break point at the entry point of a function, at a specific file/line, on every address it is entered at, as one group, on the method of every type implementing an interface, or on a runtime event (" + strings.Join(proctl.RuntimeEvents(), ", ") + "), at a line relative to the current one, or at an address. Append goroutine <id> to only stop for that goroutine. Use break pending <location> to wait for a location the program doesn't have yet, until a restart or exec. Functions can be named without their package if only one has that name. Example: break foo.go:13, break (*Foo).Bar, break +2, break *0x4010f0, break io.Reader.Read, break goroutine-exit 5, break main.foo goroutine 3"},
+		command{aliases: []string{"breakre"}, cmdFn: breakRegexp, helpMsg: "breakre <regexp>. Set a breakpoint on every function whose name matches the regular expression, as a group. Example: breakre ^main\\.\\(\\*Foo\\)\\."},
+		command{aliases: []string{"cleargroup"}, cmdFn: clearGroup, helpMsg: "cleargroup <id>. Deletes the breakpoints of a group set by breakre."},
+		command{aliases: []string{"breakpkg"}, cmdFn: breakPackage, helpMsg: "breakpkg <package>. Set a breakpoint on every exported function and method of the package with the given import path."},
+		command{aliases: []string{"tracepkg"}, cmdFn: tracePackage, helpMsg: "tracepkg <package>. Set a tracepoint on every exported function and method of the package with the given import path, to see how the program uses it."},
+		command{aliases: []string{"trace"}, cmdFn: tracepoint, helpMsg: "trace [-stack <depth>] <location> [variables...]. Set a tracepoint, printing the location and the given variables every time it is hit without stopping, and with -stack that many frames of the stack of the goroutine hitting it."},
+		command{aliases: []string{"tracere"}, cmdFn: traceRegexp, helpMsg: "tracere <regexp>. Print every call of the functions whose name matches the regular expression, with its arguments and by which goroutine, and its return, with the values returned, without stopping. The breakpoints are set as a group, see cleargroup."},
+		command{aliases: []string{"watch"}, cmdFn: watch, helpMsg: "watch <package>.<variable>. Stop when a package variable is written to."},
+		command{aliases: []string{"continue", "c"}, cmdFn: cont, helpMsg: "continue [n]. Run until breakpoint or program termination, n times to skip breakpoint hits."},
+		command{aliases: []string{"step", "si"}, cmdFn: step, helpMsg: "step [n]. Single step through program, n instructions at once."},
+		command{aliases: []string{"next", "n"}, cmdFn: next, helpMsg: "next [n]. Step over to next source line, n lines at once."},
+		command{aliases: []string{"stepin", "s"}, cmdFn: stepin, helpMsg: "Step into the next source line, following function calls."},
+		command{aliases: []string{"stepout"}, cmdFn: stepout, helpMsg: "stepout [defer]. Run until the current function returns to its caller, or with defer until the first call it deferred runs, as it returns or panics."},
+		command{aliases: []string{"until", "u"}, cmdFn: until, helpMsg: "until <location>. Run until the current goroutine reaches the location in the current function or one of its callers, continuing past other goroutines and recursive calls reaching it."},
+		command{aliases: []string{"rcontinue", "rc"}, cmdFn: rcont, helpMsg: "Run backwards until breakpoint or the start of the recording."},
+		command{aliases: []string{"rstep", "rs"}, cmdFn: rstep, helpMsg: "Step backwards to the previous source line, following function calls."},
+		command{aliases: []string{"rnext", "rn"}, cmdFn: rnext, helpMsg: "Step backwards to the previous source line, stepping over function calls."},
+		command{aliases: []string{"list", "l"}, cmdFn: listSource, helpMsg: "list [location]. Print the source around a location, or where the current thread is stopped, marking breakpoints with *."},
 		command{aliases: []string{"threads"}, cmdFn: threads, helpMsg: "Print out info for every traced thread."},
+		command{aliases: []string{"regs"}, cmdFn: regs, helpMsg: "regs [changed]. Print contents of CPU registers, or only of those that changed since the previous stop."},
 		command{aliases: []string{"thread", "t"}, cmdFn: thread, helpMsg: "Switch to the specified thread."},
 		command{aliases: []string{"clear"}, cmdFn: clear, helpMsg: "Deletes breakpoint."},
-		command{aliases: []string{"goroutines"}, cmdFn: goroutines, helpMsg: "Print out info for every goroutine."},
-		command{aliases: []string{"breakpoints", "bp"}, cmdFn: breakpoints, helpMsg: "Print out info for active breakpoints."},
-		command{aliases: []string{"print", "p"}, cmdFn: printVar, helpMsg: "Evaluate a variable."},
-		command{aliases: []string{"info"}, cmdFn: info, helpMsg: "Provides info about args, funcs, locals, sources, or vars."},
+		command{aliases: []string{"disable"}, cmdFn: disable, helpMsg: "disable <id>. Disables a breakpoint without deleting it."},
+		command{aliases: []string{"enable"}, cmdFn: enable, helpMsg: "enable <id>. Enables a disabled breakpoint."},
+		command{aliases: []string{"export"}, cmdFn: exportBreakpoints, helpMsg: "export <path>. Save breakpoints, tracepoints and watchpoints to a file, to share or import later."},
+		command{aliases: []string{"import"}, cmdFn: importBreakpoints, helpMsg: "import <path>. Set the breakpoints saved by export, finding their locations again in this build."},
+		command{aliases: []string{"frame"}, cmdFn: frame, helpMsg: "frame [n]. Select the frame of the stack of the current thread that print, set and info args|locals look variables up in, 0 being the innermost."},
+		command{aliases: []string{"up"}, cmdFn: up, helpMsg: "up [n]. Select the frame n frames, 1 by default, further from the innermost one."},
+		command{aliases: []string{"down"}, cmdFn: down, helpMsg: "down [n]. Select the frame n frames, 1 by default, closer to the innermost one."},
+		command{aliases: []string{"goroutines"}, cmdFn: goroutines, helpMsg: "Print out info for every goroutine, with its state as in tracebacks, e.g. [chan receive, 3 minutes]."},
+		command{aliases: []string{"stacks"}, cmdFn: stacks, helpMsg: "stacks [depth]. Print the stack of every goroutine, 10 frames deep by default, like the traceback of a program receiving SIGQUIT."},
+		command{aliases: []string{"defers"}, cmdFn: defers, helpMsg: "Print the calls deferred by the current goroutine which haven't run yet, next first, and the panics in flight on it."},
+		command{aliases: []string{"contexts"}, cmdFn: contexts, helpMsg: "contexts [goroutine id]. Print the context.Context arguments on the stack of a goroutine, the current one by default, and the values they carry."},
+		command{aliases: []string{"timers"}, cmdFn: timers, helpMsg: "Print out info for every active runtime timer."},
+		command{aliases: []string{"heap"}, cmdFn: heap, helpMsg: "heap <type>. Print the address and size of every object of the type allocated on the heap, named as by the runtime, e.g. main.Session. Only objects holding pointers and larger than 512 bytes have their type recorded, by Go 1.22 and later. Example: heap main.Session"},
+		command{aliases: []string{"creations"}, cmdFn: creations, helpMsg: "creations [break|trace]. Stop at, or print, the creation of every goroutine from now on, recording who created it. Without arguments, list the creations recorded."},
+		command{aliases: []string{"creator"}, cmdFn: creator, helpMsg: "creator <goroutine id>. Print which goroutine created the given one, with which function, and the stack of the go statement when its creation was recorded."},
+		command{aliases: []string{"deadlock"}, cmdFn: deadlock, helpMsg: "Report goroutines blocked on channels or locks, and whether they are waiting on each other."},
+		command{aliases: []string{"breakpoints", "bp"}, cmdFn: breakpoints, helpMsg: "Print out info for active breakpoints, with how many times they were hit, by each goroutine."},
+		command{aliases: []string{"display"}, cmdFn: display, helpMsg: "display [<expression> | clear <id>]. Print the value of an expression every time the process stops, stop printing it, or list the expressions printed."},
+		command{aliases: []string{"print", "p"}, cmdFn: printVar, helpMsg: "print [%x|%b|%o|%c|%p] <expression>. Evaluate a variable, optionally printing integers in hex, binary, octal, as a character, or the address of the variable."},
+		command{aliases: []string{"call"}, cmdFn: call, helpMsg: "call <function>(<arguments>). Call a function of the program in the current goroutine and print its results. Example: call strings.Repeat(s, 2)"},
+		command{aliases: []string{"set"}, cmdFn: setVar, helpMsg: "set <variable> = <value>. Changes the value of a variable."},
+		command{aliases: []string{"loadconfig"}, cmdFn: loadConfig, helpMsg: "loadconfig [pointers on|off | depth|strings|arrays <n>]. Print how much of values print and info read, or change it: whether pointers are followed, how deep nested structs are read, and how many bytes of strings and elements of arrays and slices, -1 for no limit. Example: loadconfig strings 4096"},
+		command{aliases: []string{"hits"}, cmdFn: hits, helpMsg: "hits <id> [bucket]. Print the rate at which a breakpoint was hit over the session, per bucket of the given length (default 1s)."},
+		command{aliases: []string{"runaway"}, cmdFn: runaway, helpMsg: "runaway <threshold> [stop|resume] | off. Stop the process when continue runs it for longer than the threshold, print the stacks of its goroutines, and leave it stopped or resume it. Without arguments, print the last report."},
+		command{aliases: []string{"profile"}, cmdFn: profile, helpMsg: "profile <duration> [folded <path>]. Run the process for the given duration, sampling the stacks of its threads every 10ms, then print the functions it spent the most time in, or write the stacks sampled to a file in the folded format of flame graph tools. Example: profile 5s folded out.folded"},
+		command{aliases: []string{"times"}, cmdFn: times, helpMsg: "Print how long the process has been running and kept stopped by the debugger."},
+		command{aliases: []string{"patches"}, cmdFn: patches, helpMsg: "patches [verify|repair]. List the bytes written over the code of the process for breakpoints, or check whether the process overwrote them, optionally writing them again."},
+		command{aliases: []string{"syscalls"}, cmdFn: syscalls, helpMsg: "syscalls [<syscall> stop|trace|clear]. Print the syscalls caught, or change how one of them is: stop when a thread enters or returns from it, print its arguments and result as the process goes on, or stop catching it. Linux only. Example: syscalls openat stop"},
+		command{aliases: []string{"signals"}, cmdFn: signals, helpMsg: "signals [<signal> pass|ignore|stop]. Print what happens to the signals the process receives, or change it for one of them: deliver it, discard it, or stop and deliver it on continue. Example: signals SIGUSR1 stop"},
+		command{aliases: []string{"children"}, cmdFn: children, helpMsg: "children [follow|detach]. Print what happens to the processes the process forks, or change it, and list the children followed until they executed a Go program."},
+		command{aliases: []string{"resources"}, cmdFn: resources, helpMsg: "Print the memory, open files and threads the process uses, and how they changed since the previous stop."},
+		command{aliases: []string{"diagnostics"}, cmdFn: diagnostics, helpMsg: "Print information about the debugger, the binary and the backend, to include in bug reports."},
+		command{aliases: []string{"info"}, cmdFn: info, helpMsg: "info args|funcs|locals|sources|types|vars [regex]. Provides info about args, funcs, locals, sources, types, or vars, optionally only those matching the regular expression."},
+		command{aliases: []string{"gdbserver"}, cmdFn: gdbserver, helpMsg: "gdbserver <address>. Serve the process to GDB compatible front-ends connecting to address, e.g. localhost:2345. The first one controls the process, the others can only observe it until the controlling one disconnects."},
+		command{aliases: []string{"dump"}, cmdFn: dump, helpMsg: "dump <path>. Write a core file of the process, which can later be examined with dlv core."},
+		command{aliases: []string{"mappings"}, cmdFn: mappings, helpMsg: "Print out the memory mappings of the process, with their permissions and backing files, like info proc mappings in gdb."},
+		command{aliases: []string{"source"}, cmdFn: c.source, helpMsg: "source <path>. Run the commands of a file, one per line, such as an init file or a transcript saved by onexit. Blank lines and lines starting with # are skipped."},
+		command{aliases: []string{"pathmap"}, cmdFn: pathmap, helpMsg: "pathmap [<from>=<to>]. Look for the source files under the directory from in the directory to instead, e.g. for programs built in a container. Lists the mappings without arguments."},
+		command{aliases: []string{"onexit"}, cmdFn: c.onExit, helpMsg: "onexit goroutines|dump <path>|transcript <path>. When the process is about to exit, print its goroutines, write a core file of it, or save the commands entered so far, to have something to look at when it crashes without hitting a breakpoint."},
+		command{aliases: []string{"on"}, cmdFn: c.onHit, helpMsg: "on <id> <command>[; <command>...] | on <id> clear. Run commands every time a breakpoint is hit, ending with continue to resume the process afterwards rather than stopping, or stop running them. Example: on 1 print a; print b; continue"},
+		command{aliases: []string{"restart"}, cmdFn: restart, helpMsg: "Restart the process, keeping its breakpoints."},
+		command{aliases: []string{"rebuild"}, cmdFn: rebuild, helpMsg: "Build the program again and restart it, keeping its breakpoints. Only for programs built by dlv run or dlv test."},
+		command{aliases: []string{"checkpoint"}, cmdFn: checkpoint, helpMsg: "checkpoint [clear <id>]. Save the state of the process, to rewind to it later with restore, or delete a checkpoint. Only the current thread is saved."},
+		command{aliases: []string{"checkpoints"}, cmdFn: checkpoints, helpMsg: "Print out info for every checkpoint."},
+		command{aliases: []string{"restore"}, cmdFn: restore, helpMsg: "restore <id>. Rewind the process to a checkpoint, keeping the current breakpoints."},
 		command{aliases: []string{"exit"}, cmdFn: nullCommand, helpMsg: "Exit the debugger."},
 	}
 
@@ -86,117 +150,1091 @@ func (c *Commands) Find(cmdstr string) cmdfunc {
 		return nullCommand
 	}
 
+	if cf, ok := c.lookup(cmdstr); ok {
+		c.lastCmd = cf
+		return cf
+	}
+
+	return noCmdAvailable
+}
+
+// Returns the command function for the given command, without making
+// it the last command.
+func (c *Commands) lookup(cmdstr string) (cmdfunc, bool) {
 	for _, v := range c.cmds {
 		if v.match(cmdstr) {
-			c.lastCmd = v.cmdFn
-			return v.cmdFn
+			return v.cmdFn, true
+		}
+	}
+	return nil, false
+}
+
+// Records a command line entered by the user,
+// for the transcript of the session.
+func (c *Commands) Record(cmdline string) {
+	c.transcript = append(c.transcript, cmdline)
+}
+
+// Writes the command lines recorded so far to path, one per line.
+func (c *Commands) saveTranscript(path string) error {
+	var buf bytes.Buffer
+	for _, l := range c.transcript {
+		fmt.Fprintln(&buf, l)
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Runs the command lines in order as if entered by the user, e.g.
+// those of an init file. Blank lines and lines starting with # are
+// skipped. Stops at the first command failing, returning its error.
+func (c *Commands) RunCommands(p *proctl.DebuggedProcess, cmdlines []string) error {
+	for _, line := range cmdlines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
 		}
+		cf, ok := c.lookup(fields[0])
+		if !ok {
+			return fmt.Errorf("unknown command %s", fields[0])
+		}
+		c.Record(strings.Join(fields, " "))
+		if err := cf(p, fields[1:]...); err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(fields, " "), err)
+		}
+	}
+	return nil
+}
+
+// Runs the command lines of the file at path, see RunCommands.
+func (c *Commands) RunFile(p *proctl.DebuggedProcess, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.RunCommands(p, strings.Split(string(data), "\n"))
+}
+
+func CommandFunc(fn func() error) cmdfunc {
+	return func(p *proctl.DebuggedProcess, args ...string) error {
+		return fn()
+	}
+}
+
+func noCmdAvailable(p *proctl.DebuggedProcess, ars ...string) error {
+	return fmt.Errorf("command not available")
+}
+
+func nullCommand(p *proctl.DebuggedProcess, ars ...string) error {
+	return nil
+}
+
+func (c *Commands) help(p *proctl.DebuggedProcess, ars ...string) error {
+	fmt.Println("The following commands are available:")
+	for _, cmd := range c.cmds {
+		fmt.Printf("\t%s - %s\n", strings.Join(cmd.aliases, "|"), cmd.helpMsg)
+	}
+	return nil
+}
+
+func (c *Commands) onExit(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	var action func(p *proctl.DebuggedProcess) error
+	switch args[0] {
+	case "goroutines":
+		action = func(p *proctl.DebuggedProcess) error { return p.PrintGoroutinesInfo() }
+	case "dump", "transcript":
+		if len(args) < 2 {
+			return fmt.Errorf("%s needs the path to write to", args[0])
+		}
+		path := args[1]
+		if args[0] == "dump" {
+			action = func(p *proctl.DebuggedProcess) error { return dump(p, path) }
+		} else {
+			action = func(p *proctl.DebuggedProcess) error { return c.saveTranscript(path) }
+		}
+	default:
+		return fmt.Errorf("unknown exit action %s", args[0])
+	}
+
+	name := strings.Join(args, " ")
+	return p.OnExit(func(p *proctl.DebuggedProcess) {
+		fmt.Printf("Process exiting, running %s\n", name)
+		if err := action(p); err != nil {
+			fmt.Fprintf(os.Stderr, "Exit action %s failed: %s\n", name, err)
+		}
+	})
+}
+
+func (c *Commands) source(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	return c.RunFile(p, args[0])
+}
+
+func pathmap(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		for _, m := range p.PathMap {
+			fmt.Printf("%s => %s\n", m.From, m.To)
+		}
+		return nil
+	}
+	i := strings.Index(args[0], "=")
+	if i < 0 {
+		return fmt.Errorf("invalid path mapping %s, expected from=to", args[0])
+	}
+	p.PathMap = append(p.PathMap, proctl.PathMapping{From: args[0][:i], To: args[0][i+1:]})
+	return nil
+}
+
+// Commands resuming the process, which can't run from a breakpoint
+// script since the process is being resumed already when it runs.
+var resumingCommands = map[string]bool{
+	"step": true, "si": true, "next": true, "n": true, "stepin": true, "s": true,
+	"stepout": true, "until": true, "u": true, "rcontinue": true, "rc": true,
+	"rstep": true, "rs": true, "rnext": true, "rn": true, "call": true,
+	"restart": true, "rebuild": true, "restore": true, "exit": true,
+}
+
+func (c *Commands) onHit(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("%s is not a breakpoint id", args[0])
+	}
+	if len(args) == 2 && args[1] == "clear" {
+		p.ClearBreakpointHooks(id)
+		return nil
+	}
+
+	// Commands are separated by semicolons, continue resumes the
+	// process once the others ran.
+	var (
+		script [][]string
+		resume bool
+	)
+	for _, line := range strings.Split(strings.Join(args[1:], " "), ";") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "continue" || fields[0] == "c":
+			resume = true
+			continue
+		case resumingCommands[fields[0]]:
+			return fmt.Errorf("%s can't run when a breakpoint is hit", fields[0])
+		}
+		if _, ok := c.lookup(fields[0]); !ok {
+			return fmt.Errorf("unknown command %s", fields[0])
+		}
+		script = append(script, fields)
+	}
+
+	return p.OnBreakpointHit(id, func(p *proctl.DebuggedProcess, bp *proctl.BreakPoint) bool {
+		for _, fields := range script {
+			cf, _ := c.lookup(fields[0])
+			if err := cf(p, fields[1:]...); err != nil {
+				fmt.Fprintf(os.Stderr, "Command %s failed: %s\n", strings.Join(fields, " "), err)
+			}
+		}
+		return resume
+	})
+}
+
+func threads(p *proctl.DebuggedProcess, ars ...string) error {
+	infos, err := p.ThreadInfo()
+	if err != nil {
+		return err
+	}
+	for _, ti := range infos {
+		prefix := "  "
+		if ti.Current {
+			prefix = "* "
+		}
+		fmt.Printf("%s%s\n", prefix, ti)
+	}
+	return nil
+}
+
+func diagnostics(p *proctl.DebuggedProcess, args ...string) error {
+	fmt.Print(p.Diagnostics())
+	return nil
+}
+
+func regs(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 0 && args[0] == "changed" {
+		changes, err := p.ChangedRegisters()
+		if err != nil {
+			return err
+		}
+		if changes == nil {
+			return fmt.Errorf("the process has not stopped before")
+		}
+		for _, c := range changes {
+			fmt.Printf("%10s = %#016x (was %#016x)\n", c.Name, c.New, c.Old)
+		}
+		return nil
+	}
+
+	regs, err := p.CurrentThread.Registers()
+	if err != nil {
+		return err
+	}
+
+	for _, reg := range regs.Slice() {
+		fmt.Printf("%10s = %#016x\n", reg.Name, reg.Value)
+	}
+	return nil
+}
+
+func thread(p *proctl.DebuggedProcess, ars ...string) error {
+	oldTid := p.CurrentThread.Id
+	tid, err := strconv.Atoi(ars[0])
+	if err != nil {
+		return err
+	}
+
+	err = p.SwitchThread(tid)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Switched from %d to %d\n", oldTid, tid)
+	return nil
+}
+
+func frame(p *proctl.DebuggedProcess, args ...string) error {
+	n := p.CurrentThread.SelectedFrame()
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid frame %s", args[0])
+		}
+	}
+	return selectFrame(p, n)
+}
+
+func up(p *proctl.DebuggedProcess, args ...string) error {
+	return moveFrame(p, 1, args...)
+}
+
+func down(p *proctl.DebuggedProcess, args ...string) error {
+	return moveFrame(p, -1, args...)
+}
+
+// Selects the frame n frames away from the selected one, in the
+// given direction.
+func moveFrame(p *proctl.DebuggedProcess, dir int, args ...string) error {
+	n := 1
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 0 {
+			return fmt.Errorf("invalid number of frames %s", args[0])
+		}
+	}
+	return selectFrame(p, p.CurrentThread.SelectedFrame()+dir*n)
+}
+
+// Selects frame n of the stack of the current thread and prints it.
+func selectFrame(p *proctl.DebuggedProcess, n int) error {
+	if err := p.CurrentThread.SelectFrame(n); err != nil {
+		return err
+	}
+	frames, err := p.CurrentThread.Stacktrace(n + 1)
+	if n >= len(frames) {
+		return err
+	}
+	f := frames[n]
+	fmt.Printf("Frame %d: %#x %s %s:%d\n", n, f.PC, f.Function, f.File, f.Line)
+	return nil
+}
+
+func goroutines(p *proctl.DebuggedProcess, ars ...string) error {
+	return p.PrintGoroutinesInfo()
+}
+
+func stacks(p *proctl.DebuggedProcess, args ...string) error {
+	depth := 10
+	if len(args) > 0 {
+		var err error
+		if depth, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid depth %s", args[0])
+		}
+	}
+	stacks, err := p.AllStacktraces(depth)
+	if err != nil {
+		return err
+	}
+	for _, gs := range stacks {
+		fmt.Print(gs)
+	}
+	return nil
+}
+
+func defers(p *proctl.DebuggedProcess, args ...string) error {
+	defers, err := p.Defers()
+	if err != nil {
+		return err
+	}
+	panics, err := p.Panics()
+	if err != nil {
+		return err
+	}
+	for _, d := range defers {
+		fmt.Println(d)
+	}
+	for _, pa := range panics {
+		fmt.Println(pa)
+	}
+	return nil
+}
+
+func creations(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		for _, gc := range p.GoroutineCreations() {
+			fmt.Println(gc)
+		}
+		return nil
+	}
+	if args[0] != "break" && args[0] != "trace" {
+		return fmt.Errorf("unknown creations command %s", args[0])
+	}
+
+	bp, err := p.BreakOnGoroutineCreation(args[0] == "trace")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	return nil
+}
+
+func creator(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid goroutine id %s", args[0])
+	}
+
+	gc, err := p.GoroutineCreator(id)
+	if err != nil {
+		return err
+	}
+	fmt.Println(gc)
+	for _, f := range gc.Stack {
+		fmt.Printf("\t%#x %s %s:%d\n", f.PC, f.Function, f.File, f.Line)
+	}
+	return nil
+}
+
+func contexts(p *proctl.DebuggedProcess, args ...string) error {
+	var id int
+	if len(args) == 0 {
+		g, err := p.CurrentThread.CurrentGoroutine()
+		if err != nil {
+			return err
+		}
+		id = g.Id
+	} else {
+		var err error
+		if id, err = strconv.Atoi(args[0]); err != nil {
+			return fmt.Errorf("invalid goroutine id %s", args[0])
+		}
+	}
+
+	cargs, err := p.GoroutineContexts(id)
+	if err != nil {
+		return err
+	}
+	if len(cargs) == 0 {
+		fmt.Printf("No contexts found on the stack of goroutine %d\n", id)
+		return nil
+	}
+	for _, arg := range cargs {
+		fmt.Printf("%s %s:\n", arg.Function, arg.Name)
+		for _, link := range arg.Chain {
+			fmt.Printf("\t%s\n", link)
+		}
+	}
+	return nil
+}
+
+func dump(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	if err := p.Dump(args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Core file written to %s\n", args[0])
+	return nil
+}
+
+func mappings(p *proctl.DebuggedProcess, args ...string) error {
+	regions, err := p.MemoryMaps()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%18s %18s %10s %4s %10s %s\n", "Start", "End", "Size", "Perm", "Offset", "Path")
+	for _, r := range regions {
+		fmt.Printf("%#18x %#18x %#10x %4s %#10x %s\n", r.Start, r.End, r.End-r.Start, r.Perm, r.Offset, r.Path)
+	}
+	return nil
+}
+
+func gdbserver(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	l, err := net.Listen("tcp", args[0])
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	fmt.Printf("Waiting for connections on %s\n", l.Addr())
+	return p.ServeGDBClients(l)
+}
+
+func listSource(p *proctl.DebuggedProcess, args ...string) error {
+	sl, err := p.ListSource(strings.Join(args, " "), 5)
+	if err != nil {
+		return err
+	}
+	fmt.Print(sl)
+	return nil
+}
+
+func times(p *proctl.DebuggedProcess, args ...string) error {
+	fmt.Println(p.SessionTimes())
+	return nil
+}
+
+func runaway(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		rr := p.LastRunaway()
+		if rr == nil {
+			fmt.Println("The process never ran away")
+			return nil
+		}
+		fmt.Print(rr)
+		return nil
+	}
+	if args[0] == "off" {
+		return p.SetRunawayDetector(proctl.RunawayDetector{})
+	}
+
+	threshold, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	rd := proctl.RunawayDetector{
+		Threshold: threshold,
+		Report: func(p *proctl.DebuggedProcess, rr *proctl.RunawayReport) {
+			fmt.Print(rr)
+		},
+	}
+	if len(args) > 1 {
+		switch args[1] {
+		case "stop":
+			rd.Policy = proctl.RunawayStop
+		case "resume":
+			rd.Policy = proctl.RunawayResume
+		default:
+			return fmt.Errorf("unknown runaway policy %s", args[1])
+		}
+	}
+	return p.SetRunawayDetector(rd)
+}
+
+func profile(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return err
+	}
+	var folded string
+	if len(args) > 1 {
+		if args[1] != "folded" || len(args) < 3 {
+			return fmt.Errorf("usage: profile <duration> [folded <path>]")
+		}
+		folded = args[2]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	prof, err := p.Profile(ctx, proctl.DefaultProfileInterval)
+	if prof == nil {
+		return err
+	}
+	if folded != "" {
+		f, ferr := os.Create(folded)
+		if ferr != nil {
+			return ferr
+		}
+		if ferr := prof.WriteFolded(f); ferr != nil {
+			f.Close()
+			return ferr
+		}
+		if ferr := f.Close(); ferr != nil {
+			return ferr
+		}
+		fmt.Printf("%d samples written to %s\n", prof.Samples, folded)
+		return err
+	}
+
+	fmt.Printf("%d samples, every %s\n", prof.Samples, prof.Interval)
+	fmt.Printf("%7s %7s  %s\n", "self", "total", "function")
+	for i, e := range prof.Flat() {
+		if i == maxProfileEntries {
+			break
+		}
+		fmt.Printf("%6.2f%% %6.2f%%  %s\n", percent(e.Self, prof.Samples), percent(e.Total, prof.Samples), e.Function)
+	}
+	return err
+}
+
+// Number of functions profile prints.
+const maxProfileEntries = 20
+
+func percent(n, total int) float64 {
+	return 100 * float64(n) / float64(total)
+}
+
+func hits(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+	bucket := time.Second
+	if len(args) > 1 {
+		if bucket, err = time.ParseDuration(args[1]); err != nil {
+			return err
+		}
+	}
+
+	h, err := p.HitHistogram(id, bucket)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Breakpoint %d hit %d times\n", id, len(p.BreakpointHits(id)))
+	fmt.Print(h)
+	return nil
+}
+
+func patches(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		for _, patch := range p.Patches() {
+			fmt.Printf("%#x: % x over % x\n", patch.Addr, patch.Patched, patch.Original)
+		}
+		return nil
+	}
+	if args[0] != "verify" && args[0] != "repair" {
+		return fmt.Errorf("unknown patches command %s", args[0])
+	}
+	mismatches, err := p.VerifyPatches(args[0] == "repair")
+	for _, pm := range mismatches {
+		fmt.Println(pm)
+	}
+	if err != nil {
+		return err
+	}
+	if len(mismatches) == 0 {
+		fmt.Println("All patches are intact.")
+	}
+	return nil
+}
+
+func signals(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 1 {
+		return fmt.Errorf("not enough arguments")
+	}
+	if len(args) > 1 {
+		sig, err := proctl.ParseSignal(args[0])
+		if err != nil {
+			return err
+		}
+		var sp proctl.SignalPolicy
+		switch args[1] {
+		case "pass":
+			sp = proctl.SignalPass
+		case "ignore":
+			sp = proctl.SignalIgnore
+		case "stop":
+			sp = proctl.SignalStop
+		default:
+			return fmt.Errorf("unknown signal policy %s", args[1])
+		}
+		if err := p.SetSignalPolicy(sig, sp); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Signals are passed to the process, except:")
+	for _, sig := range p.Signals() {
+		fmt.Printf("%s: %s\n", proctl.SignalName(sig), p.SignalPolicy(sig))
+	}
+	return nil
+}
+
+func syscalls(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 1 {
+		return fmt.Errorf("not enough arguments")
+	}
+	if len(args) > 1 {
+		nr, err := proctl.ParseSyscall(args[0])
+		if err != nil {
+			return err
+		}
+		switch args[1] {
+		case "stop":
+			err = p.CatchSyscall(nr, false)
+		case "trace":
+			err = p.CatchSyscall(nr, true)
+		case "clear":
+			err = p.ClearSyscallCatch(nr)
+		default:
+			return fmt.Errorf("unknown syscall action %s", args[1])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("Syscalls caught:")
+	for _, sc := range p.SyscallCatches() {
+		fmt.Println(sc)
+	}
+	return nil
+}
+
+func loadConfig(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 1 {
+		return fmt.Errorf("not enough arguments")
+	}
+	cfg := proctl.DefaultLoadConfig
+	if p.LoadConfig != nil {
+		cfg = *p.LoadConfig
+	}
+	if len(args) > 1 {
+		if args[0] == "pointers" {
+			switch args[1] {
+			case "on":
+				cfg.FollowPointers = true
+			case "off":
+				cfg.FollowPointers = false
+			default:
+				return fmt.Errorf("expected on or off, got %s", args[1])
+			}
+		} else {
+			n, err := strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid limit %s", args[1])
+			}
+			switch args[0] {
+			case "depth":
+				cfg.MaxVariableRecurse = n
+			case "strings":
+				cfg.MaxStringLen = n
+			case "arrays":
+				cfg.MaxArrayValues = n
+			default:
+				return fmt.Errorf("unknown setting %s", args[0])
+			}
+		}
+		p.LoadConfig = &cfg
+	}
+	fmt.Println(cfg)
+	return nil
+}
+
+func children(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "follow":
+			p.ChildPolicy = proctl.ChildFollow
+		case "detach":
+			p.ChildPolicy = proctl.ChildDetach
+		default:
+			return fmt.Errorf("unknown children policy %s", args[0])
+		}
+	}
+	fmt.Printf("Children are %sed.\n", p.ChildPolicy)
+	for _, child := range p.Children() {
+		fmt.Printf("%d: %s\n", child.Pid, child.Executable())
+	}
+	return nil
+}
+
+func resources(p *proctl.DebuggedProcess, args ...string) error {
+	ru, err := p.ResourceUsage()
+	if err != nil {
+		return err
+	}
+	fmt.Println(ru)
+	if prev, ok := p.PreviousResourceUsage(); ok {
+		fmt.Printf("since the previous stop: rss %+.1f MiB, %+d open files, %+d threads\n",
+			(float64(ru.RSS)-float64(prev.RSS))/(1<<20), ru.FDs-prev.FDs, ru.Threads-prev.Threads)
+	}
+	return nil
+}
+
+func timers(p *proctl.DebuggedProcess, ars ...string) error {
+	ts, err := p.Timers()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("[%d timers]\n", len(ts))
+	for _, t := range ts {
+		fmt.Println(t)
+	}
+	return nil
+}
+
+func heap(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	objects, err := p.HeapObjects(args[0])
+	if err != nil {
+		return err
+	}
+
+	var size uint64
+	for _, o := range objects {
+		size += o.Size
+	}
+	fmt.Printf("[%d objects of type %s, %d bytes]\n", len(objects), args[0], size)
+	for _, o := range objects {
+		fmt.Println(o)
+	}
+	return nil
+}
+
+func deadlock(p *proctl.DebuggedProcess, ars ...string) error {
+	report, err := p.Deadlocks()
+	if err != nil {
+		return err
+	}
+
+	if len(report.Blocked) == 0 {
+		fmt.Println("No goroutines are blocked on channels or locks.")
+		return nil
+	}
+
+	if report.Deadlocked {
+		fmt.Println("Deadlock: these goroutines are waiting on each other:")
+	} else {
+		ids := make([]int, len(report.Progressing))
+		for i, g := range report.Progressing {
+			ids[i] = g.Id
+		}
+		fmt.Printf("No deadlock detected, goroutines %s may still make progress.\n", formatGoroutineIds(ids))
+		fmt.Println("Blocked goroutines:")
+	}
+
+	resources, waiters := report.Waiters()
+	for _, r := range resources {
+		fmt.Printf("\t%s: goroutines %s\n", r, formatGoroutineIds(waiters[r]))
+	}
+
+	var forever []string
+	for _, bg := range report.Blocked {
+		if bg.Forever() {
+			forever = append(forever, strconv.Itoa(bg.G.Id))
+		}
+	}
+	if len(forever) > 0 {
+		fmt.Printf("Goroutines %s are blocked forever.\n", strings.Join(forever, ", "))
+	}
+	return nil
+}
+
+func formatGoroutineIds(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+func cont(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := count(args...)
+	if err != nil {
+		return err
+	}
+	if err := p.ContinueN(n); err != nil {
+		return err
+	}
+
+	switch p.StopReason() {
+	case proctl.StopHardcodedBreakpoint:
+		fmt.Println("Stopped at hardcoded breakpoint")
+	case proctl.StopSignal:
+		fmt.Printf("Received %s\n", proctl.SignalName(p.LastSignal()))
+	case proctl.StopSyscall:
+		fmt.Println(p.LastSyscall())
+	}
+
+	return printcontext(p)
+}
+
+func step(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := count(args...)
+	if err != nil {
+		return err
+	}
+	if err := p.StepN(n); err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+func stepin(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.StepInto()
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+func until(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	if err := p.ContinueUntil(args[0]); err != nil {
+		return err
 	}
 
-	return noCmdAvailable
+	return printcontext(p)
 }
 
-func CommandFunc(fn func() error) cmdfunc {
-	return func(p *proctl.DebuggedProcess, args ...string) error {
-		return fn()
+func stepout(p *proctl.DebuggedProcess, args ...string) error {
+	var err error
+	switch {
+	case len(args) == 0:
+		err = p.StepOut()
+	case args[0] == "defer":
+		err = p.StepToDeferred()
+	default:
+		return fmt.Errorf("invalid argument %s, expected defer", args[0])
+	}
+	if err != nil {
+		return err
 	}
+
+	return printcontext(p)
 }
 
-func noCmdAvailable(p *proctl.DebuggedProcess, ars ...string) error {
-	return fmt.Errorf("command not available")
+func rcont(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.ReverseContinue()
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
 }
 
-func nullCommand(p *proctl.DebuggedProcess, ars ...string) error {
+func rstep(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.ReverseStep()
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+func rnext(p *proctl.DebuggedProcess, args ...string) error {
+	err := p.ReverseNext()
+	if err != nil {
+		return err
+	}
+
+	return printcontext(p)
+}
+
+func restart(p *proctl.DebuggedProcess, args ...string) error {
+	if err := p.Restart(); err != nil {
+		return err
+	}
+	fmt.Println("Process restarted with PID", p.Pid)
 	return nil
 }
 
-func (c *Commands) help(p *proctl.DebuggedProcess, ars ...string) error {
-	fmt.Println("The following commands are available:")
-	for _, cmd := range c.cmds {
-		fmt.Printf("\t%s - %s\n", strings.Join(cmd.aliases, "|"), cmd.helpMsg)
+func rebuild(p *proctl.DebuggedProcess, args ...string) error {
+	if err := p.RebuildAndRestart(); err != nil {
+		return err
 	}
+	fmt.Println("Process rebuilt and restarted with PID", p.Pid)
 	return nil
 }
 
-func threads(p *proctl.DebuggedProcess, ars ...string) error {
-	for _, th := range p.Threads {
-		prefix := "  "
-		if th == p.CurrentThread {
-			prefix = "* "
+func checkpoint(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) > 0 {
+		if args[0] != "clear" {
+			return fmt.Errorf("unknown checkpoint command %s", args[0])
 		}
-		pc, err := th.CurrentPC()
+		if len(args) < 2 {
+			return fmt.Errorf("not enough arguments")
+		}
+		id, err := strconv.Atoi(args[1])
 		if err != nil {
 			return err
 		}
-		f, l, fn := th.Process.GoSymTable.PCToLine(pc)
-		if fn != nil {
-			fmt.Printf("%sThread %d at %#v %s:%d %s\n", prefix, th.Id, pc, f, l, fn.Name)
-		} else {
-			fmt.Printf("%sThread %d at %#v\n", prefix, th.Id, pc)
-		}
+		return p.ClearCheckpoint(id)
+	}
+	cp, err := p.Checkpoint()
+	if err != nil {
+		return err
 	}
+	fmt.Println(cp)
 	return nil
 }
 
-func thread(p *proctl.DebuggedProcess, ars ...string) error {
-	oldTid := p.CurrentThread.Id
-	tid, err := strconv.Atoi(ars[0])
+func checkpoints(p *proctl.DebuggedProcess, args ...string) error {
+	for _, cp := range p.Checkpoints() {
+		fmt.Println(cp)
+	}
+	return nil
+}
+
+func restore(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
 	if err != nil {
 		return err
 	}
+	if err := p.Restore(id); err != nil {
+		return err
+	}
+	return printcontext(p)
+}
 
-	err = p.SwitchThread(tid)
+func next(p *proctl.DebuggedProcess, args ...string) error {
+	n, err := count(args...)
 	if err != nil {
 		return err
 	}
+	if err := p.NextN(n); err != nil {
+		return err
+	}
 
-	fmt.Printf("Switched from %d to %d\n", oldTid, tid)
-	return nil
+	return printcontext(p)
 }
 
-func goroutines(p *proctl.DebuggedProcess, ars ...string) error {
-	return p.PrintGoroutinesInfo()
+// Returns how many times continue, step or next are to be repeated,
+// once unless given.
+func count(args ...string) (int, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %s", args[0])
+	}
+	return n, nil
 }
 
-func cont(p *proctl.DebuggedProcess, ars ...string) error {
-	err := p.Continue()
+func clear(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	bp, err := p.ClearByLocation(args[0])
 	if err != nil {
 		return err
 	}
+	if bp.Pending {
+		fmt.Printf("Breakpoint %d cleared at %s\n", bp.ID, bp.Location)
+		return nil
+	}
 
-	return printcontext(p)
+	fmt.Printf("Breakpoint %d cleared at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+
+	return nil
 }
 
-func step(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Step()
+func disable(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	bp, err := p.DisableBreakpoint(id)
 	if err != nil {
 		return err
 	}
 
-	return printcontext(p)
+	fmt.Printf("Breakpoint %d disabled\n", bp.ID)
+	return nil
 }
 
-func next(p *proctl.DebuggedProcess, args ...string) error {
-	err := p.Next()
+func enable(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid breakpoint id %s", args[0])
+	}
+
+	bp, err := p.EnableBreakpoint(id)
 	if err != nil {
 		return err
 	}
 
-	return printcontext(p)
+	fmt.Printf("Breakpoint %d enabled\n", bp.ID)
+	return nil
 }
 
-func clear(p *proctl.DebuggedProcess, args ...string) error {
+func exportBreakpoints(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
 	}
-
-	bp, err := p.ClearByLocation(args[0])
+	f, err := os.Create(args[0])
 	if err != nil {
 		return err
 	}
+	if err := p.ExportBreakpoints(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	fmt.Printf("Breakpoint %d cleared at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+func importBreakpoints(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
+	results, err := p.ImportBreakpoints(f)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Println(r)
+	}
 	return nil
 }
 
@@ -207,42 +1245,210 @@ func (a ById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ById) Less(i, j int) bool { return a[i].ID < a[j].ID }
 
 func breakpoints(p *proctl.DebuggedProcess, args ...string) error {
-	bps := make([]*proctl.BreakPoint, 0, len(p.BreakPoints)+4)
-
-	for _, bp := range p.HWBreakPoints {
-		if bp == nil {
+	bps := append(p.Breakpoints(), p.PendingBreakpoints()...)
+	sort.Sort(ById(bps))
+	for _, bp := range bps {
+		if hc := p.HitCounts(bp.ID); hc.Total > 0 {
+			fmt.Printf("%s %s\n", bp, hc)
 			continue
 		}
-		bps = append(bps, bp)
+		fmt.Println(bp)
 	}
 
-	for _, bp := range p.BreakPoints {
-		if bp.Temp {
-			continue
+	return nil
+}
+
+func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	var (
+		bp  *proctl.BreakPoint
+		err error
+	)
+	if args[0] == "pending" && len(args) > 1 {
+		bp, err = p.BreakPending(args[1])
+		if err == nil && bp.Pending {
+			fmt.Println(bp)
+			return nil
 		}
-		bps = append(bps, bp)
+	} else if args[0] == "goroutine-exit" && len(args) > 1 {
+		id, cerr := strconv.Atoi(args[1])
+		if cerr != nil {
+			return fmt.Errorf("invalid goroutine id %s", args[1])
+		}
+		bp, err = p.BreakOnGoroutineExit(id)
+	} else if len(args) > 2 && args[1] == "goroutine" {
+		id, cerr := strconv.Atoi(args[2])
+		if cerr != nil {
+			return fmt.Errorf("invalid goroutine id %s", args[2])
+		}
+		bp, err = p.BreakByLocationOnGoroutine(args[0], id)
+	} else {
+		bp, err = p.BreakByLocation(args[0])
+		if err != nil {
+			// Try as the method of an interface.
+			if bps, ierr := p.BreakInterfaceMethod(args[0]); ierr == nil {
+				for _, bp := range bps {
+					fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+				}
+				return nil
+			}
+		}
+	}
+	if err != nil {
+		return err
 	}
 
-	sort.Sort(ById(bps))
-	for _, bp := range bps {
-		fmt.Println(bp)
+	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+
+	return nil
+}
+
+func tracepoint(p *proctl.DebuggedProcess, args ...string) error {
+	var depth int
+	if len(args) > 0 && args[0] == "-stack" {
+		if len(args) < 2 {
+			return fmt.Errorf("not enough arguments")
+		}
+		var err error
+		if depth, err = strconv.Atoi(args[1]); err != nil || depth <= 0 {
+			return fmt.Errorf("invalid depth %s", args[1])
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
 	}
 
+	bp, err := p.TraceByLocation(args[0], args[1:]...)
+	if err != nil {
+		return err
+	}
+	bp.Stacktrace = depth
+	if bp.Group != 0 {
+		// Set on the other addresses of the line too.
+		for _, gbp := range p.Breakpoints() {
+			if gbp.Group == bp.Group {
+				gbp.Stacktrace = depth
+			}
+		}
+	}
+
+	fmt.Printf("Tracepoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+
 	return nil
 }
 
-func breakpoint(p *proctl.DebuggedProcess, args ...string) error {
+func breakPackage(p *proctl.DebuggedProcess, args ...string) error {
+	return setPackageBreakpoints(p, false, args...)
+}
+
+func tracePackage(p *proctl.DebuggedProcess, args ...string) error {
+	return setPackageBreakpoints(p, true, args...)
+}
+
+func breakRegexp(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	g, err := p.BreakByFunctionRegexp(args[0])
+	if g != nil {
+		for _, bp := range g.BreakPoints {
+			fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+		}
+		fmt.Printf("Group %d set for %s\n", g.ID, g.Pattern)
+	}
+	return err
+}
+
+func traceRegexp(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	g, err := p.TraceFunctions(args[0], func(fc proctl.FunctionCall) { fmt.Println(fc) })
+	if g != nil {
+		fmt.Printf("Tracing %d functions as group %d for %s\n", len(g.BreakPoints), g.ID, g.Pattern)
+	}
+	return err
+}
+
+func clearGroup(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid group id %s", args[0])
+	}
+
+	bps, err := p.ClearGroup(id)
+	fmt.Printf("%d breakpoints of group %d cleared\n", len(bps), id)
+	return err
+}
+
+func setPackageBreakpoints(p *proctl.DebuggedProcess, trace bool, args ...string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("not enough arguments")
+	}
+
+	bps, err := p.BreakPackage(args[0], trace)
+	for _, bp := range bps {
+		kind := "Breakpoint"
+		if bp.Tracepoint {
+			kind = "Tracepoint"
+		}
+		fmt.Printf("%s %d set at %#v for %s %s:%d\n", kind, bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	}
+	return err
+}
+
+func watch(p *proctl.DebuggedProcess, args ...string) error {
 	if len(args) == 0 {
 		return fmt.Errorf("not enough arguments")
 	}
 
-	bp, err := p.BreakByLocation(args[0])
+	bp, err := p.WatchGlobal(args[0])
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Breakpoint %d set at %#v for %s %s:%d\n", bp.ID, bp.Addr, bp.FunctionName, bp.File, bp.Line)
+	fmt.Printf("Watchpoint %d set at %#v for %s\n", bp.ID, bp.Addr, bp.Variable)
+	if bp.Partial {
+		fmt.Printf("Only the %d bytes at %#v of %s are watched, writes to the rest of it will not stop the process.\n", bp.WatchSize, bp.Addr, bp.Variable)
+	}
+
+	return nil
+}
 
+func display(p *proctl.DebuggedProcess, args ...string) error {
+	if len(args) == 0 {
+		for _, we := range p.WatchExpressions() {
+			fmt.Printf("%d: %s\n", we.ID, we.Expr)
+		}
+		return nil
+	}
+	if args[0] == "clear" {
+		if len(args) < 2 {
+			return fmt.Errorf("not enough arguments")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		return p.ClearWatchExpression(id)
+	}
+	we, err := p.AddWatchExpression(strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	// Shown right away, as at the next stops.
+	wv := proctl.WatchValue{WatchExpression: *we}
+	wv.Value, wv.Err = p.EvalSymbol(we.Expr)
+	fmt.Println(wv)
 	return nil
 }
 
@@ -251,7 +1457,19 @@ func printVar(p *proctl.DebuggedProcess, args ...string) error {
 		return fmt.Errorf("not enough arguments")
 	}
 
-	val, err := p.EvalSymbol(args[0])
+	format := proctl.FormatDefault
+	if strings.HasPrefix(args[0], "%") {
+		f, err := proctl.ParseFormat(args[0])
+		if err != nil {
+			return err
+		}
+		format, args = f, args[1:]
+		if len(args) == 0 {
+			return fmt.Errorf("not enough arguments")
+		}
+	}
+
+	val, err := p.EvalSymbolFormat(strings.Join(args, " "), format)
 	if err != nil {
 		return err
 	}
@@ -260,6 +1478,39 @@ func printVar(p *proctl.DebuggedProcess, args ...string) error {
 	return nil
 }
 
+func call(p *proctl.DebuggedProcess, args ...string) error {
+	src := strings.Join(args, " ")
+	expr, err := parser.ParseExpr(src)
+	ce, ok := expr.(*ast.CallExpr)
+	if err != nil || !ok {
+		return fmt.Errorf("expected call <function>(<arguments>)")
+	}
+
+	// Positions are offsets in src, plus one.
+	fn := src[ce.Fun.Pos()-1 : ce.Fun.End()-1]
+	var fnArgs []string
+	for _, arg := range ce.Args {
+		fnArgs = append(fnArgs, src[arg.Pos()-1:arg.End()-1])
+	}
+	results, err := p.Call(fn, fnArgs...)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Printf("%s = %s\n", r.Name, r.Value)
+	}
+	return nil
+}
+
+func setVar(p *proctl.DebuggedProcess, args ...string) error {
+	parts := strings.SplitN(strings.Join(args, " "), "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("wrong number of arguments, expected set <variable> = <value>")
+	}
+
+	return p.SetSymbol(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+}
+
 func filterVariables(vars []*proctl.Variable, filter *regexp.Regexp) []string {
 	data := make([]string, 0, len(vars))
 	for _, v := range vars {
@@ -279,10 +1530,14 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 	}
 
 	// Allow for optional regex
-	var filter *regexp.Regexp
+	var (
+		pattern string
+		filter  *regexp.Regexp
+		err     error
+	)
 	if len(args) >= 2 {
-		var err error
-		if filter, err = regexp.Compile(args[1]); err != nil {
+		pattern = args[1]
+		if filter, err = regexp.Compile(pattern); err != nil {
 			return fmt.Errorf("invalid filter argument: %s", err.Error())
 		}
 	}
@@ -291,20 +1546,13 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 
 	switch args[0] {
 	case "sources":
-		data = make([]string, 0, len(p.GoSymTable.Files))
-		for f := range p.GoSymTable.Files {
-			if filter == nil || filter.Match([]byte(f)) {
-				data = append(data, f)
-			}
-		}
+		data, err = p.Sources(pattern)
 
 	case "funcs":
-		data = make([]string, 0, len(p.GoSymTable.Funcs))
-		for _, f := range p.GoSymTable.Funcs {
-			if f.Sym != nil && (filter == nil || filter.Match([]byte(f.Name))) {
-				data = append(data, f.Name)
-			}
-		}
+		data, err = p.Funcs(pattern)
+
+	case "types":
+		data, err = p.Types(pattern)
 
 	case "args":
 		vars, err := p.CurrentThread.FunctionArguments()
@@ -328,7 +1576,10 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 		data = filterVariables(vars, filter)
 
 	default:
-		return fmt.Errorf("unsupported info type, must be args, funcs, locals, sources, or vars")
+		return fmt.Errorf("unsupported info type, must be args, funcs, locals, sources, types, or vars")
+	}
+	if err != nil {
+		return err
 	}
 
 	// sort and output data
@@ -341,6 +1592,23 @@ func info(p *proctl.DebuggedProcess, args ...string) error {
 	return nil
 }
 
+// Number of bytes of machine code shown where there is no source.
+const instructionContextBytes = 32
+
+// Formats the instructions shown where there is no source, one per
+// line, pointing at the one at pc.
+func formatInstructions(insts []proctl.Instruction, pc uint64) []string {
+	lines := make([]string, 0, len(insts))
+	for _, inst := range insts {
+		arrow := "  "
+		if inst.Addr == pc {
+			arrow = "=>"
+		}
+		lines = append(lines, fmt.Sprintf("\033[34m%s %#x\033[0m: %s\n", arrow, inst.Addr, inst.Text))
+	}
+	return lines
+}
+
 func printcontext(p *proctl.DebuggedProcess) error {
 	var context []string
 
@@ -353,7 +1621,7 @@ func printcontext(p *proctl.DebuggedProcess) error {
 
 	if fn != nil {
 		fmt.Printf("current loc: %s %s:%d\n", fn.Name, f, l)
-		file, err := os.Open(f)
+		file, err := os.Open(p.LocalPath(f))
 		if err != nil {
 			return err
 		}
@@ -388,10 +1656,16 @@ func printcontext(p *proctl.DebuggedProcess) error {
 		}
 	} else {
 		fmt.Printf("Stopped at: 0x%x\n", regs.PC())
-		context = append(context, "\033[34m=>\033[0m    no source available")
+		context = append(context, "\033[34m=>\033[0m    no source available, stepping by instruction\n")
+		if insts, err := p.Disassemble(regs.PC(), instructionContextBytes); err == nil {
+			context = append(context, formatInstructions(insts, regs.PC())...)
+		}
 	}
 
 	fmt.Println(strings.Join(context, ""))
+	for _, wv := range p.WatchValues() {
+		fmt.Println(wv)
+	}
 
 	return nil
 }
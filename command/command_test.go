@@ -2,6 +2,10 @@ package command
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/derekparker/delve/proctl"
@@ -51,3 +55,82 @@ func TestCommandReplayWithoutPreviousCommand(t *testing.T) {
 		t.Error("Null command not returned", err)
 	}
 }
+
+func TestTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "delve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cmds := DebugCommands()
+	cmds.Record("break main.main")
+	cmds.Record("continue")
+	path := filepath.Join(dir, "transcript")
+	if err := cmds.saveTranscript(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "break main.main\ncontinue\n" {
+		t.Fatalf("wrong transcript %q", data)
+	}
+}
+
+func TestOnHitScriptErrors(t *testing.T) {
+	cmds := DebugCommands()
+	for _, args := range [][]string{
+		{"1"},
+		{"x", "print", "a"},
+		{"1", "print", "a;", "next"},
+		{"1", "frobnicate"},
+	} {
+		if err := cmds.onHit(nil, args...); err == nil {
+			t.Fatalf("Expected an error for on %v", args)
+		}
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	var ran []string
+	cmds := DebugCommands()
+	cmds.Register("foo", func(p *proctl.DebuggedProcess, args ...string) error {
+		ran = append(ran, args...)
+		return nil
+	}, "foo command")
+
+	err := cmds.RunCommands(nil, []string{"# setup", "foo a b", "", "  foo c  "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ran, []string{"a", "b", "c"}) {
+		t.Fatalf("wrong arguments %v", ran)
+	}
+	if !reflect.DeepEqual(cmds.transcript, []string{"foo a b", "foo c"}) {
+		t.Fatalf("wrong transcript %v", cmds.transcript)
+	}
+
+	ran = nil
+	if err := cmds.RunCommands(nil, []string{"frobnicate", "foo d"}); err == nil {
+		t.Fatal("Expected an error for an unknown command")
+	}
+	if len(ran) != 0 {
+		t.Fatalf("Expected to stop at the unknown command, ran foo %v", ran)
+	}
+}
+
+func TestFormatInstructions(t *testing.T) {
+	insts := []proctl.Instruction{
+		{Addr: 0x401000, Len: 4, Text: "SUBQ $0x18, SP"},
+		{Addr: 0x401004, Len: 5, Text: "CALL main.helloworld(SB)"},
+	}
+	expected := []string{
+		"\033[34m   0x401000\033[0m: SUBQ $0x18, SP\n",
+		"\033[34m=> 0x401004\033[0m: CALL main.helloworld(SB)\n",
+	}
+	if lines := formatInstructions(insts, 0x401004); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %q got %q", expected, lines)
+	}
+}
@@ -0,0 +1,244 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/derekparker/delve/dwarf/util"
+)
+
+// Encodings of the pointers of .eh_frame, the low nibble giving the
+// format of the value and the high one what it is relative to.
+const (
+	ehPeAbsptr  = 0x00
+	ehPeUleb128 = 0x01
+	ehPeUdata2  = 0x02
+	ehPeUdata4  = 0x03
+	ehPeUdata8  = 0x04
+	ehPeSleb128 = 0x09
+	ehPeSdata2  = 0x0a
+	ehPeSdata4  = 0x0b
+	ehPeSdata8  = 0x0c
+	ehPePcrel   = 0x10
+	ehPeOmit    = 0xff
+)
+
+// ParseEhFrame parses the contents of the .eh_frame section loaded at
+// addr, which describes the frames of binaries built without
+// .debug_frame, as those stripped of their debug information or linked
+// by an external linker. Its entries differ from those of .debug_frame
+// in the way they refer to their CIE and the encoding of their
+// addresses, given by the augmentation data of the CIE.
+func ParseEhFrame(data []byte, addr uint64) (FrameDescriptionEntries, error) {
+	var (
+		fdes = NewFrameIndex()
+		cies = make(map[int]*ehCIE)
+	)
+
+	for off := 0; off+4 <= len(data); {
+		ulength := binary.LittleEndian.Uint32(data[off:])
+		if ulength == 0 {
+			// Terminator.
+			break
+		}
+		if ulength == 0xffffffff {
+			return nil, fmt.Errorf("64-bit .eh_frame entry at %#x not supported", off)
+		}
+		start := off + 4
+		// Checked before converting, start+length could overflow an
+		// int of 32 bits.
+		if ulength < 4 || uint64(ulength) > uint64(len(data)-start) {
+			return nil, fmt.Errorf("invalid .eh_frame entry at %#x", off)
+		}
+		length := int(ulength)
+		end := start + length
+		uid := binary.LittleEndian.Uint32(data[start:])
+		if uint64(uid) > uint64(start) {
+			return nil, fmt.Errorf("FDE at %#x refers to a CIE before the section", off)
+		}
+		id := int(uid)
+		body := data[start+4 : end]
+
+		if id == 0 {
+			cie, err := parseEhCIE(body, uint32(length-4))
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIE at %#x: %s", off, err)
+			}
+			cies[off] = cie
+		} else {
+			// The CIE pointer is the distance back to the CIE
+			// from the pointer itself.
+			cie, ok := cies[start-id]
+			if !ok {
+				return nil, fmt.Errorf("FDE at %#x refers to unknown CIE at %#x", off, start-id)
+			}
+			fde, err := parseEhFDE(body, uint32(length-4), cie, addr+uint64(start+4))
+			if err != nil {
+				return nil, fmt.Errorf("invalid FDE at %#x: %s", off, err)
+			}
+			fdes = append(fdes, fde)
+		}
+		off = end
+	}
+
+	if !sort.IsSorted(byBegin(fdes)) {
+		sort.Sort(byBegin(fdes))
+	}
+	return fdes, nil
+}
+
+// A CIE of .eh_frame, with what its augmentation tells of its FDEs.
+type ehCIE struct {
+	*CommonInformationEntry
+	hasAugmentationData bool
+	ptrEncoding         byte // Of the addresses of the FDEs
+}
+
+func parseEhCIE(data []byte, length uint32) (*ehCIE, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty entry")
+	}
+	buf := bytes.NewBuffer(data[1:])
+	cie := &ehCIE{CommonInformationEntry: &CommonInformationEntry{Length: length, Version: data[0]}}
+	cie.Augmentation, _ = util.ParseString(buf)
+	cie.CodeAlignmentFactor, _ = util.DecodeULEB128(buf)
+	cie.DataAlignmentFactor, _ = util.DecodeSLEB128(buf)
+	if cie.Version == 1 {
+		reg, err := buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		cie.ReturnAddressRegister = uint64(reg)
+	} else {
+		cie.ReturnAddressRegister, _ = util.DecodeULEB128(buf)
+	}
+
+	if len(cie.Augmentation) > 0 && cie.Augmentation[0] == 'z' {
+		cie.hasAugmentationData = true
+		n, _ := util.DecodeULEB128(buf)
+		aug := bytes.NewBuffer(buf.Next(int(n)))
+		for _, c := range cie.Augmentation[1:] {
+			switch c {
+			case 'R':
+				enc, err := aug.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				cie.ptrEncoding = enc
+			case 'P':
+				// The personality routine, of no use to unwind.
+				enc, err := aug.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				if _, err := readEncoded(aug, enc&0x0f, 0); err != nil {
+					return nil, err
+				}
+			case 'L':
+				// The encoding of the language specific data
+				// the FDEs point to, skipped with the rest of
+				// their augmentation data.
+				if _, err := aug.ReadByte(); err != nil {
+					return nil, err
+				}
+			case 'S':
+				// Frames of signal handlers.
+			default:
+				return nil, fmt.Errorf("unknown augmentation %q", cie.Augmentation)
+			}
+		}
+	} else if cie.Augmentation != "" {
+		return nil, fmt.Errorf("unknown augmentation %q", cie.Augmentation)
+	}
+
+	cie.InitialInstructions = buf.Bytes()
+	return cie, nil
+}
+
+// Parses the FDE whose address range starts at addr, once loaded.
+func parseEhFDE(data []byte, length uint32, cie *ehCIE, addr uint64) (*FrameDescriptionEntry, error) {
+	buf := bytes.NewBuffer(data)
+	begin, err := readEncoded(buf, cie.ptrEncoding, addr)
+	if err != nil {
+		return nil, err
+	}
+	// The size of the range isn't an address, whatever it's relative to.
+	size, err := readEncoded(buf, cie.ptrEncoding&0x0f, 0)
+	if err != nil {
+		return nil, err
+	}
+	if cie.hasAugmentationData {
+		n, _ := util.DecodeULEB128(buf)
+		buf.Next(int(n))
+	}
+	return &FrameDescriptionEntry{
+		Length:       length,
+		CIE:          cie.CommonInformationEntry,
+		Instructions: buf.Bytes(),
+		begin:        begin,
+		end:          size,
+	}, nil
+}
+
+// Reads a pointer with the given encoding, at addr once loaded.
+func readEncoded(buf *bytes.Buffer, enc byte, addr uint64) (uint64, error) {
+	if enc == ehPeOmit {
+		return 0, nil
+	}
+
+	var v uint64
+	switch enc & 0x0f {
+	case ehPeAbsptr:
+		b := buf.Next(ptrsize)
+		if len(b) < ptrsize {
+			return 0, fmt.Errorf("truncated pointer")
+		}
+		v = decodeAddress(b)
+	case ehPeUleb128:
+		v, _ = util.DecodeULEB128(buf)
+	case ehPeSleb128:
+		n, _ := util.DecodeSLEB128(buf)
+		v = uint64(n)
+	case ehPeUdata2, ehPeSdata2, ehPeUdata4, ehPeSdata4, ehPeUdata8, ehPeSdata8:
+		var size int
+		switch enc & 0x07 {
+		case ehPeUdata2:
+			size = 2
+		case ehPeUdata4:
+			size = 4
+		default:
+			size = 8
+		}
+		b := buf.Next(size)
+		if len(b) < size {
+			return 0, fmt.Errorf("truncated pointer")
+		}
+		switch size {
+		case 2:
+			v = uint64(binary.LittleEndian.Uint16(b))
+			if enc&0x08 != 0 {
+				v = uint64(int16(v))
+			}
+		case 4:
+			v = uint64(binary.LittleEndian.Uint32(b))
+			if enc&0x08 != 0 {
+				v = uint64(int32(v))
+			}
+		case 8:
+			v = binary.LittleEndian.Uint64(b)
+		}
+	default:
+		return 0, fmt.Errorf("unknown pointer encoding %#x", enc)
+	}
+
+	switch enc & 0x70 {
+	case 0:
+	case ehPePcrel:
+		v += addr
+	default:
+		return 0, fmt.Errorf("unsupported pointer encoding %#x", enc)
+	}
+	return v, nil
+}
@@ -0,0 +1,67 @@
+package frame
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseEhFrame(t *testing.T) {
+	data := []byte{
+		// CIE
+		0x14, 0, 0, 0, // length
+		0, 0, 0, 0, // id
+		1,           // version
+		'z', 'R', 0, // augmentation
+		1,                                  // code alignment factor
+		0x78,                               // data alignment factor, -8
+		16,                                 // return address register
+		1,                                  // augmentation data length
+		0x1b,                               // pcrel sdata4
+		0x0c, 0x07, 0x08, 0x90, 0x01, 0, 0, // initial instructions
+		// FDE
+		0x14, 0, 0, 0, // length
+		0x1c, 0, 0, 0, // CIE pointer
+		0xf8, 0x0f, 0, 0, // begin, relative to its own address
+		0x20, 0, 0, 0, // size
+		0,                            // augmentation data length
+		0x41, 0x0e, 0x10, 0, 0, 0, 0, // instructions
+		// Terminator
+		0, 0, 0, 0,
+	}
+	const addr = 0x400000
+
+	fdes, err := ParseEhFrame(data, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fdes) != 1 {
+		t.Fatalf("expected 1 FDE, got %d", len(fdes))
+	}
+
+	fde := fdes[0]
+	if begin := uint64(addr + 0x20 + 0xff8); fde.Begin() != begin || fde.End() != begin+0x20 {
+		t.Fatalf("expected FDE from %#x to %#x, got %#x to %#x", begin, begin+0x20, fde.Begin(), fde.End())
+	}
+	if fde.CIE.ReturnAddressRegister != 16 || fde.CIE.DataAlignmentFactor != -8 {
+		t.Fatalf("unexpected CIE %+v", fde.CIE)
+	}
+	if !bytes.Equal(fde.Instructions, []byte{0x41, 0x0e, 0x10, 0, 0, 0, 0}) {
+		t.Fatalf("unexpected instructions %v", fde.Instructions)
+	}
+	if _, err := fdes.FDEForPC(fde.Begin() + 4); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseEhFrameInvalidLength(t *testing.T) {
+	for _, length := range [][]byte{
+		{0xff, 0xff, 0xff, 0xff}, // 64-bit entry
+		{0xfe, 0xff, 0xff, 0xff}, // past the end, overflowing 32 bits
+		{0x02, 0, 0, 0},          // shorter than its id
+	} {
+		data := append(length, 0, 0, 0, 0, 0, 0, 0, 0)
+		if _, err := ParseEhFrame(data, 0x400000); err == nil {
+			t.Fatalf("expected an error for length % x", length)
+		}
+	}
+}
@@ -57,12 +57,42 @@ func (fde *FrameDescriptionEntry) ReturnAddressOffset(pc uint64) int64 {
 	return frame.cfa.offset + frame.regs[fde.CIE.ReturnAddressRegister].offset
 }
 
+// Returns whether the return address has been saved on the stack at the
+// given PC. Otherwise it is still in the return address register, as on
+// entry to a function on architectures with a link register.
+func (fde *FrameDescriptionEntry) ReturnAddressSaved(pc uint64) bool {
+	frame := fde.EstablishFrame(pc)
+	return frame.regs[fde.CIE.ReturnAddressRegister].rule == rule_offset
+}
+
 type FrameDescriptionEntries []*FrameDescriptionEntry
 
 func NewFrameIndex() FrameDescriptionEntries {
 	return make(FrameDescriptionEntries, 0, 1000)
 }
 
+// Relocate moves the entries by bias, for programs loaded at an
+// address other than the one they were linked at.
+func (fdes FrameDescriptionEntries) Relocate(bias uint64) {
+	for _, fde := range fdes {
+		fde.begin += bias
+	}
+}
+
+// Merge returns the entries along with those of other covering code
+// they don't, as C code only described by .eh_frame in programs whose
+// Go code is described by .debug_frame.
+func (fdes FrameDescriptionEntries) Merge(other FrameDescriptionEntries) FrameDescriptionEntries {
+	merged := append(NewFrameIndex(), fdes...)
+	for _, fde := range other {
+		if _, err := fdes.FDEForPC(fde.Begin()); err != nil {
+			merged = append(merged, fde)
+		}
+	}
+	sort.Sort(byBegin(merged))
+	return merged
+}
+
 // Returns the Frame Description Entry for the given PC.
 func (fdes FrameDescriptionEntries) FDEForPC(pc uint64) (*FrameDescriptionEntry, error) {
 	idx := sort.Search(len(fdes), func(i int) bool {
@@ -75,7 +105,7 @@ func (fdes FrameDescriptionEntries) FDEForPC(pc uint64) (*FrameDescriptionEntry,
 		return true
 
 	})
-	if idx == len(fdes) {
+	if idx == len(fdes) || !fdes[idx].Cover(pc) {
 		return nil, fmt.Errorf("could not find FDE for PC %#v", pc)
 	}
 	return fdes[idx], nil
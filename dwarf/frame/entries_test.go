@@ -1,6 +1,7 @@
 package frame
 
 import (
+	"encoding/binary"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -46,3 +47,123 @@ func BenchmarkFDEForPC(b *testing.B) {
 		_, _ = fdes.FDEForPC(0x455555555)
 	}
 }
+
+func TestParseSorted(t *testing.T) {
+	f, err := os.Open("testdata/frame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fdes := Parse(data)
+	if len(fdes) == 0 {
+		t.Fatal("No entries parsed")
+	}
+
+	for i, fde := range fdes {
+		if i > 0 && fdes[i-1].Begin() > fde.Begin() {
+			t.Fatalf("Entries not sorted: %#x before %#x", fdes[i-1].Begin(), fde.Begin())
+		}
+		if fde.CIE == nil {
+			t.Fatalf("Entry at %#x has no CIE", fde.Begin())
+		}
+		if found, err := fdes.FDEForPC(fde.Begin()); err != nil || found != fde {
+			t.Fatalf("Could not find entry at %#x: %v", fde.Begin(), err)
+		}
+	}
+}
+
+func TestReturnAddressSaved(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/frame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// On amd64 the call pushes the return address.
+	for _, fde := range Parse(data) {
+		if !fde.ReturnAddressSaved(fde.Begin()) {
+			t.Fatalf("return address not saved at entry %#x", fde.Begin())
+		}
+		if off := fde.ReturnAddressOffset(fde.Begin()); off != 0 {
+			t.Fatalf("return address at %d at entry %#x, expected 0", off, fde.Begin())
+		}
+	}
+
+	// With a link register it stays there until the prologue saves it.
+	cie := &CommonInformationEntry{ReturnAddressRegister: 30, CodeAlignmentFactor: 4, DataAlignmentFactor: -8}
+	fde := &FrameDescriptionEntry{CIE: cie, begin: 0x1000, end: 0x100}
+	if fde.ReturnAddressSaved(0x1000) {
+		t.Fatal("return address saved at entry of a link register frame")
+	}
+}
+
+func TestDecodeFDEsParallel(t *testing.T) {
+	fdes := NewFrameIndex()
+	for i := 0; i < 2*minParallelEntries; i++ {
+		raw := make([]byte, 17)
+		binary.LittleEndian.PutUint64(raw, uint64(i*10))
+		binary.LittleEndian.PutUint64(raw[8:], 10)
+		raw[16] = byte(i)
+		fdes = append(fdes, &FrameDescriptionEntry{Instructions: raw})
+	}
+
+	decodeFDEs(fdes)
+	for i, fde := range fdes {
+		if fde.Begin() != uint64(i*10) || fde.End() != uint64(i*10+10) || len(fde.Instructions) != 1 || fde.Instructions[0] != byte(i) {
+			t.Fatalf("Entry %d decoded as %#x-%#x %v", i, fde.Begin(), fde.End(), fde.Instructions)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	fde1 := &FrameDescriptionEntry{begin: 0, end: 50}
+	fde2 := &FrameDescriptionEntry{begin: 100, end: 50}
+	fde3 := &FrameDescriptionEntry{begin: 50, end: 50}
+	fde4 := &FrameDescriptionEntry{begin: 100, end: 10}
+
+	frames := append(NewFrameIndex(), fde1, fde2)
+	if _, err := frames.FDEForPC(60); err == nil {
+		t.Fatal("Expected no FDE between the entries")
+	}
+
+	merged := frames.Merge(FrameDescriptionEntries{fde3, fde4})
+	if len(merged) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(merged))
+	}
+	for pc, fde := range map[uint64]*FrameDescriptionEntry{10: fde1, 60: fde3, 105: fde2} {
+		if node, err := merged.FDEForPC(pc); err != nil || node != fde {
+			t.Fatalf("Got incorrect fde for %d", pc)
+		}
+	}
+}
+
+func TestRememberState(t *testing.T) {
+	cie := &CommonInformationEntry{
+		CodeAlignmentFactor:   1,
+		DataAlignmentFactor:   -8,
+		ReturnAddressRegister: 16,
+		// DW_CFA_def_cfa rsp 8, DW_CFA_offset rip 1
+		InitialInstructions: []byte{DW_CFA_def_cfa, 7, 8, DW_CFA_offset | 16, 1},
+	}
+	fde := &FrameDescriptionEntry{
+		CIE: cie,
+		Instructions: []byte{
+			DW_CFA_advance_loc | 1, DW_CFA_def_cfa_offset, 16, DW_CFA_offset | 6, 2,
+			DW_CFA_advance_loc | 1, DW_CFA_remember_state, DW_CFA_def_cfa, 7, 8, DW_CFA_restore | 6,
+			DW_CFA_advance_loc | 1, DW_CFA_restore_state,
+		},
+		begin: 0x1000,
+		end:   0x10,
+	}
+
+	fctx := fde.EstablishFrame(0x1003)
+	if fctx.CFARegister() != 7 || fctx.CFAOffset() != 16 {
+		t.Fatalf("Expected the CFA at rsp+16 after the epilogue, got %d+%d", fctx.CFARegister(), fctx.CFAOffset())
+	}
+	if off, ok := fctx.SavedRegisterOffset(6); !ok || off != -16 {
+		t.Fatalf("Expected rbp saved at CFA-16, got %d %v", off, ok)
+	}
+}
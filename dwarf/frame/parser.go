@@ -6,10 +6,22 @@ package frame
 import (
 	"bytes"
 	"encoding/binary"
+	"runtime"
+	"sort"
+	"sync"
+	"unsafe"
 
 	"github.com/derekparker/delve/dwarf/util"
 )
 
+// Size of the addresses in the entries. Programs are debugged by a
+// debugger built for the same architecture, so it is our own.
+const ptrsize = int(unsafe.Sizeof(uintptr(0)))
+
+// Below this number of entries, decoding them
+// in parallel costs more than it saves.
+const minParallelEntries = 4096
+
 type parsefunc func(*parseContext) parsefunc
 
 type parseContext struct {
@@ -23,6 +35,10 @@ type parseContext struct {
 // Parse takes in data (a byte slice) and returns a slice of
 // CommonInformationEntry structures. Each CommonInformationEntry
 // has a slice of FrameDescriptionEntry structures.
+//
+// Entries are split sequentially, as each one starts where the
+// previous one ends, but the frame description entries are then
+// decoded in parallel and sorted by address.
 func Parse(data []byte) FrameDescriptionEntries {
 	var (
 		buf  = bytes.NewBuffer(data)
@@ -33,9 +49,64 @@ func Parse(data []byte) FrameDescriptionEntries {
 		fn = fn(pctx)
 	}
 
+	decodeFDEs(pctx.Entries)
+	if !sort.IsSorted(byBegin(pctx.Entries)) {
+		sort.Sort(byBegin(pctx.Entries))
+	}
 	return pctx.Entries
 }
 
+// Decodes the address range of each entry, spreading
+// them over one worker per CPU for large binaries.
+func decodeFDEs(fdes FrameDescriptionEntries) {
+	workers := runtime.NumCPU()
+	if len(fdes) < minParallelEntries || workers == 1 {
+		for _, fde := range fdes {
+			fde.decode()
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	n := (len(fdes) + workers - 1) / workers
+	for start := 0; start < len(fdes); start += n {
+		end := start + n
+		if end > len(fdes) {
+			end = len(fdes)
+		}
+		wg.Add(1)
+		go func(fdes FrameDescriptionEntries) {
+			defer wg.Done()
+			for _, fde := range fdes {
+				fde.decode()
+			}
+		}(fdes[start:end])
+	}
+	wg.Wait()
+}
+
+// Splits the raw entry, stored in Instructions by
+// parseFDE, into its address range and instructions.
+func (fde *FrameDescriptionEntry) decode() {
+	r := fde.Instructions
+	fde.begin = decodeAddress(r[:ptrsize])
+	fde.end = decodeAddress(r[ptrsize : 2*ptrsize])
+	fde.Instructions = r[2*ptrsize:]
+}
+
+func decodeAddress(b []byte) uint64 {
+	if len(b) == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+type byBegin FrameDescriptionEntries
+
+func (s byBegin) Len() int           { return len(s) }
+func (s byBegin) Less(i, j int) bool { return s[i].begin < s[j].begin }
+func (s byBegin) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 func cieEntry(data []byte) bool {
 	return bytes.Equal(data, []byte{0xff, 0xff, 0xff, 0xff})
 }
@@ -55,19 +126,10 @@ func parseLength(ctx *parseContext) parsefunc {
 }
 
 func parseFDE(ctx *parseContext) parsefunc {
-	r := ctx.Buf.Next(int(ctx.Length))
-
-	ctx.Frame.begin = binary.LittleEndian.Uint64(r[:8])
-	ctx.Frame.end = binary.LittleEndian.Uint64(r[8:16])
-
-	// Insert into the tree after setting address range begin
-	// otherwise compares won't work.
+	// The address range and the instructions that make up
+	// the rest of this entry are decoded later, in bulk.
+	ctx.Frame.Instructions = ctx.Buf.Next(int(ctx.Length))
 	ctx.Entries = append(ctx.Entries, ctx.Frame)
-
-	// The rest of this entry consists of the instructions
-	// so we can just grab all of the data from the buffer
-	// cursor to length.
-	ctx.Frame.Instructions = r[16:]
 	ctx.Length = 0
 
 	return parseLength
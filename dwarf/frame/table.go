@@ -29,6 +29,7 @@ type FrameContext struct {
 	regs          map[uint64]DWRule
 	initialRegs   map[uint64]DWRule
 	prevRegs      map[uint64]DWRule
+	prevCFA       CurrentFrameAddress
 	buf           *bytes.Buffer
 	cie           *CommonInformationEntry
 	codeAlignment uint64
@@ -39,6 +40,23 @@ func (fctx *FrameContext) CFAOffset() int64 {
 	return fctx.cfa.offset
 }
 
+// Returns the DWARF number of the register the CFA is an offset from,
+// the stack pointer or, in C code, often the frame pointer.
+func (fctx *FrameContext) CFARegister() uint64 {
+	return fctx.cfa.register
+}
+
+// Returns the offset from the CFA the register reg of the caller is
+// saved at, and whether it is saved there. Registers not saved keep
+// their value, as far as unwinding is concerned.
+func (fctx *FrameContext) SavedRegisterOffset(reg uint64) (int64, bool) {
+	r, ok := fctx.regs[reg]
+	if !ok || r.rule != rule_offset {
+		return 0, false
+	}
+	return r.offset, true
+}
+
 // Instructions used to recreate the table from the .debug_frame data.
 const (
 	DW_CFA_nop                = 0x0        // No ops
@@ -65,6 +83,7 @@ const (
 	DW_CFA_val_offset_sf                   // op1: ULEB128, op2: SLEB128
 	DW_CFA_val_expression                  // op1: ULEB128, op2: BLOCK
 	DW_CFA_lo_user            = 0x1c       // op1: BLOCK
+	DW_CFA_GNU_args_size      = 0x2e       // op1: ULEB128 size
 	DW_CFA_hi_user            = 0x3f       // op1: ULEB128 register, op2: BLOCK
 	DW_CFA_advance_loc        = (0x1 << 6) // High 2 bits: 0x1, low 6: delta
 	DW_CFA_offset             = (0x2 << 6) // High 2 bits: 0x2, low 6: register
@@ -115,6 +134,7 @@ var fnlookup = map[byte]instruction{
 	DW_CFA_val_offset_sf:      valoffsetsf,
 	DW_CFA_val_expression:     valexpression,
 	DW_CFA_lo_user:            louser,
+	DW_CFA_GNU_args_size:      argssize,
 	DW_CFA_hi_user:            hiuser,
 }
 
@@ -151,13 +171,14 @@ func (frame *FrameContext) ExecuteDwarfProgram() {
 
 // Execute dwarf instructions.
 func (frame *FrameContext) ExecuteUntilPC(instructions []byte) {
-	frame.buf.Reset()
-	frame.buf.Write(instructions)
+	// Don't reuse the buffer, it still holds the initial
+	// instructions of the CIE, which writing would overwrite.
+	frame.buf = bytes.NewBuffer(instructions)
 
 	// We only need to execute the instructions until
 	// ctx.loc > ctx.addess (which is the address we
 	// are currently at in the traced process).
-	for frame.address > frame.loc && frame.buf.Len() > 0 {
+	for frame.address >= frame.loc && frame.buf.Len() > 0 {
 		executeDwarfInstruction(frame)
 	}
 }
@@ -287,7 +308,7 @@ func offsetextended(frame *FrameContext) {
 		offset, _ = util.DecodeULEB128(frame.buf)
 	)
 
-	frame.regs[reg] = DWRule{offset: int64(offset), rule: rule_offset}
+	frame.regs[reg] = DWRule{offset: int64(offset) * frame.dataAlignment, rule: rule_offset}
 }
 
 func undefined(frame *FrameContext) {
@@ -307,11 +328,16 @@ func register(frame *FrameContext) {
 }
 
 func rememberstate(frame *FrameContext) {
-	frame.prevRegs = frame.regs
+	frame.prevRegs = make(map[uint64]DWRule, len(frame.regs))
+	for reg, rule := range frame.regs {
+		frame.prevRegs[reg] = rule
+	}
+	frame.prevCFA = frame.cfa
 }
 
 func restorestate(frame *FrameContext) {
 	frame.regs = frame.prevRegs
+	frame.cfa = frame.prevCFA
 }
 
 func restoreextended(frame *FrameContext) {
@@ -414,6 +440,12 @@ func valexpression(frame *FrameContext) {
 	frame.regs[reg] = DWRule{rule: rule_valexpression, expression: expr}
 }
 
+// The size of the arguments pushed on the stack, emitted by GCC in
+// .eh_frame, of no use to unwind.
+func argssize(frame *FrameContext) {
+	util.DecodeULEB128(frame.buf)
+}
+
 func louser(frame *FrameContext) {
 	frame.buf.Next(1)
 }
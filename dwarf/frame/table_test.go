@@ -0,0 +1,64 @@
+package frame
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExecuteUntilPC(t *testing.T) {
+	initial := []byte{
+		DW_CFA_def_cfa, 7, 8, // CFA = rsp+8
+		DW_CFA_offset | 16, 1, // return address at CFA-8
+		DW_CFA_nop, DW_CFA_nop, DW_CFA_nop, DW_CFA_nop,
+		DW_CFA_nop, DW_CFA_nop, DW_CFA_nop, DW_CFA_nop,
+		DW_CFA_nop, DW_CFA_nop, DW_CFA_nop,
+	}
+	cie := &CommonInformationEntry{
+		CodeAlignmentFactor: 1,
+		DataAlignmentFactor: -8,
+		InitialInstructions: append([]byte(nil), initial...),
+	}
+	fde := &FrameDescriptionEntry{
+		CIE:   cie,
+		begin: 0x1000,
+		end:   0x1100,
+		Instructions: []byte{
+			DW_CFA_advance_loc | 4,
+			DW_CFA_def_cfa_offset, 16, // CFA = rsp+16
+			DW_CFA_offset_extended, 6, 2, // rbp at CFA-16
+			DW_CFA_advance_loc | 8,
+			DW_CFA_def_cfa_offset, 32, // CFA = rsp+32
+		},
+	}
+
+	tests := []struct {
+		pc     uint64
+		cfa    int64
+		regs   map[uint64]int64
+		nosave []uint64
+	}{
+		{0x1000, 8, map[uint64]int64{16: -8}, []uint64{6}},
+		{0x1008, 16, map[uint64]int64{16: -8, 6: -16}, nil},
+		{0x1020, 32, map[uint64]int64{16: -8, 6: -16}, nil},
+	}
+	for _, test := range tests {
+		frame := executeDwarfProgramUntilPC(fde, test.pc)
+		if frame.cfa.register != 7 || frame.cfa.offset != test.cfa {
+			t.Fatalf("expected CFA rsp+%d at %#x, got r%d+%d", test.cfa, test.pc, frame.cfa.register, frame.cfa.offset)
+		}
+		for reg, off := range test.regs {
+			if rule, ok := frame.regs[reg]; !ok || rule.rule != rule_offset || rule.offset != off {
+				t.Fatalf("expected r%d at CFA%+d at %#x, got %+v", reg, off, test.pc, rule)
+			}
+		}
+		for _, reg := range test.nosave {
+			if _, ok := frame.regs[reg]; ok {
+				t.Fatalf("expected r%d not to be saved at %#x", reg, test.pc)
+			}
+		}
+		// The initial instructions are run again for every pc.
+		if !bytes.Equal(cie.InitialInstructions, initial) {
+			t.Fatalf("initial instructions of the CIE changed to %v", cie.InitialInstructions)
+		}
+	}
+}
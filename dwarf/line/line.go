@@ -0,0 +1,136 @@
+// Package line indexes the DWARF line tables of a program,
+// mapping instruction addresses to source positions.
+package line
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Entry is a row of a line table: the source position of the
+// instructions from Address up to the address of the next entry.
+type Entry struct {
+	Address uint64
+	File    string
+	Line    int
+	// Set for the first instruction of a statement, the
+	// instructions a debugger should stop at when stepping.
+	IsStmt bool
+	// Set for the entry ending a sequence of instructions,
+	// which doesn't describe the instructions at its address.
+	EndSequence bool
+}
+
+// Index holds the rows of all the line tables of a program sorted by
+// address, so that the position of an address is found by binary
+// search rather than by running the line number programs.
+type Index struct {
+	entries []Entry
+}
+
+// New builds the index of the line tables of all the compilation
+// units of data.
+func New(data *dwarf.Data) (*Index, error) {
+	idx := new(Index)
+	r := data.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		if err := idx.add(data, cu); err != nil {
+			return nil, err
+		}
+		r.SkipChildren()
+	}
+
+	// Rows at the same address are kept in order, after the
+	// end of any sequence stopping there, so that the last one
+	// is the one describing the address.
+	sort.Stable(byAddress(idx.entries))
+	return idx, nil
+}
+
+type byAddress []Entry
+
+func (s byAddress) Len() int      { return len(s) }
+func (s byAddress) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byAddress) Less(i, j int) bool {
+	if s[i].Address != s[j].Address {
+		return s[i].Address < s[j].Address
+	}
+	return s[i].EndSequence && !s[j].EndSequence
+}
+
+// Adds the rows of the line table of the compilation unit cu.
+func (idx *Index) add(data *dwarf.Data, cu *dwarf.Entry) error {
+	lr, err := data.LineReader(cu)
+	if err != nil {
+		return fmt.Errorf("could not read line table of %s: %s", cu.Val(dwarf.AttrName), err)
+	}
+	if lr == nil {
+		return nil
+	}
+	var le dwarf.LineEntry
+	for {
+		if err := lr.Next(&le); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("could not read line table of %s: %s", cu.Val(dwarf.AttrName), err)
+		}
+		e := Entry{Address: le.Address, Line: le.Line, IsStmt: le.IsStmt, EndSequence: le.EndSequence}
+		if le.File != nil {
+			e.File = le.File.Name
+		}
+		idx.entries = append(idx.entries, e)
+	}
+}
+
+// Returns the entry describing the instruction at pc, and
+// false if pc isn't covered by any line table.
+func (idx *Index) Lookup(pc uint64) (Entry, bool) {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].Address > pc
+	})
+	if i == 0 || idx.entries[i-1].EndSequence {
+		return Entry{}, false
+	}
+	return idx.entries[i-1], true
+}
+
+// Returns the addresses of the statements starting where the
+// instructions of file:line are entered, in increasing order. A line
+// may be entered at several places, e.g. the header of a loop, at its
+// start and at the condition checked after the body, or a line whose
+// code was inlined into several functions.
+func (idx *Index) AllPCsForFileLine(file string, line int) []uint64 {
+	var pcs []uint64
+	for i, e := range idx.entries {
+		if e.EndSequence || !e.IsStmt || e.Line != line || e.File != file {
+			continue
+		}
+		// Statements following one another on the line.
+		if i > 0 {
+			if prev := idx.entries[i-1]; !prev.EndSequence && prev.Line == line && prev.File == file {
+				continue
+			}
+		}
+		pcs = append(pcs, e.Address)
+	}
+	return pcs
+}
+
+// Returns the number of entries of the index.
+func (idx *Index) Len() int {
+	return len(idx.entries)
+}
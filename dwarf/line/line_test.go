@@ -0,0 +1,123 @@
+package line_test
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/derekparker/delve/dwarf/line"
+)
+
+func TestLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "testprog")
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", exe, "../../_fixtures/testprog.go").Run(); err != nil {
+		t.Fatal("could not compile testprog:", err)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := line.New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pclndat, err := f.Section(".gopclntab").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	symtab, err := gosym.NewTable(nil, gosym.NewLineTable(pclndat, f.Section(".text").Addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every instruction of main.main maps to the same line as in
+	// the Go symbol table, up to the padding after the function.
+	fn := symtab.LookupFunc("main.main")
+	pc := fn.Entry
+	for ; pc < fn.End; pc++ {
+		e, ok := idx.Lookup(pc)
+		if !ok {
+			break
+		}
+		file, l, _ := symtab.PCToLine(pc)
+		if e.File != file || e.Line != l {
+			t.Fatalf("expected %s:%d at %#x, got %s:%d", file, l, pc, e.File, e.Line)
+		}
+	}
+	if pc == fn.Entry {
+		t.Fatalf("no entry for main.main at %#x", pc)
+	}
+	if e, ok := idx.Lookup(fn.Entry); !ok || !e.IsStmt || e.Address != fn.Entry {
+		t.Fatalf("expected a statement at the entry of main.main, got %#v", e)
+	}
+	if _, ok := idx.Lookup(0); ok {
+		t.Fatal("expected no entry for address 0")
+	}
+}
+
+func TestAllPCsForFileLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "testnextprog")
+	src, err := filepath.Abs("../../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", exe, src).Run(); err != nil {
+		t.Fatal("could not compile testnextprog:", err)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := line.New(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The header of the loop of testnext is entered before the
+	// first iteration, and after each.
+	pcs := idx.AllPCsForFileLine(src, 23)
+	if len(pcs) < 2 {
+		t.Fatalf("expected several addresses for the loop header, got %#x", pcs)
+	}
+	for i, pc := range pcs {
+		if e, ok := idx.Lookup(pc); !ok || !e.IsStmt || e.Line != 23 {
+			t.Fatalf("expected a statement of line 23 at %#x, got %#v", pc, e)
+		}
+		if i > 0 && pcs[i-1] >= pc {
+			t.Fatalf("expected increasing addresses, got %#x", pcs)
+		}
+	}
+	if pcs := idx.AllPCsForFileLine(src, 24); len(pcs) != 1 {
+		t.Fatalf("expected a single address for the loop body, got %#x", pcs)
+	}
+	if pcs := idx.AllPCsForFileLine(src, 1); len(pcs) != 0 {
+		t.Fatalf("expected no address for the package clause, got %#x", pcs)
+	}
+}
@@ -0,0 +1,180 @@
+// Package loclist reads the DWARF location lists describing variables
+// whose location changes along their function, as those of optimized
+// programs and the arguments passed in registers.
+package loclist
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/derekparker/delve/dwarf/util"
+)
+
+// The kinds of entries of DWARF 5 location lists.
+const (
+	lleEndOfList       = 0x0
+	lleBaseAddressx    = 0x1
+	lleStartxEndx      = 0x2
+	lleStartxLength    = 0x3
+	lleOffsetPair      = 0x4
+	lleDefaultLocation = 0x5
+	lleBaseAddress     = 0x6
+	lleStartEnd        = 0x7
+	lleStartLength     = 0x8
+)
+
+// Reader finds the location expressions in the location lists of a
+// program, in the .debug_loclists section of DWARF 5 or the .debug_loc
+// section of earlier versions.
+type Reader struct {
+	loc      []byte
+	loclists []byte
+	addr     []byte // The .debug_addr section, indexed by DWARF 5 lists
+	ptrSize  int
+}
+
+// New returns a reader of the location lists in the given sections,
+// any of which may be missing.
+func New(loc, loclists, addr []byte, ptrSize int) *Reader {
+	return &Reader{loc: loc, loclists: loclists, addr: addr, ptrSize: ptrSize}
+}
+
+// Find returns the location expression valid at pc of the list at off,
+// nil when the variable has no location there. base is the base
+// address of the compilation unit of the variable, addrBase the offset
+// of its addresses in .debug_addr.
+func (r *Reader) Find(off int64, base, addrBase, pc uint64) ([]byte, error) {
+	if len(r.loclists) > 0 {
+		return r.find5(off, base, addrBase, pc)
+	}
+	return r.find4(off, base, pc)
+}
+
+func (r *Reader) find4(off int64, base, pc uint64) ([]byte, error) {
+	if off < 0 || off >= int64(len(r.loc)) {
+		return nil, fmt.Errorf("invalid location list offset %#x", off)
+	}
+	buf := bytes.NewBuffer(r.loc[off:])
+	for {
+		begin, err := r.readAddr(buf)
+		if err != nil {
+			return nil, err
+		}
+		end, err := r.readAddr(buf)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case begin == 0 && end == 0:
+			return nil, nil
+		case begin == r.maxAddr():
+			base = end
+			continue
+		}
+		if buf.Len() < 2 {
+			return nil, fmt.Errorf("truncated location list")
+		}
+		expr := buf.Next(int(binary.LittleEndian.Uint16(buf.Next(2))))
+		if base+begin <= pc && pc < base+end {
+			return expr, nil
+		}
+	}
+}
+
+func (r *Reader) find5(off int64, base, addrBase, pc uint64) ([]byte, error) {
+	if off < 0 || off >= int64(len(r.loclists)) {
+		return nil, fmt.Errorf("invalid location list offset %#x", off)
+	}
+	buf := bytes.NewBuffer(r.loclists[off:])
+	for {
+		kind, err := buf.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated location list")
+		}
+
+		var begin, end uint64
+		switch kind {
+		case lleEndOfList:
+			return nil, nil
+		case lleBaseAddressx:
+			idx, _ := util.DecodeULEB128(buf)
+			if base, err = r.indexedAddr(addrBase, idx); err != nil {
+				return nil, err
+			}
+			continue
+		case lleBaseAddress:
+			if base, err = r.readAddr(buf); err != nil {
+				return nil, err
+			}
+			continue
+		case lleStartxEndx, lleStartxLength:
+			idx, _ := util.DecodeULEB128(buf)
+			if begin, err = r.indexedAddr(addrBase, idx); err != nil {
+				return nil, err
+			}
+			n, _ := util.DecodeULEB128(buf)
+			if kind == lleStartxEndx {
+				if end, err = r.indexedAddr(addrBase, n); err != nil {
+					return nil, err
+				}
+			} else {
+				end = begin + n
+			}
+		case lleOffsetPair:
+			begin, _ = util.DecodeULEB128(buf)
+			end, _ = util.DecodeULEB128(buf)
+			begin, end = base+begin, base+end
+		case lleDefaultLocation:
+			begin, end = 0, ^uint64(0)
+		case lleStartEnd, lleStartLength:
+			if begin, err = r.readAddr(buf); err != nil {
+				return nil, err
+			}
+			if kind == lleStartEnd {
+				if end, err = r.readAddr(buf); err != nil {
+					return nil, err
+				}
+			} else {
+				n, _ := util.DecodeULEB128(buf)
+				end = begin + n
+			}
+		default:
+			return nil, fmt.Errorf("unknown location list entry %#x", kind)
+		}
+
+		n, _ := util.DecodeULEB128(buf)
+		expr := buf.Next(int(n))
+		if begin <= pc && pc < end {
+			return expr, nil
+		}
+	}
+}
+
+// Returns the address at index idx of the addresses of a compilation
+// unit in .debug_addr.
+func (r *Reader) indexedAddr(addrBase, idx uint64) (uint64, error) {
+	off := addrBase + idx*uint64(r.ptrSize)
+	if off+uint64(r.ptrSize) > uint64(len(r.addr)) {
+		return 0, fmt.Errorf("invalid address index %d", idx)
+	}
+	return r.readAddr(bytes.NewBuffer(r.addr[off:]))
+}
+
+func (r *Reader) readAddr(buf *bytes.Buffer) (uint64, error) {
+	b := buf.Next(r.ptrSize)
+	if len(b) < r.ptrSize {
+		return 0, fmt.Errorf("truncated location list")
+	}
+	if r.ptrSize == 4 {
+		return uint64(binary.LittleEndian.Uint32(b)), nil
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (r *Reader) maxAddr() uint64 {
+	if r.ptrSize == 4 {
+		return 0xffffffff
+	}
+	return ^uint64(0)
+}
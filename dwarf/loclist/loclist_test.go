@@ -0,0 +1,52 @@
+package loclist
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFind5(t *testing.T) {
+	addr := []byte{
+		0, 0, 0, 0, 0, 0, 0, 0, // Another unit
+		0x00, 0x10, 0, 0, 0, 0, 0, 0,
+	}
+	lists := []byte{
+		lleBaseAddressx, 0x0,
+		lleOffsetPair, 0x0, 0x8, 1, 0x50, // DW_OP_reg0
+		lleOffsetPair, 0x8, 0x20, 1, 0x9c, // DW_OP_call_frame_cfa
+		lleEndOfList,
+	}
+	r := New(nil, lists, addr, 8)
+
+	for _, tc := range []struct {
+		pc   uint64
+		expr []byte
+	}{
+		{0x1000, []byte{0x50}},
+		{0x1008, []byte{0x9c}},
+		{0x1020, nil},
+	} {
+		expr, err := r.Find(0, 0, 8, tc.pc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expr, tc.expr) {
+			t.Fatalf("expected %v at %#x, got %v", tc.expr, tc.pc, expr)
+		}
+	}
+}
+
+func TestFind4(t *testing.T) {
+	loc := []byte{
+		0x0, 0, 0, 0, 0, 0, 0, 0, 0x8, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0x50,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	r := New(loc, nil, nil, 8)
+
+	if expr, err := r.Find(0, 0x1000, 0, 0x1004); err != nil || !bytes.Equal(expr, []byte{0x50}) {
+		t.Fatalf("expected DW_OP_reg0, got %v %v", expr, err)
+	}
+	if expr, err := r.Find(0, 0x1000, 0, 0x1008); err != nil || expr != nil {
+		t.Fatalf("expected no location, got %v %v", expr, err)
+	}
+}
@@ -3,74 +3,400 @@ package op
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"unsafe"
 
 	"github.com/derekparker/delve/dwarf/util"
 )
 
+// Size of the operand of DW_OP_addr. Programs are debugged by a
+// debugger built for the same architecture, so it is our own.
+const ptrsize = int(unsafe.Sizeof(uintptr(0)))
+
 const (
 	DW_OP_addr           = 0x3
-	DW_OP_call_frame_cfa = 0x9c
-	DW_OP_plus           = 0x22
+	DW_OP_const1u        = 0x08
+	DW_OP_const1s        = 0x09
+	DW_OP_const2u        = 0x0a
+	DW_OP_const2s        = 0x0b
+	DW_OP_const4u        = 0x0c
+	DW_OP_const4s        = 0x0d
+	DW_OP_const8u        = 0x0e
+	DW_OP_const8s        = 0x0f
+	DW_OP_constu         = 0x10
 	DW_OP_consts         = 0x11
+	DW_OP_dup            = 0x12
+	DW_OP_drop           = 0x13
+	DW_OP_swap           = 0x16
+	DW_OP_minus          = 0x1c
+	DW_OP_plus           = 0x22
 	DW_OP_plus_uconsts   = 0x23
+	DW_OP_lit0           = 0x30
+	DW_OP_lit31          = 0x4f
+	DW_OP_reg0           = 0x50
+	DW_OP_reg31          = 0x6f
+	DW_OP_breg0          = 0x70
+	DW_OP_breg31         = 0x8f
+	DW_OP_regx           = 0x90
+	DW_OP_fbreg          = 0x91
+	DW_OP_bregx          = 0x92
+	DW_OP_piece          = 0x93
+	DW_OP_nop            = 0x96
+	DW_OP_call_frame_cfa = 0x9c
+	DW_OP_stack_value    = 0x9f
+)
+
+// Returned for values the compiler didn't keep, described by empty
+// location expressions or pieces.
+var ErrOptimizedOut = errors.New("value optimized out")
+
+// DwarfRegisters holds what location expressions are evaluated against.
+type DwarfRegisters struct {
+	// Added to the addresses of DW_OP_addr, the difference between the
+	// address the program was loaded at and the one it was linked at.
+	StaticBase uint64
+	CFA        int64
+	FrameBase  int64 // The value of the DW_AT_frame_base of the function
+	// Returns the value of the register with the given DWARF
+	// number, nil when registers are not known, as for the
+	// frames of callers.
+	Reg func(num uint64) (uint64, bool)
+}
+
+// Where a piece of a value is kept.
+type PieceKind int
+
+const (
+	AddrPiece PieceKind = iota // In memory, at Addr
+	RegPiece                   // In register RegNum, Value holds its value
+	ImmPiece                   // Nowhere, the piece is Value, see DW_OP_stack_value
 )
 
-type stackfn func(*bytes.Buffer, []int64, int64) ([]int64, error)
+// A part of a value whose location is not a single address.
+type Piece struct {
+	Size   int // In bytes, 0 for the whole value
+	Kind   PieceKind
+	Addr   int64
+	RegNum uint64
+	Value  uint64
+}
+
+type context struct {
+	buf    *bytes.Buffer
+	stack  []int64
+	pieces []Piece
+	loc    *Piece // Set by the operations naming a register or a value
+	regs   DwarfRegisters
+}
+
+type stackfn func(*context, byte) error
 
 var oplut = map[byte]stackfn{
 	DW_OP_call_frame_cfa: callframecfa,
 	DW_OP_plus:           plus,
+	DW_OP_minus:          minus,
 	DW_OP_consts:         consts,
+	DW_OP_constu:         constu,
+	DW_OP_const1u:        constn,
+	DW_OP_const1s:        constn,
+	DW_OP_const2u:        constn,
+	DW_OP_const2s:        constn,
+	DW_OP_const4u:        constn,
+	DW_OP_const4s:        constn,
+	DW_OP_const8u:        constn,
+	DW_OP_const8s:        constn,
+	DW_OP_dup:            dup,
+	DW_OP_drop:           drop,
+	DW_OP_swap:           swap,
 	DW_OP_addr:           addr,
 	DW_OP_plus_uconsts:   plusuconsts,
+	DW_OP_regx:           regx,
+	DW_OP_fbreg:          fbreg,
+	DW_OP_bregx:          bregx,
+	DW_OP_piece:          piece,
+	DW_OP_nop:            nop,
+	DW_OP_stack_value:    stackvalue,
 }
 
+func init() {
+	for op := byte(DW_OP_lit0); op <= DW_OP_lit31; op++ {
+		oplut[op] = lit
+	}
+	for op := byte(DW_OP_reg0); op <= DW_OP_reg31; op++ {
+		oplut[op] = reg
+	}
+	for op := byte(DW_OP_breg0); op <= DW_OP_breg31; op++ {
+		oplut[op] = breg
+	}
+}
+
+// Executes the location expression instructions, returning the
+// address of the value it describes, with cfa as both the canonical
+// frame address and the frame base. Values which are not in memory
+// are reported as errors, see ExecuteLocation.
 func ExecuteStackProgram(cfa int64, instructions []byte) (int64, error) {
-	stack := make([]int64, 0, 3)
-	buf := bytes.NewBuffer(instructions)
+	addr, pieces, err := ExecuteLocation(instructions, DwarfRegisters{CFA: cfa, FrameBase: cfa})
+	if err != nil {
+		return 0, err
+	}
+	if pieces != nil {
+		return 0, fmt.Errorf("value is not in memory")
+	}
+	return addr, nil
+}
+
+// Executes the location expression instructions against regs. Values
+// in memory as a whole are described by their address, others, kept
+// in registers or split across several locations, by their pieces.
+func ExecuteLocation(instructions []byte, regs DwarfRegisters) (int64, []Piece, error) {
+	if len(instructions) == 0 {
+		return 0, nil, ErrOptimizedOut
+	}
+	ctx := &context{buf: bytes.NewBuffer(instructions), stack: make([]int64, 0, 3), regs: regs}
 
-	for ocfaode, err := buf.ReadByte(); err == nil; ocfaode, err = buf.ReadByte() {
-		fn, ok := oplut[ocfaode]
+	for opcode, err := ctx.buf.ReadByte(); err == nil; opcode, err = ctx.buf.ReadByte() {
+		fn, ok := oplut[opcode]
 		if !ok {
-			return 0, fmt.Errorf("invalid instruction %#v", ocfaode)
+			return 0, nil, fmt.Errorf("invalid instruction %#v", opcode)
+		}
+
+		if err := fn(ctx, opcode); err != nil {
+			return 0, nil, err
 		}
+	}
+
+	if ctx.pieces != nil {
+		return 0, ctx.pieces, nil
+	}
+	if ctx.loc != nil {
+		return 0, []Piece{*ctx.loc}, nil
+	}
+	if len(ctx.stack) == 0 {
+		return 0, nil, fmt.Errorf("empty stack")
+	}
+	return ctx.stack[len(ctx.stack)-1], nil, nil
+}
+
+// Pops the top of the stack.
+func (ctx *context) pop() (int64, error) {
+	if len(ctx.stack) == 0 {
+		return 0, fmt.Errorf("stack underflow")
+	}
+	n := ctx.stack[len(ctx.stack)-1]
+	ctx.stack = ctx.stack[:len(ctx.stack)-1]
+	return n, nil
+}
+
+// Returns the value of register num, which must be known.
+func (ctx *context) reg(num uint64) (uint64, error) {
+	if ctx.regs.Reg == nil {
+		return 0, fmt.Errorf("register %d is not known", num)
+	}
+	val, ok := ctx.regs.Reg(num)
+	if !ok {
+		return 0, fmt.Errorf("register %d is not known", num)
+	}
+	return val, nil
+}
+
+func callframecfa(ctx *context, opcode byte) error {
+	ctx.stack = append(ctx.stack, ctx.regs.CFA)
+	return nil
+}
 
-		stack, err = fn(buf, stack, cfa)
-		if err != nil {
-			return 0, err
+func addr(ctx *context, opcode byte) error {
+	base := int64(ctx.regs.StaticBase)
+	if ptrsize == 4 {
+		ctx.stack = append(ctx.stack, base+int64(binary.LittleEndian.Uint32(ctx.buf.Next(4))))
+		return nil
+	}
+	ctx.stack = append(ctx.stack, base+int64(binary.LittleEndian.Uint64(ctx.buf.Next(8))))
+	return nil
+}
+
+func plus(ctx *context, opcode byte) error {
+	b, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	a, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	ctx.stack = append(ctx.stack, a+b)
+	return nil
+}
+
+func minus(ctx *context, opcode byte) error {
+	b, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	a, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	ctx.stack = append(ctx.stack, a-b)
+	return nil
+}
+
+func plusuconsts(ctx *context, opcode byte) error {
+	a, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	num, _ := util.DecodeULEB128(ctx.buf)
+	ctx.stack = append(ctx.stack, a+int64(num))
+	return nil
+}
+
+func consts(ctx *context, opcode byte) error {
+	num, _ := util.DecodeSLEB128(ctx.buf)
+	ctx.stack = append(ctx.stack, num)
+	return nil
+}
+
+func constu(ctx *context, opcode byte) error {
+	num, _ := util.DecodeULEB128(ctx.buf)
+	ctx.stack = append(ctx.stack, int64(num))
+	return nil
+}
+
+// The constants of fixed size, signed for the odd opcodes.
+func constn(ctx *context, opcode byte) error {
+	size := 1 << uint((opcode-DW_OP_const1u)/2)
+	b := ctx.buf.Next(size)
+	if len(b) < size {
+		return fmt.Errorf("truncated constant")
+	}
+	var n int64
+	switch size {
+	case 1:
+		n = int64(b[0])
+		if opcode == DW_OP_const1s {
+			n = int64(int8(b[0]))
+		}
+	case 2:
+		n = int64(binary.LittleEndian.Uint16(b))
+		if opcode == DW_OP_const2s {
+			n = int64(int16(n))
 		}
+	case 4:
+		n = int64(binary.LittleEndian.Uint32(b))
+		if opcode == DW_OP_const4s {
+			n = int64(int32(n))
+		}
+	case 8:
+		n = int64(binary.LittleEndian.Uint64(b))
 	}
+	ctx.stack = append(ctx.stack, n)
+	return nil
+}
 
-	return stack[len(stack)-1], nil
+func lit(ctx *context, opcode byte) error {
+	ctx.stack = append(ctx.stack, int64(opcode-DW_OP_lit0))
+	return nil
 }
 
-func callframecfa(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	return append(stack, int64(cfa)), nil
+func dup(ctx *context, opcode byte) error {
+	if len(ctx.stack) == 0 {
+		return fmt.Errorf("stack underflow")
+	}
+	ctx.stack = append(ctx.stack, ctx.stack[len(ctx.stack)-1])
+	return nil
+}
+
+func drop(ctx *context, opcode byte) error {
+	_, err := ctx.pop()
+	return err
 }
 
-func addr(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	return append(stack, int64(binary.LittleEndian.Uint64(buf.Next(8)))), nil
+func swap(ctx *context, opcode byte) error {
+	if len(ctx.stack) < 2 {
+		return fmt.Errorf("stack underflow")
+	}
+	n := len(ctx.stack)
+	ctx.stack[n-1], ctx.stack[n-2] = ctx.stack[n-2], ctx.stack[n-1]
+	return nil
 }
 
-func plus(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	var (
-		slen   = len(stack)
-		digits = stack[slen-2 : slen]
-		st     = stack[:slen-2]
-	)
+// The value is in the register, rather than at an address.
+func reg(ctx *context, opcode byte) error {
+	return ctx.inRegister(uint64(opcode - DW_OP_reg0))
+}
 
-	return append(st, digits[0]+digits[1]), nil
+func regx(ctx *context, opcode byte) error {
+	num, _ := util.DecodeULEB128(ctx.buf)
+	return ctx.inRegister(num)
 }
 
-func plusuconsts(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	slen := len(stack)
-	num, _ := util.DecodeULEB128(buf)
-	stack[slen-1] = stack[slen-1] + int64(num)
-	return stack, nil
+func (ctx *context) inRegister(num uint64) error {
+	val, err := ctx.reg(num)
+	if err != nil {
+		return err
+	}
+	ctx.loc = &Piece{Kind: RegPiece, RegNum: num, Value: val}
+	return nil
+}
+
+// Pushes the address at an offset from the value of a register.
+func breg(ctx *context, opcode byte) error {
+	return ctx.regOffset(uint64(opcode - DW_OP_breg0))
+}
+
+func bregx(ctx *context, opcode byte) error {
+	num, _ := util.DecodeULEB128(ctx.buf)
+	return ctx.regOffset(num)
+}
+
+func (ctx *context) regOffset(num uint64) error {
+	off, _ := util.DecodeSLEB128(ctx.buf)
+	val, err := ctx.reg(num)
+	if err != nil {
+		return err
+	}
+	ctx.stack = append(ctx.stack, int64(val)+off)
+	return nil
+}
+
+func fbreg(ctx *context, opcode byte) error {
+	off, _ := util.DecodeSLEB128(ctx.buf)
+	ctx.stack = append(ctx.stack, ctx.regs.FrameBase+off)
+	return nil
+}
+
+// The value on the stack is the value described, not its address.
+func stackvalue(ctx *context, opcode byte) error {
+	val, err := ctx.pop()
+	if err != nil {
+		return err
+	}
+	ctx.loc = &Piece{Kind: ImmPiece, Value: uint64(val)}
+	return nil
+}
+
+// Ends the description of a part of the value, of the given size, in
+// the register or value named since the previous piece, or at the
+// address on the stack.
+func piece(ctx *context, opcode byte) error {
+	size, _ := util.DecodeULEB128(ctx.buf)
+	var p Piece
+	switch {
+	case ctx.loc != nil:
+		p = *ctx.loc
+	case len(ctx.stack) > 0:
+		a, _ := ctx.pop()
+		p = Piece{Kind: AddrPiece, Addr: a}
+	default:
+		return ErrOptimizedOut
+	}
+	p.Size = int(size)
+	ctx.pieces = append(ctx.pieces, p)
+	ctx.loc = nil
+	return nil
 }
 
-func consts(buf *bytes.Buffer, stack []int64, cfa int64) ([]int64, error) {
-	num, _ := util.DecodeSLEB128(buf)
-	return append(stack, num), nil
+func nop(ctx *context, opcode byte) error {
+	return nil
 }
@@ -1,6 +1,9 @@
 package op
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestExecuteStackProgram(t *testing.T) {
 	var (
@@ -16,3 +19,53 @@ func TestExecuteStackProgram(t *testing.T) {
 		t.Fatalf("actual %d != expected %d", actual, expected)
 	}
 }
+
+func TestExecuteLocation(t *testing.T) {
+	regs := DwarfRegisters{
+		CFA:       0x1000,
+		FrameBase: 0x1000,
+		Reg: func(num uint64) (uint64, bool) {
+			switch num {
+			case 0:
+				return 0xaa, true
+			case 3:
+				return 0xbb, true
+			}
+			return 0, false
+		},
+	}
+
+	// DW_OP_fbreg -16
+	addr, pieces, err := ExecuteLocation([]byte{DW_OP_fbreg, 0x70}, regs)
+	if err != nil || pieces != nil || addr != 0x1000-16 {
+		t.Fatalf("expected address %#x, got %#x %v %v", 0x1000-16, addr, pieces, err)
+	}
+
+	// DW_OP_reg3, DW_OP_piece 8, DW_OP_call_frame_cfa, DW_OP_piece 8
+	_, pieces, err = ExecuteLocation([]byte{DW_OP_reg0 + 3, DW_OP_piece, 8, DW_OP_call_frame_cfa, DW_OP_piece, 8}, regs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Piece{{Size: 8, Kind: RegPiece, RegNum: 3, Value: 0xbb}, {Size: 8, Kind: AddrPiece, Addr: 0x1000}}
+	if !reflect.DeepEqual(pieces, expected) {
+		t.Fatalf("expected pieces %v, got %v", expected, pieces)
+	}
+
+	// DW_OP_reg0
+	_, pieces, err = ExecuteLocation([]byte{DW_OP_reg0}, regs)
+	if err != nil || len(pieces) != 1 || pieces[0].Kind != RegPiece || pieces[0].Value != 0xaa {
+		t.Fatalf("expected the value of register 0, got %v %v", pieces, err)
+	}
+
+	if _, err := ExecuteStackProgram(0, []byte{DW_OP_reg0}); err == nil {
+		t.Fatal("expected an error for a value in a register without registers")
+	}
+
+	// DW_OP_addr 0x2000, relocated
+	instr := append([]byte{DW_OP_addr}, make([]byte, ptrsize)...)
+	instr[2] = 0x20
+	addr, _, err = ExecuteLocation(instr, DwarfRegisters{StaticBase: 0x10000})
+	if err != nil || addr != 0x12000 {
+		t.Fatalf("expected address %#x, got %#x %v", 0x12000, addr, err)
+	}
+}
@@ -0,0 +1,158 @@
+package reader
+
+import (
+	"debug/dwarf"
+	"sort"
+)
+
+// Index maps the names of the functions, package variables and types
+// of a program to the offsets of their DIEs, and addresses to the
+// compilation units and functions covering them. Building it only
+// reads the DIEs of compilation units and of their direct children,
+// the DIEs within functions and types are parsed when first read.
+type Index struct {
+	funcs map[string]dwarf.Offset
+	vars  map[string]dwarf.Offset
+	types map[string][]dwarf.Offset
+	units []pcRange // Sorted by low address
+	fns   []pcRange // Sorted by low address
+}
+
+// The addresses from low up to high, excluded, covered by the DIE at off.
+type pcRange struct {
+	low, high uint64
+	off       dwarf.Offset
+}
+
+// NewIndex builds the index of the compilation units of data.
+func NewIndex(data *dwarf.Data) (*Index, error) {
+	idx := &Index{
+		funcs: make(map[string]dwarf.Offset),
+		vars:  make(map[string]dwarf.Offset),
+		types: make(map[string][]dwarf.Offset),
+	}
+	r := data.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		idx.units = appendRanges(idx.units, data, cu)
+		if err := idx.addUnit(data, r); err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(byLow(idx.units))
+	sort.Sort(byLow(idx.fns))
+	return idx, nil
+}
+
+// Adds the children of the compilation unit r was just positioned in.
+func (idx *Index) addUnit(data *dwarf.Data, r *dwarf.Reader) error {
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil || entry.Tag == 0 {
+			return nil
+		}
+		if entry.Children {
+			r.SkipChildren()
+		}
+
+		name, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case entry.Tag == dwarf.TagSubprogram:
+			idx.funcs[name] = entry.Offset
+			idx.fns = appendRanges(idx.fns, data, entry)
+		case entry.Tag == dwarf.TagVariable:
+			idx.vars[name] = entry.Offset
+		case isType(entry.Tag):
+			idx.types[name] = append(idx.types[name], entry.Offset)
+		}
+	}
+}
+
+func appendRanges(rs []pcRange, data *dwarf.Data, entry *dwarf.Entry) []pcRange {
+	ranges, err := data.Ranges(entry)
+	if err != nil {
+		return rs
+	}
+	for _, r := range ranges {
+		rs = append(rs, pcRange{low: r[0], high: r[1], off: entry.Offset})
+	}
+	return rs
+}
+
+// Returns the offset of the DIE of the named function.
+func (idx *Index) Function(name string) (dwarf.Offset, bool) {
+	off, ok := idx.funcs[name]
+	return off, ok
+}
+
+// Returns the offset of the DIE of the named package variable.
+func (idx *Index) Variable(name string) (dwarf.Offset, bool) {
+	off, ok := idx.vars[name]
+	return off, ok
+}
+
+// Returns the offsets of the DIEs of the types with the given name,
+// in the order they appear in the debug info. A name may be given
+// to several types, e.g. a typedef and the struct it names.
+func (idx *Index) Types(name string) []dwarf.Offset {
+	return idx.types[name]
+}
+
+// Returns the names of all the types, sorted.
+func (idx *Index) TypeNames() []string {
+	names := make([]string, 0, len(idx.types))
+	for n := range idx.types {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the offset of the DIE of the function covering pc.
+func (idx *Index) FunctionAt(pc uint64) (dwarf.Offset, bool) {
+	return lookup(idx.fns, pc)
+}
+
+// Returns the offset of the DIE of the compilation unit covering pc.
+func (idx *Index) UnitAt(pc uint64) (dwarf.Offset, bool) {
+	return lookup(idx.units, pc)
+}
+
+func lookup(rs []pcRange, pc uint64) (dwarf.Offset, bool) {
+	i := sort.Search(len(rs), func(i int) bool { return rs[i].low > pc })
+	if i == 0 || pc >= rs[i-1].high {
+		return 0, false
+	}
+	return rs[i-1].off, true
+}
+
+func isType(tag dwarf.Tag) bool {
+	switch tag {
+	case dwarf.TagBaseType, dwarf.TagPointerType, dwarf.TagStructType, dwarf.TagTypedef,
+		dwarf.TagArrayType, dwarf.TagSubroutineType, dwarf.TagUnspecifiedType:
+		return true
+	}
+	return false
+}
+
+type byLow []pcRange
+
+func (r byLow) Len() int           { return len(r) }
+func (r byLow) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byLow) Less(i, j int) bool { return r[i].low < r[j].low }
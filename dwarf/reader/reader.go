@@ -8,11 +8,18 @@ import (
 type Reader struct {
 	*dwarf.Reader
 	depth int
+	index *Index
 }
 
 // New returns a reader for the specified dwarf data.
 func New(data *dwarf.Data) *Reader {
-	return &Reader{data.Reader(), 0}
+	return &Reader{data.Reader(), 0, nil}
+}
+
+// NewIndexed returns a reader for the specified dwarf data, which
+// looks functions up in idx rather than reading every entry.
+func NewIndexed(data *dwarf.Data, idx *Index) *Reader {
+	return &Reader{data.Reader(), 0, idx}
 }
 
 // Seek moves the reader to an arbitrary offset.
@@ -32,6 +39,15 @@ func (reader *Reader) SeekToEntry(entry *dwarf.Entry) error {
 // SeekToFunctionEntry moves the reader to the function that includes the
 // specified program counter.
 func (reader *Reader) SeekToFunction(pc uint64) (*dwarf.Entry, error) {
+	if reader.index != nil {
+		off, ok := reader.index.FunctionAt(pc)
+		if !ok {
+			return nil, fmt.Errorf("unable to find function context")
+		}
+		reader.Seek(off)
+		return reader.Next()
+	}
+
 	reader.Seek(0)
 	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
 		if err != nil {
@@ -47,8 +63,14 @@ func (reader *Reader) SeekToFunction(pc uint64) (*dwarf.Entry, error) {
 			continue
 		}
 
-		highpc, ok := entry.Val(dwarf.AttrHighpc).(uint64)
-		if !ok {
+		// Since DWARF 4 the high pc may be the size of the function.
+		var highpc uint64
+		switch v := entry.Val(dwarf.AttrHighpc).(type) {
+		case uint64:
+			highpc = v
+		case int64:
+			highpc = lowpc + uint64(v)
+		default:
 			continue
 		}
 
@@ -60,6 +82,39 @@ func (reader *Reader) SeekToFunction(pc uint64) (*dwarf.Entry, error) {
 	return nil, fmt.Errorf("unable to find function context")
 }
 
+// SeekToFunctionNamed moves the reader to the function with the given
+// name, as the symbol table names it, e.g. main.(*T).String. Its
+// arguments and local variables can then be walked with
+// NextScopeVariable.
+func (reader *Reader) SeekToFunctionNamed(name string) (*dwarf.Entry, error) {
+	if reader.index != nil {
+		off, ok := reader.index.Function(name)
+		if !ok {
+			return nil, fmt.Errorf("could not find function %s", name)
+		}
+		reader.Seek(off)
+		return reader.Next()
+	}
+
+	reader.Seek(0)
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		if n, ok := entry.Val(dwarf.AttrName).(string); ok && n == name {
+			return entry, nil
+		}
+		reader.SkipChildren()
+	}
+
+	return nil, fmt.Errorf("could not find function %s", name)
+}
+
 // SeekToType moves the reader to the type specified by the entry,
 // optionally resolving typedefs and pointer types. If the reader is set
 // to a struct type the NextMemberVariable call can be used to walk all member data.
@@ -68,7 +123,12 @@ func (reader *Reader) SeekToType(entry *dwarf.Entry, resolveTypedefs bool, resol
 	if !ok {
 		return nil, fmt.Errorf("entry does not have a type attribute")
 	}
+	return reader.SeekToTypeAt(offset, resolveTypedefs, resolvePointerTypes)
+}
 
+// SeekToTypeAt is like SeekToType, for the type whose entry is at
+// offset, such as the dwarf.Offset of a dwarf.Type.
+func (reader *Reader) SeekToTypeAt(offset dwarf.Offset, resolveTypedefs bool, resolvePointerTypes bool) (*dwarf.Entry, error) {
 	// Seek to the first type offset
 	reader.Seek(offset)
 
@@ -86,6 +146,7 @@ func (reader *Reader) SeekToType(entry *dwarf.Entry, resolveTypedefs bool, resol
 			return typeEntry, nil
 		}
 
+		var ok bool
 		offset, ok = typeEntry.Val(dwarf.AttrType).(dwarf.Offset)
 		if !ok {
 			return typeEntry, nil
@@ -97,21 +158,86 @@ func (reader *Reader) SeekToType(entry *dwarf.Entry, resolveTypedefs bool, resol
 	return nil, fmt.Errorf("no type entry found")
 }
 
+// NextChild moves the reader to the next child of the entry it was
+// moved to, e.g. by SeekToFunction or SeekToType, skipping the
+// children of the children, and returns it. Returns nil once past the
+// last child.
+func (reader *Reader) NextChild() (*dwarf.Entry, error) {
+	entry, err := reader.Next()
+	if err != nil || entry == nil {
+		return nil, err
+	}
+
+	// End of the current depth
+	if entry.Tag == 0 {
+		return nil, nil
+	}
+
+	reader.SkipChildren()
+	return entry, nil
+}
+
+// Children returns the children of entry, leaving the reader past
+// the last of them.
+func (reader *Reader) Children(entry *dwarf.Entry) ([]*dwarf.Entry, error) {
+	if !entry.Children {
+		return nil, nil
+	}
+	if err := reader.SeekToEntry(entry); err != nil {
+		return nil, err
+	}
+
+	var children []*dwarf.Entry
+	for child, err := reader.NextChild(); child != nil; child, err = reader.NextChild() {
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
 // NextScopeVariable moves the reader to the next debug entry that describes a local variable and returns the entry.
 func (reader *Reader) NextScopeVariable() (*dwarf.Entry, error) {
-	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+	for entry, err := reader.NextChild(); entry != nil; entry, err = reader.NextChild() {
 		if err != nil {
 			return nil, err
 		}
 
-		// All scope variables will be at the same depth
-		reader.SkipChildren()
+		if entry.Tag == dwarf.TagVariable || entry.Tag == dwarf.TagFormalParameter {
+			return entry, nil
+		}
+	}
+
+	// No more items
+	return nil, nil
+}
+
+// NextScopeVariableAt is like NextScopeVariable, but also descends into the
+// lexical blocks of the function containing pc, so that variables declared
+// in nested scopes which are live at pc are returned as well.
+func (reader *Reader) NextScopeVariableAt(pc uint64) (*dwarf.Entry, error) {
+	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+		if err != nil {
+			return nil, err
+		}
 
 		// End of the current depth
 		if entry.Tag == 0 {
-			break
+			if reader.depth == 0 {
+				break
+			}
+			reader.depth--
+			continue
 		}
 
+		if entry.Tag == dwarf.TagLexDwarfBlock && entry.Children && blockContains(entry, pc) {
+			reader.depth++
+			continue
+		}
+
+		reader.SkipChildren()
+
 		if entry.Tag == dwarf.TagVariable || entry.Tag == dwarf.TagFormalParameter {
 			return entry, nil
 		}
@@ -121,21 +247,31 @@ func (reader *Reader) NextScopeVariable() (*dwarf.Entry, error) {
 	return nil, nil
 }
 
+// Returns true if the lexical block entry covers pc. Blocks described
+// by address ranges rather than low and high pc are assumed to.
+func blockContains(entry *dwarf.Entry, pc uint64) bool {
+	lowpc, ok := entry.Val(dwarf.AttrLowpc).(uint64)
+	if !ok {
+		return true
+	}
+
+	switch highpc := entry.Val(dwarf.AttrHighpc).(type) {
+	case uint64:
+		return lowpc <= pc && pc < highpc
+	case int64:
+		// DWARF 4 encodes high pc as an offset from low pc.
+		return lowpc <= pc && pc < lowpc+uint64(highpc)
+	}
+	return true
+}
+
 // NextMememberVariable moves the reader to the next debug entry that describes a member variable and returns the entry.
 func (reader *Reader) NextMemberVariable() (*dwarf.Entry, error) {
-	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
+	for entry, err := reader.NextChild(); entry != nil; entry, err = reader.NextChild() {
 		if err != nil {
 			return nil, err
 		}
 
-		// All member variables will be at the same depth
-		reader.SkipChildren()
-
-		// End of the current depth
-		if entry.Tag == 0 {
-			break
-		}
-
 		if entry.Tag == dwarf.TagMember {
 			return entry, nil
 		}
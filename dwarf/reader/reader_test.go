@@ -0,0 +1,94 @@
+package reader_test
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/derekparker/delve/dwarf/reader"
+)
+
+func TestTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "testvariables")
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", exe, "../../_fixtures/testvariables.go").Run(); err != nil {
+		t.Fatal("could not compile testvariables:", err)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := reader.NewIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range []*reader.Reader{reader.New(data), reader.NewIndexed(data, idx)} {
+		fn, err := r.SeekToFunctionNamed("main.foobar")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name, _ := fn.Val(dwarf.AttrName).(string); name != "main.foobar" {
+			t.Fatalf("expected main.foobar, got %s", name)
+		}
+
+		var args []string
+		var bar *dwarf.Entry
+		for entry, err := r.NextScopeVariable(); entry != nil; entry, err = r.NextScopeVariable() {
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Tag != dwarf.TagFormalParameter {
+				continue
+			}
+			name, _ := entry.Val(dwarf.AttrName).(string)
+			args = append(args, name)
+			if name == "bar" {
+				bar = entry
+			}
+		}
+		if !reflect.DeepEqual(args, []string{"baz", "bar"}) {
+			t.Fatalf("expected the arguments baz and bar, got %v", args)
+		}
+
+		// The members of main.FooBar, the type of bar.
+		typ, err := r.SeekToTypeAt(bar.Val(dwarf.AttrType).(dwarf.Offset), true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ.Tag != dwarf.TagStructType {
+			t.Fatalf("expected a struct type, got %s", typ.Tag)
+		}
+		children, err := r.Children(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var members []string
+		for _, child := range children {
+			name, _ := child.Val(dwarf.AttrName).(string)
+			members = append(members, name)
+		}
+		if !reflect.DeepEqual(members, []string{"Baz", "Bur"}) {
+			t.Fatalf("expected the members Baz and Bur, got %v", members)
+		}
+
+		if _, err := r.SeekToFunctionNamed("main.nosuchfunction"); err == nil {
+			t.Fatal("expected an error for a missing function")
+		}
+	}
+}
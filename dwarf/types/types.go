@@ -0,0 +1,301 @@
+// Package types models the types of Go programs described by their
+// DWARF debug information. The compiler describes strings, slices,
+// maps, channels and interfaces through the structs and pointers of
+// their runtime representation; a Type tells which Go type they are,
+// so that values are decoded according to their kind rather than by
+// recognizing the runtime structs at each read.
+package types
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// The kinds of Go types.
+type Kind int
+
+const (
+	Invalid Kind = iota // Types the compiler left unspecified
+	Void
+	Bool
+	Int
+	Uint
+	Float
+	Complex
+	String
+	Pointer
+	Array
+	Slice
+	Map
+	Chan
+	Interface
+	Struct
+	Func
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Void:
+		return "void"
+	case Bool:
+		return "bool"
+	case Int:
+		return "int"
+	case Uint:
+		return "uint"
+	case Float:
+		return "float"
+	case Complex:
+		return "complex"
+	case String:
+		return "string"
+	case Pointer:
+		return "pointer"
+	case Array:
+		return "array"
+	case Slice:
+		return "slice"
+	case Map:
+		return "map"
+	case Chan:
+		return "chan"
+	case Interface:
+		return "interface"
+	case Struct:
+		return "struct"
+	case Func:
+		return "func"
+	}
+	return "invalid"
+}
+
+// A Go type. Types link to the types they are made of, forming a
+// graph which has cycles wherever a type refers to itself through
+// pointers.
+type Type struct {
+	Kind Kind
+	Name string // Go syntax, e.g. "main.Foo", "*main.Foo" or "[]int"
+	Size int64
+
+	// The element type of pointers, arrays, slices, maps and
+	// channels, and the key type of maps. Nil when the element of
+	// a pointer is unknown, as for unsafe.Pointer, or the runtime
+	// representation of a map or channel isn't recognized.
+	Elem *Type
+	Key  *Type
+	Len  int64 // Of arrays
+
+	// The fields of structs, and of the runtime representation of
+	// strings, slices and interfaces.
+	Fields []*Field
+	Params []*Type // Of functions, results included
+
+	// The DWARF type it was built from, typedefs resolved.
+	Dwarf dwarf.Type
+}
+
+func (t *Type) String() string {
+	return t.Name
+}
+
+// Returns the field with the given name, nil if there is none.
+func (t *Type) Field(name string) *Field {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// A field of a struct.
+type Field struct {
+	Name   string
+	Offset int64
+	Type   *Type
+}
+
+// Graph holds the Go types built from the DWARF types of a program.
+// Each DWARF type is converted once, types shared by several others
+// are shared in the graph as well.
+type Graph struct {
+	mu    sync.Mutex
+	types map[dwarf.Type]*Type
+}
+
+// Returns an empty graph.
+func NewGraph() *Graph {
+	return &Graph{types: make(map[dwarf.Type]*Type)}
+}
+
+// Returns the type of the graph built from the DWARF type dt,
+// building it first if needed.
+func (g *Graph) Type(dt dwarf.Type) *Type {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.build(dt)
+}
+
+// Returns the type of the graph built from the DWARF type at off in
+// data, as Type.
+func (g *Graph) Load(data *dwarf.Data, off dwarf.Offset) (*Type, error) {
+	dt, err := data.Type(off)
+	if err != nil {
+		return nil, err
+	}
+	return g.Type(dt), nil
+}
+
+func (g *Graph) build(dt dwarf.Type) *Type {
+	if t, ok := g.types[dt]; ok {
+		return t
+	}
+	// Registered before its parts are built, which may refer to it.
+	t := &Type{Name: goName(dt), Size: dt.Size(), Dwarf: resolve(dt)}
+	g.types[dt] = t
+
+	switch u := t.Dwarf.(type) {
+	case *dwarf.VoidType:
+		t.Kind = Void
+	case *dwarf.BoolType:
+		t.Kind = Bool
+	case *dwarf.IntType:
+		t.Kind = Int
+	case *dwarf.UintType:
+		t.Kind = Uint
+	case *dwarf.FloatType:
+		t.Kind = Float
+	case *dwarf.ComplexType:
+		t.Kind = Complex
+	case *dwarf.ArrayType:
+		t.Kind = Array
+		t.Len = u.Count
+		t.Elem = g.build(u.Type)
+	case *dwarf.FuncType:
+		t.Kind = Func
+		for _, p := range u.ParamType {
+			t.Params = append(t.Params, g.build(p))
+		}
+	case *dwarf.PtrType:
+		t.Kind = Pointer
+		if _, ok := u.Type.(*dwarf.VoidType); !ok {
+			t.Elem = g.build(u.Type)
+		}
+		// Maps and channels are named pointers to runtime structs.
+		if strings.HasPrefix(t.Name, "map[") {
+			t.Kind = Map
+			t.Key, t.Elem = g.mapTypes(u)
+		} else if isChanName(t.Name) {
+			t.Kind = Chan
+			t.Elem = nil
+		}
+	case *dwarf.StructType:
+		t.Kind = Struct
+		for _, f := range u.Field {
+			t.Fields = append(t.Fields, &Field{Name: f.Name, Offset: f.ByteOffset, Type: g.build(f.Type)})
+		}
+		switch {
+		case u.StructName == "string":
+			t.Kind = String
+		case strings.HasPrefix(u.StructName, "[]"):
+			t.Kind = Slice
+			if array := t.Field("array"); array != nil {
+				t.Elem = array.Type.Elem
+			}
+		case isInterfaceStruct(u):
+			t.Kind = Interface
+		}
+	}
+	return t
+}
+
+// Returns the key and element types of the map whose runtime
+// representation is a pointer to hmap, from the arrays of keys and
+// values of its buckets.
+func (g *Graph) mapTypes(hmap *dwarf.PtrType) (*Type, *Type) {
+	h, ok := resolve(hmap.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, nil
+	}
+	var buckets *dwarf.StructType
+	for _, f := range h.Field {
+		if f.Name != "buckets" {
+			continue
+		}
+		if p, ok := resolve(f.Type).(*dwarf.PtrType); ok {
+			buckets, _ = resolve(p.Type).(*dwarf.StructType)
+		}
+	}
+	if buckets == nil {
+		return nil, nil
+	}
+	var key, elem *Type
+	for _, f := range buckets.Field {
+		a, ok := resolve(f.Type).(*dwarf.ArrayType)
+		if !ok {
+			continue
+		}
+		switch f.Name {
+		case "keys":
+			key = g.build(a.Type)
+		case "values", "elems":
+			elem = g.build(a.Type)
+		}
+	}
+	return key, elem
+}
+
+// Returns the name of dt in Go syntax.
+func goName(dt dwarf.Type) string {
+	switch t := dt.(type) {
+	case *dwarf.TypedefType:
+		return t.Name
+	case *dwarf.StructType:
+		if t.StructName != "" {
+			return t.StructName
+		}
+	case *dwarf.PtrType:
+		if _, ok := t.Type.(*dwarf.VoidType); ok {
+			return "unsafe.Pointer"
+		}
+		return "*" + goName(t.Type)
+	case *dwarf.ArrayType:
+		return fmt.Sprintf("[%d]%s", t.Count, goName(t.Type))
+	case *dwarf.FuncType:
+		params := make([]string, len(t.ParamType))
+		for i, p := range t.ParamType {
+			params[i] = goName(p)
+		}
+		return fmt.Sprintf("func(%s)", strings.Join(params, ", "))
+	}
+	if name := dt.Common().Name; name != "" {
+		return name
+	}
+	return dt.String()
+}
+
+// Returns the type named by the typedefs dt is made of.
+func resolve(dt dwarf.Type) dwarf.Type {
+	for {
+		t, ok := dt.(*dwarf.TypedefType)
+		if !ok {
+			return dt
+		}
+		dt = t.Type
+	}
+}
+
+func isChanName(name string) bool {
+	return strings.HasPrefix(name, "chan ") || strings.HasPrefix(name, "chan<- ") || strings.HasPrefix(name, "<-chan ")
+}
+
+// Returns whether t is the runtime representation of interfaces.
+func isInterfaceStruct(t *dwarf.StructType) bool {
+	switch t.StructName {
+	case "runtime.iface", "runtime.eface", "runtime.Iface", "runtime.Eface":
+		return true
+	}
+	return false
+}
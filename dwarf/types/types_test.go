@@ -0,0 +1,94 @@
+package types_test
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/derekparker/delve/dwarf/types"
+)
+
+func loadData(t *testing.T) *dwarf.Data {
+	dir, err := ioutil.TempDir("", "types")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "testvariables")
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", exe, "../../_fixtures/testvariables.go").Run(); err != nil {
+		t.Fatal("could not compile testvariables:", err)
+	}
+
+	f, err := elf.Open(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := f.DWARF()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Returns the type named name in data, as built by g.
+func lookup(t *testing.T, g *types.Graph, data *dwarf.Data, name string) *types.Type {
+	r := data.Reader()
+	for entry, err := r.Next(); entry != nil; entry, err = r.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch entry.Tag {
+		case dwarf.TagBaseType, dwarf.TagPointerType, dwarf.TagStructType, dwarf.TagTypedef, dwarf.TagArrayType:
+		default:
+			continue
+		}
+		if n, _ := entry.Val(dwarf.AttrName).(string); n == name {
+			typ, err := g.Load(data, entry.Offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return typ
+		}
+	}
+	t.Fatalf("no type named %s", name)
+	return nil
+}
+
+func TestGraph(t *testing.T) {
+	data := loadData(t)
+	g := types.NewGraph()
+
+	s := lookup(t, g, data, "string")
+	if s.Kind != types.String || s.Field("str") == nil || s.Field("len") == nil {
+		t.Fatalf("Expected a string with str and len fields, got %s %v", s.Kind, s.Fields)
+	}
+
+	foo := lookup(t, g, data, "main.FooBar")
+	if foo.Kind != types.Struct || len(foo.Fields) != 2 {
+		t.Fatalf("Expected a struct with 2 fields, got %s %v", foo.Kind, foo.Fields)
+	}
+	if bur := foo.Field("Bur"); bur == nil || bur.Type != s || bur.Offset != 8 {
+		t.Fatalf("Expected field Bur to be the string at offset 8, got %+v", bur)
+	}
+
+	slice := lookup(t, g, data, "[]main.FooBar")
+	if slice.Kind != types.Slice || slice.Elem.Name != "main.FooBar" || slice.Elem.Field("Bur").Type != s {
+		t.Fatalf("Expected a slice of main.FooBar, got %s of %v", slice.Kind, slice.Elem)
+	}
+
+	// Nest refers to itself through a pointer.
+	nest := lookup(t, g, data, "main.Nest")
+	next := nest.Field("Nest")
+	if next == nil || next.Type.Kind != types.Pointer || next.Type.Elem.Field("Nest").Type != next.Type || next.Type.Name != "*main.Nest" {
+		t.Fatalf("Expected field Nest to point back to main.Nest, got %+v", next)
+	}
+
+	if iface := lookup(t, g, data, "error"); iface.Kind != types.Interface {
+		t.Fatalf("Expected error to be an interface, got %s", iface.Kind)
+	}
+}
@@ -0,0 +1,145 @@
+package proctl
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// Serves the program launched from cmd, or the process pid when cmd
+// is nil, to debuggers connecting to l, see ConnectAgent. This is all
+// the agent does: the debug information of the program isn't loaded,
+// only the native backend runs, so that it fits small targets. The
+// symbols are read by the debugger from its own copy of the program.
+//
+// The agent speaks the GDB remote serial protocol, see ServeGDB, so
+// GDB can connect to it as well. Returns once the debugger detaches
+// or the last client disconnects. As with Detach, a program the agent
+// launched is killed then, a process it attached to keeps running.
+func ServeAgent(l net.Listener, cmd []string, pid int) error {
+	// The register block of the protocol is laid out as on amd64.
+	if runtime.GOARCH != "amd64" {
+		return fmt.Errorf("the agent only supports amd64 targets")
+	}
+	var (
+		dbp *DebuggedProcess
+		err error
+	)
+	if cmd != nil {
+		dbp, err = launch(cmd, LaunchConfig{}, false)
+	} else {
+		dbp, err = newProcess(pid, true, false)
+	}
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "linux" {
+		// Served to debuggers looking for their copy of it.
+		dbp.path = fmt.Sprintf("/proc/%d/exe", dbp.Pid)
+	}
+
+	err = dbp.ServeGDBClients(l)
+	if cmd != nil && !dbp.Exited() {
+		dbp.kill()
+	}
+	return err
+}
+
+// Backend debugging a process served by an agent on another machine,
+// see ServeAgent. The agent speaks the same protocol as rr, but the
+// process it serves runs forward only, and on its own once detached
+// from.
+type agentBackend struct {
+	*rrBackend
+}
+
+func (ab agentBackend) info() BackendInfo {
+	return BackendInfo{
+		Name:                "agent",
+		HardwareBreakpoints: true,
+		WriteMemory:         true,
+	}
+}
+
+func (ab agentBackend) detach(dbp *DebuggedProcess) error {
+	if err := ab.conn.execOK("D"); err != nil {
+		return err
+	}
+	return ab.conn.conn.Close()
+}
+
+// Connects to the agent serving a process at addr, see ServeAgent.
+// The symbols of the process are loaded from path, a copy of its
+// executable on this machine, e.g. the binary cross compiled for the
+// target. The process is stopped, as when attached to.
+func ConnectAgent(addr, path string) (*DebuggedProcess, error) {
+	return ConnectAgentWithConfig(addr, SymbolConfig{Binary: path})
+}
+
+// Connects to the agent serving a process at addr as ConnectAgent
+// does, finding the symbols of the process according to cfg.
+func ConnectAgentWithConfig(addr string, cfg SymbolConfig) (*DebuggedProcess, error) {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := newGdbConn(c)
+	dbp, err := newAgentProcess(conn, cfg)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return dbp, nil
+}
+
+func newAgentProcess(conn *gdbConn, cfg SymbolConfig) (*DebuggedProcess, error) {
+	// Stop replies only carry the pid in the multiprocess form.
+	if _, err := conn.exec("qSupported:multiprocess+"); err != nil {
+		return nil, err
+	}
+	sr, err := conn.stopReason()
+	if err != nil {
+		return nil, err
+	}
+	if sr.pid == 0 {
+		return nil, fmt.Errorf("the agent did not report the process it serves")
+	}
+
+	ab := agentBackend{newRRBackend(conn, nil, sr.pid)}
+	dbp := &DebuggedProcess{
+		Pid:         sr.pid,
+		Threads:     make(map[int]*ThreadContext),
+		BreakPoints: make(map[uint64]*BreakPoint),
+		os:          new(OSProcessDetails),
+		backend:     ab,
+		attached:    true,
+	}
+	dbp.startTiming()
+
+	if err := ab.updateThreadList(dbp); err != nil {
+		return nil, err
+	}
+	th, ok := dbp.Threads[sr.tid]
+	if !ok {
+		return nil, fmt.Errorf("the agent reported unknown thread %d", sr.tid)
+	}
+	dbp.CurrentThread = th
+
+	path := cfg.Binary
+	if path == "" {
+		remote, err := conn.execFile(sr.pid)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the executable of process %d: %s", sr.pid, err)
+		}
+		path = mapPath(remote, cfg.PathMap)
+	}
+	if !cfg.SkipVerify {
+		if err := dbp.verifyBinary(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := dbp.loadInformation(path); err != nil {
+		return nil, err
+	}
+	return dbp, nil
+}
@@ -0,0 +1,69 @@
+package proctl
+
+import (
+	"debug/elf"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// INT 3, written over the first byte of an instruction to set a
+// software breakpoint.
+var breakpointInstruction = []byte{0xCC}
+
+// How far past a software breakpoint the PC of the thread that
+// executed it is: INT 3 traps after the instruction.
+const breakpointPCOffset = 1
+
+const (
+	elfMachine = elf.EM_386
+	// Size of the struct elf_prstatus of a NT_PRSTATUS note. Core
+	// files are only read and written on 64 bit architectures.
+	prstatusSize = 144
+)
+
+// Other names of registers, all names are case insensitive.
+var registerAliases = map[string]string{
+	"pc":    "eip",
+	"sp":    "esp",
+	"flags": "eflags",
+}
+
+// Names of the registers by DWARF register number, as used in the
+// frame information, the return address being in column 8.
+var dwarfRegisters = []string{
+	"eax", "ecx", "edx", "ebx", "esp", "ebp", "esi", "edi",
+	"eip",
+}
+
+// None, Go functions take their arguments on the stack, above the
+// return address.
+var argRegisters []string
+
+// None, functions are only called in the process on amd64.
+const debugCallRegister = ""
+
+// DWARF number of the frame pointer register, through which code
+// without frame information, as that of C libraries, is unwound.
+const dwarfFPRegister = 5
+
+// Offsets from the stack pointer of the system stack, as C code returns
+// to runtime.asmcgocall, of the g it switched stacks from and of the
+// depth of the stack pointer in the stack of that g. Then the size of
+// the frame of runtime.asmcgocall on the stack of the g and the offset
+// of its return address from the stack pointer.
+const (
+	asmcgocallGOffset     = 8
+	asmcgocallDepthOffset = 4
+	asmcgocallFrameSize   = 4
+	asmcgocallRetOffset   = 0
+)
+
+// Decodes the instruction at the start of code, located at pc, in Go
+// assembler syntax, symname naming the addresses it refers to.
+func decodeInstruction(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int, error) {
+	inst, err := x86asm.Decode(code, 32)
+	if err != nil {
+		return "", 0, err
+	}
+	return x86asm.GoSyntax(inst, pc, symname), inst.Len, nil
+}
@@ -0,0 +1,72 @@
+package proctl
+
+import (
+	"debug/elf"
+
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// INT 3, written over the first byte of an instruction to set a
+// software breakpoint.
+var breakpointInstruction = []byte{0xCC}
+
+// How far past a software breakpoint the PC of the thread that
+// executed it is: INT 3 traps after the instruction.
+const breakpointPCOffset = 1
+
+const (
+	elfMachine = elf.EM_X86_64
+	// Size of the struct elf_prstatus of a NT_PRSTATUS note.
+	prstatusSize = 336
+)
+
+// Other names of registers, all names are case insensitive.
+var registerAliases = map[string]string{
+	"pc":     "rip",
+	"sp":     "rsp",
+	"flags":  "eflags",
+	"rflags": "eflags",
+}
+
+// Names of the registers by DWARF register number, as used in the
+// frame information, the return address being in column 16.
+var dwarfRegisters = []string{
+	"rax", "rdx", "rcx", "rbx", "rsi", "rdi", "rbp", "rsp",
+	"r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15",
+	"rip",
+}
+
+// Registers holding the integer arguments of Go functions at their
+// entry, and their integer results as they return, in the order they
+// are assigned.
+var argRegisters = []string{"rax", "rbx", "rcx", "rdi", "rsi", "r8", "r9", "r10", "r11"}
+
+// Register through which runtime.debugCallV2 tells the debugger what
+// it expects of it next, see Call.
+const debugCallRegister = "r12"
+
+// DWARF number of the frame pointer register, through which code
+// without frame information, as that of C libraries, is unwound.
+const dwarfFPRegister = 6
+
+// Offsets from the stack pointer of the system stack, as C code returns
+// to runtime.asmcgocall, of the g it switched stacks from and of the
+// depth of the stack pointer in the stack of that g. Then the size of
+// the frame of runtime.asmcgocall on the stack of the g and the offset
+// of its return address from the stack pointer.
+const (
+	asmcgocallGOffset     = 8
+	asmcgocallDepthOffset = 0
+	asmcgocallFrameSize   = 16
+	asmcgocallRetOffset   = 8
+)
+
+// Decodes the instruction at the start of code, located at pc, in Go
+// assembler syntax, symname naming the addresses it refers to.
+func decodeInstruction(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int, error) {
+	inst, err := x86asm.Decode(code, 64)
+	if err != nil {
+		return "", 0, err
+	}
+	return x86asm.GoSyntax(inst, pc, symname), inst.Len, nil
+}
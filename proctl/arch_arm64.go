@@ -0,0 +1,73 @@
+package proctl
+
+import (
+	"debug/elf"
+
+	"golang.org/x/arch/arm64/arm64asm"
+)
+
+// BRK #0, written over an instruction to set a software breakpoint.
+var breakpointInstruction = []byte{0x00, 0x00, 0x20, 0xd4}
+
+// How far past a software breakpoint the PC of the thread that
+// executed it is: BRK traps before the instruction.
+const breakpointPCOffset = 0
+
+const (
+	elfMachine = elf.EM_AARCH64
+	// Size of the struct elf_prstatus of a NT_PRSTATUS note.
+	prstatusSize = 392
+)
+
+// Other names of registers, all names are case insensitive.
+var registerAliases = map[string]string{
+	"lr":   "x30",
+	"fp":   "x29",
+	"g":    "x28",
+	"cpsr": "pstate",
+}
+
+// Names of the registers by DWARF register number, as used in the
+// frame information, the return address being in x30, the link
+// register.
+var dwarfRegisters = []string{
+	"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7",
+	"x8", "x9", "x10", "x11", "x12", "x13", "x14", "x15",
+	"x16", "x17", "x18", "x19", "x20", "x21", "x22", "x23",
+	"x24", "x25", "x26", "x27", "x28", "x29", "x30", "sp",
+	"pc",
+}
+
+// Registers holding the first integer arguments of Go functions at
+// their entry.
+var argRegisters = []string{"x0", "x1", "x2"}
+
+// None, functions are only called in the process on amd64.
+const debugCallRegister = ""
+
+// DWARF number of the frame pointer register, through which code
+// without frame information, as that of C libraries, is unwound.
+const dwarfFPRegister = 29
+
+// Offsets from the stack pointer of the system stack, as C code returns
+// to runtime.asmcgocall, of the g it switched stacks from and of the
+// depth of the stack pointer in the stack of that g. Then the size of
+// the frame of runtime.asmcgocall on the stack of the g and the offset
+// of its return address from the stack pointer.
+const (
+	asmcgocallGOffset     = 0
+	asmcgocallDepthOffset = 8
+	asmcgocallFrameSize   = 16
+	asmcgocallRetOffset   = 0
+)
+
+// Decodes the instruction at the start of code, located at pc, in Go
+// assembler syntax, symname naming the addresses it refers to.
+// Instructions are all 4 bytes long.
+func decodeInstruction(code []byte, pc uint64, symname func(uint64) (string, uint64)) (string, int, error) {
+	inst, err := arm64asm.Decode(code)
+	if err != nil {
+		return "", 0, err
+	}
+	return arm64asm.GoSyntax(inst, pc, symname, nil), 4, nil
+}
@@ -0,0 +1,147 @@
+package proctl
+
+import (
+	"context"
+	"runtime"
+)
+
+// A backend implements the low level operations used to control
+// the target process. The native backend drives a live process
+// through ptrace (mach on darwin); alternative backends, such as
+// replaying an rr recording or reading a core file, implement the
+// same operations on top of a different mechanism.
+type backend interface {
+	registers(thread *ThreadContext) (Registers, error)
+	readMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error)
+	writeMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error)
+	setSoftwareBreakpoint(thread *ThreadContext, addr uint64) ([]byte, error)
+	clearSoftwareBreakpoint(thread *ThreadContext, addr uint64, originalData []byte) error
+	setHardwareBreakpoint(reg, tid int, addr uint64) error
+	clearHardwareBreakpoint(reg, tid int) error
+	setHardwareWatchpoint(reg, tid int, addr uint64, size int) error
+	hardwareBreakpointHit(thread *ThreadContext) (int, error)
+	resume(thread *ThreadContext) error
+	singleStep(thread *ThreadContext) error
+	halt(thread *ThreadContext) error
+	// Makes trapWait return, from another goroutine than the one
+	// waiting, once the context it waits with is done.
+	interrupt(dbp *DebuggedProcess) error
+	trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error)
+	detach(dbp *DebuggedProcess) error
+	info() BackendInfo
+}
+
+// The native backend, controlling a live process via the facilities
+// provided by the OS. Errors caused by the process disappearing from
+// under us are reported as a ProcessExitedError.
+type nativeBackend struct{}
+
+// Registers are read once per stop, rather than every time stepping
+// and unwinding need them, and forgotten as the thread is resumed.
+// Callers get a copy of them, which they may change.
+func (nativeBackend) registers(thread *ThreadContext) (Registers, error) {
+	if thread.regs == nil {
+		regs, err := registers(thread)
+		if err != nil {
+			return nil, thread.Process.exitedError(err)
+		}
+		thread.regs = regs
+	}
+	return copyRegisters(thread.regs), nil
+}
+
+func (nativeBackend) readMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	n, err := readMemory(thread, addr, data)
+	return n, thread.Process.exitedError(err)
+}
+
+func (nativeBackend) writeMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	n, err := writeMemory(thread, addr, data)
+	return n, thread.Process.exitedError(err)
+}
+
+// Overwrites the instruction at addr with the breakpoint instruction
+// of the architecture, e.g. INT 3 on amd64.
+func (nb nativeBackend) setSoftwareBreakpoint(thread *ThreadContext, addr uint64) ([]byte, error) {
+	tx := thread.BeginWrite()
+	if err := tx.WriteMemory(uintptr(addr), breakpointInstruction); err != nil {
+		return nil, err
+	}
+	originalData := tx.undo[0].original
+	tx.Commit()
+	thread.Process.journalPatch(addr, originalData, breakpointInstruction)
+	return originalData, nil
+}
+
+func (nb nativeBackend) clearSoftwareBreakpoint(thread *ThreadContext, addr uint64, originalData []byte) error {
+	if _, err := nb.writeMemory(thread, uintptr(addr), originalData); err != nil {
+		return err
+	}
+	thread.Process.unjournalPatch(addr)
+	return nil
+}
+
+func (nativeBackend) setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	return setHardwareBreakpoint(reg, tid, addr)
+}
+
+func (nativeBackend) clearHardwareBreakpoint(reg, tid int) error {
+	return clearHardwareBreakpoint(reg, tid)
+}
+
+func (nativeBackend) info() BackendInfo {
+	debugRegisters := runtime.GOARCH == "amd64" || runtime.GOARCH == "386"
+	return BackendInfo{
+		Name:                "native",
+		HardwareBreakpoints: debugRegisters,
+		Watchpoints:         debugRegisters,
+		WriteMemory:         true,
+	}
+}
+
+func (nativeBackend) setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	return setHardwareWatchpoint(reg, tid, addr, size)
+}
+
+func (nativeBackend) hardwareBreakpointHit(thread *ThreadContext) (int, error) {
+	reg, err := hardwareBreakpointHit(thread.Id)
+	return reg, thread.Process.exitedError(err)
+}
+
+func (nativeBackend) resume(thread *ThreadContext) error {
+	thread.Process.logger().Debugf("continue thread %d", thread.Id)
+	thread.regs = nil
+	return thread.Process.exitedError(thread.resume())
+}
+
+func (nativeBackend) singleStep(thread *ThreadContext) error {
+	thread.Process.logger().Debugf("single step thread %d", thread.Id)
+	thread.regs = nil
+	return thread.Process.exitedError(thread.singleStep())
+}
+
+func (nativeBackend) halt(thread *ThreadContext) error {
+	thread.Process.logger().Debugf("halt thread %d", thread.Id)
+	thread.regs = nil
+	return thread.Halt()
+}
+
+func (nativeBackend) interrupt(dbp *DebuggedProcess) error {
+	dbp.logger().Debugf("interrupt process %d", dbp.Pid)
+	return interrupt(dbp)
+}
+
+func (nativeBackend) trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error) {
+	wpid, err := trapWait(ctx, dbp, pid)
+	if err != nil {
+		dbp.logger().Debugf("wait for %d: %s", pid, err)
+	} else {
+		dbp.logger().Debugf("wait for %d: thread %d trapped", pid, wpid)
+	}
+	return wpid, err
+}
+
+func (nativeBackend) detach(dbp *DebuggedProcess) error {
+	dbp.logger().Debugf("detach from process %d", dbp.Pid)
+	return dbp.exitedError(dbp.detach())
+}
@@ -1,8 +1,9 @@
 package proctl
 
 import (
+	"debug/gosym"
 	"fmt"
-	"runtime"
+	"sort"
 )
 
 // Represents a single breakpoint. Stores information on the break
@@ -16,10 +17,57 @@ type BreakPoint struct {
 	OriginalData []byte
 	ID           int
 	Temp         bool
+	Goroutine    int  // When non zero, only stop for this goroutine
+	Disabled     bool // Kept, but not inserted into the process
+
+	// Set for tracepoints, which print their location and the value
+	// of Variables when hit, instead of stopping the process. When
+	// Stacktrace is non zero, that many frames of the stack of the
+	// goroutine hitting them are printed too.
+	Tracepoint bool
+	Variables  []string
+	Stacktrace int
+
+	// Set for watchpoints, which stop the process after Variable
+	// is written to. Only the WatchSize bytes at Addr are watched,
+	// which is less than the whole variable when Partial is set.
+	Variable  string
+	WatchSize int
+	Partial   bool
+
+	// Set for pending breakpoints, which are not in the process but
+	// wait for Location to resolve in its program, see BreakPending.
+	// Breakpoints set by location keep it too, to be found again by
+	// Restart in a rebuilt program.
+	Pending  bool
+	Location string
+
+	// Non zero for breakpoints set together, see BreakByFunctionRegexp.
+	Group int
+
+	// Set for breakpoints recording the creation of goroutines, see
+	// BreakOnGoroutineCreation.
+	Creations bool
 }
 
 func (bp *BreakPoint) String() string {
-	return fmt.Sprintf("Breakpoint %d at %#v %s:%d", bp.ID, bp.Addr, bp.File, bp.Line)
+	var s string
+	if bp.Pending {
+		s = fmt.Sprintf("Breakpoint %d pending at %s", bp.ID, bp.Location)
+	} else if bp.Variable != "" {
+		s = fmt.Sprintf("Watchpoint %d on %s at %#v", bp.ID, bp.Variable, bp.Addr)
+	} else if bp.Tracepoint {
+		s = fmt.Sprintf("Tracepoint %d at %#v %s:%d", bp.ID, bp.Addr, bp.File, bp.Line)
+	} else {
+		s = fmt.Sprintf("Breakpoint %d at %#v %s:%d", bp.ID, bp.Addr, bp.File, bp.Line)
+	}
+	if bp.Group != 0 {
+		s += fmt.Sprintf(" (group %d)", bp.Group)
+	}
+	if bp.Disabled {
+		s += " (disabled)"
+	}
+	return s
 }
 
 // Returned when trying to set a breakpoint at
@@ -44,11 +92,22 @@ func (iae InvalidAddressError) Error() string {
 	return fmt.Sprintf("Invalid address %#v\n", iae.address)
 }
 
+type byID []*BreakPoint
+
+func (s byID) Len() int           { return len(s) }
+func (s byID) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s byID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 // Returns whether or not a breakpoint has been set for the given address.
 func (dbp *DebuggedProcess) BreakpointExists(addr uint64) bool {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.breakpointExists(addr)
+}
+
+func (dbp *DebuggedProcess) breakpointExists(addr uint64) bool {
 	for _, bp := range dbp.HWBreakPoints {
-		// TODO(darwin)
-		if runtime.GOOS == "darwin" {
+		if !dbp.backend.info().HardwareBreakpoints {
 			break
 		}
 		if bp != nil && bp.Addr == addr {
@@ -74,42 +133,49 @@ func (dbp *DebuggedProcess) newBreakpoint(fn, f string, l int, addr uint64, data
 }
 
 func (dbp *DebuggedProcess) setBreakpoint(tid int, addr uint64) (*BreakPoint, error) {
-	var f, l, fn = dbp.GoSymTable.PCToLine(uint64(addr))
-	if fn == nil {
-		return nil, InvalidAddressError{address: addr}
+	var (
+		f, name string
+		l       int
+	)
+	// Without symbols, as when serving an agent, any address will do.
+	if dbp.GoSymTable != nil {
+		var fn *gosym.Func
+		if f, l, fn = dbp.GoSymTable.PCToLine(uint64(addr)); fn == nil {
+			return nil, InvalidAddressError{address: addr}
+		}
+		name = fn.Name
 	}
-	if dbp.BreakpointExists(addr) {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.breakpointExists(addr) {
 		return nil, BreakPointExistsError{f, l, addr}
 	}
 	// Try and set a hardware breakpoint.
 	for i, v := range dbp.HWBreakPoints {
-		// TODO(darwin)
-		if runtime.GOOS == "darwin" {
+		if !dbp.backend.info().HardwareBreakpoints {
 			break
 		}
 		if v == nil {
-			if err := setHardwareBreakpoint(i, tid, addr); err != nil {
+			if err := dbp.backend.setHardwareBreakpoint(i, tid, addr); err != nil {
 				return nil, fmt.Errorf("could not set hardware breakpoint: %v", err)
 			}
-			dbp.HWBreakPoints[i] = dbp.newBreakpoint(fn.Name, f, l, addr, nil)
+			dbp.HWBreakPoints[i] = dbp.newBreakpoint(name, f, l, addr, nil)
 			return dbp.HWBreakPoints[i], nil
 		}
 	}
-	// Fall back to software breakpoint. 0xCC is INT 3, software
-	// breakpoint trap interrupt.
+	// Fall back to software breakpoint.
 	thread := dbp.Threads[tid]
-	originalData := make([]byte, 1)
-	if _, err := readMemory(thread, uintptr(addr), originalData); err != nil {
+	originalData, err := dbp.backend.setSoftwareBreakpoint(thread, addr)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := writeMemory(thread, uintptr(addr), []byte{0xCC}); err != nil {
-		return nil, err
-	}
-	dbp.BreakPoints[addr] = dbp.newBreakpoint(fn.Name, f, l, addr, originalData)
+	dbp.BreakPoints[addr] = dbp.newBreakpoint(name, f, l, addr, originalData)
 	return dbp.BreakPoints[addr], nil
 }
 
 func (dbp *DebuggedProcess) clearBreakpoint(tid int, addr uint64) (*BreakPoint, error) {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
 	// Check for hardware breakpoint
 	for i, bp := range dbp.HWBreakPoints {
 		if bp == nil {
@@ -117,7 +183,16 @@ func (dbp *DebuggedProcess) clearBreakpoint(tid int, addr uint64) (*BreakPoint,
 		}
 		if bp.Addr == addr {
 			dbp.HWBreakPoints[i] = nil
-			if err := clearHardwareBreakpoint(i, tid); err != nil {
+			if bp.Variable != "" {
+				// Watchpoints are set on every thread.
+				for _, th := range dbp.Threads {
+					if err := dbp.backend.clearHardwareBreakpoint(i, th.Id); err != nil {
+						return nil, err
+					}
+				}
+				return bp, nil
+			}
+			if err := dbp.backend.clearHardwareBreakpoint(i, tid); err != nil {
 				return nil, err
 			}
 			return bp, nil
@@ -126,7 +201,7 @@ func (dbp *DebuggedProcess) clearBreakpoint(tid int, addr uint64) (*BreakPoint,
 	// Check for software breakpoint
 	if bp, ok := dbp.BreakPoints[addr]; ok {
 		thread := dbp.Threads[tid]
-		if _, err := writeMemory(thread, uintptr(bp.Addr), bp.OriginalData); err != nil {
+		if err := dbp.backend.clearSoftwareBreakpoint(thread, bp.Addr, bp.OriginalData); err != nil {
 			return nil, fmt.Errorf("could not clear breakpoint %s", err)
 		}
 		delete(dbp.BreakPoints, addr)
@@ -134,3 +209,183 @@ func (dbp *DebuggedProcess) clearBreakpoint(tid int, addr uint64) (*BreakPoint,
 	}
 	return nil, fmt.Errorf("No breakpoint currently set for %#v", addr)
 }
+
+// Removes every breakpoint and watchpoint from the process, restoring
+// the original instructions and clearing the debug registers of all
+// threads.
+func (dbp *DebuggedProcess) clearAllBreakpoints() error {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	for i, bp := range dbp.HWBreakPoints {
+		if bp == nil {
+			continue
+		}
+		for _, th := range dbp.Threads {
+			if err := dbp.backend.clearHardwareBreakpoint(i, th.Id); err != nil {
+				return err
+			}
+		}
+		dbp.HWBreakPoints[i] = nil
+	}
+	for addr, bp := range dbp.BreakPoints {
+		if !bp.Disabled {
+			if err := dbp.backend.clearSoftwareBreakpoint(dbp.CurrentThread, addr, bp.OriginalData); err != nil {
+				return fmt.Errorf("could not clear breakpoint %s", err)
+			}
+		}
+		delete(dbp.BreakPoints, addr)
+	}
+	return nil
+}
+
+// Logs the location of the tracepoint bp hit by thread, followed
+// by the values of the variables it traces and the stack captured
+// for it, if any.
+func (thread *ThreadContext) logTracepoint(bp *BreakPoint, stack []Frame) {
+	log := thread.Process.logger()
+	log.Infof("> %s() %s:%d (tracepoint %d)", bp.FunctionName, bp.File, bp.Line, bp.ID)
+	for _, name := range bp.Variables {
+		v, err := thread.EvalSymbol(name)
+		if err != nil {
+			log.Infof("\t%s: %s", name, err)
+			continue
+		}
+		log.Infof("\t%s = %s", name, v.Value)
+	}
+	for _, f := range stack {
+		log.Infof("\t\t%#x %s %s:%d", f.PC, f.Function, f.File, f.Line)
+	}
+}
+
+// Returns the enabled software breakpoint whose trap was just
+// executed by a thread stopped at pc.
+func (dbp *DebuggedProcess) trappedBreakpoint(pc uint64) (*BreakPoint, bool) {
+	bp, ok := dbp.BreakPoints[pc-breakpointPCOffset]
+	if !ok || bp.Disabled {
+		return nil, false
+	}
+	return bp, true
+}
+
+// Returns the enabled breakpoint a thread stopped at pc has to step
+// over to resume: a software breakpoint whose trap it just executed,
+// or a hardware breakpoint at pc, which would trap again.
+func (dbp *DebuggedProcess) breakpointAt(pc uint64) (*BreakPoint, bool) {
+	if bp, ok := dbp.trappedBreakpoint(pc); ok {
+		return bp, true
+	}
+	for _, bp := range dbp.HWBreakPoints {
+		if bp != nil && !bp.Disabled && bp.Variable == "" && bp.Addr == pc {
+			return bp, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the breakpoint or watchpoint with the given id.
+func (dbp *DebuggedProcess) breakpointByID(id int) (*BreakPoint, error) {
+	for _, bp := range dbp.HWBreakPoints {
+		if bp != nil && bp.ID == id {
+			return bp, nil
+		}
+	}
+	for _, bp := range dbp.BreakPoints {
+		if bp.ID == id {
+			return bp, nil
+		}
+	}
+	return nil, fmt.Errorf("no breakpoint with id %d", id)
+}
+
+// Returns the breakpoints, tracepoints and watchpoints set in the
+// process, hardware and software alike, leaving out temporary and
+// pending ones, sorted by ID. Breakpoints set before the symbols were
+// loaded, as when serving an agent, get their location resolved.
+func (dbp *DebuggedProcess) Breakpoints() []*BreakPoint {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	bps := dbp.userBreakpoints()
+	if dbp.GoSymTable == nil {
+		return bps
+	}
+	for _, bp := range bps {
+		if bp.File != "" || bp.Variable != "" {
+			continue
+		}
+		if f, l, fn := dbp.GoSymTable.PCToLine(bp.Addr); fn != nil {
+			bp.FunctionName, bp.File, bp.Line = fn.Name, f, l
+		}
+	}
+	return bps
+}
+
+// Returns the breakpoints, tracepoints and watchpoints set by the
+// user, leaving out temporary ones, sorted by ID. Callers hold dbp.mu.
+func (dbp *DebuggedProcess) userBreakpoints() []*BreakPoint {
+	var bps []*BreakPoint
+	for _, bp := range dbp.HWBreakPoints {
+		if bp != nil && !bp.Temp {
+			bps = append(bps, bp)
+		}
+	}
+	for _, bp := range dbp.BreakPoints {
+		if !bp.Temp {
+			bps = append(bps, bp)
+		}
+	}
+	sort.Sort(byID(bps))
+	return bps
+}
+
+// Inserts bp back into the process, or removes it from the process
+// while keeping it in the breakpoint tables, so that it keeps its ID
+// and conditions.
+func (dbp *DebuggedProcess) setBreakpointEnabled(tid int, bp *BreakPoint, enabled bool) error {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if bp.Disabled != enabled {
+		return nil
+	}
+	for i, v := range dbp.HWBreakPoints {
+		if v != bp {
+			continue
+		}
+		threads := []int{tid}
+		if bp.Variable != "" {
+			// Watchpoints are set on every thread.
+			threads = threads[:0]
+			for id := range dbp.Threads {
+				threads = append(threads, id)
+			}
+		}
+		for _, id := range threads {
+			var err error
+			switch {
+			case !enabled:
+				err = dbp.backend.clearHardwareBreakpoint(i, id)
+			case bp.Variable != "":
+				err = dbp.backend.setHardwareWatchpoint(i, id, bp.Addr, bp.WatchSize)
+			default:
+				err = dbp.backend.setHardwareBreakpoint(i, id, bp.Addr)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		bp.Disabled = !enabled
+		return nil
+	}
+
+	thread := dbp.Threads[tid]
+	if enabled {
+		originalData, err := dbp.backend.setSoftwareBreakpoint(thread, bp.Addr)
+		if err != nil {
+			return err
+		}
+		bp.OriginalData = originalData
+	} else if err := dbp.backend.clearSoftwareBreakpoint(thread, bp.Addr, bp.OriginalData); err != nil {
+		return fmt.Errorf("could not clear breakpoint %s", err)
+	}
+	bp.Disabled = !enabled
+	return nil
+}
@@ -1,13 +1,149 @@
 package proctl
 
+// #include "threads_darwin.h"
+import "C"
 import "fmt"
 
-// TODO(darwin)
+// Fields of the debug control register DR7, as
+// <sys/debugreg.h> defines them on linux.
+const (
+	drControlShift = 16
+	drControlSize  = 4
+	drEnableSize   = 2
+
+	drRWExecute = 0x0
+	drRWWrite   = 0x1
+	drLen1      = 0x0
+	drLen2      = 0x4
+	drLen4      = 0xc
+	drLen8      = 0x8
+)
+
+// Sets a hardware breakpoint by setting the contents of the
+// debug register `reg` with the address of the instruction
+// that we want to break at, through the debug state of the
+// thread as mach exposes it. There are only 4 debug registers
+// DR0-DR3. Debug register 7 is the control register.
 func setHardwareBreakpoint(reg, tid int, addr uint64) error {
-	return fmt.Errorf("not implemented on darwin")
+	return setDebugRegister(reg, tid, addr, drRWExecute|drLen1)
+}
+
+// Sets a hardware watchpoint, which raises a debug exception
+// after an instruction writes to any of the size bytes at addr.
+// size must be 1, 2, 4 or 8, and addr aligned to it.
+func setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	var length uint64
+	switch size {
+	case 1:
+		length = drLen1
+	case 2:
+		length = drLen2
+	case 4:
+		length = drLen4
+	case 8:
+		length = drLen8
+	default:
+		return fmt.Errorf("invalid watchpoint size %d", size)
+	}
+	if addr%uint64(size) != 0 {
+		return fmt.Errorf("watchpoint address %#x is not aligned to %d bytes", addr, size)
+	}
+	return setDebugRegister(reg, tid, addr, drRWWrite|length)
+}
+
+// Returns the debug register whose breakpoint or watchpoint raised
+// the last debug exception of thread tid, or -1 if none did. The
+// debug status register is cleared, as the CPU never clears it.
+func hardwareBreakpointHit(tid int) (int, error) {
+	state, err := debugState(tid)
+	if err != nil {
+		return -1, err
+	}
+	for reg := 0; reg < 4; reg++ {
+		if state.__dr6&(1<<uint(reg)) != 0 {
+			state.__dr6 = 0
+			return reg, setDebugState(tid, state)
+		}
+	}
+	return -1, nil
 }
 
-// TODO(darwin)
+// Sets the debug register `reg` to addr, with ctl holding
+// the read/write and length bits of its control flags.
+func setDebugRegister(reg, tid int, addr uint64, ctl uint64) error {
+	if reg < 0 || reg > 3 {
+		return fmt.Errorf("invalid debug register value")
+	}
+
+	var (
+		drxmask   = uint64((((1 << drControlSize) - 1) << uint(drControlShift+reg*drControlSize)) | (((1 << drEnableSize) - 1) << uint(reg*drEnableSize)))
+		drxenable = uint64(0x1) << uint(reg*drEnableSize)
+		drxctl    = ctl << uint(reg*drControlSize)
+	)
+
+	// Get current state
+	state, err := debugState(tid)
+	if err != nil {
+		return err
+	}
+	dr7 := uint64(state.__dr7)
+
+	// If addr == 0 we are expected to disable the breakpoint
+	if addr == 0 {
+		state.__dr7 = C.__uint64_t(dr7 &^ drxmask)
+		return setDebugState(tid, state)
+	}
+
+	// Error out if dr`reg` is already used
+	if dr7&(0x3<<uint(reg*drEnableSize)) != 0 {
+		return fmt.Errorf("dr%d already enabled", reg)
+	}
+
+	// Set the debug register `reg` with the address of the
+	// instruction or data we want to trigger a debug exception.
+	switch reg {
+	case 0:
+		state.__dr0 = C.__uint64_t(addr)
+	case 1:
+		state.__dr1 = C.__uint64_t(addr)
+	case 2:
+		state.__dr2 = C.__uint64_t(addr)
+	case 3:
+		state.__dr3 = C.__uint64_t(addr)
+	}
+
+	// Clear dr`reg` flags, then enable it.
+	dr7 &^= drxmask
+	dr7 |= (drxctl << drControlShift) | drxenable
+
+	// Setting the debug control register along with the
+	// address instructs the cpu to raise a debug exception
+	// when hitting the address stored in dr0-dr3.
+	state.__dr7 = C.__uint64_t(dr7)
+	return setDebugState(tid, state)
+}
+
+// Clears a hardware breakpoint. Essentially sets
+// the debug reg to 0 and clears the control register
+// flags for that reg.
 func clearHardwareBreakpoint(reg, tid int) error {
-	return fmt.Errorf("not implemented on darwin")
+	return setHardwareBreakpoint(reg, tid, 0)
+}
+
+// Reads the debug registers of thread tid, whose id on darwin
+// is its mach port.
+func debugState(tid int) (*C.x86_debug_state64_t, error) {
+	var state C.x86_debug_state64_t
+	if kret := C.get_debug_state(C.thread_act_t(tid), &state); kret != C.KERN_SUCCESS {
+		return nil, fmt.Errorf("could not get debug registers of thread %d", tid)
+	}
+	return &state, nil
+}
+
+// Writes the debug registers of thread tid.
+func setDebugState(tid int, state *C.x86_debug_state64_t) error {
+	if kret := C.set_debug_state(C.thread_act_t(tid), state); kret != C.KERN_SUCCESS {
+		return fmt.Errorf("could not set debug registers of thread %d", tid)
+	}
+	return nil
 }
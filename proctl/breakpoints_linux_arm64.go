@@ -0,0 +1,24 @@
+package proctl
+
+import "fmt"
+
+// TODO(arm64) hardware breakpoints and watchpoints, through the
+// NT_ARM_HW_BREAK and NT_ARM_HW_WATCH register sets.
+func setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	return fmt.Errorf("not implemented on arm64")
+}
+
+// TODO(arm64)
+func clearHardwareBreakpoint(reg, tid int) error {
+	return fmt.Errorf("not implemented on arm64")
+}
+
+// TODO(arm64)
+func setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	return fmt.Errorf("not implemented on arm64")
+}
+
+// TODO(arm64)
+func hardwareBreakpointHit(tid int) (int, error) {
+	return -1, nil
+}
@@ -1,3 +1,7 @@
+//go:build linux && (amd64 || 386)
+// +build linux
+// +build amd64 386
+
 package proctl
 
 /*
@@ -22,6 +26,52 @@ import "fmt"
 // that we want to break at. There are only 4 debug registers
 // DR0-DR3. Debug register 7 is the control register.
 func setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	return setDebugRegister(reg, tid, addr, C.DR_RW_EXECUTE|C.DR_LEN_1)
+}
+
+// Sets a hardware watchpoint, which raises a debug exception
+// after an instruction writes to any of the size bytes at addr.
+// size must be 1, 2, 4 or 8, and addr aligned to it.
+func setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	var length uintptr
+	switch size {
+	case 1:
+		length = C.DR_LEN_1
+	case 2:
+		length = C.DR_LEN_2
+	case 4:
+		length = C.DR_LEN_4
+	case 8:
+		length = C.DR_LEN_8
+	default:
+		return fmt.Errorf("invalid watchpoint size %d", size)
+	}
+	if addr%uint64(size) != 0 {
+		return fmt.Errorf("watchpoint address %#x is not aligned to %d bytes", addr, size)
+	}
+	return setDebugRegister(reg, tid, addr, C.DR_RW_WRITE|length)
+}
+
+// Returns the debug register whose breakpoint or watchpoint raised
+// the last debug exception of thread tid, or -1 if none did. The
+// debug status register is cleared, as the CPU never clears it.
+func hardwareBreakpointHit(tid int) (int, error) {
+	dr6off := uintptr(C.offset(C.DR_STATUS))
+	dr6, err := PtracePeekUser(tid, dr6off)
+	if err != nil {
+		return -1, err
+	}
+	for reg := 0; reg < 4; reg++ {
+		if dr6&(1<<uint(reg)) != 0 {
+			return reg, PtracePokeUser(tid, dr6off, 0)
+		}
+	}
+	return -1, nil
+}
+
+// Sets the debug register `reg` to addr, with ctl holding
+// the read/write and length bits of its control flags.
+func setDebugRegister(reg, tid int, addr uint64, ctl uintptr) error {
 	if reg < 0 || reg > 3 {
 		return fmt.Errorf("invalid debug register value")
 	}
@@ -29,9 +79,9 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 	var (
 		dr7off    = uintptr(C.offset(C.DR_CONTROL))
 		drxoff    = uintptr(C.offset(C.int(reg)))
-		drxmask   = uintptr((((1 << C.DR_CONTROL_SIZE) - 1) << uintptr(reg*C.DR_CONTROL_SIZE)) | (((1 << C.DR_ENABLE_SIZE) - 1) << uintptr(reg*C.DR_ENABLE_SIZE)))
+		drxmask   = uintptr((((1 << C.DR_CONTROL_SIZE) - 1) << uintptr(C.DR_CONTROL_SHIFT+reg*C.DR_CONTROL_SIZE)) | (((1 << C.DR_ENABLE_SIZE) - 1) << uintptr(reg*C.DR_ENABLE_SIZE)))
 		drxenable = uintptr(0x1) << uintptr(reg*C.DR_ENABLE_SIZE)
-		drxctl    = uintptr(C.DR_RW_EXECUTE|C.DR_LEN_1) << uintptr(reg*C.DR_CONTROL_SIZE)
+		drxctl    = ctl << uintptr(reg*C.DR_CONTROL_SIZE)
 	)
 
 	// Get current state
@@ -52,7 +102,7 @@ func setHardwareBreakpoint(reg, tid int, addr uint64) error {
 	}
 
 	// Set the debug register `reg` with the address of the
-	// instruction we want to trigger a debug exception.
+	// instruction or data we want to trigger a debug exception.
 	if err := PtracePokeUser(tid, drxoff, uintptr(addr)); err != nil {
 		return err
 	}
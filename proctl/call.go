@@ -0,0 +1,473 @@
+package proctl
+
+import (
+	"context"
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Values of debugCallRegister as runtime.debugCallV2 traps, telling
+// the debugger what it expects of it.
+const (
+	debugCallSetup   = 0  // The call frame is allocated, set up the call
+	debugCallReturn  = 1  // The function returned
+	debugCallPanic   = 2  // The function panicked, the panic value is at SP
+	debugCallUnsafe  = 8  // The call can't be made, the reason is at SP
+	debugCallRestore = 16 // Done, restore the registers
+)
+
+// Minimum room left on the stack of the goroutine for
+// runtime.debugCallV2, as required by the runtime.
+const debugCallStackSpace = 256
+
+// Upper bound on the number of instructions stepped on the way out
+// of runtime.debugCallV2, back to where the thread was stopped.
+const maxDebugCallRestoreSteps = 64
+
+// A part of a value passed in a single integer register, at offset
+// in the value and size bytes long.
+type registerPart struct {
+	offset, size int64
+	signed       bool
+}
+
+// A parameter or result of a function.
+type callParam struct {
+	name   string
+	t      dwarf.Type
+	result bool
+	parts  []registerPart
+	err    error // Why it isn't passed in registers, if it isn't
+}
+
+// Calls the function fnName with the arguments args, Go expressions
+// evaluated in the scope of the current function, and returns its
+// results. Unqualified names are looked up in the package of the
+// current function.
+//
+// The call is made through runtime.debugCallV2, which checks the
+// goroutine of the thread is stopped where a call can be made, runs
+// the function on a new goroutine and moves the stack as needed, and
+// the registers of the thread are restored once it returns. Only the
+// thread is resumed meanwhile, so the function must not wait on other
+// goroutines. Arguments and results are passed in registers, which
+// limits them to integers, pointers, strings, slices and interfaces.
+func (thread *ThreadContext) Call(fnName string, args ...string) ([]*Variable, error) {
+	return thread.call(context.Background(), fnName, args...)
+}
+
+// Does Call until ctx is done.
+func (thread *ThreadContext) call(ctx context.Context, fnName string, args ...string) ([]*Variable, error) {
+	dbp := thread.Process
+	if debugCallRegister == "" {
+		return nil, fmt.Errorf("function calls are not supported on %s", runtime.GOARCH)
+	}
+	if !dbp.backend.info().WriteMemory {
+		return nil, fmt.Errorf("function calls are not supported by the %s backend", dbp.backend.info().Name)
+	}
+	debugCall := dbp.lookupFunc("runtime.debugCallV2")
+	if debugCall == nil {
+		return nil, fmt.Errorf("the program does not support function calls, runtime.debugCallV2 not found")
+	}
+	fn := dbp.lookupFunc(fnName)
+	if fn == nil {
+		if pkg := thread.currentPackage(); pkg != "" {
+			fn = dbp.lookupFunc(pkg + "." + fnName)
+		}
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("could not find function %s", fnName)
+	}
+	params, err := dbp.callParams(fn.Entry)
+	if err != nil {
+		return nil, err
+	}
+
+	// Evaluate the arguments before changing anything.
+	var (
+		argRegs          []uint64
+		nargs            int
+		argSize, resSize int64
+	)
+	for _, p := range params {
+		if p.err != nil {
+			return nil, p.err
+		}
+		size := (typeSize(p.t) + int64(ptrsize) - 1) &^ (int64(ptrsize) - 1)
+		if p.result {
+			resSize += size
+			continue
+		}
+		argSize += size
+		if nargs >= len(args) {
+			nargs++
+			continue
+		}
+		expr, err := parseExpr(args[nargs])
+		if err != nil {
+			return nil, err
+		}
+		data, err := thread.encodeValue(expr, p.t)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use %s as type %s in argument to %s: %s", args[nargs], p.t, fn.Name, err)
+		}
+		for _, part := range p.parts {
+			argRegs = append(argRegs, part.value(data))
+		}
+		nargs++
+	}
+	if nargs != len(args) {
+		return nil, fmt.Errorf("wrong number of arguments to %s: %d instead of %d", fn.Name, len(args), nargs)
+	}
+	if len(argRegs) > len(argRegisters) {
+		return nil, fmt.Errorf("the arguments of %s do not fit in registers", fn.Name)
+	}
+	frameSize := argSize
+	if resSize > frameSize {
+		frameSize = resSize
+	}
+
+	g, err := thread.CurrentGoroutine()
+	if err != nil {
+		return nil, err
+	}
+	if g.status&^gstatusScan != gstatusRunning {
+		return nil, fmt.Errorf("goroutine %d is not running", g.Id)
+	}
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	if regs.SP()-g.stacklo < debugCallStackSpace {
+		return nil, fmt.Errorf("not enough room on the stack of goroutine %d", g.Id)
+	}
+	saved := regs.Slice()
+	stopPC := regs.PC()
+	pc := stopPC
+	// Return to the instruction under the breakpoint the thread
+	// is stopped at, rather than to the middle of it.
+	if bp, ok := dbp.trappedBreakpoint(pc); ok {
+		pc = bp.Addr
+	}
+
+	// Push the return address and the size of the frame for the
+	// arguments, then enter runtime.debugCallV2.
+	sp := regs.SP() - uint64(ptrsize)
+	if err := thread.writeMemory(uintptr(sp), encodeUint(pc, int64(ptrsize))); err != nil {
+		return nil, err
+	}
+	if err := thread.writeMemory(uintptr(sp-2*uint64(ptrsize)), encodeUint(uint64(frameSize), int64(ptrsize))); err != nil {
+		return nil, err
+	}
+	restored := false
+	defer func() {
+		// Leave the thread as we found it, as best we can.
+		if !restored {
+			thread.setRegisters(saved, nil)
+		}
+	}()
+	if err := regs.SetRegister(thread, "sp", sp); err != nil {
+		return nil, err
+	}
+	if err := regs.SetPC(thread, debugCall.Entry); err != nil {
+		return nil, err
+	}
+
+	var (
+		results []*Variable
+		callErr error
+	)
+	for {
+		if err := thread.Continue(); err != nil {
+			return nil, err
+		}
+		if _, err := dbp.pausableTrapWait(ctx, thread.Id, []*ThreadContext{thread}); err != nil {
+			return nil, err
+		}
+		regs, err := thread.Registers()
+		if err != nil {
+			return nil, err
+		}
+		if f := dbp.GoSymTable.PCToFunc(regs.PC()); f == nil || !isDebugCallFunc(f.Name) {
+			// A breakpoint in the function called, continued past.
+			continue
+		}
+		state, _ := registerValue(regs, debugCallRegister)
+
+		switch state {
+		case debugCallSetup:
+			for i, v := range argRegs {
+				if err := regs.SetRegister(thread, argRegisters[i], v); err != nil {
+					return nil, err
+				}
+			}
+			// Call the function from the trap, for it to return to
+			// the trap reporting it did.
+			sp := regs.SP() - uint64(ptrsize)
+			if err := thread.writeMemory(uintptr(sp), encodeUint(regs.PC(), int64(ptrsize))); err != nil {
+				return nil, err
+			}
+			if err := regs.SetRegister(thread, "sp", sp); err != nil {
+				return nil, err
+			}
+			if err := regs.SetPC(thread, fn.Entry); err != nil {
+				return nil, err
+			}
+		case debugCallReturn:
+			if results, err = thread.callResults(params, regs); err != nil {
+				callErr = err
+			}
+		case debugCallPanic:
+			callErr = fmt.Errorf("%s panicked: %s", fn.Name, thread.panicValue(regs.SP()))
+		case debugCallUnsafe:
+			reason, err := thread.readString(uintptr(regs.SP()))
+			if err != nil {
+				reason = err.Error()
+			}
+			callErr = fmt.Errorf("cannot call %s here: %s", fn.Name, reason)
+		case debugCallRestore:
+			// The runtime restores the registers which may hold
+			// pointers itself, as the stack may have moved.
+			if err := thread.setRegisters(saved, []string{registerName("pc"), registerName("sp")}); err != nil {
+				return nil, err
+			}
+			if err := thread.leaveDebugCall(pc); err != nil {
+				return nil, err
+			}
+			restored = true
+			if pc != stopPC {
+				regs, err := thread.Registers()
+				if err != nil {
+					return nil, err
+				}
+				if err := regs.SetPC(thread, stopPC); err != nil {
+					return nil, err
+				}
+			}
+			return results, callErr
+		default:
+			return nil, fmt.Errorf("unexpected state %d of the call of %s", state, fn.Name)
+		}
+	}
+}
+
+// Returns true if the function name traps to talk to the debugger
+// during a call: runtime.debugCallV2, runtime.debugCallPanicked or
+// one of the functions the call is made from, which have no package.
+func isDebugCallFunc(name string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(name, "runtime."), "debugCall")
+}
+
+// Steps thread out of runtime.debugCallV2, back to pc where it was
+// stopped before the call.
+func (thread *ThreadContext) leaveDebugCall(pc uint64) error {
+	for i := 0; i < maxDebugCallRestoreSteps; i++ {
+		if err := thread.Step(); err != nil {
+			return err
+		}
+		cur, err := thread.CurrentPC()
+		if err != nil {
+			return err
+		}
+		if cur == pc {
+			return nil
+		}
+	}
+	return fmt.Errorf("could not return from the function call to %#x", pc)
+}
+
+// Returns the parameters and results of the function at entry, in
+// the order they are declared, with the registers they are passed in.
+// Those which can't be passed in registers have err set.
+func (dbp *DebuggedProcess) callParams(entry uint64) ([]callParam, error) {
+	reader := dbp.DwarfReader()
+	if _, err := reader.SeekToFunction(dbp.dwarfPC(entry)); err != nil {
+		return nil, err
+	}
+	var params []callParam
+	for e, err := reader.NextScopeVariable(); e != nil; e, err = reader.NextScopeVariable() {
+		if err != nil {
+			return nil, err
+		}
+		if e.Tag != dwarf.TagFormalParameter {
+			continue
+		}
+		off, ok := e.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return nil, fmt.Errorf("type assertion failed")
+		}
+		t, err := dbp.Dwarf.Type(off)
+		if err != nil {
+			return nil, err
+		}
+		p := callParam{t: t}
+		p.name, _ = e.Val(dwarf.AttrName).(string)
+		p.result, _ = e.Val(dwarf.AttrVarParam).(bool)
+		if p.parts, err = registerParts(t, 0); err != nil {
+			p.err = fmt.Errorf("cannot pass %s of type %s: %s", p.name, t, err)
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+// Returns how a value of type t is split into integer registers by
+// the register based calling convention: one register per field of
+// basic type, found recursively in structs and arrays of one element.
+func registerParts(t dwarf.Type, offset int64) ([]registerPart, error) {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.IntType:
+		return []registerPart{{offset, t.ByteSize, true}}, nil
+	case *dwarf.UintType, *dwarf.BoolType, *dwarf.CharType, *dwarf.UcharType:
+		return []registerPart{{offset, t.Size(), false}}, nil
+	case *dwarf.PtrType, *dwarf.FuncType:
+		return []registerPart{{offset, int64(ptrsize), false}}, nil
+	case *dwarf.StructType:
+		var parts []registerPart
+		for _, f := range t.Field {
+			p, err := registerParts(f.Type, offset+f.ByteOffset)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, p...)
+		}
+		return parts, nil
+	case *dwarf.ArrayType:
+		switch t.Count {
+		case 0:
+			return nil, nil
+		case 1:
+			return registerParts(t.Type, offset)
+		}
+		return nil, fmt.Errorf("arrays are passed on the stack")
+	case *dwarf.FloatType, *dwarf.ComplexType:
+		return nil, fmt.Errorf("floating point registers are not supported")
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// Returns the value of the part of data, the representation in memory
+// of a value, as it is passed in a register.
+func (p registerPart) value(data []byte) uint64 {
+	buf := make([]byte, 8)
+	copy(buf, data[p.offset:p.offset+p.size])
+	v := binary.LittleEndian.Uint64(buf)
+	if p.signed {
+		shift := uint(64 - 8*p.size)
+		v = uint64(int64(v<<shift) >> shift)
+	}
+	return v
+}
+
+// Returns the results of a function which just returned them in the
+// registers regs. Their values are assembled in the frame reserved for
+// the call by runtime.debugCallV2, to be read like any other variable.
+func (thread *ThreadContext) callResults(params []callParam, regs Registers) ([]*Variable, error) {
+	data, err := resultData(params, regs)
+	if err != nil {
+		return nil, err
+	}
+	addr := regs.SP()
+	var results []*Variable
+	for i, p := range resultParams(params) {
+		if err := thread.writeMemory(uintptr(addr), data[i]); err != nil {
+			return nil, err
+		}
+		v, err := thread.newVariable(p.name, addr, p.t)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+		addr += (uint64(len(data[i])) + uint64(ptrsize) - 1) &^ (uint64(ptrsize) - 1)
+	}
+	return results, nil
+}
+
+// Returns the results among params.
+func resultParams(params []callParam) []callParam {
+	var results []callParam
+	for _, p := range params {
+		if p.result {
+			results = append(results, p)
+		}
+	}
+	return results
+}
+
+// Returns the representation in memory of the values of the results
+// among params, just returned in the registers regs.
+func resultData(params []callParam, regs Registers) ([][]byte, error) {
+	reg := 0
+	var results [][]byte
+	for _, p := range resultParams(params) {
+		if p.err != nil {
+			return nil, p.err
+		}
+		data := make([]byte, typeSize(p.t))
+		for _, part := range p.parts {
+			if reg >= len(argRegisters) {
+				return nil, fmt.Errorf("%s is returned on the stack", p.name)
+			}
+			v, ok := registerValue(regs, argRegisters[reg])
+			if !ok {
+				return nil, fmt.Errorf("could not read register %s", argRegisters[reg])
+			}
+			copy(data[part.offset:part.offset+part.size], encodeUint(v, part.size))
+			reg++
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// Returns the panic value, an interface{}, at addr.
+func (thread *ThreadContext) panicValue(addr uint64) string {
+	t, err := thread.Process.findType("interface {}")
+	if err != nil {
+		return "unknown panic value"
+	}
+	val, err := thread.extractValue(nil, int64(addr), t, true)
+	if err != nil {
+		return err.Error()
+	}
+	return val
+}
+
+// Returns the value of the register name.
+func registerValue(regs Registers, name string) (uint64, bool) {
+	for _, r := range regs.Slice() {
+		if registerName(r.Name) == name {
+			return r.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Sets the registers of thread back to the values in saved, as
+// returned by Registers.Slice, except for those named in except.
+// Only changed registers are set, read only registers being left.
+func (thread *ThreadContext) setRegisters(saved []Register, except []string) error {
+	regs, err := thread.Registers()
+	if err != nil {
+		return err
+	}
+	cur := regs.Slice()
+outer:
+	for i, r := range saved {
+		for _, name := range except {
+			if registerName(r.Name) == name {
+				continue outer
+			}
+		}
+		if i < len(cur) && cur[i].Value == r.Value {
+			continue
+		}
+		if err := regs.SetRegister(thread, r.Name, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
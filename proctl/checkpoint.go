@@ -0,0 +1,189 @@
+package proctl
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// A copy of the process taken by Checkpoint, which can be returned
+// to with Restore. The copy is a stopped process sharing the memory
+// of the process copy-on-write, with the breakpoints removed.
+type Checkpoint struct {
+	ID       int
+	Pid      int    // Of the stopped copy
+	PC       uint64 // Where the current thread was stopped
+	File     string
+	Line     int
+	Function string
+	When     time.Time
+}
+
+func (cp Checkpoint) String() string {
+	return fmt.Sprintf("Checkpoint %d at %s %s:%d (%s)", cp.ID, cp.Function, cp.File, cp.Line, cp.When.Format("15:04:05"))
+}
+
+// Saves the state of the stopped process, to return to it later with
+// Restore, by forking the current thread. Only the current thread is
+// copied: other threads don't exist in the copy, the program may hang
+// after a restore if it waits for them. Only supported by the native
+// backend on linux. As Kill does, it stops the process first if another
+// goroutine runs it.
+func (dbp *DebuggedProcess) Checkpoint() (*Checkpoint, error) {
+	dbp.acquire(nil, true)
+	defer dbp.release()
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	if _, ok := dbp.backend.(nativeBackend); !ok {
+		return nil, fmt.Errorf("checkpoints are not supported by the %s backend", dbp.backend.info().Name)
+	}
+	thread := dbp.CurrentThread
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	// The copy has no breakpoints, it resumes at the instruction the
+	// breakpoint replaced.
+	pc := regs.PC()
+	if bp, ok := dbp.trappedBreakpoint(pc); ok {
+		pc = bp.Addr
+	}
+	pid, err := forkCopy(thread.Id, pc, dbp.Patches())
+	if err != nil {
+		return nil, fmt.Errorf("could not checkpoint thread %d: %s", thread.Id, err)
+	}
+
+	cp := &Checkpoint{Pid: pid, PC: pc, When: time.Now()}
+	if f, l, fn := dbp.GoSymTable.PCToLine(pc); fn != nil {
+		cp.File, cp.Line, cp.Function = f, l, fn.Name
+	}
+	dbp.mu.Lock()
+	dbp.checkpointIDCounter++
+	cp.ID = dbp.checkpointIDCounter
+	dbp.checkpoints = append(dbp.checkpoints, cp)
+	dbp.mu.Unlock()
+	return cp, nil
+}
+
+// Returns the checkpoints taken, in the order they were taken.
+func (dbp *DebuggedProcess) Checkpoints() []Checkpoint {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	cps := make([]Checkpoint, len(dbp.checkpoints))
+	for i, cp := range dbp.checkpoints {
+		cps[i] = *cp
+	}
+	return cps
+}
+
+// Returns the checkpoint with the given id.
+func (dbp *DebuggedProcess) checkpointByID(id int) (*Checkpoint, error) {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	for _, cp := range dbp.checkpoints {
+		if cp.ID == id {
+			return cp, nil
+		}
+	}
+	return nil, fmt.Errorf("no checkpoint with id %d", id)
+}
+
+// Kills the process and continues debugging a copy of the checkpoint
+// with the given id, which is kept to be restored again. The process
+// stops where the checkpoint was taken, with the breakpoints, tracepoints
+// and watchpoints currently set rather than those set back then.
+// Memory and registers are restored, the outside world is not: files
+// written or data sent since the checkpoint stay that way.
+func (dbp *DebuggedProcess) Restore(id int) error {
+	cp, err := dbp.checkpointByID(id)
+	if err != nil {
+		return err
+	}
+	dbp.acquire(nil, true)
+	defer dbp.release()
+	pid, err := forkCopy(cp.Pid, cp.PC, nil)
+	if err != nil {
+		return fmt.Errorf("could not restore checkpoint %d: %s", id, err)
+	}
+	if !dbp.Exited() {
+		dbp.discard()
+	}
+
+	dbp.mu.Lock()
+	bps := dbp.userBreakpoints()
+	counter := dbp.breakpointIDCounter
+
+	thread := &ThreadContext{Id: pid, Process: dbp}
+	dbp.Pid = pid
+	dbp.Process, _ = os.FindProcess(pid)
+	dbp.Threads = map[int]*ThreadContext{pid: thread}
+	dbp.CurrentThread = thread
+	dbp.HWBreakPoints = [4]*BreakPoint{}
+	dbp.BreakPoints = make(map[uint64]*BreakPoint)
+	dbp.exited = false
+	dbp.exitErr = ProcessExitedError{}
+	dbp.stopReason = StopUnknown
+	dbp.prevUsage = nil
+	dbp.exitHooksRun = false
+	dbp.forks, dbp.pendingForks = nil, nil
+	dbp.patchMu.Lock()
+	dbp.patches = nil
+	dbp.patchMu.Unlock()
+	dbp.mu.Unlock()
+	if err := dbp.traceExit(dbp.tracingExit()); err != nil {
+		return err
+	}
+
+	for _, bp := range bps {
+		if err := dbp.restoreBreakpoint(bp); err != nil {
+			return fmt.Errorf("could not restore %s: %s", bp, err)
+		}
+	}
+	dbp.mu.Lock()
+	dbp.breakpointIDCounter = counter
+	dbp.mu.Unlock()
+
+	// Make the thread look like it hit the breakpoint where it stopped.
+	if _, ok := dbp.trappedBreakpoint(cp.PC + breakpointPCOffset); ok && breakpointPCOffset != 0 {
+		regs, err := thread.Registers()
+		if err != nil {
+			return err
+		}
+		if err := regs.SetPC(thread, cp.PC+breakpointPCOffset); err != nil {
+			return err
+		}
+	}
+	// Stopped in another state, as if it ran to it.
+	dbp.stopped()
+	return nil
+}
+
+// Deletes the checkpoint with the given id, killing its copy of the
+// process.
+func (dbp *DebuggedProcess) ClearCheckpoint(id int) error {
+	cp, err := dbp.checkpointByID(id)
+	if err != nil {
+		return err
+	}
+	dbp.mu.Lock()
+	for i := range dbp.checkpoints {
+		if dbp.checkpoints[i] == cp {
+			dbp.checkpoints = append(dbp.checkpoints[:i], dbp.checkpoints[i+1:]...)
+			break
+		}
+	}
+	dbp.mu.Unlock()
+	return killCopy(cp.Pid)
+}
+
+// Deletes every checkpoint, at the end of the session.
+func (dbp *DebuggedProcess) clearCheckpoints() {
+	dbp.mu.Lock()
+	cps := dbp.checkpoints
+	dbp.checkpoints = nil
+	dbp.mu.Unlock()
+	for _, cp := range cps {
+		killCopy(cp.Pid)
+	}
+}
@@ -0,0 +1,16 @@
+package proctl
+
+import "fmt"
+
+// TODO(darwin) checkpoints
+func forkCopy(tid int, pc uint64, patches []Patch) (int, error) {
+	return 0, fmt.Errorf("checkpoints are not supported on darwin")
+}
+
+func (dbp *DebuggedProcess) discard() {
+	dbp.kill()
+}
+
+func killCopy(pid int) error {
+	return fmt.Errorf("checkpoints are not supported on darwin")
+}
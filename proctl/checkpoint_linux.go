@@ -0,0 +1,137 @@
+package proctl
+
+import (
+	"fmt"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// Makes the stopped thread tid fork, by executing the system call at
+// its PC. The child, which starts stopped and traced by us through
+// PTRACE_O_TRACEFORK, is left stopped at pc, with the registers of the
+// thread, the patches removed from its memory and no hardware
+// breakpoints. Returns its pid.
+func forkCopy(tid int, pc uint64, patches []Patch) (int, error) {
+	var saved sys.PtraceRegs
+	if err := ptraceGetRegs(tid, &saved); err != nil {
+		return 0, err
+	}
+	regs := saved
+	insn := forkSyscall(&regs)
+	at := uintptr(saved.PC())
+	orig := make([]byte, len(insn))
+	if _, err := ptracePeekData(tid, at, orig); err != nil {
+		return 0, err
+	}
+	if _, err := ptracePokeData(tid, at, insn); err != nil {
+		return 0, err
+	}
+	if err := ptraceSetRegs(tid, &regs); err != nil {
+		ptracePokeData(tid, at, orig)
+		return 0, err
+	}
+	child, err := stepFork(tid)
+	// The thread goes back to where it was either way.
+	ptracePokeData(tid, at, orig)
+	if err := ptraceSetRegs(tid, &saved); err != nil {
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, _, err := wait(child, 0); err != nil {
+		return 0, fmt.Errorf("could not wait for copy %d: %s", child, err)
+	}
+	if _, err := ptracePokeData(child, at, orig); err != nil {
+		return 0, err
+	}
+	for _, p := range patches {
+		if _, err := ptracePokeData(child, uintptr(p.Addr), p.Original); err != nil {
+			return 0, fmt.Errorf("could not remove breakpoint at %#x from copy %d: %s", p.Addr, child, err)
+		}
+	}
+	// So are the debug registers, on architectures using them.
+	for reg := 0; reg < 4; reg++ {
+		clearHardwareBreakpoint(reg, child)
+	}
+	saved.SetPC(pc)
+	if err := ptraceSetRegs(child, &saved); err != nil {
+		return 0, err
+	}
+	return child, nil
+}
+
+// Single steps the thread tid over the fork system call set up by
+// forkCopy, returning the pid of the child.
+func stepFork(tid int) (int, error) {
+	child := 0
+	for {
+		if err := PtraceSingleStep(tid); err != nil {
+			return 0, err
+		}
+		_, status, err := wait(tid, 0)
+		if err != nil {
+			return 0, err
+		}
+		if status.Exited() || status.Signaled() {
+			return 0, fmt.Errorf("thread %d exited while forking", tid)
+		}
+		if status.StopSignal() != sys.SIGTRAP {
+			// Signals the thread received meanwhile are
+			// discarded, they were meant for the program.
+			continue
+		}
+		if status.TrapCause() == sys.PTRACE_EVENT_FORK {
+			msg, err := ptraceGetEventMsg(tid)
+			if err != nil {
+				return 0, fmt.Errorf("could not get event message: %s", err)
+			}
+			// Step again to complete the system call.
+			child = int(msg)
+			continue
+		}
+		if child == 0 {
+			return 0, fmt.Errorf("fork failed")
+		}
+		return child, nil
+	}
+}
+
+// Kills the process, which is being replaced by a copy, and reaps its
+// threads. Unlike kill it isn't reported as exited.
+func (dbp *DebuggedProcess) discard() {
+	if dbp.tracingExit() {
+		dbp.traceExit(false)
+	}
+	sys.Kill(dbp.Pid, sys.SIGKILL)
+	// The exit of the main thread is reported after the others.
+	for _, th := range dbp.Threads {
+		if th.Id != dbp.Pid {
+			reap(th.Id)
+		}
+	}
+	reap(dbp.Pid)
+	if dbp.Process != nil {
+		dbp.Process.Release()
+	}
+}
+
+// Waits for the killed thread tid to exit.
+func reap(tid int) {
+	for {
+		_, status, err := wait(tid, 0)
+		if err != nil || status.Exited() || status.Signaled() {
+			return
+		}
+	}
+}
+
+// Kills a stopped copy of the process and waits for it to exit.
+func killCopy(pid int) error {
+	if err := sys.Kill(pid, sys.SIGKILL); err != nil {
+		return err
+	}
+	reap(pid)
+	return nil
+}
@@ -0,0 +1,78 @@
+package proctl
+
+import (
+	"debug/elf"
+	"os"
+	"sort"
+)
+
+// What to do with the processes the debugged process forks, on linux.
+// Either way forks are reported as EventProcessForked.
+type ChildPolicy int
+
+const (
+	// Remove the breakpoints children inherited and let them run on
+	// their own.
+	ChildDetach ChildPolicy = iota
+	// Keep tracing children until they exec a Go program, when they
+	// are stopped and added to Children as targets of their own.
+	// Children executing programs which can't be debugged are
+	// detached from then.
+	ChildFollow
+)
+
+func (cp ChildPolicy) String() string {
+	switch cp {
+	case ChildDetach:
+		return "detach"
+	case ChildFollow:
+		return "follow"
+	}
+	return "unknown"
+}
+
+// Returns the children followed by the process which executed a new
+// program, by pid. They are stopped right after the exec until driven
+// themselves, with no breakpoints set. Only one process runs at a
+// time: while a child is driven, the process stays stopped.
+func (dbp *DebuggedProcess) Children() []*DebuggedProcess {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	children := make([]*DebuggedProcess, len(dbp.children))
+	copy(children, dbp.children)
+	sort.Sort(byPid(children))
+	return children
+}
+
+type byPid []*DebuggedProcess
+
+func (s byPid) Len() int           { return len(s) }
+func (s byPid) Less(i, j int) bool { return s[i].Pid < s[j].Pid }
+func (s byPid) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the path of the executable of the process.
+func (dbp *DebuggedProcess) Executable() string {
+	if exe, err := os.Readlink(dbp.path); err == nil {
+		return exe
+	}
+	return dbp.path
+}
+
+// Returns whether the executable at path is a Go program for our
+// architecture, with the debug information we need.
+func debuggable(path string) bool {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if f.Machine != elfMachine {
+		return false
+	}
+	for _, name := range []string{".debug_frame", ".debug_info", ".gopclntab"} {
+		if f.Section(name) == nil {
+			return false
+		}
+	}
+	return true
+}
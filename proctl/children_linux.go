@@ -0,0 +1,163 @@
+package proctl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// Handles the fork of thread tid into the process pid, which starts
+// stopped and traced by us, according to dbp.ChildPolicy.
+func (dbp *DebuggedProcess) forked(tid, pid int, vfork bool) error {
+	if dbp.pendingForks[pid] {
+		delete(dbp.pendingForks, pid)
+	} else if _, _, err := wait(pid, 0); err != nil {
+		return fmt.Errorf("could not wait for child %d: %s", pid, err)
+	}
+
+	// The child has a copy of our memory, breakpoints included. The
+	// child of a vfork shares it instead, until it execs, so removing
+	// them would remove ours.
+	if !vfork {
+		for _, p := range dbp.Patches() {
+			if _, err := ptracePokeData(pid, uintptr(p.Addr), p.Original); err != nil {
+				return fmt.Errorf("could not remove breakpoint at %#x from child %d: %s", p.Addr, pid, err)
+			}
+		}
+	}
+	dbp.emit(Event{Kind: EventProcessForked, Thread: tid, Process: pid})
+
+	if dbp.ChildPolicy != ChildFollow {
+		return ptraceDetach(pid)
+	}
+	if dbp.forks == nil {
+		dbp.forks = make(map[int]bool)
+	}
+	dbp.forks[pid] = true
+	return PtraceCont(pid, 0)
+}
+
+// Handles a wait status of the followed child pid, which runs until
+// it execs.
+func (dbp *DebuggedProcess) childStatus(pid int, status *sys.WaitStatus) error {
+	switch {
+	case status.Exited() || status.Signaled():
+		delete(dbp.forks, pid)
+		return nil
+	case status.StopSignal() == sys.SIGTRAP && status.TrapCause() == sys.PTRACE_EVENT_EXEC:
+		return dbp.childExeced(pid)
+	case status.StopSignal() == sys.SIGTRAP && (status.TrapCause() == sys.PTRACE_EVENT_FORK || status.TrapCause() == sys.PTRACE_EVENT_VFORK):
+		grandchild, err := ptraceGetEventMsg(pid)
+		if err != nil {
+			return fmt.Errorf("could not get event message: %s", err)
+		}
+		if err := dbp.forked(pid, int(grandchild), status.TrapCause() == sys.PTRACE_EVENT_VFORK); err != nil {
+			return err
+		}
+		return PtraceCont(pid, 0)
+	}
+	sig := status.StopSignal()
+	if sig == sys.SIGTRAP || sig == sys.SIGSTOP {
+		sig = 0
+	}
+	return PtraceCont(pid, int(sig))
+}
+
+// Handles the exec of the followed child pid, which is stopped, by
+// adding it to the children if it runs a program we can debug and
+// detaching from it otherwise. The process goes on either way.
+func (dbp *DebuggedProcess) childExeced(pid int) error {
+	delete(dbp.forks, pid)
+	path, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	dbp.emit(Event{Kind: EventExec, Thread: pid, Process: pid, Path: path})
+	if !debuggable(path) {
+		return ptraceDetach(pid)
+	}
+	child, err := newDebugProcess(pid, false)
+	if err != nil {
+		return ptraceDetach(pid)
+	}
+	child.attached = true
+	child.ChildPolicy = dbp.ChildPolicy
+	dbp.mu.Lock()
+	dbp.children = append(dbp.children, child)
+	dbp.mu.Unlock()
+	return nil
+}
+
+// Handles the exec of the process, which replaced its program, memory
+// and threads, taking the breakpoints along. The debug information of
+// the new program is loaded in place of the old one, and breakpoints
+// are set again where their lines exist in it, see BreakPending.
+func (dbp *DebuggedProcess) execed() error {
+	path, _ := os.Readlink(fmt.Sprintf("/proc/%d/exe", dbp.Pid))
+	if !debuggable(path) {
+		return fmt.Errorf("process %d executed %s, which can't be debugged", dbp.Pid, path)
+	}
+
+	dbp.mu.Lock()
+	old := dbp.Threads
+	thread, ok := old[dbp.Pid]
+	if !ok {
+		thread = &ThreadContext{Id: dbp.Pid, Process: dbp}
+	}
+	dbp.Threads = map[int]*ThreadContext{dbp.Pid: thread}
+	dbp.CurrentThread = thread
+	// The breakpoints wait for their lines in the new program.
+	for _, bp := range dbp.userBreakpoints() {
+		if bp.Variable == "" {
+			dbp.pending = append(dbp.pending, bp.pendingCopy())
+		}
+	}
+	dbp.BreakPoints = make(map[uint64]*BreakPoint)
+	dbp.HWBreakPoints = [4]*BreakPoint{}
+	dbp.mu.Unlock()
+	dbp.patchMu.Lock()
+	dbp.patches = nil
+	dbp.patchMu.Unlock()
+	dbp.lineMu.Lock()
+	dbp.lineIndex = nil
+	dbp.lineMu.Unlock()
+	dbp.typeMu.Lock()
+	dbp.typeGraph = nil
+	dbp.structTypes = nil
+	dbp.typeMu.Unlock()
+	dbp.symMu.Lock()
+	dbp.symIndex, dbp.funcIndex = nil, nil
+	dbp.symMu.Unlock()
+	for tid := range old {
+		if tid != dbp.Pid {
+			dbp.emit(Event{Kind: EventThreadExited, Thread: tid})
+		}
+	}
+
+	if err := dbp.LoadInformation(); err != nil {
+		return err
+	}
+	if err := dbp.resolvePending(); err != nil {
+		return err
+	}
+	dbp.setStopReason(StopExec)
+	dbp.emit(Event{Kind: EventExec, Thread: dbp.Pid, Process: dbp.Pid, Path: path})
+	return nil
+}
+
+// Returns the process the thread tid belongs to.
+func tgid(tid int) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", tid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "Tgid:") {
+			return strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(s.Text(), "Tgid:")))
+		}
+	}
+	return 0, fmt.Errorf("no Tgid in status of thread %d", tid)
+}
@@ -0,0 +1,178 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+)
+
+// Maximum number of frames searched for context arguments,
+// and of parents followed up the chain of a context.
+const (
+	maxContextDepth = 50
+	maxContextChain = 100
+)
+
+// ContextArg is a context.Context argument of a
+// function on the stack of a goroutine.
+type ContextArg struct {
+	Function string        // Function taking the argument
+	Name     string        // Name of the argument
+	Chain    []ContextLink // The context and its parents, up to the root
+}
+
+// ContextLink is one context of a chain of contexts.
+type ContextLink struct {
+	Type  string // Dynamic type, e.g. *context.valueCtx
+	Key   string // Key and value of contexts created by context.WithValue
+	Value string
+}
+
+func (cl ContextLink) String() string {
+	if cl.Key == "" {
+		return cl.Type
+	}
+	return fmt.Sprintf("%s %s = %s", cl.Type, cl.Key, cl.Value)
+}
+
+// Returns the context.Context arguments of the functions on the
+// stack of the goroutine with the given id, innermost frame first.
+func (dbp *DebuggedProcess) GoroutineContexts(id int) ([]*ContextArg, error) {
+	frames, err := dbp.goroutineStack(id, maxContextDepth)
+	if err != nil && len(frames) == 0 {
+		return nil, err
+	}
+
+	var args []*ContextArg
+	for _, frame := range frames {
+		if frame.fn == nil {
+			continue
+		}
+		vars, err := dbp.frameVariables(frame)
+		if err != nil {
+			continue
+		}
+		for _, v := range vars {
+			if v.tag != dwarf.TagFormalParameter || typeName(v.typ) != "context.Context" {
+				continue
+			}
+			iface, ok := resolveTypedef(v.typ).(*dwarf.StructType)
+			if !ok {
+				continue
+			}
+			chain, err := dbp.contextChain(v.addr, iface)
+			if err != nil {
+				return nil, fmt.Errorf("could not read %s of %s: %s", v.name, frame.fn.Name, err)
+			}
+			args = append(args, &ContextArg{Function: frame.fn.Name, Name: v.name, Chain: chain})
+		}
+	}
+	return args, nil
+}
+
+// Unwinds the stack of the goroutine with the given id. Goroutines
+// running on a thread are unwound from the registers of the thread,
+// parked ones from the state saved by the scheduler.
+func (dbp *DebuggedProcess) goroutineStack(id, depth int) ([]stackFrame, error) {
+	for _, th := range dbp.Threads {
+		if ok, _ := th.onGoroutine(id); !ok {
+			continue
+		}
+		regs, err := th.Registers()
+		if err != nil {
+			return nil, err
+		}
+		return dbp.stacktrace(regs.PC(), regs.SP(), regs, depth)
+	}
+
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range goroutines {
+		if g.Id == id && g.status != gstatusDead {
+			return dbp.stacktrace(g.PC, g.SP, nil, depth)
+		}
+	}
+	return nil, fmt.Errorf("no goroutine with id %d", id)
+}
+
+// Returns the chain of contexts starting at the context.Context value
+// of type t at addr, following each context to its parent.
+func (dbp *DebuggedProcess) contextChain(addr uint64, t *dwarf.StructType) ([]ContextLink, error) {
+	var chain []ContextLink
+	for len(chain) < maxContextChain {
+		typeaddr, err := dbp.interfaceType(addr, t)
+		if err != nil {
+			return nil, err
+		}
+		if typeaddr == 0 {
+			break
+		}
+		name, _, err := dbp.runtimeTypeName(typeaddr)
+		if err != nil {
+			return nil, err
+		}
+		link := ContextLink{Type: name}
+
+		valaddr, typ, err := dbp.interfaceValue(addr, t)
+		if err != nil {
+			return nil, err
+		}
+
+		// Contexts are pointers to structs, except for the
+		// empty contexts which are stored in the interface.
+		if ptr, ok := resolveTypedef(typ).(*dwarf.PtrType); ok {
+			if valaddr, err = dbp.readPointer(valaddr); err != nil {
+				return nil, err
+			}
+			typ = ptr.Type
+		}
+		st, ok := resolveTypedef(typ).(*dwarf.StructType)
+		if !ok || valaddr == 0 {
+			chain = append(chain, link)
+			break
+		}
+
+		if key, err := structField(st, "key"); err == nil {
+			if link.Key, err = dbp.CurrentThread.extractValue(nil, int64(valaddr)+key.ByteOffset, key.Type, false); err != nil {
+				return nil, err
+			}
+			val, err := structField(st, "val")
+			if err != nil {
+				return nil, err
+			}
+			if link.Value, err = dbp.CurrentThread.extractValue(nil, int64(valaddr)+val.ByteOffset, val.Type, false); err != nil {
+				return nil, err
+			}
+		}
+		chain = append(chain, link)
+
+		if addr, t, ok = parentContext(valaddr, st); !ok {
+			break
+		}
+	}
+	return chain, nil
+}
+
+// Returns the address and type of the parent of the context struct
+// st at addr, found as a context.Context field of st or of a context
+// struct embedded in it, e.g. the cancelCtx of a timerCtx.
+func parentContext(addr uint64, st *dwarf.StructType) (uint64, *dwarf.StructType, bool) {
+	for _, f := range st.Field {
+		fst, ok := resolveTypedef(f.Type).(*dwarf.StructType)
+		if !ok {
+			continue
+		}
+		faddr := addr + uint64(f.ByteOffset)
+		if typeName(f.Type) == "context.Context" {
+			return faddr, fst, true
+		}
+		if strings.HasPrefix(fst.StructName, "context.") {
+			if paddr, pt, ok := parentContext(faddr, fst); ok {
+				return paddr, pt, true
+			}
+		}
+	}
+	return 0, nil, false
+}
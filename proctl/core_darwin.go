@@ -0,0 +1,8 @@
+package proctl
+
+import "fmt"
+
+// OpenCore is not supported, darwin core files are Mach-O.
+func OpenCore(corePath, exePath string) (*DebuggedProcess, error) {
+	return nil, fmt.Errorf("core files are not supported on darwin")
+}
@@ -0,0 +1,318 @@
+package proctl
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// Offsets into the struct elf_prstatus of a NT_PRSTATUS note and
+// struct elf_prpsinfo of a NT_PRPSINFO note, on 64 bit architectures.
+const (
+	prstatusPidOffset  = 32
+	prstatusRegsOffset = 112
+	prpsinfoPidOffset  = 24
+)
+
+// Type of the note holding the auxiliary vector of the process,
+// missing from debug/elf.
+const ntAuxv = 6
+
+// A region of the address space of the dumped process, and where
+// its contents can be read from.
+type coreSegment struct {
+	addr uint64
+	size uint64
+	r    io.ReaderAt
+}
+
+// The core backend reads the state of a process from its core dump.
+// Nothing can be changed and the process can't be resumed; memory not
+// written to the core, such as the program text, is read from the
+// executable instead.
+type coreBackend struct {
+	core     *os.File
+	exe      *os.File
+	pid      int
+	segments []coreSegment
+	regs     map[int]*sys.PtraceRegs
+	auxvData []byte // From the NT_AUXV note
+}
+
+// Registers of a thread of a core dump, which can't be changed.
+type coreRegs struct {
+	Regs
+}
+
+func (r *coreRegs) SetPC(thread *ThreadContext, pc uint64) error {
+	return errCoreReadOnly
+}
+
+func (r *coreRegs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	return errCoreReadOnly
+}
+
+var errCoreReadOnly = fmt.Errorf("core files are read-only")
+
+// OpenCore opens the core dump at corePath of the program at exePath
+// for post-mortem debugging. The returned process can be inspected,
+// its stacks walked and its variables evaluated, but it can't be
+// resumed or changed in any way.
+func OpenCore(corePath, exePath string) (*DebuggedProcess, error) {
+	cb, tids, err := openCoreBackend(corePath, exePath)
+	if err != nil {
+		return nil, err
+	}
+
+	dbp := &DebuggedProcess{
+		Pid:         cb.pid,
+		Threads:     make(map[int]*ThreadContext),
+		BreakPoints: make(map[uint64]*BreakPoint),
+		os:          new(OSProcessDetails),
+		backend:     cb,
+		attached:    true,
+	}
+	dbp.startTiming()
+	if err := dbp.loadInformation(exePath); err != nil {
+		cb.close()
+		return nil, err
+	}
+	for _, tid := range tids {
+		dbp.Threads[tid] = &ThreadContext{Id: tid, Process: dbp}
+	}
+	// The first thread in the core is the one which received the
+	// signal that killed the process.
+	dbp.CurrentThread = dbp.Threads[tids[0]]
+	return dbp, nil
+}
+
+func openCoreBackend(corePath, exePath string) (*coreBackend, []int, error) {
+	core, err := os.Open(corePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	exe, err := os.Open(exePath)
+	if err != nil {
+		core.Close()
+		return nil, nil, err
+	}
+	cb := &coreBackend{core: core, exe: exe, regs: make(map[int]*sys.PtraceRegs)}
+
+	tids, err := cb.load()
+	if err != nil {
+		cb.close()
+		return nil, nil, err
+	}
+	return cb, tids, nil
+}
+
+// Reads the segments of the core and of the executable, and the
+// registers of every thread. Returns the thread ids in the order
+// they appear in the core.
+func (cb *coreBackend) load() ([]int, error) {
+	cf, err := elf.NewFile(cb.core)
+	if err != nil {
+		return nil, fmt.Errorf("could not read core file: %s", err)
+	}
+	if cf.Type != elf.ET_CORE {
+		return nil, fmt.Errorf("%s is not a core file", cb.core.Name())
+	}
+	if cf.Machine != elfMachine || cf.Class != elf.ELFCLASS64 {
+		return nil, fmt.Errorf("unsupported core file machine %s", cf.Machine)
+	}
+	ef, err := elf.NewFile(cb.exe)
+	if err != nil {
+		return nil, fmt.Errorf("could not read executable: %s", err)
+	}
+
+	var tids []int
+	for _, prog := range cf.Progs {
+		switch prog.Type {
+		case elf.PT_LOAD:
+			if prog.Filesz > 0 {
+				cb.segments = append(cb.segments, coreSegment{prog.Vaddr, prog.Filesz, prog.ReaderAt})
+			}
+		case elf.PT_NOTE:
+			t, err := cb.readNotes(prog.Open())
+			if err != nil {
+				return nil, err
+			}
+			tids = append(tids, t...)
+		}
+	}
+	if len(tids) == 0 {
+		return nil, fmt.Errorf("core file has no threads")
+	}
+	if cb.pid == 0 {
+		cb.pid = tids[0]
+	}
+
+	// Core segments take precedence, the executable
+	// only has the initial contents of its data.
+	var bias uint64
+	if entry, ok := auxvEntry(cb.auxvData, atEntry); ok && ef.Type == elf.ET_DYN {
+		bias = entry - ef.Entry
+	}
+	for _, prog := range ef.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Filesz > 0 {
+			cb.segments = append(cb.segments, coreSegment{prog.Vaddr + bias, prog.Filesz, prog.ReaderAt})
+		}
+	}
+	return tids, nil
+}
+
+// Reads the NT_PRSTATUS notes of a PT_NOTE segment, one per thread,
+// the pid from its NT_PRPSINFO note and the auxiliary vector from its
+// NT_AUXV note.
+func (cb *coreBackend) readNotes(r io.Reader) ([]int, error) {
+	var tids []int
+	for {
+		var hdr struct {
+			Namesz, Descsz, Type uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+			if err == io.EOF {
+				return tids, nil
+			}
+			return nil, fmt.Errorf("could not read core notes: %s", err)
+		}
+		// Name and descriptor are padded to 4 bytes.
+		buf := make([]byte, (hdr.Namesz+3)&^3+(hdr.Descsz+3)&^3)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("could not read core notes: %s", err)
+		}
+		desc := buf[(hdr.Namesz+3)&^3:][:hdr.Descsz]
+		if elf.NType(hdr.Type) == elf.NT_PRPSINFO && len(desc) >= prpsinfoPidOffset+4 {
+			cb.pid = int(binary.LittleEndian.Uint32(desc[prpsinfoPidOffset:]))
+		}
+		if hdr.Type == ntAuxv {
+			cb.auxvData = desc
+		}
+		if elf.NType(hdr.Type) != elf.NT_PRSTATUS {
+			continue
+		}
+		if len(desc) < prstatusRegsOffset+int(binary.Size(sys.PtraceRegs{})) {
+			return nil, fmt.Errorf("short NT_PRSTATUS note")
+		}
+		tid := int(binary.LittleEndian.Uint32(desc[prstatusPidOffset:]))
+		regs := new(sys.PtraceRegs)
+		binary.Read(bytes.NewReader(desc[prstatusRegsOffset:]), binary.LittleEndian, regs)
+		cb.regs[tid] = regs
+		tids = append(tids, tid)
+	}
+}
+
+func (cb *coreBackend) auxv(dbp *DebuggedProcess) ([]byte, error) {
+	if cb.auxvData == nil {
+		return nil, fmt.Errorf("core file has no auxiliary vector")
+	}
+	return cb.auxvData, nil
+}
+
+func (cb *coreBackend) close() {
+	cb.core.Close()
+	cb.exe.Close()
+}
+
+func (cb *coreBackend) registers(thread *ThreadContext) (Registers, error) {
+	regs, ok := cb.regs[thread.Id]
+	if !ok {
+		return nil, fmt.Errorf("no registers for thread %d in core file", thread.Id)
+	}
+	return &coreRegs{Regs{regs}}, nil
+}
+
+func (cb *coreBackend) readMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	var n int
+	for n < len(data) {
+		a := uint64(addr) + uint64(n)
+		seg := cb.segment(a)
+		if seg == nil {
+			return n, fmt.Errorf("could not read memory at %#x: not in core file", a)
+		}
+		end := len(data)
+		if rem := seg.addr + seg.size - a; rem < uint64(end-n) {
+			end = n + int(rem)
+		}
+		m, err := seg.r.ReadAt(data[n:end], int64(a-seg.addr))
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Returns the first segment containing addr.
+func (cb *coreBackend) segment(addr uint64) *coreSegment {
+	for i := range cb.segments {
+		if s := &cb.segments[i]; addr >= s.addr && addr < s.addr+s.size {
+			return s
+		}
+	}
+	return nil
+}
+
+func (cb *coreBackend) writeMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	return 0, errCoreReadOnly
+}
+
+func (cb *coreBackend) setSoftwareBreakpoint(thread *ThreadContext, addr uint64) ([]byte, error) {
+	return nil, errCoreReadOnly
+}
+
+func (cb *coreBackend) clearSoftwareBreakpoint(thread *ThreadContext, addr uint64, originalData []byte) error {
+	return errCoreReadOnly
+}
+
+func (cb *coreBackend) setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	return errCoreReadOnly
+}
+
+// There are no breakpoints to clear, this lets Detach succeed.
+func (cb *coreBackend) clearHardwareBreakpoint(reg, tid int) error {
+	return nil
+}
+
+func (cb *coreBackend) setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	return errCoreReadOnly
+}
+
+func (cb *coreBackend) hardwareBreakpointHit(thread *ThreadContext) (int, error) {
+	return -1, nil
+}
+
+func (cb *coreBackend) resume(thread *ThreadContext) error {
+	return fmt.Errorf("a process can't be resumed from a core file")
+}
+
+func (cb *coreBackend) singleStep(thread *ThreadContext) error {
+	return fmt.Errorf("a process can't be stepped from a core file")
+}
+
+func (cb *coreBackend) halt(thread *ThreadContext) error {
+	return nil
+}
+
+func (cb *coreBackend) interrupt(dbp *DebuggedProcess) error {
+	return nil
+}
+
+func (cb *coreBackend) trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error) {
+	return 0, fmt.Errorf("a process can't be resumed from a core file")
+}
+
+func (cb *coreBackend) detach(dbp *DebuggedProcess) error {
+	cb.close()
+	return nil
+}
+
+func (cb *coreBackend) info() BackendInfo {
+	return BackendInfo{Name: "core"}
+}
@@ -0,0 +1,180 @@
+package proctl
+
+import (
+	"fmt"
+	"time"
+)
+
+// Maximum number of frames recorded of the stack creating a goroutine.
+const maxCreationDepth = 30
+
+// The creation of a goroutine, as seen by a breakpoint set with
+// BreakOnGoroutineCreation.
+type GoroutineCreation struct {
+	Creator  int    // ID of the goroutine executing the go statement, 0 if none, as for the main goroutine
+	PC       uint64 // Return address of the go statement
+	Entry    uint64 // Of the function the new goroutine starts with
+	Function string
+	Stack    []Frame // Of the creator, innermost frame first
+	Time     time.Time
+}
+
+func (gc *GoroutineCreation) String() string {
+	where := fmt.Sprintf("%#x", gc.PC)
+	if len(gc.Stack) > 0 {
+		where = fmt.Sprintf("%s:%d", gc.Stack[0].File, gc.Stack[0].Line)
+	}
+	return fmt.Sprintf("goroutine %d created %s at %s", gc.Creator, gc.Function, where)
+}
+
+// Sets a breakpoint on the creation of goroutines, recording which
+// goroutine created them, where, and with which function, see
+// GoroutineCreator. With trace set the creations are printed, as for
+// tracepoints, rather than stopping the process.
+func (dbp *DebuggedProcess) BreakOnGoroutineCreation(trace bool) (*BreakPoint, error) {
+	// Rather than at runtime.newproc, which is entered again whenever
+	// its goroutine is preempted in the prologue, at the function it
+	// calls on the system stack with the creator and go statement.
+	fn := dbp.lookupFunc("runtime.newproc1")
+	if fn == nil {
+		return nil, UnknownEventError{"goroutine-create"}
+	}
+	bp, err := dbp.Break(fn.Entry)
+	if err != nil {
+		return nil, err
+	}
+	bp.Creations = true
+	bp.Tracepoint = trace
+	return bp, nil
+}
+
+// Returns the creations recorded so far, oldest first.
+func (dbp *DebuggedProcess) GoroutineCreations() []*GoroutineCreation {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	creations := make([]*GoroutineCreation, len(dbp.creations))
+	copy(creations, dbp.creations)
+	return creations
+}
+
+// Returns how the goroutine with the given id was created. The latest
+// recorded creation matching its creator, go statement and function
+// is returned, or else only what the runtime keeps of it, without the
+// stack of the creator.
+func (dbp *DebuggedProcess) GoroutineCreator(id int) (*GoroutineCreation, error) {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+	var g *G
+	for _, gr := range goroutines {
+		if gr.Id == id && gr.status != gstatusDead {
+			g = gr
+		}
+	}
+	if g == nil {
+		return nil, fmt.Errorf("no goroutine with id %d", id)
+	}
+	if g.gopc == 0 {
+		return nil, fmt.Errorf("the runtime did not record the creator of goroutine %d", id)
+	}
+
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	for i := len(dbp.creations) - 1; i >= 0; i-- {
+		gc := dbp.creations[i]
+		if gc.Entry == g.startpc && gc.PC == g.gopc && (g.parentGoid == 0 || gc.Creator == int(g.parentGoid)) {
+			return gc, nil
+		}
+	}
+
+	gc := &GoroutineCreation{Creator: int(g.parentGoid), PC: g.gopc, Entry: g.startpc}
+	if fn := dbp.GoSymTable.PCToFunc(g.startpc); fn != nil {
+		gc.Function = fn.Name
+	}
+	f, l, fn := dbp.GoSymTable.PCToLine(g.gopc - 1)
+	frame := Frame{PC: g.gopc, File: f, Line: l}
+	if fn != nil {
+		frame.Function = fn.Name
+	}
+	gc.Stack = []Frame{frame}
+	return gc, nil
+}
+
+// Records the creation of a goroutine by thread, stopped at the entry
+// of runtime.newproc1(fn *funcval, callergp *g, callerpc uintptr).
+func (dbp *DebuggedProcess) recordCreation(thread *ThreadContext) (*GoroutineCreation, error) {
+	args, err := thread.entryArguments(3)
+	if err != nil {
+		return nil, err
+	}
+	fnval, callergp, callerpc := args[0], args[1], args[2]
+	// The function value starts with the code pointer.
+	entry, err := dbp.readPointer(fnval)
+	if err != nil {
+		return nil, fmt.Errorf("could not read function value %#x: %s", fnval, err)
+	}
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+	goid, err := dbp.readUintField(callergp, gtype, "goid")
+	if err != nil {
+		return nil, err
+	}
+
+	gc := &GoroutineCreation{Creator: int(goid), PC: callerpc, Entry: entry, Time: time.Now()}
+	if fn := dbp.GoSymTable.PCToFunc(entry); fn != nil {
+		gc.Function = fn.Name
+	}
+	// The creator is parked while newproc1 runs on the system stack,
+	// its innermost frames being in runtime.newproc.
+	if goid != 0 {
+		frames, _ := dbp.goroutineStack(int(goid), maxCreationDepth)
+		for len(frames) > 0 && frames[0].pc != callerpc {
+			frames = frames[1:]
+		}
+		for _, sf := range frames {
+			f := Frame{PC: sf.pc}
+			f.File, f.Line, _ = dbp.GoSymTable.PCToLine(sf.pc - 1)
+			if sf.fn != nil {
+				f.Function = sf.fn.Name
+			}
+			gc.Stack = append(gc.Stack, f)
+		}
+	}
+
+	dbp.mu.Lock()
+	dbp.creations = append(dbp.creations, gc)
+	dbp.mu.Unlock()
+	return gc, nil
+}
+
+// Returns the first n integer arguments of the function thread is
+// stopped at the entry of.
+func (thread *ThreadContext) entryArguments(n int) ([]uint64, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	args := make([]uint64, n)
+	if argRegisters == nil {
+		for i := range args {
+			if args[i], err = thread.Process.readPointer(regs.SP() + uint64(i+1)*uint64(ptrsize)); err != nil {
+				return nil, err
+			}
+		}
+		return args, nil
+	}
+	if n > len(argRegisters) {
+		return nil, fmt.Errorf("only %d arguments are passed in registers", len(argRegisters))
+	}
+	for _, r := range regs.Slice() {
+		for i, name := range argRegisters[:n] {
+			if registerName(r.Name) == name {
+				args[i] = r.Value
+			}
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,218 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Maximum number of frames to unwind looking for
+// the blocking operation on a goroutine's stack.
+const maxWaitDepth = 15
+
+// Functions that block a goroutine on a resource, mapped to the kind
+// of the resource and the argument of the function pointing to it.
+var waitFuncs = map[string]struct{ kind, arg string }{
+	"runtime.chansend":       {"chan send", "c"},
+	"runtime.chanrecv":       {"chan recv", "c"},
+	"sync.(*Mutex).Lock":     {"mutex", "m"},
+	"sync.(*Mutex).lockSlow": {"mutex", "m"},
+	// Since Go 1.24 sync.Mutex wraps, at offset 0, the one of
+	// internal/sync, and its Lock is inlined or out of line.
+	"internal/sync.(*Mutex).lockSlow": {"mutex", "m"},
+	"sync.(*RWMutex).Lock":            {"rwmutex", "rw"},
+	"sync.(*RWMutex).RLock":           {"rwmutex", "rw"},
+	"sync.(*WaitGroup).Wait":          {"waitgroup", "wg"},
+	"sync.(*Cond).Wait":               {"cond", "c"},
+	"runtime.selectgo":                {"select", ""},
+	"runtime.selectgoImpl":            {"select", ""},
+	"runtime.block":                   {"forever", ""},
+}
+
+// A resource a goroutine is blocked on.
+type WaitResource struct {
+	Kind string // "chan send", "chan recv", "mutex", "rwmutex", "waitgroup", "cond" or "forever"
+	Addr uint64 // Address of the channel or sync primitive, 0 for a nil channel
+}
+
+func (wr WaitResource) String() string {
+	if wr.Kind == "forever" {
+		return "select with no cases"
+	}
+	if wr.Addr == 0 {
+		return wr.Kind + " on nil channel"
+	}
+	return fmt.Sprintf("%s %#x", wr.Kind, wr.Addr)
+}
+
+// A goroutine blocked on one or more resources,
+// more than one when it is blocked in a select.
+type BlockedGoroutine struct {
+	G     *G
+	Waits []WaitResource
+}
+
+// Returns true if nothing can ever unblock the goroutine, because it
+// is only waiting on nil channels or in a select without cases.
+func (bg *BlockedGoroutine) Forever() bool {
+	for _, w := range bg.Waits {
+		if w.Kind != "forever" && w.Addr != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// The result of cross-referencing what the goroutines
+// of a process are waiting on.
+type DeadlockReport struct {
+	// Set when none of the user goroutines can make progress,
+	// meaning the blocked goroutines are waiting on each other.
+	Deadlocked bool
+	// Goroutines blocked on channels or sync primitives.
+	Blocked []*BlockedGoroutine
+	// Goroutines which are running, runnable, or waiting
+	// on something that may eventually wake them up.
+	Progressing []*G
+}
+
+// Returns the goroutines blocked on channels and sync primitives,
+// grouping them by the resource they wait on and checking whether
+// any user goroutine is left that could wake them up.
+func (dbp *DebuggedProcess) Deadlocks() (*DeadlockReport, error) {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DeadlockReport{}
+	for _, g := range goroutines {
+		if g.status == gstatusDead || g.status == gstatusIdle || dbp.systemGoroutine(g) {
+			continue
+		}
+		if g.status != gstatusWaiting {
+			report.Progressing = append(report.Progressing, g)
+			continue
+		}
+
+		waits, err := dbp.waitResources(g)
+		if err != nil || len(waits) == 0 {
+			// Sleeping, doing network I/O or parked for
+			// a reason we don't understand, assume the
+			// goroutine will wake up eventually.
+			report.Progressing = append(report.Progressing, g)
+			continue
+		}
+		report.Blocked = append(report.Blocked, &BlockedGoroutine{G: g, Waits: waits})
+	}
+	report.Deadlocked = len(report.Blocked) > 0 && len(report.Progressing) == 0
+
+	return report, nil
+}
+
+// Returns the resources the blocked goroutines wait on, sorted, and
+// the ids of the goroutines waiting on each of them, so that
+// contention on a single one stands out.
+func (r *DeadlockReport) Waiters() ([]WaitResource, map[WaitResource][]int) {
+	waiters := make(map[WaitResource][]int)
+	var resources []WaitResource
+	for _, bg := range r.Blocked {
+		for _, w := range bg.Waits {
+			if _, ok := waiters[w]; !ok {
+				resources = append(resources, w)
+			}
+			waiters[w] = append(waiters[w], bg.G.Id)
+		}
+	}
+	sort.Sort(byResource(resources))
+	return resources, waiters
+}
+
+// Returns the resources g is blocked on, found by looking
+// for a blocking operation among its innermost frames.
+func (dbp *DebuggedProcess) waitResources(g *G) ([]WaitResource, error) {
+	frames, err := dbp.stacktrace(g.PC, g.SP, nil, maxWaitDepth)
+	// Its innermost frames are enough, if the outer ones can't be unwound.
+	if err != nil && len(frames) == 0 {
+		return nil, err
+	}
+
+	for _, frame := range frames {
+		if frame.fn == nil {
+			continue
+		}
+		name := frame.fn.Name
+		// chansend1, chanrecv1 and chanrecv2 are
+		// thin wrappers of chansend and chanrecv.
+		if strings.HasPrefix(name, "runtime.chansend") {
+			name = "runtime.chansend"
+		} else if strings.HasPrefix(name, "runtime.chanrecv") {
+			name = "runtime.chanrecv"
+		}
+
+		wf, ok := waitFuncs[name]
+		if !ok {
+			// The blocking operation is
+			// always close to the top.
+			if strings.HasPrefix(name, "runtime.") || strings.HasPrefix(name, "sync.") || strings.HasPrefix(name, "internal/sync.") {
+				continue
+			}
+			return nil, nil
+		}
+
+		switch wf.kind {
+		case "forever":
+			return []WaitResource{{Kind: wf.kind}}, nil
+		case "select":
+			cases, err := dbp.SelectCases(g)
+			if err != nil {
+				return nil, err
+			}
+			var waits []WaitResource
+			for _, c := range cases {
+				if c.Direction == "default" {
+					return nil, nil
+				}
+				waits = append(waits, WaitResource{Kind: "chan " + c.Direction, Addr: c.Chan})
+			}
+			if len(waits) == 0 {
+				return []WaitResource{{Kind: "forever"}}, nil
+			}
+			return waits, nil
+		}
+
+		// Operations on nil channels park before the
+		// channel is of any use, it needn't be at hand.
+		if strings.HasSuffix(g.waitreason, "(nil chan)") {
+			return []WaitResource{{Kind: wf.kind}}, nil
+		}
+		addr, err := dbp.framePointer(frame, wf.arg)
+		if err != nil {
+			return nil, err
+		}
+		return []WaitResource{{Kind: wf.kind, Addr: addr}}, nil
+	}
+
+	return nil, nil
+}
+
+// Returns true if g was started by the runtime for its own
+// purposes, such as the garbage collector or finalizers.
+func (dbp *DebuggedProcess) systemGoroutine(g *G) bool {
+	if g.startpc == 0 {
+		return false
+	}
+	fn := dbp.GoSymTable.PCToFunc(g.startpc)
+	return fn != nil && fn.Name != "runtime.main" && strings.HasPrefix(fn.Name, "runtime.")
+}
+
+type byResource []WaitResource
+
+func (r byResource) Len() int      { return len(r) }
+func (r byResource) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r byResource) Less(i, j int) bool {
+	if r[i].Kind != r[j].Kind {
+		return r[i].Kind < r[j].Kind
+	}
+	return r[i].Addr < r[j].Addr
+}
@@ -0,0 +1,115 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directories the debug packages of distributions install the
+// separate debug information files of stripped binaries in.
+var debugInfoDirs = []string{"/usr/lib/debug"}
+
+// Returns whether exe carries its own debug information.
+func hasDebugInfo(exe *elf.File) bool {
+	return exe.Section(".debug_info") != nil || exe.Section(".zdebug_info") != nil
+}
+
+// Opens the separate file holding the debug information of the
+// stripped executable at path, found by the build ID of exe or the
+// name and checksum in its .gnu_debuglink section, as gdb does. Files
+// are looked for under root first, the root of the file system of the
+// process when it runs in another mount namespace, e.g. a container,
+// and then in ours.
+func openDebugInfo(exe *elf.File, path, root string) (*elf.File, error) {
+	roots := []string{"/"}
+	if root != "" {
+		roots = []string{root, "/"}
+	}
+	if id := gnuBuildID(exe); len(id) > 1 {
+		name := hex.EncodeToString(id)
+		for _, r := range roots {
+			for _, dir := range debugInfoDirs {
+				file := filepath.Join(r, dir, ".build-id", name[:2], name[2:]+".debug")
+				if f, err := elf.Open(file); err == nil {
+					return f, nil
+				}
+			}
+		}
+	}
+
+	name, crc, ok := gnuDebugLink(exe)
+	if !ok {
+		return nil, fmt.Errorf("%s has no debug information", path)
+	}
+	// The link of /proc/<pid>/exe is the path of the executable
+	// in the file system of the process.
+	real, err := os.Readlink(path)
+	if err != nil || !filepath.IsAbs(real) {
+		real = path
+	}
+	if root != "" {
+		real = filepath.Join(root, real)
+	}
+	if resolved, err := filepath.EvalSymlinks(real); err == nil {
+		real = resolved
+	}
+	dir := filepath.Dir(real)
+	candidates := []string{filepath.Join(dir, name), filepath.Join(dir, ".debug", name)}
+	for _, r := range roots {
+		for _, d := range debugInfoDirs {
+			candidates = append(candidates, filepath.Join(r, d, strings.TrimPrefix(dir, root), name))
+		}
+	}
+	for _, file := range candidates {
+		if file == real {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil || crc32.ChecksumIEEE(data) != crc {
+			continue
+		}
+		if f, err := elf.NewFile(bytes.NewReader(data)); err == nil {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find debug information file %s of %s", name, path)
+}
+
+// Returns the build ID in the .note.gnu.build-id section of exe, nil
+// if it has none.
+func gnuBuildID(exe *elf.File) []byte {
+	data := debugSection(exe, ".note.gnu.build-id")
+	if len(data) < 12 {
+		return nil
+	}
+	namesz := binary.LittleEndian.Uint32(data)
+	descsz := binary.LittleEndian.Uint32(data[4:])
+	off := 12 + (namesz+3)&^3
+	if uint64(off)+uint64(descsz) > uint64(len(data)) {
+		return nil
+	}
+	return data[off : off+descsz]
+}
+
+// Returns the name and CRC32 checksum of the debug information file
+// in the .gnu_debuglink section of exe.
+func gnuDebugLink(exe *elf.File) (string, uint32, bool) {
+	data := debugSection(exe, ".gnu_debuglink")
+	n := bytes.IndexByte(data, 0)
+	if n <= 0 {
+		return "", 0, false
+	}
+	off := (n + 4) &^ 3
+	if off+4 > len(data) {
+		return "", 0, false
+	}
+	return string(data[:n]), binary.LittleEndian.Uint32(data[off:]), true
+}
@@ -0,0 +1,171 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// Bound on the length of the defer and panic chains, in case a
+// corrupted link makes a cycle.
+const maxDeferChain = 10000
+
+// A call deferred by a goroutine which hasn't run yet, see Defers.
+type Defer struct {
+	PC       uint64 // Where the defer statement returns to
+	SP       uint64 // Of the frame which deferred the call
+	File     string // Of the defer statement
+	Line     int
+	Function string // Called by the defer
+	addr     uint64
+}
+
+func (d Defer) String() string {
+	return fmt.Sprintf("%s deferred at %s:%d", d.Function, d.File, d.Line)
+}
+
+// A panic in flight on a goroutine, see Panics.
+type Panic struct {
+	Value     string
+	Recovered bool // Whether a deferred call recovered it
+	Goexit    bool // Whether it is a call to runtime.Goexit
+}
+
+func (p Panic) String() string {
+	switch {
+	case p.Goexit:
+		return "runtime.Goexit"
+	case p.Recovered:
+		return fmt.Sprintf("panic(%s) (recovered)", p.Value)
+	}
+	return fmt.Sprintf("panic(%s)", p.Value)
+}
+
+// Returns the calls deferred by the goroutine of the thread, which run
+// next first, from the runtime._defer list of the goroutine. Defers
+// the compiler open coded in the function deferring them, which it
+// does for optimized functions without defers in loops, are only
+// known to their frame and not listed.
+func (thread *ThreadContext) Defers() ([]Defer, error) {
+	g, gtype, err := thread.currentG()
+	if err != nil {
+		return nil, err
+	}
+	dtype, err := thread.Process.findStructType("runtime._defer")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := thread.Process.readUintField(g.addr, gtype, "_defer")
+	if err != nil {
+		return nil, err
+	}
+
+	var defers []Defer
+	for addr != 0 && len(defers) < maxDeferChain {
+		d := Defer{addr: addr}
+		if d.PC, err = thread.Process.readUintField(addr, dtype, "pc"); err != nil {
+			return nil, err
+		}
+		if d.SP, err = thread.Process.readUintField(addr, dtype, "sp"); err != nil {
+			return nil, err
+		}
+		d.File, d.Line, _ = thread.Process.GoSymTable.PCToLine(d.PC)
+		// fn is a *funcval, whose first word is the code pointer.
+		if fv, _ := thread.Process.readUintField(addr, dtype, "fn"); fv != 0 {
+			if pc, err := thread.Process.readPointer(fv); err == nil {
+				if fn := thread.Process.GoSymTable.PCToFunc(pc); fn != nil {
+					d.Function = fn.Name
+				}
+			}
+		}
+		defers = append(defers, d)
+		if addr, err = thread.Process.readUintField(addr, dtype, "link"); err != nil {
+			return nil, err
+		}
+	}
+	return defers, nil
+}
+
+// Returns the call deferred by the current function of the thread which
+// runs first, as it returns or panics, or nil if it deferred none.
+func (thread *ThreadContext) frameDefer() (*Defer, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := thread.Process.stacktrace(regs.PC(), regs.SP(), regs, 1)
+	if len(frames) == 0 {
+		return nil, err
+	}
+	defers, err := thread.Defers()
+	if err != nil {
+		return nil, err
+	}
+	// Those of the callers, deferred by frames above the
+	// current one, come after those of the current function.
+	if len(defers) == 0 || defers[0].SP >= frames[0].cfa {
+		return nil, nil
+	}
+	return &defers[0], nil
+}
+
+// Returns whether the call deferred by d is still to run.
+func (thread *ThreadContext) deferPending(d *Defer) (bool, error) {
+	defers, err := thread.Defers()
+	if err != nil {
+		return false, err
+	}
+	for _, pd := range defers {
+		if pd.addr == d.addr {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the panics in flight on the goroutine of the thread, from
+// the runtime._panic list of the goroutine. The innermost panic, raised
+// last, comes first.
+func (thread *ThreadContext) Panics() ([]Panic, error) {
+	g, gtype, err := thread.currentG()
+	if err != nil {
+		return nil, err
+	}
+	ptype, err := thread.Process.findStructType("runtime._panic")
+	if err != nil {
+		return nil, err
+	}
+	arg, err := structField(ptype, "arg")
+	if err != nil {
+		return nil, err
+	}
+	addr, err := thread.Process.readUintField(g.addr, gtype, "_panic")
+	if err != nil {
+		return nil, err
+	}
+
+	var panics []Panic
+	for addr != 0 && len(panics) < maxDeferChain {
+		p := Panic{Value: thread.panicValue(addr + uint64(arg.ByteOffset))}
+		recovered, _ := thread.Process.readUintField(addr, ptype, "recovered")
+		goexit, _ := thread.Process.readUintField(addr, ptype, "goexit")
+		p.Recovered, p.Goexit = recovered != 0, goexit != 0
+		panics = append(panics, p)
+		if addr, err = thread.Process.readUintField(addr, ptype, "link"); err != nil {
+			return nil, err
+		}
+	}
+	return panics, nil
+}
+
+// Returns the goroutine of the thread, and the type of its runtime.g.
+func (thread *ThreadContext) currentG() (*G, *dwarf.StructType, error) {
+	g, err := thread.CurrentGoroutine()
+	if err != nil {
+		return nil, nil, err
+	}
+	gtype, err := thread.Process.findStructType("runtime.g")
+	if err != nil {
+		return nil, nil, err
+	}
+	return g, gtype, nil
+}
@@ -0,0 +1,257 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Version of the debugger.
+const Version = "0.5.0.beta"
+
+// Marks the build ID the Go linker writes at the start of the text.
+var buildIDPrefix = []byte("\xff Go build ID: \"")
+
+// Information about the debugger, the binary being debugged and the
+// backend controlling it, meant to be attached to bug reports.
+type Diagnostics struct {
+	Version   string // Version of the debugger
+	GoVersion string // Version of Go the debugger was built with
+	OS, Arch  string // Platform the debugger runs on
+
+	Binary  BinaryInfo
+	Backend *BackendInfo // nil when there is no process
+	Pid     int
+	Threads int
+	Exited  bool
+
+	// Parts of the report that could not be collected, and why.
+	Errors []string
+}
+
+// Information about a binary, read from its file.
+type BinaryInfo struct {
+	Path      string
+	Format    string // "elf" or "macho"
+	Arch      string
+	BuildID   string
+	GoVersion string // Version of Go the binary was built with
+	Sections  []SectionInfo
+}
+
+// Size of a section of a binary.
+type SectionInfo struct {
+	Name string
+	Size uint64
+}
+
+// What a backend supports.
+type BackendInfo struct {
+	Name                string
+	HardwareBreakpoints bool
+	Watchpoints         bool
+	WriteMemory         bool
+	Reverse             bool
+}
+
+// A section of a binary, as needed to read variables from the file.
+type binarySection struct {
+	SectionInfo
+	addr uint64
+	r    io.ReaderAt
+	bss  bool
+}
+
+// Collects diagnostics about the binary at path. It doesn't need a
+// running process, so it can be used when launching or attaching fails.
+func DiagnoseBinary(path string) *Diagnostics {
+	d := &Diagnostics{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Binary:    BinaryInfo{Path: path},
+	}
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		d.Binary.Path = p
+	}
+
+	sections, data, closer, err := d.openBinary(path)
+	if err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("could not read binary: %s", err))
+		return d
+	}
+	defer closer.Close()
+	for _, s := range sections {
+		d.Binary.Sections = append(d.Binary.Sections, s.SectionInfo)
+	}
+	for _, s := range sections {
+		switch s.Name {
+		case ".note.go.buildid":
+			d.Binary.BuildID = noteBuildID(s.r)
+		case ".text", "__text":
+			if d.Binary.BuildID == "" {
+				d.Binary.BuildID = buildID(s.r)
+			}
+		}
+	}
+
+	if data == nil {
+		d.Errors = append(d.Errors, "binary has no debug information")
+		return d
+	}
+	if d.Binary.GoVersion, err = fileGoVersion(data, sections); err != nil {
+		d.Errors = append(d.Errors, fmt.Sprintf("could not read Go version of binary: %s", err))
+	}
+	return d
+}
+
+// Collects diagnostics about the process and the binary it runs.
+func (dbp *DebuggedProcess) Diagnostics() *Diagnostics {
+	d := DiagnoseBinary(dbp.path)
+	info := dbp.backend.info()
+	d.Backend = &info
+	d.Pid = dbp.Pid
+	d.Exited = dbp.Exited()
+	dbp.mu.RLock()
+	d.Threads = len(dbp.Threads)
+	dbp.mu.RUnlock()
+	return d
+}
+
+func (d *Diagnostics) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Delve version: %s (%s, %s/%s)\n", d.Version, d.GoVersion, d.OS, d.Arch)
+	fmt.Fprintf(&buf, "Binary: %s\n", d.Binary.Path)
+	fmt.Fprintf(&buf, "\tformat: %s, arch: %s\n", d.Binary.Format, d.Binary.Arch)
+	fmt.Fprintf(&buf, "\tbuild id: %s\n", d.Binary.BuildID)
+	fmt.Fprintf(&buf, "\tgo version: %s\n", d.Binary.GoVersion)
+	fmt.Fprintf(&buf, "\tsections:\n")
+	for _, s := range d.Binary.Sections {
+		fmt.Fprintf(&buf, "\t\t%-20s %d\n", s.Name, s.Size)
+	}
+	if d.Backend != nil {
+		b := d.Backend
+		fmt.Fprintf(&buf, "Process: %d, threads: %d, exited: %t\n", d.Pid, d.Threads, d.Exited)
+		fmt.Fprintf(&buf, "Backend: %s\n", b.Name)
+		fmt.Fprintf(&buf, "\thardware breakpoints: %t, watchpoints: %t, write memory: %t, reverse: %t\n",
+			b.HardwareBreakpoints, b.Watchpoints, b.WriteMemory, b.Reverse)
+	}
+	if len(d.Errors) > 0 {
+		fmt.Fprintf(&buf, "Errors:\n\t%s\n", strings.Join(d.Errors, "\n\t"))
+	}
+	return buf.String()
+}
+
+// Opens the ELF or Mach-O binary at path, filling in its format and
+// architecture. Returns its sections, its debug information, nil if it
+// has none, and the file to close once done reading them.
+func (d *Diagnostics) openBinary(path string) ([]binarySection, *dwarf.Data, io.Closer, error) {
+	var sections []binarySection
+	if f, err := elf.Open(path); err == nil {
+		d.Binary.Format = "elf"
+		d.Binary.Arch = f.Machine.String()
+		for _, s := range f.Sections {
+			if s.Name == "" || s.Size == 0 {
+				continue
+			}
+			sections = append(sections, binarySection{SectionInfo{s.Name, s.Size}, s.Addr, s, s.Type == elf.SHT_NOBITS})
+		}
+		data, _ := f.DWARF()
+		return sections, data, f, nil
+	}
+
+	f, err := macho.Open(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not an ELF or Mach-O file")
+	}
+	d.Binary.Format = "macho"
+	d.Binary.Arch = f.Cpu.String()
+	for _, s := range f.Sections {
+		if s.Size == 0 {
+			continue
+		}
+		sections = append(sections, binarySection{SectionInfo{s.Name, s.Size}, s.Addr, s, s.Name == "__bss" || s.Name == "__noptrbss"})
+	}
+	data, _ := f.DWARF()
+	return sections, data, f, nil
+}
+
+// Returns the Go build ID found at the start of text, if any.
+func buildID(text io.ReaderAt) string {
+	buf := make([]byte, 256)
+	n, _ := text.ReadAt(buf, 0)
+	buf = buf[:n]
+	i := bytes.Index(buf, buildIDPrefix)
+	if i < 0 {
+		return ""
+	}
+	buf = buf[i+len(buildIDPrefix):]
+	if j := bytes.IndexByte(buf, '"'); j >= 0 {
+		return string(buf[:j])
+	}
+	return ""
+}
+
+// Returns the Go build ID stored in the ELF note r: a header
+// holding the sizes of the name and of the ID, and the note
+// type, followed by the name "Go" and the ID.
+func noteBuildID(r io.ReaderAt) string {
+	buf := make([]byte, 256)
+	n, _ := r.ReadAt(buf, 0)
+	if n < 16 {
+		return ""
+	}
+	size := int(binary.LittleEndian.Uint32(buf[4:]))
+	if 16+size > n || string(buf[12:14]) != "Go" {
+		return ""
+	}
+	return string(buf[16 : 16+size])
+}
+
+// Reads runtime.buildVersion from the data of the binary.
+func fileGoVersion(data *dwarf.Data, sections []binarySection) (string, error) {
+	addr, _, err := (&DebuggedProcess{Dwarf: data}).globalVariable("runtime.buildVersion")
+	if err != nil {
+		return "", err
+	}
+	hdr, err := readSections(sections, addr, int(2*ptrsize))
+	if err != nil {
+		return "", err
+	}
+	str := decodePointer(hdr)
+	length := decodePointer(hdr[ptrsize:])
+	if length > 256 {
+		return "", fmt.Errorf("invalid version length %d", length)
+	}
+	val, err := readSections(sections, str, int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(val), nil
+}
+
+// Reads size bytes at addr from the section holding them.
+func readSections(sections []binarySection, addr uint64, size int) ([]byte, error) {
+	for _, s := range sections {
+		if s.addr == 0 || addr < s.addr || addr+uint64(size) > s.addr+s.Size {
+			continue
+		}
+		if s.bss {
+			return make([]byte, size), nil
+		}
+		buf := make([]byte, size)
+		if _, err := s.r.ReadAt(buf, int64(addr-s.addr)); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("address %#x is not in any section", addr)
+}
@@ -0,0 +1,53 @@
+package proctl
+
+import "fmt"
+
+// An instruction of the machine code of the process.
+type Instruction struct {
+	Addr uint64
+	Len  int
+	Text string // In Go assembler syntax, ? where it can't be decoded
+}
+
+func (inst Instruction) String() string {
+	return fmt.Sprintf("%#x: %s", inst.Addr, inst.Text)
+}
+
+// Decodes the machine code of the process from addr up to addr+size,
+// e.g. where there is no source to show. Software breakpoints are
+// shown as the instructions they were written over, and the addresses
+// instructions refer to as the functions they are in.
+func (dbp *DebuggedProcess) Disassemble(addr, size uint64) ([]Instruction, error) {
+	code, err := dbp.CurrentThread.readMemory(uintptr(addr), uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+	dbp.mu.RLock()
+	for _, bp := range dbp.BreakPoints {
+		for i, b := range bp.OriginalData {
+			if a := bp.Addr + uint64(i); a >= addr && a < addr+size {
+				code[a-addr] = b
+			}
+		}
+	}
+	dbp.mu.RUnlock()
+
+	symname := func(a uint64) (string, uint64) {
+		if fn := dbp.GoSymTable.PCToFunc(a); fn != nil {
+			return fn.Name, fn.Entry
+		}
+		return "", 0
+	}
+	var insts []Instruction
+	for off := 0; off < len(code); {
+		pc := addr + uint64(off)
+		text, n, err := decodeInstruction(code[off:], pc, symname)
+		if err != nil {
+			// Undecodable bytes are skipped one at a time.
+			text, n = "?", 1
+		}
+		insts = append(insts, Instruction{Addr: pc, Len: n, Text: text})
+		off += n
+	}
+	return insts, nil
+}
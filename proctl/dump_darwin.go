@@ -0,0 +1,8 @@
+package proctl
+
+import "fmt"
+
+// Dump is not supported, core files are only written on linux.
+func (dbp *DebuggedProcess) Dump(path string) error {
+	return fmt.Errorf("dumping core files is not supported on darwin")
+}
@@ -0,0 +1,223 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Size of the struct elf_prpsinfo of a NT_PRPSINFO note, the
+	// size of the struct elf_prstatus depends on the architecture.
+	prpsinfoSize = 136
+	// Offset of pr_fname in struct elf_prpsinfo.
+	prpsinfoFnameOffset = 40
+	// Memory is copied to the core in chunks of this size.
+	dumpChunkSize = 1 << 20
+)
+
+// Dump writes an ELF core file of the stopped process to path,
+// holding its readable memory mappings and the registers of all its
+// threads, the current thread first. It can be opened with OpenCore
+// to analyze the state of the process after it is gone. Parts of the
+// mappings that can't be read are written as zeros.
+func (dbp *DebuggedProcess) Dump(path string) error {
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
+	if dbp.Running() {
+		return fmt.Errorf("the process must be stopped to be dumped")
+	}
+	if name := dbp.backend.info().Name; name != "native" {
+		return fmt.Errorf("only live processes can be dumped, not %s targets", name)
+	}
+	if ptrsize != 8 {
+		return fmt.Errorf("core files can only be written on 64 bit architectures")
+	}
+
+	mappings, err := dbp.readableMappings()
+	if err != nil {
+		return err
+	}
+	notes, err := dbp.coreNotes()
+	if err != nil {
+		return err
+	}
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", dbp.Pid))
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeCore(f, mem, mappings, notes); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// Returns the readable regions of the address space of the process.
+func (dbp *DebuggedProcess) readableMappings() ([]MemoryRegion, error) {
+	regions, err := dbp.MemoryMaps()
+	if err != nil {
+		return nil, err
+	}
+	var mappings []MemoryRegion
+	for _, r := range regions {
+		if r.Perm&MemoryRead != 0 {
+			mappings = append(mappings, r)
+		}
+	}
+	return mappings, nil
+}
+
+// Returns the flags of a PT_LOAD segment with the given permissions.
+func progFlags(p MemoryPerm) elf.ProgFlag {
+	var flags elf.ProgFlag
+	if p&MemoryRead != 0 {
+		flags |= elf.PF_R
+	}
+	if p&MemoryWrite != 0 {
+		flags |= elf.PF_W
+	}
+	if p&MemoryExec != 0 {
+		flags |= elf.PF_X
+	}
+	return flags
+}
+
+// Returns the contents of the PT_NOTE segment describing the process:
+// a NT_PRPSINFO note and a NT_PRSTATUS note for each thread.
+func (dbp *DebuggedProcess) coreNotes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	psinfo := make([]byte, prpsinfoSize)
+	binary.LittleEndian.PutUint32(psinfo[prpsinfoPidOffset:], uint32(dbp.Pid))
+	if exe, err := os.Readlink(dbp.path); err == nil {
+		copy(psinfo[prpsinfoFnameOffset:prpsinfoFnameOffset+15], filepath.Base(exe))
+	}
+	writeNote(&buf, elf.NT_PRPSINFO, psinfo)
+
+	threads := []*ThreadContext{dbp.CurrentThread}
+	for _, th := range dbp.Threads {
+		if th != dbp.CurrentThread {
+			threads = append(threads, th)
+		}
+	}
+	for _, th := range threads {
+		regs, err := th.Registers()
+		if err != nil {
+			if th != dbp.CurrentThread && dbp.exitHooksRun {
+				// Already gone, dumped by an exit hook.
+				continue
+			}
+			return nil, err
+		}
+		r, ok := regs.(*Regs)
+		if !ok {
+			return nil, fmt.Errorf("unexpected registers of thread %d", th.Id)
+		}
+
+		var desc bytes.Buffer
+		desc.Write(make([]byte, prstatusPidOffset))
+		binary.Write(&desc, binary.LittleEndian, uint32(th.Id))
+		desc.Write(make([]byte, prstatusRegsOffset-desc.Len()))
+		binary.Write(&desc, binary.LittleEndian, r.regs)
+		desc.Write(make([]byte, prstatusSize-desc.Len()))
+		writeNote(&buf, elf.NT_PRSTATUS, desc.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+// Appends a note named CORE to buf, padding name and desc to 4 bytes.
+func writeNote(buf *bytes.Buffer, typ elf.NType, desc []byte) {
+	name := []byte("CORE\x00")
+	binary.Write(buf, binary.LittleEndian, [3]uint32{uint32(len(name)), uint32(len(desc)), uint32(typ)})
+	buf.Write(name)
+	buf.Write(make([]byte, (4-len(name)%4)%4))
+	buf.Write(desc)
+	buf.Write(make([]byte, (4-len(desc)%4)%4))
+}
+
+// Writes the core file: the ELF header, the program headers of the
+// notes and of one PT_LOAD segment per mapping, followed by the notes
+// and the contents of the mappings read from mem.
+func writeCore(w io.WriterAt, mem io.ReaderAt, mappings []MemoryRegion, notes []byte) error {
+	const (
+		ehsize = 64
+		phsize = 56
+	)
+	phnum := 1 + len(mappings)
+	notesOff := uint64(ehsize + phsize*phnum)
+	dataOff := notesOff + uint64(len(notes))
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_CORE),
+		Machine:   uint16(elfMachine),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     ehsize,
+		Ehsize:    ehsize,
+		Phentsize: phsize,
+		Phnum:     uint16(phnum),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var headers bytes.Buffer
+	binary.Write(&headers, binary.LittleEndian, hdr)
+	binary.Write(&headers, binary.LittleEndian, elf.Prog64{
+		Type:   uint32(elf.PT_NOTE),
+		Off:    notesOff,
+		Filesz: uint64(len(notes)),
+		Align:  4,
+	})
+	off := dataOff
+	for _, m := range mappings {
+		binary.Write(&headers, binary.LittleEndian, elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(progFlags(m.Perm)),
+			Off:    off,
+			Vaddr:  m.Start,
+			Filesz: m.End - m.Start,
+			Memsz:  m.End - m.Start,
+			Align:  1,
+		})
+		off += m.End - m.Start
+	}
+	headers.Write(notes)
+	if _, err := w.WriteAt(headers.Bytes(), 0); err != nil {
+		return err
+	}
+
+	chunk := make([]byte, dumpChunkSize)
+	off = dataOff
+	for _, m := range mappings {
+		for addr := m.Start; addr < m.End; addr += uint64(len(chunk)) {
+			buf := chunk
+			if rem := m.End - addr; rem < uint64(len(buf)) {
+				buf = buf[:rem]
+			}
+			if _, err := mem.ReadAt(buf, int64(addr)); err != nil {
+				for i := range buf {
+					buf[i] = 0
+				}
+			}
+			if _, err := w.WriteAt(buf, int64(off+addr-m.Start)); err != nil {
+				return err
+			}
+		}
+		off += m.End - m.Start
+	}
+	return nil
+}
@@ -0,0 +1,1175 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/derekparker/delve/dwarf/types"
+)
+
+// Special values of the tophash of map bucket cells, cells with
+// a tophash below mapMinTopHash do not hold a key.
+const (
+	mapEmpty      = 0
+	mapMinTopHash = 4
+)
+
+// Since Go 1.24 maps are swiss tables, see internal/runtime/maps: the
+// slots of a group hold a key when the high bit of their control byte,
+// set for empty and deleted ones, is clear.
+const (
+	swissCtrlEmpty  = 0x80
+	swissGroupSlots = 8
+)
+
+// Upper bound on the number of overflow buckets followed
+// from a single bucket while looking up a map key.
+const maxBucketChain = 1000
+
+// Type of the elements of strings.
+var byteType = &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "uint8"}}}
+
+// The predeclared basic types, used for conversions to types
+// the program itself does not use and has no debug info for.
+var basicTypes = map[string]dwarf.Type{
+	"int":     &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int"}}},
+	"int8":    &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "int8"}}},
+	"int16":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "int16"}}},
+	"int32":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int32"}}},
+	"int64":   &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "int64"}}},
+	"rune":    &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int32"}}},
+	"uint":    &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uint"}}},
+	"uint8":   byteType,
+	"byte":    byteType,
+	"uint16":  &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 2, Name: "uint16"}}},
+	"uint32":  &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "uint32"}}},
+	"uint64":  &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uint64"}}},
+	"uintptr": &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "uintptr"}}},
+	"float32": &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "float32"}}},
+	"float64": &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "float64"}}},
+	"bool":    &dwarf.BoolType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "bool"}}},
+}
+
+// Returned when evaluating a type assertion whose
+// interface value does not hold the asserted type.
+type TypeAssertionError struct {
+	Expr     string // The interface value asserted on
+	Concrete string // Its dynamic type, "nil" for nil interfaces
+	Asserted string // The asserted type
+}
+
+func (tae TypeAssertionError) Error() string {
+	return fmt.Sprintf("interface conversion: %s is %s, not %s", tae.Expr, tae.Concrete, tae.Asserted)
+}
+
+// Evaluates expr to a Variable. Unlike evalAddr, expr may denote
+// a value computed by the debugger rather than one in memory, such
+// as the result of a numeric conversion or of an operator.
+func (thread *ThreadContext) evalExpr(expr ast.Expr) (*Variable, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return thread.evalExpr(e.X)
+	case *ast.CallExpr:
+		return thread.evalCall(e)
+	case *ast.SliceExpr:
+		return thread.evalSlice(e)
+	case *ast.BinaryExpr, *ast.UnaryExpr, *ast.BasicLit:
+		return thread.evalOperation(e)
+	case *ast.Ident:
+		if _, _, err := thread.evalAddr(e); err != nil && isPredeclaredConst(e) {
+			return thread.evalOperation(e)
+		}
+	}
+
+	addr, t, err := thread.evalAddr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return thread.newVariable(exprString(expr), addr, t)
+}
+
+// Evaluates a call expression, which must be either a call of the
+// len or cap built-ins, or a conversion T(x). Conversions between types
+// with the same representation yield the value in memory reinterpreted,
+// numeric conversions a new value.
+func (thread *ThreadContext) evalCall(e *ast.CallExpr) (*Variable, error) {
+	if isBuiltinCall(e) {
+		n, err := thread.evalBuiltin(e)
+		if err != nil {
+			return nil, err
+		}
+		return thread.computedVariable(exprString(e), basicTypes["int"], strconv.FormatInt(n, 10)), nil
+	}
+	if len(e.Args) != 1 {
+		return nil, fmt.Errorf("unsupported expression %s", exprString(e))
+	}
+	t, err := thread.typeOf(e.Fun)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported function call %s", exprString(e))
+	}
+
+	if addr, src, err := thread.evalAddr(e.Args[0]); err == nil && sameRepresentation(src, t) {
+		return thread.newVariable(exprString(e), addr, t)
+	}
+
+	v, err := thread.evalScalar(e.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	val, err := convertScalar(v, t)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert %s to %s: %s", exprString(e.Args[0]), t, err)
+	}
+	return thread.computedVariable(exprString(e), t, val), nil
+}
+
+// Returns true if e is a call of the len or cap built-ins.
+func isBuiltinCall(e *ast.CallExpr) bool {
+	id, ok := e.Fun.(*ast.Ident)
+	return ok && (id.Name == "len" || id.Name == "cap")
+}
+
+// Evaluates len(x) or cap(x), reading the length or capacity
+// from the header of strings, slices, maps and channels.
+func (thread *ThreadContext) evalBuiltin(e *ast.CallExpr) (int64, error) {
+	fn := e.Fun.(*ast.Ident).Name
+	if len(e.Args) != 1 {
+		return 0, fmt.Errorf("wrong number of arguments to %s", fn)
+	}
+	addr, t, err := thread.evalAddr(e.Args[0])
+	if err != nil {
+		return 0, err
+	}
+	length, capacity, ok, err := thread.Process.lenCap(addr, t)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || fn == "cap" && capacity < 0 {
+		return 0, fmt.Errorf("invalid argument %s (type %s) for %s", exprString(e.Args[0]), t, fn)
+	}
+	if fn == "cap" {
+		return capacity, nil
+	}
+	return length, nil
+}
+
+// Returns the length and capacity of the value of type t at addr, if
+// it is an array, a pointer to an array, a string, a slice, a map or a
+// channel. The capacity of strings and maps, which have none, is -1.
+func (dbp *DebuggedProcess) lenCap(addr uint64, t dwarf.Type) (length, capacity int64, ok bool, err error) {
+	switch typ := resolveTypedef(t).(type) {
+	case *dwarf.ArrayType:
+		return typ.Count, typ.Count, true, nil
+	case *dwarf.PtrType:
+		pt, ok := resolveTypedef(typ.Type).(*dwarf.StructType)
+		if !ok {
+			if at, ok := resolveTypedef(typ.Type).(*dwarf.ArrayType); ok {
+				return at.Count, at.Count, true, nil
+			}
+			return 0, 0, false, nil
+		}
+		var lenField, capField string
+		switch {
+		case isSwissMapType(pt):
+			lenField = "used"
+		case isMapType(pt):
+			lenField = "count"
+		case isChanType(pt):
+			lenField, capField = "qcount", "dataqsiz"
+		default:
+			return 0, 0, false, nil
+		}
+		capacity = -1
+		if capField != "" {
+			capacity = 0
+		}
+		// len and cap of nil maps and channels are 0.
+		p, err := dbp.readPointer(addr)
+		if err != nil || p == 0 {
+			return 0, capacity, true, err
+		}
+		n, err := dbp.readUintField(p, pt, lenField)
+		if err != nil || capField == "" {
+			return int64(n), capacity, true, err
+		}
+		c, err := dbp.readUintField(p, pt, capField)
+		return int64(n), int64(c), true, err
+	case *dwarf.StructType:
+		switch {
+		case typ.StructName == "string":
+			n, err := dbp.readUintField(addr, typ, "len")
+			return int64(n), -1, true, err
+		case strings.HasPrefix(typ.StructName, "[]"):
+			_, length, capacity, _, err := dbp.sliceHeader(addr, typ)
+			return int64(length), int64(capacity), true, err
+		}
+	}
+	return 0, 0, false, nil
+}
+
+// Evaluates the conversion T(x) when it only reinterprets the
+// value of x in memory, e.g. (*T)(p) or a named type conversion.
+func (thread *ThreadContext) evalConversionAddr(e *ast.CallExpr) (uint64, dwarf.Type, error) {
+	if len(e.Args) != 1 {
+		return 0, nil, fmt.Errorf("unsupported expression %s", exprString(e))
+	}
+	t, err := thread.typeOf(e.Fun)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unsupported function call %s", exprString(e))
+	}
+	addr, src, err := thread.evalAddr(e.Args[0])
+	if err != nil {
+		return 0, nil, err
+	}
+	if !sameRepresentation(src, t) {
+		return 0, nil, fmt.Errorf("cannot convert %s (type %s) to %s in place", exprString(e.Args[0]), src, t)
+	}
+	return addr, t, nil
+}
+
+// Evaluates x.(T), checking the dynamic type of the interface x is T.
+func (thread *ThreadContext) evalTypeAssert(e *ast.TypeAssertExpr) (uint64, dwarf.Type, error) {
+	if e.Type == nil {
+		return 0, nil, fmt.Errorf("use of .(type) outside type switch")
+	}
+	addr, t, err := thread.evalAddr(e.X)
+	if err != nil {
+		return 0, nil, err
+	}
+	iface, ok := resolveTypedef(t).(*dwarf.StructType)
+	if !ok || !isInterfaceType(iface) {
+		return 0, nil, fmt.Errorf("invalid type assertion: %s (non-interface type %s)", exprString(e.X), t)
+	}
+	asserted, err := thread.typeOf(e.Type)
+	if err != nil {
+		return 0, nil, err
+	}
+	if st, ok := resolveTypedef(asserted).(*dwarf.StructType); ok && isInterfaceType(st) {
+		return 0, nil, fmt.Errorf("type assertion to interface type %s is not supported", asserted)
+	}
+
+	valaddr, dynamic, err := thread.Process.interfaceValue(addr, iface)
+	if err != nil {
+		return 0, nil, err
+	}
+	if dynamic == nil {
+		return 0, nil, TypeAssertionError{Expr: exprString(e.X), Concrete: "nil", Asserted: typeName(asserted)}
+	}
+	if typeName(dynamic) != typeName(asserted) {
+		return 0, nil, TypeAssertionError{Expr: exprString(e.X), Concrete: typeName(dynamic), Asserted: typeName(asserted)}
+	}
+	return valaddr, dynamic, nil
+}
+
+// Resolves the type denoted by expr. Unqualified names are looked up
+// in the package of the current function if they are not found as is.
+func (thread *ThreadContext) typeOf(expr ast.Expr) (dwarf.Type, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return thread.typeOf(e.X)
+	case *ast.StarExpr:
+		// The pointer type is only described if the
+		// program uses it, try the qualified name.
+		if t, err := thread.Process.findType(exprString(e)); err == nil {
+			return t, nil
+		}
+		if id, ok := e.X.(*ast.Ident); ok {
+			if pkg := thread.currentPackage(); pkg != "" {
+				if t, err := thread.Process.findType("*" + pkg + "." + id.Name); err == nil {
+					return t, nil
+				}
+			}
+		}
+	case *ast.Ident:
+		if t, err := thread.Process.findType(e.Name); err == nil {
+			return t, nil
+		}
+		if pkg := thread.currentPackage(); pkg != "" {
+			if t, err := thread.Process.findType(pkg + "." + e.Name); err == nil {
+				return t, nil
+			}
+		}
+		if t, ok := basicTypes[e.Name]; ok {
+			return t, nil
+		}
+	default:
+		if t, err := thread.Process.findType(exprString(e)); err == nil {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find type %s", exprString(expr))
+}
+
+// Returns the package of the function the thread is stopped in.
+func (thread *ThreadContext) currentPackage() string {
+	pc, err := thread.scopePC()
+	if err != nil {
+		return ""
+	}
+	if fn := thread.Process.GoSymTable.PCToFunc(pc); fn != nil {
+		return fn.PackageName()
+	}
+	return ""
+}
+
+// Returns the Go name of t.
+func typeName(t dwarf.Type) string {
+	if name := t.Common().Name; name != "" {
+		return name
+	}
+	return t.String()
+}
+
+// Returns true if values of type a can be reinterpreted as type b,
+// because they share the same kind of underlying type and size.
+func sameRepresentation(a, b dwarf.Type) bool {
+	a, b = resolveTypedef(a), resolveTypedef(b)
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && typeSize(a) == typeSize(b)
+}
+
+// Returns the size in memory of values of type t.
+func typeSize(t dwarf.Type) int64 {
+	if _, ok := resolveTypedef(t).(*dwarf.PtrType); ok {
+		return int64(ptrsize)
+	}
+	return t.Size()
+}
+
+// Converts a value returned by evalScalar to the basic type t,
+// formatting the result as extractValue would.
+func convertScalar(v interface{}, t dwarf.Type) (string, error) {
+	v, err := convertValue(v, t)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, int(t.Size())*8), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return v.(string), nil
+}
+
+// Converts a value returned by evalScalar to the basic type t, with
+// the truncation and rounding of Go conversions.
+func convertValue(v interface{}, t dwarf.Type) (interface{}, error) {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.IntType:
+		var n int64
+		switch v := v.(type) {
+		case int64:
+			n = v
+		case uint64:
+			n = int64(v)
+		case float64:
+			n = int64(v)
+		default:
+			return nil, fmt.Errorf("not a number")
+		}
+		shift := uint(64 - 8*t.ByteSize)
+		return n << shift >> shift, nil
+	case *dwarf.UintType:
+		var n uint64
+		switch v := v.(type) {
+		case int64:
+			n = uint64(v)
+		case uint64:
+			n = v
+		case float64:
+			n = uint64(v)
+		default:
+			return nil, fmt.Errorf("not a number")
+		}
+		shift := uint(64 - 8*t.ByteSize)
+		return n << shift >> shift, nil
+	case *dwarf.FloatType:
+		var f float64
+		switch v := v.(type) {
+		case int64:
+			f = float64(v)
+		case uint64:
+			f = float64(v)
+		case float64:
+			f = v
+		default:
+			return nil, fmt.Errorf("not a number")
+		}
+		if t.ByteSize == 4 {
+			f = float64(float32(f))
+		}
+		return f, nil
+	case *dwarf.BoolType:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("not a bool")
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			switch v := v.(type) {
+			case string:
+				return v, nil
+			case int64:
+				return string(rune(v)), nil
+			case uint64:
+				return string(rune(v)), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported conversion")
+}
+
+// Evaluates expr in the scope of the current function, returning the
+// address of the value it denotes in the memory of the process and
+// its type.
+func (thread *ThreadContext) evalAddr(expr ast.Expr) (uint64, dwarf.Type, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return thread.evalAddr(e.X)
+	case *ast.Ident:
+		return thread.lookupSymbol(e.Name)
+	case *ast.SelectorExpr:
+		return thread.evalSelector(e)
+	case *ast.IndexExpr:
+		return thread.evalIndex(e)
+	case *ast.TypeAssertExpr:
+		return thread.evalTypeAssert(e)
+	case *ast.CallExpr:
+		return thread.evalConversionAddr(e)
+	case *ast.StarExpr:
+		if u, ok := e.X.(*ast.UnaryExpr); ok && u.Op == token.AND {
+			return thread.evalAddr(u.X)
+		}
+		addr, t, err := thread.evalAddr(e.X)
+		if err != nil {
+			return 0, nil, err
+		}
+		ptr, ok := resolveTypedef(t).(*dwarf.PtrType)
+		if !ok {
+			return 0, nil, fmt.Errorf("invalid indirect of %s (type %s)", exprString(e.X), t)
+		}
+		return thread.deref(addr, ptr, e.X)
+	}
+	return 0, nil, fmt.Errorf("unsupported expression %s", exprString(expr))
+}
+
+// Evaluates x.sel, where x is either a package name, a struct,
+// or a pointer to a struct which is implicitly dereferenced.
+func (thread *ThreadContext) evalSelector(e *ast.SelectorExpr) (uint64, dwarf.Type, error) {
+	addr, t, err := thread.evalAddr(e.X)
+	if err != nil {
+		if pkg, ok := e.X.(*ast.Ident); ok {
+			if addr, t, gerr := thread.Process.globalVariable(pkg.Name + "." + e.Sel.Name); gerr == nil {
+				return addr, t, nil
+			}
+			return 0, nil, fmt.Errorf("could not find symbol value for %s", exprString(e))
+		}
+		return 0, nil, err
+	}
+
+	// The member must exist whether or not the pointer is nil,
+	// so check for it before dereferencing.
+	ptr, isptr := resolveTypedef(t).(*dwarf.PtrType)
+	st := resolveTypedef(t)
+	if isptr {
+		st = resolveTypedef(ptr.Type)
+	}
+	structType, ok := st.(*dwarf.StructType)
+	if !ok {
+		return 0, nil, fmt.Errorf("%s (type %s) is not a struct", exprString(e.X), t)
+	}
+	field, err := structField(structType, e.Sel.Name)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s has no member %s", exprString(e.X), e.Sel.Name)
+	}
+
+	if isptr {
+		if addr, _, err = thread.deref(addr, ptr, e.X); err != nil {
+			return 0, nil, err
+		}
+	}
+	return addr + uint64(field.ByteOffset), field.Type, nil
+}
+
+// Evaluates x[index], where x is an array, a pointer to an
+// array, a slice, a string or a map.
+func (thread *ThreadContext) evalIndex(e *ast.IndexExpr) (uint64, dwarf.Type, error) {
+	addr, t, err := thread.evalAddr(e.X)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	typ := resolveTypedef(t)
+	if ptr, ok := typ.(*dwarf.PtrType); ok {
+		switch pt := resolveTypedef(ptr.Type).(type) {
+		case *dwarf.ArrayType:
+			if addr, _, err = thread.deref(addr, ptr, e.X); err != nil {
+				return 0, nil, err
+			}
+			typ = pt
+		case *dwarf.StructType:
+			if isMapType(pt) {
+				return thread.evalMapIndex(e, addr, pt)
+			}
+		}
+	}
+
+	var (
+		base, length uint64
+		elem         dwarf.Type
+	)
+	switch typ := typ.(type) {
+	case *dwarf.ArrayType:
+		base, length, elem = addr, uint64(typ.Count), typ.Type
+	case *dwarf.StructType:
+		switch {
+		case typ.StructName == "string":
+			if base, err = thread.Process.readUintField(addr, typ, "str"); err != nil {
+				return 0, nil, err
+			}
+			if length, err = thread.Process.readUintField(addr, typ, "len"); err != nil {
+				return 0, nil, err
+			}
+			elem = byteType
+		case strings.HasPrefix(typ.StructName, "[]"):
+			if base, length, _, elem, err = thread.Process.sliceHeader(addr, typ); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	if elem == nil {
+		return 0, nil, fmt.Errorf("cannot index %s (type %s)", exprString(e.X), t)
+	}
+
+	idx, err := thread.evalIndexValue(e.Index)
+	if err != nil {
+		return 0, nil, err
+	}
+	if idx < 0 || uint64(idx) >= length {
+		return 0, nil, fmt.Errorf("index %d out of bounds [0, %d)", idx, length)
+	}
+	return base + uint64(idx)*elemSize(elem), elem, nil
+}
+
+// Evaluates x[lo:hi] or x[lo:hi:max], where x is an array, a pointer
+// to an array, a slice or a string. Only the elements in the window
+// are read from the process.
+func (thread *ThreadContext) evalSlice(e *ast.SliceExpr) (*Variable, error) {
+	addr, t, err := thread.evalAddr(e.X)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := resolveTypedef(t)
+	if ptr, ok := typ.(*dwarf.PtrType); ok {
+		if at, ok := resolveTypedef(ptr.Type).(*dwarf.ArrayType); ok {
+			if addr, _, err = thread.deref(addr, ptr, e.X); err != nil {
+				return nil, err
+			}
+			typ = at
+		}
+	}
+
+	var (
+		base, length, capacity uint64
+		elem                   dwarf.Type
+		str                    bool
+	)
+	switch typ := typ.(type) {
+	case *dwarf.ArrayType:
+		base, length, capacity, elem = addr, uint64(typ.Count), uint64(typ.Count), typ.Type
+	case *dwarf.StructType:
+		switch {
+		case typ.StructName == "string":
+			if base, err = thread.Process.readUintField(addr, typ, "str"); err != nil {
+				return nil, err
+			}
+			if length, err = thread.Process.readUintField(addr, typ, "len"); err != nil {
+				return nil, err
+			}
+			capacity, elem, str = length, byteType, true
+		case strings.HasPrefix(typ.StructName, "[]"):
+			if base, length, capacity, elem, err = thread.Process.sliceHeader(addr, typ); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if elem == nil {
+		return nil, fmt.Errorf("cannot slice %s (type %s)", exprString(e.X), t)
+	}
+	if str && e.Slice3 {
+		return nil, fmt.Errorf("invalid operation %s (3-index slice of string)", exprString(e))
+	}
+
+	lo, hi, max := int64(0), int64(length), int64(capacity)
+	if e.Low != nil {
+		if lo, err = thread.evalIndexValue(e.Low); err != nil {
+			return nil, err
+		}
+	}
+	if e.High != nil {
+		if hi, err = thread.evalIndexValue(e.High); err != nil {
+			return nil, err
+		}
+	}
+	if e.Max != nil {
+		if max, err = thread.evalIndexValue(e.Max); err != nil {
+			return nil, err
+		}
+	}
+	if lo < 0 || lo > hi || hi > max || uint64(max) > capacity {
+		return nil, fmt.Errorf("slice bounds out of range [%d:%d] with capacity %d", lo, hi, capacity)
+	}
+
+	stride := elemSize(elem)
+	start := uintptr(base + uint64(lo)*stride)
+	if str {
+		n := loadLimit(hi-lo, thread.Process.loadConfig().MaxStringLen)
+		val, err := thread.readMemory(start, uintptr(n))
+		if err != nil {
+			return nil, err
+		}
+		s := string(val)
+		if n < hi-lo {
+			s += fmt.Sprintf("...+%d more", hi-lo-n)
+		}
+		return &Variable{Name: exprString(e), Type: t.String(), Value: s, Kind: types.String, Len: hi - lo}, nil
+	}
+
+	vals, err := thread.readArrayValues(start, hi-lo, int64(stride), thread.Process.goType(elem))
+	if err != nil {
+		return nil, err
+	}
+	styp := fmt.Sprintf("[]%s", elem)
+	val := fmt.Sprintf("%s len: %d, cap: %d, [%s]", styp, hi-lo, max-lo, strings.Join(vals, ","))
+	return &Variable{Name: exprString(e), Type: styp, Value: val, Kind: types.Slice, Len: hi - lo, Cap: max - lo}, nil
+}
+
+// Evaluates the index of an index or slice expression.
+func (thread *ThreadContext) evalIndexValue(expr ast.Expr) (int64, error) {
+	v, err := thread.evalScalar(expr)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("non-integer index %s", exprString(expr))
+}
+
+// Looks up the key e.Index in the map whose header of type hmap is
+// pointed to by the pointer at addr. The runtime hashes keys with a
+// per process seed we cannot reproduce, so the buckets, or the groups
+// of swiss maps, are scanned.
+func (thread *ThreadContext) evalMapIndex(e *ast.IndexExpr, addr uint64, hmap *dwarf.StructType) (uint64, dwarf.Type, error) {
+	key, err := thread.evalScalar(e.Index)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	h, err := thread.Process.readPointer(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	var (
+		vaddr uint64
+		vtype dwarf.Type
+		found bool
+	)
+	switch {
+	case h == 0:
+	case isSwissMapType(hmap):
+		vaddr, vtype, found, err = thread.scanSwissMap(h, hmap, key)
+	default:
+		vaddr, vtype, found, err = thread.scanBuckets(h, hmap, key)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if !found {
+		return 0, nil, fmt.Errorf("%s has no key %s", exprString(e.X), exprString(e.Index))
+	}
+	return vaddr, vtype, nil
+}
+
+// Searches the buckets of the map whose header of type hmap is at h
+// for key. Returns the address and type of the value stored under it.
+func (thread *ThreadContext) scanBuckets(h uint64, hmap *dwarf.StructType, key interface{}) (uint64, dwarf.Type, bool, error) {
+	dbp := thread.Process
+	f, err := structField(hmap, "buckets")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	bptr, ok := resolveTypedef(f.Type).(*dwarf.PtrType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map buckets", f.Type)
+	}
+	bucket, ok := resolveTypedef(bptr.Type).(*dwarf.StructType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map buckets", f.Type)
+	}
+	B, err := dbp.readUintField(h, hmap, "B")
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	// While the map grows entries not evacuated yet are still in
+	// oldbuckets, which has half as many buckets.
+	for i, name := range []string{"buckets", "oldbuckets"} {
+		buckets, err := dbp.readUintField(h, hmap, name)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		if buckets == 0 {
+			continue
+		}
+		nbuckets := uint64(1) << (B - uint64(i))
+		for b := uint64(0); b < nbuckets; b++ {
+			addr, t, found, err := thread.scanBucket(buckets+b*uint64(bucket.Size()), bucket, key, i == 1)
+			if err != nil || found {
+				return addr, t, found, err
+			}
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// Searches the map bucket at addr, and its overflow buckets, for key.
+// Returns the address and type of the value stored under it.
+func (thread *ThreadContext) scanBucket(addr uint64, bucket *dwarf.StructType, key interface{}, old bool) (uint64, dwarf.Type, bool, error) {
+	dbp := thread.Process
+	tophash, err := structField(bucket, "tophash")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	keys, err := structField(bucket, "keys")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	values, err := structField(bucket, "values")
+	if err != nil {
+		if values, err = structField(bucket, "elems"); err != nil {
+			return 0, nil, false, err
+		}
+	}
+	keyarr, ok := resolveTypedef(keys.Type).(*dwarf.ArrayType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map keys", keys.Type)
+	}
+	valarr, ok := resolveTypedef(values.Type).(*dwarf.ArrayType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map values", values.Type)
+	}
+
+	for n := 0; addr != 0 && n < maxBucketChain; n++ {
+		hashes, err := thread.readMemory(uintptr(addr+uint64(tophash.ByteOffset)), uintptr(keyarr.Count))
+		if err != nil {
+			return 0, nil, false, err
+		}
+		// An old bucket whose first cell is marked evacuated
+		// has been moved to the new buckets already.
+		if old && hashes[0] > mapEmpty && hashes[0] < mapMinTopHash {
+			return 0, nil, false, nil
+		}
+		for i, h := range hashes {
+			if h < mapMinTopHash {
+				continue
+			}
+			kaddr := addr + uint64(keys.ByteOffset) + uint64(i)*elemSize(keyarr.Type)
+			k, err := thread.readScalar(kaddr, keyarr.Type)
+			if err != nil {
+				return 0, nil, false, err
+			}
+			if scalarsEqual(k, key) {
+				vaddr := addr + uint64(values.ByteOffset) + uint64(i)*elemSize(valarr.Type)
+				return vaddr, valarr.Type, true, nil
+			}
+		}
+		if addr, err = dbp.readUintField(addr, bucket, "overflow"); err != nil {
+			return 0, nil, false, err
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// Searches the swiss map whose header of type m is at h for key. Small
+// maps have a single group, which dirPtr points to while dirLen is 0.
+// Larger ones have a directory of dirLen tables, several entries of
+// which may point to the same table, each with lengthMask+1 groups.
+func (thread *ThreadContext) scanSwissMap(h uint64, m *dwarf.StructType, key interface{}) (uint64, dwarf.Type, bool, error) {
+	dbp := thread.Process
+	table, groups, group, err := swissMapTypes(m)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	dirPtr, err := dbp.readUintField(h, m, "dirPtr")
+	if err != nil || dirPtr == 0 {
+		return 0, nil, false, err
+	}
+	dirLen, err := dbp.readUintField(h, m, "dirLen")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if dirLen == 0 {
+		return thread.scanGroups(dirPtr, 1, group, key)
+	}
+
+	f, err := structField(table, "groups")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	dir, err := thread.readMemory(uintptr(dirPtr), uintptr(dirLen)*ptrsize)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	seen := make(map[uint64]bool)
+	for ; len(dir) > 0; dir = dir[ptrsize:] {
+		t := decodePointer(dir[:ptrsize])
+		if t == 0 || seen[t] {
+			continue
+		}
+		seen[t] = true
+		data, err := dbp.readUintField(t+uint64(f.ByteOffset), groups, "data")
+		if err != nil {
+			return 0, nil, false, err
+		}
+		mask, err := dbp.readUintField(t+uint64(f.ByteOffset), groups, "lengthMask")
+		if err != nil {
+			return 0, nil, false, err
+		}
+		addr, typ, found, err := thread.scanGroups(data, mask+1, group, key)
+		if err != nil || found {
+			return addr, typ, found, err
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// Returns the types of the tables of the swiss map of type m, of the
+// reference to their groups, and of the groups.
+func swissMapTypes(m *dwarf.StructType) (table, groups, group *dwarf.StructType, err error) {
+	derefStruct := func(t dwarf.Type, n int) (*dwarf.StructType, error) {
+		for ; n > 0; n-- {
+			ptr, ok := resolveTypedef(t).(*dwarf.PtrType)
+			if !ok {
+				return nil, fmt.Errorf("unexpected type %s in map %s", t, m.StructName)
+			}
+			t = ptr.Type
+		}
+		st, ok := resolveTypedef(t).(*dwarf.StructType)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type %s in map %s", t, m.StructName)
+		}
+		return st, nil
+	}
+
+	// dirPtr is a **table, even when it points to a group.
+	f, err := structField(m, "dirPtr")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if table, err = derefStruct(f.Type, 2); err != nil {
+		return nil, nil, nil, err
+	}
+	if f, err = structField(table, "groups"); err != nil {
+		return nil, nil, nil, err
+	}
+	if groups, err = derefStruct(f.Type, 0); err != nil {
+		return nil, nil, nil, err
+	}
+	if f, err = structField(groups, "data"); err != nil {
+		return nil, nil, nil, err
+	}
+	if group, err = derefStruct(f.Type, 1); err != nil {
+		return nil, nil, nil, err
+	}
+	return table, groups, group, nil
+}
+
+// Searches the n groups of type group at addr for key. Returns the
+// address and type of the value stored under it.
+func (thread *ThreadContext) scanGroups(addr, n uint64, group *dwarf.StructType, key interface{}) (uint64, dwarf.Type, bool, error) {
+	ctrl, err := structField(group, "ctrl")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	slots, err := structField(group, "slots")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	arr, ok := resolveTypedef(slots.Type).(*dwarf.ArrayType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map slots", slots.Type)
+	}
+	slot, ok := resolveTypedef(arr.Type).(*dwarf.StructType)
+	if !ok {
+		return 0, nil, false, fmt.Errorf("unexpected type %s for map slots", slots.Type)
+	}
+	k, err := structField(slot, "key")
+	if err != nil {
+		return 0, nil, false, err
+	}
+	v, err := structField(slot, "elem")
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	for g := uint64(0); g < n; g++ {
+		gaddr := addr + g*uint64(group.Size())
+		ctrls, err := thread.readMemory(uintptr(gaddr+uint64(ctrl.ByteOffset)), swissGroupSlots)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		for i, c := range ctrls {
+			if c&swissCtrlEmpty != 0 {
+				continue
+			}
+			saddr := gaddr + uint64(slots.ByteOffset) + uint64(i)*elemSize(slot)
+			sk, err := thread.readScalar(saddr+uint64(k.ByteOffset), k.Type)
+			if err != nil {
+				return 0, nil, false, err
+			}
+			if scalarsEqual(sk, key) {
+				return saddr + uint64(v.ByteOffset), v.Type, true, nil
+			}
+		}
+	}
+	return 0, nil, false, nil
+}
+
+// Evaluates expr to a Go value, one of int64, uint64, float64, bool
+// or string. Literals and operators are supported in addition to
+// variables; pointers evaluate to their address as a uint64.
+func (thread *ThreadContext) evalScalar(expr ast.Expr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return strconv.ParseInt(e.Value, 0, 64)
+		case token.FLOAT:
+			return strconv.ParseFloat(e.Value, 64)
+		case token.STRING:
+			return strconv.Unquote(e.Value)
+		case token.CHAR:
+			s, err := strconv.Unquote(e.Value)
+			if err != nil {
+				return nil, err
+			}
+			return int64([]rune(s)[0]), nil
+		}
+	case *ast.UnaryExpr:
+		return thread.evalUnary(e)
+	case *ast.BinaryExpr:
+		return thread.evalBinary(e)
+	case *ast.Ident:
+		addr, t, err := thread.evalAddr(e)
+		if err != nil {
+			switch e.Name {
+			case "true", "false":
+				return e.Name == "true", nil
+			case "nil":
+				return uint64(0), nil
+			}
+			return nil, err
+		}
+		return thread.readScalar(addr, t)
+	case *ast.ParenExpr:
+		return thread.evalScalar(e.X)
+	case *ast.CallExpr:
+		if isBuiltinCall(e) {
+			return thread.evalBuiltin(e)
+		}
+	}
+
+	addr, t, err := thread.evalAddr(expr)
+	if err != nil {
+		return nil, err
+	}
+	return thread.readScalar(addr, t)
+}
+
+// Reads the value of type t at addr as a Go value, see evalScalar.
+func (thread *ThreadContext) readScalar(addr uint64, t dwarf.Type) (interface{}, error) {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.IntType:
+		n, err := thread.readUintRaw(uintptr(addr), t.ByteSize)
+		if err != nil {
+			return nil, err
+		}
+		// Sign extend
+		shift := uint(64 - 8*t.ByteSize)
+		return int64(n<<shift) >> shift, nil
+	case *dwarf.UintType:
+		return thread.readUintRaw(uintptr(addr), t.ByteSize)
+	case *dwarf.PtrType:
+		return thread.Process.readPointer(addr)
+	case *dwarf.BoolType:
+		val, err := thread.readMemory(uintptr(addr), 1)
+		if err != nil {
+			return nil, err
+		}
+		return val[0] != 0, nil
+	case *dwarf.FloatType:
+		s, err := thread.readFloat(uintptr(addr), t.ByteSize)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(s, 64)
+	case *dwarf.StructType:
+		if t.StructName == "string" {
+			return thread.readString(uintptr(addr))
+		}
+	}
+	return nil, fmt.Errorf("cannot use value of type %s as a scalar", t)
+}
+
+// Compares two values returned by evalScalar, converting
+// between numeric types as needed.
+func scalarsEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case uint64:
+			return a >= 0 && uint64(a) == b
+		case float64:
+			return float64(a) == b
+		}
+	case uint64:
+		switch b := b.(type) {
+		case int64:
+			return b >= 0 && uint64(b) == a
+		case float64:
+			return float64(a) == b
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return a == float64(b)
+		case uint64:
+			return a == float64(b)
+		}
+	}
+	return a == b
+}
+
+// Returns true if t is the header of a map, which the linker
+// describes as a struct named hash<K,V>, or map<K,V> for swiss maps.
+func isMapType(t *dwarf.StructType) bool {
+	return strings.HasPrefix(t.StructName, "hash<") || isSwissMapType(t)
+}
+
+// Returns true if t is the header of a swiss map, an
+// internal/runtime/maps.Map, which maps are since Go 1.24.
+func isSwissMapType(t *dwarf.StructType) bool {
+	return strings.HasPrefix(t.StructName, "map<")
+}
+
+// Returns true if t is the header of a channel.
+func isChanType(t *dwarf.StructType) bool {
+	return strings.HasPrefix(t.StructName, "hchan<") || t.StructName == "runtime.hchan"
+}
+
+// Returns the distance between consecutive elements of type t.
+func elemSize(t dwarf.Type) uint64 {
+	if _, ok := resolveTypedef(t).(*dwarf.PtrType); ok {
+		return uint64(ptrsize)
+	}
+	return uint64(t.Size())
+}
+
+// Reads the pointer of type t at addr, returning the address
+// and type of the value it points to. x is the expression
+// the pointer was obtained from, for error reporting.
+func (thread *ThreadContext) deref(addr uint64, t *dwarf.PtrType, x ast.Expr) (uint64, dwarf.Type, error) {
+	val, err := thread.Process.readPointer(addr)
+	if err != nil {
+		return 0, nil, err
+	}
+	if val == 0 {
+		return 0, nil, fmt.Errorf("%s is nil", exprString(x))
+	}
+	return val, t.Type, nil
+}
+
+// Looks up the variable name, first among the arguments and locals
+// of the current function and then among the variables of its package.
+func (thread *ThreadContext) lookupSymbol(name string) (uint64, dwarf.Type, error) {
+	pc, err := thread.scopePC()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	reader := thread.Process.DwarfReader()
+	dpc := thread.Process.dwarfPC(pc)
+	if _, err = reader.SeekToFunction(dpc); err != nil {
+		return 0, nil, err
+	}
+
+	for entry, err := reader.NextScopeVariableAt(dpc); entry != nil; entry, err = reader.NextScopeVariableAt(dpc) {
+		if err != nil {
+			return 0, nil, err
+		}
+
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok || n != name {
+			continue
+		}
+
+		offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return 0, nil, fmt.Errorf("type assertion failed")
+		}
+		t, err := thread.Process.Dwarf.Type(offset)
+		if err != nil {
+			return 0, nil, err
+		}
+		instructions, err := thread.Process.locationExpr(entry, pc)
+		if err != nil {
+			return 0, nil, err
+		}
+		addr, err := thread.locationAddr(instructions, t.Size())
+		if err != nil {
+			return 0, nil, err
+		}
+		return uint64(addr), t, nil
+	}
+
+	if fn := thread.Process.GoSymTable.PCToFunc(pc); fn != nil {
+		if addr, t, err := thread.Process.globalVariable(fn.PackageName() + "." + name); err == nil {
+			return addr, t, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("could not find symbol value for %s", name)
+}
+
+// Parses expr as a Go expression.
+func parseExpr(expr string) (ast.Expr, error) {
+	e, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse expression %q: %s", expr, err)
+	}
+	return e, nil
+}
+
+// Returns the source representation of expr.
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, token.NewFileSet(), expr)
+	return buf.String()
+}
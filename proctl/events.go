@@ -0,0 +1,102 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Maps named runtime events to the runtime functions that implement
+// them. The runtime has renamed these functions between Go versions,
+// so each event lists its candidates from newest to oldest and the
+// first one present in the binary is used.
+var runtimeEvents = map[string][]string{
+	"gc-start":         {"runtime.gcStart", "runtime.gcstart", "runtime.gc"},
+	"goroutine-create": {"runtime.newproc1", "runtime.newproc"},
+	"goroutine-exit":   {"runtime.goexit1", "runtime.goexit0"},
+	"channel-send":     {"runtime.chansend"},
+	"channel-recv":     {"runtime.chanrecv"},
+	"channel-close":    {"runtime.closechan"},
+	"select":           {"runtime.selectgo"},
+	"panic":            {"runtime.gopanic"},
+	"throw":            {"runtime.throw"},
+}
+
+// UnknownEventError is returned when a runtime event name
+// is not known, or the runtime function implementing it
+// cannot be found in the binary.
+type UnknownEventError struct {
+	event string
+}
+
+func (uee UnknownEventError) Error() string {
+	if _, ok := runtimeEvents[uee.event]; ok {
+		return fmt.Sprintf("runtime event %s is not supported by this binary", uee.event)
+	}
+	return fmt.Sprintf("unknown runtime event %s, must be one of %s", uee.event, strings.Join(RuntimeEvents(), ", "))
+}
+
+// Returns the names of all runtime events that can be used
+// with BreakOnEvent, sorted alphabetically.
+func RuntimeEvents() []string {
+	events := make([]string, 0, len(runtimeEvents))
+	for name := range runtimeEvents {
+		events = append(events, name)
+	}
+	sort.Strings(events)
+	return events
+}
+
+// Returns the entry address of the runtime function
+// implementing the named event.
+func (dbp *DebuggedProcess) eventLocation(event string) (uint64, error) {
+	candidates, ok := runtimeEvents[event]
+	if !ok {
+		return 0, UnknownEventError{event}
+	}
+	for _, name := range candidates {
+		if fn := dbp.lookupFunc(name); fn != nil {
+			return fn.Entry, nil
+		}
+	}
+	return 0, UnknownEventError{event}
+}
+
+// Sets a breakpoint that triggers on the named runtime event,
+// e.g. "gc-start" or "goroutine-create".
+func (dbp *DebuggedProcess) BreakOnEvent(event string) (*BreakPoint, error) {
+	addr, err := dbp.eventLocation(event)
+	if err != nil {
+		return nil, err
+	}
+	return dbp.Break(addr)
+}
+
+// Sets a breakpoint that triggers when the goroutine with the given
+// id exits, either by returning from its function or via runtime.Goexit.
+func (dbp *DebuggedProcess) BreakOnGoroutineExit(id int) (*BreakPoint, error) {
+	if err := dbp.checkGoroutine(id); err != nil {
+		return nil, err
+	}
+
+	bp, err := dbp.BreakOnEvent("goroutine-exit")
+	if err != nil {
+		return nil, err
+	}
+	bp.Goroutine = id
+	return bp, nil
+}
+
+// Returns an error unless a live goroutine has the given id.
+func (dbp *DebuggedProcess) checkGoroutine(id int) error {
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return err
+	}
+	for _, g := range goroutines {
+		if g.Id == id && g.status != gstatusDead {
+			return nil
+		}
+	}
+	return fmt.Errorf("no goroutine with id %d", id)
+}
@@ -0,0 +1,67 @@
+package proctl
+
+import "fmt"
+
+// Registers hook to be run when the process is about to exit, while
+// its memory can still be read, e.g. to list its goroutines or write
+// a core file of it, so that a crash nobody set a breakpoint for
+// still leaves something to look at. Hooks run in the order they
+// were registered, once per process, on the goroutine driving it,
+// with the exiting thread as the current one.
+//
+// Threads exiting on their own, e.g. when a goroutine locked to its
+// thread returns, can't be told apart from the whole process exiting
+// and run the hooks as well.
+func (dbp *DebuggedProcess) OnExit(hook func(*DebuggedProcess)) error {
+	if name := dbp.backend.info().Name; name != "native" {
+		return fmt.Errorf("exit hooks are only run for live processes, not %s targets", name)
+	}
+	if !dbp.tracingExit() {
+		if err := dbp.traceExit(true); err != nil {
+			return err
+		}
+	}
+	dbp.mu.Lock()
+	dbp.exitHooks = append(dbp.exitHooks, hook)
+	dbp.mu.Unlock()
+	return nil
+}
+
+// Returns whether threads stop before exiting for the exit hooks.
+func (dbp *DebuggedProcess) tracingExit() bool {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return len(dbp.exitHooks) > 0
+}
+
+// Runs the exit hooks as thread is about to exit, unless they already
+// ran. The process is reported as stopped at thread meanwhile.
+func (dbp *DebuggedProcess) runExitHooks(thread *ThreadContext) {
+	dbp.mu.Lock()
+	if dbp.exitHooksRun {
+		dbp.mu.Unlock()
+		return
+	}
+	dbp.exitHooksRun = true
+	hooks := dbp.exitHooks
+	running := dbp.op != nil && dbp.op.running
+	if running {
+		dbp.op.running = false
+	}
+	current := dbp.CurrentThread
+	dbp.CurrentThread = thread
+	dbp.mu.Unlock()
+
+	restore := dbp.lend()
+	for _, hook := range hooks {
+		hook(dbp)
+	}
+	restore()
+
+	dbp.mu.Lock()
+	if running {
+		dbp.op.running = true
+	}
+	dbp.CurrentThread = current
+	dbp.mu.Unlock()
+}
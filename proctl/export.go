@@ -0,0 +1,205 @@
+package proctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// A breakpoint, tracepoint or watchpoint in the portable form written
+// by ExportBreakpoints. Locations are kept as source positions rather
+// than addresses, so they can be found again in another build of the
+// same source tree. Pending breakpoints only have the location they
+// wait for.
+type ExportedBreakpoint struct {
+	File       string   `json:"file,omitempty"`
+	Line       int      `json:"line,omitempty"`
+	Function   string   `json:"function,omitempty"`
+	Location   string   `json:"location,omitempty"` // As given to BreakPending
+	Watch      string   `json:"watch,omitempty"`    // Variable of a watchpoint
+	Tracepoint bool     `json:"tracepoint,omitempty"`
+	Variables  []string `json:"variables,omitempty"`
+	Stacktrace int      `json:"stacktrace,omitempty"`
+	Disabled   bool     `json:"disabled,omitempty"`
+}
+
+func (eb ExportedBreakpoint) String() string {
+	if eb.Watch != "" {
+		return "watch " + eb.Watch
+	}
+	if eb.File == "" {
+		return "pending " + eb.Location
+	}
+	return fmt.Sprintf("%s:%d (%s)", eb.File, eb.Line, eb.Function)
+}
+
+// The document written by ExportBreakpoints.
+type exportedBreakpoints struct {
+	Breakpoints []ExportedBreakpoint `json:"breakpoints"`
+}
+
+// The outcome of importing one breakpoint.
+type ImportResult struct {
+	Exported   ExportedBreakpoint
+	BreakPoint *BreakPoint // The breakpoint set, nil if it could not be
+	Message    string      // How the location was found, or why it wasn't
+}
+
+func (ir ImportResult) String() string {
+	if ir.BreakPoint == nil {
+		return fmt.Sprintf("%s: not set, %s", ir.Exported, ir.Message)
+	}
+	return fmt.Sprintf("%s: %s, %s", ir.Exported, ir.BreakPoint, ir.Message)
+}
+
+// Writes the breakpoints, tracepoints and watchpoints of the process
+// to w as a JSON document, which ImportBreakpoints reads back, pending
+// breakpoints included. Restrictions to a goroutine are left out, as
+// goroutine IDs are only meaningful within one run.
+func (dbp *DebuggedProcess) ExportBreakpoints(w io.Writer) error {
+	dbp.mu.RLock()
+	bps := append(dbp.userBreakpoints(), dbp.pending...)
+	dbp.mu.RUnlock()
+
+	doc := exportedBreakpoints{Breakpoints: make([]ExportedBreakpoint, 0, len(bps))}
+	for _, bp := range bps {
+		eb := ExportedBreakpoint{Location: bp.Location, Disabled: bp.Disabled}
+		if bp.Variable != "" {
+			eb.Watch = bp.Variable
+		} else {
+			eb.File, eb.Line, eb.Function = bp.File, bp.Line, bp.FunctionName
+			eb.Tracepoint, eb.Variables, eb.Stacktrace = bp.Tracepoint, bp.Variables, bp.Stacktrace
+		}
+		doc.Breakpoints = append(doc.Breakpoints, eb)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// Reads a document written by ExportBreakpoints from r, possibly for
+// another build of the program, and sets its breakpoints. Files are
+// matched by the longest common path suffix, so the source tree may
+// live elsewhere. A line which no longer holds code falls back to the
+// entry of its function. Pending breakpoints are set when their
+// location resolves, or left pending. A result is returned for every
+// breakpoint of the document, whether or not it could be set.
+func (dbp *DebuggedProcess) ImportBreakpoints(r io.Reader) ([]ImportResult, error) {
+	var doc exportedBreakpoints
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("could not read breakpoints: %s", err)
+	}
+
+	results := make([]ImportResult, 0, len(doc.Breakpoints))
+	for _, eb := range doc.Breakpoints {
+		bp, msg, err := dbp.importBreakpoint(eb)
+		if err != nil {
+			msg = err.Error()
+		}
+		results = append(results, ImportResult{Exported: eb, BreakPoint: bp, Message: msg})
+	}
+	return results, nil
+}
+
+// Sets the exported breakpoint eb, returning it along with
+// a description of how its location was found.
+func (dbp *DebuggedProcess) importBreakpoint(eb ExportedBreakpoint) (*BreakPoint, string, error) {
+	var (
+		bp  *BreakPoint
+		msg string
+		err error
+	)
+	if eb.Watch != "" {
+		bp, err = dbp.WatchGlobal(eb.Watch)
+		msg = "found variable"
+	} else if eb.File == "" {
+		bp, err = dbp.BreakPending(eb.Location)
+		msg = "found " + eb.Location
+		if bp != nil && bp.Pending {
+			msg = "still pending"
+		}
+	} else {
+		var addr uint64
+		addr, msg, err = dbp.resolveExported(eb)
+		if err != nil {
+			return nil, "", err
+		}
+		bp, err = dbp.Break(addr)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	bp.Tracepoint, bp.Variables, bp.Stacktrace = eb.Tracepoint, eb.Variables, eb.Stacktrace
+	if eb.Disabled && !bp.Pending {
+		if err := dbp.setBreakpointEnabled(dbp.CurrentThread.Id, bp, false); err != nil {
+			return bp, "", err
+		}
+	}
+	return bp, msg, nil
+}
+
+// Returns the address of the location of eb in this build.
+func (dbp *DebuggedProcess) resolveExported(eb ExportedBreakpoint) (uint64, string, error) {
+	file, err := dbp.matchFile(eb.File)
+	if err != nil {
+		if fn := dbp.lookupFunc(eb.Function); fn != nil {
+			return fn.Entry, fmt.Sprintf("%s, set at the entry of %s", err, fn.Name), nil
+		}
+		return 0, "", err
+	}
+
+	addr, fn, err := dbp.GoSymTable.LineToPC(file, eb.Line)
+	if err != nil {
+		if fn := dbp.lookupFunc(eb.Function); fn != nil {
+			return fn.Entry, fmt.Sprintf("no code at line %d of %s, set at the entry of %s", eb.Line, file, fn.Name), nil
+		}
+		return 0, "", fmt.Errorf("no code at line %d of %s", eb.Line, file)
+	}
+	if eb.Function != "" && fn != nil && fn.Name != eb.Function {
+		return addr, fmt.Sprintf("found %s:%d, which is now in %s rather than %s", file, eb.Line, fn.Name, eb.Function), nil
+	}
+	return addr, fmt.Sprintf("found %s:%d", file, eb.Line), nil
+}
+
+// Returns the source file of the program sharing the most trailing
+// path elements with path, which must at least have the same base name.
+func (dbp *DebuggedProcess) matchFile(path string) (string, error) {
+	if _, ok := dbp.GoSymTable.Files[path]; ok {
+		return path, nil
+	}
+
+	want := strings.Split(filepath.ToSlash(path), "/")
+	var (
+		best      string
+		bestLen   int
+		ambiguous bool
+	)
+	for file := range dbp.GoSymTable.Files {
+		have := strings.Split(filepath.ToSlash(file), "/")
+		n := 0
+		for n < len(want) && n < len(have) && want[len(want)-1-n] == have[len(have)-1-n] {
+			n++
+		}
+		switch {
+		case n == 0 || n < bestLen:
+		case n == bestLen:
+			ambiguous = true
+		default:
+			best, bestLen, ambiguous = file, n, false
+		}
+	}
+	if best == "" {
+		return "", fmt.Errorf("no file matching %s", path)
+	}
+	if ambiguous {
+		return "", fmt.Errorf("more than one file matching %s", path)
+	}
+	return best, nil
+}
@@ -0,0 +1,112 @@
+package proctl
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/derekparker/delve/dwarf/types"
+)
+
+// Format controls how the value of an expression is printed.
+type Format int
+
+const (
+	FormatDefault Format = iota
+	FormatHex            // Integers in base 16, %x
+	FormatBinary         // Integers in base 2, %b
+	FormatOctal          // Integers in base 8, %o
+	FormatRune           // Integers as quoted characters, %c
+	FormatAddress        // Address of the value instead of the value, %p
+)
+
+var formatVerbs = map[string]Format{
+	"%x": FormatHex,
+	"%b": FormatBinary,
+	"%o": FormatOctal,
+	"%c": FormatRune,
+	"%p": FormatAddress,
+}
+
+// Returns the format denoted by the fmt style verb s, e.g. "%x".
+func ParseFormat(s string) (Format, error) {
+	f, ok := formatVerbs[s]
+	if !ok {
+		return FormatDefault, fmt.Errorf("unknown format %s", s)
+	}
+	return f, nil
+}
+
+// Evaluates the expression name, like EvalSymbol, printing its value in
+// the given format. Formats other than FormatAddress only apply to
+// integers and pointers.
+func (thread *ThreadContext) EvalSymbolFormat(name string, format Format) (*Variable, error) {
+	if format == FormatDefault {
+		return thread.EvalSymbol(name)
+	}
+
+	expr, err := parseExpr(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatAddress {
+		addr, t, err := thread.evalAddr(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &Variable{Name: name, Type: "*" + typeName(t), Value: fmt.Sprintf("%#x", addr), Kind: types.Pointer}, nil
+	}
+
+	v, err := thread.evalExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	val, err := thread.evalScalar(expr)
+	if err != nil {
+		return nil, err
+	}
+	if v.Value, err = formatInteger(val, format); err != nil {
+		return nil, fmt.Errorf("cannot format %s (type %s): %s", name, v.Type, err)
+	}
+	v.Name = name
+	return v, nil
+}
+
+// Formats the integer v, as returned by evalScalar, in format.
+func formatInteger(v interface{}, format Format) (string, error) {
+	var (
+		n   uint64
+		neg bool
+	)
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			n, neg = uint64(-v), true
+		} else {
+			n = uint64(v)
+		}
+	case uint64:
+		n = v
+	default:
+		return "", fmt.Errorf("not an integer")
+	}
+
+	var s string
+	switch format {
+	case FormatHex:
+		s = "0x" + strconv.FormatUint(n, 16)
+	case FormatBinary:
+		s = "0b" + strconv.FormatUint(n, 2)
+	case FormatOctal:
+		s = "0o" + strconv.FormatUint(n, 8)
+	case FormatRune:
+		if neg {
+			return strconv.QuoteRune(-rune(n)), nil
+		}
+		return strconv.QuoteRune(rune(n)), nil
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s, nil
+}
@@ -0,0 +1,108 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"sort"
+	"strings"
+)
+
+// An index of the functions of the symbol table of the program, by
+// their name and the shortened names FindLocation accepts, rather
+// than looking them up one by one in the table.
+type funcIndex struct {
+	byName map[string]*gosym.Func
+	// By the names they can be shortened to, leaving out the start
+	// of their package path up to a / or a dot.
+	short map[string][]*gosym.Func
+	// Same, without the (*) around the receiver of methods.
+	deref map[string][]*gosym.Func
+	// The names of short, sorted for prefix lookups.
+	names []string
+}
+
+func newFuncIndex(table *gosym.Table) *funcIndex {
+	idx := &funcIndex{
+		byName: make(map[string]*gosym.Func, len(table.Funcs)),
+		short:  make(map[string][]*gosym.Func),
+		deref:  make(map[string][]*gosym.Func),
+	}
+	unstar := strings.NewReplacer("(*", "", ")", "")
+	for i := range table.Funcs {
+		fn := &table.Funcs[i]
+		if fn.Sym == nil {
+			continue
+		}
+		// The first one wins, as with gosym.Table.LookupFunc.
+		if _, ok := idx.byName[fn.Name]; !ok {
+			idx.byName[fn.Name] = fn
+		}
+		for _, name := range shortNames(fn.Name) {
+			idx.short[name] = append(idx.short[name], fn)
+		}
+		for _, name := range shortNames(unstar.Replace(fn.Name)) {
+			idx.deref[name] = append(idx.deref[name], fn)
+		}
+	}
+	idx.names = make([]string, 0, len(idx.short))
+	for name := range idx.short {
+		idx.names = append(idx.names, name)
+	}
+	sort.Strings(idx.names)
+	return idx
+}
+
+// Returns name and the names it can be shortened to, leaving out
+// its start up to each / and dot.
+func shortNames(name string) []string {
+	names := []string{name}
+	for i := 0; i < len(name)-1; i++ {
+		if name[i] == '/' || name[i] == '.' {
+			names = append(names, name[i+1:])
+		}
+	}
+	return names
+}
+
+// Returns the functions named name, in full or shortened, looking
+// the names without the (*) around receivers up with deref set.
+func (idx *funcIndex) lookup(name string, deref bool) []*gosym.Func {
+	if deref {
+		return idx.deref[name]
+	}
+	return idx.short[name]
+}
+
+// Returns the names, full or shortened, starting with prefix, sorted.
+func (idx *funcIndex) withPrefix(prefix string) []string {
+	i := sort.SearchStrings(idx.names, prefix)
+	var names []string
+	for ; i < len(idx.names) && strings.HasPrefix(idx.names[i], prefix); i++ {
+		names = append(names, idx.names[i])
+	}
+	return names
+}
+
+// Returns the index of the functions of the program, built on first use.
+func (dbp *DebuggedProcess) functionIndex() *funcIndex {
+	dbp.symMu.Lock()
+	defer dbp.symMu.Unlock()
+	if dbp.funcIndex == nil {
+		dbp.funcIndex = newFuncIndex(dbp.GoSymTable)
+	}
+	return dbp.funcIndex
+}
+
+// Returns the names of the functions of the program starting with
+// prefix, sorted, to complete locations with. They are given as
+// typed, in full or without the start of their package path as
+// FindLocation accepts, e.g. fmt.Pri is completed to fmt.Printf,
+// fmt.Println and fmt.Print.
+func (dbp *DebuggedProcess) CompleteFunction(prefix string) []string {
+	return dbp.functionIndex().withPrefix(prefix)
+}
+
+// Returns the function named name, as gosym.Table.LookupFunc does,
+// or nil if there is none.
+func (dbp *DebuggedProcess) lookupFunc(name string) *gosym.Func {
+	return dbp.functionIndex().byName[name]
+}
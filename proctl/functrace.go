@@ -0,0 +1,251 @@
+package proctl
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A call of a traced function, or its return, see TraceFunctions.
+type FunctionCall struct {
+	Goroutine int // Zero when called on the system stack
+	Function  string
+	File      string // Where the function is declared
+	Line      int
+	When      time.Time
+	Args      []*Variable // Values of the arguments, when called
+
+	// Set for the return of the call, with the values it returned
+	// when they were passed in integer registers.
+	Returned bool
+	Results  []*Variable
+	Duration time.Duration // Since the call
+}
+
+func (fc FunctionCall) String() string {
+	if fc.Returned {
+		return fmt.Sprintf("goroutine %d returned from %s(%s:%d)%s after %s", fc.Goroutine, fc.Function, fc.File, fc.Line, formatValues(fc.Results), fc.Duration)
+	}
+	return fmt.Sprintf("goroutine %d called %s(%s:%d)%s", fc.Goroutine, fc.Function, fc.File, fc.Line, formatValues(fc.Args))
+}
+
+// Formats the values of vars, as " with a = 1, b = 2".
+func formatValues(vars []*Variable) string {
+	if len(vars) == 0 {
+		return ""
+	}
+	s := make([]string, len(vars))
+	for i, v := range vars {
+		s[i] = fmt.Sprintf("%s = %s", v.Name, v.Value)
+	}
+	return " with " + strings.Join(s, ", ")
+}
+
+// Traces the calls of every function whose name matches the regular
+// expression re, as strace does for system calls: a breakpoint is set
+// on their entry, as a group, see BreakByFunctionRegexp, which passes
+// the call and the values of its arguments to report and resumes the
+// process each time it is hit, never stopping it. A temporary
+// breakpoint on the return address of the call then reports its
+// return, unless another breakpoint is there already. Calls are
+// logged when report is nil.
+func (dbp *DebuggedProcess) TraceFunctions(re string, report func(FunctionCall)) (*BreakpointGroup, error) {
+	g, err := dbp.BreakByFunctionRegexp(re)
+	if err != nil {
+		return g, err
+	}
+	if report == nil {
+		report = func(fc FunctionCall) { dbp.logger().Infof("%s", fc) }
+	}
+	ft := &functionTracer{report: report, returns: make(map[uint64]*returnBreakpoint)}
+	for _, bp := range g.BreakPoints {
+		// Functions without debug information, e.g. written
+		// in assembly, are traced without their values.
+		params, _ := dbp.callParams(bp.Addr)
+		if err := dbp.OnBreakpointHit(bp.ID, ft.called(params)); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// Reports the calls and returns of the functions traced together.
+type functionTracer struct {
+	report  func(FunctionCall)
+	returns map[uint64]*returnBreakpoint // By address
+}
+
+// A breakpoint on the return address of traced calls.
+type returnBreakpoint struct {
+	bp    *BreakPoint
+	calls []pendingCall
+}
+
+// A traced call which hasn't returned yet.
+type pendingCall struct {
+	call   FunctionCall
+	params []callParam
+	depth  uint64 // Of the stack once returned, see stackDepth
+}
+
+// Returns the hook reporting the calls of the function with the given
+// parameters.
+func (ft *functionTracer) called(params []callParam) BreakpointHook {
+	return func(dbp *DebuggedProcess, bp *BreakPoint) bool {
+		thread := dbp.CurrentThread
+		fc := FunctionCall{Function: bp.FunctionName, File: bp.File, Line: bp.Line, When: time.Now()}
+		if g, err := thread.CurrentGoroutine(); err == nil {
+			fc.Goroutine = g.Id
+		}
+		depth, err := thread.stackDepth()
+		if err != nil {
+			dbp.logger().Errorf("could not trace the return of %s: %s", bp.FunctionName, err)
+			ft.report(fc)
+			return true
+		}
+		// Returning pops the return address, or nothing
+		// where it is kept in a register.
+		ret := thread.ReturnAddressFromOffset(0)
+		if fde, err := dbp.FrameEntries.FDEForPC(bp.Addr); err == nil {
+			ret = thread.returnAddress(fde, bp.Addr)
+			depth -= uint64(fde.EstablishFrame(bp.Addr).CFAOffset())
+		} else {
+			depth -= uint64(ptrsize)
+		}
+		call := pendingCall{call: fc, params: params, depth: depth}
+		if ft.restarted(ret, call) {
+			// Growing the stack in the prologue enters the
+			// function anew.
+			return true
+		}
+
+		args, err := thread.FunctionArguments()
+		if err != nil {
+			dbp.logger().Debugf("could not read the arguments of %s: %s", bp.FunctionName, err)
+		}
+		for _, v := range args {
+			if !isResult(params, v.Name) {
+				call.call.Args = append(call.call.Args, v)
+			}
+		}
+		ft.report(call.call)
+		if err := ft.awaitReturn(dbp, ret, call); err != nil {
+			dbp.logger().Debugf("not tracing the return of %s: %s", bp.FunctionName, err)
+		}
+		return true
+	}
+}
+
+// Returns true if the call waits for its return at ret already, as
+// when the function is entered again for the same call.
+func (ft *functionTracer) restarted(ret uint64, call pendingCall) bool {
+	rb, ok := ft.returns[ret]
+	if !ok {
+		return false
+	}
+	for _, c := range rb.calls {
+		if c.call.Goroutine == call.call.Goroutine && c.depth == call.depth {
+			return true
+		}
+	}
+	return false
+}
+
+// Waits for call to return to ret, setting the breakpoint catching
+// the return if not set yet.
+func (ft *functionTracer) awaitReturn(dbp *DebuggedProcess, ret uint64, call pendingCall) error {
+	rb, ok := ft.returns[ret]
+	if ok {
+		// Next clears the temporary breakpoints it hits.
+		dbp.mu.RLock()
+		_, err := dbp.breakpointByID(rb.bp.ID)
+		dbp.mu.RUnlock()
+		ok = err == nil
+	}
+	if !ok {
+		bp, err := dbp.breakpoint(ret)
+		if err != nil {
+			return err
+		}
+		bp.Temp = true
+		if err := dbp.OnBreakpointHit(bp.ID, ft.returned); err != nil {
+			return err
+		}
+		rb = &returnBreakpoint{bp: bp}
+		ft.returns[ret] = rb
+	}
+	rb.calls = append(rb.calls, call)
+	return nil
+}
+
+// Reports the return of the traced call which just returned to bp,
+// clearing bp once no call is left to return to it.
+func (ft *functionTracer) returned(dbp *DebuggedProcess, bp *BreakPoint) bool {
+	rb, ok := ft.returns[bp.Addr]
+	if !ok || rb.bp != bp {
+		return true
+	}
+	thread := dbp.CurrentThread
+	var goroutine int
+	if g, err := thread.CurrentGoroutine(); err == nil {
+		goroutine = g.Id
+	}
+	depth, err := thread.stackDepth()
+	if err != nil {
+		return true
+	}
+	for i, call := range rb.calls {
+		if call.call.Goroutine != goroutine || call.depth != depth {
+			continue
+		}
+		rb.calls = append(rb.calls[:i], rb.calls[i+1:]...)
+		fc := call.call
+		now := time.Now()
+		fc.When, fc.Duration, fc.Args, fc.Returned = now, now.Sub(call.call.When), nil, true
+		if fc.Results, err = thread.returnedValues(call.params); err != nil {
+			dbp.logger().Debugf("could not read the results of %s: %s", fc.Function, err)
+		}
+		ft.report(fc)
+		break
+	}
+
+	if len(rb.calls) == 0 {
+		delete(ft.returns, bp.Addr)
+		if err := thread.clearTempBreakpoint(bp.Addr); err != nil {
+			dbp.logger().Errorf("could not clear %s: %s", bp, err)
+		}
+	}
+	return true
+}
+
+// Returns the values of the results of the function with the given
+// parameters, which just returned them in the registers of the thread.
+func (thread *ThreadContext) returnedValues(params []callParam) ([]*Variable, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	data, err := resultData(params, regs)
+	if err != nil {
+		return nil, err
+	}
+	var results []*Variable
+	for i, p := range resultParams(params) {
+		v, err := thread.newVariable(p.name, uint64(thread.Process.compositeAddr(data[i])), p.t)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, v)
+	}
+	return results, nil
+}
+
+// Returns true if name is the name of one of the results among params.
+func isResult(params []callParam, name string) bool {
+	for _, p := range resultParams(params) {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,324 @@
+package proctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A connection to a remote stub speaking the GDB remote serial
+// protocol, e.g. an rr replay session. Only the subset of the
+// protocol Delve needs to drive the target is implemented.
+type gdbConn struct {
+	conn net.Conn
+	rdr  *bufio.Reader
+	// Guards writes to conn, an interrupt may be
+	// sent while another packet is being exchanged.
+	wmu sync.Mutex
+}
+
+// A stop reply sent by the stub when the target stops,
+// see "Stop Reply Packets" in the GDB manual.
+type gdbStopReply struct {
+	kind   byte   // 'T'/'S' stopped, 'W' exited, 'X' terminated
+	signal int    // signal or exit status
+	pid    int    // process that stopped, if reported
+	tid    int    // thread that stopped, if reported
+	reason string // "replaylog" reason reported by reverse capable stubs
+}
+
+func newGdbConn(conn net.Conn) *gdbConn {
+	return &gdbConn{conn: conn, rdr: bufio.NewReader(conn)}
+}
+
+// Sends a packet and waits for the acknowledgement.
+func (gc *gdbConn) send(packet string) error {
+	var checksum byte
+	for i := 0; i < len(packet); i++ {
+		checksum += packet[i]
+	}
+
+	gc.wmu.Lock()
+	_, err := fmt.Fprintf(gc.conn, "$%s#%02x", packet, checksum)
+	gc.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for {
+		b, err := gc.rdr.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch b {
+		case '+':
+			return nil
+		case '-':
+			return gc.send(packet)
+		}
+	}
+}
+
+// Receives a packet, decoding escapes and run length encoding.
+func (gc *gdbConn) recv() (string, error) {
+	if _, err := gc.rdr.ReadString('$'); err != nil {
+		return "", err
+	}
+	data, err := gc.rdr.ReadBytes('#')
+	if err != nil {
+		return "", err
+	}
+	data = data[:len(data)-1]
+	if _, err := io.ReadFull(gc.rdr, make([]byte, 2)); err != nil {
+		return "", err
+	}
+
+	gc.wmu.Lock()
+	_, err = gc.conn.Write([]byte{'+'})
+	gc.wmu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(data); i++ {
+		switch data[i] {
+		case '}':
+			i++
+			buf.WriteByte(data[i] ^ 0x20)
+		case '*':
+			i++
+			last := buf.Bytes()[buf.Len()-1]
+			for n := int(data[i]) - 29; n > 0; n-- {
+				buf.WriteByte(last)
+			}
+		default:
+			buf.WriteByte(data[i])
+		}
+	}
+	return buf.String(), nil
+}
+
+// Sends a packet and returns the reply, converting
+// error replies ("Exx") into errors.
+func (gc *gdbConn) exec(packet string) (string, error) {
+	if err := gc.send(packet); err != nil {
+		return "", err
+	}
+	resp, err := gc.recv()
+	if err != nil {
+		return "", err
+	}
+	if len(resp) == 3 && resp[0] == 'E' {
+		return "", fmt.Errorf("remote error %s in reply to %q", resp[1:], packet)
+	}
+	return resp, nil
+}
+
+// Sends a packet that only expects an "OK" reply.
+func (gc *gdbConn) execOK(packet string) error {
+	resp, err := gc.exec(packet)
+	if err != nil {
+		return err
+	}
+	if resp != "OK" {
+		return fmt.Errorf("unexpected reply %q to %q", resp, packet)
+	}
+	return nil
+}
+
+// Sends the interrupt sequence, requesting the target stop.
+func (gc *gdbConn) interrupt() error {
+	gc.wmu.Lock()
+	defer gc.wmu.Unlock()
+	_, err := gc.conn.Write([]byte{0x03})
+	return err
+}
+
+// Selects the thread subsequent operations of
+// the given kind ('g' or 'c') apply to.
+func (gc *gdbConn) selectThread(kind byte, pid, tid int) error {
+	return gc.execOK(fmt.Sprintf("H%cp%x.%x", kind, pid, tid))
+}
+
+func (gc *gdbConn) readRegisters(pid, tid int) ([]byte, error) {
+	if err := gc.selectThread('g', pid, tid); err != nil {
+		return nil, err
+	}
+	resp, err := gc.exec("g")
+	if err != nil {
+		return nil, err
+	}
+	// Unavailable registers are reported as "xx".
+	return hex.DecodeString(strings.Replace(resp, "x", "0", -1))
+}
+
+func (gc *gdbConn) writeRegister(pid, tid, regnum int, value []byte) error {
+	if err := gc.selectThread('g', pid, tid); err != nil {
+		return err
+	}
+	return gc.execOK(fmt.Sprintf("P%x=%s", regnum, hex.EncodeToString(value)))
+}
+
+func (gc *gdbConn) readMemory(addr uintptr, data []byte) (int, error) {
+	resp, err := gc.exec(fmt.Sprintf("m%x,%x", addr, len(data)))
+	if err != nil {
+		return 0, err
+	}
+	val, err := hex.DecodeString(resp)
+	if err != nil {
+		return 0, err
+	}
+	if len(val) < len(data) {
+		return copy(data, val), fmt.Errorf("could not read memory at %#x", addr+uintptr(len(val)))
+	}
+	return copy(data, val), nil
+}
+
+func (gc *gdbConn) writeMemory(addr uintptr, data []byte) (int, error) {
+	if err := gc.execOK(fmt.Sprintf("M%x,%x:%s", addr, len(data), hex.EncodeToString(data))); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Inserts (or removes) a breakpoint of the given kind, 0 for
+// software breakpoints and 1 for hardware breakpoints.
+func (gc *gdbConn) breakpoint(kind int, addr uint64, set bool) error {
+	op := 'z'
+	if set {
+		op = 'Z'
+	}
+	return gc.execOK(fmt.Sprintf("%c%d,%x,1", op, kind, addr))
+}
+
+// Returns the ids of all threads of process pid, or of any process
+// for 0.
+func (gc *gdbConn) threadList(pid int) ([]int, error) {
+	var tids []int
+	for resp, err := gc.exec("qfThreadInfo"); resp != "l"; resp, err = gc.exec("qsThreadInfo") {
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) == 0 || resp[0] != 'm' {
+			return nil, fmt.Errorf("unexpected thread list reply %q", resp)
+		}
+		for _, id := range strings.Split(resp[1:], ",") {
+			p, tid, err := parseThreadID(id)
+			if err != nil {
+				return nil, err
+			}
+			if pid == 0 || p == 0 || p == pid {
+				tids = append(tids, tid)
+			}
+		}
+	}
+	return tids, nil
+}
+
+// Reads the path of the executable of process pid.
+func (gc *gdbConn) execFile(pid int) (string, error) {
+	var path string
+	for {
+		resp, err := gc.exec(fmt.Sprintf("qXfer:exec-file:read:%x:%x,fff", pid, len(path)))
+		if err != nil {
+			return "", err
+		}
+		if len(resp) == 0 {
+			return "", fmt.Errorf("empty reply reading executable path")
+		}
+		path += resp[1:]
+		if resp[0] == 'l' {
+			return path, nil
+		}
+	}
+}
+
+// Sends a resume packet (e.g. "c", "s", "bc", "bs")
+// and waits for the resulting stop reply.
+func (gc *gdbConn) resume(packet string) (*gdbStopReply, error) {
+	if err := gc.send(packet); err != nil {
+		return nil, err
+	}
+	return gc.waitStop()
+}
+
+// Waits for and parses a stop reply.
+func (gc *gdbConn) waitStop() (*gdbStopReply, error) {
+	resp, err := gc.recv()
+	if err != nil {
+		return nil, err
+	}
+	return parseStopReply(resp)
+}
+
+// Queries the reason the target last stopped.
+func (gc *gdbConn) stopReason() (*gdbStopReply, error) {
+	if err := gc.send("?"); err != nil {
+		return nil, err
+	}
+	return gc.waitStop()
+}
+
+func parseStopReply(resp string) (*gdbStopReply, error) {
+	if len(resp) < 3 {
+		return nil, fmt.Errorf("malformed stop reply %q", resp)
+	}
+
+	sig, err := strconv.ParseUint(resp[1:3], 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("malformed stop reply %q", resp)
+	}
+	sr := &gdbStopReply{kind: resp[0], signal: int(sig)}
+
+	switch sr.kind {
+	case 'S', 'W', 'X':
+		return sr, nil
+	case 'T':
+	default:
+		return nil, fmt.Errorf("unexpected stop reply %q", resp)
+	}
+
+	for _, kv := range strings.Split(resp[3:], ";") {
+		idx := strings.Index(kv, ":")
+		if idx < 0 {
+			continue
+		}
+		switch kv[:idx] {
+		case "thread":
+			if sr.pid, sr.tid, err = parseThreadID(kv[idx+1:]); err != nil {
+				return nil, err
+			}
+		case "replaylog":
+			sr.reason = kv[idx+1:]
+		}
+	}
+	return sr, nil
+}
+
+// Parses a thread id, in either the "tid" or multiprocess "ppid.tid" form.
+func parseThreadID(id string) (int, int, error) {
+	var pid uint64
+	if strings.HasPrefix(id, "p") {
+		idx := strings.Index(id, ".")
+		if idx < 0 {
+			return 0, 0, fmt.Errorf("malformed thread id %q", id)
+		}
+		var err error
+		if pid, err = strconv.ParseUint(id[1:idx], 16, 32); err != nil {
+			return 0, 0, fmt.Errorf("malformed thread id %q", id)
+		}
+		id = id[idx+1:]
+	}
+	tid, err := strconv.ParseUint(id, 16, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed thread id %q", id)
+	}
+	return int(pid), int(tid), nil
+}
@@ -0,0 +1,846 @@
+package proctl
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest packet the server accepts, advertised in qSupported.
+	gdbMaxPacketSize = 0x4000
+	// How often the connection is checked for an interrupt
+	// request while the process runs.
+	gdbInterruptPoll = 100 * time.Millisecond
+)
+
+// The server side of the GDB remote serial protocol, serving the
+// process to GDB compatible front-ends. It implements what GDB needs
+// to drive an all-stop amd64 target: registers, memory, breakpoints
+// and resuming, both through vCont and the legacy packets.
+//
+// Several clients may share the session. The first one to connect
+// controls the process, the others observe it: they can read its
+// state, but not change it or resume it. When the controlling client
+// disconnects the longest connected observer takes over. Packets are
+// read by a goroutine per client, and handled one at a time by the
+// goroutine driving the process, so the observers' requests wait
+// while the process runs.
+type gdbServer struct {
+	dbp *DebuggedProcess
+	// Breakpoints inserted by the clients, which are the only
+	// ones they may remove. Breakpoints the user set in Delve
+	// at the same address are left alone.
+	breakpoints map[uint64]bool
+
+	// Guards clients, which is also read by the client
+	// goroutines to tell who may interrupt the process.
+	mu sync.Mutex
+	// Connected clients, the controlling one first.
+	clients []*gdbClient
+}
+
+// A client of a gdbServer.
+type gdbClient struct {
+	conn *gdbConn
+	// Threads selected by the Hg and Hc packets, 0 for any.
+	gthread, cthread int
+	// Whether thread ids are sent in the "ppid.tid" form,
+	// negotiated through qSupported.
+	multiprocess bool
+}
+
+// A packet received by a client goroutine, to be handled by the
+// goroutine driving the process. A nil reply channel reports the
+// client disconnected.
+type gdbRequest struct {
+	client *gdbClient
+	packet string
+	reply  chan<- gdbReply
+}
+
+type gdbReply struct {
+	packet  string
+	session gdbSession
+}
+
+// What becomes of the session of a client after a packet.
+type gdbSession int
+
+const (
+	gdbServing  gdbSession = iota
+	gdbDetached            // The client leaves once the reply is sent
+	gdbKilled              // The client leaves without a reply
+)
+
+// Serves the process over conn using the GDB remote serial protocol,
+// so GDB and the IDEs built on it can debug the process. Returns once
+// the client detaches, kills the process or closes the connection,
+// which is closed on return. Like Continue, it must be called from
+// the goroutine driving the process.
+func (dbp *DebuggedProcess) ServeGDB(conn net.Conn) error {
+	conns := make(chan net.Conn, 1)
+	conns <- conn
+	close(conns)
+	return dbp.serveGDB(conns)
+}
+
+// Serves the process to the GDB compatible front-ends connecting to l,
+// the first one to connect controlling it and the others observing,
+// see ServeGDB. Returns once all clients are gone or the controlling
+// client detaches or kills the process, closing their connections.
+// The caller closes l.
+func (dbp *DebuggedProcess) ServeGDBClients(l net.Listener) error {
+	conns := make(chan net.Conn)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			select {
+			case conns <- conn:
+			case <-done:
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return dbp.serveGDB(conns)
+}
+
+// Serves the connections received from conns until the controlling
+// client ends the session or the last client disconnects.
+func (dbp *DebuggedProcess) serveGDB(conns <-chan net.Conn) error {
+	gs := &gdbServer{dbp: dbp, breakpoints: make(map[uint64]bool)}
+	requests := make(chan gdbRequest)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(done)
+		// Unblock the client goroutines still reading.
+		gs.mu.Lock()
+		for _, c := range gs.clients {
+			c.conn.conn.Close()
+		}
+		gs.mu.Unlock()
+		wg.Wait()
+	}()
+
+	connected := false
+	for {
+		select {
+		case conn, ok := <-conns:
+			if !ok {
+				if !connected {
+					return nil
+				}
+				conns = nil
+				continue
+			}
+			connected = true
+			c := &gdbClient{conn: newGdbConn(conn)}
+			gs.mu.Lock()
+			gs.clients = append(gs.clients, c)
+			gs.mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				gs.serveClient(c, requests, done)
+			}()
+
+		case req := <-requests:
+			if req.reply == nil {
+				if gs.removeClient(req.client) == 0 {
+					return nil
+				}
+				continue
+			}
+			controlling := gs.controlling(req.client)
+			reply, session := gs.handle(req.client, req.packet)
+			req.reply <- gdbReply{reply, session}
+			if session == gdbServing {
+				continue
+			}
+			// The client goroutine closes the connection once
+			// the reply is sent, the others are closed on return.
+			if gs.removeClient(req.client) == 0 || controlling {
+				return nil
+			}
+		}
+	}
+}
+
+// Reads the packets of client c and sends them to the driving
+// goroutine, then sends back its replies, until c disconnects or
+// done is closed. An interrupt sent by the controlling client while
+// the process runs stops it as RequestManualStop does.
+func (gs *gdbServer) serveClient(c *gdbClient, requests chan<- gdbRequest, done <-chan struct{}) {
+	defer c.conn.conn.Close()
+	for {
+		packet, err := c.conn.recv()
+		if err != nil {
+			select {
+			case requests <- gdbRequest{client: c}:
+			case <-done:
+			}
+			return
+		}
+
+		replies := make(chan gdbReply, 1)
+		select {
+		case requests <- gdbRequest{client: c, packet: packet, reply: replies}:
+		case <-done:
+			return
+		}
+		var reply gdbReply
+		if resumes(packet) {
+			reply = gs.awaitStop(c, replies)
+		} else {
+			reply = <-replies
+		}
+
+		if reply.session == gdbKilled {
+			return
+		}
+		if err := c.conn.send(reply.packet); err != nil {
+			// The disconnection is noticed by the next recv.
+			continue
+		}
+		if reply.session == gdbDetached {
+			return
+		}
+	}
+}
+
+// Returns whether packet resumes the process.
+func resumes(packet string) bool {
+	return packet == "c" || packet == "s" || strings.HasPrefix(packet, "vCont;")
+}
+
+// Waits for the reply to a packet of c resuming the process,
+// meanwhile watching its connection for an interrupt request.
+func (gs *gdbServer) awaitStop(c *gdbClient, replies <-chan gdbReply) gdbReply {
+	polled := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			c.conn.conn.SetReadDeadline(time.Now().Add(gdbInterruptPoll))
+			b, err := c.conn.rdr.Peek(1)
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					continue
+				}
+				return
+			}
+			if b[0] != 0x03 {
+				return
+			}
+			c.conn.rdr.ReadByte()
+			if gs.controlling(c) && gs.dbp.Running() {
+				gs.dbp.RequestManualStop()
+			}
+		}
+	}()
+
+	reply := <-replies
+	close(stop)
+	c.conn.conn.SetReadDeadline(time.Now())
+	<-polled
+	c.conn.conn.SetReadDeadline(time.Time{})
+	return reply
+}
+
+// Returns whether c controls the process.
+func (gs *gdbServer) controlling(c *gdbClient) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	return len(gs.clients) > 0 && gs.clients[0] == c
+}
+
+// Removes c from the clients, handing control over to the next
+// one if c was controlling. Returns the number of clients left.
+func (gs *gdbServer) removeClient(c *gdbClient) int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	for i := range gs.clients {
+		if gs.clients[i] == c {
+			gs.clients = append(gs.clients[:i], gs.clients[i+1:]...)
+			break
+		}
+	}
+	return len(gs.clients)
+}
+
+// Handles a packet of client c, returning the reply. Unsupported
+// packets get the empty reply, as the protocol requires. Observers
+// get an error reply to the packets changing the process.
+func (gs *gdbServer) handle(c *gdbClient, packet string) (string, gdbSession) {
+	if packet == "" {
+		return "", gdbServing
+	}
+	if !gs.controlling(c) {
+		switch packet[0] {
+		case 'q', '?', 'H', 'T', 'g', 'p', 'm':
+		case 'v':
+			if packet != "vCont?" {
+				return "E01", gdbServing
+			}
+		case 'D':
+			return "OK", gdbDetached
+		case 'k':
+			return "", gdbKilled
+		default:
+			return "E01", gdbServing
+		}
+	}
+
+	var (
+		reply string
+		err   error
+	)
+	switch packet[0] {
+	case 'q':
+		reply, err = gs.query(c, packet[1:])
+	case 'v':
+		reply, err = gs.verbose(c, packet[1:])
+	case '?':
+		reply = gs.stopReply(c)
+	case 'H':
+		err = gs.selectThread(c, packet[1:])
+		reply = "OK"
+	case 'T':
+		err = gs.threadAlive(packet[1:])
+		reply = "OK"
+	case 'g':
+		reply, err = gs.readRegisters(c)
+	case 'G':
+		err = gs.writeRegisters(c, packet[1:])
+		reply = "OK"
+	case 'p':
+		reply, err = gs.readRegister(c, packet[1:])
+	case 'P':
+		err = gs.writeRegister(c, packet[1:])
+		reply = "OK"
+	case 'm':
+		reply, err = gs.readMemory(packet[1:])
+	case 'M':
+		err = gs.writeMemory(packet[1:])
+		reply = "OK"
+	case 'Z', 'z':
+		reply, err = gs.breakpoint(packet[0] == 'Z', packet[1:])
+	case 'c':
+		reply = gs.resume(c, gs.dbp.Continue)
+	case 's':
+		reply = gs.resume(c, gs.stepper(c.cthread))
+	case 'D':
+		if err = gs.dbp.Detach(false); err == nil {
+			return "OK", gdbDetached
+		}
+	case 'k':
+		gs.dbp.Kill()
+		return "", gdbKilled
+	}
+	if err != nil {
+		return "E01", gdbServing
+	}
+	return reply, gdbServing
+}
+
+// Handles the general query packets.
+func (gs *gdbServer) query(c *gdbClient, q string) (string, error) {
+	switch {
+	case strings.HasPrefix(q, "Supported"):
+		features := fmt.Sprintf("PacketSize=%x;vContSupported+;qXfer:exec-file:read+", gdbMaxPacketSize)
+		for _, f := range strings.Split(strings.TrimPrefix(q, "Supported:"), ";") {
+			if f == "multiprocess+" {
+				c.multiprocess = true
+				features += ";multiprocess+"
+			}
+		}
+		return features, nil
+	case q == "Attached":
+		if gs.dbp.attached {
+			return "1", nil
+		}
+		return "0", nil
+	case q == "C":
+		return "QC" + gs.formatThreadID(c, gs.dbp.CurrentThread.Id), nil
+	case q == "fThreadInfo":
+		ids := make([]string, 0, len(gs.dbp.Threads))
+		for _, th := range gs.threads() {
+			ids = append(ids, gs.formatThreadID(c, th.Id))
+		}
+		return "m" + strings.Join(ids, ","), nil
+	case q == "sThreadInfo":
+		return "l", nil
+	case strings.HasPrefix(q, "Symbol"):
+		return "OK", nil
+	case strings.HasPrefix(q, "Xfer:exec-file:read:"):
+		return gs.execFile(strings.TrimPrefix(q, "Xfer:exec-file:read:"))
+	}
+	return "", nil
+}
+
+// Handles the v packets, of which only vCont is supported.
+func (gs *gdbServer) verbose(c *gdbClient, v string) (string, error) {
+	switch {
+	case v == "Cont?":
+		return "vCont;c;C;s;S", nil
+	case strings.HasPrefix(v, "Cont;"):
+		return gs.vCont(c, strings.Split(v[len("Cont;"):], ";"))
+	}
+	return "", nil
+}
+
+// Resumes the process as described by the actions of a vCont packet.
+// The target is all-stop: a thread to step stops the whole process
+// once stepped, otherwise all threads are continued. Signals passed
+// with C and S are ignored, Delve doesn't deliver signals.
+func (gs *gdbServer) vCont(c *gdbClient, actions []string) (string, error) {
+	step := -1
+	for _, action := range actions {
+		if action == "" {
+			return "", fmt.Errorf("malformed vCont action")
+		}
+		kind, tid := action, "-1"
+		if idx := strings.Index(action, ":"); idx >= 0 {
+			kind, tid = action[:idx], action[idx+1:]
+		}
+		switch kind[0] {
+		case 's', 'S':
+			id, err := gs.threadID(tid)
+			if err != nil {
+				return "", err
+			}
+			if step < 0 {
+				step = id
+			}
+		case 'c', 'C':
+		default:
+			return "", fmt.Errorf("unsupported vCont action %q", action)
+		}
+	}
+	if step >= 0 {
+		return gs.resume(c, gs.stepper(step)), nil
+	}
+	return gs.resume(c, gs.dbp.Continue), nil
+}
+
+// Returns a function single stepping the thread with the given id,
+// or the current thread for 0.
+func (gs *gdbServer) stepper(id int) func() error {
+	return func() error {
+		th, err := gs.thread(id)
+		if err != nil {
+			return err
+		}
+		if err := gs.dbp.SwitchThread(th.Id); err != nil {
+			return err
+		}
+		return gs.dbp.run(context.Background(), func(context.Context) error { return th.Step() })
+	}
+}
+
+// Resumes the process with fn on behalf of c, and returns
+// the stop reply describing how it stopped.
+func (gs *gdbServer) resume(c *gdbClient, fn func() error) string {
+	if err := fn(); err != nil {
+		if _, ok := err.(ProcessExitedError); !ok {
+			return "E01"
+		}
+	}
+	c.gthread, c.cthread = 0, 0
+	return gs.stopReply(c)
+}
+
+// Returns the stop reply describing the state of the process to c.
+func (gs *gdbServer) stopReply(c *gdbClient) string {
+	if gs.dbp.Exited() {
+		pe, _ := gs.dbp.exitError().(ProcessExitedError)
+		if pe.Signal != 0 {
+			return fmt.Sprintf("X%02x", int(pe.Signal))
+		}
+		return fmt.Sprintf("W%02x", pe.Status&0xff)
+	}
+	sig := 5 // SIGTRAP
+	if gs.dbp.StopReason() == StopManual {
+		sig = 2 // SIGINT
+	}
+	return fmt.Sprintf("T%02xthread:%s;", sig, gs.formatThreadID(c, gs.dbp.CurrentThread.Id))
+}
+
+// Formats the id of thread tid for c, in the multiprocess
+// form when c supports it.
+func (gs *gdbServer) formatThreadID(c *gdbClient, tid int) string {
+	if c.multiprocess {
+		return fmt.Sprintf("p%x.%x", gs.dbp.Pid, tid)
+	}
+	return strconv.FormatInt(int64(tid), 16)
+}
+
+// Handles Hg and Hc, selecting the thread of
+// register accesses and of the legacy resume packets.
+func (gs *gdbServer) selectThread(c *gdbClient, args string) error {
+	if args == "" {
+		return fmt.Errorf("malformed H packet")
+	}
+	id, err := gs.threadID(args[1:])
+	if err != nil {
+		return err
+	}
+	if _, err := gs.thread(id); err != nil {
+		return err
+	}
+	switch args[0] {
+	case 'g':
+		c.gthread = id
+	case 'c':
+		c.cthread = id
+	default:
+		return fmt.Errorf("malformed H packet")
+	}
+	return nil
+}
+
+func (gs *gdbServer) threadAlive(args string) error {
+	id, err := gs.threadID(args)
+	if err != nil {
+		return err
+	}
+	_, err = gs.thread(id)
+	return err
+}
+
+// Parses a thread id, mapping "-1" (all threads)
+// and "0" (any thread) to 0.
+func (gs *gdbServer) threadID(id string) (int, error) {
+	if idx := strings.Index(id, "."); strings.HasPrefix(id, "p") && idx >= 0 {
+		id = id[idx+1:]
+	}
+	if id == "-1" || id == "0" {
+		return 0, nil
+	}
+	_, tid, err := parseThreadID(id)
+	return tid, err
+}
+
+// Returns the thread with the given id, or the current thread for 0.
+func (gs *gdbServer) thread(id int) (*ThreadContext, error) {
+	if id == 0 {
+		return gs.dbp.CurrentThread, nil
+	}
+	th, ok := gs.dbp.Threads[id]
+	if !ok {
+		return nil, fmt.Errorf("thread %d does not exist", id)
+	}
+	return th, nil
+}
+
+// Returns the threads of the process, the current one first.
+func (gs *gdbServer) threads() []*ThreadContext {
+	threads := []*ThreadContext{gs.dbp.CurrentThread}
+	for _, th := range gs.dbp.Threads {
+		if th != gs.dbp.CurrentThread {
+			threads = append(threads, th)
+		}
+	}
+	return threads
+}
+
+// Returns the size of register n of the register block.
+func gdbRegSize(n int) int {
+	if n > gdbRegPCNum {
+		return 4
+	}
+	return 8
+}
+
+// Returns the values of the registers of the thread selected by Hg,
+// by their lower case names.
+func (gs *gdbServer) registerValues(c *gdbClient) (*ThreadContext, map[string]uint64, error) {
+	th, err := gs.thread(c.gthread)
+	if err != nil {
+		return nil, nil, err
+	}
+	regs, err := th.Registers()
+	if err != nil {
+		return nil, nil, err
+	}
+	vals := make(map[string]uint64)
+	for _, r := range regs.Slice() {
+		vals[strings.ToLower(r.Name)] = r.Value
+	}
+	return th, vals, nil
+}
+
+// Encodes register n, or reports it unavailable
+// when the backend doesn't provide it.
+func encodeGdbRegister(vals map[string]uint64, n int) string {
+	size := gdbRegSize(n)
+	val, ok := vals[strings.ToLower(gdbRegNames[n])]
+	if !ok {
+		return strings.Repeat("xx", size)
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, val)
+	return hex.EncodeToString(buf[:size])
+}
+
+func (gs *gdbServer) readRegisters(c *gdbClient) (string, error) {
+	_, vals, err := gs.registerValues(c)
+	if err != nil {
+		return "", err
+	}
+	var reply string
+	for n := range gdbRegNames {
+		reply += encodeGdbRegister(vals, n)
+	}
+	return reply, nil
+}
+
+// Writes the registers of a G packet which differ from
+// their current values, leaving the others untouched.
+func (gs *gdbServer) writeRegisters(c *gdbClient, data string) error {
+	th, vals, err := gs.registerValues(c)
+	if err != nil {
+		return err
+	}
+	off := 0
+	for n, name := range gdbRegNames {
+		size := gdbRegSize(n)
+		if off+2*size > len(data) {
+			break
+		}
+		field := data[off : off+2*size]
+		off += 2 * size
+		if strings.Contains(field, "x") {
+			continue
+		}
+		val, err := decodeGdbRegister(field)
+		if err != nil {
+			return err
+		}
+		if cur, ok := vals[strings.ToLower(name)]; ok && cur == val {
+			continue
+		}
+		if err := gs.setRegister(th, n, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gs *gdbServer) readRegister(c *gdbClient, args string) (string, error) {
+	n, err := strconv.ParseUint(args, 16, 32)
+	if err != nil {
+		return "", err
+	}
+	if int(n) >= len(gdbRegNames) {
+		return "", fmt.Errorf("unknown register %d", n)
+	}
+	_, vals, err := gs.registerValues(c)
+	if err != nil {
+		return "", err
+	}
+	return encodeGdbRegister(vals, int(n)), nil
+}
+
+// Handles "P n=value".
+func (gs *gdbServer) writeRegister(c *gdbClient, args string) error {
+	idx := strings.Index(args, "=")
+	if idx < 0 {
+		return fmt.Errorf("malformed P packet")
+	}
+	n, err := strconv.ParseUint(args[:idx], 16, 32)
+	if err != nil {
+		return err
+	}
+	if int(n) >= len(gdbRegNames) {
+		return fmt.Errorf("unknown register %d", n)
+	}
+	val, err := decodeGdbRegister(args[idx+1:])
+	if err != nil {
+		return err
+	}
+	th, err := gs.thread(c.gthread)
+	if err != nil {
+		return err
+	}
+	return gs.setRegister(th, int(n), val)
+}
+
+func (gs *gdbServer) setRegister(th *ThreadContext, n int, val uint64) error {
+	regs, err := th.Registers()
+	if err != nil {
+		return err
+	}
+	if n == gdbRegPCNum {
+		return regs.SetPC(th, val)
+	}
+	return regs.SetRegister(th, gdbRegNames[n], val)
+}
+
+// Decodes a little endian register value of up to 8 bytes.
+func decodeGdbRegister(field string) (uint64, error) {
+	b, err := hex.DecodeString(field)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) > 8 {
+		return 0, fmt.Errorf("register value %s too long", field)
+	}
+	buf := make([]byte, 8)
+	copy(buf, b)
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Parses the "addr,length" arguments of the m, M and Z packets.
+func parseAddrLength(args string) (uint64, int, error) {
+	idx := strings.Index(args, ",")
+	if idx < 0 {
+		return 0, 0, fmt.Errorf("malformed address and length %q", args)
+	}
+	addr, err := strconv.ParseUint(args[:idx], 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(args[idx+1:], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return addr, int(length), nil
+}
+
+// Reads memory for an m packet. The original instructions are shown
+// in place of the software breakpoints Delve inserted, as GDB
+// expects of a stub handling breakpoints itself.
+func (gs *gdbServer) readMemory(args string) (string, error) {
+	addr, length, err := parseAddrLength(args)
+	if err != nil {
+		return "", err
+	}
+	if length > gdbMaxPacketSize/2 {
+		length = gdbMaxPacketSize / 2
+	}
+	data, err := gs.dbp.ReadMemory(uintptr(addr), length)
+	if err != nil {
+		return "", err
+	}
+	gs.dbp.mu.RLock()
+	for _, bp := range gs.dbp.BreakPoints {
+		if bp.Addr >= addr && bp.Addr < addr+uint64(length) {
+			copy(data[bp.Addr-addr:], bp.OriginalData)
+		}
+	}
+	gs.dbp.mu.RUnlock()
+	return hex.EncodeToString(data), nil
+}
+
+// Handles "M addr,length:data".
+func (gs *gdbServer) writeMemory(args string) error {
+	idx := strings.Index(args, ":")
+	if idx < 0 {
+		return fmt.Errorf("malformed M packet")
+	}
+	addr, length, err := parseAddrLength(args[:idx])
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(args[idx+1:])
+	if err != nil {
+		return err
+	}
+	if len(data) != length {
+		return fmt.Errorf("M packet has %d bytes of data, not %d", len(data), length)
+	}
+	if length == 0 {
+		return nil
+	}
+	return gs.dbp.CurrentThread.writeMemory(uintptr(addr), data)
+}
+
+// Handles "Z type,addr,kind" and "z type,addr,kind". Software and
+// hardware breakpoints are both set with Break, which picks the kind
+// of breakpoint itself. Watchpoints are not supported, Delve only
+// watches variables.
+func (gs *gdbServer) breakpoint(set bool, args string) (string, error) {
+	if len(args) < 2 || args[1] != ',' {
+		return "", fmt.Errorf("malformed breakpoint packet")
+	}
+	if args[0] != '0' && args[0] != '1' {
+		return "", nil
+	}
+	addr, _, err := parseAddrLength(args[2:])
+	if err != nil {
+		return "", err
+	}
+
+	if !set {
+		if !gs.breakpoints[addr] {
+			return "OK", nil
+		}
+		if _, err := gs.dbp.Clear(addr); err != nil {
+			return "", err
+		}
+		delete(gs.breakpoints, addr)
+		return "OK", nil
+	}
+
+	if gs.dbp.BreakpointExists(addr) {
+		return "OK", nil
+	}
+	if _, err := gs.dbp.Break(addr); err != nil {
+		return "", err
+	}
+	gs.breakpoints[addr] = true
+	return "OK", nil
+}
+
+// Handles "qXfer:exec-file:read:annex:offset,length", returning
+// the path of the executable so the client can load its symbols.
+func (gs *gdbServer) execFile(args string) (string, error) {
+	idx := strings.Index(args, ":")
+	if idx < 0 {
+		return "", fmt.Errorf("malformed qXfer packet")
+	}
+	if annex := args[:idx]; annex != "" {
+		pid, err := strconv.ParseUint(annex, 16, 32)
+		if err != nil || int(pid) != gs.dbp.Pid {
+			return "", fmt.Errorf("unknown process %s", annex)
+		}
+	}
+	offset, length, err := parseAddrLength(args[idx+1:])
+	if err != nil {
+		return "", err
+	}
+
+	path := gs.dbp.path
+	if exe, err := os.Readlink(path); err == nil {
+		path = exe
+	}
+	if offset >= uint64(len(path)) {
+		return "l", nil
+	}
+	path = path[offset:]
+	if len(path) > length {
+		return "m" + path[:length], nil
+	}
+	return "l" + path, nil
+}
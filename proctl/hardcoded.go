@@ -0,0 +1,162 @@
+package proctl
+
+import (
+	"bytes"
+	"debug/gosym"
+	"strings"
+)
+
+// Functions executing a hardcoded breakpoint: the trap runtime.Breakpoint
+// ends up in, and runtime.Breakpoint itself when it isn't inlined in the
+// caller, e.g. when built with -l. Entries apply to the Go versions starting with version, ""
+// matching all of them, so that runtimes moving the trap elsewhere can be
+// described without affecting older ones.
+var hardcodedBreakpoints = []struct{ version, fn string }{
+	{"", "runtime.breakpoint"},
+	{"", "runtime.Breakpoint"},
+}
+
+// Upper bound on the instructions stepped to leave
+// the functions executing a hardcoded breakpoint.
+const maxHardcodedBreakpointSteps = 16
+
+// Why the process last stopped.
+type StopReason int
+
+const (
+	StopUnknown StopReason = iota
+	StopBreakpoint
+	StopHardcodedBreakpoint
+	StopWatchpoint
+	StopManual
+	StopExec    // The process executed a new program
+	StopRunaway // The process ran for too long, see RunawayDetector
+	StopSignal  // The process received a signal, see SignalStop
+	StopSyscall // A thread entered or left a syscall, see CatchSyscall
+)
+
+func (sr StopReason) String() string {
+	switch sr {
+	case StopBreakpoint:
+		return "breakpoint"
+	case StopHardcodedBreakpoint:
+		return "hardcoded breakpoint"
+	case StopWatchpoint:
+		return "watchpoint"
+	case StopManual:
+		return "manual stop"
+	case StopExec:
+		return "exec"
+	case StopRunaway:
+		return "runaway"
+	case StopSignal:
+		return "signal"
+	case StopSyscall:
+		return "syscall"
+	}
+	return "unknown"
+}
+
+// Returns why the process last stopped.
+func (dbp *DebuggedProcess) StopReason() StopReason {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.stopReason
+}
+
+func (dbp *DebuggedProcess) setStopReason(sr StopReason) {
+	dbp.mu.Lock()
+	dbp.stopReason = sr
+	dbp.mu.Unlock()
+}
+
+// Checks whether thread, stopped at pc, hit a hardcoded breakpoint
+// rather than one of ours. These are either in one of the functions of
+// hardcodedBreakpoints, which are all stepped out of so that the thread is
+// back in the code asking for the breakpoint, or trap instructions we
+// didn't write, e.g. in inlined code or assembly.
+func (dbp *DebuggedProcess) hardcodedBreakpoint(thread *ThreadContext, pc uint64) (bool, error) {
+	var fn *gosym.Func
+	if dbp.GoSymTable != nil {
+		// Without symbols only trap instructions are recognized.
+		fn = dbp.GoSymTable.PCToFunc(pc)
+	}
+	if fn != nil && dbp.isHardcodedBreakpointFunc(fn.Name) {
+		if _, err := thread.skipBreakpointInstruction(pc); err != nil {
+			return true, err
+		}
+		for i := 0; i < maxHardcodedBreakpointSteps; i++ {
+			if err := thread.Step(); err != nil {
+				return true, err
+			}
+			pc, err := thread.CurrentPC()
+			if err != nil {
+				return true, err
+			}
+			if f := dbp.GoSymTable.PCToFunc(pc); f == nil || !dbp.isHardcodedBreakpointFunc(f.Name) {
+				break
+			}
+		}
+		return true, nil
+	}
+
+	if _, ok := dbp.trappedBreakpoint(pc); ok {
+		return false, nil
+	}
+	for _, bp := range dbp.HWBreakPoints {
+		if bp != nil && !bp.Disabled && bp.Addr == pc {
+			return false, nil
+		}
+	}
+	if breakpointPCOffset == 0 {
+		return thread.skipBreakpointInstruction(pc)
+	}
+	data, err := thread.readMemory(uintptr(pc-breakpointPCOffset), uintptr(len(breakpointInstruction)))
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(data, breakpointInstruction), nil
+}
+
+// Moves the PC of thread past the breakpoint instruction at pc, if
+// there is one, on architectures where the trap leaves the PC on the
+// instruction, so that resuming doesn't execute it again.
+func (thread *ThreadContext) skipBreakpointInstruction(pc uint64) (bool, error) {
+	if breakpointPCOffset != 0 {
+		return false, nil
+	}
+	data, err := thread.readMemory(uintptr(pc), uintptr(len(breakpointInstruction)))
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(data, breakpointInstruction) {
+		return false, nil
+	}
+	regs, err := thread.Registers()
+	if err != nil {
+		return true, err
+	}
+	return true, regs.SetPC(thread, pc+uint64(len(breakpointInstruction)))
+}
+
+// Returns true if the function name executes a hardcoded
+// breakpoint in the Go version the process was built with.
+func (dbp *DebuggedProcess) isHardcodedBreakpointFunc(name string) bool {
+	version, _ := dbp.goVersion()
+	for _, hb := range hardcodedBreakpoints {
+		if hb.fn == name && strings.HasPrefix(version, hb.version) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the version of Go the process was built with,
+// e.g. "go1.4.2", read from runtime.buildVersion.
+func (dbp *DebuggedProcess) goVersion() (string, error) {
+	addr, _, err := dbp.globalVariable("runtime.buildVersion")
+	if err != nil {
+		return "", err
+	}
+	return dbp.CurrentThread.readString(uintptr(addr))
+}
@@ -0,0 +1,199 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"sort"
+)
+
+// The runtime's mSpanInUse, the state of spans holding heap objects.
+const mSpanInUse = 1
+
+// Objects of spans larger than this, which the runtime calls
+// minSizeForMallocHeader, start with a pointer to their runtime._type
+// unless they hold no pointers.
+const mallocHeaderMinSize = uint64(ptrsize * 8 * ptrsize)
+
+// An object allocated on the heap of the process.
+type HeapObject struct {
+	Addr uint64
+	Size uint64 // Bytes available to the object, rounded up to its size class
+}
+
+func (o HeapObject) String() string {
+	return fmt.Sprintf("%#x (%d bytes)", o.Addr, o.Size)
+}
+
+// Returns the objects of the type with the given name allocated on the
+// heap of the process, by address. Types are named as by the runtime,
+// with the name rather than the import path of their package, e.g.
+// main.Session for the objects *main.Session values point to.
+//
+// Objects are found by walking the spans of the heap, and their type
+// read from the header the runtime allocates them with, which is only
+// recorded for objects holding pointers larger than 512 bytes since Go
+// 1.22, so smaller ones, and those of older runtimes, can't be found.
+// Objects which became unreachable since the last garbage collection
+// are listed until the next one frees them: call runtime.GC() first
+// to only list reachable ones.
+func (dbp *DebuggedProcess) HeapObjects(name string) ([]HeapObject, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	span, err := dbp.findStructType("runtime.mspan")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := structField(span, "largeType"); err != nil {
+		return nil, fmt.Errorf("the runtime of the process doesn't record the types of heap objects")
+	}
+	spans, err := dbp.heapSpans()
+	if err != nil {
+		return nil, err
+	}
+
+	// Type names are read once per type rather than per object. Those
+	// which can't be read, e.g. as the process stopped allocating an
+	// object before writing its header, don't match.
+	names := make(map[uint64]string)
+	match := func(typ uint64) bool {
+		n, ok := names[typ]
+		if !ok {
+			n, _, _ = dbp.runtimeTypeName(typ)
+			names[typ] = n
+		}
+		return n == name
+	}
+
+	var objects []HeapObject
+	for _, addr := range spans {
+		found, err := dbp.spanObjects(addr, span, match)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, found...)
+	}
+	sort.Sort(byHeapAddr(objects))
+	return objects, nil
+}
+
+type byHeapAddr []HeapObject
+
+func (s byHeapAddr) Len() int           { return len(s) }
+func (s byHeapAddr) Less(i, j int) bool { return s[i].Addr < s[j].Addr }
+func (s byHeapAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the addresses of the spans of the heap, in use or not.
+func (dbp *DebuggedProcess) heapSpans() ([]uint64, error) {
+	addr, _, err := dbp.globalVariable("runtime.mheap_")
+	if err != nil {
+		return nil, err
+	}
+	mheap, err := dbp.findStructType("runtime.mheap")
+	if err != nil {
+		return nil, err
+	}
+	f, err := structField(mheap, "allspans")
+	if err != nil {
+		return nil, err
+	}
+	st, ok := resolveTypedef(f.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for allspans", f.Type)
+	}
+	array, length, _, _, err := dbp.sliceHeader(addr+uint64(f.ByteOffset), st)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := dbp.CurrentThread.readMemory(uintptr(array), uintptr(length)*ptrsize)
+	if err != nil {
+		return nil, err
+	}
+	spans := make([]uint64, 0, length)
+	for len(buf) > 0 {
+		spans = append(spans, decodePointer(buf[:ptrsize]))
+		buf = buf[ptrsize:]
+	}
+	return spans, nil
+}
+
+// Returns the objects allocated in the span at addr whose runtime._type
+// is accepted by match. Objects of spans not in use, and those without
+// a type, are skipped.
+func (dbp *DebuggedProcess) spanObjects(addr uint64, span *dwarf.StructType, match func(typ uint64) bool) ([]HeapObject, error) {
+	state, err := dbp.readUintField(addr, span, "state")
+	if err != nil {
+		return nil, err
+	}
+	if state != mSpanInUse {
+		return nil, nil
+	}
+	spanclass, err := dbp.readUintField(addr, span, "spanclass")
+	if err != nil {
+		return nil, err
+	}
+	// Objects holding no pointers have no type recorded.
+	if spanclass&1 != 0 {
+		return nil, nil
+	}
+	base, err := dbp.readUintField(addr, span, "startAddr")
+	if err != nil {
+		return nil, err
+	}
+	elemsize, err := dbp.readUintField(addr, span, "elemsize")
+	if err != nil {
+		return nil, err
+	}
+
+	// Large objects have a span of their own, which records their type.
+	if spanclass>>1 == 0 {
+		typ, err := dbp.readUintField(addr, span, "largeType")
+		if err != nil || typ == 0 {
+			return nil, err
+		}
+		if !match(typ) {
+			return nil, nil
+		}
+		return []HeapObject{{Addr: base, Size: elemsize}}, nil
+	}
+	if elemsize <= mallocHeaderMinSize {
+		return nil, nil
+	}
+
+	nelems, err := dbp.readUintField(addr, span, "nelems")
+	if err != nil {
+		return nil, err
+	}
+	freeindex, err := dbp.readUintField(addr, span, "freeindex")
+	if err != nil {
+		return nil, err
+	}
+	allocBits, err := dbp.readUintField(addr, span, "allocBits")
+	if err != nil {
+		return nil, err
+	}
+	allocated, err := dbp.CurrentThread.readMemory(uintptr(allocBits), uintptr((nelems+7)/8))
+	if err != nil {
+		return nil, err
+	}
+	// The whole span is read at once, rather than each header.
+	mem, err := dbp.CurrentThread.readMemory(uintptr(base), uintptr(nelems*elemsize))
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []HeapObject
+	for i := uint64(0); i < nelems; i++ {
+		// As the runtime's mspan.isFree.
+		if i >= freeindex && allocated[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		off := i * elemsize
+		typ := decodePointer(mem[off : off+uint64(ptrsize)])
+		if typ != 0 && match(typ) {
+			objects = append(objects, HeapObject{Addr: base + off + uint64(ptrsize), Size: elemsize - uint64(ptrsize)})
+		}
+	}
+	return objects, nil
+}
@@ -0,0 +1,67 @@
+package proctl
+
+// Run when the breakpoint it is registered for is hit, see
+// OnBreakpointHit. Returning true resumes the process, as a tracepoint
+// does, rather than leaving it stopped.
+type BreakpointHook func(dbp *DebuggedProcess, bp *BreakPoint) (resume bool)
+
+// Registers hook to run every time the breakpoint, tracepoint or
+// watchpoint with the given id is hit, e.g. to collect the values of
+// some variables and resume, without anyone having to do so by hand.
+// Hooks run on the goroutine driving the process, with the thread
+// which hit the breakpoint as the current one, in the order they were
+// registered. The process resumes once they ran if all of them return
+// true. They may read and write the process, but must not resume it.
+// Hooks are kept across restarts, and removed with the breakpoint.
+func (dbp *DebuggedProcess) OnBreakpointHit(id int, hook BreakpointHook) error {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if _, err := dbp.breakpointByID(id); err != nil {
+		return err
+	}
+	if dbp.hitHooks == nil {
+		dbp.hitHooks = make(map[int][]BreakpointHook)
+	}
+	dbp.hitHooks[id] = append(dbp.hitHooks[id], hook)
+	return nil
+}
+
+// Removes the hooks registered for the breakpoint with the given id.
+func (dbp *DebuggedProcess) ClearBreakpointHooks(id int) {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	delete(dbp.hitHooks, id)
+}
+
+// Runs the hooks of bp, just hit by the current thread, returning
+// whether they all asked for the process to resume. The process is
+// reported as stopped meanwhile. Without hooks, it stops.
+func (dbp *DebuggedProcess) runHitHooks(bp *BreakPoint) bool {
+	dbp.mu.Lock()
+	hooks := dbp.hitHooks[bp.ID]
+	if len(hooks) == 0 {
+		dbp.mu.Unlock()
+		return false
+	}
+	running := dbp.op != nil && dbp.op.running
+	if running {
+		dbp.op.running = false
+	}
+	dbp.mu.Unlock()
+
+	restore := dbp.lend()
+	resume := true
+	for _, hook := range hooks {
+		if !hook(dbp, bp) {
+			resume = false
+		}
+	}
+	restore()
+
+	dbp.mu.Lock()
+	if running {
+		dbp.op.running = true
+	}
+	dbp.mu.Unlock()
+	return resume
+}
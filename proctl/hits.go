@@ -0,0 +1,135 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits the resolution of histograms over long sessions.
+const maxHitBuckets = 10000
+
+// The hits of a breakpoint over the session, counted in buckets of
+// equal length, to see bursts of hits and when they happened.
+type HitHistogram struct {
+	ID     int       // Of the breakpoint
+	Start  time.Time // Of the first bucket, when the session started
+	Bucket time.Duration
+	Counts []int
+}
+
+// Returns the rate of hits per second in bucket i.
+func (h HitHistogram) Rate(i int) float64 {
+	return float64(h.Counts[i]) / h.Bucket.Seconds()
+}
+
+func (h HitHistogram) String() string {
+	max := 0
+	for _, n := range h.Counts {
+		if n > max {
+			max = n
+		}
+	}
+	var buf bytes.Buffer
+	for i, n := range h.Counts {
+		bar := 0
+		if max > 0 {
+			bar = 40 * n / max
+		}
+		from := time.Duration(i) * h.Bucket
+		fmt.Fprintf(&buf, "%10s %10.1f/s %s\n", from, h.Rate(i), strings.Repeat("#", bar))
+	}
+	return buf.String()
+}
+
+// How many times a breakpoint was hit, see HitCounts.
+type HitCount struct {
+	Total      int
+	Goroutines map[int]int // By goroutine ID, 0 for the system stack
+}
+
+func (hc HitCount) String() string {
+	ids := make([]int, 0, len(hc.Goroutines))
+	for id := range hc.Goroutines {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprintf("goroutine %d: %d", id, hc.Goroutines[id])
+	}
+	return fmt.Sprintf("hit %d times (%s)", hc.Total, strings.Join(s, ", "))
+}
+
+// Records a hit of bp by thread. Hits of breakpoints restricted to
+// another goroutine count too, though the process resumes on its own.
+func (dbp *DebuggedProcess) recordHit(thread *ThreadContext, bp *BreakPoint) {
+	var goroutine int
+	if g, err := thread.CurrentGoroutine(); err == nil {
+		goroutine = g.Id
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.hits == nil {
+		dbp.hits = make(map[int][]time.Time)
+		dbp.hitGoroutines = make(map[int]map[int]int)
+	}
+	dbp.hits[bp.ID] = append(dbp.hits[bp.ID], time.Now())
+	if dbp.hitGoroutines[bp.ID] == nil {
+		dbp.hitGoroutines[bp.ID] = make(map[int]int)
+	}
+	dbp.hitGoroutines[bp.ID][goroutine]++
+}
+
+// Returns how many times the breakpoint with the given ID was hit, by
+// all goroutines and by each. Like hits, counts are kept across
+// restarts and after the breakpoint is cleared.
+func (dbp *DebuggedProcess) HitCounts(id int) HitCount {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	hc := HitCount{Total: len(dbp.hits[id]), Goroutines: make(map[int]int)}
+	for g, n := range dbp.hitGoroutines[id] {
+		hc.Goroutines[g] = n
+	}
+	return hc
+}
+
+// Returns when the breakpoint with the given ID was hit, oldest first.
+// Hits are kept across restarts and after the breakpoint is cleared.
+func (dbp *DebuggedProcess) BreakpointHits(id int) []time.Time {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	hits := make([]time.Time, len(dbp.hits[id]))
+	copy(hits, dbp.hits[id])
+	return hits
+}
+
+// Returns the histogram of the hits of the breakpoint with the given ID
+// from the start of the session until now, in buckets of length bucket.
+func (dbp *DebuggedProcess) HitHistogram(id int, bucket time.Duration) (HitHistogram, error) {
+	if bucket <= 0 {
+		return HitHistogram{}, fmt.Errorf("invalid bucket length %s", bucket)
+	}
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	hits, ok := dbp.hits[id]
+	if !ok {
+		if _, err := dbp.breakpointByID(id); err != nil {
+			return HitHistogram{}, err
+		}
+	}
+	n := int(time.Since(dbp.sessionStart)/bucket) + 1
+	if n > maxHitBuckets {
+		return HitHistogram{}, fmt.Errorf("%d buckets of %s needed to cover the session, use longer ones", n, bucket)
+	}
+	h := HitHistogram{ID: id, Start: dbp.sessionStart, Bucket: bucket, Counts: make([]int, n)}
+	for _, t := range hits {
+		i := int(t.Sub(dbp.sessionStart) / bucket)
+		if i >= 0 && i < n {
+			h.Counts[i]++
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,414 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Bit of runtime._type.kind set for types whose values
+// are stored directly in the data word of interfaces.
+const kindDirectIface = 1 << 5
+
+// Bit of runtime._type.tflag set when the name of the
+// type is stored with an extra leading '*'.
+const tflagExtraStar = 1 << 1
+
+// Bit of internal/abi.Type.TFlag replacing kindDirectIface
+// in newer runtimes.
+const tflagDirectIface = 1 << 5
+
+// Returns true if t is the representation of an interface value,
+// runtime.iface for interfaces with methods and runtime.eface for
+// the empty interface.
+func isInterfaceType(t *dwarf.StructType) bool {
+	switch t.StructName {
+	case "runtime.iface", "runtime.eface", "runtime.Iface", "runtime.Eface":
+		return true
+	}
+	return false
+}
+
+// Returns the dynamic type and value of the interface value of type t
+// at addr, e.g. "(*main.Foo) *{Bar: 1}", or "nil" for nil interfaces.
+func (thread *ThreadContext) readInterface(addr uint64, t *dwarf.StructType, recurseLevel int) (string, error) {
+	valaddr, typ, err := thread.Process.interfaceValue(addr, t)
+	if err != nil {
+		return "", err
+	}
+	if typ == nil {
+		return "nil", nil
+	}
+
+	// Don't increase the recursion level, as for pointers.
+	val, err := thread.extractValueInternal(nil, int64(valaddr), typ, false, recurseLevel)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s) %s", typ, val), nil
+}
+
+// Returns the address and type of the value held by the interface
+// value of type t at addr. The address is 0 for nil interfaces.
+func (dbp *DebuggedProcess) interfaceValue(addr uint64, t *dwarf.StructType) (uint64, dwarf.Type, error) {
+	typeaddr, err := dbp.interfaceType(addr, t)
+	if err != nil || typeaddr == 0 {
+		return 0, nil, err
+	}
+
+	name, direct, err := dbp.runtimeTypeName(typeaddr)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ, err := dbp.findType(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := structField(t, "data")
+	if err != nil {
+		return 0, nil, err
+	}
+	if direct {
+		return addr + uint64(data.ByteOffset), typ, nil
+	}
+	val, err := dbp.readPointer(addr + uint64(data.ByteOffset))
+	if err != nil {
+		return 0, nil, err
+	}
+	return val, typ, nil
+}
+
+// Returns the address of the runtime._type describing the
+// dynamic type of the interface value of type t at addr.
+func (dbp *DebuggedProcess) interfaceType(addr uint64, t *dwarf.StructType) (uint64, error) {
+	tab, err := structField(t, "tab")
+	if err != nil {
+		return dbp.readUintField(addr, t, "_type")
+	}
+
+	itabaddr, err := dbp.readPointer(addr + uint64(tab.ByteOffset))
+	if err != nil || itabaddr == 0 {
+		return 0, err
+	}
+	ptr, ok := resolveTypedef(tab.Type).(*dwarf.PtrType)
+	if !ok {
+		return 0, fmt.Errorf("unexpected type %s for itab", tab.Type)
+	}
+	itab, ok := resolveTypedef(ptr.Type).(*dwarf.StructType)
+	if !ok {
+		return 0, fmt.Errorf("unexpected type %s for itab", tab.Type)
+	}
+	for _, name := range []string{"_type", "type", "Type"} {
+		if _, err := structField(itab, name); err == nil {
+			return dbp.readUintField(itabaddr, itab, name)
+		}
+	}
+	return 0, fmt.Errorf("%s has no type member", itab.StructName)
+}
+
+// Returns the name of the type described by the runtime._type at
+// addr, and whether values of the type are stored directly in the
+// data word of interfaces.
+func (dbp *DebuggedProcess) runtimeTypeName(addr uint64) (string, bool, error) {
+	rtype, err := dbp.findStructType("runtime._type")
+	if err != nil {
+		if rtype, err = dbp.findStructType("internal/abi.Type"); err != nil {
+			return "", false, err
+		}
+	}
+
+	var direct bool
+	for _, name := range []string{"kind", "Kind_"} {
+		if _, err := structField(rtype, name); err == nil {
+			kind, err := dbp.readUintField(addr, rtype, name)
+			if err != nil {
+				return "", false, err
+			}
+			direct = kind&kindDirectIface != 0
+			break
+		}
+	}
+
+	// Older runtimes point to the name, newer ones store
+	// the offset of the name within the module's types.
+	if _, err := structField(rtype, "_string"); err == nil {
+		strptr, err := dbp.readUintField(addr, rtype, "_string")
+		if err != nil {
+			return "", false, err
+		}
+		name, err := dbp.CurrentThread.readString(uintptr(strptr))
+		return name, direct, err
+	}
+
+	strfield, tflagfield := "str", "tflag"
+	if _, err := structField(rtype, "Str"); err == nil {
+		strfield, tflagfield = "Str", "TFlag"
+	}
+	off, err := dbp.readIntField(addr, rtype, strfield)
+	if err != nil {
+		return "", false, err
+	}
+	tflag, err := dbp.readUintField(addr, rtype, tflagfield)
+	if err != nil {
+		return "", false, err
+	}
+	types, err := dbp.moduleTypes(addr)
+	if err != nil {
+		return "", false, err
+	}
+	name, err := dbp.readTypeName(types + uint64(off))
+	if err != nil {
+		return "", false, err
+	}
+	if tflag&tflagExtraStar != 0 && len(name) > 0 {
+		name = name[1:]
+	}
+	return name, direct || tflag&tflagDirectIface != 0, nil
+}
+
+// Returns the start of the types section of the module containing
+// the runtime._type at addr, which type name offsets are relative to.
+func (dbp *DebuggedProcess) moduleTypes(addr uint64) (uint64, error) {
+	md, _, err := dbp.globalVariable("runtime.firstmoduledata")
+	if err != nil {
+		return 0, err
+	}
+	mdtype, err := dbp.findStructType("runtime.moduledata")
+	if err != nil {
+		return 0, err
+	}
+
+	for n := 0; md != 0 && n < 1000; n++ {
+		types, err := dbp.readUintField(md, mdtype, "types")
+		if err != nil {
+			return 0, err
+		}
+		etypes, err := dbp.readUintField(md, mdtype, "etypes")
+		if err != nil {
+			return 0, err
+		}
+		if types <= addr && addr < etypes {
+			return types, nil
+		}
+		if md, err = dbp.readUintField(md, mdtype, "next"); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("could not find module of type %#x", addr)
+}
+
+// Reads the encoded type name at addr: a flags byte followed by
+// the length of the name, as a varint in newer runtimes or big
+// endian uint16 in older ones, and the name itself.
+func (dbp *DebuggedProcess) readTypeName(addr uint64) (string, error) {
+	hdr, err := dbp.CurrentThread.readMemory(uintptr(addr), 1+binary.MaxVarintLen16)
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		length uint64
+		n      int
+	)
+	// Names are never empty, so a leading zero
+	// length byte means the uint16 encoding.
+	if hdr[1] == 0 {
+		length, n = uint64(binary.BigEndian.Uint16(hdr[1:])), 2
+	} else {
+		length, n = binary.Uvarint(hdr[1:])
+		if n <= 0 {
+			return "", fmt.Errorf("invalid type name at %#x", addr)
+		}
+	}
+
+	name, err := dbp.CurrentThread.readMemory(uintptr(addr+1+uint64(n)), uintptr(length))
+	if err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// Go specific DWARF attribute holding the address of the runtime._type
+// of a type, or in newer versions its offset in the types section.
+const attrGoRuntimeType dwarf.Attr = 0x2904
+
+// Returns the names of the methods of the interface type name, read
+// from its runtime type descriptor, as DWARF doesn't describe them.
+func (dbp *DebuggedProcess) interfaceMethods(name string) ([]string, error) {
+	typeaddr, err := dbp.runtimeTypeOf(name)
+	if err != nil {
+		return nil, err
+	}
+	itype, err := dbp.findStructType("runtime.interfacetype")
+	if err != nil {
+		if itype, err = dbp.findStructType("internal/abi.InterfaceType"); err != nil {
+			return nil, err
+		}
+	}
+	mhdr, err := structField(itype, "mhdr")
+	if err != nil {
+		if mhdr, err = structField(itype, "Methods"); err != nil {
+			return nil, err
+		}
+	}
+	st, ok := resolveTypedef(mhdr.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for methods of %s", mhdr.Type, itype.StructName)
+	}
+	array, length, _, elem, err := dbp.sliceHeader(typeaddr+uint64(mhdr.ByteOffset), st)
+	if err != nil {
+		return nil, err
+	}
+	imethod, ok := resolveTypedef(elem).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for methods of %s", elem, itype.StructName)
+	}
+	field := "name"
+	if _, err := structField(imethod, field); err != nil {
+		field = "Name"
+	}
+	f, err := structField(imethod, field)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := make([]string, 0, length)
+	for i := uint64(0); i < length; i++ {
+		addr := array + i*uint64(imethod.ByteSize)
+		var name string
+		// Older runtimes point to the name, newer ones store
+		// the offset of the name within the module's types.
+		if _, ok := resolveTypedef(f.Type).(*dwarf.PtrType); ok {
+			ptr, err := dbp.readUintField(addr, imethod, field)
+			if err != nil {
+				return nil, err
+			}
+			name, err = dbp.CurrentThread.readString(uintptr(ptr))
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			off, err := dbp.readIntField(addr, imethod, field)
+			if err != nil {
+				return nil, err
+			}
+			types, err := dbp.moduleTypes(typeaddr)
+			if err != nil {
+				return nil, err
+			}
+			if name, err = dbp.readTypeName(types + uint64(off)); err != nil {
+				return nil, err
+			}
+		}
+		methods = append(methods, name)
+	}
+	return methods, nil
+}
+
+// Returns the address of the runtime._type of the interface type name.
+func (dbp *DebuggedProcess) runtimeTypeOf(name string) (uint64, error) {
+	entries, err := dbp.typeEntries(name)
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if entry.Tag != dwarf.TagTypedef {
+			continue
+		}
+		addr, ok := entry.Val(attrGoRuntimeType).(uint64)
+		if !ok {
+			continue
+		}
+
+		t, err := dbp.Dwarf.Type(entry.Offset)
+		if err != nil {
+			return 0, err
+		}
+		if st, ok := resolveTypedef(t).(*dwarf.StructType); !ok || !isInterfaceType(st) {
+			return 0, fmt.Errorf("%s is not an interface type", name)
+		}
+
+		md, _, err := dbp.globalVariable("runtime.firstmoduledata")
+		if err != nil {
+			return addr, nil
+		}
+		mdtype, err := dbp.findStructType("runtime.moduledata")
+		if err != nil {
+			return 0, err
+		}
+		types, err := dbp.readUintField(md, mdtype, "types")
+		if err != nil {
+			return 0, err
+		}
+		if addr < types {
+			addr += types
+		}
+		return addr, nil
+	}
+	return 0, fmt.Errorf("could not find interface type %s", name)
+}
+
+// Returns the functions implementing method for every type implementing
+// the interface type iface, as found in the symbol table. Methods with
+// value receivers are preferred to the wrappers generated for pointers.
+func (dbp *DebuggedProcess) implementations(iface, method string) ([]*gosym.Func, error) {
+	methods, err := dbp.interfaceMethods(iface)
+	if err != nil {
+		return nil, err
+	}
+	var found bool
+	for _, m := range methods {
+		if m == method {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no method %s", iface, method)
+	}
+
+	funcs := make(map[string]*gosym.Func, len(dbp.GoSymTable.Funcs))
+	for i := range dbp.GoSymTable.Funcs {
+		fn := &dbp.GoSymTable.Funcs[i]
+		funcs[fn.Name] = fn
+	}
+
+	var impls []*gosym.Func
+	seen := make(map[string]bool)
+	for i := range dbp.GoSymTable.Funcs {
+		fn := &dbp.GoSymTable.Funcs[i]
+		recv := fn.ReceiverName()
+		if recv == "" || !strings.HasSuffix(fn.Name, "."+recv+"."+method) {
+			continue
+		}
+		pkg := strings.TrimSuffix(fn.Name, "."+recv+"."+method)
+		typ := pkg + "." + strings.TrimSuffix(strings.TrimPrefix(recv, "(*"), ")")
+		if typ == iface || seen[typ] {
+			continue
+		}
+
+		// The method set of *T includes the methods of T.
+		implements := true
+		for _, m := range methods {
+			if funcs[typ+"."+m] == nil && funcs[pkg+".(*"+typ[len(pkg)+1:]+")."+m] == nil {
+				implements = false
+				break
+			}
+		}
+		if !implements {
+			continue
+		}
+		seen[typ] = true
+		if value := funcs[typ+"."+method]; value != nil {
+			fn = value
+		}
+		impls = append(impls, fn)
+	}
+	if len(impls) == 0 {
+		return nil, fmt.Errorf("no implementation of %s found", iface)
+	}
+	return impls, nil
+}
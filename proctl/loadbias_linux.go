@@ -0,0 +1,70 @@
+package proctl
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+// Entry of the auxiliary vector holding the address of the entry
+// point of the executable, as loaded.
+const atEntry = 9
+
+// Implemented by the backends which can read the auxiliary vector
+// the kernel passed the process, see loadBias.
+type auxvBackend interface {
+	auxv(dbp *DebuggedProcess) ([]byte, error)
+}
+
+func (nativeBackend) auxv(dbp *DebuggedProcess) ([]byte, error) {
+	return ioutil.ReadFile(fmt.Sprintf("/proc/%d/auxv", dbp.Pid))
+}
+
+// Returns the difference between the address the executable exe was
+// loaded at and the one it was linked at, which is only ever non zero
+// for position independent executables. The addresses of the symbol
+// table and frame information are moved by it as they are loaded;
+// those of the DWARF information are translated as it is looked up,
+// see dwarfPC.
+func (dbp *DebuggedProcess) loadBias(exe *elf.File) uint64 {
+	if exe.Type != elf.ET_DYN {
+		return 0
+	}
+	ab, ok := dbp.backend.(auxvBackend)
+	if !ok {
+		return 0
+	}
+	auxv, err := ab.auxv(dbp)
+	if err != nil {
+		return 0
+	}
+	entry, ok := auxvEntry(auxv, atEntry)
+	if !ok {
+		return 0
+	}
+	return entry - exe.Entry
+}
+
+// Returns the value of the entry of the auxiliary vector auxv with the
+// given tag.
+func auxvEntry(auxv []byte, tag uint64) (uint64, bool) {
+	for len(auxv) >= 2*int(ptrsize) {
+		t, v := auxvWord(auxv), auxvWord(auxv[ptrsize:])
+		auxv = auxv[2*ptrsize:]
+		if t == tag {
+			return v, true
+		}
+		if t == 0 {
+			break
+		}
+	}
+	return 0, false
+}
+
+func auxvWord(b []byte) uint64 {
+	if ptrsize == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
+	}
+	return binary.LittleEndian.Uint64(b)
+}
@@ -0,0 +1,56 @@
+package proctl
+
+import "fmt"
+
+// Limits how much of a value is read to print it, so that huge slices
+// or deeply nested structs neither hang the debugger nor flood the
+// output. Applies to EvalSymbol, LocalVariables, FunctionArguments and
+// PackageVariables, see DebuggedProcess.LoadConfig. Negative limits
+// mean no limit.
+type LoadConfig struct {
+	// Whether the values pointers point to are read, rather than
+	// only their address.
+	FollowPointers bool
+	// How deep structs nested in structs are read, deeper ones
+	// being printed as {...}. Pointers and interfaces don't count.
+	MaxVariableRecurse int
+	// How many bytes of strings, and elements of arrays and
+	// slices, are read. The rest is counted, as in "...+10 more".
+	MaxStringLen   int
+	MaxArrayValues int
+}
+
+// The LoadConfig of processes not given one.
+var DefaultLoadConfig = LoadConfig{
+	FollowPointers:     true,
+	MaxVariableRecurse: 1,
+	MaxStringLen:       1024,
+	MaxArrayValues:     64,
+}
+
+func (cfg LoadConfig) String() string {
+	limit := func(n int) string {
+		if n < 0 {
+			return "unlimited"
+		}
+		return fmt.Sprint(n)
+	}
+	return fmt.Sprintf("pointers: %t, depth: %s, strings: %s, arrays: %s", cfg.FollowPointers, limit(cfg.MaxVariableRecurse), limit(cfg.MaxStringLen), limit(cfg.MaxArrayValues))
+}
+
+// Returns the LoadConfig of the process, the default one if none is set.
+func (dbp *DebuggedProcess) loadConfig() LoadConfig {
+	if dbp.LoadConfig == nil {
+		return DefaultLoadConfig
+	}
+	return *dbp.LoadConfig
+}
+
+// Returns how many of n values are read under the limit max, a
+// negative one meaning no limit.
+func loadLimit(n int64, max int) int64 {
+	if max >= 0 && n > int64(max) {
+		return int64(max)
+	}
+	return n
+}
@@ -0,0 +1,221 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/derekparker/delve/dwarf/op"
+)
+
+// Base of the addresses values assembled from pieces are read at. In
+// the part of the address space of the kernel, so that it is never the
+// address of anything in the process.
+const compositeBase = ^uintptr(0) &^ 0xffffff
+
+// A value not in memory as a whole, assembled from the pieces of its
+// location, see locationAddr.
+type compositeMemory struct {
+	addr uintptr
+	data []byte
+}
+
+// Returns the location expression of the variable of entry valid at
+// pc, looking it up in the location lists of the program if the
+// location of the variable changes along its function.
+func (dbp *DebuggedProcess) locationExpr(entry *dwarf.Entry, pc uint64) ([]byte, error) {
+	switch loc := entry.Val(dwarf.AttrLocation).(type) {
+	case []byte:
+		return loc, nil
+	case int64:
+		if dbp.locLists == nil {
+			return nil, fmt.Errorf("no location lists for %s", entry.Val(dwarf.AttrName))
+		}
+		pc = dbp.dwarfPC(pc)
+		base, addrBase, err := dbp.unitBases(pc)
+		if err != nil {
+			return nil, err
+		}
+		expr, err := dbp.locLists.Find(loc, base, addrBase, pc)
+		if err != nil {
+			return nil, err
+		}
+		if expr == nil {
+			return nil, op.ErrOptimizedOut
+		}
+		return expr, nil
+	}
+	return nil, fmt.Errorf("entry has no location attribute")
+}
+
+// Returns the address pc of the process was linked at, which the debug
+// information refers to it by.
+func (dbp *DebuggedProcess) dwarfPC(pc uint64) uint64 {
+	return pc - dbp.staticBase
+}
+
+// Returns the address of the package variable described by the
+// location expression instructions.
+func (dbp *DebuggedProcess) staticAddr(instructions []byte) (uint64, error) {
+	addr, pieces, err := op.ExecuteLocation(instructions, op.DwarfRegisters{StaticBase: dbp.staticBase})
+	if err != nil {
+		return 0, err
+	}
+	if pieces != nil {
+		return 0, fmt.Errorf("value is not in memory")
+	}
+	return uint64(addr), nil
+}
+
+// Returns the base address and the offset of the addresses in
+// .debug_addr of the compilation unit covering pc, as linked.
+func (dbp *DebuggedProcess) unitBases(pc uint64) (uint64, uint64, error) {
+	idx, err := dbp.SymbolIndex()
+	if err != nil {
+		return 0, 0, err
+	}
+	off, ok := idx.UnitAt(pc)
+	if !ok {
+		return 0, 0, fmt.Errorf("no compile unit for %#x", pc)
+	}
+	r := dbp.Dwarf.Reader()
+	r.Seek(off)
+	cu, err := r.Next()
+	if err != nil {
+		return 0, 0, err
+	}
+	base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+	addrBase, _ := cu.Val(dwarf.AttrAddrBase).(int64)
+	return base, uint64(addrBase), nil
+}
+
+// Returns the registers location expressions are evaluated against in
+// the frame of the stack of the thread selected by SelectFrame, the
+// one at the top by default.
+func (thread *ThreadContext) dwarfRegisters() (op.DwarfRegisters, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return op.DwarfRegisters{}, err
+	}
+
+	pc := regs.PC()
+	var cfa int64
+	reg := func(num uint64) (uint64, bool) {
+		return dwarfRegister(regs, num)
+	}
+	if thread.frame == 0 {
+		fde, err := thread.Process.FrameEntries.FDEForPC(pc)
+		if err != nil {
+			return op.DwarfRegisters{}, err
+		}
+		fctx := fde.EstablishFrame(pc)
+		cfa = fctx.CFAOffset() + int64(regs.SP())
+	} else {
+		frame, err := thread.selectedFrame(regs)
+		if err != nil {
+			return op.DwarfRegisters{}, err
+		}
+		pc, cfa = scopePC(frame), int64(frame.cfa)
+		// What the registers held in outer frames is lost.
+		reg = func(uint64) (uint64, bool) {
+			return 0, false
+		}
+	}
+
+	dbp := thread.Process
+	dregs := op.DwarfRegisters{
+		StaticBase: dbp.staticBase,
+		CFA:        cfa,
+		FrameBase:  cfa,
+		Reg:        reg,
+	}
+
+	fn, err := dbp.DwarfReader().SeekToFunction(dbp.dwarfPC(pc))
+	if err != nil {
+		return op.DwarfRegisters{}, err
+	}
+	if fb, ok := fn.Val(dwarf.AttrFrameBase).([]byte); ok {
+		base, _, err := op.ExecuteLocation(fb, dregs)
+		if err != nil {
+			return op.DwarfRegisters{}, err
+		}
+		dregs.FrameBase = base
+	}
+	return dregs, nil
+}
+
+// Returns the address of the value of the given size described by the
+// location expression instructions, in the frame at the top of the
+// stack of the thread. Values not in memory as a whole, kept in
+// registers or split across several locations, are assembled from
+// their pieces and read at an address of their own, until the process
+// is resumed.
+func (thread *ThreadContext) locationAddr(instructions []byte, size int64) (int64, error) {
+	regs, err := thread.dwarfRegisters()
+	if err != nil {
+		return 0, err
+	}
+	addr, pieces, err := op.ExecuteLocation(instructions, regs)
+	if err != nil || pieces == nil {
+		return addr, err
+	}
+
+	var data []byte
+	for _, p := range pieces {
+		n := p.Size
+		if n == 0 {
+			n = int(size)
+		}
+		switch p.Kind {
+		case op.AddrPiece:
+			b, err := thread.readMemory(uintptr(p.Addr), uintptr(n))
+			if err != nil {
+				return 0, err
+			}
+			data = append(data, b...)
+		default:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], p.Value)
+			if n > len(b) {
+				return 0, fmt.Errorf("piece of %d bytes in a register", n)
+			}
+			data = append(data, b[:n]...)
+		}
+	}
+
+	return thread.Process.compositeAddr(data), nil
+}
+
+// Returns the address the value data, not in memory as a whole, is
+// read at until the process is resumed.
+func (dbp *DebuggedProcess) compositeAddr(data []byte) int64 {
+	dbp.compositeMu.Lock()
+	defer dbp.compositeMu.Unlock()
+	cm := compositeMemory{addr: compositeBase, data: data}
+	if n := len(dbp.composites); n > 0 {
+		last := dbp.composites[n-1]
+		cm.addr = last.addr + uintptr(len(last.data))
+	}
+	dbp.composites = append(dbp.composites, cm)
+	return int64(cm.addr)
+}
+
+// Returns the bytes of the values assembled from pieces at addr, and
+// whether addr is the address of one.
+func (dbp *DebuggedProcess) readComposite(addr, size uintptr) ([]byte, bool, error) {
+	if addr < compositeBase {
+		return nil, false, nil
+	}
+	dbp.compositeMu.Lock()
+	defer dbp.compositeMu.Unlock()
+	for _, cm := range dbp.composites {
+		if addr >= cm.addr && addr < cm.addr+uintptr(len(cm.data)) {
+			off := addr - cm.addr
+			if off+size > uintptr(len(cm.data)) {
+				return nil, true, fmt.Errorf("could not read %d bytes at %#x", size, addr)
+			}
+			return append([]byte{}, cm.data[off:off+size]...), true, nil
+		}
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,59 @@
+package proctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Receives the diagnostics of the debugger, see DebuggedProcess.Logger.
+// Debugf traces every ptrace (mach on darwin) request and stop of the
+// process, Infof reports what the process did on its own, e.g. hitting
+// a tracepoint, and Errorf what went wrong without failing an operation.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// Returns a Logger writing information to out and errors to errs, and
+// debug tracing as well if debug is set. A nil writer discards, so
+// NewLogger(nil, nil, false) silences the debugger.
+func NewLogger(out, errs io.Writer, debug bool) Logger {
+	return &writerLogger{out: out, errs: errs, debug: debug}
+}
+
+// Logs to the standard output and error, without debug tracing, as
+// processes do unless given a Logger.
+var defaultLogger = NewLogger(os.Stdout, os.Stderr, false)
+
+type writerLogger struct {
+	out, errs io.Writer
+	debug     bool
+}
+
+func (l *writerLogger) Debugf(format string, args ...interface{}) {
+	if l.debug && l.errs != nil {
+		fmt.Fprintf(l.errs, "debug: "+format+"\n", args...)
+	}
+}
+
+func (l *writerLogger) Infof(format string, args ...interface{}) {
+	if l.out != nil {
+		fmt.Fprintf(l.out, format+"\n", args...)
+	}
+}
+
+func (l *writerLogger) Errorf(format string, args ...interface{}) {
+	if l.errs != nil {
+		fmt.Fprintf(l.errs, format+"\n", args...)
+	}
+}
+
+// Returns the logger of the process, the default one if none is set.
+func (dbp *DebuggedProcess) logger() Logger {
+	if dbp.Logger == nil {
+		return defaultLogger
+	}
+	return dbp.Logger
+}
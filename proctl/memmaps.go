@@ -0,0 +1,112 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Access permissions of a MemoryRegion.
+type MemoryPerm uint8
+
+const (
+	MemoryRead MemoryPerm = 1 << iota
+	MemoryWrite
+	MemoryExec
+	MemoryShared // Shared with other processes rather than copied on write
+)
+
+// Formats the permissions as /proc/<pid>/maps does, e.g. "r-xp".
+func (p MemoryPerm) String() string {
+	b := []byte("---p")
+	if p&MemoryRead != 0 {
+		b[0] = 'r'
+	}
+	if p&MemoryWrite != 0 {
+		b[1] = 'w'
+	}
+	if p&MemoryExec != 0 {
+		b[2] = 'x'
+	}
+	if p&MemoryShared != 0 {
+		b[3] = 's'
+	}
+	return string(b)
+}
+
+// A region of the address space of a process, see MemoryMaps.
+type MemoryRegion struct {
+	Start, End uint64 // [Start, End)
+	Perm       MemoryPerm
+	Offset     uint64 // Of the region in the backing file
+	// The file backing the region, or what the memory is used
+	// for, e.g. [heap] or [stack] on linux, empty if anonymous.
+	Path string
+}
+
+func (r MemoryRegion) String() string {
+	return fmt.Sprintf("%#x-%#x %s %#x %s", r.Start, r.End, r.Perm, r.Offset, r.Path)
+}
+
+// Returns whether the region holds the addresses [addr, addr+size).
+func (r MemoryRegion) Contains(addr, size uint64) bool {
+	return r.Start <= addr && addr+size <= r.End && addr+size >= addr
+}
+
+// Returns the regions the address space of the process is made of, by
+// address, as read from /proc/<pid>/maps on linux and from the VM
+// regions of the task on darwin. Only live processes are supported.
+func (dbp *DebuggedProcess) MemoryMaps() ([]MemoryRegion, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	if name := dbp.backend.info().Name; name != "native" {
+		return nil, fmt.Errorf("memory mappings are only known for live processes, not %s targets", name)
+	}
+	regions, err := memoryMaps(dbp)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(byRegionStart(regions))
+	return regions, nil
+}
+
+type byRegionStart []MemoryRegion
+
+func (r byRegionStart) Len() int           { return len(r) }
+func (r byRegionStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byRegionStart) Less(i, j int) bool { return r[i].Start < r[j].Start }
+
+// Returns the error err of reading [addr, addr+size) from the memory
+// of the process, explaining reads of unmapped memory rather than
+// failing with the bare errno.
+func (dbp *DebuggedProcess) readError(addr, size uint64, err error) error {
+	if _, exited := err.(ProcessExitedError); exited {
+		return err
+	}
+	if merr := dbp.checkReadable(addr, size); merr != nil {
+		return merr
+	}
+	return err
+}
+
+// Returns an error if the addresses [addr, addr+size) aren't all
+// mapped readable in the process, nil if they are or the mappings of
+// the process are unknown.
+func (dbp *DebuggedProcess) checkReadable(addr, size uint64) error {
+	regions, err := dbp.MemoryMaps()
+	if err != nil {
+		return nil
+	}
+	for _, r := range regions {
+		if r.Perm&MemoryRead == 0 || addr < r.Start || addr >= r.End {
+			continue
+		}
+		// Contiguous regions may hold the rest.
+		if addr+size <= r.End {
+			return nil
+		}
+		size -= r.End - addr
+		addr = r.End
+	}
+	return fmt.Errorf("address %#x is not mapped readable", addr)
+}
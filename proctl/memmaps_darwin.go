@@ -0,0 +1,50 @@
+package proctl
+
+// #include "proctl_darwin.h"
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+func memoryMaps(dbp *DebuggedProcess) ([]MemoryRegion, error) {
+	var regions []MemoryRegion
+	var addr C.mach_vm_address_t
+	for {
+		var (
+			size   C.mach_vm_size_t
+			info   C.vm_region_basic_info_data_64_t
+			object C.mach_port_t
+		)
+		count := C.mach_msg_type_number_t(C.VM_REGION_BASIC_INFO_COUNT_64)
+		kret := C.mach_vm_region(C.vm_map_t(dbp.os.task), &addr, &size, C.VM_REGION_BASIC_INFO_64, C.vm_region_info_t(unsafe.Pointer(&info)), &count, &object)
+		if kret == C.KERN_INVALID_ADDRESS {
+			// No region past addr.
+			break
+		}
+		if kret != C.KERN_SUCCESS {
+			return nil, fmt.Errorf("could not read the memory regions of %d", dbp.Pid)
+		}
+
+		region := MemoryRegion{Start: uint64(addr), End: uint64(addr + size), Offset: uint64(info.offset)}
+		if info.protection&C.VM_PROT_READ != 0 {
+			region.Perm |= MemoryRead
+		}
+		if info.protection&C.VM_PROT_WRITE != 0 {
+			region.Perm |= MemoryWrite
+		}
+		if info.protection&C.VM_PROT_EXECUTE != 0 {
+			region.Perm |= MemoryExec
+		}
+		if info.shared != 0 {
+			region.Perm |= MemoryShared
+		}
+		var path [C.PROC_PIDPATHINFO_MAXSIZE]C.char
+		if n := C.proc_regionfilename(C.int(dbp.Pid), C.uint64_t(addr), unsafe.Pointer(&path[0]), C.uint32_t(len(path))); n > 0 {
+			region.Path = C.GoStringN(&path[0], n)
+		}
+		regions = append(regions, region)
+		addr += size
+	}
+	return regions, nil
+}
@@ -0,0 +1,62 @@
+package proctl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func memoryMaps(dbp *DebuggedProcess) ([]MemoryRegion, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", dbp.Pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMemoryMaps(f)
+}
+
+// Parses the regions listed in the format of /proc/<pid>/maps.
+func parseMemoryMaps(r io.Reader) ([]MemoryRegion, error) {
+	var regions []MemoryRegion
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// start-end perms offset dev inode [path]
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 || len(fields[1]) != 4 {
+			return nil, fmt.Errorf("malformed mapping %q", s.Text())
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("malformed mapping %q", s.Text())
+		}
+		start, err := strconv.ParseUint(bounds[0], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed mapping %q", s.Text())
+		}
+		end, err := strconv.ParseUint(bounds[1], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed mapping %q", s.Text())
+		}
+		offset, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed mapping %q", s.Text())
+		}
+
+		region := MemoryRegion{Start: start, End: end, Offset: offset}
+		for i, p := range []MemoryPerm{MemoryRead, MemoryWrite, MemoryExec, MemoryShared} {
+			if fields[1][i] != '-' && fields[1][i] != 'p' {
+				region.Perm |= p
+			}
+		}
+		if len(fields) > 5 {
+			// Paths may hold spaces, and deleted files are
+			// suffixed with " (deleted)".
+			region.Path = strings.Join(fields[5:], " ")
+		}
+		regions = append(regions, region)
+	}
+	return regions, s.Err()
+}
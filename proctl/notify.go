@@ -0,0 +1,138 @@
+package proctl
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// The kinds of events delivered to the channels registered with Notify.
+type EventKind int
+
+const (
+	EventThreadCreated EventKind = iota
+	EventThreadExited
+	EventThreadSwitched // The current thread changed as another one stopped
+	EventBreakpointHit  // Including watchpoints and tracepoints
+	EventProcessExited
+	EventManualStop
+	EventProcessForked // See ChildPolicy
+	EventExec          // A process executed a new program
+	EventRunaway       // See RunawayDetector
+	EventSignal        // The process received a signal, see SignalStop
+	EventSyscall       // A thread entered or left a caught syscall, see CatchSyscall
+	EventStopped       // The process stopped after being resumed, whatever the reason
+)
+
+func (ek EventKind) String() string {
+	switch ek {
+	case EventThreadCreated:
+		return "thread created"
+	case EventThreadExited:
+		return "thread exited"
+	case EventThreadSwitched:
+		return "thread switched"
+	case EventBreakpointHit:
+		return "breakpoint hit"
+	case EventProcessExited:
+		return "process exited"
+	case EventManualStop:
+		return "manual stop"
+	case EventProcessForked:
+		return "process forked"
+	case EventExec:
+		return "exec"
+	case EventRunaway:
+		return "runaway"
+	case EventSignal:
+		return "signal"
+	case EventSyscall:
+		return "syscall"
+	case EventStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// Something that happened to the process while it was being driven.
+type Event struct {
+	Kind       EventKind
+	Thread     int                 // Thread the event is about, if any
+	Previous   int                 // The former current thread, for EventThreadSwitched
+	BreakPoint *BreakPoint         // Set for EventBreakpointHit
+	Exit       *ProcessExitedError // Set for EventProcessExited
+	Process    int                 // The new process for EventProcessForked, the one executing for EventExec
+	Path       string              // The program executed, for EventExec
+	Runaway    *RunawayReport      // Set for EventRunaway
+	Signal     syscall.Signal      // Set for EventSignal
+	Syscall    *SyscallStop        // Set for EventSyscall
+	Watches    []WatchValue        // Values of the watch expressions, for EventStopped
+	Stack      []Frame             // Captured for tracepoints, see BreakPoint.Stacktrace
+}
+
+func (ev Event) String() string {
+	switch ev.Kind {
+	case EventThreadCreated:
+		return fmt.Sprintf("new thread spawned %d", ev.Thread)
+	case EventThreadExited:
+		return fmt.Sprintf("thread %d exited", ev.Thread)
+	case EventThreadSwitched:
+		return fmt.Sprintf("thread context changed from %d to %d", ev.Previous, ev.Thread)
+	case EventBreakpointHit:
+		return fmt.Sprintf("thread %d hit %s", ev.Thread, ev.BreakPoint)
+	case EventProcessExited:
+		return ev.Exit.Error()
+	case EventProcessForked:
+		return fmt.Sprintf("thread %d forked process %d", ev.Thread, ev.Process)
+	case EventExec:
+		return fmt.Sprintf("process %d executed %s", ev.Process, ev.Path)
+	case EventRunaway:
+		return fmt.Sprintf("process running for %s, stopped at thread %d", ev.Runaway.Elapsed, ev.Thread)
+	case EventSignal:
+		return fmt.Sprintf("thread %d received %s", ev.Thread, SignalName(ev.Signal))
+	case EventSyscall:
+		return ev.Syscall.String()
+	case EventStopped:
+		s := fmt.Sprintf("process stopped at thread %d", ev.Thread)
+		for _, wv := range ev.Watches {
+			s += "\n" + wv.String()
+		}
+		return s
+	}
+	return ev.Kind.String()
+}
+
+// Registers ch to receive the events of the process. As with
+// signal.Notify, events are sent without blocking and dropped when ch
+// is full, so ch should be buffered. Events are sent from the
+// goroutine driving the process, as they happen.
+func (dbp *DebuggedProcess) Notify(ch chan<- Event) {
+	dbp.notifyMu.Lock()
+	defer dbp.notifyMu.Unlock()
+	dbp.notify = append(dbp.notify, ch)
+}
+
+// Stops delivering events to ch.
+func (dbp *DebuggedProcess) StopNotify(ch chan<- Event) {
+	dbp.notifyMu.Lock()
+	defer dbp.notifyMu.Unlock()
+	for i, c := range dbp.notify {
+		if c == ch {
+			dbp.notify = append(dbp.notify[:i], dbp.notify[i+1:]...)
+			return
+		}
+	}
+}
+
+// Logs ev for debugging and sends it to the registered channels. It
+// may be called with mu held.
+func (dbp *DebuggedProcess) emit(ev Event) {
+	dbp.logger().Debugf("%s", ev)
+	dbp.notifyMu.Lock()
+	defer dbp.notifyMu.Unlock()
+	for _, ch := range dbp.notify {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,435 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+
+	"github.com/derekparker/delve/dwarf/types"
+)
+
+// Evaluates a literal or the result of an operator to a Variable
+// of the type Go gives it. &x yields the address of x.
+func (thread *ThreadContext) evalOperation(expr ast.Expr) (*Variable, error) {
+	if u, ok := expr.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		addr, t, err := thread.evalAddr(u.X)
+		if err != nil {
+			return nil, err
+		}
+		return &Variable{Name: exprString(expr), Type: "*" + typeName(t), Value: fmt.Sprintf("%#x", addr), Kind: types.Pointer}, nil
+	}
+
+	t, err := thread.exprType(expr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := thread.evalScalar(expr)
+	if err != nil {
+		return nil, err
+	}
+	val, err := convertScalar(v, t)
+	if err != nil {
+		return nil, fmt.Errorf("cannot use %s as a value of type %s: %s", exprString(expr), t, err)
+	}
+	return thread.computedVariable(exprString(expr), t, val), nil
+}
+
+// Evaluates the unary expression e to a Go value, see evalScalar.
+func (thread *ThreadContext) evalUnary(e *ast.UnaryExpr) (interface{}, error) {
+	if e.Op == token.AND {
+		addr, _, err := thread.evalAddr(e.X)
+		if err != nil {
+			return nil, err
+		}
+		return addr, nil
+	}
+
+	x, err := thread.evalScalar(e.X)
+	if err != nil {
+		return nil, err
+	}
+	v, err := unaryOp(e.Op, x)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operation: %s (%s)", exprString(e), err)
+	}
+	return thread.truncate(e, v), nil
+}
+
+// Evaluates the binary expression e to a Go value, see evalScalar.
+// && and || only evaluate their right operand if needed, so that
+// conditions like p != nil && p.x > 0 can be evaluated.
+func (thread *ThreadContext) evalBinary(e *ast.BinaryExpr) (interface{}, error) {
+	x, err := thread.evalScalar(e.X)
+	if err != nil {
+		return nil, err
+	}
+	if e.Op == token.LAND || e.Op == token.LOR {
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid operation: %s (operator %s not defined on %s)", exprString(e), e.Op, exprString(e.X))
+		}
+		if b == (e.Op == token.LOR) {
+			return b, nil
+		}
+	}
+	y, err := thread.evalScalar(e.Y)
+	if err != nil {
+		return nil, err
+	}
+	v, err := binaryOp(e.Op, x, y)
+	if err != nil {
+		return nil, fmt.Errorf("invalid operation: %s (%s)", exprString(e), err)
+	}
+	if _, ok := v.(bool); ok {
+		return v, nil
+	}
+	return thread.truncate(e, v), nil
+}
+
+// Converts v, the result of the operation expr computed on 64 bits,
+// to the type of expr, so that e.g. arithmetic on an uint8 wraps
+// around at 256 as in Go.
+func (thread *ThreadContext) truncate(expr ast.Expr, v interface{}) interface{} {
+	t, err := thread.exprType(expr)
+	if err != nil {
+		return v
+	}
+	if c, err := convertValue(v, t); err == nil {
+		return c
+	}
+	return v
+}
+
+// Returns the type of expr. As in Go, the operands of a binary
+// operator give it their type, untyped constants taking the type of
+// the other operand, and comparisons are of type bool.
+func (thread *ThreadContext) exprType(expr ast.Expr) (dwarf.Type, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return thread.exprType(e.X)
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT:
+			return basicTypes["int"], nil
+		case token.FLOAT:
+			return basicTypes["float64"], nil
+		case token.CHAR:
+			return basicTypes["rune"], nil
+		case token.STRING:
+			return thread.Process.findType("string")
+		}
+	case *ast.Ident:
+		if _, t, err := thread.evalAddr(e); err == nil {
+			return t, nil
+		}
+		if isPredeclaredConst(e) {
+			return basicTypes["bool"], nil
+		}
+	case *ast.UnaryExpr:
+		switch e.Op {
+		case token.NOT:
+			return basicTypes["bool"], nil
+		case token.AND:
+			t, err := thread.exprType(e.X)
+			if err != nil {
+				return nil, err
+			}
+			return thread.pointerTo(t), nil
+		}
+		return thread.exprType(e.X)
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return basicTypes["bool"], nil
+		case token.SHL, token.SHR:
+			return thread.exprType(e.X)
+		}
+		x, err := thread.exprType(e.X)
+		if err != nil {
+			return nil, err
+		}
+		if !isUntyped(e.X) {
+			return x, nil
+		}
+		y, err := thread.exprType(e.Y)
+		if err != nil {
+			return nil, err
+		}
+		// Untyped float constants win over untyped integers.
+		if _, isfloat := y.(*dwarf.FloatType); !isUntyped(e.Y) || isfloat {
+			return y, nil
+		}
+		return x, nil
+	case *ast.CallExpr:
+		if isBuiltinCall(e) {
+			return basicTypes["int"], nil
+		}
+		return thread.typeOf(e.Fun)
+	}
+	_, t, err := thread.evalAddr(expr)
+	return t, err
+}
+
+// Returns the type of pointers to t.
+func (thread *ThreadContext) pointerTo(t dwarf.Type) dwarf.Type {
+	name := "*" + typeName(t)
+	if pt, err := thread.Process.findType(name); err == nil {
+		return pt
+	}
+	return &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: int64(ptrsize), Name: name}, Type: t}
+}
+
+// Returns true if expr is an untyped constant, which takes the type
+// of the other operand of a binary operator.
+func isUntyped(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.ParenExpr:
+		return isUntyped(e.X)
+	case *ast.UnaryExpr:
+		return e.Op != token.AND && isUntyped(e.X)
+	case *ast.BinaryExpr:
+		return isUntyped(e.X) && isUntyped(e.Y)
+	}
+	return false
+}
+
+// Returns true if id is one of the predeclared constants true and
+// false, which a variable of the program may shadow.
+func isPredeclaredConst(id *ast.Ident) bool {
+	return id.Name == "true" || id.Name == "false"
+}
+
+// Applies the unary operator op to x, a value returned by evalScalar.
+func unaryOp(op token.Token, x interface{}) (interface{}, error) {
+	switch x := x.(type) {
+	case int64:
+		switch op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		case token.XOR:
+			return ^x, nil
+		}
+	case uint64:
+		switch op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		case token.XOR:
+			return ^x, nil
+		}
+	case float64:
+		switch op {
+		case token.ADD:
+			return x, nil
+		case token.SUB:
+			return -x, nil
+		}
+	case bool:
+		if op == token.NOT {
+			return !x, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined on %T", op, x)
+}
+
+// Applies the binary operator op to x and y, values returned by
+// evalScalar, after converting them to a common numeric type.
+func binaryOp(op token.Token, x, y interface{}) (interface{}, error) {
+	switch op {
+	case token.EQL:
+		return scalarsEqual(x, y), nil
+	case token.NEQ:
+		return !scalarsEqual(x, y), nil
+	case token.LAND, token.LOR:
+		a, aok := x.(bool)
+		b, bok := y.(bool)
+		if !aok || !bok {
+			return nil, fmt.Errorf("operator %s not defined on %T", op, x)
+		}
+		if op == token.LAND {
+			return a && b, nil
+		}
+		return a || b, nil
+	case token.SHL, token.SHR:
+		return shiftOp(op, x, y)
+	}
+
+	x, y = promoteScalars(x, y)
+	if reflect.TypeOf(x) != reflect.TypeOf(y) {
+		return nil, fmt.Errorf("mismatched types %T and %T", x, y)
+	}
+	switch x := x.(type) {
+	case int64:
+		y := y.(int64)
+		switch op {
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO, token.REM:
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == token.QUO {
+				return x / y, nil
+			}
+			return x % y, nil
+		case token.AND:
+			return x & y, nil
+		case token.OR:
+			return x | y, nil
+		case token.XOR:
+			return x ^ y, nil
+		case token.AND_NOT:
+			return x &^ y, nil
+		}
+	case uint64:
+		y := y.(uint64)
+		switch op {
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO, token.REM:
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == token.QUO {
+				return x / y, nil
+			}
+			return x % y, nil
+		case token.AND:
+			return x & y, nil
+		case token.OR:
+			return x | y, nil
+		case token.XOR:
+			return x ^ y, nil
+		case token.AND_NOT:
+			return x &^ y, nil
+		}
+	case float64:
+		y := y.(float64)
+		switch op {
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			return x / y, nil
+		}
+	case string:
+		y := y.(string)
+		switch op {
+		case token.LSS:
+			return x < y, nil
+		case token.LEQ:
+			return x <= y, nil
+		case token.GTR:
+			return x > y, nil
+		case token.GEQ:
+			return x >= y, nil
+		case token.ADD:
+			return x + y, nil
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined on %T", op, x)
+}
+
+// Shifts the integer x by the non-negative integer y.
+func shiftOp(op token.Token, x, y interface{}) (interface{}, error) {
+	var n uint64
+	switch y := y.(type) {
+	case int64:
+		if y < 0 {
+			return nil, fmt.Errorf("negative shift count %d", y)
+		}
+		n = uint64(y)
+	case uint64:
+		n = y
+	default:
+		return nil, fmt.Errorf("shift count of type %T", y)
+	}
+	switch x := x.(type) {
+	case int64:
+		if op == token.SHL {
+			return x << n, nil
+		}
+		return x >> n, nil
+	case uint64:
+		if op == token.SHL {
+			return x << n, nil
+		}
+		return x >> n, nil
+	}
+	return nil, fmt.Errorf("shift of type %T", x)
+}
+
+// Converts two numeric values returned by evalScalar to the same
+// type: float64 if either is a float64, otherwise uint64 if either
+// is a uint64. Other values are returned unchanged.
+func promoteScalars(x, y interface{}) (interface{}, interface{}) {
+	toFloat := func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case int64:
+			return float64(v)
+		case uint64:
+			return float64(v)
+		}
+		return v
+	}
+	toUint := func(v interface{}) interface{} {
+		if v, ok := v.(int64); ok {
+			return uint64(v)
+		}
+		return v
+	}
+
+	_, xf := x.(float64)
+	_, yf := y.(float64)
+	if xf || yf {
+		return toFloat(x), toFloat(y)
+	}
+	_, xu := x.(uint64)
+	_, yu := y.(uint64)
+	if xu || yu {
+		return toUint(x), toUint(y)
+	}
+	return x, y
+}
@@ -0,0 +1,102 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Bytes we wrote over the code of the process, e.g. the instruction
+// of a software breakpoint, with the ones they replaced.
+type Patch struct {
+	Addr     uint64
+	Original []byte
+	Patched  []byte
+}
+
+// A patch the process overwrote, e.g. by generating code at its
+// address, and what is there now.
+type PatchMismatch struct {
+	Patch
+	Found    []byte
+	Repaired bool // Whether the patch was written again
+}
+
+func (pm PatchMismatch) String() string {
+	s := fmt.Sprintf("%#x: patched % x, found % x", pm.Addr, pm.Patched, pm.Found)
+	if pm.Repaired {
+		s += ", repaired"
+	}
+	return s
+}
+
+// Records that patched was written at addr over original.
+func (dbp *DebuggedProcess) journalPatch(addr uint64, original, patched []byte) {
+	dbp.patchMu.Lock()
+	defer dbp.patchMu.Unlock()
+	if dbp.patches == nil {
+		dbp.patches = make(map[uint64]*Patch)
+	}
+	dbp.patches[addr] = &Patch{Addr: addr, Original: original, Patched: patched}
+}
+
+// Records that the patch at addr was reverted.
+func (dbp *DebuggedProcess) unjournalPatch(addr uint64) {
+	dbp.patchMu.Lock()
+	defer dbp.patchMu.Unlock()
+	delete(dbp.patches, addr)
+}
+
+// Returns the patches currently written to the process, by address.
+func (dbp *DebuggedProcess) Patches() []Patch {
+	dbp.patchMu.Lock()
+	defer dbp.patchMu.Unlock()
+	patches := make([]Patch, 0, len(dbp.patches))
+	for _, p := range dbp.patches {
+		patches = append(patches, *p)
+	}
+	sort.Sort(byPatchAddr(patches))
+	return patches
+}
+
+type byPatchAddr []Patch
+
+func (s byPatchAddr) Len() int           { return len(s) }
+func (s byPatchAddr) Less(i, j int) bool { return s[i].Addr < s[j].Addr }
+func (s byPatchAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Checks that the patches are still in the memory of the process, and
+// returns those that aren't. With repair, the patches are written
+// again; whatever overwrote them becomes the original code, restored
+// when the patch is removed.
+func (dbp *DebuggedProcess) VerifyPatches(repair bool) ([]PatchMismatch, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+
+	var mismatches []PatchMismatch
+	for _, p := range dbp.Patches() {
+		found := make([]byte, len(p.Patched))
+		if _, err := dbp.backend.readMemory(dbp.CurrentThread, uintptr(p.Addr), found); err != nil {
+			return mismatches, err
+		}
+		if bytes.Equal(found, p.Patched) {
+			continue
+		}
+		pm := PatchMismatch{Patch: p, Found: found}
+		if repair {
+			if _, err := dbp.backend.writeMemory(dbp.CurrentThread, uintptr(p.Addr), p.Patched); err != nil {
+				return mismatches, err
+			}
+			if bp, ok := dbp.BreakPoints[p.Addr]; ok {
+				bp.OriginalData = found
+			}
+			dbp.journalPatch(p.Addr, found, p.Patched)
+			pm.Repaired = true
+		}
+		mismatches = append(mismatches, pm)
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,114 @@
+package proctl
+
+import "context"
+
+// An operation waiting for the process to be stopped, see whileStopped.
+type stoppedFn struct {
+	fn   func() error
+	done chan error
+}
+
+// Runs fn, which modifies the process, while its threads are stopped.
+// Unless an operation owns the process, see acquire, fn owns it while
+// it runs. Otherwise fn is queued for the owner, which runs it once it
+// waits for the process through pausableTrapWait, interrupting the wait
+// and resuming the process afterwards transparently, or else once it
+// releases the process. Hooks of the owner, which are lent the stopped
+// process, run fn right away, see lend.
+func (dbp *DebuggedProcess) whileStopped(fn func() error) error {
+	dbp.mu.Lock()
+	switch {
+	case dbp.op == nil:
+		dbp.op = &operation{}
+		dbp.mu.Unlock()
+		defer dbp.release()
+		return fn()
+	case dbp.op.lent:
+		dbp.mu.Unlock()
+		dbp.lentMu.Lock()
+		defer dbp.lentMu.Unlock()
+		return fn()
+	}
+	sf := stoppedFn{fn: fn, done: make(chan error, 1)}
+	dbp.stoppedFns = append(dbp.stoppedFns, sf)
+	if dbp.cancelWait != nil {
+		dbp.cancelWait()
+	}
+	dbp.mu.Unlock()
+	return <-sf.done
+}
+
+// Lends the process, stopped, to the hooks the owner runs, for them to
+// modify it through whileStopped, until the returned function is
+// called. Hooks and other goroutines calling whileStopped meanwhile
+// take turns.
+func (dbp *DebuggedProcess) lend() func() {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.op == nil || dbp.op.lent {
+		return func() {}
+	}
+	dbp.op.lent = true
+	return func() {
+		dbp.mu.Lock()
+		dbp.op.lent = false
+		dbp.mu.Unlock()
+	}
+}
+
+// Waits for the next trap of the process, for pid, or any thread with
+// -1, as trapWait does. The running threads are those resumed, all of
+// them if nil. Operations of whileStopped interrupt the wait, to be run
+// with the process halted, after which the running threads are resumed
+// and waited for again.
+func (dbp *DebuggedProcess) pausableTrapWait(ctx context.Context, pid int, running []*ThreadContext) (int, error) {
+	for {
+		wctx, cancel := context.WithCancel(ctx)
+		dbp.mu.Lock()
+		dbp.cancelWait = cancel
+		if len(dbp.stoppedFns) > 0 {
+			// Asked for while the previous trap was handled.
+			cancel()
+		}
+		dbp.mu.Unlock()
+
+		wpid, err := dbp.trapWait(wctx, pid)
+		dbp.mu.Lock()
+		dbp.cancelWait = nil
+		dbp.mu.Unlock()
+		cancel()
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		if err != context.Canceled {
+			return wpid, err
+		}
+
+		if err := dbp.Halt(); err != nil {
+			return -1, err
+		}
+		dbp.runStopped()
+		threads := running
+		if threads == nil {
+			for _, th := range dbp.Threads {
+				threads = append(threads, th)
+			}
+		}
+		for _, th := range threads {
+			if err := th.Continue(); err != nil {
+				return -1, err
+			}
+		}
+	}
+}
+
+// Runs the operations waiting for the process to be stopped.
+func (dbp *DebuggedProcess) runStopped() {
+	dbp.mu.Lock()
+	fns := dbp.stoppedFns
+	dbp.stoppedFns = nil
+	dbp.mu.Unlock()
+	for _, sf := range fns {
+		sf.done <- sf.fn()
+	}
+}
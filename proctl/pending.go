@@ -0,0 +1,108 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Sets a breakpoint at loc as BreakByLocation does or, when loc can't
+// be resolved in the program of the process, e.g. before it loaded
+// the code, registers a pending breakpoint for it. Pending breakpoints
+// are set once loc resolves in a program loaded by Restart or an exec,
+// keeping their ID.
+func (dbp *DebuggedProcess) BreakPending(loc string) (*BreakPoint, error) {
+	if bp, err := dbp.BreakByLocation(loc); err == nil {
+		return bp, nil
+	} else if _, ok := err.(BreakPointExistsError); ok || !stableLocation(loc) {
+		return nil, err
+	} else if _, ok := err.(AmbiguousLocationError); ok {
+		return nil, err
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	for _, bp := range dbp.pending {
+		if bp.Location == loc {
+			return nil, fmt.Errorf("breakpoint %d is already pending at %s", bp.ID, loc)
+		}
+	}
+	dbp.breakpointIDCounter++
+	bp := &BreakPoint{ID: dbp.breakpointIDCounter, Pending: true, Location: loc}
+	dbp.pending = append(dbp.pending, bp)
+	return bp, nil
+}
+
+// Returns the pending breakpoints, by ID.
+func (dbp *DebuggedProcess) PendingBreakpoints() []*BreakPoint {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	bps := make([]*BreakPoint, len(dbp.pending))
+	copy(bps, dbp.pending)
+	sort.Sort(byID(bps))
+	return bps
+}
+
+// Removes the pending breakpoint at loc.
+func (dbp *DebuggedProcess) clearPending(loc string) (*BreakPoint, bool) {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	for i, bp := range dbp.pending {
+		if bp.Location == loc {
+			dbp.pending = append(dbp.pending[:i], dbp.pending[i+1:]...)
+			return bp, true
+		}
+	}
+	return nil, false
+}
+
+// Sets the pending breakpoints whose location resolves in the program
+// of the process, the others stay pending.
+func (dbp *DebuggedProcess) resolvePending() error {
+	dbp.mu.Lock()
+	pending := dbp.pending
+	dbp.pending = nil
+	dbp.mu.Unlock()
+
+	var firstErr error
+	for _, pbp := range pending {
+		addr, err := dbp.FindLocation(pbp.Location)
+		if err != nil {
+			dbp.mu.Lock()
+			dbp.pending = append(dbp.pending, pbp)
+			dbp.mu.Unlock()
+			continue
+		}
+		bp, err := dbp.breakpoint(addr)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("could not set %s: %s", pbp, err)
+			}
+			continue
+		}
+		bp.ID = pbp.ID
+		bp.Location = pbp.Location
+		bp.Tracepoint = pbp.Tracepoint
+		bp.Variables = pbp.Variables
+		bp.Stacktrace = pbp.Stacktrace
+		bp.Group = pbp.Group
+		bp.Creations = pbp.Creations
+	}
+	return firstErr
+}
+
+// Returns a pending breakpoint waiting for the location of bp.
+func (bp *BreakPoint) pendingCopy() *BreakPoint {
+	loc := bp.Location
+	if loc == "" {
+		loc = fmt.Sprintf("%s:%d", bp.File, bp.Line)
+	}
+	return &BreakPoint{
+		ID:         bp.ID,
+		Pending:    true,
+		Location:   loc,
+		Tracepoint: bp.Tracepoint,
+		Variables:  bp.Variables,
+		Stacktrace: bp.Stacktrace,
+		Group:      bp.Group,
+		Creations:  bp.Creations,
+	}
+}
@@ -0,0 +1,253 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats the value of a struct type located at addr, to be printed
+// in place of its fields, see RegisterPrettyPrinter. An error prints
+// the fields instead, as when the runtime of the process lays the type
+// out differently.
+type PrettyPrinter func(thread *ThreadContext, addr uint64, t *dwarf.StructType) (string, error)
+
+var (
+	prettyPrintersMu sync.RWMutex
+	prettyPrinters   = map[string]PrettyPrinter{
+		"sync.Mutex":         (*ThreadContext).readSyncPrimitive,
+		"sync.RWMutex":       (*ThreadContext).readSyncPrimitive,
+		"sync.WaitGroup":     (*ThreadContext).readSyncPrimitive,
+		"time.Time":          (*ThreadContext).readTime,
+		"errors.errorString": (*ThreadContext).readErrorString,
+		"strings.Builder":    (*ThreadContext).readBuilder,
+		"bytes.Buffer":       (*ThreadContext).readBuffer,
+		"math/big.Int":       (*ThreadContext).readBigInt,
+	}
+)
+
+// Registers pp to print the values of the struct type with the given
+// name, as in the debug info, e.g. "time.Time" or "math/big.Int",
+// replacing the pretty printer of the type if there is one already. A
+// nil pp removes it, printing the fields of the type again.
+func RegisterPrettyPrinter(typeName string, pp PrettyPrinter) {
+	prettyPrintersMu.Lock()
+	defer prettyPrintersMu.Unlock()
+	if pp == nil {
+		delete(prettyPrinters, typeName)
+		return
+	}
+	prettyPrinters[typeName] = pp
+}
+
+// Returns the pretty printer of the struct type with the given name,
+// nil if it has none.
+func prettyPrinter(typeName string) PrettyPrinter {
+	prettyPrintersMu.RLock()
+	defer prettyPrintersMu.RUnlock()
+	return prettyPrinters[typeName]
+}
+
+// Layout of the wall clock of a time.Time with a monotonic reading,
+// see the time package.
+const (
+	timeHasMonotonic   = 1 << 63
+	timeNsecMask       = 1<<30 - 1
+	timeNsecShift      = 30
+	timeWallToUnix     = 59453308800 - 62135596800 // From 1885, the wall epoch
+	timeInternalToUnix = -62135596800              // From year 1, the ext epoch
+)
+
+// Returns the time.Time at addr, e.g. "2009-11-10 23:00:00 +0000 UTC",
+// in its location as known to the debugger.
+func (thread *ThreadContext) readTime(addr uint64, t *dwarf.StructType) (string, error) {
+	dbp := thread.Process
+	var sec, nsec int64
+	if _, err := structField(t, "wall"); err == nil {
+		wall, err := dbp.readUintField(addr, t, "wall")
+		if err != nil {
+			return "", err
+		}
+		ext, err := dbp.readIntField(addr, t, "ext")
+		if err != nil {
+			return "", err
+		}
+		nsec = int64(wall & timeNsecMask)
+		if wall&timeHasMonotonic != 0 {
+			sec = int64(wall<<1>>(timeNsecShift+1)) + timeWallToUnix
+		} else {
+			sec = ext + timeInternalToUnix
+		}
+	} else {
+		// Before Go 1.9, without monotonic readings.
+		if sec, err = dbp.readIntField(addr, t, "sec"); err != nil {
+			return "", err
+		}
+		if nsec, err = dbp.readIntField(addr, t, "nsec"); err != nil {
+			return "", err
+		}
+		sec += timeInternalToUnix
+	}
+
+	name, err := thread.locationName(addr, t)
+	if err != nil {
+		return "", err
+	}
+	tm := time.Unix(sec, nsec).UTC()
+	switch name {
+	case "", "UTC":
+	case "Local":
+		tm = tm.Local()
+	default:
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			// Unknown to the debugger, shown in UTC.
+			return fmt.Sprintf("%s (%s)", tm, name), nil
+		}
+		tm = tm.In(loc)
+	}
+	return tm.String(), nil
+}
+
+// Returns the name of the location of the time.Time of type t located
+// at addr, empty for UTC.
+func (thread *ThreadContext) locationName(addr uint64, t *dwarf.StructType) (string, error) {
+	dbp := thread.Process
+	f, err := structField(t, "loc")
+	if err != nil {
+		return "", err
+	}
+	loc, err := dbp.readPointer(addr + uint64(f.ByteOffset))
+	if err != nil || loc == 0 {
+		return "", err
+	}
+	lt, err := dbp.findStructType("time.Location")
+	if err != nil {
+		return "", err
+	}
+	name, err := structField(lt, "name")
+	if err != nil {
+		return "", err
+	}
+	return thread.readString(uintptr(loc + uint64(name.ByteOffset)))
+}
+
+// Returns the message of the errors.errorString at addr, quoted.
+func (thread *ThreadContext) readErrorString(addr uint64, t *dwarf.StructType) (string, error) {
+	f, err := structField(t, "s")
+	if err != nil {
+		return "", err
+	}
+	s, strlen, err := thread.readStringMax(uintptr(addr+uint64(f.ByteOffset)), thread.Process.loadConfig().MaxStringLen)
+	if err != nil {
+		return "", err
+	}
+	return quoteTruncated([]byte(s), strlen), nil
+}
+
+// Returns what was written to the strings.Builder at addr, quoted.
+func (thread *ThreadContext) readBuilder(addr uint64, t *dwarf.StructType) (string, error) {
+	buf, n, err := thread.readByteSliceField(addr, t, "buf", 0)
+	if err != nil {
+		return "", err
+	}
+	return quoteTruncated(buf, n), nil
+}
+
+// Returns the unread contents of the bytes.Buffer at addr, quoted.
+func (thread *ThreadContext) readBuffer(addr uint64, t *dwarf.StructType) (string, error) {
+	off, err := thread.Process.readIntField(addr, t, "off")
+	if err != nil {
+		return "", err
+	}
+	buf, n, err := thread.readByteSliceField(addr, t, "buf", off)
+	if err != nil {
+		return "", err
+	}
+	return quoteTruncated(buf, n), nil
+}
+
+// Reads the named []byte field of the struct of type t located at
+// addr, from the index off on, up to the MaxStringLen of the
+// LoadConfig of the process, returning the bytes with how many there
+// are from off on.
+func (thread *ThreadContext) readByteSliceField(addr uint64, t *dwarf.StructType, name string, off int64) ([]byte, int64, error) {
+	f, err := structField(t, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	addr += uint64(f.ByteOffset)
+	array, err := thread.Process.readPointer(addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	length, err := thread.Process.readPointer(addr + uint64(ptrsize))
+	if err != nil {
+		return nil, 0, err
+	}
+	n := int64(length) - off
+	if off < 0 || n < 0 {
+		return nil, 0, fmt.Errorf("invalid offset %d in %s of length %d", off, name, length)
+	}
+	size := loadLimit(n, thread.Process.loadConfig().MaxStringLen)
+	if size == 0 {
+		return nil, n, nil
+	}
+	buf, err := thread.readMemory(uintptr(array+uint64(off)), uintptr(size))
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf, n, nil
+}
+
+// Quotes the first bytes of the n there are, counting the others.
+func quoteTruncated(b []byte, n int64) string {
+	s := strconv.Quote(string(b))
+	if more := n - int64(len(b)); more > 0 {
+		s += fmt.Sprintf("...+%d more", more)
+	}
+	return s
+}
+
+// Returns the math/big.Int at addr in base 10.
+func (thread *ThreadContext) readBigInt(addr uint64, t *dwarf.StructType) (string, error) {
+	neg, err := thread.Process.readUintField(addr, t, "neg")
+	if err != nil {
+		return "", err
+	}
+	f, err := structField(t, "abs")
+	if err != nil {
+		return "", err
+	}
+	// A nat is a []Word, of pointer sized words.
+	abs := addr + uint64(f.ByteOffset)
+	array, err := thread.Process.readPointer(abs)
+	if err != nil {
+		return "", err
+	}
+	length, err := thread.Process.readPointer(abs + uint64(ptrsize))
+	if err != nil {
+		return "", err
+	}
+	if length*uint64(ptrsize) > maxMemoryRead {
+		return "", fmt.Errorf("math/big.Int of %d words is too large", length)
+	}
+	words := make([]big.Word, length)
+	if length > 0 {
+		data, err := thread.readMemory(uintptr(array), uintptr(length)*ptrsize)
+		if err != nil {
+			return "", err
+		}
+		for i := range words {
+			words[i] = big.Word(decodePointer(data[uintptr(i)*ptrsize:]))
+		}
+	}
+	n := new(big.Int).SetBits(words)
+	if neg != 0 {
+		n.Neg(n)
+	}
+	return n.String(), nil
+}
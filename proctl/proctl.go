@@ -3,24 +3,46 @@
 package proctl
 
 import (
+	"context"
 	"debug/dwarf"
 	"debug/gosym"
 	"fmt"
+	"go/ast"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	sys "golang.org/x/sys/unix"
 
 	"github.com/derekparker/delve/dwarf/frame"
+	"github.com/derekparker/delve/dwarf/line"
+	"github.com/derekparker/delve/dwarf/loclist"
 	"github.com/derekparker/delve/dwarf/reader"
+	"github.com/derekparker/delve/dwarf/types"
 )
 
 // Struct representing a debugged process. Holds onto pid, register values,
 // process struct and process state.
+//
+// Concurrency model: the operations running the process (Continue, Next,
+// Step and friends) own it one at a time, see acquire, so that several
+// goroutines, e.g. a server and one stopping the process by hand, may
+// call them: each waits for the one owning the process to return. Kill,
+// Detach and Restart stop that operation first, and go before those
+// waiting. Any other goroutine may concurrently call the read-only
+// accessors (Running, Exited, BreakpointExists, FindLocation) and
+// RequestManualStop, even while the process runs, as well as Break and
+// Clear while it is continued. Breakpoint tables, the thread list and
+// execution state are only mutated by the owner of the process, under
+// mu. The symbol tables (Dwarf, GoSymTable, FrameEntries) are immutable
+// once loaded.
 type DebuggedProcess struct {
 	Pid                 int
 	Process             *os.Process
@@ -31,32 +53,195 @@ type DebuggedProcess struct {
 	BreakPoints         map[uint64]*BreakPoint
 	Threads             map[int]*ThreadContext
 	CurrentThread       *ThreadContext
+	path                string
+	attached            bool
+	cmd                 []string     // Command line of launched processes
+	launchCfg           LaunchConfig // How they were launched
+	pty                 *os.File     // Master of their pseudo-terminal, see PTY
 	os                  *OSProcessDetails
+	backend             backend
+	mu                  sync.RWMutex
 	breakpointIDCounter int
-	running             bool
-	halt                bool
+	groupIDCounter      int
+	op                  *operation         // Owning the process, see acquire
+	opReturned          *sync.Cond         // Signalled on mu as op is released, see acquire
+	stoppers            int                // Waiting to stop op and own the process, see acquire
+	cancelWait          context.CancelFunc // Interrupts op waiting for a trap, see whileStopped
+	stoppedFns          []stoppedFn        // Waiting for op to stop the process, see whileStopped
+	lentMu              sync.Mutex         // Taken by whileStopped while op lends the process, see lend
 	exited              bool
-}
+	exitErr             ProcessExitedError
+	stopReason          StopReason
+	times               SessionTimes
+	stateSince          time.Time           // When the process was last resumed or stopped
+	timedRunning        bool                // Whether stateSince is when it was resumed
+	sessionStart        time.Time           // When the debugger took control of the process
+	hits                map[int][]time.Time // Breakpoint hits by ID, see BreakpointHits
+	hitGoroutines       map[int]map[int]int // Breakpoint hits by ID and goroutine, see HitCounts
+	notifyMu            sync.Mutex
+	notify              []chan<- Event // Channels registered with Notify
+	lineMu              sync.Mutex
+	lineIndex           *line.Index              // Built on first use by LineIndex
+	prevUsage           *ResourceUsage           // At the previous stop, see PreviousResourceUsage
+	exitHooks           []func(*DebuggedProcess) // See OnExit
+	exitHooksRun        bool
+	patchMu             sync.Mutex
+	patches             map[uint64]*Patch  // Written to the code, see Patches
+	forks               map[int]bool       // Children followed until they exec
+	pendingForks        map[int]bool       // Children stopped before their fork was reported
+	children            []*DebuggedProcess // Children stopped after exec, see Children
+	pending             []*BreakPoint      // See BreakPending
+	runaway             RunawayDetector
+	lastRunaway         *RunawayReport
+	creations           []*GoroutineCreation            // See BreakOnGoroutineCreation
+	signals             map[syscall.Signal]SignalPolicy // See SetSignalPolicy
+	lastSignal          syscall.Signal
+	syscalls            map[int]bool // Caught syscalls, traced when true, see CatchSyscall
+	lastSyscall         *SyscallStop
+	typeMu              sync.Mutex
+	typeGraph           *types.Graph                 // Go types of the values read, see goType
+	structTypes         map[string]*dwarf.StructType // By name, see findStructType
+	symMu               sync.Mutex
+	symIndex            *reader.Index // See SymbolIndex
+	funcIndex           *funcIndex    // See functionIndex
+	locLists            *loclist.Reader
+	staticBase          uint64 // Load bias of position independent executables, see loadBias
+	compositeMu         sync.Mutex
+	composites          []compositeMemory        // Values assembled from pieces, see locationAddr
+	checkpoints         []*Checkpoint            // See Checkpoint
+	hitHooks            map[int][]BreakpointHook // By breakpoint ID, see OnBreakpointHit
+	checkpointIDCounter int
+	watchExprs          []WatchExpression // See AddWatchExpression
+	watchExprIDCounter  int
+	watchValues         []WatchValue // At the last stop, see WatchValues
+
+	// When set, Continue doesn't stop at hardcoded
+	// breakpoints, such as calls to runtime.Breakpoint.
+	ContinueHardcodedBreakpoints bool
+
+	// What to do with the processes the process forks.
+	ChildPolicy ChildPolicy
 
-// A ManualStopError happens when the user triggers a
-// manual stop via SIGERM.
-type ManualStopError struct{}
+	// Where diagnostics go, the standard output and error if nil.
+	Logger Logger
 
-func (mse ManualStopError) Error() string {
-	return "Manual stop requested"
+	// How much of values is read, DefaultLoadConfig if nil.
+	LoadConfig *LoadConfig
+
+	// Maps the paths of the source files in the debug information,
+	// those of the machine or container the program was built in,
+	// to local ones. Locations given as file:line are mapped back.
+	// Without a mapping, the source files of a process running in
+	// another mount namespace, e.g. a container, are looked for in
+	// its file system when missing from ours.
+	PathMap []PathMapping
 }
 
 // ProcessExitedError indicates that the process has exited and contains both
-// process id and exit status.
+// process id and exit status. If the process was killed, Signal is the
+// signal that killed it.
 type ProcessExitedError struct {
 	Pid    int
 	Status int
+	Signal syscall.Signal
 }
 
 func (pe ProcessExitedError) Error() string {
+	if pe.Signal != 0 {
+		return fmt.Sprintf("process %d was killed by signal %s", pe.Pid, pe.Signal)
+	}
 	return fmt.Sprintf("process %d has exited with status %d", pe.Pid, pe.Status)
 }
 
+// Values of kern_return_t returned by task_for_pid.
+const (
+	kernProtectionFailure = 2
+	kernInvalidArgument   = 4
+	kernFailure           = 5
+)
+
+// TaskAccessError is returned on OS X when the mach task of the
+// process, needed to control it, cannot be acquired. Remediation
+// describes how to fix it when the cause is known. LaunchOnly is set
+// when only this process is out of reach, and launching programs
+// may still work.
+type TaskAccessError struct {
+	Pid         int
+	Code        int // kern_return_t of task_for_pid
+	Remediation string
+	LaunchOnly  bool
+}
+
+func newTaskAccessError(pid, code int) TaskAccessError {
+	tae := TaskAccessError{Pid: pid, Code: code}
+	switch code {
+	case kernFailure:
+		tae.Remediation = "the debugger must be codesigned with a certificate trusted for code signing, or run as root"
+	case kernProtectionFailure:
+		tae.Remediation = "the process is protected by System Integrity Protection or the hardened runtime, debug a build of the program launched by the debugger instead"
+		tae.LaunchOnly = true
+	case kernInvalidArgument:
+		tae.Remediation = "the process does not exist"
+	}
+	return tae
+}
+
+func (tae TaskAccessError) Error() string {
+	if tae.Remediation == "" {
+		return fmt.Sprintf("could not acquire mach task of process %d: kern_return_t %d", tae.Pid, tae.Code)
+	}
+	return fmt.Sprintf("could not acquire mach task of process %d: %s", tae.Pid, tae.Remediation)
+}
+
+// Config describes how a process is set up once the debugger has
+// taken control of it, the same way whether it was launched or
+// attached to. Both leave the process stopped by default.
+type Config struct {
+	// Locations to set breakpoints at, see BreakByLocation.
+	Breakpoints []string
+	// Same, except that those not in the program are kept
+	// pending rather than failing, see BreakPending.
+	PendingBreakpoints []string
+	// Resume the process once its breakpoints are set,
+	// rather than leaving it stopped.
+	Resume bool
+	// Where diagnostics go, see DebuggedProcess.Logger.
+	Logger Logger
+	// How much of values is read, see DebuggedProcess.LoadConfig.
+	LoadConfig *LoadConfig
+	// Where source files are, see DebuggedProcess.PathMap.
+	PathMap []PathMapping
+}
+
+// Sets up a process that was just launched or attached to according
+// to cfg. When cfg.Resume is set it returns once the process stops
+// again, as Continue does.
+func (dbp *DebuggedProcess) Setup(cfg Config) error {
+	if cfg.Logger != nil {
+		dbp.Logger = cfg.Logger
+	}
+	if cfg.LoadConfig != nil {
+		dbp.LoadConfig = cfg.LoadConfig
+	}
+	if cfg.PathMap != nil {
+		dbp.PathMap = cfg.PathMap
+	}
+	for _, loc := range cfg.Breakpoints {
+		if _, err := dbp.BreakByLocation(loc); err != nil {
+			return fmt.Errorf("could not set breakpoint at %s: %s", loc, err)
+		}
+	}
+	for _, loc := range cfg.PendingBreakpoints {
+		if _, err := dbp.BreakPending(loc); err != nil {
+			return fmt.Errorf("could not set breakpoint at %s: %s", loc, err)
+		}
+	}
+	if !cfg.Resume {
+		return nil
+	}
+	return dbp.Continue()
+}
+
 // Attach to an existing process with the given PID.
 func Attach(pid int) (*DebuggedProcess, error) {
 	dbp, err := newDebugProcess(pid, true)
@@ -66,42 +251,412 @@ func Attach(pid int) (*DebuggedProcess, error) {
 	return dbp, nil
 }
 
+// Attach to the running process whose executable is named name, e.g.
+// "server" for /usr/local/bin/server. It is an error for no process, or
+// for several of them, to have that name.
+func AttachByName(name string) (*DebuggedProcess, error) {
+	pids, err := processesByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not list processes: %s", err)
+	}
+	switch len(pids) {
+	case 0:
+		return nil, fmt.Errorf("no process named %s is running", name)
+	case 1:
+		return Attach(pids[0])
+	}
+	sort.Ints(pids)
+	list := make([]string, len(pids))
+	for i, pid := range pids {
+		list[i] = strconv.Itoa(pid)
+	}
+	return nil, fmt.Errorf("%d processes are named %s, attach to one of them by pid: %s",
+		len(pids), name, strings.Join(list, ", "))
+}
+
+// How to launch a program, see LaunchWithConfig. The zero value
+// launches it the way Launch does, in our working directory and
+// environment, with our standard input, output and error.
+type LaunchConfig struct {
+	Dir   string   // Working directory of the program
+	Env   []string // Environment of the program, as returned by os.Environ, when not nil
+	Stdin string   // File to redirect the standard input of the program from
+
+	// Terminal for the standard input, output and error of the
+	// program, e.g. the tty of another terminal window, so that
+	// curses-style and interactive programs don't share ours.
+	TTY string
+	// Allocate a pseudo-terminal for the input and output of the
+	// program instead, see PTY. Linux only.
+	PTY bool
+
+	// The command line building the program from source, e.g. go
+	// build with the -o flag, run in the working directory of the
+	// debugger by RebuildAndRestart.
+	Build []string
+}
+
 // Create and begin debugging a new process. First entry in
 // `cmd` is the program to run, and then rest are the arguments
 // to be supplied to that process.
 func Launch(cmd []string) (*DebuggedProcess, error) {
+	return LaunchWithConfig(cmd, LaunchConfig{})
+}
+
+// Launches cmd as Launch does, setting up the program according to cfg.
+func LaunchWithConfig(cmd []string, cfg LaunchConfig) (*DebuggedProcess, error) {
+	return launch(cmd, cfg, true)
+}
+
+// Launches cmd according to cfg, loading the debug information
+// of the program if symbols is set.
+func launch(cmd []string, cfg LaunchConfig, symbols bool) (*DebuggedProcess, error) {
+	if cfg.TTY != "" && cfg.PTY {
+		return nil, fmt.Errorf("a terminal and a pseudo-terminal can't both be used")
+	}
+	if cfg.Stdin != "" && (cfg.TTY != "" || cfg.PTY) {
+		return nil, fmt.Errorf("standard input can't be redirected when using a terminal")
+	}
+
 	proc := exec.Command(cmd[0])
 	proc.Args = cmd
+	proc.Dir = cfg.Dir
+	proc.Env = cfg.Env
 	proc.Stdout = os.Stdout
 	proc.Stderr = os.Stderr
 	proc.SysProcAttr = &syscall.SysProcAttr{Ptrace: true}
 
-	if err := proc.Start(); err != nil {
+	if cfg.Stdin != "" {
+		f, err := os.Open(cfg.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		proc.Stdin = f
+	}
+	var (
+		term *os.File
+		pty  *os.File
+		err  error
+	)
+	switch {
+	case cfg.TTY != "":
+		term, err = os.OpenFile(cfg.TTY, os.O_RDWR, 0)
+	case cfg.PTY:
+		pty, term, err = openPTY()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if term != nil {
+		defer term.Close()
+		proc.Stdin, proc.Stdout, proc.Stderr = term, term, term
+		// In a session of its own, controlled by the terminal.
+		proc.SysProcAttr.Setsid = true
+		proc.SysProcAttr.Setctty = true
+	}
+
+	// The thread forking the process is its tracer.
+	execPtraceFunc(func() { err = proc.Start() })
+	if err != nil {
+		if pty != nil {
+			pty.Close()
+		}
 		return nil, err
 	}
 
-	_, _, err := wait(proc.Process.Pid, 0)
+	_, _, err = wait(proc.Process.Pid, 0)
 	if err != nil {
 		return nil, fmt.Errorf("waiting for target execve failed: %s", err)
 	}
 
-	return newDebugProcess(proc.Process.Pid, false)
+	dbp, err := newProcess(proc.Process.Pid, false, symbols)
+	if err != nil {
+		// Don't leave the process stopped behind us.
+		proc.Process.Kill()
+		proc.Wait()
+		if pty != nil {
+			pty.Close()
+		}
+		return nil, err
+	}
+	dbp.cmd = cmd
+	dbp.launchCfg = cfg
+	dbp.pty = pty
+	return dbp, nil
+}
+
+// Returns the master side of the pseudo-terminal of a program launched
+// with LaunchConfig.PTY, nil otherwise. Reading from it gets the output
+// of the program, writing to it sends the program input.
+func (dbp *DebuggedProcess) PTY() *os.File {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.pty
+}
+
+// Detaches from the process, first removing all breakpoints and
+// watchpoints so that it can keep running on its own. When kill is
+// set and the process was launched, rather than attached to, it is
+// killed afterwards. Checkpoints are deleted either way.
+func (dbp *DebuggedProcess) Detach(kill bool) error {
+	dbp.acquire(nil, true)
+	defer dbp.release()
+	dbp.clearCheckpoints()
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
+	if err := dbp.clearAllBreakpoints(); err != nil {
+		return err
+	}
+	if err := dbp.backend.detach(dbp); err != nil {
+		return err
+	}
+	if !kill || dbp.attached {
+		return nil
+	}
+	return dbp.kill()
+}
+
+// Kills the process. Its threads are halted and detached from before
+// it is sent SIGKILL and reaped, after which it is reported as exited
+// by the rest of the API. Checkpoints are deleted as well.
+func (dbp *DebuggedProcess) Kill() error {
+	dbp.acquire(nil, true)
+	defer dbp.release()
+	return dbp.haltAndKill()
+}
+
+// Kills the process for Kill, the caller owning it.
+func (dbp *DebuggedProcess) haltAndKill() error {
+	dbp.clearCheckpoints()
+	if dbp.Exited() {
+		return nil
+	}
+	if err := dbp.Halt(); err != nil {
+		if _, ok := err.(ProcessExitedError); ok {
+			return nil
+		}
+		return err
+	}
+	if err := dbp.backend.detach(dbp); err != nil {
+		if _, ok := err.(ProcessExitedError); ok {
+			return nil
+		}
+		return err
+	}
+	return dbp.kill()
+}
+
+// Kills the process and launches its command line again, setting the
+// breakpoints, tracepoints and watchpoints of the old process in the
+// new one. Breakpoints keep their IDs and are found again by location,
+// in case the program was rebuilt, breakpoints whose line is gone
+// become pending, see BreakPending. Restrictions to a goroutine are
+// dropped, as goroutine IDs don't carry over. Only processes launched
+// by the debugger can be restarted.
+func (dbp *DebuggedProcess) Restart() error {
+	if dbp.cmd == nil {
+		return fmt.Errorf("only processes launched by the debugger can be restarted")
+	}
+	dbp.acquire(nil, true)
+	defer dbp.release()
+	if err := dbp.haltAndKill(); err != nil {
+		return err
+	}
+	ndbp, err := LaunchWithConfig(dbp.cmd, dbp.launchCfg)
+	if err != nil {
+		return err
+	}
+
+	dbp.mu.Lock()
+	bps := dbp.userBreakpoints()
+	counter := dbp.breakpointIDCounter
+
+	dbp.Pid = ndbp.Pid
+	dbp.Process = ndbp.Process
+	dbp.Dwarf = ndbp.Dwarf
+	dbp.GoSymTable = ndbp.GoSymTable
+	dbp.FrameEntries = ndbp.FrameEntries
+	dbp.locLists = ndbp.locLists
+	dbp.staticBase = ndbp.staticBase
+	dbp.HWBreakPoints = ndbp.HWBreakPoints
+	dbp.BreakPoints = ndbp.BreakPoints
+	dbp.Threads = ndbp.Threads
+	dbp.CurrentThread = ndbp.CurrentThread
+	dbp.path = ndbp.path
+	dbp.os = ndbp.os
+	dbp.backend = ndbp.backend
+	if dbp.pty != nil {
+		dbp.pty.Close()
+	}
+	dbp.pty = ndbp.pty
+	dbp.exited = false
+	dbp.exitErr = ProcessExitedError{}
+	dbp.stopReason = StopUnknown
+	dbp.prevUsage = nil
+	dbp.watchValues = nil
+	dbp.exitHooksRun = false
+	dbp.forks, dbp.pendingForks = nil, nil
+	dbp.patchMu.Lock()
+	dbp.patches = nil
+	dbp.patchMu.Unlock()
+	for _, th := range dbp.Threads {
+		th.Process = dbp
+	}
+	dbp.mu.Unlock()
+	dbp.lineMu.Lock()
+	dbp.lineIndex = nil
+	dbp.lineMu.Unlock()
+	dbp.typeMu.Lock()
+	dbp.typeGraph = nil
+	dbp.structTypes = nil
+	dbp.typeMu.Unlock()
+	dbp.symMu.Lock()
+	dbp.symIndex, dbp.funcIndex = nil, nil
+	dbp.symMu.Unlock()
+	if dbp.tracingExit() {
+		if err := dbp.traceExit(true); err != nil {
+			return err
+		}
+	}
+
+	for _, bp := range bps {
+		if err := dbp.restoreBreakpoint(bp); err != nil {
+			if bp.Variable != "" {
+				return fmt.Errorf("could not restore %s: %s", bp, err)
+			}
+			// The line is gone from the program, wait for it to return.
+			dbp.pending = append(dbp.pending, bp.pendingCopy())
+		}
+	}
+	dbp.breakpointIDCounter = counter
+	return dbp.resolvePending()
+}
+
+// Builds the program again with the Build command of the LaunchConfig
+// it was launched with, and then restarts it as Restart does, its
+// breakpoints being found again in the new binary by location. If the
+// build fails, its output is returned in the error and the process is
+// left as it is.
+func (dbp *DebuggedProcess) RebuildAndRestart() error {
+	if dbp.cmd == nil || len(dbp.launchCfg.Build) == 0 {
+		return fmt.Errorf("only processes built by the debugger can be rebuilt")
+	}
+	build := dbp.launchCfg.Build
+	if out, err := exec.Command(build[0], build[1:]...).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not rebuild program: %s\n%s", err, out)
+	}
+	return dbp.Restart()
+}
+
+// Sets the breakpoint bp of a previous run of the program.
+func (dbp *DebuggedProcess) restoreBreakpoint(bp *BreakPoint) error {
+	var (
+		nbp *BreakPoint
+		err error
+	)
+	if bp.Variable != "" {
+		nbp, err = dbp.WatchGlobal(bp.Variable)
+	} else {
+		addr := bp.Addr
+		// Find the location again if the program changed, the
+		// function it was given as may have moved to another line.
+		if bp.Location != "" && bp.Group == 0 {
+			if addr, err = dbp.FindLocation(bp.Location); err != nil {
+				return err
+			}
+		} else if f, l, fn := dbp.GoSymTable.PCToLine(addr); fn == nil || fn.Name != bp.FunctionName || f != bp.File || l != bp.Line {
+			if addr, _, err = dbp.GoSymTable.LineToPC(bp.File, bp.Line); err != nil {
+				return err
+			}
+		}
+		nbp, err = dbp.breakpoint(addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	nbp.ID = bp.ID
+	nbp.Location = bp.Location
+	nbp.Tracepoint = bp.Tracepoint
+	nbp.Variables = bp.Variables
+	nbp.Stacktrace = bp.Stacktrace
+	nbp.Group = bp.Group
+	nbp.Creations = bp.Creations
+	if bp.Disabled {
+		return dbp.setBreakpointEnabled(dbp.CurrentThread.Id, nbp, false)
+	}
+	return nil
+}
+
+// Sends SIGKILL to the process we are no longer tracing and reaps it,
+// if it is our child. There is no process behind a core file, it is
+// only reported as exited.
+func (dbp *DebuggedProcess) kill() error {
+	if dbp.Process != nil {
+		if dbp.tracingExit() {
+			// Exit hooks are for the process exiting on its own.
+			dbp.traceExit(false)
+		}
+		if err := dbp.Process.Kill(); err != nil {
+			return err
+		}
+		dbp.Process.Wait()
+	}
+	dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: -1, Signal: syscall.SIGKILL})
+	return nil
 }
 
 // Returns whether or not Delve thinks the debugged
-// process has exited.
+// process has exited. Once it has, operations needing the process
+// return the ProcessExitedError it exited with, see ExitStatus.
 func (dbp *DebuggedProcess) Exited() bool {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
 	return dbp.exited
 }
 
+// Returns the status the process exited with, once Exited, or -1 if it
+// was killed by a signal. It is -1 as well while the process is alive.
+func (dbp *DebuggedProcess) ExitStatus() int {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	if !dbp.exited || dbp.exitErr.Signal != 0 {
+		return -1
+	}
+	return dbp.exitErr.Status
+}
+
 // Returns whether or not Delve thinks the debugged
 // process is currently executing.
 func (dbp *DebuggedProcess) Running() bool {
-	return dbp.running
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.op != nil && dbp.op.running
 }
 
-// Find a location by string (file+line, function, breakpoint id, addr)
+// Find a location by string: file+line, function, runtime event, line
+// relative to the one the current thread is stopped at (+n or -n),
+// breakpoint id, or raw address (*addr). Functions can be named
+// without their package path, or package, and methods as (*T).M or
+// T.M, as long as only one function matches, otherwise an
+// AmbiguousLocationError lists those which do.
 func (dbp *DebuggedProcess) FindLocation(str string) (uint64, error) {
+	// Raw address
+	if strings.HasPrefix(str, "*") {
+		addr, err := strconv.ParseUint(str[1:], 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid address %s", str[1:])
+		}
+		return addr, nil
+	}
+
+	// Relative line
+	if strings.HasPrefix(str, "+") || strings.HasPrefix(str, "-") {
+		return dbp.relativeLine(str)
+	}
+
 	// File + Line
 	if strings.ContainsRune(str, ':') {
 		fl := strings.Split(str, ":")
@@ -116,25 +671,35 @@ func (dbp *DebuggedProcess) FindLocation(str string) (uint64, error) {
 			return 0, err
 		}
 
-		pc, _, err := dbp.GoSymTable.LineToPC(fileName, line)
+		pc, _, err := dbp.GoSymTable.LineToPC(dbp.debugPath(fileName), line)
 		if err != nil {
 			return 0, err
 		}
 		return pc, nil
 	} else {
 		// Try to lookup by function name
-		fn := dbp.GoSymTable.LookupFunc(str)
+		fn, err := dbp.findFunction(str)
+		if err != nil {
+			return 0, err
+		}
 		if fn != nil {
 			return fn.Entry, nil
 		}
 
-		// Attempt to parse as number for breakpoint id or raw address
+		// Named runtime event
+		if _, ok := runtimeEvents[str]; ok {
+			return dbp.eventLocation(str)
+		}
+
+		// Attempt to parse as number for breakpoint id
 		id, err := strconv.ParseUint(str, 0, 64)
 		if err != nil {
 			return 0, fmt.Errorf("unable to find location for %s", str)
 		}
 
 		// Use as breakpoint id
+		dbp.mu.RLock()
+		defer dbp.mu.RUnlock()
 		for _, bp := range dbp.HWBreakPoints {
 			if bp == nil {
 				continue
@@ -148,20 +713,94 @@ func (dbp *DebuggedProcess) FindLocation(str string) (uint64, error) {
 				return bp.Addr, nil
 			}
 		}
+		return 0, fmt.Errorf("no breakpoint with id %s, use *%s for an address", str, str)
+	}
+}
+
+// Returned by FindLocation for a function name matching several
+// functions, listed in Candidates.
+type AmbiguousLocationError struct {
+	Location   string
+	Candidates []string
+}
+
+func (ale AmbiguousLocationError) Error() string {
+	return fmt.Sprintf("location %s is ambiguous: %s", ale.Location, strings.Join(ale.Candidates, ", "))
+}
+
+// Returns the function named name, in full or shortened, see
+// FindLocation, or nil if there is none. The receiver of a method
+// given without (*) only matters when no method of T is named so, as
+// for every method of T the compiler generates one of *T.
+func (dbp *DebuggedProcess) findFunction(name string) (*gosym.Func, error) {
+	if fn := dbp.lookupFunc(name); fn != nil {
+		return fn, nil
+	}
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		// Not an identifier, but e.g. a breakpoint ID, which would
+		// match closures as func1.1.
+		return nil, nil
+	}
+	idx := dbp.functionIndex()
+	matches := idx.lookup(name, false)
+	if len(matches) == 0 && !strings.ContainsRune(name, '(') {
+		matches = idx.lookup(name, true)
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil
+	case 1:
+		return matches[0], nil
+	}
+	ale := AmbiguousLocationError{Location: name}
+	for _, f := range matches {
+		ale.Candidates = append(ale.Candidates, f.Name)
+	}
+	sort.Strings(ale.Candidates)
+	return nil, ale
+}
+
+// Returns the address of the line offset lines after the one the
+// current thread is stopped at, offset being given as +n or -n.
+func (dbp *DebuggedProcess) relativeLine(offset string) (uint64, error) {
+	n, err := strconv.Atoi(offset)
+	if err != nil {
+		return 0, fmt.Errorf("invalid line offset %s", offset)
+	}
+	pc, err := dbp.CurrentPC()
+	if err != nil {
+		return 0, err
+	}
+	f, l, fn := dbp.GoSymTable.PCToLine(pc)
+	if fn == nil {
+		return 0, fmt.Errorf("no line for the current location %#x", pc)
+	}
+	pc, _, err = dbp.GoSymTable.LineToPC(f, l+n)
+	if err != nil {
+		return 0, err
+	}
+	return pc, nil
+}
 
-		// Last resort, use as raw address
-		return id, nil
+// Returns whether loc names the same place in every run of the program,
+// rather than depending on where it is stopped, the breakpoints it has
+// or the addresses of a build.
+func stableLocation(loc string) bool {
+	if _, err := strconv.ParseUint(loc, 0, 64); err == nil {
+		return false
 	}
+	return !strings.HasPrefix(loc, "*") && !strings.HasPrefix(loc, "+") && !strings.HasPrefix(loc, "-")
 }
 
-// Sends out a request that the debugged process halt
-// execution. Sends SIGSTOP to all threads.
+// Sends out a request that the debugged process halt execution, by
+// cancelling the operation running it. The operation stops the process
+// and returns without error. Does nothing if the process isn't running.
 func (dbp *DebuggedProcess) RequestManualStop() {
-	dbp.halt = true
-	for _, th := range dbp.Threads {
-		th.Halt()
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	if dbp.op != nil && dbp.op.cancel != nil {
+		dbp.op.cancel()
 	}
-	dbp.running = false
 }
 
 // Sets a breakpoint at addr, and stores it in the process wide
@@ -173,141 +812,604 @@ func (dbp *DebuggedProcess) RequestManualStop() {
 // hardware supports it, and there are free debug registers, Delve
 // will set a hardware breakpoint. Otherwise we fall back to software
 // breakpoints, which are a bit more work for us.
-func (dbp *DebuggedProcess) Break(addr uint64) (*BreakPoint, error) {
+//
+// The breakpoint can be set while another goroutine runs the process,
+// which is then briefly stopped to set it, see whileStopped.
+func (dbp *DebuggedProcess) Break(addr uint64) (bp *BreakPoint, err error) {
+	err = dbp.whileStopped(func() error {
+		bp, err = dbp.breakpoint(addr)
+		return err
+	})
+	return bp, err
+}
+
+// Sets a breakpoint at addr as Break does, for the operation owning
+// the process, e.g. the temporary ones of StepOut.
+func (dbp *DebuggedProcess) breakpoint(addr uint64) (*BreakPoint, error) {
 	return dbp.setBreakpoint(dbp.CurrentThread.Id, addr)
 }
 
-// Sets a breakpoint by location string (function, file+line, address)
+// Sets a breakpoint by location string (function, file+line, address).
+// A line entered at several addresses, as the header of a loop, gets
+// a breakpoint at each of them, set as a group, see linePCs. The one
+// at the address FindLocation returns is returned.
 func (dbp *DebuggedProcess) BreakByLocation(loc string) (*BreakPoint, error) {
+	bps, err := dbp.breakByLocation(loc)
+	if err != nil {
+		return nil, err
+	}
+	return bps[0], nil
+}
+
+// Sets the breakpoints of BreakByLocation, and returns them all.
+func (dbp *DebuggedProcess) breakByLocation(loc string) ([]*BreakPoint, error) {
 	addr, err := dbp.FindLocation(loc)
 	if err != nil {
 		return nil, err
 	}
-	return dbp.Break(addr)
+	bp, err := dbp.Break(addr)
+	if err != nil {
+		return nil, err
+	}
+	if stableLocation(loc) {
+		bp.Location = loc
+	}
+	bps := []*BreakPoint{bp}
+	if !strings.ContainsRune(loc, ':') {
+		return bps, nil
+	}
+
+	for _, pc := range dbp.linePCs(addr) {
+		if pc == addr {
+			continue
+		}
+		lbp, err := dbp.Break(pc)
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); ok {
+				continue
+			}
+			return bps, err
+		}
+		bps = append(bps, lbp)
+	}
+	if len(bps) > 1 {
+		dbp.groupIDCounter++
+		for _, lbp := range bps {
+			lbp.Group = dbp.groupIDCounter
+		}
+	}
+	return bps, nil
 }
 
-// Clears a breakpoint in the current thread.
-func (dbp *DebuggedProcess) Clear(addr uint64) (*BreakPoint, error) {
-	return dbp.clearBreakpoint(dbp.CurrentThread.Id, addr)
+// Returns the addresses the line of the statement at pc is entered
+// at, pc included, see line.Index.AllPCsForFileLine.
+func (dbp *DebuggedProcess) linePCs(pc uint64) []uint64 {
+	idx, err := dbp.LineIndex()
+	if err != nil {
+		return nil
+	}
+	e, ok := idx.Lookup(dbp.dwarfPC(pc))
+	if !ok {
+		return nil
+	}
+	pcs := idx.AllPCsForFileLine(e.File, e.Line)
+	for i := range pcs {
+		pcs[i] += dbp.staticBase
+	}
+	return pcs
 }
 
-// Clears a breakpoint by location (function, file+line, address, breakpoint id)
-func (dbp *DebuggedProcess) ClearByLocation(loc string) (*BreakPoint, error) {
-	addr, err := dbp.FindLocation(loc)
+// Sets a breakpoint by location string which only stops the process
+// when hit by the goroutine with the given id. Other goroutines
+// hitting it are continued past.
+func (dbp *DebuggedProcess) BreakByLocationOnGoroutine(loc string, id int) (*BreakPoint, error) {
+	if err := dbp.checkGoroutine(id); err != nil {
+		return nil, err
+	}
+	bps, err := dbp.breakByLocation(loc)
 	if err != nil {
 		return nil, err
 	}
-	return dbp.Clear(addr)
+	for _, bp := range bps {
+		bp.Goroutine = id
+	}
+	return bps[0], nil
 }
 
-// Returns the status of the current main thread context.
-func (dbp *DebuggedProcess) Status() *sys.WaitStatus {
-	return dbp.CurrentThread.Status
+// Sets a tracepoint by location string. When hit, the location and
+// the values of the given variables are printed, and the process
+// continues.
+func (dbp *DebuggedProcess) TraceByLocation(loc string, variables ...string) (*BreakPoint, error) {
+	bps, err := dbp.breakByLocation(loc)
+	if err != nil {
+		return nil, err
+	}
+	for _, bp := range bps {
+		bp.Tracepoint = true
+		bp.Variables = variables
+	}
+	return bps[0], nil
 }
 
-// Step over function calls.
-func (dbp *DebuggedProcess) Next() error {
-	var runnable []*ThreadContext
+// Sets a breakpoint on the method of every type implementing an
+// interface, given as "pkg.Interface.Method". Methods which already
+// have a breakpoint are skipped.
+func (dbp *DebuggedProcess) BreakInterfaceMethod(name string) ([]*BreakPoint, error) {
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid interface method %s", name)
+	}
+	impls, err := dbp.implementations(name[:i], name[i+1:])
+	if err != nil {
+		return nil, err
+	}
 
-	fn := func() error {
-		for _, th := range dbp.Threads {
-			// Continue any blocked M so that the
-			// scheduler can continue to do its'
-			// job correctly.
-			if th.blocked() {
-				err := th.Continue()
-				if err != nil {
-					return err
-				}
+	bps := make([]*BreakPoint, 0, len(impls))
+	for _, fn := range impls {
+		bp, err := dbp.Break(fn.Entry)
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); ok {
 				continue
 			}
-
-			runnable = append(runnable, th)
-		}
-		for _, th := range runnable {
-			err := th.Next()
-			if err != nil && err != sys.ESRCH {
-				return err
-			}
+			return bps, err
 		}
-		return dbp.Halt()
+		bps = append(bps, bp)
 	}
-	return dbp.run(fn)
+	return bps, nil
 }
 
-// Resume process.
-func (dbp *DebuggedProcess) Continue() error {
-	for _, thread := range dbp.Threads {
-		err := thread.Continue()
-		if err != nil {
-			return err
+// Sets a breakpoint, or a tracepoint when trace is set, on every
+// exported function of the package with the given import path and on
+// the exported methods of its exported types. Functions which already
+// have a breakpoint are skipped.
+func (dbp *DebuggedProcess) BreakPackage(pkg string, trace bool) ([]*BreakPoint, error) {
+	var fns []*gosym.Func
+	for i := range dbp.GoSymTable.Funcs {
+		fn := &dbp.GoSymTable.Funcs[i]
+		if fn.Sym == nil || fn.PackageName() != pkg || !exportedFunc(fn) {
+			continue
 		}
+		fns = append(fns, fn)
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("no exported functions in package %s", pkg)
 	}
 
-	fn := func() error {
-		wpid, err := trapWait(dbp, -1)
+	bps := make([]*BreakPoint, 0, len(fns))
+	for _, fn := range fns {
+		bp, err := dbp.Break(fn.Entry)
 		if err != nil {
-			return err
+			if _, ok := err.(BreakPointExistsError); ok {
+				continue
+			}
+			return bps, err
 		}
+		bp.Tracepoint = trace
+		bps = append(bps, bp)
+	}
+	return bps, nil
+}
 
-		thread, ok := dbp.Threads[wpid]
-		if !ok {
-			return fmt.Errorf("could not find thread for %d", wpid)
-		}
+// Breakpoints set together, which can be cleared together.
+type BreakpointGroup struct {
+	ID          int
+	Pattern     string
+	BreakPoints []*BreakPoint
+}
 
-		if wpid != dbp.CurrentThread.Id {
-			fmt.Printf("thread context changed from %d to %d\n", dbp.CurrentThread.Id, thread.Id)
-			dbp.CurrentThread = thread
+// Sets a breakpoint on every function whose name matches the regular
+// expression re, e.g. `^main\.\(\*Foo\)\.` for the methods of *Foo.
+// Functions already having a breakpoint are skipped. The breakpoints
+// are returned as a group, see ClearGroup.
+func (dbp *DebuggedProcess) BreakByFunctionRegexp(re string) (*BreakpointGroup, error) {
+	r, err := regexp.Compile(re)
+	if err != nil {
+		return nil, err
+	}
+	var fns []*gosym.Func
+	for i := range dbp.GoSymTable.Funcs {
+		fn := &dbp.GoSymTable.Funcs[i]
+		if fn.Sym != nil && r.MatchString(fn.Name) {
+			fns = append(fns, fn)
 		}
+	}
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("no functions matching %s", re)
+	}
 
-		pc, err := thread.CurrentPC()
+	dbp.groupIDCounter++
+	g := &BreakpointGroup{ID: dbp.groupIDCounter, Pattern: re}
+	for _, fn := range fns {
+		bp, err := dbp.Break(fn.Entry)
 		if err != nil {
-			return err
+			if _, ok := err.(BreakPointExistsError); ok {
+				continue
+			}
+			return g, err
 		}
+		bp.Group = g.ID
+		g.BreakPoints = append(g.BreakPoints, bp)
+	}
+	return g, nil
+}
 
-		// Check to see if we hit a runtime.breakpoint
-		fn := dbp.GoSymTable.PCToFunc(pc)
-		if fn != nil && fn.Name == "runtime.breakpoint" {
-			// step twice to get back to user code
-			for i := 0; i < 2; i++ {
-				err = thread.Step()
+// Clears the breakpoints of the group with the given ID, pending ones
+// included.
+func (dbp *DebuggedProcess) ClearGroup(id int) ([]*BreakPoint, error) {
+	dbp.mu.Lock()
+	var bps, cleared []*BreakPoint
+	for _, bp := range dbp.userBreakpoints() {
+		if bp.Group == id {
+			bps = append(bps, bp)
+		}
+	}
+	pending := dbp.pending[:0]
+	for _, bp := range dbp.pending {
+		if bp.Group == id {
+			cleared = append(cleared, bp)
+		} else {
+			pending = append(pending, bp)
+		}
+	}
+	dbp.pending = pending
+	dbp.mu.Unlock()
+	if len(bps) == 0 && len(cleared) == 0 {
+		return nil, fmt.Errorf("no breakpoints in group %d", id)
+	}
+
+	for _, bp := range bps {
+		if _, err := dbp.Clear(bp.Addr); err != nil {
+			return cleared, err
+		}
+		cleared = append(cleared, bp)
+	}
+	return cleared, nil
+}
+
+// Returns whether fn is an exported function, or an exported
+// method of an exported type.
+func exportedFunc(fn *gosym.Func) bool {
+	if !ast.IsExported(fn.BaseName()) {
+		return false
+	}
+	recv := strings.Trim(fn.ReceiverName(), "(*)")
+	return recv == "" || ast.IsExported(recv)
+}
+
+// Clears a breakpoint in the current thread. As with Break, this can
+// be done while another goroutine runs the process.
+func (dbp *DebuggedProcess) Clear(addr uint64) (bp *BreakPoint, err error) {
+	err = dbp.whileStopped(func() error {
+		bp, err = dbp.clear(addr)
+		return err
+	})
+	return bp, err
+}
+
+// Clears the breakpoint at addr as Clear does, for the
+// operation owning the process.
+func (dbp *DebuggedProcess) clear(addr uint64) (*BreakPoint, error) {
+	bp, err := dbp.clearBreakpoint(dbp.CurrentThread.Id, addr)
+	if err != nil {
+		return nil, err
+	}
+	dbp.ClearBreakpointHooks(bp.ID)
+	return bp, nil
+}
+
+// Disables the breakpoint with the given id. The breakpoint is removed
+// from the process but keeps its ID, and can be enabled again.
+func (dbp *DebuggedProcess) DisableBreakpoint(id int) (*BreakPoint, error) {
+	return dbp.toggleBreakpoint(id, false)
+}
+
+// Enables the breakpoint with the given id, previously disabled.
+func (dbp *DebuggedProcess) EnableBreakpoint(id int) (*BreakPoint, error) {
+	return dbp.toggleBreakpoint(id, true)
+}
+
+func (dbp *DebuggedProcess) toggleBreakpoint(id int, enabled bool) (*BreakPoint, error) {
+	dbp.mu.RLock()
+	bp, err := dbp.breakpointByID(id)
+	dbp.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := dbp.setBreakpointEnabled(dbp.CurrentThread.Id, bp, enabled); err != nil {
+		return nil, err
+	}
+	return bp, nil
+}
+
+// Clears a breakpoint by location (function, file+line, address,
+// breakpoint id). The breakpoints BreakByLocation set together on the
+// other addresses of a line are cleared too.
+func (dbp *DebuggedProcess) ClearByLocation(loc string) (*BreakPoint, error) {
+	if bp, ok := dbp.clearPending(loc); ok {
+		return bp, nil
+	}
+	addr, err := dbp.FindLocation(loc)
+	if err != nil {
+		return nil, err
+	}
+	bp, err := dbp.Clear(addr)
+	if err != nil || bp.Group == 0 || !strings.ContainsRune(loc, ':') {
+		return bp, err
+	}
+	for _, gbp := range dbp.Breakpoints() {
+		if gbp.Group != bp.Group {
+			continue
+		}
+		if _, err := dbp.Clear(gbp.Addr); err != nil {
+			return bp, err
+		}
+	}
+	return bp, nil
+}
+
+// Returns the status of the current main thread context.
+func (dbp *DebuggedProcess) Status() *sys.WaitStatus {
+	return dbp.CurrentThread.Status
+}
+
+// Step over function calls.
+func (dbp *DebuggedProcess) Next() error {
+	return dbp.NextContext(context.Background())
+}
+
+// Does Next until ctx is done, which stops the process and returns the
+// error of ctx.
+func (dbp *DebuggedProcess) NextContext(ctx context.Context) error {
+	var runnable []*ThreadContext
+
+	fn := func(ctx context.Context) error {
+		current := dbp.CurrentThread
+		for _, th := range dbp.Threads {
+			// Continue any blocked M so that the
+			// scheduler can continue to do its'
+			// job correctly.
+			if th.blocked() {
+				err := th.Continue()
 				if err != nil {
 					return err
 				}
+				continue
+			}
+
+			runnable = append(runnable, th)
+		}
+		for _, th := range runnable {
+			next, err := th.next(ctx)
+			if err != nil && err != sys.ESRCH {
+				return err
+			}
+			if th == current {
+				current = next
 			}
-			dbp.Halt()
+		}
+		// Stop where the goroutine we were following
+		// is, the scheduler may have moved it.
+		if current != dbp.CurrentThread {
+			dbp.emit(Event{Kind: EventThreadSwitched, Thread: current.Id, Previous: dbp.CurrentThread.Id})
+			dbp.mu.Lock()
+			dbp.CurrentThread = current
+			dbp.mu.Unlock()
+		}
+		return dbp.Halt()
+	}
+	return dbp.run(ctx, fn)
+}
+
+// Does Next n times, see ContinueN.
+func (dbp *DebuggedProcess) NextN(n int) error {
+	return dbp.repeat(n, dbp.Next)
+}
+
+// Resume process. With a runaway detector set, the process is stopped
+// once it runs for too long, see SetRunawayDetector.
+func (dbp *DebuggedProcess) Continue() error {
+	return dbp.ContinueContext(context.Background())
+}
+
+// Does Continue until ctx is done, which stops the process and returns
+// the error of ctx.
+func (dbp *DebuggedProcess) ContinueContext(ctx context.Context) error {
+	dbp.mu.RLock()
+	rd := dbp.runaway
+	dbp.mu.RUnlock()
+	if rd.Threshold > 0 {
+		return dbp.run(ctx, func(ctx context.Context) error { return dbp.resumeWatched(ctx, rd) })
+	}
+	return dbp.run(ctx, dbp.resume)
+}
+
+// Does Continue n times, as long as the process stops at breakpoints,
+// to skip the first hits of one.
+func (dbp *DebuggedProcess) ContinueN(n int) error {
+	return dbp.repeat(n, dbp.Continue)
+}
+
+// Does op n times, stopping early once the process exits or stops for
+// any other reason than reaching a breakpoint, see StopReason.
+func (dbp *DebuggedProcess) repeat(n int, op func() error) error {
+	if n < 1 {
+		return fmt.Errorf("invalid count %d", n)
+	}
+	for i := 0; i < n; i++ {
+		dbp.setStopReason(StopUnknown)
+		if err := op(); err != nil {
+			return err
+		}
+		if dbp.Exited() {
+			return nil
+		}
+		if sr := dbp.StopReason(); sr != StopUnknown && sr != StopBreakpoint {
 			return nil
 		}
+	}
+	return nil
+}
 
-		// Check for hardware breakpoint
-		for _, bp := range dbp.HWBreakPoints {
-			if bp != nil && bp.Addr == pc {
-				if !bp.Temp {
-					return dbp.Halt()
+// Resumes all threads and waits for the next trap. Temporary
+// breakpoints do not halt the process, it is up to the caller
+// to decide what to do once one has been hit. Breakpoints
+// restricted to another goroutine are continued past.
+func (dbp *DebuggedProcess) resume(ctx context.Context) error {
+	dbp.setStopReason(StopUnknown)
+	// Continuing past a breakpoint only resumes the thread which
+	// trapped, the others are still running.
+	var trapped *ThreadContext
+	for {
+		if trapped != nil {
+			if err := trapped.Continue(); err != nil {
+				return err
+			}
+		} else {
+			for _, thread := range dbp.Threads {
+				err := thread.Continue()
+				if err != nil {
+					return err
 				}
-				return nil
 			}
 		}
-		// Check to see if we have hit a software breakpoint.
-		if bp, ok := dbp.BreakPoints[pc-1]; ok {
-			if !bp.Temp {
-				return dbp.Halt()
-			}
+
+		wpid, err := dbp.pausableTrapWait(ctx, -1, nil)
+		if err != nil {
+			return err
+		}
+		if dbp.StopReason() == StopExec {
+			// The breakpoints went away with the old program.
 			return nil
 		}
 
-		return fmt.Errorf("unrecognized breakpoint %#v", pc)
+		thread, ok := dbp.Threads[wpid]
+		if !ok {
+			return fmt.Errorf("could not find thread for %d", wpid)
+		}
+		trapped = thread
+
+		if wpid != dbp.CurrentThread.Id {
+			dbp.emit(Event{Kind: EventThreadSwitched, Thread: thread.Id, Previous: dbp.CurrentThread.Id})
+			dbp.mu.Lock()
+			dbp.CurrentThread = thread
+			dbp.mu.Unlock()
+		}
+		if sr := dbp.StopReason(); sr == StopSignal || sr == StopSyscall {
+			return dbp.Halt()
+		}
+
+		pc, err := thread.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		// Check to see if we hit a runtime.Breakpoint
+		// or another hardcoded breakpoint.
+		hardcoded, err := dbp.hardcodedBreakpoint(thread, pc)
+		if err != nil {
+			return err
+		}
+		if hardcoded {
+			if dbp.ContinueHardcodedBreakpoints {
+				continue
+			}
+			dbp.setStopReason(StopHardcodedBreakpoint)
+			return dbp.Halt()
+		}
+
+		// Watchpoints trap after the instruction writing
+		// to the variable, wherever that may be.
+		wp, err := dbp.watchpointHit(thread)
+		if err != nil {
+			return err
+		}
+		if wp != nil {
+			dbp.logger().Infof("%s: %s written", wp, wp.Variable)
+			dbp.recordHit(thread, wp)
+			dbp.emit(Event{Kind: EventBreakpointHit, Thread: thread.Id, BreakPoint: wp})
+			if dbp.runHitHooks(wp) {
+				continue
+			}
+			dbp.setStopReason(StopWatchpoint)
+			return dbp.Halt()
+		}
+
+		// Check for a hardware breakpoint at pc, or
+		// a software breakpoint on the trap just behind it.
+		bp, ok := dbp.trappedBreakpoint(pc)
+		for _, hwbp := range dbp.HWBreakPoints {
+			if hwbp != nil && !hwbp.Disabled && hwbp.Addr == pc {
+				bp, ok = hwbp, true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("unrecognized breakpoint %#v", pc)
+		}
+		if bp.Temp {
+			// Unless its hooks resume, e.g. those of the
+			// breakpoints catching traced functions returning.
+			if dbp.runHitHooks(bp) {
+				continue
+			}
+			return nil
+		}
+		dbp.recordHit(thread, bp)
+		if bp.Goroutine != 0 {
+			match, err := thread.onGoroutine(bp.Goroutine)
+			if err != nil {
+				return err
+			}
+			if !match {
+				continue
+			}
+		}
+		var stack []Frame
+		if bp.Tracepoint && bp.Stacktrace > 0 {
+			// Captured before the hit is reported, for its
+			// listeners to get it.
+			if stack, err = thread.Stacktrace(bp.Stacktrace); err != nil {
+				dbp.logger().Errorf("could not capture the stack of tracepoint %d: %s", bp.ID, err)
+			}
+		}
+		dbp.emit(Event{Kind: EventBreakpointHit, Thread: thread.Id, BreakPoint: bp, Stack: stack})
+		if bp.Creations {
+			gc, err := dbp.recordCreation(thread)
+			if err != nil {
+				return fmt.Errorf("could not record goroutine creation: %s", err)
+			}
+			if bp.Tracepoint {
+				dbp.logger().Infof("> %s (tracepoint %d)", gc, bp.ID)
+				continue
+			}
+		}
+		if bp.Tracepoint {
+			thread.logTracepoint(bp, stack)
+		}
+		if resume := dbp.runHitHooks(bp); resume || bp.Tracepoint {
+			continue
+		}
+		dbp.setStopReason(StopBreakpoint)
+		return dbp.Halt()
 	}
-	return dbp.run(fn)
 }
 
 // Steps through process.
 func (dbp *DebuggedProcess) Step() (err error) {
-	fn := func() error {
+	return dbp.StepContext(context.Background())
+}
+
+// Does Step unless ctx is done already, in which case the error of
+// ctx is returned.
+func (dbp *DebuggedProcess) StepContext(ctx context.Context) error {
+	fn := func(ctx context.Context) error {
 		for _, th := range dbp.Threads {
 			if th.blocked() {
 				continue
 			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			err := th.Step()
 			if err != nil {
 				return err
@@ -316,13 +1418,264 @@ func (dbp *DebuggedProcess) Step() (err error) {
 		return nil
 	}
 
-	return dbp.run(fn)
+	return dbp.run(ctx, fn)
+}
+
+// Does Step n times, see ContinueN.
+func (dbp *DebuggedProcess) StepN(n int) error {
+	return dbp.repeat(n, dbp.Step)
+}
+
+// Step into the next source line of the current thread,
+// following function calls.
+func (dbp *DebuggedProcess) StepInto() error {
+	fn := func(context.Context) error {
+		return dbp.CurrentThread.StepInto()
+	}
+	return dbp.run(context.Background(), fn)
+}
+
+// Step out of the current function. A temporary breakpoint is
+// set at the return address of the current frame and the process
+// is continued until it returns to the caller. Other goroutines and
+// recursive calls returning there meanwhile are continued past.
+func (dbp *DebuggedProcess) StepOut() error {
+	fn := func(ctx context.Context) error {
+		thread := dbp.CurrentThread
+		pc, err := thread.CurrentPC()
+		if err != nil {
+			return err
+		}
+
+		if bp, ok := dbp.trappedBreakpoint(pc); ok {
+			pc = bp.Addr
+		}
+
+		fde, err := dbp.FrameEntries.FDEForPC(pc)
+		if err != nil {
+			return err
+		}
+
+		depth, err := thread.frameDepth()
+		if err != nil {
+			return err
+		}
+		g, gerr := thread.CurrentGoroutine()
+
+		ret := thread.returnAddress(fde, pc)
+		bp, err := dbp.breakpoint(ret)
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); !ok {
+				return err
+			}
+			// There is already a user breakpoint at the return
+			// address, which will stop the process for us.
+			return dbp.resume(ctx)
+		}
+		bp.Temp = true
+		// Only the current goroutine stops, once back in the caller
+		// rather than in a recursive call returning to the same
+		// address.
+		hook := func(dbp *DebuggedProcess, bp *BreakPoint) bool {
+			th := dbp.CurrentThread
+			if gerr == nil {
+				if ok, _ := th.onGoroutine(g.Id); !ok {
+					return true
+				}
+			}
+			d, err := th.frameDepth()
+			return err == nil && d >= depth
+		}
+		if err := dbp.OnBreakpointHit(bp.ID, hook); err != nil {
+			dbp.clear(ret)
+			return err
+		}
+
+		if err := dbp.resume(ctx); err != nil {
+			return err
+		}
+
+		thread = dbp.CurrentThread
+		if pc, err = thread.CurrentPC(); err != nil {
+			return err
+		}
+		if pc == ret || pc-breakpointPCOffset == ret {
+			if err := dbp.Halt(); err != nil {
+				return err
+			}
+			return thread.clearTempBreakpoint(ret)
+		}
+
+		// Some other breakpoint was hit before the function returned.
+		_, err = dbp.clear(ret)
+		return err
+	}
+	return dbp.run(context.Background(), fn)
+}
+
+// Continues until the first call the current function deferred runs,
+// as it returns or panics, stopping at the entry of the deferred
+// function rather than in the caller as StepOut does, since cleanup
+// often happens there. The breakpoint set there is only hit by the
+// current goroutine once it runs the call, other calls of the function
+// are continued past. Without calls deferred by the current function,
+// it does StepOut.
+func (dbp *DebuggedProcess) StepToDeferred() error {
+	d, err := dbp.CurrentThread.frameDefer()
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return dbp.StepOut()
+	}
+	fn := dbp.lookupFunc(d.Function)
+	if fn == nil {
+		return fmt.Errorf("could not find the function deferred at %s:%d", d.File, d.Line)
+	}
+
+	run := func(ctx context.Context) error {
+		g, err := dbp.CurrentThread.CurrentGoroutine()
+		if err != nil {
+			return err
+		}
+		bp, err := dbp.breakpoint(fn.Entry)
+		if err != nil {
+			if _, ok := err.(BreakPointExistsError); !ok {
+				return err
+			}
+			// There is already a user breakpoint at the deferred
+			// function, which will stop the process for us.
+			return dbp.resume(ctx)
+		}
+		bp.Temp = true
+		hook := func(dbp *DebuggedProcess, bp *BreakPoint) bool {
+			th := dbp.CurrentThread
+			if ok, _ := th.onGoroutine(g.Id); !ok {
+				return true
+			}
+			pending, err := th.deferPending(d)
+			return err == nil && pending
+		}
+		if err := dbp.OnBreakpointHit(bp.ID, hook); err != nil {
+			dbp.clear(fn.Entry)
+			return err
+		}
+
+		if err := dbp.resume(ctx); err != nil {
+			return err
+		}
+		thread := dbp.CurrentThread
+		pc, err := thread.CurrentPC()
+		if err != nil {
+			return err
+		}
+		if pc == fn.Entry || pc-breakpointPCOffset == fn.Entry {
+			if err := dbp.Halt(); err != nil {
+				return err
+			}
+			return thread.clearTempBreakpoint(fn.Entry)
+		}
+
+		// Some other breakpoint was hit before the call ran.
+		_, err = dbp.clear(fn.Entry)
+		return err
+	}
+	return dbp.run(context.Background(), run)
+}
+
+// Continues until the current goroutine reaches loc in the current
+// frame or one of its callers, as gdb's until and advance do. Unlike a
+// breakpoint at loc, other goroutines reaching it and the calls the
+// current goroutine makes meanwhile, e.g. recursive ones of the current
+// function, are continued past. The process stops at the breakpoints
+// it hits on the way, leaving loc unreached, and the temporary
+// breakpoints set at loc are cleared either way.
+func (dbp *DebuggedProcess) ContinueUntil(loc string) error {
+	addr, err := dbp.FindLocation(loc)
+	if err != nil {
+		return err
+	}
+	pcs := []uint64{addr}
+	if strings.ContainsRune(loc, ':') {
+		pcs = dbp.linePCs(addr)
+	}
+
+	fn := func(ctx context.Context) error {
+		thread := dbp.CurrentThread
+		g, err := thread.CurrentGoroutine()
+		if err != nil {
+			return err
+		}
+		depth, err := thread.frameDepth()
+		if err != nil {
+			return err
+		}
+		// Only the current goroutine stops, and not in the
+		// frames of the calls it makes.
+		hook := func(dbp *DebuggedProcess, bp *BreakPoint) bool {
+			th := dbp.CurrentThread
+			if ok, _ := th.onGoroutine(g.Id); !ok {
+				return true
+			}
+			d, err := th.frameDepth()
+			return err == nil && d > depth
+		}
+
+		var temps []uint64
+		defer func() {
+			for _, pc := range temps {
+				if dbp.BreakpointExists(pc) {
+					dbp.clear(pc)
+				}
+			}
+		}()
+		for _, pc := range pcs {
+			bp, err := dbp.breakpoint(pc)
+			if err != nil {
+				// A breakpoint already there stops the process
+				// for us.
+				if _, ok := err.(BreakPointExistsError); ok {
+					continue
+				}
+				return err
+			}
+			bp.Temp = true
+			temps = append(temps, pc)
+			if err := dbp.OnBreakpointHit(bp.ID, hook); err != nil {
+				return err
+			}
+		}
+
+		if err := dbp.resume(ctx); err != nil {
+			return err
+		}
+		pc, err := dbp.CurrentThread.CurrentPC()
+		if err != nil {
+			return err
+		}
+		for i, tpc := range temps {
+			if pc == tpc || pc-breakpointPCOffset == tpc {
+				temps = append(temps[:i], temps[i+1:]...)
+				if err := dbp.Halt(); err != nil {
+					return err
+				}
+				return dbp.CurrentThread.clearTempBreakpoint(tpc)
+			}
+		}
+		return nil
+	}
+	return dbp.run(context.Background(), fn)
 }
 
 // Change from current thread to the thread specified by `tid`.
 func (dbp *DebuggedProcess) SwitchThread(tid int) error {
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
 	if th, ok := dbp.Threads[tid]; ok {
+		dbp.mu.Lock()
 		dbp.CurrentThread = th
+		dbp.mu.Unlock()
 		return nil
 	}
 	return fmt.Errorf("thread %d does not exist", tid)
@@ -331,35 +1684,199 @@ func (dbp *DebuggedProcess) SwitchThread(tid int) error {
 // Obtains register values from what Delve considers to be the current
 // thread of the traced process.
 func (dbp *DebuggedProcess) Registers() (Registers, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
 	return dbp.CurrentThread.Registers()
 }
 
+// Returns the registers of the current thread that changed
+// since the previous stop, see ThreadContext.ChangedRegisters.
+func (dbp *DebuggedProcess) ChangedRegisters() ([]RegisterChange, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	return dbp.CurrentThread.ChangedRegisters()
+}
+
 // Returns the PC of the current thread.
 func (dbp *DebuggedProcess) CurrentPC() (uint64, error) {
+	if dbp.Exited() {
+		return 0, dbp.exitError()
+	}
 	return dbp.CurrentThread.CurrentPC()
 }
 
 // Returns the value of the named symbol.
 func (dbp *DebuggedProcess) EvalSymbol(name string) (*Variable, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
 	return dbp.CurrentThread.EvalSymbol(name)
 }
 
-// Returns a reader for the dwarf data
+// Returns the value of the named symbol printed in format.
+func (dbp *DebuggedProcess) EvalSymbolFormat(name string, format Format) (*Variable, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	return dbp.CurrentThread.EvalSymbolFormat(name, format)
+}
+
+// Calls the function fnName in the current thread, see ThreadContext.Call.
+func (dbp *DebuggedProcess) Call(fnName string, args ...string) ([]*Variable, error) {
+	var results []*Variable
+	err := dbp.run(context.Background(), func(ctx context.Context) (err error) {
+		results, err = dbp.CurrentThread.call(ctx, fnName, args...)
+		return err
+	})
+	return results, err
+}
+
+// Returns the calls deferred by the current goroutine, see
+// ThreadContext.Defers.
+func (dbp *DebuggedProcess) Defers() ([]Defer, error) {
+	return dbp.CurrentThread.Defers()
+}
+
+// Returns the panics in flight on the current goroutine, see
+// ThreadContext.Panics.
+func (dbp *DebuggedProcess) Panics() ([]Panic, error) {
+	return dbp.CurrentThread.Panics()
+}
+
+// Reads size bytes of memory at addr, see ThreadContext.ReadMemory.
+func (dbp *DebuggedProcess) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	return dbp.CurrentThread.ReadMemory(addr, size)
+}
+
+// Sets the value of the named symbol.
+func (dbp *DebuggedProcess) SetSymbol(name, value string) error {
+	return dbp.CurrentThread.SetSymbol(name, value)
+}
+
+// Returns the index of the DWARF line tables of the program, mapping
+// addresses to source positions. It is built on first use, and its
+// addresses are those the program was linked at.
+func (dbp *DebuggedProcess) LineIndex() (*line.Index, error) {
+	dbp.lineMu.Lock()
+	defer dbp.lineMu.Unlock()
+	if dbp.lineIndex == nil {
+		idx, err := line.New(dbp.Dwarf)
+		if err != nil {
+			return nil, err
+		}
+		dbp.lineIndex = idx
+	}
+	return dbp.lineIndex, nil
+}
+
+// Returns the index of the functions, package variables and types
+// of the program, mapping names and addresses to their DWARF entries.
+// It is built on first use, and its addresses are those the program
+// was linked at.
+func (dbp *DebuggedProcess) SymbolIndex() (*reader.Index, error) {
+	dbp.symMu.Lock()
+	defer dbp.symMu.Unlock()
+	if dbp.symIndex == nil {
+		idx, err := reader.NewIndex(dbp.Dwarf)
+		if err != nil {
+			return nil, err
+		}
+		dbp.symIndex = idx
+	}
+	return dbp.symIndex, nil
+}
+
+// Returns the DWARF entry of the named package variable.
+func (dbp *DebuggedProcess) packageVariableEntry(name string) (*dwarf.Entry, error) {
+	idx, err := dbp.SymbolIndex()
+	if err != nil {
+		return nil, err
+	}
+	off, ok := idx.Variable(name)
+	if !ok {
+		return nil, fmt.Errorf("could not find symbol value for %s", name)
+	}
+	r := dbp.Dwarf.Reader()
+	r.Seek(off)
+	return r.Next()
+}
+
+// Returns the DWARF entries of the types with the given name.
+func (dbp *DebuggedProcess) typeEntries(name string) ([]*dwarf.Entry, error) {
+	idx, err := dbp.SymbolIndex()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*dwarf.Entry
+	r := dbp.Dwarf.Reader()
+	for _, off := range idx.Types(name) {
+		r.Seek(off)
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Returns the Go type of the DWARF type dt, built on first use.
+func (dbp *DebuggedProcess) goType(dt dwarf.Type) *types.Type {
+	dbp.typeMu.Lock()
+	if dbp.typeGraph == nil {
+		dbp.typeGraph = types.NewGraph()
+	}
+	g := dbp.typeGraph
+	dbp.typeMu.Unlock()
+	return g.Type(dt)
+}
+
+// Returns the line of the instruction at pc, and whether it starts a
+// statement. Addresses without DWARF line information are looked up
+// in the Go symbol table, and taken to start statements.
+func (dbp *DebuggedProcess) lineForPC(pc uint64) (int, bool) {
+	if idx, err := dbp.LineIndex(); err == nil {
+		if e, ok := idx.Lookup(dbp.dwarfPC(pc)); ok {
+			return e.Line, e.IsStmt
+		}
+	}
+	_, l, _ := dbp.GoSymTable.PCToLine(pc)
+	return l, true
+}
+
+// Returns a reader for the dwarf data, looking functions up in the
+// symbol index.
 func (dbp *DebuggedProcess) DwarfReader() *reader.Reader {
+	if idx, err := dbp.SymbolIndex(); err == nil {
+		return reader.NewIndexed(dbp.Dwarf, idx)
+	}
 	return reader.New(dbp.Dwarf)
 }
 
 // Returns a new DebuggedProcess struct.
 func newDebugProcess(pid int, attach bool) (*DebuggedProcess, error) {
+	return newProcess(pid, attach, true)
+}
+
+// Returns a new DebuggedProcess, whose debug information is only
+// loaded if symbols is set. Without it, as when serving the process
+// to a remote debugger, only what needs no symbols works: registers,
+// memory, breakpoints at addresses and resuming.
+func newProcess(pid int, attach, symbols bool) (*DebuggedProcess, error) {
 	dbp := DebuggedProcess{
 		Pid:         pid,
 		Threads:     make(map[int]*ThreadContext),
 		BreakPoints: make(map[uint64]*BreakPoint),
 		os:          new(OSProcessDetails),
+		backend:     nativeBackend{},
+		attached:    attach,
 	}
+	dbp.startTiming()
 
 	if attach {
-		err := sys.PtraceAttach(pid)
+		err := ptraceAttach(pid)
 		if err != nil {
 			return nil, err
 		}
@@ -375,9 +1892,10 @@ func newDebugProcess(pid int, attach bool) (*DebuggedProcess, error) {
 	}
 
 	dbp.Process = proc
-	err = dbp.LoadInformation()
-	if err != nil {
-		return nil, err
+	if symbols {
+		if err := dbp.LoadInformation(); err != nil {
+			return nil, err
+		}
 	}
 
 	if err := dbp.updateThreadList(); err != nil {
@@ -387,17 +1905,172 @@ func newDebugProcess(pid int, attach bool) (*DebuggedProcess, error) {
 	return &dbp, nil
 }
 
-func (dbp *DebuggedProcess) run(fn func() error) error {
-	if dbp.exited {
-		return fmt.Errorf("process has already exited")
+// Marks the process as exited, returning err, which describes how.
+func (dbp *DebuggedProcess) setExited(err ProcessExitedError) error {
+	dbp.mu.Lock()
+	dbp.exited = true
+	dbp.exitErr = err
+	dbp.mu.Unlock()
+	dbp.emit(Event{Kind: EventProcessExited, Exit: &err})
+	return err
+}
+
+// Returns the error loading the debug information of the binary fails
+// with, given the errors parsing its frame and symbol information.
+// Nothing can be done without the symbol table, whereas without frame
+// information only stacks can't be unwound: Next steps instructions
+// rather than lines and StepOut fails, which is logged instead.
+func (dbp *DebuggedProcess) loadError(frameErr, symErr error) error {
+	if symErr != nil {
+		return symErr
 	}
-	dbp.running = true
-	dbp.halt = false
-	defer func() { dbp.running = false }()
-	if err := fn(); err != nil {
-		if _, ok := err.(ManualStopError); !ok {
-			return err
+	if frameErr != nil {
+		dbp.logger().Errorf("%s, stacks can't be unwound", frameErr)
+	}
+	return nil
+}
+
+// Returns the error describing how the process exited.
+func (dbp *DebuggedProcess) exitError() error {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.exitErr
+}
+
+// Runs fn, which resumes the process, with a context cancelled by
+// RequestManualStop. Once ctx or that context is done, fn stops the
+// process and returns the error of the context. The threads left running
+// are then halted: a manual stop is reported as an EventManualStop,
+// whereas ctx being done returns its error. Unless it exited, the
+// process is then reported stopped, see stopped.
+//
+// Operations are run one at a time, those called meanwhile waiting for
+// the one running to return, after which they find the process where
+// it stopped.
+func (dbp *DebuggedProcess) run(ctx context.Context, fn func(context.Context) error) error {
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dbp.acquire(cancel, false)
+	defer dbp.release()
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
+	err := dbp.runUntilStop(ctx, rctx, fn)
+	if !dbp.Exited() {
+		dbp.stopped()
+	}
+	return err
+}
+
+// Runs fn for run with rctx, ctx or RequestManualStop cancelling it,
+// until the process stops or exits.
+func (dbp *DebuggedProcess) runUntilStop(ctx, rctx context.Context, fn func(context.Context) error) error {
+	for _, th := range dbp.Threads {
+		if regs, err := th.Registers(); err == nil {
+			th.prevRegs = regs.Slice()
 		}
+		th.frame = 0
+	}
+	dbp.recordResourceUsage()
+	dbp.compositeMu.Lock()
+	dbp.composites = nil
+	dbp.compositeMu.Unlock()
+	dbp.mu.Lock()
+	dbp.op.running = true
+	dbp.timeResume()
+	dbp.mu.Unlock()
+	defer func() {
+		dbp.mu.Lock()
+		dbp.op.running = false
+		dbp.timeStop()
+		dbp.mu.Unlock()
+	}()
+	err := fn(rctx)
+	if err == nil || err != rctx.Err() {
+		return err
+	}
+	if err := dbp.Halt(); err != nil {
+		return err
 	}
+	dbp.setStopReason(StopManual)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	dbp.emit(Event{Kind: EventManualStop, Thread: dbp.CurrentThread.Id})
 	return nil
 }
+
+// An operation owning the process, see acquire.
+type operation struct {
+	cancel  context.CancelFunc // Stops it, see RequestManualStop
+	running bool               // While the process runs, see Running
+	lent    bool               // While hooks run, see whileStopped
+}
+
+// Waits for no operation to own the process and makes the caller its
+// owner, until it calls release. cancel stops the caller, when another
+// goroutine asks for the process to stop, see RequestManualStop. With
+// stop, as for Kill, Detach and Restart, the owner is stopped first,
+// and the caller goes before operations waiting to run the process.
+func (dbp *DebuggedProcess) acquire(cancel context.CancelFunc, stop bool) {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.opReturned == nil {
+		dbp.opReturned = sync.NewCond(&dbp.mu)
+	}
+	if stop {
+		dbp.stoppers++
+	}
+	for dbp.op != nil || (!stop && dbp.stoppers > 0) {
+		if stop && dbp.op != nil && dbp.op.cancel != nil {
+			dbp.op.cancel()
+		}
+		dbp.opReturned.Wait()
+	}
+	if stop {
+		dbp.stoppers--
+	}
+	dbp.op = &operation{cancel: cancel}
+}
+
+// Releases the process acquired by the caller, for the next operation
+// waiting to own it, if any. Those of whileStopped queued meanwhile are
+// run first, the process being stopped, or gone.
+func (dbp *DebuggedProcess) release() {
+	for {
+		dbp.runStopped()
+		dbp.mu.Lock()
+		if len(dbp.stoppedFns) == 0 {
+			break
+		}
+		dbp.mu.Unlock()
+	}
+	dbp.op = nil
+	if dbp.opReturned != nil {
+		dbp.opReturned.Broadcast()
+	}
+	dbp.mu.Unlock()
+}
+
+// Waits for the next trap as the backend does, interrupting the
+// process once ctx is done for the wait to return its error.
+func (dbp *DebuggedProcess) trapWait(ctx context.Context, pid int) (int, error) {
+	if ctx.Done() == nil {
+		return dbp.backend.trapWait(ctx, dbp, pid)
+	}
+	waited := make(chan struct{})
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		select {
+		case <-ctx.Done():
+			dbp.backend.interrupt(dbp)
+		case <-waited:
+		}
+	}()
+	defer func() {
+		close(waited)
+		<-watched
+	}()
+	return dbp.backend.trapWait(ctx, dbp, pid)
+}
@@ -22,8 +22,13 @@ __attribute__ ((section ("__TEXT,__info_plist"),used)) =
 "</plist>\n";
 
 kern_return_t
-acquire_mach_task(int tid,
-		mach_port_name_t *task,
+acquire_task(int pid, mach_port_name_t *task)
+{
+	return task_for_pid(mach_task_self(), pid, task);
+}
+
+kern_return_t
+acquire_mach_task(mach_port_name_t *task,
 		mach_port_t *port_set,
 		mach_port_t *exception_port,
 		mach_port_t *notification_port)
@@ -32,9 +37,6 @@ acquire_mach_task(int tid,
 	mach_port_t prev_not;
 	mach_port_t self = mach_task_self();
 
-	kret = task_for_pid(self, tid, task);
-	if (kret != KERN_SUCCESS) return kret;
-
 	// Allocate exception port.
 	kret = mach_port_allocate(self, MACH_PORT_RIGHT_RECEIVE, exception_port);
 	if (kret != KERN_SUCCESS) return kret;
@@ -75,6 +77,11 @@ find_executable(int pid) {
 	return pathbuf;
 }
 
+int
+list_pids(int *pids, int count) {
+	return proc_listallpids(pids, count * sizeof(int));
+}
+
 kern_return_t
 get_threads(task_t task, void *slice) {
 	kern_return_t kret;
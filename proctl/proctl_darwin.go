@@ -3,14 +3,17 @@ package proctl
 // #include "proctl_darwin.h"
 import "C"
 import (
+	"context"
 	"debug/gosym"
 	"debug/macho"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"unsafe"
 
 	"github.com/derekparker/delve/dwarf/frame"
+	"github.com/derekparker/delve/dwarf/loclist"
 	sys "golang.org/x/sys/unix"
 )
 
@@ -22,8 +25,11 @@ type OSProcessDetails struct {
 }
 
 func (dbp *DebuggedProcess) Halt() (err error) {
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
 	for _, th := range dbp.Threads {
-		err := th.Halt()
+		err := dbp.backend.halt(th)
 		if err != nil {
 			return err
 		}
@@ -34,8 +40,10 @@ func (dbp *DebuggedProcess) Halt() (err error) {
 // Finds the executable and then uses it
 // to parse the following information:
 // * Dwarf .debug_frame section
-// * Dwarf .debug_line section
 // * Go symbol table.
+// The __debug_line section is indexed from the DWARF data on first
+// use, as on linux, see LineIndex, and Next falls back to the Go
+// symbol table for code without line tables.
 func (dbp *DebuggedProcess) LoadInformation() error {
 	var (
 		wg  sync.WaitGroup
@@ -43,7 +51,11 @@ func (dbp *DebuggedProcess) LoadInformation() error {
 		err error
 	)
 
-	ret := C.acquire_mach_task(C.int(dbp.Pid), &dbp.os.task, &dbp.os.portSet, &dbp.os.exceptionPort, &dbp.os.notificationPort)
+	ret := C.acquire_task(C.int(dbp.Pid), &dbp.os.task)
+	if ret != C.KERN_SUCCESS {
+		return newTaskAccessError(dbp.Pid, int(ret))
+	}
+	ret = C.acquire_mach_task(&dbp.os.task, &dbp.os.portSet, &dbp.os.exceptionPort, &dbp.os.notificationPort)
 	if ret != C.KERN_SUCCESS {
 		return fmt.Errorf("could not acquire mach task %d", ret)
 	}
@@ -56,12 +68,24 @@ func (dbp *DebuggedProcess) LoadInformation() error {
 		return err
 	}
 	dbp.Dwarf = data
+	dbp.locLists = loclist.New(debugSection(exe, "__debug_loc"), debugSection(exe, "__debug_loclists"), debugSection(exe, "__debug_addr"), int(ptrsize))
 
+	var frameErr, symErr error
 	wg.Add(2)
-	go dbp.parseDebugFrame(exe, &wg)
-	go dbp.obtainGoSymbols(exe, &wg)
+	go func() {
+		defer wg.Done()
+		frameErr = dbp.parseDebugFrame(exe)
+	}()
+	go func() {
+		defer wg.Done()
+		symErr = dbp.obtainGoSymbols(exe)
+	}()
 	wg.Wait()
 
+	if err := dbp.loadError(frameErr, symErr); err != nil {
+		return err
+	}
+	dbp.loadRuntimeTypes()
 	return nil
 }
 
@@ -98,43 +122,80 @@ func (dbp *DebuggedProcess) updateThreadList() error {
 	return nil
 }
 
+// TODO(darwin) stop on exit, for the exit hooks.
+func (dbp *DebuggedProcess) traceExit(enable bool) error {
+	if enable {
+		return fmt.Errorf("exit hooks are not supported on darwin")
+	}
+	return nil
+}
+
 func (dbp *DebuggedProcess) addThread(port int, attach bool) (*ThreadContext, error) {
 	if thread, ok := dbp.Threads[port]; ok {
 		return thread, nil
 	}
-	fmt.Println("new thread spawned", port)
 	thread := &ThreadContext{
 		Id:      port,
 		Process: dbp,
 		os:      new(OSSpecificDetails),
 	}
-	dbp.Threads[port] = thread
 	thread.os.thread_act = C.thread_act_t(port)
+	dbp.mu.Lock()
+	dbp.Threads[port] = thread
 	if dbp.CurrentThread == nil {
 		dbp.CurrentThread = thread
 	}
+	dbp.mu.Unlock()
+	dbp.emit(Event{Kind: EventThreadCreated, Thread: port})
 	return thread, nil
 }
 
-func (dbp *DebuggedProcess) parseDebugFrame(exe *macho.File, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Parses the frame information of exe, from __debug_frame or else
+// __eh_frame.
+func (dbp *DebuggedProcess) parseDebugFrame(exe *macho.File) error {
+	// The C code of programs linked externally, as those using cgo,
+	// is only described by __eh_frame, as are binaries stripped of their
+	// debug information.
+	ehFrame, ehErr := dbp.parseEhFrame(exe)
 
 	if sec := exe.Section("__debug_frame"); sec != nil {
-		debugFrame, err := exe.Section("__debug_frame").Data()
+		debugFrame, err := sec.Data()
 		if err != nil {
-			fmt.Println("could not get __debug_frame section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get __debug_frame section: %s", err)
 		}
 		dbp.FrameEntries = frame.Parse(debugFrame)
-	} else {
-		fmt.Println("could not find __debug_frame section in binary")
-		os.Exit(1)
+		if ehErr == nil {
+			dbp.FrameEntries = dbp.FrameEntries.Merge(ehFrame)
+		}
+		return nil
+	}
+
+	if ehErr != nil {
+		return ehErr
 	}
+	dbp.FrameEntries = ehFrame
+	return nil
 }
 
-func (dbp *DebuggedProcess) obtainGoSymbols(exe *macho.File, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Parses the frame information in the __eh_frame section of exe.
+func (dbp *DebuggedProcess) parseEhFrame(exe *macho.File) (frame.FrameDescriptionEntries, error) {
+	sec := exe.Section("__eh_frame")
+	if sec == nil {
+		return nil, fmt.Errorf("could not find __debug_frame or __eh_frame section in binary")
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("could not get __eh_frame section %s", err)
+	}
+	fdes, err := frame.ParseEhFrame(data, sec.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse __eh_frame section %s", err)
+	}
+	return fdes, nil
+}
 
+// Loads the Go symbol table of exe, from its __gosymtab and __gopclntab.
+func (dbp *DebuggedProcess) obtainGoSymbols(exe *macho.File) error {
 	var (
 		symdat  []byte
 		pclndat []byte
@@ -144,27 +205,73 @@ func (dbp *DebuggedProcess) obtainGoSymbols(exe *macho.File, wg *sync.WaitGroup)
 	if sec := exe.Section("__gosymtab"); sec != nil {
 		symdat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gosymtab section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get .gosymtab section: %s", err)
 		}
 	}
 
 	if sec := exe.Section("__gopclntab"); sec != nil {
 		pclndat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gopclntab section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get .gopclntab section: %s", err)
 		}
 	}
 
-	pcln := gosym.NewLineTable(pclndat, exe.Section("__text").Addr)
+	// The Go code of programs linked externally, as those using
+	// cgo, doesn't start the __text section, and the line table is
+	// relative to where it starts.
+	text := exe.Section("__text").Addr
+	if exe.Symtab != nil {
+		for _, s := range exe.Symtab.Syms {
+			if s.Name == "runtime.text" {
+				text = s.Value
+				break
+			}
+		}
+	}
+
+	pcln := gosym.NewLineTable(pclndat, text)
 	tab, err := gosym.NewTable(symdat, pcln)
 	if err != nil {
-		fmt.Println("could not get initialize line table", err)
-		os.Exit(1)
+		return fmt.Errorf("could not initialize line table: %s", err)
 	}
 
 	dbp.GoSymTable = tab
+	return nil
+}
+
+// Returns the pids of the processes whose executable is named name,
+// other than ours. Processes we can't get the executable of are
+// left out.
+func processesByName(name string) ([]int, error) {
+	pids := make([]C.int, 1<<14)
+	n := C.list_pids(&pids[0], C.int(len(pids)))
+	if n < 0 {
+		return nil, fmt.Errorf("could not list processes")
+	}
+	var found []int
+	for _, pid := range pids[:n] {
+		if pid == 0 || int(pid) == os.Getpid() {
+			continue
+		}
+		path := C.GoString(C.find_executable(pid))
+		if path != "" && filepath.Base(path) == name {
+			found = append(found, int(pid))
+		}
+	}
+	return found, nil
+}
+
+// Returns the contents of the named section of exe, nil if it has none.
+func debugSection(exe *macho.File, name string) []byte {
+	sec := exe.Section(name)
+	if sec == nil {
+		return nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil
+	}
+	return data
 }
 
 func (dbp *DebuggedProcess) findExecutable() (*macho.File, error) {
@@ -172,10 +279,35 @@ func (dbp *DebuggedProcess) findExecutable() (*macho.File, error) {
 	if err != nil {
 		return nil, err
 	}
-	return macho.Open(C.GoString(pathptr))
+	dbp.path = C.GoString(pathptr)
+	return macho.Open(dbp.path)
 }
 
-func trapWait(dbp *DebuggedProcess, pid int) (int, error) {
+// The mach calls used to control the process report its death
+// through trapWait, errors are returned as they are unless the
+// process is already known to have exited.
+func (dbp *DebuggedProcess) exitedError(err error) error {
+	if err != nil && dbp.Exited() {
+		return dbp.exitError()
+	}
+	return err
+}
+
+// Stops the threads of the running process, for trapWait to return.
+func interrupt(dbp *DebuggedProcess) error {
+	dbp.mu.RLock()
+	threads := make([]*ThreadContext, 0, len(dbp.Threads))
+	for _, th := range dbp.Threads {
+		threads = append(threads, th)
+	}
+	dbp.mu.RUnlock()
+	for _, th := range threads {
+		th.Halt()
+	}
+	return nil
+}
+
+func trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error) {
 	port := C.mach_port_wait(dbp.os.portSet)
 
 	switch port {
@@ -184,16 +316,15 @@ func trapWait(dbp *DebuggedProcess, pid int) (int, error) {
 		if err != nil {
 			return -1, err
 		}
-		dbp.exited = true
-		return -1, ProcessExitedError{Pid: dbp.Pid, Status: status.ExitStatus()}
+		return -1, dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: status.ExitStatus()})
 	case C.MACH_RCV_INTERRUPTED:
-		if !dbp.halt {
+		if ctx.Err() == nil {
 			// Call trapWait again, it seems
 			// MACH_RCV_INTERRUPTED is emitted before
 			// process natural death _sometimes_.
-			return trapWait(dbp, pid)
+			return trapWait(ctx, dbp, pid)
 		}
-		return -1, ManualStopError{}
+		return -1, ctx.Err()
 	case 0:
 		return -1, fmt.Errorf("error while waiting for task")
 	}
@@ -205,8 +336,28 @@ func trapWait(dbp *DebuggedProcess, pid int) (int, error) {
 	return int(port), nil
 }
 
+// Processes share the file system of the debugger on darwin.
+func (dbp *DebuggedProcess) fsRoot() string {
+	return ""
+}
+
+// The runtime measures time with mach_absolute_time, which isn't
+// comparable across processes without its timebase.
+func monotonicNow() (int64, error) {
+	return 0, fmt.Errorf("not implemented on darwin")
+}
+
 func wait(pid, options int) (int, *sys.WaitStatus, error) {
 	var status sys.WaitStatus
 	wpid, err := sys.Wait4(pid, &status, options, nil)
 	return wpid, &status, err
 }
+
+// Resumes the threads suspended through mach and
+// detaches from the process, letting it run.
+func (dbp *DebuggedProcess) detach() error {
+	for _, th := range dbp.Threads {
+		C.resume_thread(th.os.thread_act)
+	}
+	return ptraceDetach(dbp.Pid)
+}
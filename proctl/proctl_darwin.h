@@ -24,11 +24,17 @@ boolean_t mach_exc_server(
 		mach_msg_header_t *OutHeadP);
 
 kern_return_t
-acquire_mach_task(int, mach_port_name_t*, mach_port_t*, mach_port_t*, mach_port_t*);
+acquire_task(int, mach_port_name_t*);
+
+kern_return_t
+acquire_mach_task(mach_port_name_t*, mach_port_t*, mach_port_t*, mach_port_t*);
 
 char *
 find_executable(int pid);
 
+int
+list_pids(int *pids, int count);
+
 kern_return_t
 get_threads(task_t task, void *);
 
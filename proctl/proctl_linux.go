@@ -1,32 +1,51 @@
 package proctl
 
 import (
+	"context"
 	"debug/elf"
 	"debug/gosym"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
+	"unsafe"
 
 	sys "golang.org/x/sys/unix"
 
 	"github.com/derekparker/delve/dwarf/frame"
+	"github.com/derekparker/delve/dwarf/loclist"
 )
 
 const (
 	STATUS_SLEEPING   = 'S'
 	STATUS_RUNNING    = 'R'
 	STATUS_TRACE_STOP = 't'
+	STATUS_ZOMBIE     = 'Z'
+	STATUS_DEAD       = 'X'
+)
+
+// Bounds on scanning the task list of a process while attaching
+// to its threads, see updateThreadList. The delay between scans
+// doubles each time.
+const (
+	maxAttachRetries = 10
+	attachRetryDelay = time.Millisecond
 )
 
 // Not actually needed for Linux.
 type OSProcessDetails interface{}
 
 func (dbp *DebuggedProcess) Halt() (err error) {
+	if dbp.Exited() {
+		return dbp.exitError()
+	}
 	for _, th := range dbp.Threads {
-		err := th.Halt()
+		err := dbp.backend.halt(th)
 		if err != nil {
 			return err
 		}
@@ -37,25 +56,82 @@ func (dbp *DebuggedProcess) Halt() (err error) {
 // Finds the executable from /proc/<pid>/exe and then
 // uses that to parse the following information:
 // * Dwarf .debug_frame section
-// * Dwarf .debug_line section
 // * Go symbol table.
+// The .debug_line section is indexed on first use, see LineIndex.
 func (dbp *DebuggedProcess) LoadInformation() error {
+	return dbp.loadInformation(fmt.Sprintf("/proc/%d/exe", dbp.Pid))
+}
+
+// Parses the debug information of the executable at path.
+func (dbp *DebuggedProcess) loadInformation(path string) error {
 	var (
-		wg  sync.WaitGroup
-		exe *elf.File
-		err error
+		wg         sync.WaitGroup
+		exe, debug *elf.File
+		err        error
 	)
 
-	exe, err = dbp.findExecutable()
+	exe, debug, err = dbp.findExecutable(path)
 	if err != nil {
 		return err
 	}
+	dbp.staticBase = dbp.loadBias(exe)
 
+	var frameErr, symErr error
 	wg.Add(2)
-	go dbp.parseDebugFrame(exe, &wg)
-	go dbp.obtainGoSymbols(exe, &wg)
+	go func() {
+		defer wg.Done()
+		frameErr = dbp.parseDebugFrame(exe, debug)
+	}()
+	go func() {
+		defer wg.Done()
+		symErr = dbp.obtainGoSymbols(exe)
+	}()
 	wg.Wait()
 
+	if err := dbp.loadError(frameErr, symErr); err != nil {
+		return err
+	}
+	dbp.loadRuntimeTypes()
+	return nil
+}
+
+// The ptrace options every thread of the process is traced with: new
+// threads and children are traced as well, execs reported, and syscall
+// stops told apart from SIGTRAPs.
+const ptraceBaseOptions = syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEFORK |
+	syscall.PTRACE_O_TRACEVFORK | syscall.PTRACE_O_TRACEEXEC | syscall.PTRACE_O_TRACESYSGOOD
+
+// Continues the stopped thread tid, delivering sig, until its next
+// syscall too while syscalls are caught, see CatchSyscall.
+func (dbp *DebuggedProcess) ptraceResume(tid, sig int) error {
+	if th, ok := dbp.Threads[tid]; ok {
+		th.regs = nil
+	}
+	if dbp.catchingSyscalls() {
+		return ptraceSyscall(tid, sig)
+	}
+	return PtraceCont(tid, sig)
+}
+
+// Returns the ptrace options of the threads of the process.
+func (dbp *DebuggedProcess) ptraceOptions() int {
+	if dbp.tracingExit() {
+		return ptraceBaseOptions | syscall.PTRACE_O_TRACEEXIT
+	}
+	return ptraceBaseOptions
+}
+
+// Makes the threads stop before exiting, for the exit hooks, or not.
+func (dbp *DebuggedProcess) traceExit(enable bool) error {
+	opts := ptraceBaseOptions
+	if enable {
+		opts |= syscall.PTRACE_O_TRACEEXIT
+	}
+	for _, th := range dbp.Threads {
+		if err := ptraceSetOptions(th.Id, opts); err != nil {
+			return fmt.Errorf("could not set options of thread %d: %s", th.Id, err)
+		}
+	}
 	return nil
 }
 
@@ -65,10 +141,9 @@ func (dbp *DebuggedProcess) addThread(tid int, attach bool) (*ThreadContext, err
 	if thread, ok := dbp.Threads[tid]; ok {
 		return thread, nil
 	}
-	fmt.Println("new thread spawned", tid)
 
 	if attach {
-		err := sys.PtraceAttach(tid)
+		err := ptraceAttach(tid)
 		if err != nil && err != sys.EPERM {
 			// Do not return err if err == EPERM,
 			// we may already be tracing this thread due to
@@ -87,91 +162,225 @@ func (dbp *DebuggedProcess) addThread(tid int, attach bool) (*ThreadContext, err
 		}
 	}
 
-	err := syscall.PtraceSetOptions(tid, syscall.PTRACE_O_TRACECLONE)
+	err := ptraceSetOptions(tid, dbp.ptraceOptions())
 	if err == syscall.ESRCH {
 		_, _, err = wait(tid, 0)
 		if err != nil {
 			return nil, fmt.Errorf("error while waiting after adding thread: %d %s", tid, err)
 		}
 
-		err := syscall.PtraceSetOptions(tid, syscall.PTRACE_O_TRACECLONE)
+		err := ptraceSetOptions(tid, dbp.ptraceOptions())
 		if err != nil {
 			return nil, fmt.Errorf("could not set options for new traced thread %d %s", tid, err)
 		}
 	}
 
-	dbp.Threads[tid] = &ThreadContext{
+	thread := &ThreadContext{
 		Id:      tid,
 		Process: dbp,
 	}
 
+	dbp.mu.Lock()
+	dbp.Threads[tid] = thread
 	if dbp.CurrentThread == nil {
-		dbp.CurrentThread = dbp.Threads[tid]
+		dbp.CurrentThread = thread
 	}
+	dbp.mu.Unlock()
+	dbp.emit(Event{Kind: EventThreadCreated, Thread: tid})
 
-	return dbp.Threads[tid], nil
+	return thread, nil
 }
 
+// Attaches to the threads of the process we are not tracing yet.
+//
+// Threads created by traced threads are traced automatically, but
+// until we have attached to all of them untraced threads may keep
+// spawning new ones, and threads may exit while we attach to them.
+// The task list is scanned again until it stops changing.
 func (dbp *DebuggedProcess) updateThreadList() error {
-	var attach bool
-	tids, _ := filepath.Glob(fmt.Sprintf("/proc/%d/task/*", dbp.Pid))
-	for _, tidpath := range tids {
-		tidstr := filepath.Base(tidpath)
-		tid, err := strconv.Atoi(tidstr)
+	var (
+		delay  = attachRetryDelay
+		exited = make(map[int]bool)
+	)
+	for i := 0; i < maxAttachRetries; i++ {
+		tids, err := dbp.tasks()
 		if err != nil {
 			return err
 		}
-		if tid != dbp.Pid {
-			attach = true
+
+		stable := true
+		for _, tid := range tids {
+			if _, ok := dbp.Threads[tid]; ok || exited[tid] {
+				continue
+			}
+			stable = false
+			if _, err := dbp.addThread(tid, tid != dbp.Pid); err != nil {
+				if tid != dbp.Pid && gone(tid) {
+					// Exited while we attached to it.
+					exited[tid] = true
+					continue
+				}
+				return err
+			}
 		}
-		if _, err := dbp.addThread(tid, attach); err != nil {
-			return err
+		if stable {
+			return nil
 		}
+
+		time.Sleep(delay)
+		delay *= 2
 	}
-	return nil
+	return fmt.Errorf("threads of process %d still changing after %d attempts to attach to them", dbp.Pid, maxAttachRetries)
 }
 
-func (dbp *DebuggedProcess) findExecutable() (*elf.File, error) {
-	procpath := fmt.Sprintf("/proc/%d/exe", dbp.Pid)
+// Returns the pids of the processes whose executable is named name,
+// other than ours. The executables of processes we can't read
+// /proc/<pid>/exe of are known by their command name, which the kernel
+// truncates to 15 characters.
+func processesByName(name string) ([]int, error) {
+	paths, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, path := range paths {
+		pid, err := strconv.Atoi(filepath.Base(path))
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if exe, err := os.Readlink(filepath.Join(path, "exe")); err == nil {
+			if filepath.Base(strings.TrimSuffix(exe, " (deleted)")) == name {
+				pids = append(pids, pid)
+			}
+			continue
+		}
+		comm, err := ioutil.ReadFile(filepath.Join(path, "comm"))
+		if err != nil {
+			// Exited meanwhile.
+			continue
+		}
+		short := name
+		if len(short) > 15 {
+			short = short[:15]
+		}
+		if strings.TrimSuffix(string(comm), "\n") == short {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
 
-	f, err := os.OpenFile(procpath, 0, os.ModePerm)
+// Returns the ids of the threads of the process.
+func (dbp *DebuggedProcess) tasks() ([]int, error) {
+	paths, err := filepath.Glob(fmt.Sprintf("/proc/%d/task/*", dbp.Pid))
 	if err != nil {
 		return nil, err
 	}
+	tids := make([]int, 0, len(paths))
+	for _, path := range paths {
+		tid, err := strconv.Atoi(filepath.Base(path))
+		if err != nil {
+			return nil, err
+		}
+		tids = append(tids, tid)
+	}
+	return tids, nil
+}
+
+// Opens the executable at path and the file holding its debug
+// information, the executable itself unless it was stripped of it.
+func (dbp *DebuggedProcess) findExecutable(path string) (*elf.File, *elf.File, error) {
+	f, err := os.OpenFile(path, 0, os.ModePerm)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	elffile, err := elf.NewFile(f)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if elffile.Machine != elfMachine {
+		return nil, nil, fmt.Errorf("%s is a %s binary, not %s", path, elffile.Machine, elfMachine)
+	}
+	dbp.path = path
+
+	debug := elffile
+	if !hasDebugInfo(elffile) {
+		if debug, err = openDebugInfo(elffile, path, dbp.fsRoot()); err != nil {
+			return nil, nil, err
+		}
 	}
 
-	data, err := elffile.DWARF()
+	data, err := debug.DWARF()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	dbp.Dwarf = data
+	dbp.locLists = loclist.New(debugSection(debug, ".debug_loc"), debugSection(debug, ".debug_loclists"), debugSection(debug, ".debug_addr"), int(ptrsize))
 
-	return elffile, nil
+	return elffile, debug, nil
 }
 
-func (dbp *DebuggedProcess) parseDebugFrame(exe *elf.File, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Returns the contents of the named section of exe, nil if it has none.
+func debugSection(exe *elf.File, name string) []byte {
+	sec := exe.Section(name)
+	if sec == nil {
+		return nil
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil
+	}
+	return data
+}
 
-	if sec := exe.Section(".debug_frame"); sec != nil {
-		debugFrame, err := exe.Section(".debug_frame").Data()
+// Parses the frame information of exe, from .debug_frame in the file
+// holding its debug information or else its own .eh_frame.
+func (dbp *DebuggedProcess) parseDebugFrame(exe, debug *elf.File) error {
+	// The C code of programs linked externally, as those using cgo,
+	// is only described by .eh_frame, as are binaries stripped of their
+	// debug information.
+	ehFrame, ehErr := dbp.parseEhFrame(exe)
+
+	if sec := debug.Section(".debug_frame"); sec != nil {
+		debugFrame, err := sec.Data()
 		if err != nil {
-			fmt.Println("could not get .debug_frame section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get .debug_frame section: %s", err)
 		}
 		dbp.FrameEntries = frame.Parse(debugFrame)
-	} else {
-		fmt.Println("could not find .debug_frame section in binary")
-		os.Exit(1)
+		dbp.FrameEntries.Relocate(dbp.staticBase)
+		if ehErr == nil {
+			dbp.FrameEntries = dbp.FrameEntries.Merge(ehFrame)
+		}
+		return nil
 	}
+
+	if ehErr != nil {
+		return ehErr
+	}
+	dbp.FrameEntries = ehFrame
+	return nil
 }
 
-func (dbp *DebuggedProcess) obtainGoSymbols(exe *elf.File, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Parses the frame information in the .eh_frame section of exe.
+func (dbp *DebuggedProcess) parseEhFrame(exe *elf.File) (frame.FrameDescriptionEntries, error) {
+	sec := exe.Section(".eh_frame")
+	if sec == nil {
+		return nil, fmt.Errorf("could not find .debug_frame or .eh_frame section in binary")
+	}
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("could not get .eh_frame section %s", err)
+	}
+	fdes, err := frame.ParseEhFrame(data, sec.Addr+dbp.staticBase)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse .eh_frame section %s", err)
+	}
+	return fdes, nil
+}
 
+// Loads the Go symbol table of exe, from its .gosymtab and .gopclntab.
+func (dbp *DebuggedProcess) obtainGoSymbols(exe *elf.File) error {
 	var (
 		symdat  []byte
 		pclndat []byte
@@ -181,27 +390,38 @@ func (dbp *DebuggedProcess) obtainGoSymbols(exe *elf.File, wg *sync.WaitGroup) {
 	if sec := exe.Section(".gosymtab"); sec != nil {
 		symdat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gosymtab section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get .gosymtab section: %s", err)
 		}
 	}
 
 	if sec := exe.Section(".gopclntab"); sec != nil {
 		pclndat, err = sec.Data()
 		if err != nil {
-			fmt.Println("could not get .gopclntab section", err)
-			os.Exit(1)
+			return fmt.Errorf("could not get .gopclntab section: %s", err)
 		}
 	}
 
-	pcln := gosym.NewLineTable(pclndat, exe.Section(".text").Addr)
+	// The Go code of programs linked externally, as those using
+	// cgo, doesn't start the .text section, and the line table is
+	// relative to where it starts.
+	text := exe.Section(".text").Addr
+	if syms, err := exe.Symbols(); err == nil {
+		for _, s := range syms {
+			if s.Name == "runtime.text" {
+				text = s.Value
+				break
+			}
+		}
+	}
+
+	pcln := gosym.NewLineTable(pclndat, text+dbp.staticBase)
 	tab, err := gosym.NewTable(symdat, pcln)
 	if err != nil {
-		fmt.Println("could not get initialize line table", err)
-		os.Exit(1)
+		return fmt.Errorf("could not initialize line table: %s", err)
 	}
 
 	dbp.GoSymTable = tab
+	return nil
 }
 
 func stopped(pid int) bool {
@@ -223,30 +443,91 @@ func stopped(pid int) bool {
 	return false
 }
 
-func trapWait(dbp *DebuggedProcess, pid int) (int, error) {
+// Stops the running threads of the process, for trapWait to return.
+// A signal sent to the whole process could be left pending on one of
+// the threads we stopped, those not resumed while stepping, which
+// would never report it.
+func interrupt(dbp *DebuggedProcess) error {
+	dbp.mu.RLock()
+	tids := make([]int, 0, len(dbp.Threads))
+	for tid := range dbp.Threads {
+		tids = append(tids, tid)
+	}
+	dbp.mu.RUnlock()
+	for _, tid := range tids {
+		if stopped(tid) {
+			continue
+		}
+		if err := sys.Tgkill(dbp.Pid, tid, sys.SIGSTOP); err != nil && err != sys.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+func trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error) {
 	for {
+		if ctx.Err() != nil {
+			// Threads reporting an event as they were interrupted
+			// are skipped by interrupt, and resumed since.
+			if err := interrupt(dbp); err != nil {
+				return -1, err
+			}
+		}
 		wpid, status, err := wait(pid, 0)
 		if err != nil {
+			if err == sys.ECHILD {
+				// Reaped behind our back, the exit status is lost.
+				return -1, dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: -1})
+			}
 			return -1, fmt.Errorf("wait err %s %d", err, pid)
 		}
 		if wpid == 0 {
 			continue
 		}
+		if dbp.forks[wpid] {
+			if err := dbp.childStatus(wpid, status); err != nil {
+				return -1, fmt.Errorf("could not follow child %d: %s", wpid, err)
+			}
+			continue
+		}
+		if _, ok := dbp.Threads[wpid]; !ok && status.Stopped() && status.StopSignal() == sys.SIGSTOP {
+			// A new thread or a child, stopped before its
+			// creation was reported. Threads are continued once
+			// it is, children are handled by forked.
+			if pid, err := tgid(wpid); err == nil && pid != dbp.Pid {
+				if dbp.pendingForks == nil {
+					dbp.pendingForks = make(map[int]bool)
+				}
+				dbp.pendingForks[wpid] = true
+			}
+			continue
+		}
 		if th, ok := dbp.Threads[wpid]; ok {
 			th.Status = status
 		}
 
-		if status.Exited() && wpid == dbp.Pid {
-			return -1, ProcessExitedError{Pid: wpid, Status: status.ExitStatus()}
+		if status.Exited() || status.Signaled() {
+			if wpid == dbp.Pid {
+				return -1, dbp.processExited(status)
+			}
+			// A thread went away, stop tracking it.
+			dbp.logger().Debugf("thread %d exited", wpid)
+			dbp.mu.Lock()
+			delete(dbp.Threads, wpid)
+			dbp.mu.Unlock()
+			dbp.emit(Event{Kind: EventThreadExited, Thread: wpid})
+			continue
 		}
 		if status.StopSignal() == sys.SIGTRAP && status.TrapCause() == sys.PTRACE_EVENT_CLONE {
 			// A traced thread has cloned a new thread, grab the pid and
 			// add it to our list of traced threads.
-			cloned, err := sys.PtraceGetEventMsg(wpid)
+			cloned, err := ptraceGetEventMsg(wpid)
 			if err != nil {
 				return -1, fmt.Errorf("could not get event message: %s", err)
 			}
 
+			dbp.logger().Debugf("thread %d spawned thread %d", wpid, cloned)
 			th, err := dbp.addThread(int(cloned), false)
 			if err != nil {
 				return -1, err
@@ -263,17 +544,179 @@ func trapWait(dbp *DebuggedProcess, pid int) (int, error) {
 			}
 			continue
 		}
+		if status.StopSignal() == sys.SIGTRAP && (status.TrapCause() == sys.PTRACE_EVENT_FORK || status.TrapCause() == sys.PTRACE_EVENT_VFORK) {
+			child, err := ptraceGetEventMsg(wpid)
+			if err != nil {
+				return -1, fmt.Errorf("could not get event message: %s", err)
+			}
+			dbp.logger().Debugf("thread %d forked process %d", wpid, child)
+			if err := dbp.forked(wpid, int(child), status.TrapCause() == sys.PTRACE_EVENT_VFORK); err != nil {
+				return -1, err
+			}
+			if err := dbp.ptraceResume(wpid, 0); err != nil {
+				return -1, fmt.Errorf("could not continue forking thread %d %s", wpid, err)
+			}
+			continue
+		}
+		if status.StopSignal() == sys.SIGTRAP && status.TrapCause() == sys.PTRACE_EVENT_EXEC {
+			// Reported for the process, whichever thread exec'd.
+			if err := dbp.execed(); err != nil {
+				return -1, err
+			}
+			return dbp.Pid, nil
+		}
+		if status.StopSignal() == sys.SIGTRAP && status.TrapCause() == sys.PTRACE_EVENT_EXIT {
+			// The thread is about to exit, its exit is reported next.
+			if th, ok := dbp.Threads[wpid]; ok {
+				dbp.runExitHooks(th)
+			}
+			if err := dbp.ptraceResume(wpid, 0); err != nil && err != sys.ESRCH {
+				return -1, fmt.Errorf("could not continue exiting thread %d %s", wpid, err)
+			}
+			continue
+		}
+		if status.StopSignal() == sys.SIGTRAP|0x80 {
+			th, ok := dbp.Threads[wpid]
+			if !ok {
+				continue
+			}
+			stop, err := th.syscallStop()
+			if err != nil {
+				return -1, fmt.Errorf("could not read the syscall of thread %d: %s", wpid, err)
+			}
+			if stop {
+				return wpid, nil
+			}
+			if err := dbp.ptraceResume(wpid, 0); err != nil && err != sys.ESRCH {
+				return -1, fmt.Errorf("could not continue thread %d %s", wpid, err)
+			}
+			continue
+		}
 		if status.StopSignal() == sys.SIGTRAP {
 			return wpid, nil
 		}
-		if status.StopSignal() == sys.SIGSTOP && dbp.halt {
-			return -1, ManualStopError{}
+		if status.StopSignal() == sys.SIGSTOP {
+			if ctx.Err() != nil {
+				return -1, ctx.Err()
+			}
+			// Left over from interrupting an operation which
+			// completed meanwhile, discarded.
+			if err := dbp.ptraceResume(wpid, 0); err != nil && err != sys.ESRCH {
+				return -1, fmt.Errorf("could not continue thread %d %s", wpid, err)
+			}
+			continue
+		}
+		if sig := status.StopSignal(); status.Stopped() && sig != sys.SIGTRAP && sig != sys.SIGSTOP {
+			th, ok := dbp.Threads[wpid]
+			if !ok {
+				continue
+			}
+			dbp.logger().Debugf("thread %d received %s", wpid, SignalName(sig))
+			switch dbp.SignalPolicy(sig) {
+			case SignalStop:
+				dbp.signalStopped(th, sig)
+				return wpid, nil
+			case SignalIgnore:
+				sig = 0
+			}
+			if err := dbp.ptraceResume(wpid, int(sig)); err != nil && err != sys.ESRCH {
+				return -1, fmt.Errorf("could not deliver %s to thread %d %s", SignalName(sig), wpid, err)
+			}
+		}
+	}
+}
+
+// Converts the error returned by a ptrace request into a
+// ProcessExitedError if it failed because the process is gone,
+// for example because it was killed while stopped.
+func (dbp *DebuggedProcess) exitedError(err error) error {
+	if err != nil && dbp.Exited() {
+		return dbp.exitError()
+	}
+	if err != sys.ESRCH || !gone(dbp.Pid) {
+		return err
+	}
+
+	// The process is a zombie or was reaped already. The exit of its
+	// main thread is only reported once its other threads, which are
+	// dying too, have been reaped.
+	for {
+		wpid, status, werr := wait(-1, 0)
+		if werr != nil {
+			return dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: -1})
+		}
+		if wpid == dbp.Pid && (status.Exited() || status.Signaled()) {
+			return dbp.processExited(status)
+		}
+		if status.Stopped() {
+			// On its way out, e.g. reporting PTRACE_EVENT_EXIT.
+			dbp.ptraceResume(wpid, 0)
 		}
 	}
 }
 
+// Records the exit of the process, returning the matching error.
+func (dbp *DebuggedProcess) processExited(status *sys.WaitStatus) error {
+	if status.Signaled() {
+		return dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: -1, Signal: status.Signal()})
+	}
+	return dbp.setExited(ProcessExitedError{Pid: dbp.Pid, Status: status.ExitStatus()})
+}
+
+// Returns true if the process with the given pid
+// no longer exists, or is a zombie.
+func gone(pid int) bool {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	defer f.Close()
+
+	var (
+		p     int
+		comm  string
+		state rune
+	)
+	fmt.Fscanf(f, "%d %s %c", &p, &comm, &state)
+	return state == STATUS_ZOMBIE || state == STATUS_DEAD
+}
+
+// Returns the root of the file system of the process, as seen from
+// ours, when it runs in another mount namespace, e.g. in a container,
+// and an empty string when it shares our file system.
+func (dbp *DebuggedProcess) fsRoot() string {
+	ours, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return ""
+	}
+	theirs, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", dbp.Pid))
+	if err != nil || theirs == ours {
+		return ""
+	}
+	return fmt.Sprintf("/proc/%d/root", dbp.Pid)
+}
+
+// Returns CLOCK_MONOTONIC in nanoseconds, the clock of runtime.nanotime.
+func monotonicNow() (int64, error) {
+	var ts syscall.Timespec
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return 0, errno
+	}
+	return ts.Nano(), nil
+}
+
 func wait(pid, options int) (int, *sys.WaitStatus, error) {
 	var status sys.WaitStatus
 	wpid, err := sys.Wait4(pid, &status, sys.WALL|options, nil)
 	return wpid, &status, err
 }
+
+// Detaches from every thread of the process, letting them run.
+func (dbp *DebuggedProcess) detach() error {
+	for _, th := range dbp.Threads {
+		if err := ptraceDetach(th.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
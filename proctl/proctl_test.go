@@ -1,17 +1,30 @@
 package proctl
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"debug/dwarf"
+	"debug/elf"
 	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func withTestProcess(name string, t *testing.T, fn func(p *DebuggedProcess)) {
-	runtime.LockOSThread()
 	base := filepath.Base(name)
 	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", base, name+".go").Run(); err != nil {
 		t.Fatalf("Could not compile %s due to %s", name, err)
@@ -159,6 +172,100 @@ func TestBreakPointInSeperateGoRoutine(t *testing.T) {
 	})
 }
 
+func TestBreakOnGoroutineCreation(t *testing.T) {
+	withTestProcess("../_fixtures/testthreads", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakOnGoroutineCreation(false)
+		assertNoError(err, t, "BreakOnGoroutineCreation()")
+
+		// The runtime starts goroutines of its own first.
+		var gc *GoroutineCreation
+		for i := 0; i < 20 && gc == nil; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+			creations := p.GoroutineCreations()
+			if last := creations[len(creations)-1]; len(last.Stack) > 0 && last.Stack[0].Function == "main.main" {
+				gc = last
+			}
+		}
+		if gc == nil {
+			t.Fatal("No goroutine created by main.main")
+		}
+		if gc.Entry == 0 || gc.Creator == 0 || gc.PC == 0 {
+			t.Fatalf("Creation not decoded: %#v", gc)
+		}
+
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+		_, err = p.BreakByLocation("main.anotherthread")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		g, err := p.CurrentThread.CurrentGoroutine()
+		assertNoError(err, t, "CurrentGoroutine()")
+		creator, err := p.GoroutineCreator(g.Id)
+		assertNoError(err, t, "GoroutineCreator()")
+		if creator.Creator != gc.Creator || creator.PC != gc.PC || creator.Stack[0].Function != "main.main" {
+			t.Fatalf("Goroutine %d reported created by %s, expected %s", g.Id, creator, gc)
+		}
+	})
+}
+
+func TestBreakOnEvent(t *testing.T) {
+	withTestProcess("../_fixtures/testdefers", t, func(p *DebuggedProcess) {
+		_, err := p.BreakOnEvent("no-such-event")
+		if _, ok := err.(UnknownEventError); !ok {
+			t.Fatalf("Expected UnknownEventError, got %v", err)
+		}
+
+		bp, err := p.BreakOnEvent("panic")
+		assertNoError(err, t, "BreakOnEvent()")
+		if bp.FunctionName != "runtime.gopanic" {
+			t.Fatalf("Expected a breakpoint on runtime.gopanic, got %s", bp.FunctionName)
+		}
+		assertNoError(p.Continue(), t, "Continue()")
+
+		if pc := currentPC(p, t); pc != bp.Addr {
+			t.Fatalf("Expected to stop at the entry of runtime.gopanic (%#x), stopped at %#x", bp.Addr, pc)
+		}
+		frames, err := p.CurrentThread.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+		if frames[1].Function != "main.inner" || frames[1].Line != 13 {
+			t.Fatalf("Expected the panic to be raised at main.inner:13, got %s:%d", frames[1].Function, frames[1].Line)
+		}
+	})
+}
+
+func TestBreakOnGoroutineExit(t *testing.T) {
+	withTestProcess("../_fixtures/testgoexit", t, func(p *DebuggedProcess) {
+		if _, err := p.BreakOnGoroutineExit(9999); err == nil {
+			t.Fatal("Expected an error breaking on the exit of a goroutine that doesn't exist")
+		}
+
+		for _, name := range []string{"main.returning", "main.exiting"} {
+			bp, err := p.BreakByLocation(name)
+			assertNoError(err, t, "BreakByLocation()")
+			assertNoError(p.Continue(), t, "Continue()")
+			_, err = p.Clear(bp.Addr)
+			assertNoError(err, t, "Clear()")
+			g, err := p.CurrentThread.CurrentGoroutine()
+			assertNoError(err, t, "CurrentGoroutine()")
+
+			// Goroutines of the runtime may exit too, but only g stops.
+			exit, err := p.BreakOnGoroutineExit(g.Id)
+			assertNoError(err, t, "BreakOnGoroutineExit()")
+			assertNoError(p.Continue(), t, "Continue()")
+			if pc := currentPC(p, t); pc != exit.Addr {
+				t.Fatalf("Expected %s to stop at its exit (%#x), stopped at %#x", name, exit.Addr, pc)
+			}
+			eg, err := p.CurrentThread.CurrentGoroutine()
+			assertNoError(err, t, "CurrentGoroutine()")
+			if eg.Id != g.Id {
+				t.Fatalf("Expected goroutine %d to stop at its exit, goroutine %d did", g.Id, eg.Id)
+			}
+			_, err = p.Clear(exit.Addr)
+			assertNoError(err, t, "Clear()")
+		}
+	})
+}
+
 func TestBreakPointWithNonExistantFunction(t *testing.T) {
 	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
 		_, err := p.Break(0)
@@ -193,122 +300,3478 @@ func TestClearBreakPoint(t *testing.T) {
 	})
 }
 
-func TestNext(t *testing.T) {
-	var (
-		err            error
-		executablePath = "../_fixtures/testnextprog"
-	)
+func TestDisableBreakPoint(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.sleepytime")
+		bp, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
 
-	testcases := []struct {
-		begin, end int
-	}{
-		{19, 20},
-		{20, 23},
-		{23, 24},
-		{24, 26},
-		{26, 31},
-		{31, 23},
-		{23, 24},
-		{24, 26},
-		{26, 31},
-		{31, 23},
-		{23, 24},
-		{24, 26},
-		{26, 27},
-		{27, 34},
-		{34, 35},
-		{35, 41},
-		{41, 40},
-		{40, 41},
-	}
+		_, err = p.DisableBreakpoint(bp.ID)
+		assertNoError(err, t, "DisableBreakpoint()")
+		if !bp.Disabled || !p.BreakpointExists(bp.Addr) {
+			t.Fatal("Breakpoint not kept disabled")
+		}
 
-	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+		enabled, err := p.EnableBreakpoint(bp.ID)
+		assertNoError(err, t, "EnableBreakpoint()")
+		if enabled != bp || bp.Disabled {
+			t.Fatal("Breakpoint not enabled")
+		}
+
+		if _, err := p.DisableBreakpoint(bp.ID + 1); err == nil {
+			t.Fatal("Expected an error disabling an unknown breakpoint")
+		}
+	})
+}
+
+func TestBreakpoints(t *testing.T) {
+	withTestProcess("../_fixtures/fncall", t, func(p *DebuggedProcess) {
+		// More than fit in the debug registers, to get both kinds.
+		fns := []string{"main.main", "main.add", "main.greet", "main.divmod", "main.boom"}
+		for _, name := range fns {
+			fn := p.GoSymTable.LookupFunc(name)
+			_, err := p.Break(fn.Entry)
+			assertNoError(err, t, "Break()")
+		}
+
+		bps := p.Breakpoints()
+		if len(bps) != len(fns) {
+			t.Fatalf("Expected %d breakpoints, got %d", len(fns), len(bps))
+		}
+		for i, bp := range bps {
+			if bp.FunctionName != fns[i] || bp.File == "" || bp.Line == 0 {
+				t.Fatalf("Expected breakpoint %d in %s, got %s in %s", i, fns[i], bp, bp.FunctionName)
+			}
+			if i > 0 && bps[i-1].ID >= bp.ID {
+				t.Fatalf("Breakpoints not sorted by ID: %v", bps)
+			}
+		}
+	})
+}
+
+func TestThreadInfo(t *testing.T) {
+	withTestProcess("../_fixtures/testthreads", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.anotherthread")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		infos, err := p.ThreadInfo()
+		assertNoError(err, t, "ThreadInfo()")
+		if len(infos) != len(p.Threads) {
+			t.Fatalf("Expected %d threads, got %d", len(p.Threads), len(infos))
+		}
+		current := 0
+		for i, ti := range infos {
+			if i > 0 && infos[i-1].Id >= ti.Id {
+				t.Fatalf("Threads not sorted by id: %v", infos)
+			}
+			if runtime.GOOS == "linux" && ti.State != "tracing stop" {
+				t.Fatalf("Expected thread %d to be in tracing stop, got %s", ti.Id, ti.State)
+			}
+			if !ti.Current {
+				continue
+			}
+			current++
+			if ti.Id != p.CurrentThread.Id || ti.Function != "main.anotherthread" {
+				t.Fatalf("Expected the current thread %d in main.anotherthread, got %s", p.CurrentThread.Id, ti)
+			}
+		}
+		if current != 1 {
+			t.Fatalf("Expected one current thread, got %d", current)
+		}
+	})
+}
+
+func TestAllStacktraces(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testcontext.go")
 	if err != nil {
 		t.Fatal(err)
 	}
+	withTestProcess("../_fixtures/testcontext", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 33)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
 
-	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
-		pc, _, _ := p.GoSymTable.LineToPC(fp, testcases[0].begin)
+		stacks, err := p.AllStacktraces(5)
+		assertNoError(err, t, "AllStacktraces()")
+		var current, parked bool
+		for _, gs := range stacks {
+			if len(gs.Frames) > 5 {
+				t.Fatalf("Expected at most 5 frames, got %s", gs)
+			}
+			if len(gs.Frames) == 0 {
+				continue
+			}
+			if gs.Frames[0].Function == "main.main" {
+				current = gs.Status == "running"
+			}
+			for _, f := range gs.Frames[1:] {
+				if f.Function == "main.handle" {
+					parked = gs.Status == "chan receive"
+				}
+			}
+		}
+		if !current || !parked {
+			t.Fatalf("Expected main.main running and main.handle waiting, got %v", stacks)
+		}
+	})
+}
+
+func TestPartialStacktrace(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testcontext.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTestProcess("../_fixtures/testcontext", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 33)
 		_, err := p.Break(pc)
 		assertNoError(err, t, "Break()")
 		assertNoError(p.Continue(), t, "Continue()")
 
-		f, ln := currentLineNumber(p, t)
-		for _, tc := range testcases {
-			if ln != tc.begin {
-				t.Fatalf("Program not stopped at correct spot expected %d was %s:%d", tc.begin, f, ln)
+		stacks, err := p.AllStacktraces(1)
+		assertNoError(err, t, "AllStacktraces()")
+		for _, gs := range stacks {
+			if len(gs.Frames) == 1 && gs.Frames[0].Function == "main.main" && !gs.Truncated {
+				t.Fatalf("Expected the stack of main.main to be truncated, got %s", gs)
 			}
+		}
 
-			assertNoError(p.Next(), t, "Next() returned an error")
+		// A stack pointer into unmapped memory, as that of a corrupt
+		// stack, leaves the caller of the first frame unknown.
+		frames, err := p.stacktrace(pc, 0x10, nil, 10)
+		if _, ok := err.(UnwindError); !ok {
+			t.Fatalf("Expected an UnwindError, got %v", err)
+		}
+		if len(frames) != 1 || frames[0].fn == nil || frames[0].fn.Name != "main.main" {
+			t.Fatalf("Expected the frame of main.main, got %v", frames)
+		}
+	})
+}
 
-			f, ln = currentLineNumber(p, t)
-			if ln != tc.end {
-				t.Fatalf("Program did not continue to correct next location expected %d was %s:%d", tc.end, f, ln)
+func TestGoroutineState(t *testing.T) {
+	withTestProcess("../_fixtures/testcontext", t, func(p *DebuggedProcess) {
+		now, err := p.nanotime()
+		assertNoError(err, t, "nanotime()")
+		for _, tc := range []struct {
+			g        G
+			expected string
+		}{
+			{G{status: gstatusRunnable}, "runnable"},
+			{G{status: gstatusWaiting}, "waiting"},
+			{G{status: gstatusWaiting, waitreason: "chan receive", waitsince: now - int64(30*time.Second)}, "chan receive"},
+			{G{status: gstatusWaiting, waitreason: "chan receive", waitsince: now - int64(3*time.Minute)}, "chan receive, 3 minutes"},
+			{G{status: gstatusSyscall, waitsince: now - int64(time.Minute), lockedm: true}, "syscall, 1 minutes, locked to thread"},
+		} {
+			if state := p.GoroutineState(&tc.g); state != tc.expected {
+				t.Fatalf("Expected %q got %q", tc.expected, state)
+			}
+		}
+	})
+}
+
+func TestSelectCases(t *testing.T) {
+	withTestProcess("../_fixtures/testselect", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.waiting")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		chans := make(map[string]uint64)
+		for _, name := range []string{"main.requests", "main.replies"} {
+			addr, _, err := p.globalVariable(name)
+			assertNoError(err, t, "globalVariable()")
+			chans[name], err = p.readPointer(addr)
+			assertNoError(err, t, "readPointer()")
+		}
+
+		gs, err := p.Goroutines()
+		assertNoError(err, t, "Goroutines()")
+		var cases []*SelectCase
+		for _, g := range gs {
+			if g.Func == nil || g.Func.Name != "runtime.gopark" {
+				continue
+			}
+			if cases, err = p.SelectCases(g); err == nil {
+				break
 			}
 		}
+		if cases == nil {
+			t.Fatal("No goroutine blocked in a select")
+		}
 
-		p.Clear(pc)
-		if len(p.BreakPoints) != 0 {
-			t.Fatal("Not all breakpoints were cleaned up", len(p.HWBreakPoints))
+		// The compiler orders sends first, and receives from the
+		// last case backwards.
+		expected := []SelectCase{
+			{Chan: chans["main.replies"], Direction: "send"},
+			{Chan: 0, Direction: "recv"},
+			{Chan: chans["main.requests"], Direction: "recv"},
 		}
-		for _, bp := range p.HWBreakPoints {
-			if bp != nil {
-				t.Fatal("Not all breakpoints were cleaned up", bp.Addr)
+		if len(cases) != len(expected) {
+			t.Fatalf("Expected %d cases, got %v", len(expected), cases)
+		}
+		for i := range expected {
+			if *cases[i] != expected[i] {
+				t.Fatalf("Expected case %d to be %s, got %s", i, &expected[i], cases[i])
 			}
 		}
 	})
 }
 
-func TestFindReturnAddress(t *testing.T) {
-	var testfile, _ = filepath.Abs("../_fixtures/testnextprog")
+func TestWaitGroupState(t *testing.T) {
+	withTestProcess("../_fixtures/testwaitgroup", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.waiting")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
 
-	withTestProcess(testfile, t, func(p *DebuggedProcess) {
-		var (
-			fdes = p.FrameEntries
-			gsd  = p.GoSymTable
-		)
+		// Three workers are outstanding, and main waits for them.
+		v, err := p.EvalSymbol("main.wg")
+		assertNoError(err, t, "EvalSymbol()")
+		if expected := "sync.WaitGroup {counter: 3, waiters: 1, waiting: [1]}"; v.Value != expected {
+			t.Fatalf("Expected %s got %s", expected, v.Value)
+		}
+	})
+}
 
-		testsourcefile := testfile + ".go"
-		start, _, err := gsd.LineToPC(testsourcefile, 24)
-		if err != nil {
-			t.Fatal(err)
+func TestInterfaceValues(t *testing.T) {
+	withTestProcess("../_fixtures/testinterfaces", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.inspect")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// Values are stored directly in the data word, or
+		// pointed to by it, depending on their type.
+		for name, expected := range map[string]string{
+			"main.square":  "(struct main.Square) {side: 2}",
+			"main.circle":  "(*main.Circle) *{r: 1}",
+			"main.none":    "nil",
+			"main.number":  "(int) 42",
+			"main.text":    "(struct string) hello",
+			"main.failure": "(*errors.errorString) *{\"failed\"}",
+			"main.success": "nil",
+		} {
+			v, err := p.EvalSymbol(name)
+			assertNoError(err, t, "EvalSymbol()")
+			if v.Value != expected {
+				t.Errorf("%s: expected %s got %s", name, expected, v.Value)
+			}
 		}
+	})
+}
 
-		_, err = p.Break(start)
-		if err != nil {
-			t.Fatal(err)
+// Breaks with the breakpoint instruction, which all threads run into,
+// rather than debug registers, which are set on the current thread.
+type softwareBreakpoints struct {
+	backend
+}
+
+func (sb softwareBreakpoints) info() BackendInfo {
+	info := sb.backend.info()
+	info.HardwareBreakpoints = false
+	return info
+}
+
+func TestDeadlocks(t *testing.T) {
+	withTestProcess("../_fixtures/testdeadlock", t, func(p *DebuggedProcess) {
+		// The runtime notices the deadlock, and is stopped as it
+		// reports it, on whichever thread went idle last.
+		p.backend = softwareBreakpoints{p.backend}
+		_, err := p.BreakByLocation("runtime.fatal")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		addrs := make(map[string]uint64)
+		for _, name := range []string{"main.a", "main.b", "main.updates"} {
+			addr, _, err := p.globalVariable(name)
+			assertNoError(err, t, "globalVariable()")
+			addrs[name] = addr
 		}
+		addrs["main.updates"], err = p.readPointer(addrs["main.updates"])
+		assertNoError(err, t, "readPointer()")
 
-		err = p.Continue()
-		if err != nil {
-			t.Fatal(err)
+		report, err := p.Deadlocks()
+		assertNoError(err, t, "Deadlocks()")
+		if !report.Deadlocked || len(report.Progressing) != 0 {
+			t.Fatalf("Expected a deadlock, goroutines %v are progressing", report.Progressing)
 		}
+		waits := make(map[string][]WaitResource)
+		for _, bg := range report.Blocked {
+			frames, err := p.stacktrace(bg.G.PC, bg.G.SP, nil, maxWaitDepth)
+			assertNoError(err, t, "stacktrace()")
+			for _, frame := range frames {
+				if frame.fn != nil && strings.HasPrefix(frame.fn.Name, "main.") {
+					waits[frame.fn.Name] = bg.Waits
+					break
+				}
+			}
+		}
+		expected := map[string][]WaitResource{
+			"main.main":     {{Kind: "mutex", Addr: addrs["main.b"]}},
+			"main.second":   {{Kind: "mutex", Addr: addrs["main.a"]}},
+			"main.consumer": {{Kind: "chan recv", Addr: addrs["main.updates"]}},
+			"main.forever":  {{Kind: "chan recv", Addr: 0}},
+		}
+		if !reflect.DeepEqual(waits, expected) {
+			t.Fatalf("Expected %v got %v", expected, waits)
+		}
+	})
+}
 
-		regs, err := p.Registers()
-		if err != nil {
-			t.Fatal(err)
+func TestTimers(t *testing.T) {
+	withTestProcess("../_fixtures/testtimers", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.ready")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		now, err := p.nanotime()
+		assertNoError(err, t, "nanotime()")
+		timers, err := p.Timers()
+		assertNoError(err, t, "Timers()")
+
+		// Each fires within its duration of now.
+		within := func(tm *Timer, d time.Duration) bool {
+			return tm.When > now && tm.When <= now+int64(d)
+		}
+		var afterFunc, ticker *Timer
+		for _, tm := range timers {
+			if tm.AfterFunc != nil && tm.AfterFunc.Name == "main.fired" {
+				afterFunc = tm
+			}
+			if tm.Period == int64(time.Minute) {
+				ticker = tm
+			}
+		}
+		if afterFunc == nil || afterFunc.Period != 0 || !within(afterFunc, time.Hour) || within(afterFunc, time.Minute) {
+			t.Fatalf("Expected main.fired to be called within the hour, got %v", timers)
 		}
+		if ticker == nil || !within(ticker, time.Minute) {
+			t.Fatalf("Expected a ticker firing every minute, got %v", timers)
+		}
+	})
+}
 
-		fde, err := fdes.FDEForPC(start)
-		if err != nil {
-			t.Fatal(err)
+func TestDefersAndPanics(t *testing.T) {
+	withTestProcess("../_fixtures/testdefers", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.cleanup")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// cleanup(1) runs first, as the panic unwinds inner.
+		defers, err := p.Defers()
+		assertNoError(err, t, "Defers()")
+		if len(defers) != 2 || !strings.HasPrefix(defers[0].Function, "main.inner") || !strings.HasPrefix(defers[1].Function, "main.recovering") {
+			t.Fatalf("Expected the defers of inner and recovering, got %v", defers)
+		}
+		if defers[0].Line != 11 || defers[1].Line != 17 {
+			t.Fatalf("Expected defers at lines 11 and 17, got %v", defers)
 		}
 
-		ret := fde.ReturnAddressOffset(start)
-		if err != nil {
-			t.Fatal(err)
+		panics, err := p.Panics()
+		assertNoError(err, t, "Panics()")
+		if len(panics) != 1 || !strings.Contains(panics[0].Value, "boom") || panics[0].Recovered {
+			t.Fatalf("Expected a panic with boom in flight, got %v", panics)
 		}
+	})
+}
 
-		addr := uint64(int64(regs.SP()) + ret)
-		data := make([]byte, 8)
+func TestStepToDeferred(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testdefers.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTestProcess("../_fixtures/testdefers", t, func(p *DebuggedProcess) {
+		for _, line := range []int{27, 13} {
+			pc, _, _ := p.GoSymTable.LineToPC(fp, line)
+			_, err := p.Break(pc)
+			assertNoError(err, t, "Break()")
+		}
 
-		readMemory(p.CurrentThread, uintptr(addr), data)
-		addr = binary.LittleEndian.Uint64(data)
+		// closing returns normally, running its deferred closure.
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.StepToDeferred(), t, "StepToDeferred()")
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if fn := p.GoSymTable.PCToFunc(pc); fn == nil || fn.Name != "main.closing.func1" {
+			t.Fatalf("Expected to stop in the closure deferred by closing, stopped at %#x", pc)
+		}
+		defers, err := p.Defers()
+		assertNoError(err, t, "Defers()")
+		if len(defers) != 0 {
+			t.Fatalf("Expected the deferred closure to be running, got pending %v", defers)
+		}
 
-		linuxExpected := uint64(0x400fbc)
-		darwinExpected := uint64(0x23bc)
-		if addr != linuxExpected && addr != darwinExpected {
-			t.Fatalf("return address not found correctly, expected (linux) %#v or (darwin) %#v got %#v", linuxExpected, darwinExpected, addr)
+		// inner panics, running cleanup(1) first.
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.StepToDeferred(), t, "StepToDeferred()")
+		pc, err = p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if fn := p.GoSymTable.PCToFunc(pc); fn == nil || !strings.HasPrefix(fn.Name, "main.inner") {
+			t.Fatalf("Expected to stop in the call deferred by inner, stopped at %#x", pc)
+		}
+		defers, err = p.Defers()
+		assertNoError(err, t, "Defers()")
+		if len(defers) != 2 {
+			t.Fatalf("Expected cleanup(0) and the defer of recovering to be pending, got %v", defers)
+		}
+	})
+}
+
+func TestBreakOnUnknownGoroutine(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		if _, err := p.BreakByLocationOnGoroutine("main.helloworld", 1000); err == nil {
+			t.Fatal("Expected an error restricting a breakpoint to an unknown goroutine")
+		}
+		if p.BreakpointExists(p.GoSymTable.LookupFunc("main.helloworld").Entry) {
+			t.Fatal("Breakpoint set for an unknown goroutine")
+		}
+	})
+}
+
+func TestTracepoint(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.TraceByLocation("main.helloworld", "a")
+		assertNoError(err, t, "TraceByLocation()")
+		if !bp.Tracepoint || !reflect.DeepEqual(bp.Variables, []string{"a"}) {
+			t.Fatalf("Tracepoint not set up: %#v", bp)
+		}
+		if !strings.HasPrefix(bp.String(), "Tracepoint") {
+			t.Fatalf("Unexpected description %s", bp)
+		}
+	})
+}
+
+func TestTracepointStack(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		tp, err := p.TraceByLocation("main.helloworld")
+		assertNoError(err, t, "TraceByLocation()")
+		tp.Stacktrace = 2
+
+		events := make(chan Event, 64)
+		p.Notify(events)
+		defer p.StopNotify(events)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if err := p.ContinueContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("Expected the deadline to be exceeded, got %v", err)
+		}
+
+		hits := 0
+		for len(events) > 0 {
+			ev := <-events
+			if ev.Kind != EventBreakpointHit {
+				continue
+			}
+			hits++
+			if len(ev.Stack) != 2 || ev.Stack[0].Function != "main.helloworld" || ev.Stack[1].Function != "main.main" {
+				t.Fatalf("Expected main.main calling main.helloworld, got %v", ev.Stack)
+			}
+		}
+		if hits == 0 {
+			t.Fatal("Expected the tracepoint to be hit")
+		}
+	})
+}
+
+func TestBreakInterfaceMethod(t *testing.T) {
+	withTestProcess("../_fixtures/testifaces", t, func(p *DebuggedProcess) {
+		bps, err := p.BreakInterfaceMethod("main.Shape.Area")
+		assertNoError(err, t, "BreakInterfaceMethod()")
+
+		var fns []string
+		for _, bp := range bps {
+			fns = append(fns, bp.FunctionName)
+		}
+		sort.Strings(fns)
+		expected := []string{"main.(*Circle).Area", "main.Square.Area"}
+		if !reflect.DeepEqual(fns, expected) {
+			t.Fatalf("Expected breakpoints on %v got %v", expected, fns)
+		}
+
+		if _, err := p.BreakInterfaceMethod("main.Shape.Perimeter"); err == nil {
+			t.Fatal("Expected an error for a method not in the interface")
+		}
+		if _, err := p.BreakInterfaceMethod("main.Square.Area"); err == nil {
+			t.Fatal("Expected an error for a type which is not an interface")
+		}
+	})
+}
+
+func TestDetachKill(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+
+		assertNoError(p.Detach(true), t, "Detach()")
+		if !p.Exited() {
+			t.Fatal("Process not reported as exited after being killed")
+		}
+		if len(p.BreakPoints) != 0 || p.HWBreakPoints[0] != nil {
+			t.Fatal("Breakpoints not removed")
+		}
+		if err := p.Process.Signal(syscall.Signal(0)); err == nil {
+			t.Fatal("Process still running")
+		}
+	})
+}
+
+func TestKill(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		assertNoError(p.Kill(), t, "Kill()")
+		if !p.Exited() {
+			t.Fatal("Process not reported as exited after being killed")
+		}
+
+		for _, err := range []error{p.Continue(), getRegistersError(p)} {
+			pe, ok := err.(ProcessExitedError)
+			if !ok || pe.Signal != syscall.SIGKILL {
+				t.Fatalf("Expected the process to be reported as killed, got %v", err)
+			}
+		}
+		assertNoError(p.Kill(), t, "Kill()")
+		if p.ExitStatus() != -1 {
+			t.Fatalf("Expected exit status -1 for a killed process, got %d", p.ExitStatus())
+		}
+	})
+}
+
+func TestDiagnostics(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		d := p.Diagnostics()
+		if len(d.Errors) > 0 {
+			t.Fatalf("Unexpected errors: %v", d.Errors)
+		}
+		if d.Pid != p.Pid || d.Threads == 0 || d.Exited {
+			t.Fatalf("Unexpected process pid: %d threads: %d exited: %t", d.Pid, d.Threads, d.Exited)
+		}
+		if d.Backend == nil || d.Backend.Name != "native" {
+			t.Fatalf("Unexpected backend %v", d.Backend)
+		}
+
+		// The fixture was built by the toolchain running the tests.
+		b := d.Binary
+		if b.Format != "elf" || b.Arch == "" || b.GoVersion != runtime.Version() {
+			t.Fatalf("Unexpected binary format: %s arch: %s go version: %s", b.Format, b.Arch, b.GoVersion)
+		}
+		if b.BuildID == "" {
+			t.Fatal("Expected a build id")
+		}
+		var text bool
+		for _, s := range b.Sections {
+			if s.Name == ".text" && s.Size > 0 {
+				text = true
+			}
+		}
+		if !text {
+			t.Fatalf("Expected a .text section in %v", b.Sections)
+		}
+		if report := d.String(); !strings.Contains(report, "Binary: "+b.Path) || !strings.Contains(report, "Backend: native") {
+			t.Fatalf("Unexpected report:\n%s", report)
+		}
+	})
+
+	// Launching may fail before there is a process, the
+	// report then explains what could not be read.
+	d := DiagnoseBinary("../_fixtures/nonexistent")
+	if d.Backend != nil || len(d.Errors) != 1 || !strings.HasPrefix(d.Errors[0], "could not read binary") {
+		t.Fatalf("Unexpected diagnostics %#v", d)
+	}
+}
+
+func TestKilledWhileStopped(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// Killed behind the debugger's back.
+		assertNoError(syscall.Kill(p.Pid, syscall.SIGKILL), t, "Kill()")
+		for i := 0; i < 100 && !gone(p.Pid); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		_, err = p.ReadMemory(uintptr(bp.Addr), 1)
+		_, pcErr := p.CurrentPC()
+		for _, err := range []error{err, pcErr, p.Continue()} {
+			pe, ok := err.(ProcessExitedError)
+			if !ok || pe.Signal != syscall.SIGKILL {
+				t.Fatalf("Expected the process to be reported as killed, got %v", err)
+			}
+		}
+		if !p.Exited() {
+			t.Fatal("Process not reported as exited after being killed")
+		}
+	})
+}
+
+func TestExitStatus(t *testing.T) {
+	withTestProcess("../_fixtures/continuetestprog", t, func(p *DebuggedProcess) {
+		if p.ExitStatus() != -1 {
+			t.Fatalf("Expected exit status -1 before exiting, got %d", p.ExitStatus())
+		}
+		pe, ok := p.Continue().(ProcessExitedError)
+		if !ok {
+			t.Fatal("Expected the process to exit")
+		}
+		if !p.Exited() || p.ExitStatus() != pe.Status || pe.Status != 0 {
+			t.Fatalf("Expected exited with status 0, got %t, %d", p.Exited(), p.ExitStatus())
+		}
+
+		_, pcErr := p.CurrentPC()
+		_, evalErr := p.EvalSymbol("main.sayhi")
+		for _, err := range []error{p.Continue(), p.Next(), p.Step(), p.Halt(), p.SwitchThread(p.Pid), getRegistersError(p), pcErr, evalErr} {
+			if err != pe {
+				t.Fatalf("Expected %v, got %v", pe, err)
+			}
+		}
+	})
+}
+
+func getRegistersError(p *DebuggedProcess) error {
+	_, err := p.Registers()
+	return err
+}
+
+func TestRestart(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		bp, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		tp, err := p.TraceByLocation("main.sleepytime", "a")
+		assertNoError(err, t, "TraceByLocation()")
+		_, err = p.DisableBreakpoint(tp.ID)
+		assertNoError(err, t, "DisableBreakpoint()")
+
+		pid := p.Pid
+		assertNoError(p.Restart(), t, "Restart()")
+		if p.Pid == pid || p.Exited() {
+			t.Fatalf("Process not restarted, pid %d", p.Pid)
+		}
+
+		var restored []*BreakPoint
+		for _, bp := range p.HWBreakPoints {
+			if bp != nil {
+				restored = append(restored, bp)
+			}
+		}
+		for _, bp := range p.BreakPoints {
+			restored = append(restored, bp)
+		}
+		if len(restored) != 2 {
+			t.Fatalf("Expected 2 breakpoints got %d", len(restored))
+		}
+		for _, nbp := range restored {
+			switch nbp.ID {
+			case bp.ID:
+				if nbp.Addr != bp.Addr || nbp.Disabled {
+					t.Fatalf("Breakpoint not restored: %s", nbp)
+				}
+			case tp.ID:
+				if !nbp.Tracepoint || !nbp.Disabled || !reflect.DeepEqual(nbp.Variables, tp.Variables) {
+					t.Fatalf("Tracepoint not restored: %s", nbp)
+				}
+			default:
+				t.Fatalf("Unexpected breakpoint %s", nbp)
+			}
+		}
+
+		_, err = p.Break(p.GoSymTable.LookupFunc("main.main").Entry)
+		assertNoError(err, t, "Break()")
+
+		// The deferred kill of withTestProcess is bound to the old process.
+		assertNoError(p.Kill(), t, "Kill()")
+	})
+}
+
+func TestRebuildAndRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rebuild")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile("../_fixtures/continuetestprog.go")
+	assertNoError(err, t, "ReadFile()")
+	file, exe := filepath.Join(dir, "continuetestprog.go"), filepath.Join(dir, "continuetestprog")
+	assertNoError(ioutil.WriteFile(file, src, 0644), t, "WriteFile()")
+	build := []string{"go", "build", "-gcflags=-N -l", "-o", exe, file}
+	if err := exec.Command(build[0], build[1:]...).Run(); err != nil {
+		t.Fatal("Could not compile continuetestprog:", err)
+	}
+
+	p, err := LaunchWithConfig([]string{exe}, LaunchConfig{Build: build})
+	if err != nil {
+		t.Fatal("Launch():", err)
+	}
+	defer func() { p.Kill() }()
+	bp, err := p.BreakByLocation("main.sayhi")
+	assertNoError(err, t, "BreakByLocation()")
+	addr := bp.Addr
+
+	// Breaks the build, which leaves the process alone.
+	assertNoError(ioutil.WriteFile(file, append(src, "func broken() {"...), 0644), t, "WriteFile()")
+	pid := p.Pid
+	if err := p.RebuildAndRestart(); err == nil || p.Pid != pid || p.Exited() {
+		t.Fatalf("Expected the broken build to fail without restarting, got %v", err)
+	}
+
+	// Moves main.sayhi, where the breakpoint is to be found again.
+	src = bytes.Replace(src, []byte("func sayhi() {"), []byte("func padding() {\n\tfmt.Println(\"padding\")\n}\n\nfunc sayhi() {"), 1)
+	src = bytes.Replace(src, []byte("\tsayhi()\n"), []byte("\tpadding()\n\tsayhi()\n"), 1)
+	assertNoError(ioutil.WriteFile(file, src, 0644), t, "WriteFile()")
+	assertNoError(p.RebuildAndRestart(), t, "RebuildAndRestart()")
+	if p.Pid == pid || p.Exited() {
+		t.Fatalf("Process not restarted, pid %d", p.Pid)
+	}
+	fn := p.GoSymTable.LookupFunc("main.sayhi")
+	if p.GoSymTable.LookupFunc("main.padding") == nil || fn == nil || fn.Entry == addr {
+		t.Fatal("Process not rebuilt")
+	}
+	bps := p.Breakpoints()
+	if len(bps) != 1 || bps[0].ID != bp.ID || bps[0].Addr != fn.Entry {
+		t.Fatalf("Expected breakpoint %d at main.sayhi %#x, got %v", bp.ID, fn.Entry, bps)
+	}
+}
+
+func TestPendingBreakpoint(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		missing, err := p.BreakPending("main.nosuchfunc")
+		assertNoError(err, t, "BreakPending()")
+		if !missing.Pending {
+			t.Fatalf("Breakpoint at a missing function not pending: %s", missing)
+		}
+		set, err := p.BreakPending("main.helloworld")
+		assertNoError(err, t, "BreakPending()")
+		if set.Pending || !p.BreakpointExists(set.Addr) {
+			t.Fatalf("Breakpoint at an existing function not set: %s", set)
+		}
+
+		// As if main.sleepytime was missing from the program before.
+		p.breakpointIDCounter++
+		late := &BreakPoint{ID: p.breakpointIDCounter, Pending: true, Location: "main.sleepytime"}
+		p.pending = append(p.pending, late)
+
+		assertNoError(p.Restart(), t, "Restart()")
+		pending := p.PendingBreakpoints()
+		if len(pending) != 1 || pending[0].ID != missing.ID {
+			t.Fatalf("Expected breakpoint %d pending got %v", missing.ID, pending)
+		}
+		addr := p.GoSymTable.LookupFunc("main.sleepytime").Entry
+		var resolved *BreakPoint
+		for _, bp := range p.userBreakpoints() {
+			if bp.Addr == addr {
+				resolved = bp
+			}
+		}
+		if resolved == nil || resolved.ID != late.ID || resolved.Pending {
+			t.Fatalf("Pending breakpoint %d not set after restart: %v", late.ID, resolved)
+		}
+
+		_, err = p.ClearByLocation("main.nosuchfunc")
+		assertNoError(err, t, "ClearByLocation()")
+		if len(p.PendingBreakpoints()) != 0 {
+			t.Fatal("Pending breakpoint not cleared")
+		}
+
+		// The deferred kill of withTestProcess is bound to the old process.
+		assertNoError(p.Kill(), t, "Kill()")
+	})
+}
+
+func TestReadMemory(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.sleepytime")
+		expected, err := dataAtAddr(p.CurrentThread, fn.Entry)
+		assertNoError(err, t, "dataAtAddr()")
+
+		data, err := p.ReadMemory(uintptr(fn.Entry), 1)
+		assertNoError(err, t, "ReadMemory()")
+		if !bytes.Equal(data, expected) {
+			t.Fatalf("Expected %#v got %#v", expected, data)
+		}
+
+		if _, err := p.ReadMemory(uintptr(fn.Entry), 0); err == nil {
+			t.Fatal("Expected an error reading 0 bytes")
+		}
+		if _, err := p.ReadMemory(0, 8); err == nil {
+			t.Fatal("Expected an error reading unmapped memory")
+		}
+	})
+}
+
+func TestSetRegister(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		regs, err := p.CurrentThread.Registers()
+		assertNoError(err, t, "Registers()")
+		assertNoError(regs.SetRegister(p.CurrentThread, "RAX", 0xdeadbeef), t, "SetRegister()")
+
+		regs, err = p.CurrentThread.Registers()
+		assertNoError(err, t, "Registers()")
+		for _, reg := range regs.Slice() {
+			if reg.Name == "Rax" && reg.Value != 0xdeadbeef {
+				t.Fatalf("Expected Rax to be %#x got %#x", uint64(0xdeadbeef), reg.Value)
+			}
+		}
+
+		if err := regs.SetRegister(p.CurrentThread, "nonexistent", 0); err == nil {
+			t.Fatal("Expected an error setting an unknown register")
+		}
+	})
+}
+
+func TestRegistersCachedPerStop(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		before := getRegisters(p, t)
+		kept := p.CurrentThread.regs
+		regs := getRegisters(p, t)
+		if kept == nil || p.CurrentThread.regs != kept {
+			t.Fatal("Expected the registers to be read once per stop")
+		}
+		// Those kept are updated as the registers are set,
+		// those returned before are left as they were.
+		assertNoError(regs.SetRegister(p.CurrentThread, "RAX", 0xdeadbeef), t, "SetRegister()")
+		read, err := registers(p.CurrentThread)
+		assertNoError(err, t, "registers()")
+		if !reflect.DeepEqual(read.Slice(), getRegisters(p, t).Slice()) {
+			t.Fatalf("Expected the registers kept to be those of the thread, got %v and %v", getRegisters(p, t).Slice(), read.Slice())
+		}
+		if reflect.DeepEqual(before.Slice(), read.Slice()) {
+			t.Fatal("Expected the registers returned before setting them not to change")
+		}
+
+		assertNoError(p.Step(), t, "Step()")
+		read, err = registers(p.CurrentThread)
+		assertNoError(err, t, "registers()")
+		if p.CurrentThread.regs == kept || getRegisters(p, t).PC() != read.PC() {
+			t.Fatalf("Expected the registers to be read again after stepping to %#x", read.PC())
+		}
+	})
+}
+
+func TestNext(t *testing.T) {
+	var (
+		err            error
+		executablePath = "../_fixtures/testnextprog"
+	)
+
+	testcases := []struct {
+		begin, end int
+	}{
+		{19, 20},
+		{20, 23},
+		{23, 24},
+		{24, 26},
+		{26, 31},
+		{31, 23},
+		{23, 24},
+		{24, 26},
+		{26, 31},
+		{31, 23},
+		{23, 24},
+		{24, 26},
+		{26, 27},
+		{27, 34},
+		{34, 35},
+		{35, 41},
+		{41, 40},
+		{40, 41},
+	}
+
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, testcases[0].begin)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		f, ln := currentLineNumber(p, t)
+		for _, tc := range testcases {
+			if ln != tc.begin {
+				t.Fatalf("Program not stopped at correct spot expected %d was %s:%d", tc.begin, f, ln)
+			}
+
+			assertNoError(p.Next(), t, "Next() returned an error")
+
+			f, ln = currentLineNumber(p, t)
+			if ln != tc.end {
+				t.Fatalf("Program did not continue to correct next location expected %d was %s:%d", tc.end, f, ln)
+			}
+		}
+
+		p.Clear(pc)
+		if len(p.BreakPoints) != 0 {
+			t.Fatal("Not all breakpoints were cleaned up", len(p.HWBreakPoints))
+		}
+		for _, bp := range p.HWBreakPoints {
+			if bp != nil {
+				t.Fatal("Not all breakpoints were cleaned up", bp.Addr)
+			}
+		}
+	})
+}
+
+func TestNextRecursion(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testrecursion.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testrecursion", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 9)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		// The recursive calls go through the same return
+		// address, Next stays in the outermost one.
+		assertNoError(p.Next(), t, "Next()")
+		f, ln := currentLineNumber(p, t)
+		if ln != 10 {
+			t.Fatalf("Expected to stop at line 10, stopped at %s:%d", f, ln)
+		}
+		v, err := p.EvalSymbol("n")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "5" {
+			t.Fatalf("Expected to stop in the outermost call with n = 5, got n = %s", v.Value)
+		}
+	})
+}
+
+func TestContinueUntil(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testrecursion.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testrecursion", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.fact")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		// The recursive calls reach line 10 first.
+		assertNoError(p.ContinueUntil(fmt.Sprintf("%s:%d", fp, 10)), t, "ContinueUntil()")
+		f, ln := currentLineNumber(p, t)
+		if ln != 10 {
+			t.Fatalf("Expected to stop at line 10, stopped at %s:%d", f, ln)
+		}
+		v, err := p.EvalSymbol("n")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "5" {
+			t.Fatalf("Expected to stop in the outermost call with n = 5, got n = %s", v.Value)
+		}
+		if bps := p.Breakpoints(); len(bps) != 0 {
+			t.Fatalf("Expected the temporary breakpoints to be cleared, got %v", bps)
+		}
+	})
+}
+
+func TestStepInto(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 34)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		assertNoError(p.StepInto(), t, "StepInto()")
+
+		fn := p.GoSymTable.PCToFunc(currentPC(p, t))
+		if fn == nil || fn.Name != "main.helloworld" {
+			t.Fatalf("StepInto() did not follow call into main.helloworld: %#v", fn)
+		}
+	})
+}
+
+func TestStepIntoRecursive(t *testing.T) {
+	withTestProcess("../_fixtures/testrecursion", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.down")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+		v, err := p.EvalSymbol("n")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "3" {
+			t.Fatalf("Expected to stop in down(3), got n = %s", v.Value)
+		}
+
+		// The recursive call is on the same line as the caller.
+		assertNoError(p.StepInto(), t, "StepInto()")
+		fn := p.GoSymTable.PCToFunc(currentPC(p, t))
+		if fn == nil || fn.Name != "main.down" {
+			t.Fatalf("StepInto() did not stop in main.down: %#v", fn)
+		}
+		if pc := currentPC(p, t); pc != fn.Entry {
+			t.Fatalf("StepInto() did not follow the recursive call, stopped at %#x", pc)
+		}
+	})
+}
+
+func TestLocationForms(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		next, err := p.FindLocation(fmt.Sprintf("%s:%d", fp, 14))
+		assertNoError(err, t, "FindLocation()")
+		for loc, addr := range map[string]uint64{
+			"+1":                         next,
+			"+0":                         bp.Addr,
+			fmt.Sprintf("*%#x", next):    next,
+			fmt.Sprintf("*%d", next):     next,
+			strconv.Itoa(bp.ID):          bp.Addr,
+			fmt.Sprintf("%s:%d", fp, 13): bp.Addr,
+		} {
+			found, err := p.FindLocation(loc)
+			assertNoError(err, t, "FindLocation("+loc+")")
+			if found != addr {
+				t.Fatalf("Expected %#x for %s, got %#x", addr, loc, found)
+			}
+		}
+
+		// A bare number is no longer taken for an address.
+		for _, loc := range []string{"-1", "+x", "*main", "99", fmt.Sprintf("%d", next)} {
+			if _, err := p.FindLocation(loc); err == nil {
+				t.Fatalf("Expected an error for %s", loc)
+			}
+		}
+		if _, err := p.BreakPending("-1"); err == nil || len(p.PendingBreakpoints()) != 0 {
+			t.Fatalf("Expected a relative location not to become pending, got %v", err)
+		}
+	})
+}
+
+func TestShortFunctionNames(t *testing.T) {
+	withTestProcess("../_fixtures/testifaces", t, func(p *DebuggedProcess) {
+		for loc, name := range map[string]string{
+			"(*Circle).Area": "main.(*Circle).Area",
+			"Circle.Area":    "main.(*Circle).Area",
+			"Square.Name":    "main.Square.Name",
+			"main.Line.Area": "main.Line.Area",
+			"Println":        "fmt.Println",
+		} {
+			addr, err := p.FindLocation(loc)
+			assertNoError(err, t, "FindLocation("+loc+")")
+			if fn := p.GoSymTable.LookupFunc(name); fn == nil || fn.Entry != addr {
+				t.Fatalf("Expected %s for %s, got %#x", name, loc, addr)
+			}
+		}
+
+		_, err := p.FindLocation("Area")
+		ale, ok := err.(AmbiguousLocationError)
+		if !ok {
+			t.Fatalf("Expected an ambiguous location, got %v", err)
+		}
+		for _, name := range []string{"main.(*Circle).Area", "main.Line.Area", "main.Square.Area"} {
+			i := sort.SearchStrings(ale.Candidates, name)
+			if i == len(ale.Candidates) || ale.Candidates[i] != name {
+				t.Fatalf("Expected %s among the candidates, got %v", name, ale.Candidates)
+			}
+		}
+		if _, err := p.FindLocation("ain.main"); err == nil {
+			t.Fatal("Expected no function for a partial name")
+		}
+	})
+}
+
+func TestCompleteFunction(t *testing.T) {
+	withTestProcess("../_fixtures/testifaces", t, func(p *DebuggedProcess) {
+		for prefix, expected := range map[string][]string{
+			"main.(*Circle).A": {"main.(*Circle).Area"},
+			"Square.":          {"Square.Area", "Square.Name"},
+			"fmt.Println":      {"fmt.Println"},
+		} {
+			if names := p.CompleteFunction(prefix); !reflect.DeepEqual(names, expected) {
+				t.Fatalf("Expected %v for %s, got %v", expected, prefix, names)
+			}
+		}
+		if names := p.CompleteFunction("nosuchfunction"); len(names) != 0 {
+			t.Fatalf("Expected no function, got %v", names)
+		}
+	})
+}
+
+func TestBreakByLocationAllLinePCs(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testnextprog.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		// The header of the loop, entered before and after each iteration.
+		loc := fmt.Sprintf("%s:%d", fp, 23)
+		bp, err := p.BreakByLocation(loc)
+		assertNoError(err, t, "BreakByLocation()")
+		if bp.Group == 0 {
+			t.Fatalf("Expected the breakpoints of the line in a group, got %s", bp)
+		}
+		var addrs []uint64
+		for _, gbp := range p.Breakpoints() {
+			if gbp.Group == bp.Group {
+				addrs = append(addrs, gbp.Addr)
+			}
+		}
+		if len(addrs) < 2 {
+			t.Fatalf("Expected breakpoints at several addresses, got %#x", addrs)
+		}
+
+		assertNoError(p.Continue(), t, "Continue()")
+		first := currentPC(p, t)
+		assertNoError(p.Continue(), t, "Continue()")
+		if _, l := currentLineNumber(p, t); currentPC(p, t) == first || l != 23 {
+			t.Fatalf("Expected to stop at line 23 after the first iteration, stopped at %#x line %d", currentPC(p, t), l)
+		}
+
+		_, err = p.ClearByLocation(loc)
+		assertNoError(err, t, "ClearByLocation()")
+		for _, addr := range addrs {
+			if p.BreakpointExists(addr) {
+				t.Fatalf("Expected the breakpoint at %#x to be cleared", addr)
+			}
+		}
+	})
+}
+
+func TestListSource(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		sl, err := p.ListSource("", 2)
+		assertNoError(err, t, "ListSource()")
+		if sl.Line != 13 || len(sl.Lines) != 5 || sl.Lines[0].Line != 11 {
+			t.Fatalf("Expected lines 11 to 15 around line 13, got:\n%s", sl)
+		}
+		cur := sl.Lines[2]
+		if !cur.Current || len(cur.BreakPoints) != 1 || cur.BreakPoints[0] != bp.ID {
+			t.Fatalf("Expected line 13 to be current with breakpoint %d, got %#v", bp.ID, cur)
+		}
+
+		sl, err = p.ListSource("main.main", 1)
+		assertNoError(err, t, "ListSource()")
+		for _, l := range sl.Lines {
+			if l.Current {
+				t.Fatalf("Expected no current line in main.main, got:\n%s", sl)
+			}
+		}
+	})
+}
+
+func TestPathMap(t *testing.T) {
+	fixtures, err := filepath.Abs("../_fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "pathmap")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+	src, err := ioutil.ReadFile(filepath.Join(fixtures, "testnextprog.go"))
+	assertNoError(err, t, "ReadFile()")
+	// Marks the copy listed.
+	src = bytes.Replace(src, []byte("func helloworld() {"), []byte("func helloworld() { // copy"), 1)
+	assertNoError(ioutil.WriteFile(filepath.Join(dir, "testnextprog.go"), src, 0644), t, "WriteFile()")
+
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation(filepath.Join(fixtures, "testnextprog.go") + ":13")
+		assertNoError(err, t, "FindLocation()")
+
+		assertNoError(p.Setup(Config{PathMap: []PathMapping{{From: fixtures, To: dir}}}), t, "Setup()")
+		if local := p.LocalPath(filepath.Join(fixtures, "testnextprog.go")); local != filepath.Join(dir, "testnextprog.go") {
+			t.Fatalf("Expected the source in %s, got %s", dir, local)
+		}
+		mapped, err := p.FindLocation(filepath.Join(dir, "testnextprog.go") + ":13")
+		assertNoError(err, t, "FindLocation()")
+		if mapped != pc {
+			t.Fatalf("Expected %#x for the mapped location, got %#x", pc, mapped)
+		}
+
+		sl, err := p.ListSource("main.helloworld", 0)
+		assertNoError(err, t, "ListSource()")
+		if len(sl.Lines) != 1 || !strings.HasSuffix(sl.Lines[0].Text, "// copy") {
+			t.Fatalf("Expected the line of main.helloworld from the copy, got:\n%s", sl)
+		}
+	})
+}
+
+func TestSymbolSearch(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		funcs, err := p.Funcs(`^main\.`)
+		assertNoError(err, t, "Funcs()")
+		if i := sort.SearchStrings(funcs, "main.testnext"); i == len(funcs) || funcs[i] != "main.testnext" {
+			t.Fatalf("Expected main.testnext among %v", funcs)
+		}
+		for _, f := range funcs {
+			if !strings.HasPrefix(f, "main.") {
+				t.Fatalf("Unexpected function %s", f)
+			}
+		}
+
+		sources, err := p.Sources(`testnextprog\.go$`)
+		assertNoError(err, t, "Sources()")
+		if len(sources) != 1 || !filepath.IsAbs(sources[0]) {
+			t.Fatalf("Expected the path of testnextprog.go, got %v", sources)
+		}
+
+		types, err := p.Types(`^runtime\.g$`)
+		assertNoError(err, t, "Types()")
+		if !reflect.DeepEqual(types, []string{"runtime.g"}) {
+			t.Fatalf("Expected runtime.g once, got %v", types)
+		}
+
+		if _, err := p.Funcs("("); err == nil {
+			t.Fatal("Expected an error for an invalid regular expression")
+		}
+	})
+}
+
+func TestSymbolIndex(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		idx, err := p.SymbolIndex()
+		assertNoError(err, t, "SymbolIndex()")
+
+		fn := p.GoSymTable.LookupFunc("main.testnext")
+		off, ok := idx.Function("main.testnext")
+		if !ok {
+			t.Fatal("Expected main.testnext in the index")
+		}
+		if at, ok := idx.FunctionAt(fn.Entry + 1); !ok || at != off {
+			t.Fatalf("Expected %#x to be in main.testnext", fn.Entry+1)
+		}
+		if _, ok := idx.UnitAt(fn.Entry); !ok {
+			t.Fatalf("Expected %#x to be in a compile unit", fn.Entry)
+		}
+
+		entry, err := p.DwarfReader().SeekToFunction(fn.Entry)
+		assertNoError(err, t, "SeekToFunction()")
+		if entry.Offset != off {
+			t.Fatalf("Expected to seek to main.testnext, got %v", entry.Val(dwarf.AttrName))
+		}
+
+		if _, ok := idx.Variable("runtime.allglen"); !ok {
+			t.Fatal("Expected runtime.allglen in the index")
+		}
+	})
+}
+
+func TestSeparateDebugInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("separate debug information is only looked up on linux")
+	}
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy not found")
+	}
+	dir, err := ioutil.TempDir("", "delve")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+	assertNoError(os.Mkdir(filepath.Join(dir, ".debug"), 0755), t, "Mkdir()")
+
+	exe := filepath.Join(dir, "testprog")
+	debug := filepath.Join(dir, ".debug", "testprog.debug")
+	for _, args := range [][]string{
+		{"go", "build", "-gcflags=-N -l", "-o", exe, "../_fixtures/testprog.go"},
+		{"objcopy", "--only-keep-debug", exe, debug},
+		{"objcopy", "--strip-debug", "--add-gnu-debuglink=" + debug, exe},
+	} {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %s\n%s", args[0], err, out)
+		}
+	}
+
+	p, err := Launch([]string{exe})
+	assertNoError(err, t, "Launch()")
+	defer p.Process.Kill()
+
+	fn := p.GoSymTable.LookupFunc("main.helloworld")
+	entry, err := p.DwarfReader().SeekToFunction(fn.Entry)
+	assertNoError(err, t, "SeekToFunction()")
+	if name := entry.Val(dwarf.AttrName); name != "main.helloworld" {
+		t.Fatalf("Expected main.helloworld, got %v", name)
+	}
+	if _, err := p.FrameEntries.FDEForPC(fn.Entry); err != nil {
+		t.Fatal("Expected frame information of main.helloworld:", err)
+	}
+
+	_, err = p.Break(fn.Entry)
+	assertNoError(err, t, "Break()")
+	assertNoError(p.Continue(), t, "Continue()")
+	pc, err := p.CurrentPC()
+	assertNoError(err, t, "CurrentPC()")
+	if pc != fn.Entry && pc-1 != fn.Entry {
+		t.Fatalf("Expected to stop at main.helloworld, got %#x", pc)
+	}
+}
+
+func TestMissingFrameInfo(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("sections are removed with objcopy, which handles ELF only")
+	}
+	if _, err := exec.LookPath("objcopy"); err != nil {
+		t.Skip("objcopy not found")
+	}
+	dir, err := ioutil.TempDir("", "delve")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	exe := filepath.Join(dir, "testprog")
+	for _, args := range [][]string{
+		{"go", "build", "-gcflags=-N -l", "-o", exe, "../_fixtures/testprog.go"},
+		{"objcopy", "--remove-section=.debug_frame", "--remove-section=.eh_frame", exe},
+	} {
+		if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+			t.Fatalf("%s failed: %s\n%s", args[0], err, out)
+		}
+	}
+
+	p, err := Launch([]string{exe})
+	assertNoError(err, t, "Launch()")
+	defer p.Process.Kill()
+	if len(p.FrameEntries) != 0 {
+		t.Fatalf("Expected no frame information, got %d entries", len(p.FrameEntries))
+	}
+
+	pc, err := p.FindLocation("main.helloworld")
+	assertNoError(err, t, "FindLocation()")
+	_, err = p.Break(pc)
+	assertNoError(err, t, "Break()")
+	assertNoError(p.Continue(), t, "Continue()")
+	assertNoError(p.Next(), t, "Next()")
+	if fn := p.GoSymTable.PCToFunc(currentPC(p, t)); fn == nil || fn.Name != "main.helloworld" {
+		t.Fatalf("Expected Next to step within main.helloworld, got %v", fn)
+	}
+}
+
+func TestHardcodedBreakpoints(t *testing.T) {
+	withTestProcess("../_fixtures/testhardcoded", t, func(p *DebuggedProcess) {
+		// Stepped out of runtime.Breakpoint, back in the code
+		// calling it, at the line following each call.
+		for _, line := range []int{10, 12} {
+			assertNoError(p.Continue(), t, "Continue()")
+			if p.StopReason() != StopHardcodedBreakpoint {
+				t.Fatalf("Expected to stop at a hardcoded breakpoint, got %s", p.StopReason())
+			}
+			f, ln := currentLineNumber(p, t)
+			if fn := p.GoSymTable.PCToFunc(currentPC(p, t)); fn == nil || fn.Name != "main.main" || ln != line {
+				t.Fatalf("Expected to stop in main.main at line %d, stopped at %s:%d", line, f, ln)
+			}
+		}
+	})
+
+	withTestProcess("../_fixtures/testhardcoded", t, func(p *DebuggedProcess) {
+		p.ContinueHardcodedBreakpoints = true
+		err := p.Continue()
+		if _, ok := err.(ProcessExitedError); !ok {
+			t.Fatalf("Expected to run past the hardcoded breakpoints until the process exits, got %v", err)
+		}
+	})
+}
+
+func TestCgoStacktrace(t *testing.T) {
+	if _, err := exec.LookPath("gcc"); err != nil {
+		t.Skip("gcc not found")
+	}
+	withTestProcess("../_fixtures/testcgo", t, func(p *DebuggedProcess) {
+		assertNoError(p.Continue(), t, "Continue()")
+		if p.StopReason() != StopHardcodedBreakpoint {
+			t.Fatalf("Expected to stop at the trap in C code, got %s", p.StopReason())
+		}
+
+		// Through the C frames and back from the system stack to
+		// the goroutine stack.
+		regs := getRegisters(p, t)
+		frames, err := p.stacktrace(regs.PC(), regs.SP(), regs, 50)
+		assertNoError(err, t, "stacktrace()")
+		var names []string
+		for _, f := range frames {
+			if f.fn != nil {
+				names = append(names, f.fn.Name)
+			}
+		}
+		if !strings.Contains(strings.Join(names, " "), "runtime.cgocall main._Cfunc_cfunc main.callC main.main") {
+			t.Fatalf("Expected the stack to reach main.main from C code, got %v", names)
+		}
+	})
+}
+
+func TestStackSwitchStacktrace(t *testing.T) {
+	stack := func(p *DebuggedProcess) string {
+		regs := getRegisters(p, t)
+		frames, err := p.stacktrace(regs.PC(), regs.SP(), regs, 200)
+		assertNoError(err, t, "stacktrace()")
+		var names []string
+		for _, f := range frames {
+			if f.fn != nil {
+				names = append(names, f.fn.Name)
+			}
+		}
+		return strings.Join(names, " ")
+	}
+
+	withTestProcess("../_fixtures/teststackswitch", t, func(p *DebuggedProcess) {
+		// ReadMemStats reads them on the system stack.
+		_, err := p.BreakByLocation("runtime.readmemstats_m")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		names := stack(p)
+		if !strings.Contains(names, "runtime.systemstack runtime.systemstack_switch runtime.ReadMemStats main.main") || !strings.HasSuffix(names, "runtime.goexit") {
+			t.Fatalf("Expected the stack to reach main.main from the system stack, got %s", names)
+		}
+		_, err = p.Clear(p.GoSymTable.LookupFunc("runtime.readmemstats_m").Entry)
+		assertNoError(err, t, "Clear()")
+
+		// grow recurses deeper than the stack main starts with.
+		_, err = p.BreakByLocation("runtime.newstack")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		names = stack(p)
+		if !strings.Contains(names, "runtime.newstack runtime.morestack main.grow main.grow") || !strings.HasSuffix(names, "runtime.goexit") {
+			t.Fatalf("Expected the stack to reach main.main from newstack, got %s", names)
+		}
+	})
+}
+
+func TestCall(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("function calls are only supported on amd64")
+	}
+	fp, err := filepath.Abs("../_fixtures/fncall.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTestProcess("../_fixtures/fncall", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 27)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		before := getRegisters(p, t)
+
+		results, err := p.Call("add", "x", "2")
+		assertNoError(err, t, "Call(add)")
+		if len(results) != 1 || results[0].Value != "42" {
+			t.Fatalf("Expected add(x, 2) to return 42, got %v", results)
+		}
+
+		results, err = p.Call("main.greet", "name")
+		assertNoError(err, t, "Call(greet)")
+		if len(results) != 1 || results[0].Value != "hello world" {
+			t.Fatalf("Expected greet(name) to return hello world, got %v", results)
+		}
+
+		results, err = p.Call("divmod", "7", "2")
+		assertNoError(err, t, "Call(divmod)")
+		if len(results) != 2 || results[0].Value != "3" || results[1].Value != "1" {
+			t.Fatalf("Expected divmod(7, 2) to return 3, 1, got %v", results)
+		}
+
+		_, err = p.Call("boom", "name")
+		if err == nil || !strings.Contains(err.Error(), "panicked") {
+			t.Fatalf("Expected boom(name) to panic, got %v", err)
+		}
+
+		if _, err = p.Call("add", "1"); err == nil {
+			t.Fatal("Expected an error calling add with one argument")
+		}
+
+		after := getRegisters(p, t)
+		if before.PC() != after.PC() || before.SP() != after.SP() {
+			t.Fatalf("Expected the registers to be restored, PC %#x SP %#x instead of PC %#x SP %#x", after.PC(), after.SP(), before.PC(), before.SP())
+		}
+		v, err := p.EvalSymbol("x")
+		assertNoError(err, t, "EvalSymbol(x)")
+		if v.Value != "40" {
+			t.Fatalf("Expected x to be 40, got %s", v.Value)
+		}
+	})
+}
+
+func TestCheckpoint(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checkpoints are only supported on linux")
+	}
+	fp, err := filepath.Abs("../_fixtures/fncall.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withTestProcess("../_fixtures/fncall", t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 27)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		cp, err := p.Checkpoint()
+		assertNoError(err, t, "Checkpoint()")
+		if cp.Line != 27 || cp.PC != pc {
+			t.Fatalf("Expected the checkpoint at line 27 %#x, got %s %#x", pc, cp, cp.PC)
+		}
+		assertNoError(p.SetSymbol("x", "7"), t, "SetSymbol()")
+		if _, ok := p.Continue().(ProcessExitedError); !ok {
+			t.Fatal("Expected the process to exit")
+		}
+
+		// Restoring twice gets the same state back each time.
+		for i := 0; i < 2; i++ {
+			assertNoError(p.Restore(cp.ID), t, "Restore()")
+			if p.Exited() {
+				t.Fatal("Expected the restored process not to have exited")
+			}
+			if f, l := currentLineNumber(p, t); f != fp || l != 27 {
+				t.Fatalf("Expected to be restored at line 27, got %s:%d", f, l)
+			}
+			v, err := p.EvalSymbol("x")
+			assertNoError(err, t, "EvalSymbol(x)")
+			if v.Value != "40" {
+				t.Fatalf("Expected x to be 40, got %s", v.Value)
+			}
+			assertNoError(p.SetSymbol("x", "7"), t, "SetSymbol()")
+		}
+
+		pe, ok := p.Continue().(ProcessExitedError)
+		if !ok || pe.Status != 0 {
+			t.Fatalf("Expected the restored process to exit, got %v", pe)
+		}
+		assertNoError(p.ClearCheckpoint(cp.ID), t, "ClearCheckpoint()")
+		if len(p.Checkpoints()) != 0 {
+			t.Fatalf("Expected no checkpoints, got %v", p.Checkpoints())
+		}
+	})
+}
+
+func TestRestoreWhileRunning(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("checkpoints are only supported on linux")
+	}
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		cp, err := p.Checkpoint()
+		assertNoError(err, t, "Checkpoint()")
+		defer p.ClearCheckpoint(cp.ID)
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		// Restore stops Continue before swapping the process.
+		errs := make(chan error, 1)
+		go func() { errs <- p.Continue() }()
+		for !p.Running() {
+			time.Sleep(time.Millisecond)
+		}
+		assertNoError(p.Restore(cp.ID), t, "Restore()")
+		// The copy outlives the process withTestProcess kills.
+		defer p.Kill()
+		assertNoError(<-errs, t, "Continue()")
+		if p.Exited() || p.Running() {
+			t.Fatal("Expected the restored process to be stopped")
+		}
+		if pc := currentPC(p, t); pc != cp.PC {
+			t.Fatalf("Expected to be restored at %#x, got %#x", cp.PC, pc)
+		}
+		if len(p.Threads) != 1 || p.CurrentThread.Id != p.Pid {
+			t.Fatalf("Expected the single thread of the copy, got %d threads", len(p.Threads))
+		}
+	})
+}
+
+func TestPositionIndependentExecutable(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("load bias is only read on linux")
+	}
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-buildmode=pie", "-o", "testvariables", "../_fixtures/testvariables.go").Run(); err != nil {
+		t.Skipf("Could not compile testvariables as a PIE: %s", err)
+	}
+	defer os.Remove("./testvariables")
+
+	p, err := Launch([]string{"./testvariables"})
+	assertNoError(err, t, "Launch()")
+	defer p.Process.Kill()
+	if p.staticBase == 0 {
+		t.Fatal("Expected a load bias")
+	}
+
+	fp, _ := filepath.Abs("../_fixtures/testvariables.go")
+	pc, _, err := p.GoSymTable.LineToPC(fp, 57)
+	assertNoError(err, t, "LineToPC()")
+	_, err = p.Break(pc)
+	assertNoError(err, t, "Break()")
+	assertNoError(p.Continue(), t, "Continue()")
+
+	for name, value := range map[string]string{"a1": "foofoofoofoofoofoo", "a2": "6", "f": "main.barfoo"} {
+		v, err := p.EvalSymbol(name)
+		assertNoError(err, t, fmt.Sprintf("EvalSymbol(%s)", name))
+		if v.Value != value {
+			t.Fatalf("Expected %s to be %s, got %s", name, value, v.Value)
+		}
+	}
+	_, err = p.Goroutines()
+	assertNoError(err, t, "Goroutines()")
+}
+
+func TestStepOut(t *testing.T) {
+	withTestProcess("../_fixtures/testnextprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// The line of the return address, after the call, depends on
+		// how the compiler attributes the instructions following it.
+		frames, err := p.CurrentThread.Stacktrace(2)
+		assertNoError(err, t, "Stacktrace()")
+		ret := frames[1]
+		if ret.Function != "main.testnext" {
+			t.Fatalf("Expected helloworld to be called by main.testnext, got %s", ret.Function)
+		}
+
+		assertNoError(p.StepOut(), t, "StepOut()")
+
+		f, l := currentLineNumber(p, t)
+		if pc := currentPC(p, t); pc != ret.PC || l != ret.Line {
+			t.Fatalf("StepOut() did not return to caller at %s:%d (%#x), stopped at %s:%d (%#x)", ret.File, ret.Line, ret.PC, f, l, pc)
+		}
+
+		count := len(p.BreakPoints)
+		for _, bp := range p.HWBreakPoints {
+			if bp != nil {
+				count++
+			}
+		}
+		if count != 1 {
+			t.Fatal("Temporary breakpoint was not cleaned up")
+		}
+	})
+}
+
+func TestStepOutRecursive(t *testing.T) {
+	withTestProcess("../_fixtures/testrecursion", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.fact")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.ContinueN(3), t, "ContinueN()")
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+
+		// The recursive calls fact(3) makes return to the same
+		// address first.
+		assertNoError(p.StepOut(), t, "StepOut()")
+		v, err := p.EvalSymbol("n")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "4" {
+			t.Fatalf("Expected to return to the caller with n = 4, got n = %s", v.Value)
+		}
+		if bps := p.Breakpoints(); len(bps) != 0 {
+			t.Fatalf("Expected the temporary breakpoint to be cleared, got %v", bps)
+		}
+	})
+}
+
+func TestFindReturnAddress(t *testing.T) {
+	var testfile, _ = filepath.Abs("../_fixtures/testnextprog")
+
+	withTestProcess(testfile, t, func(p *DebuggedProcess) {
+		var (
+			fdes = p.FrameEntries
+			gsd  = p.GoSymTable
+		)
+
+		testsourcefile := testfile + ".go"
+		start, _, err := gsd.LineToPC(testsourcefile, 24)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = p.Break(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Continue()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		regs, err := p.Registers()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fde, err := fdes.FDEForPC(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ret := fde.ReturnAddressOffset(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := uint64(int64(regs.SP()) + ret)
+		data := make([]byte, 8)
+
+		readMemory(p.CurrentThread, uintptr(addr), data)
+		addr = binary.LittleEndian.Uint64(data)
+
+		linuxExpected := uint64(0x400fbc)
+		darwinExpected := uint64(0x23bc)
+		if addr != linuxExpected && addr != darwinExpected {
+			t.Fatalf("return address not found correctly, expected (linux) %#v or (darwin) %#v got %#v", linuxExpected, darwinExpected, addr)
+		}
+	})
+}
+
+func TestFunctionExtents(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		ext, err := p.FunctionExtents("main.helloworld")
+		assertNoError(err, t, "FunctionExtents()")
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		if ext.Entry != fn.Entry || ext.End != fn.End {
+			t.Fatalf("Expected extents %#x-%#x got %#x-%#x", fn.Entry, fn.End, ext.Entry, ext.End)
+		}
+		if len(ext.Lines) == 0 || ext.Lines[0].PC != ext.Entry || ext.Lines[0].Line != 13 {
+			t.Fatalf("Unexpected line table rows %v", ext.Lines)
+		}
+
+		syms, err := OpenSymbols("./testprog")
+		assertNoError(err, t, "OpenSymbols()")
+		fext, err := syms.FunctionExtents("main.helloworld")
+		assertNoError(err, t, "FunctionExtents()")
+		if !reflect.DeepEqual(ext, fext) {
+			t.Fatalf("Expected %v got %v", ext, fext)
+		}
+
+		if _, err := p.FunctionExtents("main.nonexistent"); err == nil {
+			t.Fatal("Expected an error for an unknown function")
+		}
+	})
+}
+
+func TestTaskAccessError(t *testing.T) {
+	if err := newTaskAccessError(1, kernProtectionFailure); !err.LaunchOnly || err.Remediation == "" {
+		t.Fatalf("Expected a launch only error with a remediation, got %#v", err)
+	}
+	if err := newTaskAccessError(1, kernFailure); err.LaunchOnly || !strings.Contains(err.Error(), "codesigned") {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if err := newTaskAccessError(1, 42); !strings.Contains(err.Error(), "42") {
+		t.Fatalf("Expected the code in %s", err)
+	}
+}
+
+func TestWatchRange(t *testing.T) {
+	testcases := []struct {
+		addr, size uint64
+		start      uint64
+		n          int
+	}{
+		{0x1000, 1, 0x1000, 1},
+		{0x1000, 8, 0x1000, 8},
+		{0x1004, 4, 0x1004, 4},
+		{0x1002, 4, 0x1000, 8},
+		{0x1000, 3, 0x1000, 4},
+		{0x1000, 24, 0x1010, 8},
+	}
+
+	for _, tc := range testcases {
+		start, n := watchRange(tc.addr, tc.size)
+		if start != tc.start || n != tc.n {
+			t.Fatalf("watchRange(%#x, %d): expected %#x+%d got %#x+%d", tc.addr, tc.size, tc.start, tc.n, start, n)
+		}
+	}
+}
+
+// Builds the fixture name, runs it until it crashes and calls fn
+// with the core file it dumps. The test is skipped if core dumps
+// are disabled.
+func withCoreFile(name string, t *testing.T, fn func(p *DebuggedProcess, pid int)) {
+	if runtime.GOOS != "linux" {
+		t.Skip("core files are only supported on linux")
+	}
+	var rlim syscall.Rlimit
+	assertNoError(syscall.Getrlimit(syscall.RLIMIT_CORE, &rlim), t, "Getrlimit()")
+	defer syscall.Setrlimit(syscall.RLIMIT_CORE, &rlim)
+	if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: rlim.Max, Max: rlim.Max}); err != nil || rlim.Max == 0 {
+		t.Skip("core dumps are disabled")
+	}
+
+	// Describe arguments by their stack slot rather than location lists.
+	base := filepath.Base(name)
+	if err := exec.Command("go", "build", "-gcflags=-N -l -dwarflocationlists=false", "-o", base, name+".go").Run(); err != nil {
+		t.Fatalf("Could not compile %s due to %s", name, err)
+	}
+	defer os.Remove("./" + base)
+
+	cmd := exec.Command("./" + base)
+	cmd.Env = append(os.Environ(), "GOTRACEBACK=crash")
+	cmd.Run()
+	corePath := "core"
+	if _, err := os.Stat(corePath); err != nil {
+		corePath = fmt.Sprintf("core.%d", cmd.Process.Pid)
+	}
+	if _, err := os.Stat(corePath); err != nil {
+		t.Skip("no core file was written")
+	}
+	defer os.Remove(corePath)
+
+	p, err := OpenCore(corePath, "./"+base)
+	assertNoError(err, t, "OpenCore()")
+	defer p.Detach(false)
+
+	fn(p, cmd.Process.Pid)
+}
+
+func TestCore(t *testing.T) {
+	withCoreFile("../_fixtures/testcore", t, func(p *DebuggedProcess, pid int) {
+		if p.Pid != pid {
+			t.Fatalf("Expected pid %d got %d", pid, p.Pid)
+		}
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if fn := p.GoSymTable.PCToFunc(pc); fn == nil {
+			t.Fatalf("PC %#x is not in a function", pc)
+		}
+		v, err := p.EvalSymbol("main.message")
+		assertNoError(err, t, "EvalSymbol()")
+		if v.Value != "post-mortem" {
+			t.Fatalf("Expected post-mortem got %s", v.Value)
+		}
+
+		if err := p.Continue(); err == nil {
+			t.Fatal("Expected an error resuming a core file")
+		}
+		if _, err := p.Break(pc); err == nil {
+			t.Fatal("Expected an error setting a breakpoint in a core file")
+		}
+	})
+}
+
+func TestGoroutineContexts(t *testing.T) {
+	withCoreFile("../_fixtures/testcontext", t, func(p *DebuggedProcess, pid int) {
+		goroutines, err := p.Goroutines()
+		assertNoError(err, t, "Goroutines()")
+		var args []*ContextArg
+		for _, g := range goroutines {
+			gargs, err := p.GoroutineContexts(g.Id)
+			assertNoError(err, t, "GoroutineContexts()")
+			args = append(args, gargs...)
+		}
+		if len(args) != 1 || args[0].Function != "main.handle" || args[0].Name != "ctx" {
+			t.Fatalf("Expected the ctx argument of main.handle, got %v", args)
+		}
+
+		var chain []string
+		for _, link := range args[0].Chain {
+			chain = append(chain, link.String())
+		}
+		expected := []string{
+			"*context.valueCtx (main.key) 1 = (int) 42",
+			"*context.timerCtx",
+			"*context.valueCtx (main.key) 0 = (struct string) gopher",
+			"context.backgroundCtx",
+		}
+		if !reflect.DeepEqual(chain, expected) {
+			t.Fatalf("Expected chain %q got %q", expected, chain)
+		}
+	})
+}
+
+func TestSetup(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		assertNoError(p.Setup(Config{Breakpoints: []string{"main.helloworld", "main.sleepytime"}}), t, "Setup()")
+		for _, loc := range []string{"main.helloworld", "main.sleepytime"} {
+			addr, err := p.FindLocation(loc)
+			assertNoError(err, t, "FindLocation()")
+			if !p.BreakpointExists(addr) {
+				t.Fatalf("Expected a breakpoint at %s", loc)
+			}
+		}
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if p.BreakpointExists(pc - 1) {
+			t.Fatal("Expected the process to stay stopped where it was")
+		}
+
+		if err := p.Setup(Config{Breakpoints: []string{"main.nonexistent"}, Resume: true}); err == nil {
+			t.Fatal("Expected an error for an unknown location")
+		}
+		assertNoError(p.Setup(Config{PendingBreakpoints: []string{"main.nonexistent"}}), t, "Setup()")
+		if pending := p.PendingBreakpoints(); len(pending) != 1 || pending[0].Location != "main.nonexistent" {
+			t.Fatalf("Expected main.nonexistent to be pending, got %v", pending)
+		}
+	})
+}
+
+func TestDump(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("core files are only supported on linux")
+	}
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		core := filepath.Join(os.TempDir(), "testprog.core")
+		assertNoError(p.Dump(core), t, "Dump()")
+		defer os.Remove(core)
+
+		c, err := OpenCore(core, "./testprog")
+		assertNoError(err, t, "OpenCore()")
+		defer c.Detach(false)
+
+		if c.Pid != p.Pid || len(c.Threads) != len(p.Threads) || c.CurrentThread.Id != p.CurrentThread.Id {
+			t.Fatalf("Expected pid %d with %d threads, got pid %d with %d threads", p.Pid, len(p.Threads), c.Pid, len(c.Threads))
+		}
+		regs := getRegisters(p, t)
+		cregs, err := c.Registers()
+		assertNoError(err, t, "Registers()")
+		if !reflect.DeepEqual(regs.Slice(), cregs.Slice()) {
+			t.Fatalf("Expected registers %v got %v", regs.Slice(), cregs.Slice())
+		}
+
+		data, err := p.ReadMemory(uintptr(regs.SP()), 64)
+		assertNoError(err, t, "ReadMemory()")
+		cdata, err := c.ReadMemory(uintptr(regs.SP()), 64)
+		assertNoError(err, t, "ReadMemory()")
+		if !bytes.Equal(data, cdata) {
+			t.Fatalf("Expected stack %x got %x", data, cdata)
+		}
+	})
+}
+
+func TestMemoryMaps(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		regions, err := p.MemoryMaps()
+		assertNoError(err, t, "MemoryMaps()")
+
+		find := func(addr uint64) *MemoryRegion {
+			for i := range regions {
+				if regions[i].Contains(addr, 1) {
+					return &regions[i]
+				}
+			}
+			t.Fatalf("No region holds %#x in %v", addr, regions)
+			return nil
+		}
+		for i := 1; i < len(regions); i++ {
+			if regions[i].Start < regions[i-1].End {
+				t.Fatalf("Expected regions by address, got %s after %s", regions[i], regions[i-1])
+			}
+		}
+		text := find(p.GoSymTable.LookupFunc("main.main").Entry)
+		if text.Perm&(MemoryRead|MemoryExec) != MemoryRead|MemoryExec || text.Perm&MemoryWrite != 0 || filepath.Base(text.Path) != "testprog" {
+			t.Fatalf("Expected main.main in the read only code of testprog, got %s", text)
+		}
+		regs := getRegisters(p, t)
+		if stack := find(regs.SP()); stack.Perm&MemoryWrite == 0 {
+			t.Fatalf("Expected a writable stack, got %s", stack)
+		}
+
+		_, err = p.ReadMemory(0, 8)
+		if err == nil || !strings.Contains(err.Error(), "not mapped") {
+			t.Fatalf("Expected reading address 0 to fail as unmapped, got %v", err)
+		}
+	})
+}
+
+func TestParseMemoryMaps(t *testing.T) {
+	maps := "00400000-00452000 r-xp 00000000 08:02 173521      /usr/bin/dbus-daemon\n" +
+		"00e03000-00e24000 rw-p 00000000 00:00 0           [heap]\n" +
+		"7f2c4c5c3000-7f2c4c5c4000 rw-s 00002000 08:02 135522 /tmp/a file (deleted)\n"
+	regions, err := parseMemoryMaps(strings.NewReader(maps))
+	assertNoError(err, t, "parseMemoryMaps()")
+	expected := []MemoryRegion{
+		{Start: 0x400000, End: 0x452000, Perm: MemoryRead | MemoryExec, Path: "/usr/bin/dbus-daemon"},
+		{Start: 0xe03000, End: 0xe24000, Perm: MemoryRead | MemoryWrite, Path: "[heap]"},
+		{Start: 0x7f2c4c5c3000, End: 0x7f2c4c5c4000, Perm: MemoryRead | MemoryWrite | MemoryShared, Offset: 0x2000, Path: "/tmp/a file (deleted)"},
+	}
+	if !reflect.DeepEqual(regions, expected) {
+		t.Fatalf("Expected %v got %v", expected, regions)
+	}
+	if s := regions[0].Perm.String(); s != "r-xp" {
+		t.Fatalf("Expected r-xp got %s", s)
+	}
+
+	if _, err := parseMemoryMaps(strings.NewReader("00400000 r-xp\n")); err == nil {
+		t.Fatal("Expected an error for a malformed mapping")
+	}
+}
+
+func TestExportImportBreakpoints(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.sleepytime")
+		assertNoError(err, t, "BreakByLocation()")
+		_, err = p.DisableBreakpoint(bp.ID)
+		assertNoError(err, t, "DisableBreakpoint()")
+		tp, err := p.TraceByLocation("main.helloworld", "a")
+		assertNoError(err, t, "TraceByLocation()")
+
+		var buf bytes.Buffer
+		assertNoError(p.ExportBreakpoints(&buf), t, "ExportBreakpoints()")
+		for _, addr := range []uint64{bp.Addr, tp.Addr} {
+			_, err := p.Clear(addr)
+			assertNoError(err, t, "Clear()")
+		}
+
+		// Pretend the source tree lives elsewhere and add
+		// locations which can't be found as they are.
+		doc := strings.Replace(buf.String(), filepath.Dir(bp.File), "/elsewhere/src/testprog", -1)
+		doc = strings.Replace(doc, `"breakpoints": [`, `"breakpoints": [
+		{"file": "/elsewhere/src/testprog/testprog.go", "line": 1, "function": "main.main"},
+		{"file": "/elsewhere/src/missing.go", "line": 3},`, 1)
+
+		results, err := p.ImportBreakpoints(strings.NewReader(doc))
+		assertNoError(err, t, "ImportBreakpoints()")
+		if len(results) != 4 {
+			t.Fatalf("Expected 4 results got %v", results)
+		}
+		if results[0].BreakPoint == nil || results[0].BreakPoint.FunctionName != "main.main" || !strings.Contains(results[0].Message, "entry of main.main") {
+			t.Fatalf("Expected a fallback to the entry of main.main, got %s", results[0])
+		}
+		if results[1].BreakPoint != nil || !strings.Contains(results[1].Message, "no file matching") {
+			t.Fatalf("Expected the missing file to be reported, got %s", results[1])
+		}
+		ibp, itp := results[2].BreakPoint, results[3].BreakPoint
+		if ibp == nil || ibp.Addr != bp.Addr || !ibp.Disabled {
+			t.Fatalf("Expected a disabled breakpoint at %#x, got %s", bp.Addr, results[2])
+		}
+		if itp == nil || itp.Addr != tp.Addr || !itp.Tracepoint || !reflect.DeepEqual(itp.Variables, []string{"a"}) {
+			t.Fatalf("Expected a tracepoint at %#x, got %s", tp.Addr, results[3])
+		}
+	})
+}
+
+func TestExportImportPendingBreakpoints(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		pbp, err := p.BreakPending("plugin.Hook")
+		assertNoError(err, t, "BreakPending()")
+		pbp.Tracepoint = true
+
+		var buf bytes.Buffer
+		assertNoError(p.ExportBreakpoints(&buf), t, "ExportBreakpoints()")
+		_, err = p.ClearByLocation("plugin.Hook")
+		assertNoError(err, t, "ClearByLocation()")
+		if !strings.Contains(buf.String(), `"location": "plugin.Hook"`) {
+			t.Fatalf("Expected the pending location to be exported, got %s", buf.String())
+		}
+
+		results, err := p.ImportBreakpoints(&buf)
+		assertNoError(err, t, "ImportBreakpoints()")
+		if len(results) != 1 || results[0].Message != "still pending" {
+			t.Fatalf("Expected the breakpoint to be left pending, got %v", results)
+		}
+		pending := p.PendingBreakpoints()
+		if len(pending) != 1 || pending[0].Location != "plugin.Hook" || !pending[0].Tracepoint {
+			t.Fatalf("Expected a pending tracepoint at plugin.Hook, got %v", pending)
+		}
+	})
+}
+
+func TestGDBServer(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		pc := currentPC(p, t)
+		sp := getRegisters(p, t).SP()
+		tid := p.CurrentThread.Id
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		text, err := p.ReadMemory(uintptr(fn.Entry), 16)
+		assertNoError(err, t, "ReadMemory()")
+
+		// The client runs in another goroutine, as the
+		// process can only be driven from this one.
+		server, client := net.Pipe()
+		go func() {
+			defer client.Close()
+			if err := gdbClientSession(p, newGdbConn(client), tid, pc, sp, fn.Entry, text); err != nil {
+				t.Error(err)
+			}
+		}()
+		assertNoError(p.ServeGDB(server), t, "ServeGDB()")
+	})
+}
+
+func gdbClientSession(p *DebuggedProcess, gc *gdbConn, tid int, pc, sp, entry uint64, text []byte) error {
+	resp, err := gc.exec("qSupported:multiprocess+;swbreak+")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(resp, "vContSupported+") {
+		return fmt.Errorf("expected vCont to be supported, got %q", resp)
+	}
+	sr, err := gc.stopReason()
+	if err != nil {
+		return err
+	}
+	if sr.kind != 'T' || sr.tid != tid {
+		return fmt.Errorf("expected a stop of thread %d, got %#v", tid, sr)
+	}
+	tids, err := gc.threadList(0)
+	if err != nil {
+		return err
+	}
+	if len(tids) == 0 || tids[0] != tid {
+		return fmt.Errorf("expected thread %d first, got %v", tid, tids)
+	}
+
+	regs, err := gc.readRegisters(p.Pid, tid)
+	if err != nil {
+		return err
+	}
+	if len(regs) < gdbRegPC+8 || binary.LittleEndian.Uint64(regs[gdbRegPC:]) != pc || binary.LittleEndian.Uint64(regs[gdbRegSP:]) != sp {
+		return fmt.Errorf("expected PC %#x and SP %#x in registers %x", pc, sp, regs)
+	}
+
+	if err := gc.breakpoint(0, entry, true); err != nil {
+		return err
+	}
+	if !p.BreakpointExists(entry) {
+		return fmt.Errorf("expected a breakpoint at %#x", entry)
+	}
+	data := make([]byte, len(text))
+	if _, err := gc.readMemory(uintptr(entry), data); err != nil {
+		return err
+	}
+	if !bytes.Equal(data, text) {
+		return fmt.Errorf("expected the original instructions %x, got %x", text, data)
+	}
+	if err := gc.breakpoint(0, entry, false); err != nil {
+		return err
+	}
+	if p.BreakpointExists(entry) {
+		return fmt.Errorf("expected the breakpoint at %#x to be cleared", entry)
+	}
+
+	if _, err := gc.exec("m0,8"); err == nil {
+		return fmt.Errorf("expected an error reading unmapped memory")
+	}
+	return nil
+}
+
+func TestGdbConnPackets(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	gc := newGdbConn(local)
+	defer local.Close()
+
+	type reply struct {
+		resp string
+		err  error
+	}
+	replies := make(chan reply, 1)
+	request := func(packet string) {
+		go func() {
+			resp, err := gc.exec(packet)
+			replies <- reply{resp, err}
+		}()
+	}
+
+	rdr := bufio.NewReader(remote)
+	readPacket := func() string {
+		packet, err := rdr.ReadString('#')
+		assertNoError(err, t, "ReadString()")
+		checksum := make([]byte, 2)
+		_, err = io.ReadFull(rdr, checksum)
+		assertNoError(err, t, "ReadFull()")
+		return packet + string(checksum)
+	}
+	write := func(data string) {
+		_, err := remote.Write([]byte(data))
+		assertNoError(err, t, "Write()")
+	}
+	readAck := func() {
+		b, err := rdr.ReadByte()
+		assertNoError(err, t, "ReadByte()")
+		if b != '+' {
+			t.Fatalf("Expected the reply to be acknowledged, got %q", b)
+		}
+	}
+
+	// A rejected packet is sent again, and escapes and
+	// run length encoding are decoded in the reply.
+	request("m1000,4")
+	for i := 0; i < 2; i++ {
+		if packet := readPacket(); packet != "$m1000,4#8e" {
+			t.Fatalf("Expected $m1000,4#8e, got %q", packet)
+		}
+		if i == 0 {
+			write("-")
+		}
+	}
+	write("+")
+	write("$ab}\x03c0* #20")
+	readAck()
+	if r := <-replies; r.err != nil || r.resp != "ab#c0000" {
+		t.Fatalf("Expected \"ab#c0000\", got %q (%v)", r.resp, r.err)
+	}
+
+	// Error replies become errors.
+	request("g")
+	if packet := readPacket(); packet != "$g#67" {
+		t.Fatalf("Expected $g#67, got %q", packet)
+	}
+	write("+$E01#a6")
+	readAck()
+	if r := <-replies; r.err == nil || !strings.Contains(r.err.Error(), "remote error 01") {
+		t.Fatalf("Expected a remote error, got %q (%v)", r.resp, r.err)
+	}
+}
+
+func TestParseStopReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply    string
+		expected *gdbStopReply
+	}{
+		{"S05", &gdbStopReply{kind: 'S', signal: 5}},
+		{"W01", &gdbStopReply{kind: 'W', signal: 1}},
+		{"X09", &gdbStopReply{kind: 'X', signal: 9}},
+		{"T0506:0000000000000000;thread:2a;", &gdbStopReply{kind: 'T', signal: 5, tid: 0x2a}},
+		{"T05thread:p1a.1b;replaylog:begin;", &gdbStopReply{kind: 'T', signal: 5, pid: 0x1a, tid: 0x1b, reason: "begin"}},
+		{"T05", &gdbStopReply{kind: 'T', signal: 5}},
+		{"T0", nil},
+		{"Txx", nil},
+		{"Q05", nil},
+		{"T05thread:p1a;", nil},
+	} {
+		sr, err := parseStopReply(tc.reply)
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatalf("Expected %q to be rejected, got %#v", tc.reply, sr)
+			}
+			continue
+		}
+		assertNoError(err, t, "parseStopReply()")
+		if *sr != *tc.expected {
+			t.Fatalf("Expected %q to be parsed as %#v, got %#v", tc.reply, tc.expected, sr)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	if _, err := exec.LookPath("rr"); err != nil {
+		t.Skip("rr is not installed")
+	}
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", "testheap", "../_fixtures/testheap.go").Run(); err != nil {
+		t.Fatalf("Could not compile testheap due to %s", err)
+	}
+	defer os.Remove("./testheap")
+
+	tracedir, err := Record([]string{"./testheap"})
+	assertNoError(err, t, "Record()")
+	defer os.RemoveAll(filepath.Dir(tracedir))
+	p, err := Replay(tracedir)
+	assertNoError(err, t, "Replay()")
+	defer p.Process.Kill()
+
+	entry, err := p.BreakByLocation("main.main")
+	assertNoError(err, t, "BreakByLocation()")
+	bp, err := p.BreakByLocation("main.allocated")
+	assertNoError(err, t, "BreakByLocation()")
+	for _, addr := range []uint64{entry.Addr, bp.Addr} {
+		assertNoError(p.Continue(), t, "Continue()")
+		if pc := currentPC(p, t); pc != addr {
+			t.Fatalf("Expected to stop at %#x, stopped at %#x", addr, pc)
+		}
+	}
+
+	// Running backwards from allocated stops at the entry of main.
+	assertNoError(p.ReverseContinue(), t, "ReverseContinue()")
+	if pc := currentPC(p, t); pc != entry.Addr {
+		t.Fatalf("Expected to stop back at %#x, stopped at %#x", entry.Addr, pc)
+	}
+}
+
+func TestSessionTimes(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		time.Sleep(20 * time.Millisecond)
+		st := p.SessionTimes()
+		if st.Running != 0 || st.Resumes != 0 || st.Stopped < 20*time.Millisecond || st.LongestStop != st.Stopped {
+			t.Fatalf("Expected the process to have only been stopped, got %s", st)
+		}
+
+		err := p.run(context.Background(), func(context.Context) error {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		})
+		assertNoError(err, t, "run()")
+		st = p.SessionTimes()
+		if st.Running < 20*time.Millisecond || st.Resumes != 1 || st.Stopped < 20*time.Millisecond {
+			t.Fatalf("Expected the process to have run once, got %s", st)
+		}
+	})
+}
+
+func TestHitCounts(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		restricted, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		restricted.Goroutine = 2
+		bp, err := p.BreakByLocation("main.sleepytime")
+		assertNoError(err, t, "BreakByLocation()")
+		for i := 0; i < 3; i++ {
+			assertNoError(p.Continue(), t, "Continue()")
+		}
+
+		if hc := p.HitCounts(bp.ID); hc.String() != "hit 3 times (goroutine 1: 3)" {
+			t.Fatalf("Unexpected hits %s", hc)
+		}
+		// Hits on other goroutines count, though they resume.
+		hc := p.HitCounts(restricted.ID)
+		if hc.Total == 0 || hc.Goroutines[1] != hc.Total || len(p.BreakpointHits(restricted.ID)) != hc.Total {
+			t.Fatalf("Unexpected hits %s", hc)
+		}
+	})
+}
+
+func TestContinueN(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		if err := p.ContinueN(0); err == nil {
+			t.Fatal("Expected an error for a count of 0")
+		}
+		bp, err := p.BreakByLocation("main.sleepytime")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.ContinueN(3), t, "ContinueN()")
+		if hc := p.HitCounts(bp.ID); hc.Total != 3 {
+			t.Fatalf("Expected 3 hits, got %s", hc)
+		}
+
+		pc, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		assertNoError(p.StepN(2), t, "StepN()")
+		next, err := p.CurrentPC()
+		assertNoError(err, t, "CurrentPC()")
+		if next == pc {
+			t.Fatalf("Expected StepN to move past %#x", pc)
+		}
+	})
+}
+
+func TestHitHistogram(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		h, err := p.HitHistogram(bp.ID, time.Second)
+		assertNoError(err, t, "HitHistogram()")
+		if len(h.Counts) != 1 || h.Counts[0] != 0 {
+			t.Fatalf("Expected no hits got %v", h.Counts)
+		}
+
+		// As if the breakpoint was hit twice in a burst, then once more.
+		p.sessionStart = time.Now().Add(-3 * time.Second)
+		for _, d := range []time.Duration{500, 600, 2500} {
+			p.recordHit(p.CurrentThread, bp)
+			p.hits[bp.ID][len(p.hits[bp.ID])-1] = p.sessionStart.Add(d * time.Millisecond)
+		}
+		if n := len(p.BreakpointHits(bp.ID)); n != 3 {
+			t.Fatalf("Expected 3 hits got %d", n)
+		}
+		h, err = p.HitHistogram(bp.ID, time.Second)
+		assertNoError(err, t, "HitHistogram()")
+		if len(h.Counts) < 3 || h.Counts[0] != 2 || h.Counts[1] != 0 || h.Counts[2] != 1 || h.Rate(0) != 2 {
+			t.Fatalf("Unexpected histogram %v", h.Counts)
+		}
+
+		if _, err := p.HitHistogram(bp.ID+1, time.Second); err == nil {
+			t.Fatal("Expected an error for an unknown breakpoint")
+		}
+	})
+}
+
+func TestBreakpointHooks(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		if err := p.OnBreakpointHit(bp.ID+1, nil); err == nil {
+			t.Fatal("Expected an error for an unknown breakpoint")
+		}
+
+		var hits []int
+		err = p.OnBreakpointHit(bp.ID, func(p *DebuggedProcess, hit *BreakPoint) bool {
+			if p.Running() || hit != bp {
+				t.Errorf("Expected the process to be stopped at %s", bp)
+			}
+			hits = append(hits, p.CurrentThread.Id)
+			return len(hits) < 3
+		})
+		assertNoError(err, t, "OnBreakpointHit()")
+		assertNoError(p.Continue(), t, "Continue()")
+		if len(hits) != 3 || p.StopReason() != StopBreakpoint {
+			t.Fatalf("Expected to stop on the third hit, got %d hits, stopped for %s", len(hits), p.StopReason())
+		}
+
+		// Hooks go away with their breakpoint.
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+		bp, err = p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		if len(hits) != 3 {
+			t.Fatalf("Expected the hook to be cleared, ran %d times", len(hits))
+		}
+	})
+}
+
+func TestTraceFunctions(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		var calls []FunctionCall
+		g, err := p.TraceFunctions(`^main\.helloworld$`, func(fc FunctionCall) {
+			// Tracing never stops the process on its own.
+			if calls = append(calls, fc); len(calls) == 3 {
+				p.RequestManualStop()
+			}
+		})
+		assertNoError(err, t, "TraceFunctions()")
+		if len(g.BreakPoints) != 1 {
+			t.Fatalf("Expected to trace one function, got %d", len(g.BreakPoints))
+		}
+		assertNoError(p.Continue(), t, "Continue()")
+		if len(calls) < 3 || p.StopReason() != StopManual {
+			t.Fatalf("Expected to stop after three calls, got %d calls, stopped for %s", len(calls), p.StopReason())
+		}
+
+		fc := calls[0]
+		if fc.Function != "main.helloworld" || fc.Goroutine != 1 || !strings.HasSuffix(fc.File, "testprog.go") {
+			t.Fatalf("Unexpected call %#v", fc)
+		}
+		if s := fc.String(); s != fmt.Sprintf("goroutine 1 called main.helloworld(%s:%d)", fc.File, fc.Line) {
+			t.Fatalf("Unexpected record %q", s)
+		}
+	})
+}
+
+func TestTraceFunctionReturns(t *testing.T) {
+	withTestProcess("../_fixtures/testregargs", t, func(p *DebuggedProcess) {
+		var records []FunctionCall
+		_, err := p.TraceFunctions(`^main\.regargs$`, func(fc FunctionCall) { records = append(records, fc) })
+		assertNoError(err, t, "TraceFunctions()")
+		if _, ok := p.Continue().(ProcessExitedError); !ok {
+			t.Fatal("Expected the process to exit")
+		}
+		if len(records) != 2 {
+			t.Fatalf("Expected a call and a return, got %v", records)
+		}
+
+		call, ret := records[0], records[1]
+		if call.Returned || formatValues(call.Args) != " with n = 42, s = register" {
+			t.Fatalf("Unexpected call %s", call)
+		}
+		if !ret.Returned || ret.Goroutine != call.Goroutine || formatValues(ret.Results) != " with ~r0 = 50" {
+			t.Fatalf("Unexpected return %s", ret)
+		}
+		if len(p.Breakpoints()) != 1 {
+			t.Fatalf("Expected the return breakpoint to be temporary, got %v", p.Breakpoints())
+		}
+	})
+}
+
+func TestRunawayDetector(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		var reports int
+		err := p.SetRunawayDetector(RunawayDetector{
+			Threshold: 200 * time.Millisecond,
+			Policy:    RunawayStop,
+			Report:    func(*DebuggedProcess, *RunawayReport) { reports++ },
+		})
+		assertNoError(err, t, "SetRunawayDetector()")
+		assertNoError(p.Continue(), t, "Continue()")
+		if p.StopReason() != StopRunaway || reports != 1 {
+			t.Fatalf("Expected one runaway report got %d, stopped for %s", reports, p.StopReason())
+		}
+
+		rr := p.LastRunaway()
+		if rr.Elapsed < 200*time.Millisecond {
+			t.Fatalf("Process stopped after %s", rr.Elapsed)
+		}
+		var inMain bool
+		for _, gs := range rr.Stacks {
+			for _, f := range gs.Frames {
+				if f.Function == "main.main" {
+					inMain = true
+				}
+			}
+		}
+		if !inMain {
+			t.Fatalf("main.main not on the stack of any goroutine:\n%s", rr)
+		}
+	})
+}
+
+func TestPtraceFromAnotherThread(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.sleepytime")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+
+		// Locked to a thread of its own, other than the one
+		// which launched the process.
+		errs := make(chan error)
+		go func() {
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			errs <- p.Continue()
+		}()
+		assertNoError(<-errs, t, "Continue()")
+		if current := currentPC(p, t); current != pc {
+			t.Fatalf("Expected to stop at %#x, got %#x", pc, current)
+		}
+	})
+}
+
+func TestContinueContext(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := p.ContinueContext(ctx); err != context.DeadlineExceeded {
+			t.Fatalf("Expected the deadline to be exceeded, got %v", err)
+		}
+		if p.Running() || p.StopReason() != StopManual {
+			t.Fatalf("Expected the process to be stopped manually, stopped for %s", p.StopReason())
+		}
+
+		events := make(chan Event, 8)
+		p.Notify(events)
+		defer p.StopNotify(events)
+		time.AfterFunc(100*time.Millisecond, p.RequestManualStop)
+		assertNoError(p.Continue(), t, "Continue()")
+		ev := <-events
+		// The runtime may start threads meanwhile.
+		for ev.Kind == EventThreadCreated || ev.Kind == EventThreadSwitched {
+			ev = <-events
+		}
+		if ev.Kind != EventManualStop {
+			t.Fatalf("Expected a manual stop, got %s", ev)
+		}
+
+		// The process runs as usual once stopped.
+		pc, err := p.FindLocation("main.sleepytime")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+		if fn := p.GoSymTable.PCToFunc(currentPC(p, t)); fn == nil || fn.Name != "main.sleepytime" {
+			t.Fatalf("Expected to stop in main.sleepytime, got %v", fn)
+		}
+	})
+}
+
+func TestBreakWhileRunning(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		pc, err := p.FindLocation("main.sleepytime")
+		assertNoError(err, t, "FindLocation()")
+
+		errs := make(chan error)
+		cont := func() {
+			go func() { errs <- p.Continue() }()
+			for !p.Running() {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		cont()
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(<-errs, t, "Continue()")
+		if fn := p.GoSymTable.PCToFunc(currentPC(p, t)); fn == nil || fn.Name != "main.sleepytime" {
+			t.Fatalf("Expected to stop in main.sleepytime, got %v", fn)
+		}
+
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+
+		// Never hit again.
+		pc, err = p.FindLocation("main.init.0")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		cont()
+		_, err = p.Clear(pc)
+		assertNoError(err, t, "Clear()")
+		if p.BreakpointExists(pc) {
+			t.Fatal("Expected the breakpoint to be cleared")
+		}
+		time.Sleep(100 * time.Millisecond)
+		if !p.Running() {
+			t.Fatalf("Expected the process to keep running, stopped for %s", p.StopReason())
+		}
+		p.RequestManualStop()
+		assertNoError(<-errs, t, "Continue()")
+	})
+}
+
+func TestBreakWhileStepping(t *testing.T) {
+	withTestProcess("../_fixtures/testnextloop", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.work")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.StepOut(), t, "StepOut()")
+		pc, err := p.FindLocation("main.main")
+		assertNoError(err, t, "FindLocation()")
+		orig, err := p.ReadMemory(uintptr(pc), len(breakpointInstruction))
+		assertNoError(err, t, "ReadMemory()")
+
+		// Set and cleared while Next runs the process over the
+		// calls of main.work, waiting for it to be stopped.
+		done := make(chan struct{})
+		errs := make(chan error, 1)
+		go func() {
+			for {
+				select {
+				case <-done:
+					errs <- nil
+					return
+				default:
+				}
+				if _, err := p.Break(pc); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := p.Clear(pc); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+		for i := 0; i < 10; i++ {
+			assertNoError(p.Next(), t, "Next()")
+		}
+		close(done)
+		assertNoError(<-errs, t, "Break() and Clear()")
+
+		if p.BreakpointExists(pc) {
+			t.Fatal("Expected the breakpoint to be cleared")
+		}
+		data, err := p.ReadMemory(uintptr(pc), len(breakpointInstruction))
+		assertNoError(err, t, "ReadMemory()")
+		if !bytes.Equal(data, orig) {
+			t.Fatalf("Expected the instruction to be restored, got %x instead of %x", data, orig)
+		}
+	})
+}
+
+func TestConcurrentOperations(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bp, err := p.BreakByLocation("main.sleepytime")
+		assertNoError(err, t, "BreakByLocation()")
+
+		// Each Continue waits for the other one to stop at the breakpoint.
+		errs := make(chan error)
+		for i := 0; i < 2; i++ {
+			go func() { errs <- p.Continue() }()
+		}
+		for i := 0; i < 2; i++ {
+			assertNoError(<-errs, t, "Continue()")
+		}
+		if hc := p.HitCounts(bp.ID); hc.Total != 2 {
+			t.Fatalf("Expected 2 hits, got %s", hc)
+		}
+
+		// Restart, Kill and Detach stop the process the other
+		// goroutine continues, which returns as stopped by hand.
+		_, err = p.Clear(bp.Addr)
+		assertNoError(err, t, "Clear()")
+		cont := func() {
+			go func() { errs <- p.Continue() }()
+			for !p.Running() {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		cont()
+		assertNoError(p.Restart(), t, "Restart()")
+		assertNoError(<-errs, t, "Continue()")
+		if p.Exited() || p.Running() {
+			t.Fatal("Expected the restarted process to be stopped")
+		}
+
+		cont()
+		assertNoError(p.Kill(), t, "Kill()")
+		assertNoError(<-errs, t, "Continue()")
+		if !p.Exited() {
+			t.Fatal("Expected the process to be killed")
+		}
+
+		assertNoError(p.Restart(), t, "Restart()")
+		cont()
+		assertNoError(p.Detach(true), t, "Detach()")
+		assertNoError(<-errs, t, "Continue()")
+		if !p.Exited() {
+			t.Fatal("Expected the process to be killed once detached from")
+		}
+
+		// Those called after Kill find the process gone.
+		assertNoError(p.Restart(), t, "Restart()")
+		go func() { errs <- p.Continue() }()
+		assertNoError(p.Kill(), t, "Kill()")
+		if err := <-errs; err != nil {
+			if _, ok := err.(ProcessExitedError); !ok {
+				t.Fatalf("Expected Continue to be stopped or find the process exited, got %v", err)
+			}
+		}
+	})
+}
+
+func TestSignalPolicy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("signal policies are only applied on linux")
+	}
+	withTestProcess("../_fixtures/testsignals", t, func(p *DebuggedProcess) {
+		if err := p.SetSignalPolicy(syscall.SIGTRAP, SignalIgnore); err == nil {
+			t.Fatal("Expected an error setting a policy for SIGTRAP")
+		}
+		assertNoError(p.SetSignalPolicy(syscall.SIGUSR1, SignalStop), t, "SetSignalPolicy()")
+
+		assertNoError(p.Continue(), t, "Continue()")
+		if p.StopReason() != StopSignal || p.LastSignal() != syscall.SIGUSR1 {
+			t.Fatalf("Expected to stop for SIGUSR1, stopped for %s", p.StopReason())
+		}
+
+		// The signal is delivered on continue, the program exits
+		// with an error if it never receives it.
+		pe, ok := p.Continue().(ProcessExitedError)
+		if !ok || pe.Status != 0 {
+			t.Fatalf("Expected the program to handle SIGUSR1 and exit, got %v", pe)
+		}
+	})
+}
+
+func TestCatchSyscall(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("syscalls can only be caught on linux")
+	}
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		write, err := ParseSyscall("write")
+		assertNoError(err, t, "ParseSyscall()")
+		assertNoError(p.CatchSyscall(write, false), t, "CatchSyscall()")
+
+		// Hello, World! is written to the standard output.
+		assertNoError(p.Continue(), t, "Continue()")
+		ss := p.LastSyscall()
+		if p.StopReason() != StopSyscall || ss == nil || ss.Number != write || ss.Exit || ss.Args[0] != 1 {
+			t.Fatalf("Expected to stop entering write to the standard output, stopped for %s at %v", p.StopReason(), ss)
+		}
+		assertNoError(p.Continue(), t, "Continue()")
+		ss = p.LastSyscall()
+		if p.StopReason() != StopSyscall || ss.Number != write || !ss.Exit || ss.Ret != int64(len("Hello, World!\n")) {
+			t.Fatalf("Expected to stop returning from write, stopped for %s at %v", p.StopReason(), ss)
+		}
+
+		events := make(chan Event, 64)
+		p.Notify(events)
+		assertNoError(p.CatchSyscall(write, true), t, "CatchSyscall()")
+		_, err = p.BreakByLocation("main.sleepytime")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+		assertNoError(p.Continue(), t, "Continue()")
+		if p.StopReason() != StopBreakpoint {
+			t.Fatalf("Expected traced syscalls not to stop, stopped for %s", p.StopReason())
+		}
+		var traced int
+		for len(events) > 0 {
+			if ev := <-events; ev.Kind == EventSyscall && ev.Syscall.Number == write {
+				traced++
+			}
+		}
+		if traced != 2 {
+			t.Fatalf("Expected the entry and exit of write to be traced, got %d events", traced)
+		}
+
+		assertNoError(p.ClearSyscallCatch(write), t, "ClearSyscallCatch()")
+		if len(p.SyscallCatches()) != 0 {
+			t.Fatalf("Expected no syscalls caught, got %v", p.SyscallCatches())
+		}
+		assertNoError(p.Continue(), t, "Continue()")
+		if p.StopReason() != StopBreakpoint {
+			t.Fatalf("Expected to stop at the breakpoint, stopped for %s", p.StopReason())
+		}
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.main")
+		insts, err := p.Disassemble(fn.Entry, fn.End-fn.Entry)
+		assertNoError(err, t, "Disassemble()")
+
+		addr := fn.Entry
+		var calls []string
+		for _, inst := range insts {
+			if inst.Addr != addr || inst.Text == "?" {
+				t.Fatalf("Expected an instruction at %#x, got %s", addr, inst)
+			}
+			addr += uint64(inst.Len)
+			if strings.Contains(inst.Text, "main.sleepytime(SB)") || strings.Contains(inst.Text, "main.helloworld(SB)") {
+				calls = append(calls, inst.Text)
+			}
+		}
+		if len(calls) != 2 {
+			t.Fatalf("Expected the calls to sleepytime and helloworld, got %v", insts)
+		}
+
+		// Breakpoints don't show.
+		_, err = p.Break(insts[1].Addr)
+		assertNoError(err, t, "Break()")
+		binsts, err := p.Disassemble(fn.Entry, fn.End-fn.Entry)
+		assertNoError(err, t, "Disassemble()")
+		if !reflect.DeepEqual(binsts, insts) {
+			t.Fatalf("Expected %v with a breakpoint, got %v", insts, binsts)
+		}
+	})
+}
+
+func TestHeapObjects(t *testing.T) {
+	withTestProcess("../_fixtures/testheap", t, func(p *DebuggedProcess) {
+		_, err := p.BreakByLocation("main.allocated")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		addr, typ, err := p.globalVariable("main.sessions")
+		assertNoError(err, t, "globalVariable()")
+		array, length, _, _, err := p.sliceHeader(addr, resolveTypedef(typ).(*dwarf.StructType))
+		assertNoError(err, t, "sliceHeader()")
+		sessions := make(map[uint64]bool)
+		for i := uint64(0); i < length; i++ {
+			s, err := p.readPointer(array + i*uint64(ptrsize))
+			assertNoError(err, t, "readPointer()")
+			sessions[s] = true
+		}
+
+		objects, err := p.HeapObjects("main.Session")
+		assertNoError(err, t, "HeapObjects()")
+		if len(objects) != 20 {
+			t.Fatalf("Expected 20 sessions, got %v", objects)
+		}
+		for _, o := range objects {
+			if !sessions[o.Addr] || o.Size < 656 {
+				t.Fatalf("Expected %s to be one of the sessions", o)
+			}
+		}
+
+		// Large objects have their type recorded by their span.
+		objects, err = p.HeapObjects("main.Blob")
+		assertNoError(err, t, "HeapObjects()")
+		if len(objects) != 2 || objects[0].Size < 40000 {
+			t.Fatalf("Expected 2 blobs, got %v", objects)
+		}
+
+		objects, err = p.HeapObjects("main.Missing")
+		assertNoError(err, t, "HeapObjects()")
+		if len(objects) != 0 {
+			t.Fatalf("Expected no objects of an unknown type, got %v", objects)
+		}
+	})
+}
+
+func TestResourceUsage(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		ru, err := p.ResourceUsage()
+		assertNoError(err, t, "ResourceUsage()")
+		if ru.RSS == 0 || ru.FDs < 3 || ru.Threads != len(p.Threads) {
+			t.Fatalf("Expected memory, the standard files and %d threads, got %s", len(p.Threads), ru)
+		}
+		if _, ok := p.PreviousResourceUsage(); ok {
+			t.Fatal("Expected no previous resource usage before resuming")
+		}
+
+		assertNoError(p.run(context.Background(), func(context.Context) error { return nil }), t, "run()")
+		if prev, ok := p.PreviousResourceUsage(); !ok || prev.Threads != ru.Threads {
+			t.Fatalf("Expected the resource usage at the previous stop, got %s", prev)
+		}
+	})
+}
+
+func TestExitHooks(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		var runs int
+		assertNoError(p.OnExit(func(p *DebuggedProcess) {
+			runs++
+			if p.Running() {
+				t.Error("Expected the process to be reported as stopped in exit hooks")
+			}
+			if _, err := p.Goroutines(); err != nil {
+				t.Errorf("Expected to read the goroutines in exit hooks, got %s", err)
+			}
+		}), t, "OnExit()")
+
+		err := p.run(context.Background(), func(context.Context) error {
+			p.runExitHooks(p.CurrentThread)
+			p.runExitHooks(p.CurrentThread)
+			return nil
+		})
+		assertNoError(err, t, "run()")
+		if runs != 1 {
+			t.Fatalf("Expected the exit hooks to run once, ran %d times", runs)
+		}
+		assertNoError(p.Kill(), t, "Kill()")
+	})
+}
+
+func TestAttachThreadChurn(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the task list is only scanned on linux")
+	}
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", "testthreadchurn", "../_fixtures/testthreadchurn.go").Run(); err != nil {
+		t.Fatalf("Could not compile testthreadchurn due to %s", err)
+	}
+	defer os.Remove("./testthreadchurn")
+
+	for i := 0; i < 5; i++ {
+		cmd := exec.Command("./testthreadchurn")
+		assertNoError(cmd.Start(), t, "Start()")
+		time.Sleep(100 * time.Millisecond)
+
+		// Threads start and exit while we attach to them,
+		// we must end up tracing all of those left.
+		p, err := Attach(cmd.Process.Pid)
+		if err != nil {
+			cmd.Process.Kill()
+			t.Fatal("Attach():", err)
+		}
+		tids, err := p.tasks()
+		assertNoError(err, t, "tasks()")
+		for _, tid := range tids {
+			if _, ok := p.Threads[tid]; !ok {
+				t.Errorf("Thread %d of %v is not traced", tid, tids)
+			}
+		}
+		assertNoError(p.Kill(), t, "Kill()")
+		cmd.Wait()
+	}
+}
+
+func TestProcessesByName(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		pids, err := processesByName("testprog")
+		assertNoError(err, t, "processesByName()")
+		var found bool
+		for _, pid := range pids {
+			found = found || pid == p.Pid
+		}
+		if !found {
+			t.Fatalf("Expected %d among the processes named testprog, got %v", p.Pid, pids)
+		}
+
+		if _, err := AttachByName("testprog-not-running"); err == nil {
+			t.Fatal("Expected attaching to a program that isn't running to fail")
+		}
+	})
+}
+
+func TestLaunchWithConfig(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("pseudo-terminals are only allocated on linux")
+	}
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", "testprog", "../_fixtures/testprog.go").Run(); err != nil {
+		t.Fatalf("Could not compile testprog due to %s", err)
+	}
+	defer os.Remove("./testprog")
+	wd, err := os.Getwd()
+	assertNoError(err, t, "Getwd()")
+	dir, err := ioutil.TempDir("", "delve")
+	assertNoError(err, t, "TempDir()")
+	defer os.RemoveAll(dir)
+
+	cfg := LaunchConfig{Dir: dir, Env: []string{"DELVE_TEST=1"}, PTY: true}
+	p, err := LaunchWithConfig([]string{filepath.Join(wd, "testprog")}, cfg)
+	assertNoError(err, t, "LaunchWithConfig()")
+	defer p.Process.Kill()
+	defer p.PTY().Close()
+
+	cwd, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", p.Pid))
+	assertNoError(err, t, "Readlink()")
+	if real, _ := filepath.EvalSymlinks(dir); cwd != real {
+		t.Fatalf("Expected working directory %s, got %s", real, cwd)
+	}
+	environ, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", p.Pid))
+	assertNoError(err, t, "ReadFile()")
+	if string(environ) != "DELVE_TEST=1\x00" {
+		t.Fatalf("Expected only DELVE_TEST in the environment, got %q", environ)
+	}
+	stdout, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/1", p.Pid))
+	assertNoError(err, t, "Readlink()")
+	if !strings.HasPrefix(stdout, "/dev/pts/") || p.PTY() == nil {
+		t.Fatalf("Expected output to a pseudo-terminal, got %s", stdout)
+	}
+}
+
+func TestAgent(t *testing.T) {
+	if err := exec.Command("go", "build", "-gcflags=-N -l", "-o", "testprog", "../_fixtures/testprog.go").Run(); err != nil {
+		t.Fatalf("Could not compile testprog due to %s", err)
+	}
+	defer os.Remove("./testprog")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assertNoError(err, t, "Listen()")
+	defer l.Close()
+
+	// The agent drives the process from this goroutine.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Found through the path of the executable on the target.
+		wd, _ := os.Getwd()
+		cfg := SymbolConfig{PathMap: []PathMapping{{From: wd, To: "."}}}
+		p, err := ConnectAgentWithConfig(l.Addr().String(), cfg)
+		if err != nil {
+			t.Error("ConnectAgent():", err)
+			l.Close()
+			return
+		}
+		if err := agentSession(p); err != nil {
+			t.Error(err)
+		}
+		if err := p.Detach(true); err != nil {
+			t.Error("Detach():", err)
+		}
+	}()
+	assertNoError(ServeAgent(l, []string{"./testprog"}, 0), t, "ServeAgent()")
+	<-done
+}
+
+func agentSession(p *DebuggedProcess) error {
+	if p.backend.info().Name != "agent" || p.Pid == 0 || len(p.Threads) == 0 {
+		return fmt.Errorf("expected a process served by the agent, got pid %d and %d threads", p.Pid, len(p.Threads))
+	}
+	pc, err := p.CurrentPC()
+	if err != nil {
+		return err
+	}
+	if pc == 0 {
+		return fmt.Errorf("expected the PC of the process")
+	}
+	fn := p.GoSymTable.LookupFunc("main.helloworld")
+	text, err := p.ReadMemory(uintptr(fn.Entry), 4)
+	if err != nil {
+		return err
+	}
+	if _, err := p.Break(fn.Entry); err != nil {
+		return err
+	}
+	if !p.BreakpointExists(fn.Entry) {
+		return fmt.Errorf("expected a breakpoint at %#x", fn.Entry)
+	}
+	data, err := p.ReadMemory(uintptr(fn.Entry), len(text))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, text) {
+		return fmt.Errorf("expected the original instructions % x, got % x", text, data)
+	}
+	return nil
+}
+
+func TestVerifyBinary(t *testing.T) {
+	if err := exec.Command("go", "build", "-o", "testnextprog", "../_fixtures/testnextprog.go").Run(); err != nil {
+		t.Fatalf("Could not compile testnextprog due to %s", err)
+	}
+	defer os.Remove("./testnextprog")
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		assertNoError(p.verifyBinary("./testprog"), t, "verifyBinary()")
+		err := p.verifyBinary("./testnextprog")
+		if _, ok := err.(BuildIDMismatchError); !ok {
+			t.Fatalf("Expected a build ID mismatch, got %v", err)
+		}
+	})
+}
+
+func TestDebuggable(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		if !debuggable(p.Executable()) {
+			t.Fatalf("Expected %s to be debuggable", p.Executable())
+		}
+		if debuggable("/bin/sh") {
+			t.Fatal("Expected /bin/sh not to be debuggable")
+		}
+	})
+}
+
+func TestVerifyPatches(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		// Use up the hardware breakpoints, which patch nothing.
+		for _, name := range []string{"main.main", "main.sleepytime", "runtime.main", "runtime.goexit"} {
+			_, err := p.Break(p.GoSymTable.LookupFunc(name).Entry)
+			assertNoError(err, t, "Break()")
+		}
+		fn := p.GoSymTable.LookupFunc("main.helloworld")
+		bp, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		if bp.OriginalData == nil {
+			t.Skip("hardware breakpoint, nothing patched")
+		}
+		patches := p.Patches()
+		if len(patches) != 1 || patches[0].Addr != fn.Entry || !bytes.Equal(patches[0].Original, bp.OriginalData) {
+			t.Fatalf("Expected the breakpoint to be journaled, got %v", patches)
+		}
+		mismatches, err := p.VerifyPatches(false)
+		assertNoError(err, t, "VerifyPatches()")
+		if len(mismatches) != 0 {
+			t.Fatalf("Expected the patches to be intact, got %v", mismatches)
+		}
+
+		// As if the program generated code over the breakpoint.
+		code := []byte{0x90}
+		_, err = writeMemory(p.CurrentThread, uintptr(fn.Entry), code)
+		assertNoError(err, t, "writeMemory()")
+		mismatches, err = p.VerifyPatches(true)
+		assertNoError(err, t, "VerifyPatches()")
+		if len(mismatches) != 1 || !mismatches[0].Repaired || !bytes.Equal(mismatches[0].Found, code) {
+			t.Fatalf("Expected the overwritten patch to be repaired, got %v", mismatches)
+		}
+		if !bytes.Equal(bp.OriginalData, code) {
+			t.Fatalf("Expected the new code to be restored on clear, got % x", bp.OriginalData)
+		}
+
+		_, err = p.Clear(fn.Entry)
+		assertNoError(err, t, "Clear()")
+		data := make([]byte, len(code))
+		_, err = readMemory(p.CurrentThread, uintptr(fn.Entry), data)
+		assertNoError(err, t, "readMemory()")
+		if !bytes.Equal(data, code) || len(p.Patches()) != 0 {
+			t.Fatalf("Expected the new code back and no patches, got % x and %v", data, p.Patches())
+		}
+	})
+}
+
+func TestReadLargeMemory(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("executables are only read as ELF")
+	}
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		f, err := elf.Open("testprog")
+		assertNoError(err, t, "elf.Open()")
+		defer f.Close()
+		text := f.Section(".text")
+		code, err := text.Data()
+		assertNoError(err, t, "Data()")
+
+		read := func() {
+			data := make([]byte, len(code))
+			n, err := readMemory(p.CurrentThread, uintptr(text.Addr+p.staticBase), data)
+			assertNoError(err, t, "readMemory()")
+			if n != len(code) || !bytes.Equal(data, code) {
+				t.Fatalf("Expected the %d bytes of the text section, read %d differing ones", len(code), n)
+			}
+		}
+		read()
+
+		// As read on kernels without process_vm_readv.
+		noProcessVMReadv.Store(true)
+		defer noProcessVMReadv.Store(false)
+		read()
+	})
+}
+
+func TestMemoryTransaction(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		addr := uintptr(p.GoSymTable.LookupFunc("main.helloworld").Entry)
+		original := make([]byte, 4)
+		_, err := readMemory(p.CurrentThread, addr, original)
+		assertNoError(err, t, "readMemory()")
+
+		tx := p.CurrentThread.BeginWrite()
+		assertNoError(tx.WriteMemory(addr, []byte{0x90, 0x90}), t, "WriteMemory()")
+		assertNoError(tx.WriteMemory(addr+1, []byte{0xcc, 0xcc, 0xcc}), t, "WriteMemory()")
+		data := make([]byte, len(original))
+		_, err = readMemory(p.CurrentThread, addr, data)
+		assertNoError(err, t, "readMemory()")
+		if !bytes.Equal(data, []byte{0x90, 0xcc, 0xcc, 0xcc}) {
+			t.Fatalf("Expected both writes to be done, got % x", data)
+		}
+
+		assertNoError(tx.Rollback(), t, "Rollback()")
+		_, err = readMemory(p.CurrentThread, addr, data)
+		assertNoError(err, t, "readMemory()")
+		if !bytes.Equal(data, original) {
+			t.Fatalf("Expected % x after rollback, got % x", original, data)
+		}
+	})
+}
+
+func TestLogger(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		var out, errs bytes.Buffer
+		p.Logger = NewLogger(&out, &errs, true)
+		_, err := p.BreakByLocation("main.helloworld")
+		assertNoError(err, t, "BreakByLocation()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		trace := errs.String()
+		if !strings.Contains(trace, "debug: continue thread") || !strings.Contains(trace, "hit Breakpoint 1 ") {
+			t.Fatalf("Expected the requests and stops to be traced, got %q", trace)
+		}
+		if out.Len() != 0 {
+			t.Fatalf("Expected no information to be logged, got %q", out.String())
+		}
+
+		p.Logger = NewLogger(nil, nil, false)
+		assertNoError(p.Continue(), t, "Continue()")
+		if errs.String() != trace {
+			t.Fatal("Expected nothing to be logged once silenced")
+		}
+	})
+}
+
+func TestNotify(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		events := make(chan Event, 8)
+		p.Notify(events)
+
+		err := p.run(context.Background(), func(ctx context.Context) error {
+			p.RequestManualStop()
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		assertNoError(err, t, "run()")
+		if ev := <-events; ev.Kind != EventManualStop || ev.Thread != p.CurrentThread.Id {
+			t.Fatalf("Expected a manual stop of thread %d, got %s", p.CurrentThread.Id, ev)
+		}
+		if ev := <-events; ev.Kind != EventStopped {
+			t.Fatalf("Expected the process to be stopped, got %s", ev)
+		}
+
+		assertNoError(p.Kill(), t, "Kill()")
+		ev := <-events
+		if ev.Kind != EventProcessExited || ev.Exit == nil || ev.Exit.Signal != syscall.SIGKILL {
+			t.Fatalf("Expected the process to be killed, got %s", ev)
+		}
+
+		p.StopNotify(events)
+		p.emit(Event{Kind: EventManualStop})
+		if len(events) != 0 {
+			t.Fatalf("Expected no events after StopNotify, got %d", len(events))
+		}
+	})
+}
+
+func TestGDBServerObservers(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assertNoError(err, t, "Listen()")
+		defer l.Close()
+		entry := p.GoSymTable.LookupFunc("main.helloworld").Entry
+
+		go func() {
+			if err := gdbObserverSession(p, l.Addr().String(), entry); err != nil {
+				t.Error(err)
+			}
+		}()
+		assertNoError(p.ServeGDBClients(l), t, "ServeGDBClients()")
+	})
+}
+
+func gdbObserverSession(p *DebuggedProcess, addr string, entry uint64) error {
+	dial := func() (*gdbConn, error) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		gc := newGdbConn(conn)
+		// Wait for the server to register the client.
+		_, err = gc.exec("qSupported")
+		return gc, err
+	}
+	controller, err := dial()
+	if err != nil {
+		return err
+	}
+	observer, err := dial()
+	if err != nil {
+		return err
+	}
+	defer observer.conn.Close()
+
+	if _, err := observer.readRegisters(p.Pid, p.CurrentThread.Id); err != nil {
+		return fmt.Errorf("observer could not read registers: %s", err)
+	}
+	if err := observer.breakpoint(0, entry, true); err == nil {
+		return fmt.Errorf("observer could set a breakpoint")
+	}
+
+	// The observer takes over once the controlling client is gone.
+	controller.conn.Close()
+	for i := 0; ; i++ {
+		err := observer.breakpoint(0, entry, true)
+		if err == nil {
+			break
+		}
+		if i == 50 {
+			return fmt.Errorf("observer did not take over: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !p.BreakpointExists(entry) {
+		return fmt.Errorf("expected a breakpoint at %#x", entry)
+	}
+	return observer.breakpoint(0, entry, false)
+}
+
+func TestChangedRegisters(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		changes, err := p.ChangedRegisters()
+		assertNoError(err, t, "ChangedRegisters()")
+		if changes != nil {
+			t.Fatalf("Expected no changes before the first stop, got %v", changes)
+		}
+
+		old := getRegisters(p, t).Slice()
+		var rax uint64
+		for _, r := range old {
+			if r.Name == "Rax" {
+				rax = r.Value
+			}
+		}
+		err = p.run(context.Background(), func(context.Context) error {
+			regs, err := p.Registers()
+			if err != nil {
+				return err
+			}
+			return regs.SetRegister(p.CurrentThread, "rax", rax+1)
+		})
+		assertNoError(err, t, "run()")
+
+		changes, err = p.ChangedRegisters()
+		assertNoError(err, t, "ChangedRegisters()")
+		expected := []RegisterChange{{"Rax", rax, rax + 1}}
+		if !reflect.DeepEqual(changes, expected) {
+			t.Fatalf("Expected %v, got %v", expected, changes)
+		}
+	})
+}
+
+func TestBreakByFunctionRegexp(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		g, err := p.BreakByFunctionRegexp(`^main\.(helloworld|sleepytime)$`)
+		assertNoError(err, t, "BreakByFunctionRegexp()")
+		if len(g.BreakPoints) != 2 {
+			t.Fatalf("Expected 2 breakpoints got %d", len(g.BreakPoints))
+		}
+		for _, bp := range g.BreakPoints {
+			if bp.Group != g.ID || !p.BreakpointExists(bp.Addr) {
+				t.Fatalf("Breakpoint not set in group %d: %s", g.ID, bp)
+			}
+		}
+		other, err := p.BreakByLocation("main.main")
+		assertNoError(err, t, "BreakByLocation()")
+
+		cleared, err := p.ClearGroup(g.ID)
+		assertNoError(err, t, "ClearGroup()")
+		if len(cleared) != 2 {
+			t.Fatalf("Expected 2 breakpoints cleared got %d", len(cleared))
+		}
+		for _, bp := range g.BreakPoints {
+			if p.BreakpointExists(bp.Addr) {
+				t.Fatalf("Breakpoint not cleared: %s", bp)
+			}
+		}
+		if !p.BreakpointExists(other.Addr) {
+			t.Fatal("Breakpoint outside of the group cleared")
+		}
+
+		if _, err := p.BreakByFunctionRegexp(`^main\.nosuchfunc$`); err == nil {
+			t.Fatal("Expected an error for a regexp matching no function")
+		}
+	})
+}
+
+func TestBreakPackage(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		bps, err := p.BreakPackage("time", true)
+		assertNoError(err, t, "BreakPackage()")
+		found := false
+		for _, bp := range bps {
+			if !bp.Tracepoint || !strings.HasPrefix(bp.FunctionName, "time.") {
+				t.Fatalf("Unexpected %s for %s", bp, bp.FunctionName)
+			}
+			found = found || bp.FunctionName == "time.Sleep"
+		}
+		if !found {
+			t.Fatalf("Expected a tracepoint on time.Sleep, got %v", bps)
+		}
+
+		bps, err = p.BreakPackage("time", true)
+		assertNoError(err, t, "BreakPackage()")
+		if len(bps) != 0 {
+			t.Fatalf("Expected existing tracepoints to be skipped, got %v", bps)
+		}
+		if _, err := p.BreakPackage("main", false); err == nil {
+			t.Fatal("Expected an error for a package without exported functions")
+		}
+	})
+}
+
+func TestRegisterArguments(t *testing.T) {
+	withTestProcess("../_fixtures/testregargs", t, func(p *DebuggedProcess) {
+		fn := p.GoSymTable.LookupFunc("main.regargs")
+		_, err := p.Break(fn.Entry)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		// At the entry of the function the arguments are still in
+		// the registers they are passed in.
+		for name, expected := range map[string]string{"n": "42", "s": "register"} {
+			v, err := p.EvalSymbol(name)
+			assertNoError(err, t, "EvalSymbol()")
+			if v.Value != expected {
+				t.Fatalf("Expected %s to be %s, got %s", name, expected, v.Value)
+			}
+		}
+	})
+}
+
+func TestProfile(t *testing.T) {
+	withTestProcess("../_fixtures/testprofile", t, func(p *DebuggedProcess) {
+		ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+		defer cancel()
+		prof, err := p.Profile(ctx, 5*time.Millisecond)
+		assertNoError(err, t, "Profile()")
+		if p.Running() || p.StopReason() != StopManual {
+			t.Fatalf("Expected the process to be left stopped, stopped for %s", p.StopReason())
+		}
+		if prof.Samples == 0 {
+			t.Fatal("Expected samples")
+		}
+
+		var spin, main *ProfileEntry
+		flat := prof.Flat()
+		for i := range flat {
+			if flat[i].Self > flat[i].Total {
+				t.Fatalf("Expected %s to have less self than total samples", flat[i].Function)
+			}
+			switch flat[i].Function {
+			case "main.spin":
+				spin = &flat[i]
+			case "main.main":
+				main = &flat[i]
+			}
+		}
+		if spin == nil || spin.Self == 0 || main == nil || main.Total < spin.Total {
+			t.Fatalf("Expected main.spin to be sampled spinning in main.main, got %v", flat)
+		}
+
+		var buf bytes.Buffer
+		assertNoError(prof.WriteFolded(&buf), t, "WriteFolded()")
+		samples := 0
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			i := strings.LastIndex(line, " ")
+			n, err := strconv.Atoi(line[i+1:])
+			if i < 0 || err != nil {
+				t.Fatalf("Malformed folded stack %q", line)
+			}
+			samples += n
+		}
+		if samples != prof.Samples {
+			t.Fatalf("Expected %d samples in the folded stacks, got %d", prof.Samples, samples)
 		}
 	})
 }
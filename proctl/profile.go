@@ -0,0 +1,160 @@
+package proctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interval between the samples of Profile, when not given.
+const DefaultProfileInterval = 10 * time.Millisecond
+
+// Maximum number of frames recorded by each sample of Profile.
+const maxProfileDepth = 64
+
+// The stacks the threads of a process were running, sampled at a
+// regular interval by Profile.
+type Profile struct {
+	Interval time.Duration
+	Samples  int // One per thread stopped
+	// Number of samples of each stack, by its functions joined
+	// with semicolons, outermost first.
+	stacks map[string]int
+}
+
+// A function of a Profile and how often it ran.
+type ProfileEntry struct {
+	Function string
+	Self     int // Samples stopped in the function itself
+	Total    int // Samples with the function on the stack
+}
+
+// Samples the stacks of the threads of the process as a poor man's
+// profiler, for programs which can't be restarted with profiling
+// enabled: the process is resumed, and every interval it is halted,
+// the stack of each of its threads is recorded and it is resumed
+// again. Profiling goes on until ctx is done, the process is stopped
+// manually, or it stops on its own, e.g. at a breakpoint, and leaves
+// the process stopped. Threads blocked in the kernel are sampled
+// too, in the system call they are blocked in. The samples taken are
+// returned even if the process exited meanwhile, along with the error.
+// Only live processes are supported.
+func (dbp *DebuggedProcess) Profile(ctx context.Context, interval time.Duration) (*Profile, error) {
+	if name := dbp.backend.info().Name; name != "native" {
+		return nil, fmt.Errorf("profiling is only supported for live processes, not %s targets", name)
+	}
+	if interval <= 0 {
+		interval = DefaultProfileInterval
+	}
+	prof := &Profile{Interval: interval, stacks: make(map[string]int)}
+	err := dbp.run(ctx, func(ctx context.Context) error {
+		for {
+			sctx, cancel := context.WithTimeout(ctx, interval)
+			err := dbp.resume(sctx)
+			cancel()
+			if err != context.DeadlineExceeded || ctx.Err() != nil {
+				return err
+			}
+			// Stop the threads the interrupt left running.
+			if err := dbp.Halt(); err != nil {
+				return err
+			}
+			dbp.sample(prof)
+		}
+	})
+	if err == ctx.Err() {
+		err = nil
+	}
+	return prof, err
+}
+
+// Records the stack of every thread of the stopped process in prof.
+// Stacks which can't be unwound past some frame are recorded up to it.
+func (dbp *DebuggedProcess) sample(prof *Profile) {
+	for _, th := range dbp.Threads {
+		regs, err := th.Registers()
+		if err != nil {
+			continue
+		}
+		frames, _ := dbp.stacktrace(regs.PC(), regs.SP(), regs, maxProfileDepth)
+		if len(frames) == 0 {
+			continue
+		}
+		names := make([]string, len(frames))
+		for i, sf := range frames {
+			name := fmt.Sprintf("%#x", sf.pc)
+			if sf.fn != nil {
+				name = sf.fn.Name
+			}
+			names[len(frames)-1-i] = name
+		}
+		prof.stacks[strings.Join(names, ";")]++
+		prof.Samples++
+	}
+}
+
+// Returns the functions sampled, those the process spent the most
+// time in first.
+func (prof *Profile) Flat() []ProfileEntry {
+	entries := make(map[string]*ProfileEntry)
+	entry := func(fn string) *ProfileEntry {
+		e, ok := entries[fn]
+		if !ok {
+			e = &ProfileEntry{Function: fn}
+			entries[fn] = e
+		}
+		return e
+	}
+	for stack, n := range prof.stacks {
+		fns := strings.Split(stack, ";")
+		entry(fns[len(fns)-1]).Self += n
+		// Recursive functions count once per sample.
+		seen := make(map[string]bool)
+		for _, fn := range fns {
+			if !seen[fn] {
+				seen[fn] = true
+				entry(fn).Total += n
+			}
+		}
+	}
+
+	flat := make([]ProfileEntry, 0, len(entries))
+	for _, e := range entries {
+		flat = append(flat, *e)
+	}
+	sort.Sort(byProfileSelf(flat))
+	return flat
+}
+
+type byProfileSelf []ProfileEntry
+
+func (p byProfileSelf) Len() int      { return len(p) }
+func (p byProfileSelf) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
+func (p byProfileSelf) Less(i, j int) bool {
+	if p[i].Self != p[j].Self {
+		return p[i].Self > p[j].Self
+	}
+	if p[i].Total != p[j].Total {
+		return p[i].Total > p[j].Total
+	}
+	return p[i].Function < p[j].Function
+}
+
+// Writes the profile as folded stacks, one per line followed by its
+// number of samples, as flame graph tools expect them.
+func (prof *Profile) WriteFolded(w io.Writer) error {
+	stacks := make([]string, 0, len(prof.stacks))
+	for stack := range prof.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, prof.stacks[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package proctl
+
+import "runtime"
+
+// Requests run on the ptrace thread, see execPtraceFunc.
+var (
+	ptraceChan     = make(chan func())
+	ptraceDoneChan = make(chan struct{})
+)
+
+func init() {
+	go ptraceHandler()
+}
+
+// Runs the functions sent on ptraceChan, one at a time, on an OS
+// thread of its own. ptrace(2) only accepts requests about a tracee
+// from the thread tracing it, whereas the goroutines calling into
+// proctl may run on any thread.
+func ptraceHandler() {
+	runtime.LockOSThread()
+	for fn := range ptraceChan {
+		fn()
+		ptraceDoneChan <- struct{}{}
+	}
+}
+
+// Runs fn on the ptrace thread and waits for it to return. The
+// processes debugged are launched and attached to from that thread,
+// making it their tracer, so every ptrace request about them must be
+// made through execPtraceFunc. fn must not call execPtraceFunc.
+func execPtraceFunc(fn func()) {
+	ptraceChan <- fn
+	<-ptraceDoneChan
+}
@@ -6,18 +6,37 @@ import (
 	sys "golang.org/x/sys/unix"
 )
 
+// The ptrace requests proctl makes, all issued from the ptrace
+// thread, see execPtraceFunc.
+
 func PtraceCont(tid, sig int) error {
-	_, _, err := sys.Syscall6(sys.SYS_PTRACE, sys.PTRACE_CONT, uintptr(tid), 1, uintptr(sig), 0, 0)
-	if err != syscall.Errno(0) {
-		return err
+	var errno syscall.Errno
+	execPtraceFunc(func() {
+		_, _, errno = sys.Syscall6(sys.SYS_PTRACE, sys.PTRACE_CONT, uintptr(tid), 1, uintptr(sig), 0, 0)
+	})
+	if errno != syscall.Errno(0) {
+		return errno
 	}
 	return nil
 }
 
 func PtraceSingleStep(tid int) error {
-	_, _, err := sys.Syscall6(sys.SYS_PTRACE, sys.PT_STEP, uintptr(tid), 1, 0, 0, 0)
-	if err != syscall.Errno(0) {
-		return err
+	var errno syscall.Errno
+	execPtraceFunc(func() {
+		_, _, errno = sys.Syscall6(sys.SYS_PTRACE, sys.PT_STEP, uintptr(tid), 1, 0, 0, 0)
+	})
+	if errno != syscall.Errno(0) {
+		return errno
 	}
 	return nil
 }
+
+func ptraceAttach(pid int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceAttach(pid) })
+	return
+}
+
+func ptraceDetach(pid int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceDetach(pid) })
+	return
+}
@@ -7,27 +7,101 @@ import (
 	sys "golang.org/x/sys/unix"
 )
 
-func PtraceCont(tid, sig int) error {
-	return sys.PtraceCont(tid, sig)
+// The ptrace requests proctl makes, all issued from the ptrace
+// thread, see execPtraceFunc.
+
+func PtraceCont(tid, sig int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceCont(tid, sig) })
+	return
+}
+
+// Resumes tid until its next syscall entry or exit, see CatchSyscall.
+func ptraceSyscall(tid, sig int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceSyscall(tid, sig) })
+	return
 }
 
-func PtraceSingleStep(tid int) error {
-	return sys.PtraceSingleStep(tid)
+func PtraceSingleStep(tid int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceSingleStep(tid) })
+	return
 }
 
 func PtracePokeUser(tid int, off, addr uintptr) error {
-	_, _, err := sys.Syscall6(sys.SYS_PTRACE, sys.PTRACE_POKEUSR, uintptr(tid), uintptr(off), uintptr(addr), 0, 0)
-	if err != syscall.Errno(0) {
-		return err
+	var errno syscall.Errno
+	execPtraceFunc(func() {
+		_, _, errno = sys.Syscall6(sys.SYS_PTRACE, sys.PTRACE_POKEUSR, uintptr(tid), uintptr(off), uintptr(addr), 0, 0)
+	})
+	if errno != syscall.Errno(0) {
+		return errno
 	}
 	return nil
 }
 
 func PtracePeekUser(tid int, off uintptr) (uintptr, error) {
-	var val uintptr
-	_, _, err := syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKUSR, uintptr(tid), uintptr(off), uintptr(unsafe.Pointer(&val)), 0, 0)
-	if err != syscall.Errno(0) {
-		return 0, err
+	var (
+		val   uintptr
+		errno syscall.Errno
+	)
+	execPtraceFunc(func() {
+		_, _, errno = syscall.Syscall6(syscall.SYS_PTRACE, syscall.PTRACE_PEEKUSR, uintptr(tid), uintptr(off), uintptr(unsafe.Pointer(&val)), 0, 0)
+	})
+	if errno != syscall.Errno(0) {
+		return 0, errno
 	}
 	return val, nil
 }
+
+func ptraceAttach(pid int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceAttach(pid) })
+	return
+}
+
+func ptraceDetach(tid int) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceDetach(tid) })
+	return
+}
+
+func ptraceSetOptions(tid, options int) (err error) {
+	execPtraceFunc(func() { err = syscall.PtraceSetOptions(tid, options) })
+	return
+}
+
+func ptraceGetEventMsg(tid int) (msg uint, err error) {
+	execPtraceFunc(func() { msg, err = sys.PtraceGetEventMsg(tid) })
+	return
+}
+
+func ptraceGetRegs(tid int, regs *sys.PtraceRegs) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceGetRegs(tid, regs) })
+	return
+}
+
+func ptraceSetRegs(tid int, regs *sys.PtraceRegs) (err error) {
+	execPtraceFunc(func() { err = sys.PtraceSetRegs(tid, regs) })
+	return
+}
+
+func ptracePeekData(tid int, addr uintptr, data []byte) (n int, err error) {
+	execPtraceFunc(func() { n, err = sys.PtracePeekData(tid, addr, data) })
+	return
+}
+
+func ptracePokeData(tid int, addr uintptr, data []byte) (n int, err error) {
+	execPtraceFunc(func() { n, err = sys.PtracePokeData(tid, addr, data) })
+	return
+}
+
+// The request of linux 5.3 and later describing the syscall stop a
+// thread is in.
+const ptraceRequestGetSyscallInfo = 0x420e
+
+func ptraceGetSyscallInfo(tid int, info *ptraceSyscallInfo) error {
+	var errno syscall.Errno
+	execPtraceFunc(func() {
+		_, _, errno = sys.Syscall6(sys.SYS_PTRACE, ptraceRequestGetSyscallInfo, uintptr(tid), unsafe.Sizeof(*info), uintptr(unsafe.Pointer(info)), 0, 0)
+	})
+	if errno != syscall.Errno(0) {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+package proctl
+
+import (
+	"fmt"
+	"os"
+)
+
+// TODO(darwin) pseudo-terminals
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals are not supported on darwin")
+}
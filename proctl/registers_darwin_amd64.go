@@ -4,8 +4,14 @@ package proctl
 import "C"
 import "fmt"
 
+// Offset from the base of the thread local storage, at which the
+// runtime keeps the address of the current G.
+const tlsGOffset = 0x30
+
 type Regs struct {
-	pc, sp uint64
+	rax, rbx, rcx, rdx, rdi, rsi, rbp, sp uint64
+	r8, r9, r10, r11, r12, r13, r14, r15  uint64
+	pc, rflags, cs, fs, gs                uint64
 }
 
 func (r *Regs) PC() uint64 {
@@ -16,11 +22,112 @@ func (r *Regs) SP() uint64 {
 	return r.sp
 }
 
+// The thread state only holds the selector of the GS segment,
+// not its base, so goroutines are found by their stacks instead.
+func (r *Regs) TLS() uint64 {
+	return 0
+}
+
+func (r *Regs) Slice() []Register {
+	return []Register{
+		{"Rip", r.pc},
+		{"Rsp", r.sp},
+		{"Rax", r.rax},
+		{"Rbx", r.rbx},
+		{"Rcx", r.rcx},
+		{"Rdx", r.rdx},
+		{"Rdi", r.rdi},
+		{"Rsi", r.rsi},
+		{"Rbp", r.rbp},
+		{"R8", r.r8},
+		{"R9", r.r9},
+		{"R10", r.r10},
+		{"R11", r.r11},
+		{"R12", r.r12},
+		{"R13", r.r13},
+		{"R14", r.r14},
+		{"R15", r.r15},
+		{"Rflags", r.rflags},
+		{"Cs", r.cs},
+		{"Fs", r.fs},
+		{"Gs", r.gs},
+	}
+}
+
+func (r *Regs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	var state C.x86_thread_state64_t
+	kret := C.get_registers(C.mach_port_name_t(thread.os.thread_act), &state)
+	if kret != C.KERN_SUCCESS {
+		return fmt.Errorf("could not get registers")
+	}
+
+	var reg *C.__uint64_t
+	switch registerName(name) {
+	case "rip":
+		reg = &state.__rip
+	case "rsp":
+		reg = &state.__rsp
+	case "rax":
+		reg = &state.__rax
+	case "rbx":
+		reg = &state.__rbx
+	case "rcx":
+		reg = &state.__rcx
+	case "rdx":
+		reg = &state.__rdx
+	case "rdi":
+		reg = &state.__rdi
+	case "rsi":
+		reg = &state.__rsi
+	case "rbp":
+		reg = &state.__rbp
+	case "r8":
+		reg = &state.__r8
+	case "r9":
+		reg = &state.__r9
+	case "r10":
+		reg = &state.__r10
+	case "r11":
+		reg = &state.__r11
+	case "r12":
+		reg = &state.__r12
+	case "r13":
+		reg = &state.__r13
+	case "r14":
+		reg = &state.__r14
+	case "r15":
+		reg = &state.__r15
+	case "eflags":
+		reg = &state.__rflags
+	default:
+		return fmt.Errorf("unknown or read only register %s", name)
+	}
+	*reg = C.__uint64_t(value)
+
+	if kret := C.set_registers(thread.os.thread_act, &state); kret != C.KERN_SUCCESS {
+		return fmt.Errorf("could not set register %s", name)
+	}
+	regs, err := registers(thread)
+	if err != nil {
+		return err
+	}
+	*r = *regs.(*Regs)
+	thread.regs = copyRegisters(r)
+	return nil
+}
+
+func copyRegisters(r Registers) Registers {
+	regs := *r.(*Regs)
+	return &regs
+}
+
 func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
 	kret := C.set_pc(thread.os.thread_act, C.uint64_t(pc))
 	if kret != C.KERN_SUCCESS {
 		return fmt.Errorf("could not set pc")
 	}
+	r.pc = pc
+	thread.regs = copyRegisters(r)
 	return nil
 }
 
@@ -30,6 +137,28 @@ func registers(thread *ThreadContext) (Registers, error) {
 	if kret != C.KERN_SUCCESS {
 		return nil, fmt.Errorf("could not get registers")
 	}
-	regs := &Regs{pc: uint64(state.__rip), sp: uint64(state.__rsp)}
+	regs := &Regs{
+		rax:    uint64(state.__rax),
+		rbx:    uint64(state.__rbx),
+		rcx:    uint64(state.__rcx),
+		rdx:    uint64(state.__rdx),
+		rdi:    uint64(state.__rdi),
+		rsi:    uint64(state.__rsi),
+		rbp:    uint64(state.__rbp),
+		sp:     uint64(state.__rsp),
+		r8:     uint64(state.__r8),
+		r9:     uint64(state.__r9),
+		r10:    uint64(state.__r10),
+		r11:    uint64(state.__r11),
+		r12:    uint64(state.__r12),
+		r13:    uint64(state.__r13),
+		r14:    uint64(state.__r14),
+		r15:    uint64(state.__r15),
+		pc:     uint64(state.__rip),
+		rflags: uint64(state.__rflags),
+		cs:     uint64(state.__cs),
+		fs:     uint64(state.__fs),
+		gs:     uint64(state.__gs),
+	}
 	return regs, nil
 }
@@ -0,0 +1,133 @@
+package proctl
+
+import (
+	"fmt"
+	"syscall"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// Offset from the base of the thread local storage, at which the
+// runtime keeps the address of the current G. The base, in the GDT
+// entry selected by gs, isn't part of the registers, so TLS doesn't
+// report it and this is never used.
+const tlsGOffset = -4
+
+type Regs struct {
+	regs *sys.PtraceRegs
+}
+
+func (r *Regs) PC() uint64 {
+	return r.regs.PC()
+}
+
+func (r *Regs) SP() uint64 {
+	return uint64(uint32(r.regs.Esp))
+}
+
+func (r *Regs) TLS() uint64 {
+	return 0
+}
+
+func (r *Regs) Slice() []Register {
+	regs := []struct {
+		name  string
+		value int32
+	}{
+		{"Eip", r.regs.Eip},
+		{"Esp", r.regs.Esp},
+		{"Eax", r.regs.Eax},
+		{"Ebx", r.regs.Ebx},
+		{"Ecx", r.regs.Ecx},
+		{"Edx", r.regs.Edx},
+		{"Edi", r.regs.Edi},
+		{"Esi", r.regs.Esi},
+		{"Ebp", r.regs.Ebp},
+		{"Orig_eax", r.regs.Orig_eax},
+		{"Eflags", r.regs.Eflags},
+		{"Cs", r.regs.Xcs},
+		{"Ss", r.regs.Xss},
+		{"Ds", r.regs.Xds},
+		{"Es", r.regs.Xes},
+		{"Fs", r.regs.Xfs},
+		{"Gs", r.regs.Xgs},
+	}
+	out := make([]Register, len(regs))
+	for i, reg := range regs {
+		out[i] = Register{reg.name, uint64(uint32(reg.value))}
+	}
+	return out
+}
+
+func (r *Regs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	var reg *int32
+	switch registerName(name) {
+	case "eip":
+		reg = &r.regs.Eip
+	case "esp":
+		reg = &r.regs.Esp
+	case "eax":
+		reg = &r.regs.Eax
+	case "ebx":
+		reg = &r.regs.Ebx
+	case "ecx":
+		reg = &r.regs.Ecx
+	case "edx":
+		reg = &r.regs.Edx
+	case "edi":
+		reg = &r.regs.Edi
+	case "esi":
+		reg = &r.regs.Esi
+	case "ebp":
+		reg = &r.regs.Ebp
+	case "orig_eax":
+		reg = &r.regs.Orig_eax
+	case "eflags":
+		reg = &r.regs.Eflags
+	default:
+		return fmt.Errorf("unknown or read only register %s", name)
+	}
+	if value > 0xffffffff {
+		return fmt.Errorf("value %#x does not fit in register %s", value, name)
+	}
+	*reg = int32(value)
+	return r.set(thread)
+}
+
+func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
+	r.regs.SetPC(pc)
+	return r.set(thread)
+}
+
+// Writes the registers to thread, and keeps them as those of the
+// thread until it is resumed.
+func (r *Regs) set(thread *ThreadContext) error {
+	if err := ptraceSetRegs(thread.Id, r.regs); err != nil {
+		return err
+	}
+	thread.regs = copyRegisters(r)
+	return nil
+}
+
+func copyRegisters(r Registers) Registers {
+	regs := *r.(*Regs).regs
+	return &Regs{&regs}
+}
+
+func registers(thread *ThreadContext) (Registers, error) {
+	var regs sys.PtraceRegs
+	err := ptraceGetRegs(thread.Id, &regs)
+	if err != nil {
+		return nil, err
+	}
+	return &Regs{&regs}, nil
+}
+
+// Sets up regs to make the fork system call, returning the
+// instruction making it.
+func forkSyscall(regs *sys.PtraceRegs) []byte {
+	regs.Eax = syscall.SYS_FORK
+	// Don't restart the system call the thread may be stopped in.
+	regs.Orig_eax = -1
+	return []byte{0xcd, 0x80} // INT 0x80
+}
@@ -1,6 +1,15 @@
 package proctl
 
-import sys "golang.org/x/sys/unix"
+import (
+	"fmt"
+	"syscall"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// Offset from the base of the thread local storage, at which the
+// runtime keeps the address of the current G.
+const tlsGOffset = -8
 
 type Regs struct {
 	regs *sys.PtraceRegs
@@ -14,16 +23,128 @@ func (r *Regs) SP() uint64 {
 	return r.regs.Rsp
 }
 
+func (r *Regs) TLS() uint64 {
+	return r.regs.Fs_base
+}
+
+func (r *Regs) Slice() []Register {
+	return []Register{
+		{"Rip", r.regs.Rip},
+		{"Rsp", r.regs.Rsp},
+		{"Rax", r.regs.Rax},
+		{"Rbx", r.regs.Rbx},
+		{"Rcx", r.regs.Rcx},
+		{"Rdx", r.regs.Rdx},
+		{"Rdi", r.regs.Rdi},
+		{"Rsi", r.regs.Rsi},
+		{"Rbp", r.regs.Rbp},
+		{"R8", r.regs.R8},
+		{"R9", r.regs.R9},
+		{"R10", r.regs.R10},
+		{"R11", r.regs.R11},
+		{"R12", r.regs.R12},
+		{"R13", r.regs.R13},
+		{"R14", r.regs.R14},
+		{"R15", r.regs.R15},
+		{"Orig_rax", r.regs.Orig_rax},
+		{"Eflags", r.regs.Eflags},
+		{"Cs", r.regs.Cs},
+		{"Ss", r.regs.Ss},
+		{"Ds", r.regs.Ds},
+		{"Es", r.regs.Es},
+		{"Fs", r.regs.Fs},
+		{"Gs", r.regs.Gs},
+		{"Fs_base", r.regs.Fs_base},
+		{"Gs_base", r.regs.Gs_base},
+	}
+}
+
+func (r *Regs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	var reg *uint64
+	switch registerName(name) {
+	case "rip":
+		reg = &r.regs.Rip
+	case "rsp":
+		reg = &r.regs.Rsp
+	case "rax":
+		reg = &r.regs.Rax
+	case "rbx":
+		reg = &r.regs.Rbx
+	case "rcx":
+		reg = &r.regs.Rcx
+	case "rdx":
+		reg = &r.regs.Rdx
+	case "rdi":
+		reg = &r.regs.Rdi
+	case "rsi":
+		reg = &r.regs.Rsi
+	case "rbp":
+		reg = &r.regs.Rbp
+	case "r8":
+		reg = &r.regs.R8
+	case "r9":
+		reg = &r.regs.R9
+	case "r10":
+		reg = &r.regs.R10
+	case "r11":
+		reg = &r.regs.R11
+	case "r12":
+		reg = &r.regs.R12
+	case "r13":
+		reg = &r.regs.R13
+	case "r14":
+		reg = &r.regs.R14
+	case "r15":
+		reg = &r.regs.R15
+	case "orig_rax":
+		reg = &r.regs.Orig_rax
+	case "eflags":
+		reg = &r.regs.Eflags
+	case "fs_base":
+		reg = &r.regs.Fs_base
+	case "gs_base":
+		reg = &r.regs.Gs_base
+	default:
+		return fmt.Errorf("unknown or read only register %s", name)
+	}
+	*reg = value
+	return r.set(thread)
+}
+
 func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
 	r.regs.SetPC(pc)
-	return sys.PtraceSetRegs(thread.Id, r.regs)
+	return r.set(thread)
+}
+
+// Writes the registers to thread, and keeps them as those of the
+// thread until it is resumed.
+func (r *Regs) set(thread *ThreadContext) error {
+	if err := ptraceSetRegs(thread.Id, r.regs); err != nil {
+		return err
+	}
+	thread.regs = copyRegisters(r)
+	return nil
+}
+
+func copyRegisters(r Registers) Registers {
+	regs := *r.(*Regs).regs
+	return &Regs{&regs}
 }
 
 func registers(thread *ThreadContext) (Registers, error) {
 	var regs sys.PtraceRegs
-	err := sys.PtraceGetRegs(thread.Id, &regs)
+	err := ptraceGetRegs(thread.Id, &regs)
 	if err != nil {
 		return nil, err
 	}
 	return &Regs{&regs}, nil
 }
+
+// Sets up regs to make the fork system call, returning the
+// instruction making it.
+func forkSyscall(regs *sys.PtraceRegs) []byte {
+	regs.Rax = syscall.SYS_FORK
+	// Don't restart the system call the thread may be stopped in.
+	regs.Orig_rax = ^uint64(0)
+	return []byte{0x0f, 0x05} // SYSCALL
+}
@@ -0,0 +1,100 @@
+package proctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// The runtime keeps the current G in x28 on arm64 rather than in the
+// thread local storage, whose address TLS doesn't report, so this is
+// never used.
+const tlsGOffset = 0
+
+type Regs struct {
+	regs *sys.PtraceRegs
+}
+
+func (r *Regs) PC() uint64 {
+	return r.regs.PC()
+}
+
+func (r *Regs) SP() uint64 {
+	return r.regs.Sp
+}
+
+func (r *Regs) TLS() uint64 {
+	return 0
+}
+
+func (r *Regs) Slice() []Register {
+	regs := make([]Register, 0, len(r.regs.Regs)+3)
+	regs = append(regs, Register{"Pc", r.regs.Pc}, Register{"Sp", r.regs.Sp})
+	for i, v := range r.regs.Regs {
+		regs = append(regs, Register{fmt.Sprintf("X%d", i), v})
+	}
+	return append(regs, Register{"Pstate", r.regs.Pstate})
+}
+
+func (r *Regs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	var reg *uint64
+	switch name = registerName(name); name {
+	case "pc":
+		reg = &r.regs.Pc
+	case "sp":
+		reg = &r.regs.Sp
+	case "pstate":
+		reg = &r.regs.Pstate
+	default:
+		n, err := strconv.Atoi(strings.TrimPrefix(name, "x"))
+		if !strings.HasPrefix(name, "x") || err != nil || n < 0 || n >= len(r.regs.Regs) {
+			return fmt.Errorf("unknown or read only register %s", name)
+		}
+		reg = &r.regs.Regs[n]
+	}
+	*reg = value
+	return r.set(thread)
+}
+
+func (r *Regs) SetPC(thread *ThreadContext, pc uint64) error {
+	r.regs.SetPC(pc)
+	return r.set(thread)
+}
+
+// Writes the registers to thread, and keeps them as those of the
+// thread until it is resumed.
+func (r *Regs) set(thread *ThreadContext) error {
+	if err := ptraceSetRegs(thread.Id, r.regs); err != nil {
+		return err
+	}
+	thread.regs = copyRegisters(r)
+	return nil
+}
+
+func copyRegisters(r Registers) Registers {
+	regs := *r.(*Regs).regs
+	return &Regs{&regs}
+}
+
+func registers(thread *ThreadContext) (Registers, error) {
+	var regs sys.PtraceRegs
+	err := ptraceGetRegs(thread.Id, &regs)
+	if err != nil {
+		return nil, err
+	}
+	return &Regs{&regs}, nil
+}
+
+// Sets up regs to make the clone system call, as arm64 has no fork,
+// returning the instruction making it.
+func forkSyscall(regs *sys.PtraceRegs) []byte {
+	regs.Regs[8] = syscall.SYS_CLONE
+	regs.Regs[0] = uint64(syscall.SIGCHLD)
+	for i := 1; i < 5; i++ {
+		regs.Regs[i] = 0
+	}
+	return []byte{0x01, 0x00, 0x00, 0xd4} // SVC #0
+}
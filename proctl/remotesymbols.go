@@ -0,0 +1,129 @@
+package proctl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Maps the paths of files on the target to the paths of their copies
+// on this machine, by replacing the prefix From with To, e.g. the
+// paths of the binary of a remote process, see SymbolConfig, or of the
+// source files in the debug information, see DebuggedProcess.PathMap.
+type PathMapping struct {
+	From, To string
+}
+
+// Where to find the symbols of a process running on another machine,
+// possibly with another OS or file system layout, see
+// ConnectAgentWithConfig.
+type SymbolConfig struct {
+	// Copy of the executable of the process. When empty, the path
+	// of the executable on the target is mapped with PathMap.
+	Binary  string
+	PathMap []PathMapping
+	// Load the symbols even if the build ID of the binary
+	// doesn't match the one of the process.
+	SkipVerify bool
+}
+
+// Returns path mapped by the first of the mappings whose prefix
+// it has, or path itself if there is none.
+func mapPath(path string, mappings []PathMapping) string {
+	for _, m := range mappings {
+		if strings.HasPrefix(path, m.From) {
+			return m.To + path[len(m.From):]
+		}
+	}
+	return path
+}
+
+// Returns the local path of the source file at path in the debug
+// information of the process: mapped by its PathMap, or else, when it
+// runs in another mount namespace, e.g. a container, found in its file
+// system if missing from ours.
+func (dbp *DebuggedProcess) LocalPath(path string) string {
+	if mapped := mapPath(path, dbp.PathMap); mapped != path {
+		return mapped
+	}
+	if root := dbp.fsRoot(); root != "" {
+		if _, err := os.Stat(path); err != nil {
+			if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+				return filepath.Join(root, path)
+			}
+		}
+	}
+	return path
+}
+
+// Returns the path in the debug information of the process of the
+// local source file at path, undoing LocalPath.
+func (dbp *DebuggedProcess) debugPath(path string) string {
+	for _, m := range dbp.PathMap {
+		if strings.HasPrefix(path, m.To) {
+			return m.From + path[len(m.To):]
+		}
+	}
+	if root := dbp.fsRoot(); root != "" && strings.HasPrefix(path, root+"/") {
+		return path[len(root):]
+	}
+	return path
+}
+
+// BuildIDMismatchError is returned when the symbols of a process
+// are to be loaded from a binary other than the one it runs.
+type BuildIDMismatchError struct {
+	Path    string
+	Pid     int
+	Binary  string // Build ID of the binary at Path
+	Process string // Build ID found in the memory of the process
+}
+
+func (bme BuildIDMismatchError) Error() string {
+	return fmt.Sprintf("%s does not match the executable of process %d: build ID %q, process has %q",
+		bme.Path, bme.Pid, bme.Binary, bme.Process)
+}
+
+// Checks that the binary at path is the one the process runs, by
+// comparing the build ID of the file with the one found at the same
+// address in the memory of the process. Binaries without a build ID
+// can't be checked and are assumed to match.
+func (dbp *DebuggedProcess) verifyBinary(path string) error {
+	sections, _, closer, err := (&Diagnostics{}).openBinary(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for _, s := range sections {
+		var parse func(io.ReaderAt) string
+		switch s.Name {
+		case ".note.go.buildid":
+			parse = noteBuildID
+		case ".text", "__text":
+			parse = buildID
+		default:
+			continue
+		}
+		id := parse(s.r)
+		if id == "" || s.addr == 0 {
+			continue
+		}
+		size := uint64(256)
+		if s.Size < size {
+			size = s.Size
+		}
+		mem, err := dbp.CurrentThread.readMemory(uintptr(s.addr), uintptr(size))
+		if err != nil {
+			return fmt.Errorf("could not read the build ID of process %d: %s", dbp.Pid, err)
+		}
+		if found := parse(bytes.NewReader(mem)); found != id {
+			return BuildIDMismatchError{Path: path, Pid: dbp.Pid, Binary: id, Process: found}
+		}
+		return nil
+	}
+	return nil
+}
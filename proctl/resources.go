@@ -0,0 +1,39 @@
+package proctl
+
+import "fmt"
+
+// Resources used by the process at a point in time, to correlate
+// where it is stopped with e.g. leaked memory, files or threads.
+type ResourceUsage struct {
+	RSS     uint64 // Resident set size, in bytes
+	FDs     int    // Open file descriptors
+	Threads int
+}
+
+func (ru ResourceUsage) String() string {
+	return fmt.Sprintf("rss %.1f MiB, %d open files, %d threads",
+		float64(ru.RSS)/(1<<20), ru.FDs, ru.Threads)
+}
+
+// Returns the resources the process used at the previous
+// stop, or false if it wasn't resumed since it was started.
+func (dbp *DebuggedProcess) PreviousResourceUsage() (ResourceUsage, bool) {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	if dbp.prevUsage == nil {
+		return ResourceUsage{}, false
+	}
+	return *dbp.prevUsage, true
+}
+
+// Records the resources used by the process as it is
+// resumed, to be compared with those at the next stop.
+func (dbp *DebuggedProcess) recordResourceUsage() {
+	ru, err := dbp.ResourceUsage()
+	if err != nil {
+		return
+	}
+	dbp.mu.Lock()
+	dbp.prevUsage = &ru
+	dbp.mu.Unlock()
+}
@@ -0,0 +1,8 @@
+package proctl
+
+import "fmt"
+
+// TODO(darwin)
+func (dbp *DebuggedProcess) ResourceUsage() (ResourceUsage, error) {
+	return ResourceUsage{}, fmt.Errorf("resource usage is not supported on darwin")
+}
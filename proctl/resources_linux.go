@@ -0,0 +1,54 @@
+package proctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Returns the resources currently used by the process, read from /proc.
+func (dbp *DebuggedProcess) ResourceUsage() (ResourceUsage, error) {
+	if dbp.Exited() {
+		return ResourceUsage{}, dbp.exitError()
+	}
+	if name := dbp.backend.info().Name; name != "native" {
+		return ResourceUsage{}, fmt.Errorf("resource usage is only known for live processes, not %s targets", name)
+	}
+
+	var ru ResourceUsage
+	// size resident shared text lib data dt, in pages
+	statm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/statm", dbp.Pid))
+	if err != nil {
+		return ru, err
+	}
+	fields := strings.Fields(string(statm))
+	if len(fields) < 2 {
+		return ru, fmt.Errorf("malformed statm %q", statm)
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return ru, fmt.Errorf("malformed statm %q", statm)
+	}
+	ru.RSS = pages * uint64(os.Getpagesize())
+
+	if ru.FDs, err = countEntries(fmt.Sprintf("/proc/%d/fd", dbp.Pid)); err != nil {
+		return ru, err
+	}
+	if ru.Threads, err = countEntries(fmt.Sprintf("/proc/%d/task", dbp.Pid)); err != nil {
+		return ru, err
+	}
+	return ru, nil
+}
+
+// Returns the number of entries of the directory at path.
+func countEntries(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	return len(names), err
+}
@@ -0,0 +1,528 @@
+package proctl
+
+import (
+	"context"
+	"debug/gosym"
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Offsets of RSP and RIP in the register
+// block of the amd64 remote protocol target.
+const (
+	gdbRegSP    = 7 * 8
+	gdbRegPC    = 16 * 8
+	gdbRegPCNum = 16
+)
+
+// Names of the registers in the register block of the amd64 remote
+// protocol target, in order. The 16 general purpose registers and
+// RIP are 8 bytes long, eflags and the segment registers 4.
+var gdbRegNames = []string{
+	"Rax", "Rbx", "Rcx", "Rdx", "Rsi", "Rdi", "Rbp", "Rsp",
+	"R8", "R9", "R10", "R11", "R12", "R13", "R14", "R15",
+	"Rip", "Eflags", "Cs", "Ss", "Ds", "Es", "Fs", "Gs",
+}
+
+// Backend replaying an rr recording. rr serves the replay through
+// the GDB remote serial protocol, which we drive over gdbConn. Since
+// the recording cannot be modified, breakpoints are inserted through
+// the protocol rather than by writing to target memory.
+type rrBackend struct {
+	conn *gdbConn
+	cmd  *exec.Cmd
+	pid  int
+	// When set, resuming and stepping execute backwards.
+	reverse bool
+	// Set by resume, the next trapWait sends the continue packet.
+	resumed bool
+	// Software breakpoints inserted in the target.
+	breakpoints map[uint64]bool
+	// Addresses of the hardware breakpoints, by debug register.
+	hwbreakpoints [4]uint64
+	// Threads stopped by a software breakpoint. The remote
+	// protocol reports such stops with the PC at the breakpoint
+	// address, whereas the rest of Delve expects the PC to be
+	// past the trap instruction as it is for a native INT 3.
+	trapped map[int]bool
+
+	mu          sync.Mutex
+	running     bool
+	interrupted bool
+}
+
+// Register values of a thread of an rr replay.
+type rrRegs struct {
+	pc, sp uint64
+	data   []byte
+	rr     *rrBackend
+}
+
+func (r *rrRegs) PC() uint64 {
+	return r.pc
+}
+
+func (r *rrRegs) SP() uint64 {
+	return r.sp
+}
+
+// The register block of the remote protocol doesn't hold
+// the base of the FS segment.
+func (r *rrRegs) TLS() uint64 {
+	return 0
+}
+
+func (r *rrRegs) Slice() []Register {
+	regs := make([]Register, 0, len(gdbRegNames))
+	off := 0
+	for i, name := range gdbRegNames {
+		size := 8
+		if i > gdbRegPCNum {
+			size = 4
+		}
+		if off+size > len(r.data) {
+			break
+		}
+		var val uint64
+		switch {
+		case i == gdbRegPCNum:
+			val = r.pc
+		case size == 8:
+			val = binary.LittleEndian.Uint64(r.data[off:])
+		default:
+			val = uint64(binary.LittleEndian.Uint32(r.data[off:]))
+		}
+		regs = append(regs, Register{name, val})
+		off += size
+	}
+	return regs
+}
+
+func (r *rrRegs) SetRegister(thread *ThreadContext, name string, value uint64) error {
+	name = registerName(name)
+	if name == "rip" {
+		return r.SetPC(thread, value)
+	}
+	off := 0
+	for i, n := range gdbRegNames {
+		size := 8
+		if i > gdbRegPCNum {
+			size = 4
+		}
+		if strings.ToLower(n) != name {
+			off += size
+			continue
+		}
+		val := make([]byte, 8)
+		binary.LittleEndian.PutUint64(val, value)
+		if err := r.rr.conn.writeRegister(r.rr.pid, thread.Id, i, val[:size]); err != nil {
+			return err
+		}
+		if off+size <= len(r.data) {
+			copy(r.data[off:], val[:size])
+		}
+		if off == gdbRegSP {
+			r.sp = value
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown register %s", name)
+}
+
+func (r *rrRegs) SetPC(thread *ThreadContext, pc uint64) error {
+	// Rewinding over an emulated trap instruction is a no-op.
+	if r.rr.trapped[thread.Id] && pc == r.pc-1 {
+		delete(r.rr.trapped, thread.Id)
+		r.pc = pc
+		return nil
+	}
+	val := make([]byte, 8)
+	binary.LittleEndian.PutUint64(val, pc)
+	if err := r.rr.conn.writeRegister(r.rr.pid, thread.Id, gdbRegPCNum, val); err != nil {
+		return err
+	}
+	r.pc = pc
+	return nil
+}
+
+func newRRBackend(conn *gdbConn, cmd *exec.Cmd, pid int) *rrBackend {
+	return &rrBackend{
+		conn:        conn,
+		cmd:         cmd,
+		pid:         pid,
+		breakpoints: make(map[uint64]bool),
+		trapped:     make(map[int]bool),
+	}
+}
+
+func (rr *rrBackend) registers(thread *ThreadContext) (Registers, error) {
+	data, err := rr.conn.readRegisters(rr.pid, thread.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gdbRegPC+8 {
+		return nil, fmt.Errorf("short register block for thread %d", thread.Id)
+	}
+	regs := &rrRegs{
+		pc:   binary.LittleEndian.Uint64(data[gdbRegPC:]),
+		sp:   binary.LittleEndian.Uint64(data[gdbRegSP:]),
+		data: data,
+		rr:   rr,
+	}
+	if rr.trapped[thread.Id] {
+		regs.pc++
+	}
+	return regs, nil
+}
+
+func (rr *rrBackend) readMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	return rr.conn.readMemory(addr, data)
+}
+
+func (rr *rrBackend) writeMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
+	return rr.conn.writeMemory(addr, data)
+}
+
+func (rr *rrBackend) setSoftwareBreakpoint(thread *ThreadContext, addr uint64) ([]byte, error) {
+	originalData := make([]byte, 1)
+	if _, err := rr.conn.readMemory(uintptr(addr), originalData); err != nil {
+		return nil, err
+	}
+	if err := rr.conn.breakpoint(0, addr, true); err != nil {
+		return nil, err
+	}
+	rr.breakpoints[addr] = true
+	return originalData, nil
+}
+
+func (rr *rrBackend) clearSoftwareBreakpoint(thread *ThreadContext, addr uint64, originalData []byte) error {
+	if err := rr.conn.breakpoint(0, addr, false); err != nil {
+		return err
+	}
+	delete(rr.breakpoints, addr)
+	return nil
+}
+
+func (rr *rrBackend) setHardwareBreakpoint(reg, tid int, addr uint64) error {
+	if addr == 0 {
+		return rr.clearHardwareBreakpoint(reg, tid)
+	}
+	if err := rr.conn.breakpoint(1, addr, true); err != nil {
+		return err
+	}
+	rr.hwbreakpoints[reg] = addr
+	return nil
+}
+
+func (rr *rrBackend) clearHardwareBreakpoint(reg, tid int) error {
+	if rr.hwbreakpoints[reg] == 0 {
+		return nil
+	}
+	if err := rr.conn.breakpoint(1, rr.hwbreakpoints[reg], false); err != nil {
+		return err
+	}
+	rr.hwbreakpoints[reg] = 0
+	return nil
+}
+
+func (rr *rrBackend) info() BackendInfo {
+	return BackendInfo{
+		Name:                "rr",
+		HardwareBreakpoints: true,
+		WriteMemory:         true,
+		Reverse:             true,
+	}
+}
+
+func (rr *rrBackend) setHardwareWatchpoint(reg, tid int, addr uint64, size int) error {
+	return fmt.Errorf("watchpoints are not supported when replaying")
+}
+
+func (rr *rrBackend) hardwareBreakpointHit(thread *ThreadContext) (int, error) {
+	return -1, nil
+}
+
+// The replay is all-stop, resuming any thread resumes all of them.
+// The continue packet itself is sent by trapWait.
+func (rr *rrBackend) resume(thread *ThreadContext) error {
+	delete(rr.trapped, thread.Id)
+	rr.resumed = true
+	return nil
+}
+
+func (rr *rrBackend) singleStep(thread *ThreadContext) error {
+	delete(rr.trapped, thread.Id)
+	if err := rr.conn.selectThread('c', rr.pid, thread.Id); err != nil {
+		return err
+	}
+	packet := "s"
+	if rr.reverse {
+		packet = "bs"
+	}
+	sr, err := rr.conn.resume(packet)
+	if err != nil {
+		return err
+	}
+	return rr.stopError(sr)
+}
+
+func (rr *rrBackend) halt(thread *ThreadContext) error {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if !rr.running || rr.interrupted {
+		return nil
+	}
+	rr.interrupted = true
+	return rr.conn.interrupt()
+}
+
+func (rr *rrBackend) interrupt(dbp *DebuggedProcess) error {
+	return rr.halt(nil)
+}
+
+func (rr *rrBackend) trapWait(ctx context.Context, dbp *DebuggedProcess, pid int) (int, error) {
+	if !rr.resumed {
+		return -1, fmt.Errorf("process is not running")
+	}
+	rr.resumed = false
+
+	packet := "c"
+	if rr.reverse {
+		packet = "bc"
+	}
+
+	rr.mu.Lock()
+	rr.running = true
+	rr.interrupted = false
+	rr.mu.Unlock()
+
+	sr, err := rr.conn.resume(packet)
+
+	rr.mu.Lock()
+	rr.running = false
+	interrupted := rr.interrupted
+	rr.mu.Unlock()
+
+	if err != nil {
+		return -1, err
+	}
+	if err := rr.stopError(sr); err != nil {
+		return -1, err
+	}
+	if interrupted && ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+
+	if err := rr.updateThreadList(dbp); err != nil {
+		return -1, err
+	}
+	tid := sr.tid
+	if _, ok := dbp.Threads[tid]; !ok {
+		tid = dbp.CurrentThread.Id
+	}
+
+	data, err := rr.conn.readRegisters(rr.pid, tid)
+	if err != nil {
+		return -1, err
+	}
+	if len(data) >= gdbRegPC+8 && rr.breakpoints[binary.LittleEndian.Uint64(data[gdbRegPC:])] {
+		rr.trapped[tid] = true
+	}
+	return tid, nil
+}
+
+// A replay cannot run on its own, detaching ends it. The rr process
+// is left for the caller to reap.
+func (rr *rrBackend) detach(dbp *DebuggedProcess) error {
+	// Best effort, rr is killed regardless.
+	rr.conn.execOK("D")
+	return rr.cmd.Process.Kill()
+}
+
+// Converts stop replies that end the replay into errors.
+
+func (rr *rrBackend) stopError(sr *gdbStopReply) error {
+	switch {
+	case sr.kind == 'W' || sr.kind == 'X':
+		return ProcessExitedError{Pid: rr.pid, Status: sr.signal}
+	case sr.reason == "begin":
+		return fmt.Errorf("reached the beginning of the recording")
+	}
+	return nil
+}
+
+// Adds any threads of the replayed process we do not know about yet.
+func (rr *rrBackend) updateThreadList(dbp *DebuggedProcess) error {
+	tids, err := rr.conn.threadList(rr.pid)
+	if err != nil {
+		return err
+	}
+
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	for _, tid := range tids {
+		if _, ok := dbp.Threads[tid]; ok {
+			continue
+		}
+		dbp.Threads[tid] = &ThreadContext{Id: tid, Process: dbp}
+		if dbp.CurrentThread == nil {
+			dbp.CurrentThread = dbp.Threads[tid]
+		}
+		dbp.emit(Event{Kind: EventThreadCreated, Thread: tid})
+	}
+	return nil
+}
+
+// Returns the rr backend of the process, if it is replaying a recording.
+func (dbp *DebuggedProcess) replay() (*rrBackend, error) {
+	rr, ok := dbp.backend.(*rrBackend)
+	if !ok {
+		return nil, fmt.Errorf("reverse execution is only available when replaying a recording")
+	}
+	return rr, nil
+}
+
+// Runs the process backwards until a breakpoint
+// is hit or the start of the recording is reached.
+func (dbp *DebuggedProcess) ReverseContinue() error {
+	rr, err := dbp.replay()
+	if err != nil {
+		return err
+	}
+	fn := func(ctx context.Context) error {
+		rr.reverse = true
+		defer func() { rr.reverse = false }()
+		return dbp.resume(ctx)
+	}
+	return dbp.run(context.Background(), fn)
+}
+
+// Steps the current thread backwards to the start of the
+// previous source line, following function calls.
+func (dbp *DebuggedProcess) ReverseStep() error {
+	rr, err := dbp.replay()
+	if err != nil {
+		return err
+	}
+	fn := func(ctx context.Context) error {
+		return dbp.CurrentThread.reverseStepLine(ctx, rr, false)
+	}
+	return dbp.run(context.Background(), fn)
+}
+
+// Steps the current thread backwards to the start of the
+// previous source line, stepping over function calls.
+func (dbp *DebuggedProcess) ReverseNext() error {
+	rr, err := dbp.replay()
+	if err != nil {
+		return err
+	}
+	fn := func(ctx context.Context) error {
+		return dbp.CurrentThread.reverseStepLine(ctx, rr, true)
+	}
+	return dbp.run(context.Background(), fn)
+}
+
+// Steps backwards instruction by instruction until the start of the
+// previous source line is reached. Stepping back past the first
+// instruction of that line and then forward once lands on its start.
+// If over is set, functions called from the current one are run back
+// through to their call site instead of being stepped into.
+func (thread *ThreadContext) reverseStepLine(ctx context.Context, rr *rrBackend, over bool) error {
+	rr.reverse = true
+	defer func() { rr.reverse = false }()
+
+	f, l, fn, sp, err := thread.position()
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := thread.Step(); err != nil {
+			return err
+		}
+
+		nf, nl, nfn, nsp, err := thread.position()
+		if err != nil {
+			return err
+		}
+		if nfn == nil {
+			return nil
+		}
+
+		// A lower stack pointer in another function means we have
+		// stepped backwards over a return, into a callee.
+		if over && (fn == nil || nfn.Entry != fn.Entry) && nsp < sp {
+			stopped, err := thread.reverseOutOfCall(ctx, nfn.Entry)
+			if err != nil || stopped {
+				return err
+			}
+			continue
+		}
+
+		if nl == l && nf == f && fn != nil && nfn.Entry == fn.Entry {
+			continue
+		}
+
+		if found {
+			rr.reverse = false
+			return thread.Step()
+		}
+		found = true
+		f, l, fn, sp = nf, nl, nfn, nsp
+	}
+}
+
+// Runs a function the thread has stepped backwards into back to
+// its entry, and then steps back once more onto its call site.
+// Returns true if a user breakpoint stopped the process first.
+func (thread *ThreadContext) reverseOutOfCall(ctx context.Context, entry uint64) (bool, error) {
+	dbp := thread.Process
+	bp, err := dbp.breakpoint(entry)
+	if err != nil {
+		if _, ok := err.(BreakPointExistsError); !ok {
+			return false, err
+		}
+		// A user breakpoint at the entry will stop us there.
+		return true, dbp.resume(ctx)
+	}
+	bp.Temp = true
+
+	if err := dbp.resume(ctx); err != nil {
+		return false, err
+	}
+
+	pc, err := dbp.CurrentThread.CurrentPC()
+	if err != nil {
+		return false, err
+	}
+	if pc != entry && pc-1 != entry {
+		_, err = dbp.clear(entry)
+		return true, err
+	}
+	if err := dbp.CurrentThread.clearTempBreakpoint(entry); err != nil {
+		return false, err
+	}
+	return false, dbp.CurrentThread.Step()
+}
+
+// Returns the source position and stack pointer of the thread.
+func (thread *ThreadContext) position() (string, int, *gosym.Func, uint64, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return "", 0, nil, 0, err
+	}
+	pc := regs.PC()
+	if bp, ok := thread.Process.trappedBreakpoint(pc); ok {
+		pc = bp.Addr
+	}
+	f, l, fn := thread.Process.GoSymTable.PCToLine(pc)
+	return f, l, fn, regs.SP(), nil
+}
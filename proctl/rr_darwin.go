@@ -0,0 +1,13 @@
+package proctl
+
+import "fmt"
+
+// Record is not supported, rr only runs on linux.
+func Record(cmd []string) (string, error) {
+	return "", fmt.Errorf("recording is not supported on darwin")
+}
+
+// Replay is not supported, rr only runs on linux.
+func Replay(tracedir string) (*DebuggedProcess, error) {
+	return nil, fmt.Errorf("replaying recordings is not supported on darwin")
+}
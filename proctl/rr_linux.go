@@ -0,0 +1,124 @@
+package proctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// How long to wait for rr to start serving a replay.
+const rrConnectTimeout = 10 * time.Second
+
+// Record runs cmd under rr, recording its execution. The returned
+// trace directory can later be passed to Replay to debug the run.
+func Record(cmd []string) (string, error) {
+	dir, err := ioutil.TempDir("", "dlv-rr-")
+	if err != nil {
+		return "", err
+	}
+	tracedir := filepath.Join(dir, "trace")
+
+	rec := exec.Command("rr", append([]string{"record", "--output-trace-dir", tracedir}, cmd...)...)
+	rec.Stdin = os.Stdin
+	rec.Stdout = os.Stdout
+	rec.Stderr = os.Stderr
+	if err := rec.Run(); err != nil {
+		// rr exits with the status of the recorded program,
+		// the recording is still usable if the program failed.
+		if _, ok := err.(*exec.ExitError); !ok {
+			return "", fmt.Errorf("could not record %s: %s", cmd[0], err)
+		}
+	}
+	if _, err := os.Stat(tracedir); err != nil {
+		return "", fmt.Errorf("rr did not produce a recording: %s", err)
+	}
+
+	return tracedir, nil
+}
+
+// Replay starts replaying the rr recording in tracedir. The returned
+// process supports the reverse execution operations in addition to
+// the usual forward ones, but its memory and registers cannot be
+// modified.
+func Replay(tracedir string) (*DebuggedProcess, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	cmd := exec.Command("rr", "replay", fmt.Sprintf("--dbgport=%d", port), tracedir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start rr: %s", err)
+	}
+
+	conn, err := dialRR(port)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+
+	dbp, err := newReplayProcess(conn, cmd)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, err
+	}
+	return dbp, nil
+}
+
+func dialRR(port int) (*gdbConn, error) {
+	deadline := time.Now().Add(rrConnectTimeout)
+	for {
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err == nil {
+			return newGdbConn(c), nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("could not connect to rr: %s", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func newReplayProcess(conn *gdbConn, cmd *exec.Cmd) (*DebuggedProcess, error) {
+	sr, err := conn.stopReason()
+	if err != nil {
+		return nil, err
+	}
+	if sr.pid == 0 {
+		return nil, fmt.Errorf("rr did not report the replayed process")
+	}
+
+	path, err := conn.execFile(sr.pid)
+	if err != nil {
+		return nil, err
+	}
+
+	rr := newRRBackend(conn, cmd, sr.pid)
+	dbp := &DebuggedProcess{
+		Pid:         sr.pid,
+		Process:     cmd.Process,
+		Threads:     make(map[int]*ThreadContext),
+		BreakPoints: make(map[uint64]*BreakPoint),
+		os:          new(OSProcessDetails),
+		backend:     rr,
+	}
+
+	if err := dbp.loadInformation(path); err != nil {
+		return nil, err
+	}
+	if err := rr.updateThreadList(dbp); err != nil {
+		return nil, err
+	}
+	if th, ok := dbp.Threads[sr.tid]; ok {
+		dbp.CurrentThread = th
+	}
+	return dbp, nil
+}
@@ -0,0 +1,145 @@
+package proctl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// Maximum number of frames of each goroutine in runaway reports.
+const maxRunawayDepth = 30
+
+// What Continue does after reporting that the process ran away.
+type RunawayPolicy int
+
+const (
+	// Leave the process stopped, as if stopped manually.
+	RunawayStop RunawayPolicy = iota
+	// Resume the process, reporting again if it keeps running.
+	RunawayResume
+)
+
+func (rp RunawayPolicy) String() string {
+	switch rp {
+	case RunawayStop:
+		return "stop"
+	case RunawayResume:
+		return "resume"
+	}
+	return "unknown"
+}
+
+// Stops the process when Continue runs it for longer than Threshold
+// without it stopping on its own, to report what it is doing instead
+// of hanging silently, see SetRunawayDetector.
+type RunawayDetector struct {
+	Threshold time.Duration
+	Policy    RunawayPolicy
+	// Called with each report, while the process is stopped. The
+	// report is sent as EventRunaway as well.
+	Report func(*DebuggedProcess, *RunawayReport)
+}
+
+// What the goroutines of a process were doing when it ran for
+// longer than the threshold of its runaway detector.
+type RunawayReport struct {
+	Elapsed time.Duration // Since Continue resumed the process
+	Stacks  []GoroutineStack
+}
+
+func (rr *RunawayReport) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Process running for %s, %d goroutines:\n", rr.Elapsed, len(rr.Stacks))
+	for _, gs := range rr.Stacks {
+		buf.WriteString(gs.String())
+	}
+	return buf.String()
+}
+
+// The stack of a goroutine, innermost frame first.
+type GoroutineStack struct {
+	G      *G
+	Status string // As tracebacks print it, e.g. running or chan receive, see GoroutineState
+	Frames []Frame
+	// Set when the stack is deeper than the frames asked for.
+	Truncated bool
+	// Why the stack couldn't be unwound past its last frame, if so.
+	Err error
+}
+
+func (gs GoroutineStack) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Goroutine %d [%s]:\n", gs.G.Id, gs.Status)
+	for _, f := range gs.Frames {
+		fmt.Fprintf(&buf, "\t%#x %s %s:%d\n", f.PC, f.Function, f.File, f.Line)
+	}
+	if gs.Truncated {
+		buf.WriteString("\t...additional frames elided...\n")
+	}
+	if gs.Err != nil {
+		fmt.Fprintf(&buf, "\t(%s)\n", gs.Err)
+	}
+	return buf.String()
+}
+
+// A frame of a stack, by the PC executing in it.
+type Frame struct {
+	PC       uint64
+	File     string
+	Line     int
+	Function string
+}
+
+// Sets the runaway detector of Continue, a zero Threshold disables it.
+func (dbp *DebuggedProcess) SetRunawayDetector(rd RunawayDetector) error {
+	if name := dbp.backend.info().Name; name != "native" {
+		return fmt.Errorf("runaway detection is only supported for live processes, not %s targets", name)
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	dbp.runaway = rd
+	return nil
+}
+
+// Returns the last runaway report, nil if the process never ran away.
+func (dbp *DebuggedProcess) LastRunaway() *RunawayReport {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.lastRunaway
+}
+
+// Continues the process as resume does, stopping it once it runs for
+// longer than the threshold of the runaway detector.
+func (dbp *DebuggedProcess) resumeWatched(ctx context.Context, rd RunawayDetector) error {
+	for {
+		start := time.Now()
+		wctx, cancel := context.WithTimeout(ctx, rd.Threshold)
+		err := dbp.resume(wctx)
+		cancel()
+		if err != context.DeadlineExceeded || ctx.Err() != nil {
+			return err
+		}
+		// Stop the threads the interrupt left running.
+		if err := dbp.Halt(); err != nil {
+			return err
+		}
+
+		rr := &RunawayReport{Elapsed: time.Since(start)}
+		rr.Stacks, err = dbp.AllStacktraces(maxRunawayDepth)
+		if err != nil {
+			return fmt.Errorf("could not capture the stacks of the runaway process: %s", err)
+		}
+		dbp.mu.Lock()
+		dbp.lastRunaway = rr
+		dbp.stopReason = StopRunaway
+		dbp.mu.Unlock()
+		dbp.emit(Event{Kind: EventRunaway, Thread: dbp.CurrentThread.Id, Runaway: rr})
+		if rd.Report != nil {
+			rd.Report(dbp, rr)
+		}
+		if rd.Policy != RunawayResume {
+			return nil
+		}
+	}
+}
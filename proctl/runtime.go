@@ -0,0 +1,224 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// The runtime structs the goroutines, threads and maps of a program
+// are decoded with. Their layout changes across Go releases, and is
+// read from the debug information of the program as it is loaded.
+var runtimeStructs = []string{"runtime.g", "runtime.m", "runtime.hmap"}
+
+// Resolves the runtime structs of the program, which findStructType
+// then returns without looking them up. Those missing from its
+// runtime, e.g. runtime.hmap since maps changed implementation, are
+// left out.
+func (dbp *DebuggedProcess) loadRuntimeTypes() {
+	for _, name := range runtimeStructs {
+		dbp.findStructType(name)
+	}
+}
+
+// Returns the struct type with the given name, e.g. "runtime.g",
+// as described by the debug info of the process. Types found are
+// cached, and the offsets of their fields read from them.
+func (dbp *DebuggedProcess) findStructType(name string) (*dwarf.StructType, error) {
+	dbp.typeMu.Lock()
+	st, ok := dbp.structTypes[name]
+	dbp.typeMu.Unlock()
+	if ok {
+		return st, nil
+	}
+
+	st, err := dbp.lookupStructType(name)
+	if err != nil {
+		return nil, err
+	}
+	dbp.typeMu.Lock()
+	if dbp.structTypes == nil {
+		dbp.structTypes = make(map[string]*dwarf.StructType)
+	}
+	dbp.structTypes[name] = st
+	dbp.typeMu.Unlock()
+	return st, nil
+}
+
+// Looks the struct type with the given name up for findStructType.
+func (dbp *DebuggedProcess) lookupStructType(name string) (*dwarf.StructType, error) {
+	entries, err := dbp.typeEntries(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Tag != dwarf.TagStructType {
+			continue
+		}
+
+		t, err := dbp.Dwarf.Type(entry.Offset)
+		if err != nil {
+			return nil, err
+		}
+		st, ok := t.(*dwarf.StructType)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a struct type", name)
+		}
+		return st, nil
+	}
+	return nil, fmt.Errorf("could not find type %s", name)
+}
+
+// Returns the field of t with the given name.
+func structField(t *dwarf.StructType, name string) (*dwarf.StructField, error) {
+	for _, f := range t.Field {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no member %s", t.StructName, name)
+}
+
+// Reads the named integer or pointer field of the struct of type t
+// located at addr in the memory of the process.
+func (dbp *DebuggedProcess) readUintField(addr uint64, t *dwarf.StructType, name string) (uint64, error) {
+	f, err := structField(t, name)
+	if err != nil {
+		return 0, err
+	}
+	addr += uint64(f.ByteOffset)
+	// Pointers the runtime may hide from the garbage collector, such
+	// as sudog.elem, are split into a pointer and a uintptr, only one
+	// of which is set.
+	if st, ok := resolveTypedef(f.Type).(*dwarf.StructType); ok {
+		if _, err := structField(st, "vu"); err == nil {
+			if vp, err := dbp.readUintField(addr, st, "vp"); err != nil || vp != 0 {
+				return vp, err
+			}
+			return dbp.readUintField(addr, st, "vu")
+		}
+	}
+	size := f.Type.Size()
+	if size <= 0 || size > 8 {
+		return 0, fmt.Errorf("member %s of %s is not an integer", name, t.StructName)
+	}
+	return dbp.CurrentThread.readUintRaw(uintptr(addr), size)
+}
+
+// Reads a pointer sized value from the memory of the process.
+func (dbp *DebuggedProcess) readPointer(addr uint64) (uint64, error) {
+	val, err := dbp.CurrentThread.readMemory(uintptr(addr), ptrsize)
+	if err != nil {
+		return 0, err
+	}
+	return decodePointer(val), nil
+}
+
+// Returns the address and type of the named package variable.
+func (dbp *DebuggedProcess) globalVariable(name string) (uint64, dwarf.Type, error) {
+	entry, err := dbp.packageVariableEntry(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return 0, nil, fmt.Errorf("type assertion failed")
+	}
+	t, err := dbp.Dwarf.Type(offset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
+	if !ok {
+		return 0, nil, fmt.Errorf("type assertion failed")
+	}
+	addr, err := dbp.staticAddr(instructions)
+	if err != nil {
+		return 0, nil, err
+	}
+	return addr, t, nil
+}
+
+// Strips any typedefs from t.
+func resolveTypedef(t dwarf.Type) dwarf.Type {
+	for {
+		tt, ok := t.(*dwarf.TypedefType)
+		if !ok {
+			return t
+		}
+		t = tt.Type
+	}
+}
+
+// Reads the named signed integer field of the struct of type t located
+// at addr. Integers wrapped in a struct, such as atomic.Int32, are
+// unwrapped.
+func (dbp *DebuggedProcess) readIntField(addr uint64, t *dwarf.StructType, name string) (int64, error) {
+	f, err := structField(t, name)
+	if err != nil {
+		return 0, err
+	}
+	addr += uint64(f.ByteOffset)
+	ft := resolveTypedef(f.Type)
+	if st, ok := ft.(*dwarf.StructType); ok {
+		v, err := structField(st, "v")
+		if err != nil {
+			return 0, fmt.Errorf("member %s of %s is not an integer", name, t.StructName)
+		}
+		addr += uint64(v.ByteOffset)
+		ft = v.Type
+	}
+
+	val, err := dbp.CurrentThread.readUintRaw(uintptr(addr), ft.Size())
+	if err != nil {
+		return 0, err
+	}
+	switch ft.Size() {
+	case 1:
+		return int64(int8(val)), nil
+	case 2:
+		return int64(int16(val)), nil
+	case 4:
+		return int64(int32(val)), nil
+	}
+	return int64(val), nil
+}
+
+// Reads the header of the slice of type t located at addr, returning
+// the address of its backing array, its length and capacity, and
+// the type of its elements.
+func (dbp *DebuggedProcess) sliceHeader(addr uint64, t *dwarf.StructType) (uint64, uint64, uint64, dwarf.Type, error) {
+	array, err := dbp.readUintField(addr, t, "array")
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	length, err := dbp.readUintField(addr, t, "len")
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+	capacity, err := dbp.readUintField(addr, t, "cap")
+	if err != nil {
+		return 0, 0, 0, nil, err
+	}
+
+	f, _ := structField(t, "array")
+	ptr, ok := resolveTypedef(f.Type).(*dwarf.PtrType)
+	if !ok {
+		return 0, 0, 0, nil, fmt.Errorf("invalid type %s in slice array", f.Type)
+	}
+	return array, length, capacity, ptr.Type, nil
+}
+
+// Returns the type with the given name, e.g. "*main.Foo" or "int",
+// as described by the debug info of the process.
+func (dbp *DebuggedProcess) findType(name string) (dwarf.Type, error) {
+	entries, err := dbp.typeEntries(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return dbp.Dwarf.Type(entries[0].Offset)
+	}
+	return nil, fmt.Errorf("could not find type %s", name)
+}
@@ -0,0 +1,61 @@
+package proctl
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Returns the names of the functions of the program matching the
+// regular expression filter, all of them when it is empty, sorted.
+func (dbp *DebuggedProcess) Funcs(filter string) ([]string, error) {
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	var funcs []string
+	for _, f := range dbp.GoSymTable.Funcs {
+		if f.Sym != nil && re.MatchString(f.Name) {
+			funcs = append(funcs, f.Name)
+		}
+	}
+	sort.Strings(funcs)
+	return funcs, nil
+}
+
+// Returns the source files of the program matching the regular
+// expression filter, all of them when it is empty, sorted.
+func (dbp *DebuggedProcess) Sources(filter string) ([]string, error) {
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for f := range dbp.GoSymTable.Files {
+		if re.MatchString(f) {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Returns the names of the types described by the debug information
+// of the program matching the regular expression filter, all of them
+// when it is empty, sorted.
+func (dbp *DebuggedProcess) Types(filter string) ([]string, error) {
+	re, err := regexp.Compile(filter)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := dbp.SymbolIndex()
+	if err != nil {
+		return nil, err
+	}
+	var types []string
+	for _, n := range idx.TypeNames() {
+		if re.MatchString(n) {
+			types = append(types, n)
+		}
+	}
+	return types, nil
+}
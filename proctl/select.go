@@ -0,0 +1,281 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+)
+
+// Maximum number of frames to unwind looking for
+// the select implementation on a goroutine's stack.
+const maxSelectDepth = 10
+
+// SelectCase describes a single case of a select statement
+// that a goroutine is blocked in.
+type SelectCase struct {
+	Chan      uint64 // Address of the channel, 0 for a nil channel or the default case
+	Direction string // "send", "recv" or "default"
+	Ready     bool   // Whether the case is able to proceed
+}
+
+func (sc *SelectCase) String() string {
+	if sc.Direction == "default" {
+		return "default"
+	}
+	ready := "blocked"
+	if sc.Ready {
+		ready = "ready"
+	}
+	return fmt.Sprintf("%s on chan %#x (%s)", sc.Direction, sc.Chan, ready)
+}
+
+// Returns the cases of the select statement g is currently blocked in.
+func (dbp *DebuggedProcess) SelectCases(g *G) ([]*SelectCase, error) {
+	frames, err := dbp.stacktrace(g.PC, g.SP, nil, maxSelectDepth)
+	if err != nil && len(frames) == 0 {
+		return nil, err
+	}
+
+	for _, frame := range frames {
+		if frame.fn == nil {
+			continue
+		}
+		if frame.fn.Name != "runtime.selectgo" && frame.fn.Name != "runtime.selectgoImpl" {
+			continue
+		}
+		if _, _, err := dbp.frameVariable(frame, "cas0"); err == nil {
+			return dbp.selectCases(frame)
+		}
+		return dbp.hselectCases(frame)
+	}
+
+	return nil, fmt.Errorf("goroutine %d is not blocked in a select", g.Id)
+}
+
+// Decodes the cases of a select statement in runtimes where selectgo
+// takes the scase array directly. Older versions of these runtimes
+// record the direction in scase.kind (caseNil, caseRecv, caseSend,
+// caseDefault), newer ones order all sends before all receives.
+func (dbp *DebuggedProcess) selectCases(frame stackFrame) ([]*SelectCase, error) {
+	cas0, err := dbp.framePointer(frame, "cas0")
+	if err != nil {
+		return nil, err
+	}
+
+	scase, err := dbp.findStructType("runtime.scase")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = structField(scase, "kind")
+	haskind := err == nil
+	nsends := -1
+	if !haskind {
+		if nsends, err = dbp.frameInt(frame, "nsends"); err != nil {
+			return nil, err
+		}
+	}
+	// Runtimes without a local ncases have the arguments only.
+	ncases, err := dbp.frameInt(frame, "ncases")
+	if err != nil {
+		if haskind {
+			return nil, err
+		}
+		nrecvs, err := dbp.frameInt(frame, "nrecvs")
+		if err != nil {
+			return nil, err
+		}
+		ncases = nsends + nrecvs
+	}
+
+	cases := make([]*SelectCase, 0, ncases)
+	for i := 0; i < ncases; i++ {
+		addr := cas0 + uint64(i)*uint64(scase.ByteSize)
+
+		var dir string
+		if haskind {
+			kind, err := dbp.readUintField(addr, scase, "kind")
+			if err != nil {
+				return nil, err
+			}
+			switch kind {
+			case 1:
+				dir = "recv"
+			case 2:
+				dir = "send"
+			case 3:
+				dir = "default"
+			default:
+				continue
+			}
+		} else if i < nsends {
+			dir = "send"
+		} else {
+			dir = "recv"
+		}
+
+		c, err := dbp.newSelectCase(addr, scase, dir)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+
+	return cases, nil
+}
+
+// Decodes the cases of a select statement in runtimes where selectgo
+// takes an hselect structure. These runtimes number scase.kind as
+// caseRecv, caseSend, caseDefault.
+func (dbp *DebuggedProcess) hselectCases(frame stackFrame) ([]*SelectCase, error) {
+	sel, err := dbp.framePointer(frame, "sel")
+	if err != nil {
+		return nil, err
+	}
+
+	hselect, err := dbp.findStructType("runtime.hselect")
+	if err != nil {
+		return nil, err
+	}
+	scase, err := dbp.findStructType("runtime.scase")
+	if err != nil {
+		return nil, err
+	}
+
+	ncase, err := dbp.readUintField(sel, hselect, "ncase")
+	if err != nil {
+		return nil, err
+	}
+	field, err := structField(hselect, "scase")
+	if err != nil {
+		return nil, err
+	}
+
+	cases := make([]*SelectCase, 0, ncase)
+	for i := uint64(0); i < ncase; i++ {
+		addr := sel + uint64(field.ByteOffset) + i*uint64(scase.ByteSize)
+		kind, err := dbp.readUintField(addr, scase, "kind")
+		if err != nil {
+			return nil, err
+		}
+
+		var dir string
+		switch kind {
+		case 0:
+			dir = "recv"
+		case 1:
+			dir = "send"
+		default:
+			dir = "default"
+		}
+
+		c, err := dbp.newSelectCase(addr, scase, dir)
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, c)
+	}
+
+	return cases, nil
+}
+
+func (dbp *DebuggedProcess) newSelectCase(addr uint64, scase *dwarf.StructType, dir string) (*SelectCase, error) {
+	if dir == "default" {
+		return &SelectCase{Direction: dir, Ready: true}, nil
+	}
+
+	ch, err := dbp.readUintField(addr, scase, "c")
+	if err != nil {
+		return nil, err
+	}
+	ready, err := dbp.chanReady(ch, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SelectCase{Chan: ch, Direction: dir, Ready: ready}, nil
+}
+
+// Returns whether an operation in direction dir ("send" or "recv")
+// on the channel at addr could proceed without blocking.
+func (dbp *DebuggedProcess) chanReady(addr uint64, dir string) (bool, error) {
+	// Operations on nil channels block forever.
+	if addr == 0 {
+		return false, nil
+	}
+
+	hchan, err := dbp.findStructType("runtime.hchan")
+	if err != nil {
+		return false, err
+	}
+
+	closed, err := dbp.readUintField(addr, hchan, "closed")
+	if err != nil {
+		return false, err
+	}
+	if closed != 0 {
+		return true, nil
+	}
+
+	qcount, err := dbp.readUintField(addr, hchan, "qcount")
+	if err != nil {
+		return false, err
+	}
+
+	if dir == "recv" {
+		waiting, err := dbp.waitqNonEmpty(addr, hchan, "sendq")
+		if err != nil {
+			return false, err
+		}
+		return qcount > 0 || waiting, nil
+	}
+
+	dataqsiz, err := dbp.readUintField(addr, hchan, "dataqsiz")
+	if err != nil {
+		return false, err
+	}
+	waiting, err := dbp.waitqNonEmpty(addr, hchan, "recvq")
+	if err != nil {
+		return false, err
+	}
+	return qcount < dataqsiz || waiting, nil
+}
+
+// Returns whether the named wait queue of the channel at addr
+// has any goroutines waiting on it.
+func (dbp *DebuggedProcess) waitqNonEmpty(addr uint64, hchan *dwarf.StructType, name string) (bool, error) {
+	field, err := structField(hchan, name)
+	if err != nil {
+		return false, err
+	}
+	waitq, ok := resolveTypedef(field.Type).(*dwarf.StructType)
+	if !ok {
+		return false, fmt.Errorf("unexpected type %s for %s", field.Type, name)
+	}
+	first, err := dbp.readUintField(addr+uint64(field.ByteOffset), waitq, "first")
+	if err != nil {
+		return false, err
+	}
+	return first != 0, nil
+}
+
+// Reads the pointer valued argument or local variable name of frame.
+func (dbp *DebuggedProcess) framePointer(frame stackFrame, name string) (uint64, error) {
+	addr, _, err := dbp.frameVariable(frame, name)
+	if err != nil {
+		return 0, err
+	}
+	return dbp.readPointer(addr)
+}
+
+// Reads the integer valued argument or local variable name of frame.
+func (dbp *DebuggedProcess) frameInt(frame stackFrame, name string) (int, error) {
+	addr, t, err := dbp.frameVariable(frame, name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dbp.CurrentThread.readUintRaw(uintptr(addr), t.Size())
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
@@ -0,0 +1,189 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"strings"
+)
+
+// Sets the variable denoted by the expression name to value. The value
+// is either a literal, nil, &x, or an expression of the same type as
+// the variable, whose contents are copied.
+//
+// The debugger cannot allocate memory in the process, so strings can
+// only be set to "" or to the value of another string, with which they
+// then share their contents.
+func (thread *ThreadContext) SetSymbol(name, value string) error {
+	lhs, err := parseExpr(name)
+	if err != nil {
+		return err
+	}
+	addr, t, err := thread.evalAddr(lhs)
+	if err != nil {
+		return err
+	}
+	rhs, err := parseExpr(value)
+	if err != nil {
+		return err
+	}
+
+	data, err := thread.encodeValue(rhs, t)
+	if err != nil {
+		return fmt.Errorf("cannot use %s as type %s in assignment: %s", value, t, err)
+	}
+	return thread.writeMemory(uintptr(addr), data)
+}
+
+// Returns the representation in memory of expr, converted
+// to a value of type t.
+func (thread *ThreadContext) encodeValue(expr ast.Expr, t dwarf.Type) ([]byte, error) {
+	if p, ok := expr.(*ast.ParenExpr); ok {
+		return thread.encodeValue(p.X, t)
+	}
+
+	size := typeSize(t)
+	if size <= 0 {
+		return nil, fmt.Errorf("unknown size")
+	}
+	if id, ok := expr.(*ast.Ident); ok && id.Name == "nil" {
+		if !nillable(t) {
+			return nil, fmt.Errorf("nil is not a valid value")
+		}
+		return make([]byte, size), nil
+	}
+
+	switch typ := resolveTypedef(t).(type) {
+	case *dwarf.IntType, *dwarf.UintType, *dwarf.FloatType, *dwarf.BoolType:
+		v, err := thread.evalScalar(expr)
+		if err != nil {
+			return nil, err
+		}
+		return encodeScalar(v, typ)
+	case *dwarf.PtrType:
+		if u, ok := expr.(*ast.UnaryExpr); ok && u.Op == token.AND {
+			addr, _, err := thread.evalAddr(u.X)
+			if err != nil {
+				return nil, err
+			}
+			return encodeUint(addr, size), nil
+		}
+		if lit, ok := expr.(*ast.BasicLit); ok && lit.Kind == token.INT {
+			v, err := thread.evalScalar(lit)
+			if err != nil {
+				return nil, err
+			}
+			return encodeScalar(v, &dwarf.UintType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: size}}})
+		}
+	case *dwarf.StructType:
+		if lit, ok := expr.(*ast.BasicLit); ok && typ.StructName == "string" {
+			if lit.Kind != token.STRING || lit.Value != `""` {
+				return nil, fmt.Errorf("cannot allocate memory for a new string")
+			}
+			return make([]byte, size), nil
+		}
+	}
+
+	// Copy the value of an expression of the same type.
+	addr, src, err := thread.evalAddr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if !sameRepresentation(src, t) {
+		return nil, fmt.Errorf("mismatched type %s", src)
+	}
+	return thread.readMemory(uintptr(addr), uintptr(size))
+}
+
+// Returns true if nil can be assigned to values of type t.
+func nillable(t dwarf.Type) bool {
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.PtrType, *dwarf.FuncType:
+		return true
+	case *dwarf.StructType:
+		return strings.HasPrefix(t.StructName, "[]") || isInterfaceType(t) || isMapType(t)
+	}
+	return false
+}
+
+// Converts a value returned by evalScalar to the basic type t, checking
+// it is representable by t, and returns its representation in memory.
+func encodeScalar(v interface{}, t dwarf.Type) ([]byte, error) {
+	size := t.Size()
+	switch t.(type) {
+	case *dwarf.IntType:
+		var n int64
+		switch v := v.(type) {
+		case int64:
+			n = v
+		case uint64:
+			if v > math.MaxInt64 {
+				return nil, fmt.Errorf("%d overflows %s", v, t)
+			}
+			n = int64(v)
+		default:
+			return nil, fmt.Errorf("not an integer")
+		}
+		shift := uint(64 - 8*size)
+		if n<<shift>>shift != n {
+			return nil, fmt.Errorf("%d overflows %s", n, t)
+		}
+		return encodeUint(uint64(n), size), nil
+	case *dwarf.UintType:
+		var n uint64
+		switch v := v.(type) {
+		case int64:
+			if v < 0 {
+				return nil, fmt.Errorf("%d overflows %s", v, t)
+			}
+			n = uint64(v)
+		case uint64:
+			n = v
+		default:
+			return nil, fmt.Errorf("not an integer")
+		}
+		if size < 8 && n>>uint(8*size) != 0 {
+			return nil, fmt.Errorf("%d overflows %s", n, t)
+		}
+		return encodeUint(n, size), nil
+	case *dwarf.FloatType:
+		var f float64
+		switch v := v.(type) {
+		case int64:
+			f = float64(v)
+		case uint64:
+			f = float64(v)
+		case float64:
+			f = v
+		default:
+			return nil, fmt.Errorf("not a number")
+		}
+		if size == 4 {
+			return encodeUint(uint64(math.Float32bits(float32(f))), size), nil
+		}
+		return encodeUint(math.Float64bits(f), size), nil
+	case *dwarf.BoolType:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("not a bool")
+		}
+		if b {
+			return encodeUint(1, size), nil
+		}
+		return encodeUint(0, size), nil
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// Returns the size bytes long little endian representation of n.
+func encodeUint(n uint64, size int64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, n)
+	if size < 8 {
+		return buf[:size]
+	}
+	return append(buf, make([]byte, size-8)...)
+}
@@ -0,0 +1,128 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	sys "golang.org/x/sys/unix"
+)
+
+// What happens to a signal the process receives, on linux. SIGTRAP
+// and SIGSTOP are used by the debugger itself and have no policy.
+type SignalPolicy int
+
+const (
+	// Deliver the signal to the process without stopping.
+	SignalPass SignalPolicy = iota
+	// Discard the signal, the process never sees it.
+	SignalIgnore
+	// Stop the process, with StopSignal. The signal is delivered
+	// once the thread that received it is resumed.
+	SignalStop
+)
+
+func (sp SignalPolicy) String() string {
+	switch sp {
+	case SignalPass:
+		return "pass"
+	case SignalIgnore:
+		return "ignore"
+	case SignalStop:
+		return "stop"
+	}
+	return "unknown"
+}
+
+// Sets what happens to sig when the process receives it. Signals are
+// passed by default.
+func (dbp *DebuggedProcess) SetSignalPolicy(sig syscall.Signal, sp SignalPolicy) error {
+	if name := dbp.backend.info().Name; name != "native" {
+		return fmt.Errorf("signal policies are only supported for live processes, not %s targets", name)
+	}
+	if sig == sys.SIGTRAP || sig == sys.SIGSTOP {
+		return fmt.Errorf("%s is used by the debugger and can't be given a policy", SignalName(sig))
+	}
+	if sig == sys.SIGKILL {
+		return fmt.Errorf("%s can't be intercepted", SignalName(sig))
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.signals == nil {
+		dbp.signals = make(map[syscall.Signal]SignalPolicy)
+	}
+	if sp == SignalPass {
+		delete(dbp.signals, sig)
+	} else {
+		dbp.signals[sig] = sp
+	}
+	return nil
+}
+
+// Returns what happens to sig when the process receives it.
+func (dbp *DebuggedProcess) SignalPolicy(sig syscall.Signal) SignalPolicy {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.signals[sig]
+}
+
+// Returns the signals which aren't passed to the process, by number.
+func (dbp *DebuggedProcess) Signals() []syscall.Signal {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	sigs := make([]syscall.Signal, 0, len(dbp.signals))
+	for sig := range dbp.signals {
+		sigs = append(sigs, sig)
+	}
+	sort.Sort(bySignal(sigs))
+	return sigs
+}
+
+type bySignal []syscall.Signal
+
+func (s bySignal) Len() int           { return len(s) }
+func (s bySignal) Less(i, j int) bool { return s[i] < s[j] }
+func (s bySignal) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the signal the process last stopped for, see SignalStop.
+func (dbp *DebuggedProcess) LastSignal() syscall.Signal {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.lastSignal
+}
+
+// Records that thread stopped for sig, which it receives once resumed.
+func (dbp *DebuggedProcess) signalStopped(thread *ThreadContext, sig syscall.Signal) {
+	thread.signal = sig
+	dbp.mu.Lock()
+	dbp.lastSignal = sig
+	dbp.stopReason = StopSignal
+	dbp.mu.Unlock()
+	dbp.emit(Event{Kind: EventSignal, Thread: thread.Id, Signal: sig})
+}
+
+// Returns the signal with the given name, with or without the SIG
+// prefix, or number, e.g. SIGUSR1, usr1 or 10.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := sys.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %s", name)
+}
+
+// Returns the name of sig, e.g. SIGUSR1.
+func SignalName(sig syscall.Signal) string {
+	if name := sys.SignalName(sig); name != "" {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
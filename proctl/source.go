@@ -0,0 +1,95 @@
+package proctl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// A line of source code, see ListSource.
+type SourceLine struct {
+	Line        int
+	Text        string
+	Current     bool  // The current thread is stopped on the line
+	BreakPoints []int // IDs of the breakpoints set on the line
+}
+
+// Lines of a source file around a location, see ListSource.
+type SourceListing struct {
+	File  string
+	Line  int // The line of the location listed
+	Lines []SourceLine
+}
+
+func (sl *SourceListing) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:%d\n", sl.File, sl.Line)
+	for _, l := range sl.Lines {
+		arrow := "  "
+		if l.Current {
+			arrow = "=>"
+		}
+		mark := " "
+		if len(l.BreakPoints) > 0 {
+			mark = "*"
+		}
+		fmt.Fprintf(&buf, "%s%s %d: %s\n", arrow, mark, l.Line, l.Text)
+	}
+	return buf.String()
+}
+
+// Returns the source lines around loc, a location as accepted by
+// FindLocation or the current PC when empty, with up to context lines
+// before and after it. Lines are annotated with the breakpoints set on
+// them and whether the current thread is stopped there.
+func (dbp *DebuggedProcess) ListSource(loc string, context int) (*SourceListing, error) {
+	current, err := dbp.CurrentPC()
+	pc := current
+	if loc != "" {
+		pc, err = dbp.FindLocation(loc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	file, line, fn := dbp.GoSymTable.PCToLine(pc)
+	if fn == nil {
+		return nil, fmt.Errorf("no source for %#x", pc)
+	}
+
+	sl := &SourceListing{File: file, Line: line}
+	currentLine := -1
+	if f, l, _ := dbp.GoSymTable.PCToLine(current); f == file {
+		currentLine = l
+	}
+	bps := make(map[int][]int)
+	dbp.mu.RLock()
+	for _, bp := range dbp.userBreakpoints() {
+		if bp.Variable == "" && bp.File == file {
+			bps[bp.Line] = append(bps[bp.Line], bp.ID)
+		}
+	}
+	dbp.mu.RUnlock()
+
+	f, err := os.Open(dbp.LocalPath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan() && n <= line+context; n++ {
+		if n < line-context {
+			continue
+		}
+		sl.Lines = append(sl.Lines, SourceLine{
+			Line:        n,
+			Text:        s.Text(),
+			Current:     n == currentLine,
+			BreakPoints: bps[n],
+		})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
@@ -0,0 +1,421 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+
+	"github.com/derekparker/delve/dwarf/op"
+)
+
+// A single frame of a stack trace. The frame is identified by the
+// PC executing in it and its canonical frame address.
+type stackFrame struct {
+	pc  uint64
+	cfa uint64
+	fn  *gosym.Func
+}
+
+// Returned along with the frames unwound so far when neither frame
+// information nor a frame pointer lead to the caller of a frame, or
+// the stack they point to can't be read, e.g. as it is corrupt.
+type UnwindError struct {
+	PC  uint64 // Of the frame unwinding stopped at
+	Err error
+}
+
+func (ue UnwindError) Error() string {
+	return fmt.Sprintf("could not unwind the stack past %#x: %s", ue.PC, ue.Err)
+}
+
+// Unwinds the stack starting from the given pc and sp, returning at
+// most depth frames. Unwinding stops early at the bottom of the stack,
+// or with an UnwindError along with the frames found until then when
+// the caller of a frame can't be found. regs are the registers of the
+// thread running the first frame, if any, whose return address may not
+// be on the stack yet and whose frame pointer C code may be unwound
+// through.
+func (dbp *DebuggedProcess) stacktrace(pc, sp uint64, regs Registers, depth int) ([]stackFrame, error) {
+	var bp uint64
+	if regs != nil {
+		bp, _ = dwarfRegister(regs, dwarfFPRegister)
+	}
+
+	frames := make([]stackFrame, 0, depth)
+	for len(frames) < depth {
+		fn := dbp.GoSymTable.PCToFunc(pc)
+		if fn != nil && fn.Name == "runtime.asmcgocall" && len(frames) > 0 {
+			// Back from the system stack C code ran on to the
+			// stack of the goroutine which called it.
+			if gsp, ok := dbp.asmcgocallSP(sp); ok {
+				cfa := gsp + asmcgocallFrameSize
+				frames = append(frames, stackFrame{pc: pc, cfa: cfa, fn: fn})
+				ret, err := dbp.readPointer(gsp + asmcgocallRetOffset)
+				if err != nil {
+					return frames, UnwindError{PC: pc, Err: err}
+				}
+				pc, sp = ret, cfa
+				continue
+			}
+		}
+
+		fde, err := dbp.FrameEntries.FDEForPC(pc)
+		if err != nil {
+			// Code without frame information, as that of the C
+			// libraries, is assumed to keep a frame pointer.
+			if bp == 0 {
+				return frames, UnwindError{PC: pc, Err: err}
+			}
+			cfa := bp + 2*uint64(ptrsize)
+			frames = append(frames, stackFrame{pc: pc, cfa: cfa, fn: fn})
+			ret, err := dbp.readPointer(bp + uint64(ptrsize))
+			if err != nil {
+				return frames, UnwindError{PC: pc, Err: err}
+			}
+			if bp, err = dbp.readPointer(bp); err != nil {
+				return frames, UnwindError{PC: pc, Err: err}
+			}
+			if ret == 0 {
+				break
+			}
+			pc, sp = ret, cfa
+			continue
+		}
+
+		fctx := fde.EstablishFrame(pc)
+		base := sp
+		if fctx.CFARegister() == dwarfFPRegister {
+			if bp == 0 {
+				return frames, UnwindError{PC: pc, Err: fmt.Errorf("no frame pointer")}
+			}
+			base = bp
+		}
+		cfa := uint64(int64(base) + fctx.CFAOffset())
+		frames = append(frames, stackFrame{pc: pc, cfa: cfa, fn: fn})
+		if fn != nil && fn.Name == "runtime.goexit" {
+			break
+		}
+		if fn != nil && stackSwitchFuncs[fn.Name] && regs != nil {
+			// Back from the system stack to the stack of the
+			// goroutine, where it was when it switched.
+			if gpc, gsp, gbp, ok := dbp.switchedFrom(regs, sp, fn.Name); ok {
+				pc, sp, bp = gpc, gsp, gbp
+				continue
+			}
+		}
+
+		var ret uint64
+		if off, ok := fctx.SavedRegisterOffset(fde.CIE.ReturnAddressRegister); ok {
+			if ret, err = dbp.readPointer(uint64(int64(cfa) + off)); err != nil {
+				return frames, UnwindError{PC: pc, Err: err}
+			}
+		} else if len(frames) == 1 && regs != nil {
+			ret, _ = dwarfRegister(regs, fde.CIE.ReturnAddressRegister)
+		}
+		if off, ok := fctx.SavedRegisterOffset(dwarfFPRegister); ok {
+			if bp, err = dbp.readPointer(uint64(int64(cfa) + off)); err != nil {
+				return frames, UnwindError{PC: pc, Err: err}
+			}
+		}
+		if ret == 0 {
+			break
+		}
+		pc, sp = ret, cfa
+	}
+	return frames, nil
+}
+
+// Returns the stack of every live goroutine, at most depth frames
+// each, as the traceback a Go program prints on SIGQUIT does, but
+// without the program taking part. Goroutines running on a thread
+// are unwound from its registers, the others from where they were
+// parked. A goroutine whose stack can't be unwound past some frame
+// gets the frames up to it, along with the error.
+func (dbp *DebuggedProcess) AllStacktraces(depth int) ([]GoroutineStack, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	goroutines, err := dbp.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+	running := make(map[int]*ThreadContext)
+	for _, th := range dbp.Threads {
+		if g, err := th.CurrentGoroutine(); err == nil {
+			running[g.Id] = th
+		}
+	}
+
+	var stacks []GoroutineStack
+	for _, g := range goroutines {
+		if g.status == gstatusDead {
+			continue
+		}
+		var (
+			frames []stackFrame
+			err    error
+		)
+		// One more frame than asked for tells whether the stack
+		// is deeper than depth.
+		if th, ok := running[g.Id]; ok {
+			var regs Registers
+			if regs, err = th.Registers(); err == nil {
+				frames, err = dbp.stacktrace(regs.PC(), regs.SP(), regs, depth+1)
+			}
+		} else {
+			frames, err = dbp.stacktrace(g.PC, g.SP, nil, depth+1)
+		}
+		gs := GoroutineStack{G: g, Status: dbp.GoroutineState(g), Err: err}
+		if len(frames) > depth {
+			frames = frames[:depth]
+			gs.Truncated, gs.Err = true, nil
+		}
+		gs.Frames = dbp.frames(frames)
+		stacks = append(stacks, gs)
+	}
+	return stacks, nil
+}
+
+// Returns at most depth frames of the stack of the goroutine running
+// on the thread, or of the thread itself when it runs none. When the
+// stack can't be unwound past some frame, the frames up to it are
+// returned along with an UnwindError.
+func (thread *ThreadContext) Stacktrace(depth int) ([]Frame, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	frames, err := thread.Process.stacktrace(regs.PC(), regs.SP(), regs, depth)
+	return thread.Process.frames(frames), err
+}
+
+// Selects the frame of the stack of the thread, 0 being the innermost,
+// whose function EvalSymbol, LocalVariables and FunctionArguments look
+// variables up in, as gdb's frame command does. The innermost frame is
+// selected again as the process is resumed.
+func (thread *ThreadContext) SelectFrame(n int) error {
+	if n < 0 {
+		return fmt.Errorf("invalid frame %d", n)
+	}
+	regs, err := thread.Registers()
+	if err != nil {
+		return err
+	}
+	frames, err := thread.Process.stacktrace(regs.PC(), regs.SP(), regs, n+1)
+	if n >= len(frames) {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("no frame %d, the stack has %d frames", n, len(frames))
+	}
+	thread.frame = n
+	return nil
+}
+
+// Returns the frame selected by SelectFrame.
+func (thread *ThreadContext) SelectedFrame() int {
+	return thread.frame
+}
+
+// Returns the frame selected by SelectFrame, of the stack of the
+// thread with the registers regs.
+func (thread *ThreadContext) selectedFrame(regs Registers) (stackFrame, error) {
+	frames, err := thread.Process.stacktrace(regs.PC(), regs.SP(), regs, thread.frame+1)
+	if thread.frame >= len(frames) {
+		if err != nil {
+			return stackFrame{}, err
+		}
+		return stackFrame{}, fmt.Errorf("no frame %d, the stack has %d frames", thread.frame, len(frames))
+	}
+	return frames[thread.frame], nil
+}
+
+// Returns the PC variables are looked up at in the frame selected by
+// SelectFrame.
+func (thread *ThreadContext) scopePC() (uint64, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return 0, err
+	}
+	if thread.frame == 0 {
+		return regs.PC(), nil
+	}
+	frame, err := thread.selectedFrame(regs)
+	if err != nil {
+		return 0, err
+	}
+	return scopePC(frame), nil
+}
+
+// Returns the PC variables are looked up at in an outer frame, within
+// the call it made: the return address which is its PC may already be
+// out of the scope of its variables.
+func scopePC(frame stackFrame) uint64 {
+	return frame.pc - 1
+}
+
+// Returns the source location of the frames of a stack trace.
+func (dbp *DebuggedProcess) frames(frames []stackFrame) []Frame {
+	var fs []Frame
+	for _, sf := range frames {
+		f := Frame{PC: sf.pc}
+		f.File, f.Line, _ = dbp.GoSymTable.PCToLine(sf.pc)
+		if sf.fn != nil {
+			f.Function = sf.fn.Name
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// Returns the stack pointer of the goroutine runtime.asmcgocall called
+// C code for, from the stack pointer of the system stack it switched
+// to as the C code returns to it. Returns false if it didn't switch
+// stacks, having been called on the system stack.
+func (dbp *DebuggedProcess) asmcgocallSP(sp uint64) (uint64, bool) {
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return 0, false
+	}
+	g, err := dbp.parseG(sp+asmcgocallGOffset, gtype)
+	if err != nil {
+		return 0, false
+	}
+	depth, err := dbp.readPointer(sp + asmcgocallDepthOffset)
+	if err != nil || depth > g.stackhi-g.stacklo {
+		return 0, false
+	}
+	gsp := g.stackhi - depth
+	if gsp == sp {
+		return 0, false
+	}
+	return gsp, true
+}
+
+// Functions switching from the stack of a goroutine to the system stack
+// of its thread, g0, having saved where the goroutine was in its
+// g.sched: to grow its stack, to run runtime code and to park it.
+var stackSwitchFuncs = map[string]bool{
+	"runtime.morestack":   true,
+	"runtime.systemstack": true,
+	"runtime.mcall":       true,
+}
+
+// Returns the pc, sp and frame pointer the goroutine of the thread with
+// the registers regs was at as it switched to the system stack, which
+// fn runs on at sp, from the g.sched of the goroutine, the curg of the
+// m of g0. Returns false if fn didn't switch stacks, e.g. being called
+// on the system stack already or the thread running no goroutine.
+func (dbp *DebuggedProcess) switchedFrom(regs Registers, sp uint64, fn string) (uint64, uint64, uint64, bool) {
+	tls := regs.TLS()
+	if tls == 0 {
+		return 0, 0, 0, false
+	}
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	mtype, err := dbp.findStructType("runtime.m")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	g0, err := dbp.readPointer(uint64(int64(tls) + tlsGOffset))
+	if err != nil || g0 == 0 {
+		return 0, 0, 0, false
+	}
+	m, err := dbp.readUintField(g0, gtype, "m")
+	if err != nil || m == 0 {
+		return 0, 0, 0, false
+	}
+	f, err := structField(mtype, "curg")
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if curg, err := dbp.readPointer(m + uint64(f.ByteOffset)); err != nil || curg == 0 || curg == g0 {
+		return 0, 0, 0, false
+	}
+	g, err := dbp.parseG(m+uint64(f.ByteOffset), gtype)
+	if err != nil || (g.stacklo <= sp && sp < g.stackhi) {
+		return 0, 0, 0, false
+	}
+	// systemstack leaves systemstack_switch in g.sched, unless it was
+	// called on the system stack, in which case g.sched is that of
+	// whichever switch happened before.
+	if fn == "runtime.systemstack" && (g.Func == nil || g.Func.Name != "runtime.systemstack_switch") {
+		return 0, 0, 0, false
+	}
+
+	var bp uint64
+	if sched, err := structField(gtype, "sched"); err == nil {
+		if gobuf, ok := resolveTypedef(sched.Type).(*dwarf.StructType); ok {
+			bp, _ = dbp.readUintField(g.addr+uint64(sched.ByteOffset), gobuf, "bp")
+		}
+	}
+	return g.PC, g.SP, bp, true
+}
+
+// A named argument or local variable of the function executing in a
+// frame, and where it is stored.
+type frameVar struct {
+	name string
+	tag  dwarf.Tag
+	addr uint64
+	typ  dwarf.Type
+}
+
+// Returns the address and type of the named argument or local
+// variable of the function executing in frame.
+func (dbp *DebuggedProcess) frameVariable(frame stackFrame, name string) (uint64, dwarf.Type, error) {
+	vars, err := dbp.frameVariables(frame)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, v := range vars {
+		if v.name == name {
+			return v.addr, v.typ, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("could not find symbol value for %s", name)
+}
+
+// Returns the arguments and local variables of the function
+// executing in frame, in the order they are declared. Variables
+// whose type or location can't be read are left out.
+func (dbp *DebuggedProcess) frameVariables(frame stackFrame) ([]frameVar, error) {
+	reader := dbp.DwarfReader()
+	if _, err := reader.SeekToFunction(dbp.dwarfPC(frame.pc)); err != nil {
+		return nil, err
+	}
+
+	var vars []frameVar
+	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := entry.Val(dwarf.AttrName).(string)
+		if !ok {
+			continue
+		}
+
+		offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			continue
+		}
+		t, err := dbp.Dwarf.Type(offset)
+		if err != nil {
+			continue
+		}
+
+		instructions, err := dbp.locationExpr(entry, frame.pc)
+		if err != nil || len(instructions) == 0 {
+			continue
+		}
+		addr, err := op.ExecuteStackProgram(int64(frame.cfa), instructions)
+		if err != nil {
+			continue
+		}
+		vars = append(vars, frameVar{n, entry.Tag, uint64(addr), t})
+	}
+	return vars, nil
+}
@@ -0,0 +1,101 @@
+package proctl
+
+import (
+	"debug/gosym"
+	"fmt"
+)
+
+// Start and end of a function, and the rows of the line table
+// covering its instructions.
+type FunctionExtents struct {
+	Name  string
+	Entry uint64
+	End   uint64 // Address just past the last instruction
+	Lines []LineRow
+}
+
+// A row of the line table: the instructions from PC up to the PC
+// of the next row were compiled from File:Line.
+type LineRow struct {
+	PC   uint64
+	File string
+	Line int
+}
+
+// Go symbol table of a binary, read from its file.
+type Symbols struct {
+	table *gosym.Table
+}
+
+// Reads the Go symbol table of the ELF or Mach-O binary at path,
+// without starting it, for tools that only need symbol information.
+func OpenSymbols(path string) (*Symbols, error) {
+	sections, _, closer, err := (&Diagnostics{}).openBinary(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	var (
+		symdat, pclndat []byte
+		text            uint64
+	)
+	for _, s := range sections {
+		switch s.Name {
+		case ".gosymtab", "__gosymtab":
+			symdat, err = sectionData(s)
+		case ".gopclntab", "__gopclntab":
+			pclndat, err = sectionData(s)
+		case ".text", "__text":
+			text = s.addr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s section: %s", s.Name, err)
+		}
+	}
+	if pclndat == nil {
+		return nil, fmt.Errorf("%s has no Go line table", path)
+	}
+
+	tab, err := gosym.NewTable(symdat, gosym.NewLineTable(pclndat, text))
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize line table: %s", err)
+	}
+	return &Symbols{tab}, nil
+}
+
+// Returns the extents of the function name, e.g. "main.main".
+func (s *Symbols) FunctionExtents(name string) (*FunctionExtents, error) {
+	return functionExtents(s.table, name)
+}
+
+// Returns the extents of the function name, e.g. "main.main".
+func (dbp *DebuggedProcess) FunctionExtents(name string) (*FunctionExtents, error) {
+	return functionExtents(dbp.GoSymTable, name)
+}
+
+func functionExtents(table *gosym.Table, name string) (*FunctionExtents, error) {
+	fn := table.LookupFunc(name)
+	if fn == nil {
+		return nil, fmt.Errorf("could not find function %s", name)
+	}
+
+	ext := &FunctionExtents{Name: fn.Name, Entry: fn.Entry, End: fn.End}
+	for pc := fn.Entry; pc < fn.End; pc++ {
+		f, l, _ := table.PCToLine(pc)
+		if n := len(ext.Lines); n > 0 && ext.Lines[n-1].File == f && ext.Lines[n-1].Line == l {
+			continue
+		}
+		ext.Lines = append(ext.Lines, LineRow{pc, f, l})
+	}
+	return ext, nil
+}
+
+// Returns the contents of the section s.
+func sectionData(s binarySection) ([]byte, error) {
+	buf := make([]byte, s.Size)
+	if _, err := s.r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,332 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Bits of the sync.Mutex state word.
+const (
+	mutexLocked = 1 << iota
+	mutexWoken
+	mutexStarving
+)
+
+// Bias sync.RWMutex subtracts from readerCount while a writer is pending.
+const rwmutexMaxReaders = 1 << 30
+
+// Upper bound on the number of sudogs visited walking a semaphore
+// queue, guards against looping over a queue being modified.
+const maxSemaWaiters = 10000
+
+// Decoded state of a sync.Mutex.
+type mutexState struct {
+	locked   bool
+	woken    bool
+	starving bool
+	waiters  int64
+	// Ids of the goroutines blocked on the semaphore of the mutex,
+	// nil if the semaphore tables could not be read.
+	waiting []int
+}
+
+// Returns a description of the state of the sync.Mutex,
+// sync.RWMutex or sync.WaitGroup of type t located at addr.
+func (thread *ThreadContext) readSyncPrimitive(addr uint64, t *dwarf.StructType) (string, error) {
+	switch t.StructName {
+	case "sync.RWMutex":
+		return thread.Process.rwmutexState(addr, t)
+	case "sync.WaitGroup":
+		return thread.Process.waitGroupState(addr, t)
+	}
+	ms, err := thread.Process.mutexState(addr, t)
+	if err != nil {
+		return "", err
+	}
+	return ms.String(), nil
+}
+
+// Decodes the sync.Mutex of type t located at addr.
+func (dbp *DebuggedProcess) mutexState(addr uint64, t *dwarf.StructType) (*mutexState, error) {
+	if mu, err := structField(t, "mu"); err == nil {
+		// Since Go 1.24, a sync.Mutex wraps an internal/sync.Mutex.
+		if mt, ok := resolveTypedef(mu.Type).(*dwarf.StructType); ok {
+			addr += uint64(mu.ByteOffset)
+			t = mt
+		}
+	}
+	state, err := dbp.readIntField(addr, t, "state")
+	if err != nil {
+		return nil, err
+	}
+	sema, err := structField(t, "sema")
+	if err != nil {
+		return nil, err
+	}
+
+	// Runtimes with starvation mode use an extra bit of
+	// the state word, shifting the waiter count up.
+	starvation := dbp.lookupFunc("sync.runtime_SemacquireMutex") != nil
+	shift := uint(2)
+	if starvation {
+		shift = 3
+	}
+
+	ms := &mutexState{
+		locked:   state&mutexLocked != 0,
+		woken:    state&mutexWoken != 0,
+		starving: starvation && state&mutexStarving != 0,
+		waiters:  state >> shift,
+	}
+	if ids, err := dbp.semaWaiters(addr + uint64(sema.ByteOffset)); err == nil {
+		ms.waiting = ids
+	}
+	return ms, nil
+}
+
+func (ms *mutexState) String() string {
+	fields := []string{
+		"locked: " + strconv.FormatBool(ms.locked),
+		"waiters: " + strconv.FormatInt(ms.waiters, 10),
+	}
+	if ms.woken {
+		fields = append(fields, "woken: true")
+	}
+	if ms.starving {
+		fields = append(fields, "starving: true")
+	}
+	if ms.waiting != nil {
+		fields = append(fields, "waiting: "+formatGoroutineIds(ms.waiting))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// Decodes the sync.RWMutex of type t located at addr.
+func (dbp *DebuggedProcess) rwmutexState(addr uint64, t *dwarf.StructType) (string, error) {
+	w, err := structField(t, "w")
+	if err != nil {
+		return "", err
+	}
+	wtype, ok := resolveTypedef(w.Type).(*dwarf.StructType)
+	if !ok {
+		return "", fmt.Errorf("unexpected type %s for w", w.Type)
+	}
+	ms, err := dbp.mutexState(addr+uint64(w.ByteOffset), wtype)
+	if err != nil {
+		return "", err
+	}
+
+	readers, err := dbp.readIntField(addr, t, "readerCount")
+	if err != nil {
+		return "", err
+	}
+	// A writer holding or waiting for the lock
+	// makes readerCount negative.
+	writer := readers < 0
+	if writer {
+		readers += rwmutexMaxReaders
+	}
+
+	fields := []string{
+		"writer: " + strconv.FormatBool(writer),
+		"readers: " + strconv.FormatInt(readers, 10),
+	}
+	if readerSem, err := structField(t, "readerSem"); err == nil {
+		if ids, err := dbp.semaWaiters(addr + uint64(readerSem.ByteOffset)); err == nil {
+			fields = append(fields, "waitingReaders: "+formatGoroutineIds(ids))
+		}
+	}
+	// Writers wait on the inner mutex for other writers,
+	// and on writerSem for active readers to leave.
+	if ms.waiting != nil {
+		waiting := ms.waiting
+		if writerSem, err := structField(t, "writerSem"); err == nil {
+			if ids, err := dbp.semaWaiters(addr + uint64(writerSem.ByteOffset)); err == nil {
+				waiting = append(ids, waiting...)
+			}
+		}
+		fields = append(fields, "waitingWriters: "+formatGoroutineIds(waiting))
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+// Decodes the sync.WaitGroup of type t located at addr.
+func (dbp *DebuggedProcess) waitGroupState(addr uint64, t *dwarf.StructType) (string, error) {
+	var (
+		counter, waiters int64
+		sema             uint64
+		err              error
+	)
+
+	if _, ferr := structField(t, "counter"); ferr == nil {
+		// Separate fields, with a lazily allocated semaphore.
+		if counter, err = dbp.readIntField(addr, t, "counter"); err != nil {
+			return "", err
+		}
+		if waiters, err = dbp.readIntField(addr, t, "waiters"); err != nil {
+			return "", err
+		}
+		if sema, err = dbp.readUintField(addr, t, "sema"); err != nil {
+			return "", err
+		}
+	} else {
+		// The counter and waiter count are packed in a 64 bit state
+		// word, the counter in the high 32 bits. Depending on the
+		// runtime the word is a field of its own or is carved out of
+		// state1, at whichever end of it is 8 byte aligned, with the
+		// semaphore taking the other end.
+		var state uint64
+		if _, ferr := structField(t, "state"); ferr == nil {
+			state, err = dbp.readUintField(addr, t, "state")
+			if sem, serr := structField(t, "sema"); serr == nil {
+				sema = addr + uint64(sem.ByteOffset)
+			}
+		} else if f, ferr := structField(t, "state1"); ferr == nil {
+			base := addr + uint64(f.ByteOffset)
+			switch {
+			case f.Type.Size() == 8:
+				state, err = dbp.CurrentThread.readUintRaw(uintptr(base), 8)
+				if sem, serr := structField(t, "state2"); serr == nil {
+					sema = addr + uint64(sem.ByteOffset)
+				}
+			case base%8 == 0:
+				state, err = dbp.CurrentThread.readUintRaw(uintptr(base), 8)
+				sema = base + 8
+			default:
+				state, err = dbp.CurrentThread.readUintRaw(uintptr(base+4), 8)
+				sema = base
+			}
+		} else {
+			return "", fmt.Errorf("unknown layout for %s", t.StructName)
+		}
+		if err != nil {
+			return "", err
+		}
+		counter = int64(int32(state >> 32))
+		waiters = int64(uint32(state))
+	}
+
+	fields := []string{
+		"counter: " + strconv.FormatInt(counter, 10),
+		"waiters: " + strconv.FormatInt(waiters, 10),
+	}
+	if sema != 0 {
+		if ids, err := dbp.semaWaiters(sema); err == nil {
+			fields = append(fields, "waiting: "+formatGoroutineIds(ids))
+		}
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+// Returns the ids of the goroutines blocked on the semaphore at addr,
+// by looking them up in the runtime semaphore tables.
+func (dbp *DebuggedProcess) semaWaiters(addr uint64) ([]int, error) {
+	tableaddr, t, err := dbp.globalVariable("runtime.semtable")
+	if err != nil {
+		return nil, err
+	}
+	table, ok := resolveTypedef(t).(*dwarf.ArrayType)
+	if !ok || table.Count <= 0 {
+		return nil, fmt.Errorf("unexpected type %s for runtime.semtable", t)
+	}
+	bucket, ok := resolveTypedef(table.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for runtime.semtable", t)
+	}
+	root, err := structField(bucket, "root")
+	if err != nil {
+		return nil, err
+	}
+	roottype, ok := resolveTypedef(root.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for semaRoot", root.Type)
+	}
+	sudog, err := dbp.findStructType("runtime.sudog")
+	if err != nil {
+		return nil, err
+	}
+	gtype, err := dbp.findStructType("runtime.g")
+	if err != nil {
+		return nil, err
+	}
+
+	// Mirrors runtime.semroot.
+	idx := (addr >> 3) % uint64(table.Count)
+	rootaddr := tableaddr + idx*uint64(bucket.Size()) + uint64(root.ByteOffset)
+
+	var sudogs []uint64
+	if _, err := structField(roottype, "treap"); err == nil {
+		// Waiters are kept in a treap keyed by address, ordered
+		// through prev and next, with the waiters of the same
+		// address queued on waitlink.
+		node, err := dbp.readUintField(rootaddr, roottype, "treap")
+		if err != nil {
+			return nil, err
+		}
+		for n := 0; node != 0 && n < maxSemaWaiters; n++ {
+			elem, err := dbp.readUintField(node, sudog, "elem")
+			if err != nil {
+				return nil, err
+			}
+			if elem == addr {
+				break
+			}
+			child := "next"
+			if addr < elem {
+				child = "prev"
+			}
+			if node, err = dbp.readUintField(node, sudog, child); err != nil {
+				return nil, err
+			}
+		}
+		for n := 0; node != 0 && n < maxSemaWaiters; n++ {
+			sudogs = append(sudogs, node)
+			if node, err = dbp.readUintField(node, sudog, "waitlink"); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// Waiters of all the addresses hashing to
+		// the same root are queued through next.
+		node, err := dbp.readUintField(rootaddr, roottype, "head")
+		if err != nil {
+			return nil, err
+		}
+		for n := 0; node != 0 && n < maxSemaWaiters; n++ {
+			elem, err := dbp.readUintField(node, sudog, "elem")
+			if err != nil {
+				return nil, err
+			}
+			if elem == addr {
+				sudogs = append(sudogs, node)
+			}
+			if node, err = dbp.readUintField(node, sudog, "next"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	ids := make([]int, 0, len(sudogs))
+	for _, s := range sudogs {
+		g, err := dbp.readUintField(s, sudog, "g")
+		if err != nil {
+			return nil, err
+		}
+		goid, err := dbp.readUintField(g, gtype, "goid")
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, int(goid))
+	}
+	return ids, nil
+}
+
+func formatGoroutineIds(ids []int) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(id)
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
@@ -0,0 +1,152 @@
+package proctl
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strconv"
+	"syscall"
+)
+
+// A syscall a thread entered or returned from, see CatchSyscall.
+type SyscallStop struct {
+	Thread int
+	Number int
+	Args   [6]uint64 // The arguments it was entered with
+	Exit   bool      // Set once it returned
+	Ret    int64     // Set on exit, a negated errno when it failed
+}
+
+func (ss SyscallStop) String() string {
+	name := SyscallName(ss.Number)
+	if !ss.Exit {
+		return fmt.Sprintf("thread %d entered %s(%#x, %#x, %#x, %#x, %#x, %#x)", ss.Thread, name,
+			ss.Args[0], ss.Args[1], ss.Args[2], ss.Args[3], ss.Args[4], ss.Args[5])
+	}
+	if ss.Ret < 0 && ss.Ret > -4096 {
+		return fmt.Sprintf("thread %d returned from %s = %d (%s)", ss.Thread, name, ss.Ret, syscall.Errno(-ss.Ret))
+	}
+	return fmt.Sprintf("thread %d returned from %s = %d", ss.Thread, name, ss.Ret)
+}
+
+// A syscall caught by CatchSyscall.
+type SyscallCatch struct {
+	Number int
+	Trace  bool
+}
+
+func (sc SyscallCatch) String() string {
+	if sc.Trace {
+		return SyscallName(sc.Number) + ": trace"
+	}
+	return SyscallName(sc.Number) + ": stop"
+}
+
+// Stops the process whenever one of its threads enters or returns from
+// the syscall nr, with StopSyscall, the syscall being described by
+// LastSyscall. With trace set, the syscall is logged instead and the
+// process keeps running. An EventSyscall is emitted either way. Threads
+// are resumed with PTRACE_SYSCALL for as long as any syscall is caught,
+// so only live processes on linux can have their syscalls caught.
+func (dbp *DebuggedProcess) CatchSyscall(nr int, trace bool) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("syscalls can only be caught on linux")
+	}
+	if name := dbp.backend.info().Name; name != "native" {
+		return fmt.Errorf("syscalls can only be caught for live processes, not %s targets", name)
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.syscalls == nil {
+		dbp.syscalls = make(map[int]bool)
+	}
+	dbp.syscalls[nr] = trace
+	return nil
+}
+
+// Stops catching the syscall nr.
+func (dbp *DebuggedProcess) ClearSyscallCatch(nr int) error {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if _, ok := dbp.syscalls[nr]; !ok {
+		return fmt.Errorf("%s is not caught", SyscallName(nr))
+	}
+	delete(dbp.syscalls, nr)
+	return nil
+}
+
+// Returns the syscalls caught, by number.
+func (dbp *DebuggedProcess) SyscallCatches() []SyscallCatch {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	catches := make([]SyscallCatch, 0, len(dbp.syscalls))
+	for nr, trace := range dbp.syscalls {
+		catches = append(catches, SyscallCatch{Number: nr, Trace: trace})
+	}
+	sort.Sort(bySyscall(catches))
+	return catches
+}
+
+type bySyscall []SyscallCatch
+
+func (s bySyscall) Len() int           { return len(s) }
+func (s bySyscall) Less(i, j int) bool { return s[i].Number < s[j].Number }
+func (s bySyscall) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Returns the syscall the process last stopped for, see CatchSyscall,
+// or nil if it never did.
+func (dbp *DebuggedProcess) LastSyscall() *SyscallStop {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.lastSyscall
+}
+
+// Returns whether threads are to stop at syscalls, see CatchSyscall.
+func (dbp *DebuggedProcess) catchingSyscalls() bool {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return len(dbp.syscalls) > 0
+}
+
+// Handles the syscall stop ss, returning whether the process
+// is to stop for it.
+func (dbp *DebuggedProcess) syscallStopped(ss SyscallStop) bool {
+	dbp.mu.Lock()
+	trace, ok := dbp.syscalls[ss.Number]
+	if ok && !trace {
+		dbp.lastSyscall = &ss
+		dbp.stopReason = StopSyscall
+	}
+	dbp.mu.Unlock()
+	if !ok {
+		return false
+	}
+	if trace {
+		dbp.logger().Infof("> %s", ss)
+	}
+	dbp.emit(Event{Kind: EventSyscall, Thread: ss.Thread, Syscall: &ss})
+	return !trace
+}
+
+// Returns the number of the syscall with the given name, e.g. openat,
+// or number.
+func ParseSyscall(name string) (int, error) {
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		return n, nil
+	}
+	if n, ok := syscallNumbers[name]; ok {
+		return n, nil
+	}
+	return 0, fmt.Errorf("unknown syscall %s", name)
+}
+
+// Returns the name of the syscall nr, or its number
+// for those CatchSyscall doesn't know by name.
+func SyscallName(nr int) string {
+	for name, n := range syscallNumbers {
+		if n == nr {
+			return name
+		}
+	}
+	return fmt.Sprintf("syscall %d", nr)
+}
@@ -0,0 +1,4 @@
+package proctl
+
+// Syscalls can only be caught on linux, see CatchSyscall.
+var syscallNumbers = map[string]int{}
@@ -0,0 +1,47 @@
+package proctl
+
+// The values of ptraceSyscallInfo.Op.
+const (
+	ptraceSyscallInfoEntry = 1
+	ptraceSyscallInfoExit  = 2
+)
+
+// struct ptrace_syscall_info. Data holds the nr and args of an entry,
+// the rval and is_error of an exit.
+type ptraceSyscallInfo struct {
+	Op                 uint8
+	_                  [3]uint8
+	Arch               uint32
+	InstructionPointer uint64
+	StackPointer       uint64
+	Data               [8]uint64
+}
+
+// Handles the syscall stop thread is in, returning whether the process
+// is to stop for it. Entries are recorded on the thread to tell which
+// syscall an exit returns from, exits whose entry was missed, e.g. as
+// the thread was halted in between, are skipped.
+func (thread *ThreadContext) syscallStop() (bool, error) {
+	var info ptraceSyscallInfo
+	if err := ptraceGetSyscallInfo(thread.Id, &info); err != nil {
+		return false, err
+	}
+	var ss SyscallStop
+	switch info.Op {
+	case ptraceSyscallInfoEntry:
+		ss = SyscallStop{Thread: thread.Id, Number: int(info.Data[0])}
+		copy(ss.Args[:], info.Data[1:7])
+		entry := ss
+		thread.syscall = &entry
+	case ptraceSyscallInfoExit:
+		if thread.syscall == nil {
+			return false, nil
+		}
+		ss = *thread.syscall
+		ss.Exit, ss.Ret = true, int64(info.Data[0])
+		thread.syscall = nil
+	default:
+		return false, nil
+	}
+	return thread.Process.syscallStopped(ss), nil
+}
@@ -0,0 +1,97 @@
+package proctl
+
+import "syscall"
+
+// The numbers of the syscalls of linux/arm64 CatchSyscall knows by name.
+var syscallNumbers = map[string]int{
+	"read":              syscall.SYS_READ,
+	"write":             syscall.SYS_WRITE,
+	"close":             syscall.SYS_CLOSE,
+	"fstat":             syscall.SYS_FSTAT,
+	"ppoll":             syscall.SYS_PPOLL,
+	"lseek":             syscall.SYS_LSEEK,
+	"mmap":              syscall.SYS_MMAP,
+	"mprotect":          syscall.SYS_MPROTECT,
+	"munmap":            syscall.SYS_MUNMAP,
+	"brk":               syscall.SYS_BRK,
+	"rt_sigaction":      syscall.SYS_RT_SIGACTION,
+	"rt_sigprocmask":    syscall.SYS_RT_SIGPROCMASK,
+	"rt_sigreturn":      syscall.SYS_RT_SIGRETURN,
+	"ioctl":             syscall.SYS_IOCTL,
+	"pread64":           syscall.SYS_PREAD64,
+	"pwrite64":          syscall.SYS_PWRITE64,
+	"readv":             syscall.SYS_READV,
+	"writev":            syscall.SYS_WRITEV,
+	"faccessat":         syscall.SYS_FACCESSAT,
+	"pipe2":             syscall.SYS_PIPE2,
+	"pselect6":          syscall.SYS_PSELECT6,
+	"sched_yield":       syscall.SYS_SCHED_YIELD,
+	"madvise":           syscall.SYS_MADVISE,
+	"dup":               syscall.SYS_DUP,
+	"dup3":              syscall.SYS_DUP3,
+	"nanosleep":         syscall.SYS_NANOSLEEP,
+	"clock_nanosleep":   syscall.SYS_CLOCK_NANOSLEEP,
+	"getpid":            syscall.SYS_GETPID,
+	"gettid":            syscall.SYS_GETTID,
+	"sendfile":          syscall.SYS_SENDFILE,
+	"socket":            syscall.SYS_SOCKET,
+	"socketpair":        syscall.SYS_SOCKETPAIR,
+	"connect":           syscall.SYS_CONNECT,
+	"accept":            syscall.SYS_ACCEPT,
+	"accept4":           syscall.SYS_ACCEPT4,
+	"sendto":            syscall.SYS_SENDTO,
+	"recvfrom":          syscall.SYS_RECVFROM,
+	"sendmsg":           syscall.SYS_SENDMSG,
+	"recvmsg":           syscall.SYS_RECVMSG,
+	"shutdown":          syscall.SYS_SHUTDOWN,
+	"bind":              syscall.SYS_BIND,
+	"listen":            syscall.SYS_LISTEN,
+	"setsockopt":        syscall.SYS_SETSOCKOPT,
+	"getsockopt":        syscall.SYS_GETSOCKOPT,
+	"clone":             syscall.SYS_CLONE,
+	"execve":            syscall.SYS_EXECVE,
+	"exit":              syscall.SYS_EXIT,
+	"exit_group":        syscall.SYS_EXIT_GROUP,
+	"wait4":             syscall.SYS_WAIT4,
+	"waitid":            syscall.SYS_WAITID,
+	"kill":              syscall.SYS_KILL,
+	"tkill":             syscall.SYS_TKILL,
+	"tgkill":            syscall.SYS_TGKILL,
+	"fcntl":             syscall.SYS_FCNTL,
+	"flock":             syscall.SYS_FLOCK,
+	"fsync":             syscall.SYS_FSYNC,
+	"fdatasync":         syscall.SYS_FDATASYNC,
+	"truncate":          syscall.SYS_TRUNCATE,
+	"ftruncate":         syscall.SYS_FTRUNCATE,
+	"getdents64":        syscall.SYS_GETDENTS64,
+	"getcwd":            syscall.SYS_GETCWD,
+	"chdir":             syscall.SYS_CHDIR,
+	"fchdir":            syscall.SYS_FCHDIR,
+	"renameat":          syscall.SYS_RENAMEAT,
+	"mkdirat":           syscall.SYS_MKDIRAT,
+	"unlinkat":          syscall.SYS_UNLINKAT,
+	"readlinkat":        syscall.SYS_READLINKAT,
+	"fchmod":            syscall.SYS_FCHMOD,
+	"fchmodat":          syscall.SYS_FCHMODAT,
+	"fchown":            syscall.SYS_FCHOWN,
+	"fchownat":          syscall.SYS_FCHOWNAT,
+	"umask":             syscall.SYS_UMASK,
+	"gettimeofday":      syscall.SYS_GETTIMEOFDAY,
+	"clock_gettime":     syscall.SYS_CLOCK_GETTIME,
+	"sigaltstack":       syscall.SYS_SIGALTSTACK,
+	"futex":             syscall.SYS_FUTEX,
+	"sched_getaffinity": syscall.SYS_SCHED_GETAFFINITY,
+	"epoll_create1":     syscall.SYS_EPOLL_CREATE1,
+	"epoll_ctl":         syscall.SYS_EPOLL_CTL,
+	"epoll_pwait":       syscall.SYS_EPOLL_PWAIT,
+	"eventfd2":          syscall.SYS_EVENTFD2,
+	"inotify_init1":     syscall.SYS_INOTIFY_INIT1,
+	"inotify_add_watch": syscall.SYS_INOTIFY_ADD_WATCH,
+	"prlimit64":         syscall.SYS_PRLIMIT64,
+	"setrlimit":         syscall.SYS_SETRLIMIT,
+	"getrlimit":         syscall.SYS_GETRLIMIT,
+	"mount":             syscall.SYS_MOUNT,
+	"umount2":           syscall.SYS_UMOUNT2,
+	"ptrace":            syscall.SYS_PTRACE,
+	"uname":             syscall.SYS_UNAME,
+}
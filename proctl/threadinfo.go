@@ -0,0 +1,80 @@
+package proctl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// What a thread of the process is doing, see ThreadInfo.
+type ThreadInfo struct {
+	Id       int
+	State    string // As reported by the OS, e.g. "tracing stop"
+	PC       uint64
+	File     string
+	Line     int
+	Function string
+	Current  bool // Whether it is the current thread
+
+	// On darwin, where Id is the mach port of the thread, the id
+	// of the thread system-wide and its name, as set by the
+	// program with pthread_setname_np, if any.
+	SystemId uint64
+	Name     string
+}
+
+func (ti ThreadInfo) String() string {
+	thread := fmt.Sprintf("Thread %d", ti.Id)
+	if ti.SystemId != 0 {
+		thread += fmt.Sprintf(" tid %d", ti.SystemId)
+	}
+	if ti.Name != "" {
+		thread += fmt.Sprintf(" %q", ti.Name)
+	}
+	if ti.Function == "" {
+		return fmt.Sprintf("%s (%s) at %#v", thread, ti.State, ti.PC)
+	}
+	return fmt.Sprintf("%s (%s) at %#v %s:%d %s", thread, ti.State, ti.PC, ti.File, ti.Line, ti.Function)
+}
+
+// Returns the state and location of every thread of the process,
+// sorted by id. The state of threads of targets which aren't live
+// processes, such as core files, is reported as stopped.
+func (dbp *DebuggedProcess) ThreadInfo() ([]ThreadInfo, error) {
+	if dbp.Exited() {
+		return nil, dbp.exitError()
+	}
+	dbp.mu.RLock()
+	threads := make([]*ThreadContext, 0, len(dbp.Threads))
+	for _, th := range dbp.Threads {
+		threads = append(threads, th)
+	}
+	current := dbp.CurrentThread
+	dbp.mu.RUnlock()
+	native := dbp.backend.info().Name == "native"
+
+	infos := make([]ThreadInfo, 0, len(threads))
+	for _, th := range threads {
+		pc, err := th.CurrentPC()
+		if err != nil {
+			return nil, err
+		}
+		ti := ThreadInfo{Id: th.Id, State: "stopped", PC: pc, Current: th == current}
+		if native {
+			if err := threadDetails(th, &ti); err != nil {
+				ti.State = "unknown"
+			}
+		}
+		if f, l, fn := dbp.GoSymTable.PCToLine(pc); fn != nil {
+			ti.File, ti.Line, ti.Function = f, l, fn.Name
+		}
+		infos = append(infos, ti)
+	}
+	sort.Sort(byThreadID(infos))
+	return infos, nil
+}
+
+type byThreadID []ThreadInfo
+
+func (s byThreadID) Len() int           { return len(s) }
+func (s byThreadID) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s byThreadID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,48 @@
+package proctl
+
+// #include <libproc.h>
+// #include "threads_darwin.h"
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Names of the run states of thread_info.
+var threadStates = map[C.int]string{
+	C.TH_STATE_RUNNING:         "running",
+	C.TH_STATE_STOPPED:         "stopped",
+	C.TH_STATE_WAITING:         "waiting",
+	C.TH_STATE_UNINTERRUPTIBLE: "uninterruptible",
+	C.TH_STATE_HALTED:          "halted",
+}
+
+// Fills in the state of the thread, read from thread_info, with how
+// many times it is suspended, and the system-wide id and name of the
+// thread, which mean more to users than its mach port. The name is
+// left empty when it can't be read.
+func threadDetails(thread *ThreadContext, ti *ThreadInfo) error {
+	var state, suspended C.int
+	if kret := C.get_run_state(thread.os.thread_act, &state, &suspended); kret != C.KERN_SUCCESS {
+		return fmt.Errorf("could not get info of thread %d", thread.Id)
+	}
+	name, ok := threadStates[state]
+	if !ok {
+		name = fmt.Sprintf("state %d", state)
+	}
+	if suspended > 0 {
+		name += fmt.Sprintf(", suspended %d", suspended)
+	}
+	ti.State = name
+
+	var id, handle C.uint64_t
+	if kret := C.get_identifier_info(thread.os.thread_act, &id, &handle); kret != C.KERN_SUCCESS {
+		return nil
+	}
+	ti.SystemId = uint64(id)
+	var pti C.struct_proc_threadinfo
+	if n := C.proc_pidinfo(C.int(thread.Process.Pid), C.PROC_PIDTHREADINFO, handle, unsafe.Pointer(&pti), C.int(unsafe.Sizeof(pti))); n == C.int(unsafe.Sizeof(pti)) {
+		ti.Name = C.GoString(&pti.pth_name[0])
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package proctl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Names of the states of /proc/<pid>/task/<tid>/stat, see proc(5).
+var threadStates = map[byte]string{
+	'R': "running",
+	'S': "sleeping",
+	'D': "disk sleep",
+	'T': "stopped",
+	't': "tracing stop",
+	'Z': "zombie",
+	'X': "dead",
+	'I': "idle",
+}
+
+// Fills in the state of the thread, read from /proc.
+func threadDetails(thread *ThreadContext, ti *ThreadInfo) error {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/task/%d/stat", thread.Process.Pid, thread.Id))
+	if err != nil {
+		return err
+	}
+	// pid (comm) state ..., where comm may contain spaces and parens.
+	s := string(stat)
+	i := strings.LastIndex(s, ")")
+	if i < 0 || i+2 >= len(s) {
+		return fmt.Errorf("malformed stat %q", stat)
+	}
+	name, ok := threadStates[s[i+2]]
+	if !ok {
+		name = string(s[i+2])
+	}
+	ti.State = name
+	return nil
+}
@@ -1,8 +1,11 @@
 package proctl
 
 import (
-	"encoding/binary"
+	"context"
 	"fmt"
+	"math"
+	"strings"
+	"syscall"
 
 	sys "golang.org/x/sys/unix"
 
@@ -19,6 +22,19 @@ type ThreadContext struct {
 	Process *DebuggedProcess
 	Status  *sys.WaitStatus
 	os      *OSSpecificDetails
+	// Registers at the previous stop, recorded as the process
+	// is resumed. Nil until the thread is first resumed.
+	prevRegs []Register
+	// Delivered when the thread is next resumed, see SignalStop.
+	signal syscall.Signal
+	// Frame expressions are evaluated in, see SelectFrame.
+	frame int
+	// The syscall the thread last entered, until it returns from it,
+	// while syscalls are caught, see CatchSyscall.
+	syscall *SyscallStop
+	// Registers read since the thread last stopped, which stay the
+	// same until it is resumed. Only kept by the native backend.
+	regs Registers
 }
 
 // An interface for a generic register type. The
@@ -29,17 +45,115 @@ type Registers interface {
 	PC() uint64
 	SP() uint64
 	SetPC(*ThreadContext, uint64) error
+	// Returns the general purpose, flags
+	// and segment registers, in that order.
+	Slice() []Register
+	// Sets the named register of thread to value, see
+	// registerName for the accepted names.
+	SetRegister(thread *ThreadContext, name string, value uint64) error
+	// Returns the base address of the thread local
+	// storage, or 0 when it is not known.
+	TLS() uint64
+}
+
+// A named register and its value.
+type Register struct {
+	Name  string
+	Value uint64
+}
+
+// A register whose value changed between two stops.
+type RegisterChange struct {
+	Name     string
+	Old, New uint64
+}
+
+// Returns the canonical, lower case, name of
+// the register name, e.g. "rip" for "PC".
+func registerName(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := registerAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+// Returns the value of the register with the given DWARF number.
+func dwarfRegister(regs Registers, num uint64) (uint64, bool) {
+	if num >= uint64(len(dwarfRegisters)) {
+		return 0, false
+	}
+	for _, r := range regs.Slice() {
+		if registerName(r.Name) == dwarfRegisters[num] {
+			return r.Value, true
+		}
+	}
+	return 0, false
 }
 
 // Obtains register values from the debugged process.
 func (thread *ThreadContext) Registers() (Registers, error) {
-	regs, err := registers(thread)
+	regs, err := thread.Process.backend.registers(thread)
 	if err != nil {
+		if _, ok := err.(ProcessExitedError); ok {
+			return nil, err
+		}
 		return nil, fmt.Errorf("could not get registers: %s", err)
 	}
 	return regs, nil
 }
 
+// Returns the registers of the thread that changed since the previous
+// stop, in the order of Registers.Slice. Returns nil if the thread
+// wasn't stopped before, i.e. the process was not resumed yet or the
+// thread was created since.
+func (thread *ThreadContext) ChangedRegisters() ([]RegisterChange, error) {
+	if thread.prevRegs == nil {
+		return nil, nil
+	}
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+
+	prev := make(map[string]uint64, len(thread.prevRegs))
+	for _, r := range thread.prevRegs {
+		prev[r.Name] = r.Value
+	}
+	changes := []RegisterChange{}
+	for _, r := range regs.Slice() {
+		if old, ok := prev[r.Name]; ok && old != r.Value {
+			changes = append(changes, RegisterChange{r.Name, old, r.Value})
+		}
+	}
+	return changes, nil
+}
+
+// Reads size bytes of the memory of the process starting at addr.
+// Reads are limited to maxMemoryRead bytes, and partial reads are
+// reported as errors.
+func (thread *ThreadContext) ReadMemory(addr uintptr, size int) ([]byte, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	if size > maxMemoryRead {
+		return nil, fmt.Errorf("size %d exceeds the maximum of %d bytes", size, maxMemoryRead)
+	}
+	if addr+uintptr(size) < addr {
+		return nil, fmt.Errorf("address range %#x+%d overflows", addr, size)
+	}
+
+	buf := make([]byte, size)
+	n, err := thread.Process.backend.readMemory(thread, addr, buf)
+	if err != nil {
+		return nil, thread.Process.readError(uint64(addr), uint64(size), err)
+	}
+	if n < size {
+		return nil, fmt.Errorf("could not read memory at %#x, read %d of %d bytes", addr, n, size)
+	}
+	return buf, nil
+}
+
 // Returns the current PC for this thread.
 func (thread *ThreadContext) CurrentPC() (uint64, error) {
 	regs, err := thread.Registers()
@@ -61,14 +175,14 @@ func (thread *ThreadContext) Continue() error {
 
 	// Check whether we are stopped at a breakpoint, and
 	// if so, single step over it before continuing.
-	if _, ok := thread.Process.BreakPoints[regs.PC()-1]; ok {
+	if _, ok := thread.Process.breakpointAt(regs.PC()); ok {
 		err := thread.Step()
 		if err != nil {
 			return fmt.Errorf("could not step %s", err)
 		}
 	}
 
-	return thread.resume()
+	return thread.Process.backend.resume(thread)
 }
 
 // Single steps this thread a single instruction, ensuring that
@@ -79,10 +193,10 @@ func (thread *ThreadContext) Step() (err error) {
 		return err
 	}
 
-	bp, ok := thread.Process.BreakPoints[regs.PC()-1]
+	bp, ok := thread.Process.breakpointAt(regs.PC())
 	if ok {
-		// Clear the breakpoint so that we can continue execution.
-		_, err = thread.Process.Clear(bp.Addr)
+		// Disable the breakpoint so that we can continue execution.
+		err = thread.Process.setBreakpointEnabled(thread.Id, bp, false)
 		if err != nil {
 			return err
 		}
@@ -95,11 +209,13 @@ func (thread *ThreadContext) Step() (err error) {
 
 		// Restore breakpoint now that we have passed it.
 		defer func() {
-			_, err = thread.Process.Break(bp.Addr)
+			if rerr := thread.Process.setBreakpointEnabled(thread.Id, bp, true); err == nil {
+				err = rerr
+			}
 		}()
 	}
 
-	err = thread.singleStep()
+	err = thread.Process.backend.singleStep(thread)
 	if err != nil {
 		return fmt.Errorf("step failed: %s", err.Error())
 	}
@@ -109,94 +225,263 @@ func (thread *ThreadContext) Step() (err error) {
 
 // Step to next source line. Next will step over functions,
 // and will follow through to the return address of a function.
+// Where there is no source, Next steps a single instruction.
 // Next is implemented on the thread context, however during the
 // course of this function running, it's very likely that the
 // goroutine our M is executing will switch to another M, therefore
 // this function cannot assume all execution will happen on this thread
-// in the traced process.
-func (thread *ThreadContext) Next() (err error) {
+// in the traced process. The goroutine running on the thread is
+// followed to the next line, other goroutines going through the
+// same code on the way are ignored.
+func (thread *ThreadContext) Next() error {
+	_, err := thread.next(context.Background())
+	return err
+}
+
+// Does Next until ctx is done, returning the thread the goroutine ends
+// up on.
+func (thread *ThreadContext) next(ctx context.Context) (*ThreadContext, error) {
 	pc, err := thread.CurrentPC()
 	if err != nil {
-		return err
+		return thread, err
 	}
 
-	if bp, ok := thread.Process.BreakPoints[pc-1]; ok {
+	// Zero on the system stack, any goroutine will do then.
+	var goroutine int
+	if g, err := thread.CurrentGoroutine(); err == nil {
+		goroutine = g.Id
+	}
+
+	if bp, ok := thread.Process.trappedBreakpoint(pc); ok {
 		pc = bp.Addr
 	}
 
+	// Without line or frame information, as in assembly or
+	// stripped code, step a single instruction instead.
+	_, _, fn := thread.Process.GoSymTable.PCToLine(pc)
 	fde, err := thread.Process.FrameEntries.FDEForPC(pc)
-	if err != nil {
-		return err
+	if fn == nil || err != nil {
+		return thread, thread.Step()
 	}
 
-	_, l, _ := thread.Process.GoSymTable.PCToLine(pc)
-	ret := thread.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
+	l, _ := thread.Process.lineForPC(pc)
+	ret := thread.returnAddress(fde, pc)
 	for {
+		if err = ctx.Err(); err != nil {
+			return thread, err
+		}
 		if err = thread.Step(); err != nil {
-			return err
+			return thread, err
 		}
 
 		if pc, err = thread.CurrentPC(); err != nil {
-			return err
+			return thread, err
 		}
 
-		if !fde.Cover(pc) && pc != ret {
-			if err := thread.continueToReturnAddress(pc, fde); err != nil {
+		// Stepped into a call, possibly of this very function.
+		if (!fde.Cover(pc) || pc == fde.Begin()) && pc != ret {
+			th, err := thread.continueToReturnAddress(ctx, pc, fde, goroutine)
+			if err != nil {
 				if _, ok := err.(InvalidAddressError); !ok {
-					return err
+					return th, err
 				}
 			}
+			thread = th
 			if pc, err = thread.CurrentPC(); err != nil {
-				return err
+				return thread, err
 			}
 		}
 
-		if _, nl, _ := thread.Process.GoSymTable.PCToLine(pc); nl != l {
+		// Stop at the first statement of another line.
+		if nl, stmt := thread.Process.lineForPC(pc); nl != l && stmt {
 			break
 		}
 	}
 
+	return thread, nil
+}
+
+// Step into the next source line. StepInto single steps the
+// thread until the line table reports a new statement, following
+// any function calls made along the way.
+func (thread *ThreadContext) StepInto() (err error) {
+	pc, err := thread.CurrentPC()
+	if err != nil {
+		return err
+	}
+
+	if bp, ok := thread.Process.trappedBreakpoint(pc); ok {
+		pc = bp.Addr
+	}
+
+	f, l, fn := thread.Process.GoSymTable.PCToLine(pc)
+	// A recursive call from a function on a single line doesn't
+	// change the line, only the frame.
+	depth, derr := thread.frameDepth()
+	for {
+		if err = thread.Step(); err != nil {
+			return err
+		}
+
+		if pc, err = thread.CurrentPC(); err != nil {
+			return err
+		}
+
+		nf, nl, nfn := thread.Process.GoSymTable.PCToLine(pc)
+		// Stop if we have stepped into code we have no
+		// source information for.
+		if nfn == nil {
+			break
+		}
+
+		if nl != l || nf != f || fn == nil || nfn.Entry != fn.Entry {
+			break
+		}
+		if derr == nil {
+			if d, err := thread.frameDepth(); err == nil && d > depth {
+				break
+			}
+		}
+	}
+
 	return nil
 }
 
-func (thread *ThreadContext) continueToReturnAddress(pc uint64, fde *frame.FrameDescriptionEntry) error {
-	for !fde.Cover(pc) {
+// Continues the thread until the given goroutine returns to the
+// function described by fde, returning the thread it returns on. The
+// thread has just stepped into the function at pc, which may be the
+// one described by fde itself, called recursively.
+func (thread *ThreadContext) continueToReturnAddress(ctx context.Context, pc uint64, fde *frame.FrameDescriptionEntry, goroutine int) (*ThreadContext, error) {
+	for {
+		// Returning to the frame we are called from, not to
+		// one deeper in the stack, leaves the stack pointer at
+		// least as high as it is now.
+		depth, err := thread.stackDepth()
+		if err != nil {
+			return thread, err
+		}
 		// Offset is 0 because we have just stepped into this function.
 		addr := thread.ReturnAddressFromOffset(0)
-		bp, err := thread.Process.Break(addr)
+		if entry, err := thread.Process.FrameEntries.FDEForPC(pc); err == nil {
+			addr = thread.returnAddress(entry, pc)
+		}
+		bp, err := thread.Process.breakpoint(addr)
 		if err != nil {
 			if _, ok := err.(BreakPointExistsError); !ok {
-				return err
+				return thread, err
 			}
 		}
 		bp.Temp = true
+
+		thread, err = thread.continueToBreakpoint(ctx, bp, goroutine, depth)
 		// Ensure we cleanup after ourselves no matter what.
-		defer thread.clearTempBreakpoint(bp.Addr)
+		if cerr := thread.clearTempBreakpoint(bp.Addr); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return thread, err
+		}
+		if pc, err = thread.CurrentPC(); err != nil {
+			return thread, err
+		}
+		if fde.Cover(pc) {
+			return thread, nil
+		}
+	}
+}
 
-		for {
-			err = thread.Continue()
-			if err != nil {
-				return err
-			}
-			// Wait on -1, just in case scheduler switches threads for this G.
-			wpid, err := trapWait(thread.Process, -1)
-			if err != nil {
-				return err
-			}
-			if wpid != thread.Id {
-				thread = thread.Process.Threads[wpid]
-			}
-			pc, err = thread.CurrentPC()
+// Continues the thread until the given goroutine hits bp with its stack
+// no deeper than depth, see stackDepth, returning the thread it hit it
+// on. Other goroutines hitting bp are continued, as are deeper calls of
+// the same function, e.g. when it is recursive.
+func (thread *ThreadContext) continueToBreakpoint(ctx context.Context, bp *BreakPoint, goroutine int, depth uint64) (*ThreadContext, error) {
+	for {
+		err := thread.Continue()
+		if err != nil {
+			return thread, err
+		}
+		// Wait on -1, just in case scheduler switches threads for this G.
+		wpid, err := thread.Process.pausableTrapWait(ctx, -1, []*ThreadContext{thread})
+		if err != nil {
+			return thread, err
+		}
+		if wpid != thread.Id {
+			thread = thread.Process.Threads[wpid]
+		}
+		pc, err := thread.CurrentPC()
+		if err != nil {
+			return thread, err
+		}
+		if pc-breakpointPCOffset != bp.Addr && pc != bp.Addr {
+			continue
+		}
+		if goroutine != 0 {
+			match, err := thread.onGoroutine(goroutine)
 			if err != nil {
-				return err
+				return thread, err
 			}
-			if (pc-1) == bp.Addr || pc == bp.Addr {
-				break
+			if !match {
+				continue
 			}
 		}
+		d, err := thread.stackDepth()
+		if err != nil {
+			return thread, err
+		}
+		if d <= depth {
+			return thread, nil
+		}
 	}
+}
 
-	return nil
+// Returns how deep the stack pointer of the thread is in the stack of
+// the goroutine it runs, as its distance from the top of the stack,
+// which unlike the stack pointer itself survives the runtime moving
+// the stack to grow it. On the system stack, it is the distance from
+// the top of the address space.
+func (thread *ThreadContext) stackDepth() (uint64, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return 0, err
+	}
+	if g, err := thread.CurrentGoroutine(); err == nil {
+		return g.stackhi - regs.SP(), nil
+	}
+	return math.MaxUint64 - regs.SP(), nil
+}
+
+// Returns the depth of the innermost frame of the thread as stackDepth
+// does, but from its canonical frame address, which unlike the stack
+// pointer is the same wherever in its function the frame is executing.
+func (thread *ThreadContext) frameDepth() (uint64, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return 0, err
+	}
+	frames, err := thread.Process.stacktrace(regs.PC(), regs.SP(), regs, 1)
+	if len(frames) == 0 {
+		return 0, err
+	}
+	if g, err := thread.CurrentGoroutine(); err == nil {
+		return g.stackhi - frames[0].cfa, nil
+	}
+	return math.MaxUint64 - frames[0].cfa, nil
+}
+
+// Returns the address the function described by fde, executing at
+// pc, is going to return to. Until the prologue saves it on the stack
+// it is still in the return address register, e.g. the link register
+// of arm64.
+func (thread *ThreadContext) returnAddress(fde *frame.FrameDescriptionEntry, pc uint64) uint64 {
+	if !fde.ReturnAddressSaved(pc) {
+		regs, err := thread.Registers()
+		if err == nil {
+			if ret, ok := dwarfRegister(regs, fde.CIE.ReturnAddressRegister); ok {
+				return ret
+			}
+		}
+	}
+	return thread.ReturnAddressFromOffset(fde.ReturnAddressOffset(pc))
 }
 
 // Takes an offset from RSP and returns the address of the
@@ -208,9 +493,9 @@ func (thread *ThreadContext) ReturnAddressFromOffset(offset int64) uint64 {
 	}
 
 	retaddr := int64(regs.SP()) + offset
-	data := make([]byte, 8)
-	readMemory(thread, uintptr(retaddr), data)
-	return binary.LittleEndian.Uint64(data)
+	data := make([]byte, ptrsize)
+	thread.Process.backend.readMemory(thread, uintptr(retaddr), data)
+	return decodePointer(data)
 }
 
 func (thread *ThreadContext) clearTempBreakpoint(pc uint64) error {
@@ -218,7 +503,7 @@ func (thread *ThreadContext) clearTempBreakpoint(pc uint64) error {
 	if _, ok := thread.Process.BreakPoints[pc]; ok {
 		software = true
 	}
-	if _, err := thread.Process.Clear(pc); err != nil {
+	if _, err := thread.Process.clear(pc); err != nil {
 		return err
 	}
 	if software {
@@ -233,3 +518,49 @@ func (thread *ThreadContext) clearTempBreakpoint(pc uint64) error {
 
 	return nil
 }
+
+// Returns the goroutine running on this thread. It is read from the
+// thread local storage when its address is known, otherwise it is
+// found by looking for the goroutine whose stack contains the stack
+// pointer.
+func (thread *ThreadContext) CurrentGoroutine() (*G, error) {
+	regs, err := thread.Registers()
+	if err != nil {
+		return nil, err
+	}
+	if tls := regs.TLS(); tls != 0 {
+		gtype, err := thread.Process.findStructType("runtime.g")
+		if err != nil {
+			return nil, err
+		}
+		g, err := thread.Process.parseG(uint64(int64(tls)+tlsGOffset), gtype)
+		if err == nil {
+			// The scheduler runs on g0, whose id is 0.
+			if g.Id == 0 {
+				return nil, fmt.Errorf("thread %d is not running a goroutine", thread.Id)
+			}
+			return g, nil
+		}
+	}
+	goroutines, err := thread.Process.Goroutines()
+	if err != nil {
+		return nil, err
+	}
+	sp := regs.SP()
+	for _, g := range goroutines {
+		if g.status != gstatusDead && g.stacklo <= sp && sp < g.stackhi {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("thread %d is not running a goroutine", thread.Id)
+}
+
+// Returns true if the thread is running the goroutine with the given id.
+func (thread *ThreadContext) onGoroutine(id int) (bool, error) {
+	g, err := thread.CurrentGoroutine()
+	if err != nil {
+		// On the system stack, e.g. in the scheduler.
+		return false, nil
+	}
+	return g.Id == id, nil
+}
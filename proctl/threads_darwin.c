@@ -67,6 +67,11 @@ set_pc(thread_act_t task, uint64_t pc) {
 	return thread_set_state(task, x86_THREAD_STATE64, (thread_state_t)&state, stateCount);
 }
 
+kern_return_t
+set_registers(thread_act_t task, x86_thread_state64_t *state) {
+	return thread_set_state(task, x86_THREAD_STATE64, (thread_state_t)state, x86_THREAD_STATE64_COUNT);
+}
+
 kern_return_t
 single_step(thread_act_t thread) {
 	kern_return_t kret;
@@ -119,3 +124,43 @@ clear_trap_flag(thread_act_t thread) {
 
 	return thread_set_state(thread, x86_THREAD_STATE64, (thread_state_t)&regs, count);
 }
+
+kern_return_t
+get_run_state(thread_act_t thread, int *run_state, int *suspend_count) {
+	kern_return_t kret;
+	struct thread_basic_info info;
+	unsigned int info_count = THREAD_BASIC_INFO_COUNT;
+
+	kret = thread_info((thread_t)thread, THREAD_BASIC_INFO, (thread_info_t)&info, &info_count);
+	if (kret != KERN_SUCCESS) return kret;
+
+	*run_state = info.run_state;
+	*suspend_count = info.suspend_count;
+	return KERN_SUCCESS;
+}
+
+kern_return_t
+get_debug_state(thread_act_t thread, x86_debug_state64_t *state) {
+	mach_msg_type_number_t count = x86_DEBUG_STATE64_COUNT;
+
+	return thread_get_state(thread, x86_DEBUG_STATE64, (thread_state_t)state, &count);
+}
+
+kern_return_t
+set_debug_state(thread_act_t thread, x86_debug_state64_t *state) {
+	return thread_set_state(thread, x86_DEBUG_STATE64, (thread_state_t)state, x86_DEBUG_STATE64_COUNT);
+}
+
+kern_return_t
+get_identifier_info(thread_act_t thread, uint64_t *thread_id, uint64_t *thread_handle) {
+	kern_return_t kret;
+	struct thread_identifier_info info;
+	mach_msg_type_number_t count = THREAD_IDENTIFIER_INFO_COUNT;
+
+	kret = thread_info((thread_t)thread, THREAD_IDENTIFIER_INFO, (thread_info_t)&info, &count);
+	if (kret != KERN_SUCCESS) return kret;
+
+	*thread_id = info.thread_id;
+	*thread_handle = info.thread_handle;
+	return KERN_SUCCESS;
+}
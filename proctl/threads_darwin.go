@@ -3,6 +3,7 @@ package proctl
 // #include "threads_darwin.h"
 import "C"
 import (
+	"context"
 	"fmt"
 	"unsafe"
 )
@@ -25,7 +26,7 @@ func (t *ThreadContext) singleStep() error {
 	if kret != C.KERN_SUCCESS {
 		return fmt.Errorf("could not single step")
 	}
-	trapWait(t.Process, 0)
+	trapWait(context.Background(), t.Process, 0)
 	kret = C.clear_trap_flag(t.os.thread_act)
 	if kret != C.KERN_SUCCESS {
 		return fmt.Errorf("could not clear CPU trap flag")
@@ -15,6 +15,9 @@ get_registers(mach_port_name_t, x86_thread_state64_t*);
 kern_return_t
 set_pc(thread_act_t, uint64_t);
 
+kern_return_t
+set_registers(thread_act_t, x86_thread_state64_t*);
+
 kern_return_t
 single_step(thread_act_t);
 
@@ -23,3 +26,15 @@ clear_trap_flag(thread_act_t);
 
 kern_return_t
 resume_thread(thread_act_t);
+
+kern_return_t
+get_run_state(thread_act_t, int *, int *);
+
+kern_return_t
+get_debug_state(thread_act_t, x86_debug_state64_t*);
+
+kern_return_t
+set_debug_state(thread_act_t, x86_debug_state64_t*);
+
+kern_return_t
+get_identifier_info(thread_act_t, uint64_t *, uint64_t *);
@@ -2,6 +2,7 @@ package proctl
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	sys "golang.org/x/sys/unix"
 )
@@ -16,26 +17,61 @@ func (t *ThreadContext) Halt() error {
 	}
 	err := sys.Tgkill(t.Process.Pid, t.Id, sys.SIGSTOP)
 	if err != nil {
+		if perr, ok := t.Process.exitedError(err).(ProcessExitedError); ok {
+			return perr
+		}
 		return fmt.Errorf("Halt err %s %d", err, t.Id)
 	}
-	_, _, err = wait(t.Id, 0)
-	if err != nil {
-		return fmt.Errorf("wait err %s %d", err, t.Id)
+	for {
+		_, status, err := wait(t.Id, 0)
+		if err != nil {
+			return fmt.Errorf("wait err %s %d", err, t.Id)
+		}
+		// Another signal may arrive before ours, it is
+		// delivered when the thread is resumed.
+		sig := status.StopSignal()
+		if !status.Stopped() || sig == sys.SIGSTOP || sig == sys.SIGTRAP {
+			return nil
+		}
+		if sig == sys.SIGTRAP|0x80 {
+			// A syscall stop, its exit won't be reported.
+			t.syscall = nil
+		} else if t.Process.SignalPolicy(sig) != SignalIgnore {
+			t.signal = sig
+		}
+		if err := PtraceCont(t.Id, 0); err != nil {
+			return fmt.Errorf("could not continue thread %d to halt it %s", t.Id, err)
+		}
 	}
-	return nil
 }
 
 func (t *ThreadContext) resume() error {
-	return PtraceCont(t.Id, 0)
+	sig := t.signal
+	t.signal = 0
+	return t.Process.ptraceResume(t.Id, int(sig))
 }
 
 func (t *ThreadContext) singleStep() error {
-	err := sys.PtraceSingleStep(t.Id)
-	if err != nil {
-		return err
+	for {
+		err := PtraceSingleStep(t.Id)
+		if err != nil {
+			return err
+		}
+		_, status, err := wait(t.Id, 0)
+		if err != nil {
+			return err
+		}
+		// A signal pending on the thread, e.g. a preemption
+		// request of the runtime, stops it before it steps, it
+		// is delivered when the thread is resumed.
+		sig := status.StopSignal()
+		if !status.Stopped() || sig == sys.SIGTRAP {
+			return nil
+		}
+		if t.Process.SignalPolicy(sig) != SignalIgnore {
+			t.signal = sig
+		}
 	}
-	_, _, err = wait(t.Id, 0)
-	return err
 }
 
 func (t *ThreadContext) blocked() bool {
@@ -49,9 +85,39 @@ func (t *ThreadContext) blocked() bool {
 }
 
 func writeMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
-	return sys.PtracePokeData(thread.Id, addr, data)
+	return ptracePokeData(thread.Id, addr, data)
 }
 
+// Set once the kernel is found not to support process_vm_readv, by
+// whichever thread reads memory first.
+var noProcessVMReadv atomic.Bool
+
+// Reads memory with a single process_vm_readv, rather than a ptrace
+// request per word, which makes large reads, such as the contents of
+// strings and slices or stack scans, much faster. What it can't read,
+// e.g. on kernels without it or across an unreadable page, is read
+// with ptrace, which sees the same memory.
 func readMemory(thread *ThreadContext, addr uintptr, data []byte) (int, error) {
-	return sys.PtracePeekData(thread.Id, addr, data)
+	if len(data) == 0 {
+		return 0, nil
+	}
+	var n int
+	if !noProcessVMReadv.Load() {
+		local := []sys.Iovec{{Base: &data[0]}}
+		local[0].SetLen(len(data))
+		remote := []sys.RemoteIovec{{Base: addr, Len: len(data)}}
+		var err error
+		n, err = sys.ProcessVMReadv(thread.Id, local, remote, 0)
+		if err == sys.ENOSYS {
+			noProcessVMReadv.Store(true)
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n == len(data) {
+			return n, nil
+		}
+	}
+	m, err := ptracePeekData(thread.Id, addr+uintptr(n), data[n:])
+	return n + m, err
 }
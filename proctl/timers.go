@@ -0,0 +1,243 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A runtime timer, backing time.Sleep, time.Timer,
+// time.Ticker and time.AfterFunc among others.
+type Timer struct {
+	When   int64       // Runtime monotonic time, in nanoseconds, the timer fires at
+	Period int64       // Period of tickers, 0 for one shot timers
+	Func   *gosym.Func // Function the runtime calls when the timer fires
+	// Function run in its own goroutine for timers
+	// created by time.AfterFunc, nil otherwise.
+	AfterFunc *gosym.Func
+	addr      uint64
+}
+
+func (t *Timer) String() string {
+	fn := "?"
+	if t.Func != nil {
+		fn = t.Func.Name
+	}
+	if t.AfterFunc != nil {
+		fn = fmt.Sprintf("%s (%s)", fn, t.AfterFunc.Name)
+	}
+	s := fmt.Sprintf("Timer %#x - when: %s, func: %s", t.addr, time.Duration(t.When), fn)
+	if t.Period > 0 {
+		s += fmt.Sprintf(", period: %s", time.Duration(t.Period))
+	}
+	return s
+}
+
+// A slice of timers kept by the runtime.
+type timerHeap struct {
+	addr uint64
+	t    *dwarf.StructType
+}
+
+// Returns the active runtime timers of the process, sorted by when they fire.
+func (dbp *DebuggedProcess) Timers() ([]*Timer, error) {
+	timer, err := dbp.findStructType("runtime.timer")
+	if err != nil {
+		return nil, err
+	}
+	heaps, err := dbp.timerHeaps()
+	if err != nil {
+		return nil, err
+	}
+
+	var timers []*Timer
+	for _, heap := range heaps {
+		array, length, _, elem, err := dbp.sliceHeader(heap.addr, heap.t)
+		if err != nil {
+			return nil, err
+		}
+		stride := uint64(elem.Size())
+		for i := uint64(0); i < length; i++ {
+			addr := array + i*stride
+			// Newer runtimes cache when next to the pointer.
+			if st, ok := resolveTypedef(elem).(*dwarf.StructType); ok {
+				addr, err = dbp.readUintField(addr, st, "timer")
+			} else {
+				addr, err = dbp.readPointer(addr)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if addr == 0 {
+				continue
+			}
+			t, err := dbp.parseTimer(addr, timer)
+			if err != nil {
+				return nil, err
+			}
+			timers = append(timers, t)
+		}
+	}
+
+	sort.Sort(byWhen(timers))
+	return timers, nil
+}
+
+func (dbp *DebuggedProcess) parseTimer(addr uint64, t *dwarf.StructType) (*Timer, error) {
+	when, err := dbp.readIntField(addr, t, "when")
+	if err != nil {
+		return nil, err
+	}
+	period, err := dbp.readIntField(addr, t, "period")
+	if err != nil {
+		return nil, err
+	}
+	fn, err := dbp.readFuncField(addr, t, "f")
+	if err != nil {
+		return nil, err
+	}
+
+	timer := &Timer{When: when, Period: period, Func: fn, addr: addr}
+	// AfterFunc timers call time.goFunc, passing
+	// the user's function as the argument.
+	if fn != nil && fn.Name == "time.goFunc" {
+		if arg, err := structField(t, "arg"); err == nil {
+			// The argument is an interface{}, a func
+			// value is stored directly in its data word.
+			data, err := dbp.readPointer(addr + uint64(arg.ByteOffset) + uint64(ptrsize))
+			if err == nil && data != 0 {
+				if pc, err := dbp.readPointer(data); err == nil {
+					timer.AfterFunc = dbp.GoSymTable.PCToFunc(pc)
+				}
+			}
+		}
+	}
+	return timer, nil
+}
+
+// Returns the function referred to by the func valued field name
+// of the struct of type t at addr, nil if the field is nil.
+func (dbp *DebuggedProcess) readFuncField(addr uint64, t *dwarf.StructType, name string) (*gosym.Func, error) {
+	fv, err := dbp.readUintField(addr, t, name)
+	if err != nil || fv == 0 {
+		return nil, err
+	}
+	pc, err := dbp.readPointer(fv)
+	if err != nil {
+		return nil, err
+	}
+	return dbp.GoSymTable.PCToFunc(pc), nil
+}
+
+// Locates the slices the runtime keeps its timers in. Depending on its
+// version these are either global, possibly split in buckets, or kept
+// per P.
+func (dbp *DebuggedProcess) timerHeaps() ([]timerHeap, error) {
+	if addr, t, err := dbp.globalVariable("runtime.timers"); err == nil {
+		switch t := resolveTypedef(t).(type) {
+		case *dwarf.StructType:
+			heap, err := timerSlice(addr, t)
+			if err != nil {
+				return nil, err
+			}
+			return []timerHeap{heap}, nil
+		case *dwarf.ArrayType:
+			bucket, ok := resolveTypedef(t.Type).(*dwarf.StructType)
+			if !ok {
+				return nil, fmt.Errorf("unexpected type %s for runtime.timers", t)
+			}
+			heaps := make([]timerHeap, 0, t.Count)
+			for i := int64(0); i < t.Count; i++ {
+				heap, err := timerSlice(addr+uint64(i*bucket.Size()), bucket)
+				if err != nil {
+					return nil, err
+				}
+				heaps = append(heaps, heap)
+			}
+			return heaps, nil
+		}
+		return nil, fmt.Errorf("unexpected type %s for runtime.timers", t)
+	}
+
+	ps, err := dbp.allP()
+	if err != nil {
+		return nil, err
+	}
+	ptype, err := dbp.findStructType("runtime.p")
+	if err != nil {
+		return nil, err
+	}
+	f, err := structField(ptype, "timers")
+	if err != nil {
+		return nil, err
+	}
+	heaps := make([]timerHeap, 0, len(ps))
+	for _, p := range ps {
+		heap, err := timerSlice(p+uint64(f.ByteOffset), resolveTypedef(f.Type))
+		if err != nil {
+			return nil, err
+		}
+		heaps = append(heaps, heap)
+	}
+	return heaps, nil
+}
+
+// Returns the slice of timers held by the value of type t at addr,
+// which is either the slice itself or a struct containing it.
+func timerSlice(addr uint64, t dwarf.Type) (timerHeap, error) {
+	st, ok := t.(*dwarf.StructType)
+	if !ok {
+		return timerHeap{}, fmt.Errorf("unexpected type %s holding timers", t)
+	}
+	if strings.HasPrefix(st.StructName, "[]") {
+		return timerHeap{addr, st}, nil
+	}
+	for _, f := range st.Field {
+		switch f.Name {
+		case "t", "heap", "timersBucket":
+			return timerSlice(addr+uint64(f.ByteOffset), resolveTypedef(f.Type))
+		}
+	}
+	return timerHeap{}, fmt.Errorf("could not find timers in %s", st.StructName)
+}
+
+// Returns the addresses of the Ps of the process.
+func (dbp *DebuggedProcess) allP() ([]uint64, error) {
+	addr, t, err := dbp.globalVariable("runtime.allp")
+	if err != nil {
+		return nil, err
+	}
+
+	var array, length uint64
+	switch t := resolveTypedef(t).(type) {
+	case *dwarf.StructType:
+		if array, length, _, _, err = dbp.sliceHeader(addr, t); err != nil {
+			return nil, err
+		}
+	case *dwarf.ArrayType:
+		array, length = addr, uint64(t.Count)
+	default:
+		return nil, fmt.Errorf("unexpected type %s for runtime.allp", t)
+	}
+
+	ps := make([]uint64, 0, length)
+	for i := uint64(0); i < length; i++ {
+		p, err := dbp.readPointer(array + i*uint64(ptrsize))
+		if err != nil {
+			return nil, err
+		}
+		if p != 0 {
+			ps = append(ps, p)
+		}
+	}
+	return ps, nil
+}
+
+type byWhen []*Timer
+
+func (t byWhen) Len() int           { return len(t) }
+func (t byWhen) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byWhen) Less(i, j int) bool { return t[i].When < t[j].When }
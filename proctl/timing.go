@@ -0,0 +1,72 @@
+package proctl
+
+import (
+	"fmt"
+	"time"
+)
+
+// Wall-clock time the process spent running and stopped since the
+// debugger took control of it, to tell how much the debugging session
+// slowed it down. Restarts carry the times over.
+type SessionTimes struct {
+	Running     time.Duration
+	Stopped     time.Duration
+	Resumes     int           // Number of times the process was resumed
+	LongestStop time.Duration // Longest time the process was kept stopped
+}
+
+func (st SessionTimes) String() string {
+	total := st.Running + st.Stopped
+	var pct float64
+	if total > 0 {
+		pct = 100 * float64(st.Stopped) / float64(total)
+	}
+	return fmt.Sprintf("running %s, stopped %s (%.1f%%), resumed %d times, longest stop %s",
+		st.Running, st.Stopped, pct, st.Resumes, st.LongestStop)
+}
+
+// Returns the time the process spent running and stopped so far.
+func (dbp *DebuggedProcess) SessionTimes() SessionTimes {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+
+	st := dbp.times
+	current := time.Since(dbp.stateSince)
+	// Rather than running, which a manual stop clears
+	// before the process has actually stopped.
+	if dbp.timedRunning {
+		st.Running += current
+	} else {
+		st.Stopped += current
+		if current > st.LongestStop {
+			st.LongestStop = current
+		}
+	}
+	return st
+}
+
+// Starts accounting time, the process being stopped. Callers hold mu.
+func (dbp *DebuggedProcess) startTiming() {
+	dbp.stateSince = time.Now()
+	dbp.sessionStart = dbp.stateSince
+}
+
+// Accounts the time the process was stopped for
+// as it is resumed. Callers hold mu.
+func (dbp *DebuggedProcess) timeResume() {
+	now := time.Now()
+	stop := now.Sub(dbp.stateSince)
+	dbp.times.Stopped += stop
+	if stop > dbp.times.LongestStop {
+		dbp.times.LongestStop = stop
+	}
+	dbp.times.Resumes++
+	dbp.stateSince, dbp.timedRunning = now, true
+}
+
+// Accounts the time the process ran for as it stops. Callers hold mu.
+func (dbp *DebuggedProcess) timeStop() {
+	now := time.Now()
+	dbp.times.Running += now.Sub(dbp.stateSince)
+	dbp.stateSince, dbp.timedRunning = now, false
+}
@@ -0,0 +1,68 @@
+package proctl
+
+import "fmt"
+
+// A set of writes to the memory of the process which can be undone
+// together, so that an operation failing halfway, e.g. after writing
+// part of a value, does not leave the process with a mix of old and
+// new bytes.
+type MemoryTransaction struct {
+	thread *ThreadContext
+	undo   []memoryWrite
+}
+
+// The bytes a write replaced.
+type memoryWrite struct {
+	addr     uintptr
+	original []byte
+}
+
+// Starts a transaction writing through thread.
+func (thread *ThreadContext) BeginWrite() *MemoryTransaction {
+	return &MemoryTransaction{thread: thread}
+}
+
+// Writes data at addr, after saving the bytes it replaces. A write
+// which fails partway restores what it already wrote before
+// returning the error, writes done earlier in the transaction are
+// left to Rollback.
+func (tx *MemoryTransaction) WriteMemory(addr uintptr, data []byte) error {
+	backend := tx.thread.Process.backend
+	original := make([]byte, len(data))
+	if _, err := backend.readMemory(tx.thread, addr, original); err != nil {
+		return err
+	}
+	n, err := backend.writeMemory(tx.thread, addr, data)
+	if err == nil && n < len(data) {
+		err = fmt.Errorf("short write, %d of %d bytes written", n, len(data))
+	}
+	if err != nil {
+		if n > 0 {
+			if _, rerr := backend.writeMemory(tx.thread, addr, original[:n]); rerr != nil {
+				return fmt.Errorf("%s, and could not restore %#x: %s", err, addr, rerr)
+			}
+		}
+		return err
+	}
+	tx.undo = append(tx.undo, memoryWrite{addr, original})
+	return nil
+}
+
+// Restores the bytes replaced by the writes of the transaction, last
+// write first. Returns the first error but keeps restoring the rest.
+func (tx *MemoryTransaction) Rollback() error {
+	var firstErr error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		w := tx.undo[i]
+		if _, err := tx.thread.Process.backend.writeMemory(tx.thread, w.addr, w.original); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not restore %#x: %s", w.addr, err)
+		}
+	}
+	tx.undo = nil
+	return firstErr
+}
+
+// Keeps the writes of the transaction.
+func (tx *MemoryTransaction) Commit() {
+	tx.undo = nil
+}
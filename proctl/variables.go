@@ -3,25 +3,35 @@ package proctl
 import (
 	"bytes"
 	"debug/dwarf"
+	"debug/gosym"
 	"encoding/binary"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 
-	"github.com/derekparker/delve/dwarf/op"
-	"github.com/derekparker/delve/dwarf/reader"
+	"github.com/derekparker/delve/dwarf/types"
 )
 
-const (
-	maxVariableRecurse = 1
-	maxArrayValues     = 64
-)
+const maxMemoryRead = 1 << 20
 
+// The value of a variable or expression. Value is the value as the
+// commands print it, Kind, Addr, Len and Cap describe it for clients
+// showing values their own way, e.g. as trees GUIs expand, which read
+// what is under a value with Children as it is expanded.
 type Variable struct {
 	Name  string
 	Value string
 	Type  string
+
+	Kind types.Kind
+	Addr uint64 // Where the value is, 0 for values computed by the debugger
+	Len  int64  // Of arrays, strings, slices, maps and channels
+	Cap  int64  // Of arrays, slices and channels
+
+	thread *ThreadContext
+	typ    dwarf.Type // Nil for values computed by the debugger
 }
 
 type M struct {
@@ -31,239 +41,340 @@ type M struct {
 	curg     uintptr
 }
 
-const ptrsize uintptr = unsafe.Sizeof(int(1))
+// G represents a runtime G (goroutine) structure, or
+// at least the parts of it Delve is interested in.
+type G struct {
+	Id   int         // Goroutine ID
+	PC   uint64      // PC the goroutine was parked at
+	SP   uint64      // SP the goroutine was parked at
+	File string      // File of the PC the goroutine was parked at
+	Line int         // Line of the PC the goroutine was parked at
+	Func *gosym.Func // Function the goroutine was parked in
+	addr uint64      // Address of the runtime.g structure
+
+	status     uint64 // Scheduling status, one of the gstatus constants
+	startpc    uint64 // PC of the function the goroutine was started with
+	gopc       uint64 // Return address of the go statement which created the goroutine
+	parentGoid uint64 // ID of the goroutine which created it, 0 if not known
+	stacklo    uint64 // Bounds of the goroutine stack, [stacklo, stackhi)
+	stackhi    uint64
+	waitreason string // Why it is waiting, e.g. chan receive, empty if not known
+	waitsince  int64  // Runtime nanotime since which it is blocked, 0 if not known
+	lockedm    bool   // Whether it is locked to its thread
+}
+
+// Scheduling states of a goroutine, see runtime.g.atomicstatus.
+const (
+	gstatusIdle      = 0
+	gstatusRunnable  = 1
+	gstatusRunning   = 2
+	gstatusSyscall   = 3
+	gstatusWaiting   = 4
+	gstatusDead      = 6
+	gstatusCopystack = 8
+	gstatusPreempted = 9
+	gstatusScan      = 0x1000
+)
 
-// Parses and returns select info on the internal M
-// data structures used by the Go scheduler.
-func (thread *ThreadContext) AllM() ([]*M, error) {
-	reader := thread.Process.Dwarf.Reader()
+// Returns the name of the scheduling state of a goroutine.
+func gstatusName(status uint64) string {
+	switch status &^ gstatusScan {
+	case gstatusIdle:
+		return "idle"
+	case gstatusRunnable:
+		return "runnable"
+	case gstatusRunning:
+		return "running"
+	case gstatusSyscall:
+		return "syscall"
+	case gstatusWaiting:
+		return "waiting"
+	case gstatusDead:
+		return "dead"
+	case gstatusCopystack:
+		return "copystack"
+	case gstatusPreempted:
+		return "preempted"
+	}
+	return fmt.Sprintf("status %d", status)
+}
+
+// Returns the state of g as runtime tracebacks print it, e.g.
+// "chan receive, 3 minutes": the reason a waiting goroutine waits
+// rather than its scheduling status, how many minutes it has been
+// blocked for once it has been for a minute, and whether it is locked
+// to its thread. The duration is only known for live processes.
+func (dbp *DebuggedProcess) GoroutineState(g *G) string {
+	state := gstatusName(g.status)
+	if g.status == gstatusWaiting && g.waitreason != "" {
+		state = g.waitreason
+	}
+	if (g.status == gstatusWaiting || g.status == gstatusSyscall) && g.waitsince != 0 {
+		if now, err := dbp.nanotime(); err == nil {
+			if minutes := (now - g.waitsince) / int64(time.Minute); minutes >= 1 {
+				state += fmt.Sprintf(", %d minutes", minutes)
+			}
+		}
+	}
+	if g.lockedm {
+		state += ", locked to thread"
+	}
+	return state
+}
 
-	allmaddr, err := parseAllMPtr(thread.Process, reader)
+// Returns the current value of the monotonic clock the runtime of the
+// process measures time with, see runtime.nanotime.
+func (dbp *DebuggedProcess) nanotime() (int64, error) {
+	if name := dbp.backend.info().Name; name != "native" {
+		return 0, fmt.Errorf("the clock of %s targets is unknown", name)
+	}
+	return monotonicNow()
+}
+
+// Reads the reason the goroutine whose runtime.g of type gtype is at
+// gaddr waits: a string in old runtimes, an index into
+// runtime.waitReasonStrings since Go 1.11.
+func (dbp *DebuggedProcess) readWaitReason(gaddr uint64, gtype *dwarf.StructType) (string, error) {
+	f, err := structField(gtype, "waitreason")
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	mptr, err := thread.readMemory(uintptr(allmaddr), ptrsize)
+	if dbp.goType(f.Type).Kind == types.String {
+		return dbp.CurrentThread.readString(uintptr(gaddr + uint64(f.ByteOffset)))
+	}
+	reason, err := dbp.readUintField(gaddr, gtype, "waitreason")
+	if err != nil || reason == 0 {
+		return "", err
+	}
+	addr, t, err := dbp.globalVariable("runtime.waitReasonStrings")
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	m := binary.LittleEndian.Uint64(mptr)
-	if m == 0 {
-		return nil, fmt.Errorf("allm contains no M pointers")
+	reasons, ok := resolveTypedef(t).(*dwarf.ArrayType)
+	if !ok {
+		return "", fmt.Errorf("unexpected type %s for runtime.waitReasonStrings", t)
 	}
+	if reason >= uint64(reasons.Count) {
+		return fmt.Sprintf("wait reason %d", reason), nil
+	}
+	return dbp.CurrentThread.readString(uintptr(addr + reason*uint64(reasons.Type.Size())))
+}
 
-	// parse addresses
-	procidInstructions, err := instructionsFor("procid", thread.Process, reader, true)
-	if err != nil {
-		return nil, err
+const ptrsize uintptr = unsafe.Sizeof(int(1))
+
+// Decodes a pointer sized value read from the memory of the process.
+func decodePointer(b []byte) uint64 {
+	if ptrsize == 4 {
+		return uint64(binary.LittleEndian.Uint32(b))
 	}
-	spinningInstructions, err := instructionsFor("spinning", thread.Process, reader, true)
+	return binary.LittleEndian.Uint64(b)
+}
+
+// Parses and returns select info on the internal M
+// data structures used by the Go scheduler.
+func (thread *ThreadContext) AllM() ([]*M, error) {
+	dbp := thread.Process
+	mtype, err := dbp.findStructType("runtime.m")
 	if err != nil {
 		return nil, err
 	}
-	alllinkInstructions, err := instructionsFor("alllink", thread.Process, reader, true)
+	allmaddr, err := parseAllMPtr(dbp)
 	if err != nil {
 		return nil, err
 	}
-	blockedInstructions, err := instructionsFor("blocked", thread.Process, reader, true)
+	m, err := dbp.readPointer(allmaddr)
 	if err != nil {
 		return nil, err
 	}
-	curgInstructions, err := instructionsFor("curg", thread.Process, reader, true)
-	if err != nil {
-		return nil, err
+	if m == 0 {
+		return nil, fmt.Errorf("allm contains no M pointers")
 	}
 
 	var allm []*M
-	for {
-		// curg
-		curgAddr, err := executeMemberStackProgram(mptr, curgInstructions)
-		if err != nil {
-			return nil, err
-		}
-		curgBytes, err := thread.readMemory(uintptr(curgAddr), ptrsize)
-		if err != nil {
-			return nil, fmt.Errorf("could not read curg %#v %s", curgAddr, err)
-		}
-		curg := binary.LittleEndian.Uint64(curgBytes)
-
-		// procid
-		procidAddr, err := executeMemberStackProgram(mptr, procidInstructions)
+	for m != 0 {
+		curg, err := dbp.readUintField(m, mtype, "curg")
 		if err != nil {
-			return nil, err
-		}
-		procidBytes, err := thread.readMemory(uintptr(procidAddr), ptrsize)
-		if err != nil {
-			return nil, fmt.Errorf("could not read procid %#v %s", procidAddr, err)
-		}
-		procid := binary.LittleEndian.Uint64(procidBytes)
-
-		// spinning
-		spinningAddr, err := executeMemberStackProgram(mptr, spinningInstructions)
-		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("could not read curg %#v %s", m, err)
 		}
-		spinBytes, err := thread.readMemory(uintptr(spinningAddr), 1)
+		procid, err := dbp.readUintField(m, mtype, "procid")
 		if err != nil {
-			return nil, fmt.Errorf("could not read spinning %#v %s", spinningAddr, err)
-		}
-
-		// blocked
-		blockedAddr, err := executeMemberStackProgram(mptr, blockedInstructions)
-		if err != nil {
-			return nil, err
-		}
-		blockBytes, err := thread.readMemory(uintptr(blockedAddr), 1)
-		if err != nil {
-			return nil, fmt.Errorf("could not read blocked %#v %s", blockedAddr, err)
+			return nil, fmt.Errorf("could not read procid %#v %s", m, err)
 		}
+		// Both changed over time, and are best effort.
+		spinning, _ := dbp.readUintField(m, mtype, "spinning")
+		blocked, _ := dbp.readUintField(m, mtype, "blocked")
 
 		allm = append(allm, &M{
 			procid:   int(procid),
-			blocked:  blockBytes[0],
-			spinning: spinBytes[0],
+			blocked:  uint8(blocked),
+			spinning: uint8(spinning),
 			curg:     uintptr(curg),
 		})
 
 		// Follow the linked list
-		alllinkAddr, err := executeMemberStackProgram(mptr, alllinkInstructions)
-		if err != nil {
-			return nil, err
-		}
-		mptr, err = thread.readMemory(uintptr(alllinkAddr), ptrsize)
+		next, err := dbp.readUintField(m, mtype, "alllink")
 		if err != nil {
-			return nil, fmt.Errorf("could not read alllink %#v %s", alllinkAddr, err)
-		}
-		m = binary.LittleEndian.Uint64(mptr)
-
-		if m == 0 {
-			break
+			return nil, fmt.Errorf("could not read alllink %#v %s", m, err)
 		}
+		m = next
 	}
 
 	return allm, nil
 }
 
-func instructionsFor(name string, dbp *DebuggedProcess, reader *dwarf.Reader, member bool) ([]byte, error) {
-	reader.Seek(0)
-	entry, err := findDwarfEntry(name, reader, member)
+func parseAllMPtr(dbp *DebuggedProcess) (uint64, error) {
+	entry, err := dbp.packageVariableEntry("runtime.allm")
 	if err != nil {
-		return nil, err
-	}
-	return instructionsForEntry(entry)
-}
-
-func instructionsForEntry(entry *dwarf.Entry) ([]byte, error) {
-	if entry.Tag == dwarf.TagMember {
-		instructions, ok := entry.Val(dwarf.AttrDataMemberLoc).([]byte)
-		if !ok {
-			return nil, fmt.Errorf("member data has no data member location attribute")
-		}
-		// clone slice to prevent stomping on the dwarf data
-		return append([]byte{}, instructions...), nil
+		return 0, err
 	}
 
-	// non-member
 	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
 	if !ok {
-		return nil, fmt.Errorf("entry has no location attribute")
+		return 0, fmt.Errorf("type assertion failed")
 	}
-
-	// clone slice to prevent stomping on the dwarf data
-	return append([]byte{}, instructions...), nil
+	return dbp.staticAddr(instructions)
 }
 
-func executeMemberStackProgram(base, instructions []byte) (uint64, error) {
-	parentInstructions := append([]byte{op.DW_OP_addr}, base...)
-	addr, err := op.ExecuteStackProgram(0, append(parentInstructions, instructions...))
+func (dbp *DebuggedProcess) PrintGoroutinesInfo() error {
+	goroutines, err := dbp.Goroutines()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	return uint64(addr), nil
-}
+	fmt.Printf("[%d goroutines]\n", len(goroutines))
+	for _, g := range goroutines {
+		fname := ""
+		if g.Func != nil {
+			fname = g.Func.Name
+		}
+		fmt.Printf("Goroutine %d [%s] - %s:%d %s\n", g.Id, dbp.GoroutineState(g), g.File, g.Line, fname)
 
-func parseAllMPtr(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
-	entry, err := findDwarfEntry("runtime.allm", reader, false)
-	if err != nil {
-		return 0, err
+		cases, err := dbp.SelectCases(g)
+		if err != nil {
+			continue
+		}
+		for _, c := range cases {
+			fmt.Printf("\t%s\n", c)
+		}
 	}
 
-	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
-	if !ok {
-		return 0, fmt.Errorf("type assertion failed")
+	return nil
+}
+
+// Returns all goroutines known to the runtime of the process.
+func (dbp *DebuggedProcess) Goroutines() ([]*G, error) {
+	allglen, err := allglenval(dbp)
+	if err != nil {
+		return nil, err
 	}
-	addr, err := op.ExecuteStackProgram(0, instructions)
+	allgentryaddr, err := addressFor(dbp, "runtime.allg")
 	if err != nil {
-		return 0, err
+		// Newer runtimes keep them in the allgs slice,
+		// which starts with the pointer to its array.
+		if allgentryaddr, _, err = dbp.globalVariable("runtime.allgs"); err != nil {
+			return nil, err
+		}
 	}
-
-	return uint64(addr), nil
-}
-
-func (dbp *DebuggedProcess) PrintGoroutinesInfo() error {
-	reader := dbp.Dwarf.Reader()
-
-	allglen, err := allglenval(dbp, reader)
+	allg, err := dbp.readPointer(allgentryaddr)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	reader.Seek(0)
-	allgentryaddr, err := addressFor(dbp, "runtime.allg", reader)
+
+	gtype, err := dbp.findStructType("runtime.g")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Printf("[%d goroutines]\n", allglen)
-	faddr, err := dbp.CurrentThread.readMemory(uintptr(allgentryaddr), ptrsize)
-	allg := binary.LittleEndian.Uint64(faddr)
 
+	goroutines := make([]*G, 0, allglen)
 	for i := uint64(0); i < allglen; i++ {
-		err = printGoroutineInfo(dbp, allg+(i*uint64(ptrsize)), reader)
+		g, err := dbp.parseG(allg+(i*uint64(ptrsize)), gtype)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		goroutines = append(goroutines, g)
 	}
 
-	return nil
+	return goroutines, nil
 }
 
-func printGoroutineInfo(dbp *DebuggedProcess, addr uint64, reader *dwarf.Reader) error {
-	gaddrbytes, err := dbp.CurrentThread.readMemory(uintptr(addr), ptrsize)
+// Parses the G pointed to by the pointer stored at addr.
+func (dbp *DebuggedProcess) parseG(addr uint64, gtype *dwarf.StructType) (*G, error) {
+	gaddr, err := dbp.readPointer(addr)
 	if err != nil {
-		return fmt.Errorf("error derefing *G %s", err)
+		return nil, fmt.Errorf("error derefing *G %s", err)
 	}
-	initialInstructions := append([]byte{op.DW_OP_addr}, gaddrbytes...)
 
-	reader.Seek(0)
-	goidaddr, err := offsetFor(dbp, "goid", reader, initialInstructions)
+	goid, err := dbp.readUintField(gaddr, gtype, "goid")
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("error reading goid %s", err)
 	}
-	reader.Seek(0)
-	schedaddr, err := offsetFor(dbp, "sched", reader, initialInstructions)
+
+	sched, err := structField(gtype, "sched")
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	goidbytes, err := dbp.CurrentThread.readMemory(uintptr(goidaddr), ptrsize)
+	gobuf, ok := resolveTypedef(sched.Type).(*dwarf.StructType)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type %s for sched", sched.Type)
+	}
+	schedaddr := gaddr + uint64(sched.ByteOffset)
+	pc, err := dbp.readUintField(schedaddr, gobuf, "pc")
 	if err != nil {
-		return fmt.Errorf("error reading goid %s", err)
+		return nil, fmt.Errorf("error reading sched %s", err)
 	}
-	schedbytes, err := dbp.CurrentThread.readMemory(uintptr(schedaddr+uint64(ptrsize)), ptrsize)
+	sp, err := dbp.readUintField(schedaddr, gobuf, "sp")
 	if err != nil {
-		return fmt.Errorf("error reading sched %s", err)
+		return nil, fmt.Errorf("error reading sched %s", err)
 	}
-	gopc := binary.LittleEndian.Uint64(schedbytes)
-	f, l, fn := dbp.GoSymTable.PCToLine(gopc)
-	fname := ""
-	if fn != nil {
-		fname = fn.Name
+
+	// The status field was renamed over time, and the start
+	// PC is missing in old runtimes, both are best effort.
+	status, err := dbp.readUintField(gaddr, gtype, "atomicstatus")
+	if err != nil {
+		status, _ = dbp.readUintField(gaddr, gtype, "status")
 	}
-	fmt.Printf("Goroutine %d - %s:%d %s\n", binary.LittleEndian.Uint64(goidbytes), f, l, fname)
-	return nil
+	startpc, _ := dbp.readUintField(gaddr, gtype, "startpc")
+	gopc, _ := dbp.readUintField(gaddr, gtype, "gopc")
+	parentGoid, _ := dbp.readUintField(gaddr, gtype, "parentGoid")
+	var stacklo, stackhi uint64
+	if stack, err := structField(gtype, "stack"); err == nil {
+		if st, ok := resolveTypedef(stack.Type).(*dwarf.StructType); ok {
+			stacklo, _ = dbp.readUintField(gaddr+uint64(stack.ByteOffset), st, "lo")
+			stackhi, _ = dbp.readUintField(gaddr+uint64(stack.ByteOffset), st, "hi")
+		}
+	}
+
+	status &^= gstatusScan
+	var waitreason string
+	if status == gstatusWaiting {
+		waitreason, _ = dbp.readWaitReason(gaddr, gtype)
+	}
+	waitsince, _ := dbp.readIntField(gaddr, gtype, "waitsince")
+	lockedm, _ := dbp.readUintField(gaddr, gtype, "lockedm")
+
+	f, l, fn := dbp.GoSymTable.PCToLine(pc)
+	return &G{
+		Id:         int(goid),
+		PC:         pc,
+		SP:         sp,
+		File:       f,
+		Line:       l,
+		Func:       fn,
+		addr:       gaddr,
+		status:     status,
+		startpc:    startpc,
+		gopc:       gopc,
+		parentGoid: parentGoid,
+		stacklo:    stacklo,
+		stackhi:    stackhi,
+		waitreason: waitreason,
+		waitsince:  waitsince,
+		lockedm:    lockedm != 0,
+	}, nil
 }
 
-func allglenval(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
-	entry, err := findDwarfEntry("runtime.allglen", reader, false)
+func allglenval(dbp *DebuggedProcess) (uint64, error) {
+	entry, err := dbp.packageVariableEntry("runtime.allglen")
 	if err != nil {
 		return 0, err
 	}
@@ -272,19 +383,19 @@ func allglenval(dbp *DebuggedProcess, reader *dwarf.Reader) (uint64, error) {
 	if !ok {
 		return 0, fmt.Errorf("type assertion failed")
 	}
-	addr, err := op.ExecuteStackProgram(0, instructions)
+	addr, err := dbp.staticAddr(instructions)
 	if err != nil {
 		return 0, err
 	}
-	val, err := dbp.CurrentThread.readMemory(uintptr(addr), 8)
+	val, err := dbp.CurrentThread.readMemory(uintptr(addr), ptrsize)
 	if err != nil {
 		return 0, err
 	}
-	return binary.LittleEndian.Uint64(val), nil
+	return decodePointer(val), nil
 }
 
-func addressFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader) (uint64, error) {
-	entry, err := findDwarfEntry(name, reader, false)
+func addressFor(dbp *DebuggedProcess, name string) (uint64, error) {
+	entry, err := dbp.packageVariableEntry(name)
 	if err != nil {
 		return 0, err
 	}
@@ -293,80 +404,36 @@ func addressFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader) (uint64
 	if !ok {
 		return 0, fmt.Errorf("type assertion failed")
 	}
-	addr, err := op.ExecuteStackProgram(0, instructions)
-	if err != nil {
-		return 0, err
-	}
-
-	return uint64(addr), nil
+	return dbp.staticAddr(instructions)
 }
 
-func offsetFor(dbp *DebuggedProcess, name string, reader *dwarf.Reader, parentinstr []byte) (uint64, error) {
-	entry, err := findDwarfEntry(name, reader, true)
-	if err != nil {
-		return 0, err
-	}
-	instructions, ok := entry.Val(dwarf.AttrDataMemberLoc).([]byte)
-	if !ok {
-		return 0, fmt.Errorf("type assertion failed")
-	}
-	offset, err := op.ExecuteStackProgram(0, append(parentinstr, instructions...))
-	if err != nil {
-		return 0, err
-	}
-
-	return uint64(offset), nil
-}
-
-// Returns the value of the named symbol.
+// Returns the value of the Go expression name. Besides plain names,
+// it may select struct members, e.g. foo.bar.baz, dereference
+// pointers, e.g. (*p).field, with p.field dereferencing implicitly,
+// index, convert, take addresses with & and apply the arithmetic,
+// comparison and logical operators, e.g. len(s) > 0 && s[0] == 'a'.
 func (thread *ThreadContext) EvalSymbol(name string) (*Variable, error) {
-	pc, err := thread.CurrentPC()
+	expr, err := parseExpr(name)
 	if err != nil {
 		return nil, err
 	}
 
-	reader := thread.Process.DwarfReader()
-
-	_, err = reader.SeekToFunction(pc)
+	v, err := thread.evalExpr(expr)
 	if err != nil {
 		return nil, err
 	}
-
-	varName := name
-	memberName := ""
-	if strings.Contains(name, ".") {
-		idx := strings.Index(name, ".")
-		varName = name[:idx]
-		memberName = name[idx+1:]
-	}
-
-	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
-		if err != nil {
-			return nil, err
-		}
-
-		n, ok := entry.Val(dwarf.AttrName).(string)
-		if !ok {
-			continue
-		}
-
-		if n == varName {
-			if len(memberName) == 0 {
-				return thread.extractVariableFromEntry(entry)
-			}
-			return thread.evaluateStructMember(entry, reader, memberName)
-		}
-	}
-
-	return nil, fmt.Errorf("could not find symbol value for %s", name)
+	v.Name = name
+	return v, nil
 }
 
-// LocalVariables returns all local variables from the current function scope.
+// LocalVariables returns all local variables from the current function scope,
+// including those declared in nested blocks that are in scope at the current PC.
 func (thread *ThreadContext) LocalVariables() ([]*Variable, error) {
 	return thread.variablesByTag(dwarf.TagVariable)
 }
 
-// FunctionArguments returns the name, value, and type of all current function arguments.
+// FunctionArguments returns the name, value, and type of all current function arguments,
+// in the order they are declared in the function signature.
 func (thread *ThreadContext) FunctionArguments() ([]*Variable, error) {
 	return thread.variablesByTag(dwarf.TagFormalParameter)
 }
@@ -393,109 +460,6 @@ func (thread *ThreadContext) PackageVariables() ([]*Variable, error) {
 	return vars, nil
 }
 
-func findDwarfEntry(name string, reader *dwarf.Reader, member bool) (*dwarf.Entry, error) {
-	depth := 1
-	for entry, err := reader.Next(); entry != nil; entry, err = reader.Next() {
-		if err != nil {
-			return nil, err
-		}
-
-		if entry.Children {
-			depth++
-		}
-
-		if entry.Tag == 0 {
-			depth--
-			if depth <= 0 {
-				return nil, fmt.Errorf("could not find symbol value for %s", name)
-			}
-		}
-
-		if member {
-			if entry.Tag != dwarf.TagMember {
-				continue
-			}
-		} else {
-			if entry.Tag != dwarf.TagVariable && entry.Tag != dwarf.TagFormalParameter && entry.Tag != dwarf.TagStructType {
-				continue
-			}
-		}
-
-		n, ok := entry.Val(dwarf.AttrName).(string)
-		if !ok || n != name {
-			continue
-		}
-		return entry, nil
-	}
-	return nil, fmt.Errorf("could not find symbol value for %s", name)
-}
-
-func (thread *ThreadContext) evaluateStructMember(parentEntry *dwarf.Entry, reader *reader.Reader, memberName string) (*Variable, error) {
-	parentAddr, err := thread.extractVariableDataAddress(parentEntry, reader)
-	if err != nil {
-		return nil, err
-	}
-
-	// Get parent variable name
-	parentName, ok := parentEntry.Val(dwarf.AttrName).(string)
-	if !ok {
-		return nil, fmt.Errorf("unable to retrive variable name")
-	}
-
-	// Seek reader to the type information so members can be iterated
-	_, err = reader.SeekToType(parentEntry, true, true)
-	if err != nil {
-		return nil, err
-	}
-
-	// Iterate to find member by name
-	for memberEntry, err := reader.NextMemberVariable(); memberEntry != nil; memberEntry, err = reader.NextMemberVariable() {
-		if err != nil {
-			return nil, err
-		}
-
-		name, ok := memberEntry.Val(dwarf.AttrName).(string)
-		if !ok {
-			continue
-		}
-
-		if name == memberName {
-			// Nil ptr, wait until here to throw a nil pointer error to prioritize no such member error
-			if parentAddr == 0 {
-				return nil, fmt.Errorf("%s is nil", parentName)
-			}
-
-			memberInstr, err := instructionsForEntry(memberEntry)
-			if err != nil {
-				return nil, err
-			}
-
-			offset, ok := memberEntry.Val(dwarf.AttrType).(dwarf.Offset)
-			if !ok {
-				return nil, fmt.Errorf("type assertion failed")
-			}
-
-			data := thread.Process.Dwarf
-			t, err := data.Type(offset)
-			if err != nil {
-				return nil, err
-			}
-
-			baseAddr := make([]byte, 8)
-			binary.LittleEndian.PutUint64(baseAddr, uint64(parentAddr))
-
-			parentInstructions := append([]byte{op.DW_OP_addr}, baseAddr...)
-			val, err := thread.extractValue(append(parentInstructions, memberInstr...), 0, t, true)
-			if err != nil {
-				return nil, err
-			}
-			return &Variable{Name: strings.Join([]string{parentName, memberName}, "."), Type: t.String(), Value: val}, nil
-		}
-	}
-
-	return nil, fmt.Errorf("%s has no member %s", parentName, memberName)
-}
-
 // Extracts the name, type, and value of a variable from a dwarf entry
 func (thread *ThreadContext) extractVariableFromEntry(entry *dwarf.Entry) (*Variable, error) {
 	if entry == nil {
@@ -522,254 +486,209 @@ func (thread *ThreadContext) extractVariableFromEntry(entry *dwarf.Entry) (*Vari
 		return nil, err
 	}
 
-	instructions, ok := entry.Val(dwarf.AttrLocation).([]byte)
-	if !ok {
-		return nil, fmt.Errorf("type assertion failed")
-	}
-
-	val, err := thread.extractValue(instructions, 0, t, true)
+	pc, err := thread.scopePC()
 	if err != nil {
 		return nil, err
 	}
-
-	return &Variable{Name: n, Type: t.String(), Value: val}, nil
-}
-
-// Execute the stack program taking into account the current stack frame
-func (thread *ThreadContext) executeStackProgram(instructions []byte) (int64, error) {
-	regs, err := thread.Registers()
-	if err != nil {
-		return 0, err
-	}
-
-	fde, err := thread.Process.FrameEntries.FDEForPC(regs.PC())
-	if err != nil {
-		return 0, err
-	}
-
-	fctx := fde.EstablishFrame(regs.PC())
-	cfa := fctx.CFAOffset() + int64(regs.SP())
-	address, err := op.ExecuteStackProgram(cfa, instructions)
-	if err != nil {
-		return 0, err
-	}
-	return address, nil
-}
-
-// Extracts the address of a variable, dereferencing any pointers
-func (thread *ThreadContext) extractVariableDataAddress(entry *dwarf.Entry, reader *reader.Reader) (int64, error) {
-	instructions, err := instructionsForEntry(entry)
+	instructions, err := thread.Process.locationExpr(entry, pc)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-
-	address, err := thread.executeStackProgram(instructions)
+	addr, err := thread.locationAddr(instructions, t.Size())
 	if err != nil {
-		return 0, err
-	}
-
-	// Dereference pointers to get down the concrete type
-	for typeEntry, err := reader.SeekToType(entry, true, false); typeEntry != nil; typeEntry, err = reader.SeekToType(typeEntry, true, false) {
-		if err != nil {
-			return 0, err
-		}
-
-		if typeEntry.Tag != dwarf.TagPointerType {
-			break
-		}
-
-		ptraddress := uintptr(address)
-
-		ptr, err := thread.readMemory(ptraddress, ptrsize)
-		if err != nil {
-			return 0, err
-		}
-		address = int64(binary.LittleEndian.Uint64(ptr))
+		return nil, err
 	}
 
-	return address, nil
+	return thread.newVariable(n, uint64(addr), t)
 }
 
 // Extracts the value from the instructions given in the DW_AT_location entry.
 // We execute the stack program described in the DW_OP_* instruction stream, and
 // then grab the value from the other processes memory.
-func (thread *ThreadContext) extractValue(instructions []byte, addr int64, typ interface{}, printStructName bool) (string, error) {
+func (thread *ThreadContext) extractValue(instructions []byte, addr int64, typ dwarf.Type, printStructName bool) (string, error) {
 	return thread.extractValueInternal(instructions, addr, typ, printStructName, 0)
 }
 
-func (thread *ThreadContext) extractValueInternal(instructions []byte, addr int64, typ interface{}, printStructName bool, recurseLevel int) (string, error) {
+func (thread *ThreadContext) extractValueInternal(instructions []byte, addr int64, typ dwarf.Type, printStructName bool, recurseLevel int) (string, error) {
 	var err error
 
 	if addr == 0 {
-		addr, err = thread.executeStackProgram(instructions)
+		addr, err = thread.locationAddr(instructions, typ.Size())
 		if err != nil {
 			return "", err
 		}
 	}
 
-	// If we have a user defined type, find the
-	// underlying concrete type and use that.
-	for {
-		if tt, ok := typ.(*dwarf.TypedefType); ok {
-			typ = tt.Type
-		} else {
-			break
-		}
-	}
+	return thread.readValue(uintptr(addr), thread.Process.goType(typ), printStructName, recurseLevel)
+}
 
-	ptraddress := uintptr(addr)
-	switch t := typ.(type) {
-	case *dwarf.PtrType:
-		ptr, err := thread.readMemory(ptraddress, ptrsize)
+// Reads the value of type t at addr, according to its kind.
+func (thread *ThreadContext) readValue(addr uintptr, t *types.Type, printStructName bool, recurseLevel int) (string, error) {
+	switch t.Kind {
+	case types.Pointer:
+		ptr, err := thread.readMemory(addr, ptrsize)
 		if err != nil {
 			return "", err
 		}
 
-		intaddr := int64(binary.LittleEndian.Uint64(ptr))
-		if intaddr == 0 {
-			return fmt.Sprintf("%s nil", t.String()), nil
+		ptraddr := uintptr(decodePointer(ptr))
+		if ptraddr == 0 {
+			return fmt.Sprintf("%s nil", t), nil
+		}
+		if t.Elem == nil || !thread.Process.loadConfig().FollowPointers {
+			return fmt.Sprintf("%s %#x", t, ptraddr), nil
 		}
 
 		// Don't increase the recursion level when dereferencing pointers
-		val, err := thread.extractValueInternal(nil, intaddr, t.Type, printStructName, recurseLevel)
+		val, err := thread.readValue(ptraddr, t.Elem, printStructName, recurseLevel)
 		if err != nil {
 			return "", err
 		}
 
 		return fmt.Sprintf("*%s", val), nil
-	case *dwarf.StructType:
-		switch {
-		case t.StructName == "string":
-			return thread.readString(ptraddress)
-		case strings.HasPrefix(t.StructName, "[]"):
-			return thread.readSlice(ptraddress, t)
-		default:
-			// Recursively call extractValue to grab
-			// the value of all the members of the struct.
-			if recurseLevel <= maxVariableRecurse {
-				fields := make([]string, 0, len(t.Field))
-				for _, field := range t.Field {
-					val, err := thread.extractValueInternal(nil, field.ByteOffset+addr, field.Type, printStructName, recurseLevel+1)
-					if err != nil {
-						return "", err
-					}
-
-					fields = append(fields, fmt.Sprintf("%s: %s", field.Name, val))
-				}
+	case types.Map, types.Chan:
+		// Shown as the pointers to the runtime structs they are.
+		return thread.readValue(addr, thread.Process.goType(t.Dwarf), printStructName, recurseLevel)
+	case types.String:
+		return thread.readStringValue(addr)
+	case types.Slice:
+		return thread.readSlice(addr, t)
+	case types.Interface:
+		return thread.readInterface(uint64(addr), t.Dwarf.(*dwarf.StructType), recurseLevel)
+	case types.Struct:
+		if pp := prettyPrinter(t.Name); pp != nil {
+			// Fall back to printing the raw fields if the
+			// value cannot be decoded for this runtime.
+			if val, err := pp(thread, uint64(addr), t.Dwarf.(*dwarf.StructType)); err == nil {
 				if printStructName {
-					return fmt.Sprintf("%s {%s}", t.StructName, strings.Join(fields, ", ")), nil
+					return fmt.Sprintf("%s {%s}", t, val), nil
+				}
+				return fmt.Sprintf("{%s}", val), nil
+			}
+		}
+		// Recursively read the values of all
+		// the members of the struct.
+		if max := thread.Process.loadConfig().MaxVariableRecurse; max < 0 || recurseLevel <= max {
+			fields := make([]string, 0, len(t.Fields))
+			for _, field := range t.Fields {
+				val, err := thread.readValue(addr+uintptr(field.Offset), field.Type, printStructName, recurseLevel+1)
+				if err != nil {
+					return "", err
 				}
-				return fmt.Sprintf("{%s}", strings.Join(fields, ", ")), nil
+
+				fields = append(fields, fmt.Sprintf("%s: %s", field.Name, val))
 			}
-			// no fields
 			if printStructName {
-				return fmt.Sprintf("%s {...}", t.StructName), nil
+				return fmt.Sprintf("%s {%s}", t, strings.Join(fields, ", ")), nil
 			}
-			return "{...}", nil
-		}
-	case *dwarf.ArrayType:
-		return thread.readArray(ptraddress, t)
-	case *dwarf.IntType:
-		return thread.readInt(ptraddress, t.ByteSize)
-	case *dwarf.UintType:
-		return thread.readUint(ptraddress, t.ByteSize)
-	case *dwarf.FloatType:
-		return thread.readFloat(ptraddress, t.ByteSize)
-	case *dwarf.BoolType:
-		return thread.readBool(ptraddress)
-	case *dwarf.FuncType:
-		return thread.readFunctionPtr(ptraddress)
-	case *dwarf.VoidType:
+			return fmt.Sprintf("{%s}", strings.Join(fields, ", ")), nil
+		}
+		// no fields
+		if printStructName {
+			return fmt.Sprintf("%s {...}", t), nil
+		}
+		return "{...}", nil
+	case types.Array:
+		return thread.readArray(addr, t)
+	case types.Int:
+		return thread.readInt(addr, t.Size)
+	case types.Uint:
+		return thread.readUint(addr, t.Size)
+	case types.Float:
+		return thread.readFloat(addr, t.Size)
+	case types.Bool:
+		return thread.readBool(addr)
+	case types.Func:
+		return thread.readFunctionPtr(addr)
+	case types.Void:
 		return "(void)", nil
-	case *dwarf.UnspecifiedType:
+	case types.Invalid:
 		return "(unknown)", nil
-	default:
-		fmt.Printf("Unknown type: %T\n", t)
 	}
 
-	return "", fmt.Errorf("could not find value for type %s", typ)
+	return "", fmt.Errorf("could not find value for type %s", t)
 }
 
 func (thread *ThreadContext) readString(addr uintptr) (string, error) {
+	s, _, err := thread.readStringMax(addr, -1)
+	return s, err
+}
+
+// Reads the string at addr to print it, up to the MaxStringLen of the
+// LoadConfig of the process.
+func (thread *ThreadContext) readStringValue(addr uintptr) (string, error) {
+	s, strlen, err := thread.readStringMax(addr, thread.Process.loadConfig().MaxStringLen)
+	if err != nil {
+		return "", err
+	}
+	if more := strlen - int64(len(s)); more > 0 {
+		s += fmt.Sprintf("...+%d more", more)
+	}
+	return s, nil
+}
+
+// Reads at most max bytes of the string at addr, all of them if max is
+// negative, returning them with the length of the string.
+func (thread *ThreadContext) readStringMax(addr uintptr, max int) (string, int64, error) {
 	// string data structure is always two ptrs in size. Addr, followed by len
 	// http://research.swtch.com/godata
 
 	// read len
 	val, err := thread.readMemory(addr+ptrsize, ptrsize)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	strlen := uintptr(binary.LittleEndian.Uint64(val))
+	strlen := int64(decodePointer(val))
 
 	// read addr
 	val, err = thread.readMemory(addr, ptrsize)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
-	addr = uintptr(binary.LittleEndian.Uint64(val))
+	addr = uintptr(decodePointer(val))
 
-	val, err = thread.readMemory(addr, strlen)
+	val, err = thread.readMemory(addr, uintptr(loadLimit(strlen, max)))
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	return *(*string)(unsafe.Pointer(&val)), nil
+	return *(*string)(unsafe.Pointer(&val)), strlen, nil
 }
 
-func (thread *ThreadContext) readSlice(addr uintptr, t *dwarf.StructType) (string, error) {
-	var sliceLen, sliceCap int64
-	var arrayAddr uintptr
-	var arrayType dwarf.Type
-	for _, f := range t.Field {
-		switch f.Name {
-		case "array":
-			val, err := thread.readMemory(addr+uintptr(f.ByteOffset), ptrsize)
-			if err != nil {
-				return "", err
-			}
-			arrayAddr = uintptr(binary.LittleEndian.Uint64(val))
-			// Dereference array type to get value type
-			ptrType, ok := f.Type.(*dwarf.PtrType)
-			if !ok {
-				return "", fmt.Errorf("Invalid type %s in slice array", f.Type)
-			}
-			arrayType = ptrType.Type
-		case "len":
-			lstr, err := thread.extractValue(nil, int64(addr+uintptr(f.ByteOffset)), f.Type, true)
-			if err != nil {
-				return "", err
-			}
-			sliceLen, err = strconv.ParseInt(lstr, 10, 64)
-			if err != nil {
-				return "", err
-			}
-		case "cap":
-			cstr, err := thread.extractValue(nil, int64(addr+uintptr(f.ByteOffset)), f.Type, true)
-			if err != nil {
-				return "", err
-			}
-			sliceCap, err = strconv.ParseInt(cstr, 10, 64)
-			if err != nil {
-				return "", err
-			}
-		}
+func (thread *ThreadContext) readSlice(addr uintptr, t *types.Type) (string, error) {
+	array, length, capacity := t.Field("array"), t.Field("len"), t.Field("cap")
+	if array == nil || length == nil || capacity == nil || t.Elem == nil {
+		return "", fmt.Errorf("invalid slice type %s", t)
+	}
+	val, err := thread.readMemory(addr+uintptr(array.Offset), ptrsize)
+	if err != nil {
+		return "", err
+	}
+	arrayAddr := uintptr(decodePointer(val))
+	sliceLen, err := thread.readUintRaw(addr+uintptr(length.Offset), length.Type.Size)
+	if err != nil {
+		return "", err
+	}
+	sliceCap, err := thread.readUintRaw(addr+uintptr(capacity.Offset), capacity.Type.Size)
+	if err != nil {
+		return "", err
 	}
 
-	stride := arrayType.Size()
-	if _, ok := arrayType.(*dwarf.PtrType); ok {
+	stride := t.Elem.Size
+	if t.Elem.Kind == types.Pointer {
 		stride = int64(ptrsize)
 	}
-	vals, err := thread.readArrayValues(arrayAddr, sliceLen, stride, arrayType)
+	vals, err := thread.readArrayValues(arrayAddr, int64(sliceLen), stride, t.Elem)
 	if err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("[]%s len: %d, cap: %d, [%s]", arrayType, sliceLen, sliceCap, strings.Join(vals, ",")), nil
+	return fmt.Sprintf("[]%s len: %d, cap: %d, [%s]", t.Elem, sliceLen, sliceCap, strings.Join(vals, ",")), nil
 }
 
-func (thread *ThreadContext) readArray(addr uintptr, t *dwarf.ArrayType) (string, error) {
-	if t.Count > 0 {
-		vals, err := thread.readArrayValues(addr, t.Count, t.ByteSize/t.Count, t.Type)
+func (thread *ThreadContext) readArray(addr uintptr, t *types.Type) (string, error) {
+	if t.Len > 0 {
+		vals, err := thread.readArrayValues(addr, t.Len, t.Size/t.Len, t.Elem)
 		if err != nil {
 			return "", err
 		}
@@ -779,17 +698,18 @@ func (thread *ThreadContext) readArray(addr uintptr, t *dwarf.ArrayType) (string
 	return fmt.Sprintf("%s []", t), nil
 }
 
-func (thread *ThreadContext) readArrayValues(addr uintptr, count int64, stride int64, t dwarf.Type) ([]string, error) {
+func (thread *ThreadContext) readArrayValues(addr uintptr, count int64, stride int64, t *types.Type) ([]string, error) {
 	vals := make([]string, 0)
 
+	n := loadLimit(count, thread.Process.loadConfig().MaxArrayValues)
 	for i := int64(0); i < count; i++ {
 		// Cap number of elements
-		if i >= maxArrayValues {
-			vals = append(vals, fmt.Sprintf("...+%d more", count-maxArrayValues))
+		if i >= n {
+			vals = append(vals, fmt.Sprintf("...+%d more", count-n))
 			break
 		}
 
-		val, err := thread.extractValue(nil, int64(addr+uintptr(i*stride)), t, false)
+		val, err := thread.readValue(addr+uintptr(i*stride), t, false, 0)
 		if err != nil {
 			return nil, err
 		}
@@ -821,11 +741,20 @@ func (thread *ThreadContext) readInt(addr uintptr, size int64) (string, error) {
 }
 
 func (thread *ThreadContext) readUint(addr uintptr, size int64) (string, error) {
+	n, err := thread.readUintRaw(addr, size)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatUint(n, 10), nil
+}
+
+func (thread *ThreadContext) readUintRaw(addr uintptr, size int64) (uint64, error) {
 	var n uint64
 
 	val, err := thread.readMemory(addr, uintptr(size))
 	if err != nil {
-		return "", err
+		return 0, err
 	}
 
 	switch size {
@@ -839,7 +768,7 @@ func (thread *ThreadContext) readUint(addr uintptr, size int64) (string, error)
 		n = uint64(binary.LittleEndian.Uint64(val))
 	}
 
-	return strconv.FormatUint(n, 10), nil
+	return n, nil
 }
 
 func (thread *ThreadContext) readFloat(addr uintptr, size int64) (string, error) {
@@ -883,7 +812,7 @@ func (thread *ThreadContext) readFunctionPtr(addr uintptr) (string, error) {
 	}
 
 	// dereference pointer to find function pc
-	addr = uintptr(binary.LittleEndian.Uint64(val))
+	addr = uintptr(decodePointer(val))
 	if addr == 0 {
 		return "nil", nil
 	}
@@ -893,10 +822,10 @@ func (thread *ThreadContext) readFunctionPtr(addr uintptr) (string, error) {
 		return "", err
 	}
 
-	funcAddr := binary.LittleEndian.Uint64(val)
+	funcAddr := decodePointer(val)
 	reader := thread.Process.DwarfReader()
 
-	entry, err := reader.SeekToFunction(funcAddr)
+	entry, err := reader.SeekToFunction(thread.Process.dwarfPC(funcAddr))
 	if err != nil {
 		return "", err
 	}
@@ -910,23 +839,37 @@ func (thread *ThreadContext) readFunctionPtr(addr uintptr) (string, error) {
 }
 
 func (thread *ThreadContext) readMemory(addr uintptr, size uintptr) ([]byte, error) {
+	if buf, ok, err := thread.Process.readComposite(addr, size); ok {
+		return buf, err
+	}
 	buf := make([]byte, size)
 
-	_, err := readMemory(thread, addr, buf)
+	_, err := thread.Process.backend.readMemory(thread, addr, buf)
 	if err != nil {
-		return nil, err
+		return nil, thread.Process.readError(uint64(addr), uint64(size), err)
 	}
 
 	return buf, nil
 }
 
+// Writes data at addr, either completely or not at all.
+func (thread *ThreadContext) writeMemory(addr uintptr, data []byte) error {
+	tx := thread.BeginWrite()
+	if err := tx.WriteMemory(addr, data); err != nil {
+		return err
+	}
+	tx.Commit()
+	return nil
+}
+
 // Fetches all variables of a specific type in the current function scope
 func (thread *ThreadContext) variablesByTag(tag dwarf.Tag) ([]*Variable, error) {
-	pc, err := thread.CurrentPC()
+	pc, err := thread.scopePC()
 	if err != nil {
 		return nil, err
 	}
 
+	pc = thread.Process.dwarfPC(pc)
 	reader := thread.Process.DwarfReader()
 
 	_, err = reader.SeekToFunction(pc)
@@ -936,7 +879,7 @@ func (thread *ThreadContext) variablesByTag(tag dwarf.Tag) ([]*Variable, error)
 
 	vars := make([]*Variable, 0)
 
-	for entry, err := reader.NextScopeVariable(); entry != nil; entry, err = reader.NextScopeVariable() {
+	for entry, err := reader.NextScopeVariableAt(pc); entry != nil; entry, err = reader.NextScopeVariableAt(pc) {
 		if err != nil {
 			return nil, err
 		}
@@ -1,10 +1,14 @@
 package proctl
 
 import (
+	"debug/dwarf"
 	"errors"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
+
+	"github.com/derekparker/delve/dwarf/types"
 )
 
 type varTest struct {
@@ -59,6 +63,29 @@ func TestVariableEvaluation(t *testing.T) {
 		{"a9.Baz", "nil", "int", errors.New("a9 is nil")},
 		{"a9.NonExistent", "nil", "int", errors.New("a9 has no member NonExistent")},
 		{"a8", "main.FooBar2 {Bur: 10, Baz: feh}", "main.FooBar2", nil}, // reread variable after member
+		{"*a7", "main.FooBar {Baz: 5, Bur: strum}", "main.FooBar", nil},
+		{"(*a7).Bur", "strum", "struct string", nil},
+		{"ms.Nest.Nest.Level", "2", "int", nil},
+		{"*a9", "nil", "main.FooBar", errors.New("a9 is nil")},
+		{"a4[1]", "2", "int", nil},
+		{"a5[a4[0]]", "2", "int", nil},
+		{"a11[2].Bur", "c", "struct string", nil},
+		{"a13[1].Baz", "7", "int", nil},
+		{"a1[0]", "102", "uint8", nil},
+		{"a5[5]", "", "", errors.New("index 5 out of bounds [0, 5)")},
+		{"a5[1:3]", "[]int len: 2, cap: 4, [2,3]", "[]int", nil},
+		{"a4[:1]", "[]int len: 1, cap: 2, [1]", "[]int", nil},
+		{"a1[3:6]", "foo", "struct string", nil},
+		{"a5[2:6]", "", "", errors.New("slice bounds out of range [2:6] with capacity 5")},
+		{"len(a5)", "5", "int", nil},
+		{"cap(a12)", "2", "int", nil},
+		{"len(a1)", "18", "int", nil},
+		{"len(a4)", "2", "int", nil},
+		{"a5[len(a4)]", "3", "int", nil},
+		{"cap(a1)", "", "", errors.New("invalid argument a1 (type struct string) for cap")},
+		{"int8(a2)", "6", "int8", nil},
+		{"int(a3)", "7", "int", nil},
+		{"a2.(int)", "", "", errors.New("invalid type assertion: a2 (non-interface type int)")},
 		{"i32", "[2]int32 [1,2]", "[2]int32", nil},
 		{"b1", "true", "bool", nil},
 		{"b2", "false", "bool", nil}, {"i8", "1", "int8", nil},
@@ -71,6 +98,22 @@ func TestVariableEvaluation(t *testing.T) {
 		{"ba", "[]int len: 200, cap: 200, [0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,...+136 more]", "struct []int", nil},
 		{"ms", "main.Nest {Level: 0, Nest: *main.Nest {Level: 1, Nest: *main.Nest {...}}}", "main.Nest", nil},
 		{"NonExistent", "", "", errors.New("could not find symbol value for NonExistent")},
+		{"a2 + 1", "7", "int", nil},
+		{"a2*a4[1] - 1", "11", "int", nil},
+		{"a3 / 2", "3.615", "float64", nil},
+		{"1 + 2.5", "3.5", "float64", nil},
+		{"1<<3 | 1", "9", "int", nil},
+		{"u8 + 1", "0", "uint8", nil},
+		{"-neg", "1", "int", nil},
+		{"!b1", "false", "bool", nil},
+		{"a2 > 5 && b2 == false", "true", "bool", nil},
+		{"a9 == nil || a9.Baz == 0", "true", "bool", nil},
+		{"len(a5) >= 5 && a5[4] == 5", "true", "bool", nil},
+		{"a1[0] == 'f'", "true", "bool", nil},
+		{"baz + a10", "bazburzumofo", "struct string", nil},
+		{"*&a2", "6", "int", nil},
+		{"a2 / 0", "", "", errors.New("invalid operation: a2 / 0 (division by zero)")},
+		{"a2 + a1", "", "", errors.New("invalid operation: a2 + a1 (mismatched types int64 and string)")},
 	}
 
 	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
@@ -93,6 +136,314 @@ func TestVariableEvaluation(t *testing.T) {
 				}
 			}
 		}
+
+		addr, err := p.EvalSymbol("&a2")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		expected, err := p.EvalSymbolFormat("a2", FormatAddress)
+		assertNoError(err, t, "EvalSymbolFormat() returned an error")
+		assertVariable(t, addr, varTest{"&a2", expected.Value, "*int", nil})
+	})
+}
+
+func TestMapEvaluation(t *testing.T) {
+	fp, err := filepath.Abs("../_fixtures/testmaps.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []varTest{
+		{"small[\"two\"]", "2", "int", nil},
+		{"small[\"four\"]", "", "", errors.New("small has no key \"four\"")},
+		{"large[42]", "v42", "struct string", nil},
+		{"large[100]", "", "", errors.New("large has no key 100")},
+		{"split[4999]", "9998", "int", nil},
+		{"split[-1]", "", "", errors.New("split has no key -1")},
+		{"nilmap[\"one\"]", "", "", errors.New("nilmap has no key \"one\"")},
+		{"len(small)", "3", "int", nil},
+		{"len(large)", "100", "int", nil},
+		{"len(split)", "5000", "int", nil},
+		{"len(nilmap)", "0", "int", nil},
+		{"cap(small)", "", "", errors.New("invalid argument small (type map[string]int) for cap")},
+		{"len(ch)", "2", "int", nil},
+		{"cap(ch)", "10", "int", nil},
+		{"len(nilchan)", "0", "int", nil},
+		{"cap(nilchan)", "0", "int", nil},
+		{"len(s)", "3", "int", nil},
+		{"cap(s)", "10", "int", nil},
+		{"len(str)", "5", "int", nil},
+	}
+
+	withTestProcess("../_fixtures/testmaps", t, func(p *DebuggedProcess) {
+		pc, _, err := p.GoSymTable.LineToPC(fp, 25)
+		assertNoError(err, t, "LineToPC()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		for _, tc := range testcases {
+			variable, err := p.EvalSymbol(tc.name)
+			if tc.err == nil {
+				assertNoError(err, t, "EvalSymbol("+tc.name+")")
+				assertVariable(t, variable, tc)
+			} else if err == nil || tc.err.Error() != err.Error() {
+				t.Fatalf("Unexpected error for %s. Expected %s got %v", tc.name, tc.err, err)
+			}
+		}
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 57)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+		assertNoError(p.Continue(), t, "Continue() returned an error")
+
+		cfg := LoadConfig{FollowPointers: true, MaxVariableRecurse: 0, MaxStringLen: 3, MaxArrayValues: 2}
+		assertNoError(p.Setup(Config{LoadConfig: &cfg}), t, "Setup() returned an error")
+		for _, tc := range []varTest{
+			{"a1", "foo...+15 more", "struct string", nil},
+			{"a1[3:9]", "foo...+3 more", "struct string", nil},
+			{"a5", "[]int len: 5, cap: 5, [1,2,...+3 more]", "struct []int", nil},
+			{"ms", "main.Nest {Level: 0, Nest: *main.Nest {...}}", "main.Nest", nil},
+			// Evaluation still reads whole values.
+			{"len(a1)", "18", "int", nil},
+			{"a1 == \"foofoofoofoofoofoo\"", "true", "bool", nil},
+		} {
+			variable, err := p.EvalSymbol(tc.name)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc)
+		}
+
+		cfg.FollowPointers = false
+		variable, err := p.EvalSymbol("a7")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		addr, err := p.EvalSymbolFormat("*a7", FormatAddress)
+		assertNoError(err, t, "EvalSymbolFormat() returned an error")
+		assertVariable(t, variable, varTest{"a7", "*main.FooBar " + addr.Value, "*main.FooBar", nil})
+	})
+}
+
+func TestVariableChildren(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 57)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+		assertNoError(p.Continue(), t, "Continue() returned an error")
+
+		children := func(name string) []*Variable {
+			v, err := p.EvalSymbol(name)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			c, err := v.Children()
+			assertNoError(err, t, "Children() returned an error")
+			return c
+		}
+
+		a7 := children("a7")
+		if len(a7) != 1 || a7[0].Kind != types.Struct {
+			t.Fatalf("Expected the struct a7 points to, got %v", a7)
+		}
+		assertVariable(t, a7[0], varTest{"*a7", "main.FooBar {Baz: 5, Bur: strum}", "main.FooBar", nil})
+		fields, err := a7[0].Children()
+		assertNoError(err, t, "Children() returned an error")
+		if len(fields) != 2 {
+			t.Fatalf("Expected 2 fields, got %v", fields)
+		}
+		assertVariable(t, fields[0], varTest{"Baz", "5", "int", nil})
+		assertVariable(t, fields[1], varTest{"Bur", "strum", "struct string", nil})
+		if fields[1].Kind != types.String || fields[1].Len != 5 {
+			t.Fatalf("Expected a string of length 5, got %v", fields[1])
+		}
+
+		a5, err := p.EvalSymbol("a5")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if a5.Kind != types.Slice || a5.Len != 5 || a5.Cap != 5 || a5.Addr == 0 {
+			t.Fatalf("Expected a slice of length and capacity 5, got %v", a5)
+		}
+		elems, err := a5.Elements(3, 10)
+		assertNoError(err, t, "Elements() returned an error")
+		if len(elems) != 2 {
+			t.Fatalf("Expected 2 elements, got %v", elems)
+		}
+		assertVariable(t, elems[1], varTest{"a5[4]", "5", "int", nil})
+
+		if ba := children("ba"); len(ba) != DefaultLoadConfig.MaxArrayValues {
+			t.Fatalf("Expected %d elements, got %d", DefaultLoadConfig.MaxArrayValues, len(ba))
+		}
+		if c := children("a4"); len(c) != 2 || c[1].Value != "2" {
+			t.Fatalf("Expected the elements of a4, got %v", c)
+		}
+		if c := children("a9"); len(c) != 0 {
+			t.Fatalf("Expected no children for a nil pointer, got %v", c)
+		}
+
+		sum, err := p.EvalSymbol("a2 + 1")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if sum.Kind != types.Int || sum.Addr != 0 {
+			t.Fatalf("Expected a computed int, got %v", sum)
+		}
+	})
+}
+
+func TestPrettyPrinters(t *testing.T) {
+	executablePath := "../_fixtures/testprettyprint"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 26)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+		assertNoError(p.Continue(), t, "Continue() returned an error")
+
+		for _, tc := range []varTest{
+			{"t1", "time.Time {2009-11-10 23:00:00.000000005 +0000 UTC}", "time.Time", nil},
+			{"mu", "*sync.Mutex {locked: true, waiters: 0, waiting: []}", "*sync.Mutex", nil},
+			{"sb", `*strings.Builder {"built"}`, "*strings.Builder", nil},
+			{"buf", `*bytes.Buffer {"rest"}`, "*bytes.Buffer", nil},
+			{"n1", "*math/big.Int {1267650600228229401496703205376}", "*math/big.Int", nil},
+			{"n2", "*math/big.Int {-42}", "*math/big.Int", nil},
+		} {
+			variable, err := p.EvalSymbol(tc.name)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc)
+		}
+
+		variable, err := p.EvalSymbol("err")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		if !strings.Contains(variable.Value, `{"boom"}`) {
+			t.Fatalf("Expected the message of err, got %s", variable.Value)
+		}
+
+		RegisterPrettyPrinter("math/big.Int", func(thread *ThreadContext, addr uint64, t *dwarf.StructType) (string, error) {
+			return "big", nil
+		})
+		defer RegisterPrettyPrinter("math/big.Int", (*ThreadContext).readBigInt)
+		variable, err = p.EvalSymbol("n2")
+		assertNoError(err, t, "EvalSymbol() returned an error")
+		assertVariable(t, variable, varTest{"n2", "*math/big.Int {big}", "*math/big.Int", nil})
+	})
+}
+
+func TestSetSymbol(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name, value string
+		expected    varTest
+	}{
+		{"a2", "42", varTest{"a2", "42", "int", nil}},
+		{"a3", "-1.5", varTest{"a3", "-1.5", "float64", nil}},
+		{"b1", "false", varTest{"b1", "false", "bool", nil}},
+		{"a7.Baz", "a2", varTest{"a7.Baz", "42", "int", nil}},
+		{"a9", "a7", varTest{"a9", "*main.FooBar {Baz: 42, Bur: strum}", "*main.FooBar", nil}},
+		{"a1", "baz", varTest{"a1", "bazburzum", "struct string", nil}},
+		{"a10", `""`, varTest{"a10", "", "struct string", nil}},
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		for _, tc := range testcases {
+			assertNoError(p.SetSymbol(tc.name, tc.value), t, "SetSymbol() returned an error")
+			variable, err := p.EvalSymbol(tc.name)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			assertVariable(t, variable, tc.expected)
+		}
+
+		if err := p.SetSymbol("i8", "1000"); err == nil {
+			t.Fatal("Expected an error setting i8 to 1000")
+		}
+		if err := p.SetSymbol("a1", `"new"`); err == nil {
+			t.Fatal("Expected an error setting a1 to a new string")
+		}
+	})
+}
+
+func TestWatchExpressions(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		if _, err := p.AddWatchExpression("a2 +"); err == nil {
+			t.Fatal("Expected an error adding an invalid expression")
+		}
+		for _, expr := range []string{"a2", "a7.Baz", "nonexistent"} {
+			_, err := p.AddWatchExpression(expr)
+			assertNoError(err, t, "AddWatchExpression()")
+		}
+
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 57)
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		events := make(chan Event, 8)
+		p.Notify(events)
+		defer p.StopNotify(events)
+		assertNoError(p.Continue(), t, "Continue() returned an error")
+
+		var stop *Event
+		for len(events) > 0 {
+			if ev := <-events; ev.Kind == EventStopped {
+				stop = &ev
+			}
+		}
+		if stop == nil {
+			t.Fatal("Expected a stopped event")
+		}
+		if len(stop.Watches) != 3 {
+			t.Fatalf("Expected 3 watch values, got %d", len(stop.Watches))
+		}
+
+		values := p.WatchValues()
+		for i, expected := range []string{"6", "5"} {
+			if values[i].Err != nil || values[i].Value.Value != expected {
+				t.Fatalf("Expected %s, got %s", expected, values[i])
+			}
+		}
+		if values[2].Err == nil {
+			t.Fatalf("Expected an error evaluating nonexistent, got %s", values[2])
+		}
+
+		assertNoError(p.ClearWatchExpression(values[2].ID), t, "ClearWatchExpression()")
+		if err := p.ClearWatchExpression(values[2].ID); err == nil {
+			t.Fatal("Expected an error clearing a cleared watch expression")
+		}
+		if exprs := p.WatchExpressions(); len(exprs) != 2 {
+			t.Fatalf("Expected 2 watch expressions, got %v", exprs)
+		}
 	})
 }
 
@@ -227,3 +578,142 @@ func TestLocalVariables(t *testing.T) {
 		}
 	})
 }
+
+func TestAllM(t *testing.T) {
+	withTestProcess("../_fixtures/testprog", t, func(p *DebuggedProcess) {
+		// Resolved as the program was loaded.
+		p.typeMu.Lock()
+		_, ok := p.structTypes["runtime.m"]
+		p.typeMu.Unlock()
+		if !ok {
+			t.Fatal("Expected runtime.m to be resolved at load time")
+		}
+
+		pc, err := p.FindLocation("main.sleepytime")
+		assertNoError(err, t, "FindLocation()")
+		_, err = p.Break(pc)
+		assertNoError(err, t, "Break()")
+		assertNoError(p.Continue(), t, "Continue()")
+
+		allm, err := p.CurrentThread.AllM()
+		assertNoError(err, t, "AllM()")
+		found := false
+		for _, m := range allm {
+			if m.procid == p.CurrentThread.Id {
+				found = true
+				if m.curg == 0 {
+					t.Fatalf("Expected the M of thread %d to run a goroutine", m.procid)
+				}
+			}
+		}
+		if !found {
+			t.Fatalf("Expected an M for thread %d, got %d Ms", p.CurrentThread.Id, len(allm))
+		}
+	})
+}
+
+func TestSelectFrame(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 23)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		thread := p.CurrentThread
+		expect := func(name, value string) {
+			v, err := thread.EvalSymbol(name)
+			assertNoError(err, t, "EvalSymbol() returned an error")
+			if v.Value != value {
+				t.Fatalf("Expected %s = %s in frame %d, got %s", name, value, thread.SelectedFrame(), v.Value)
+			}
+		}
+		expect("a1", "bur")
+
+		// foobar, calling barfoo.
+		assertNoError(thread.SelectFrame(1), t, "SelectFrame() returned an error")
+		expect("a1", "foofoofoofoofoofoo")
+		expect("a2+1", "7")
+		args, err := thread.FunctionArguments()
+		assertNoError(err, t, "FunctionArguments() returned an error")
+		if len(args) != 2 || args[0].Name != "baz" || args[0].Value != "bazburzum" {
+			t.Fatalf("Expected the arguments of foobar, got %v", args)
+		}
+
+		if err := thread.SelectFrame(100); err == nil {
+			t.Fatal("Expected an error selecting a frame past the bottom of the stack")
+		}
+		if thread.SelectedFrame() != 1 {
+			t.Fatalf("Expected frame 1 to stay selected, got %d", thread.SelectedFrame())
+		}
+		assertNoError(thread.SelectFrame(0), t, "SelectFrame() returned an error")
+		expect("a1", "bur")
+	})
+}
+
+func TestFunctionArgumentsOrder(t *testing.T) {
+	executablePath := "../_fixtures/testvariables"
+
+	fp, err := filepath.Abs(executablePath + ".go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withTestProcess(executablePath, t, func(p *DebuggedProcess) {
+		pc, _, _ := p.GoSymTable.LineToPC(fp, 57)
+
+		_, err := p.Break(pc)
+		assertNoError(err, t, "Break() returned an error")
+
+		err = p.Continue()
+		assertNoError(err, t, "Continue() returned an error")
+
+		args, err := p.CurrentThread.FunctionArguments()
+		assertNoError(err, t, "FunctionArguments() returned an error")
+
+		expected := []string{"baz", "bar"}
+		if len(args) != len(expected) {
+			t.Fatalf("Invalid argument count. Expected %d got %d.", len(expected), len(args))
+		}
+		for i, arg := range args {
+			if arg.Name != expected[i] {
+				t.Fatalf("Expected argument %d to be %s got %s", i, expected[i], arg.Name)
+			}
+		}
+	})
+}
+
+func TestFormatInteger(t *testing.T) {
+	testcases := []struct {
+		v        interface{}
+		format   Format
+		expected string
+	}{
+		{int64(255), FormatHex, "0xff"},
+		{int64(-255), FormatHex, "-0xff"},
+		{uint64(5), FormatBinary, "0b101"},
+		{int64(8), FormatOctal, "0o10"},
+		{int64(97), FormatRune, "'a'"},
+	}
+
+	for _, tc := range testcases {
+		s, err := formatInteger(tc.v, tc.format)
+		assertNoError(err, t, "formatInteger()")
+		if s != tc.expected {
+			t.Fatalf("Expected %s got %s", tc.expected, s)
+		}
+	}
+
+	if _, err := formatInteger(1.5, FormatHex); err == nil {
+		t.Fatal("Expected an error formatting a float")
+	}
+}
@@ -0,0 +1,127 @@
+package proctl
+
+import (
+	"debug/dwarf"
+	"fmt"
+
+	"github.com/derekparker/delve/dwarf/types"
+)
+
+// Returns the variable of type t at addr, with its value read as
+// EvalSymbol prints it.
+func (thread *ThreadContext) newVariable(name string, addr uint64, t dwarf.Type) (*Variable, error) {
+	val, err := thread.extractValue(nil, int64(addr), t, true)
+	if err != nil {
+		return nil, err
+	}
+	v := &Variable{Name: name, Value: val, Type: t.String(), Kind: thread.Process.goType(t).Kind, Addr: addr, thread: thread, typ: t}
+	switch v.Kind {
+	case types.Array, types.String, types.Slice, types.Map, types.Chan:
+		if v.Len, v.Cap, _, err = thread.Process.lenCap(addr, t); err != nil {
+			return nil, err
+		}
+		if v.Cap < 0 {
+			v.Cap = 0
+		}
+	}
+	return v, nil
+}
+
+// Returns a variable whose value was computed by the debugger, e.g. the
+// result of an operator, which is in no memory to read children from.
+func (thread *ThreadContext) computedVariable(name string, t dwarf.Type, val string) *Variable {
+	return &Variable{Name: name, Value: val, Type: t.String(), Kind: thread.Process.goType(t).Kind}
+}
+
+// Returns the variables a client expanding v shows under it: the value
+// a pointer points to, the members of a struct, the elements of an
+// array or slice, and the dynamic value of an interface. They are read
+// from the process when asked for, to only read large values as far as
+// they are looked at, and until the process is resumed. At most the
+// MaxArrayValues of the LoadConfig of the process elements are
+// returned, see Elements for the others. Values computed by the
+// debugger and values of other kinds have no children.
+func (v *Variable) Children() ([]*Variable, error) {
+	if v.typ == nil {
+		return nil, nil
+	}
+	thread := v.thread
+	switch v.Kind {
+	case types.Pointer:
+		if thread.Process.goType(v.typ).Elem == nil {
+			return nil, nil
+		}
+		p, err := thread.Process.readPointer(v.Addr)
+		if err != nil || p == 0 {
+			return nil, err
+		}
+		child, err := thread.newVariable("*"+v.Name, p, resolveTypedef(v.typ).(*dwarf.PtrType).Type)
+		if err != nil {
+			return nil, err
+		}
+		return []*Variable{child}, nil
+	case types.Struct:
+		st := resolveTypedef(v.typ).(*dwarf.StructType)
+		children := make([]*Variable, 0, len(st.Field))
+		for _, f := range st.Field {
+			child, err := thread.newVariable(f.Name, v.Addr+uint64(f.ByteOffset), f.Type)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+		return children, nil
+	case types.Array, types.Slice:
+		return v.Elements(0, loadLimit(v.Len, thread.Process.loadConfig().MaxArrayValues))
+	case types.Interface:
+		addr, dyn, err := thread.Process.interfaceValue(v.Addr, resolveTypedef(v.typ).(*dwarf.StructType))
+		if err != nil || dyn == nil {
+			return nil, err
+		}
+		child, err := thread.newVariable(fmt.Sprintf("%s.(%s)", v.Name, dyn), addr, dyn)
+		if err != nil {
+			return nil, err
+		}
+		return []*Variable{child}, nil
+	}
+	return nil, nil
+}
+
+// Returns at most n elements of an array or slice variable, starting
+// with element start, all of those left if n is negative, to page
+// through those Children leaves out.
+func (v *Variable) Elements(start, n int64) ([]*Variable, error) {
+	if v.typ == nil || (v.Kind != types.Array && v.Kind != types.Slice) {
+		return nil, fmt.Errorf("%s (type %s) has no elements", v.Name, v.Type)
+	}
+	if start < 0 || start > v.Len {
+		return nil, fmt.Errorf("index %d out of bounds [0, %d]", start, v.Len)
+	}
+	if n < 0 || start+n > v.Len {
+		n = v.Len - start
+	}
+
+	var (
+		base = v.Addr
+		elem dwarf.Type
+	)
+	switch t := resolveTypedef(v.typ).(type) {
+	case *dwarf.ArrayType:
+		elem = t.Type
+	case *dwarf.StructType:
+		var err error
+		if base, _, _, elem, err = v.thread.Process.sliceHeader(v.Addr, t); err != nil {
+			return nil, err
+		}
+	}
+	stride := elemSize(elem)
+	elems := make([]*Variable, 0, n)
+	for i := start; i < start+n; i++ {
+		child, err := v.thread.newVariable(fmt.Sprintf("%s[%d]", v.Name, i), base+uint64(i)*stride, elem)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, child)
+	}
+	return elems, nil
+}
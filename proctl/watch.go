@@ -0,0 +1,79 @@
+package proctl
+
+import "fmt"
+
+// Number of bytes a single debug register can watch.
+const maxWatchSize = 8
+
+// Sets a hardware watchpoint which stops the process after the package
+// variable name, e.g. "main.counter", is written to. Watchpoints use
+// the debug registers of hardware breakpoints, so only four of either
+// can be set at once.
+//
+// A debug register watches at most 8 bytes, for larger variables only
+// the most significant word is watched and the watchpoint is marked
+// Partial.
+func (dbp *DebuggedProcess) WatchGlobal(name string) (*BreakPoint, error) {
+	addr, t, err := dbp.globalVariable(name)
+	if err != nil {
+		return nil, err
+	}
+	size := typeSize(t)
+	if size <= 0 {
+		return nil, fmt.Errorf("cannot watch %s of type %s", name, t)
+	}
+	waddr, wsize := watchRange(addr, uint64(size))
+
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	if dbp.breakpointExists(waddr) {
+		return nil, fmt.Errorf("%#x is already watched", waddr)
+	}
+	for i, v := range dbp.HWBreakPoints {
+		if v != nil {
+			continue
+		}
+		// Writes may come from any thread.
+		for _, th := range dbp.Threads {
+			if err := dbp.backend.setHardwareWatchpoint(i, th.Id, waddr, wsize); err != nil {
+				for _, th := range dbp.Threads {
+					dbp.backend.clearHardwareBreakpoint(i, th.Id)
+				}
+				return nil, fmt.Errorf("could not set watchpoint: %v", err)
+			}
+		}
+		bp := dbp.newBreakpoint("", "", 0, waddr, nil)
+		bp.Variable = name
+		bp.WatchSize = wsize
+		bp.Partial = waddr > addr || waddr+uint64(wsize) < addr+uint64(size)
+		dbp.HWBreakPoints[i] = bp
+		return bp, nil
+	}
+	return nil, fmt.Errorf("no free debug register to watch %s", name)
+}
+
+// Returns the watchpoint that stopped thread, if any.
+func (dbp *DebuggedProcess) watchpointHit(thread *ThreadContext) (*BreakPoint, error) {
+	reg, err := dbp.backend.hardwareBreakpointHit(thread)
+	if err != nil || reg < 0 {
+		return nil, err
+	}
+	if bp := dbp.HWBreakPoints[reg]; bp != nil && bp.Variable != "" {
+		return bp, nil
+	}
+	return nil, nil
+}
+
+// Returns the address and size of the smallest range a debug register
+// can watch covering the size bytes at addr. Debug registers watch 1,
+// 2, 4 or 8 bytes aligned to their size, when that is not enough the
+// word holding the last, most significant, byte is returned.
+func watchRange(addr, size uint64) (uint64, int) {
+	for n := uint64(1); n <= maxWatchSize; n *= 2 {
+		start := addr &^ (n - 1)
+		if start+n >= addr+size {
+			return start, int(n)
+		}
+	}
+	return (addr + size - 1) &^ (maxWatchSize - 1), maxWatchSize
+}
@@ -0,0 +1,86 @@
+package proctl
+
+import "fmt"
+
+// An expression evaluated every time the process stops, see
+// AddWatchExpression.
+type WatchExpression struct {
+	ID   int
+	Expr string
+}
+
+// The value of a watch expression when the process last stopped.
+type WatchValue struct {
+	WatchExpression
+	Value *Variable // Nil if the expression could not be evaluated
+	Err   error
+}
+
+func (wv WatchValue) String() string {
+	if wv.Err != nil {
+		return fmt.Sprintf("%d: %s = <%s>", wv.ID, wv.Expr, wv.Err)
+	}
+	return fmt.Sprintf("%d: %s = %s", wv.ID, wv.Expr, wv.Value.Value)
+}
+
+// Adds expr, an expression as accepted by EvalSymbol, to those
+// evaluated every time the process stops after being resumed, at a
+// breakpoint, after a step or a manual stop, in the scope of the
+// current thread. Their values are sent with the EventStopped event,
+// and returned by WatchValues until the next stop. Expressions which
+// can't be evaluated there, e.g. locals of other functions, have an
+// error as value. Watch expressions are kept across restarts.
+func (dbp *DebuggedProcess) AddWatchExpression(expr string) (*WatchExpression, error) {
+	if _, err := parseExpr(expr); err != nil {
+		return nil, err
+	}
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	dbp.watchExprIDCounter++
+	we := WatchExpression{ID: dbp.watchExprIDCounter, Expr: expr}
+	dbp.watchExprs = append(dbp.watchExprs, we)
+	return &we, nil
+}
+
+// Removes the watch expression with the given id.
+func (dbp *DebuggedProcess) ClearWatchExpression(id int) error {
+	dbp.mu.Lock()
+	defer dbp.mu.Unlock()
+	for i, we := range dbp.watchExprs {
+		if we.ID == id {
+			dbp.watchExprs = append(dbp.watchExprs[:i], dbp.watchExprs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no watch expression with id %d", id)
+}
+
+// Returns the watch expressions, by id.
+func (dbp *DebuggedProcess) WatchExpressions() []WatchExpression {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return append([]WatchExpression(nil), dbp.watchExprs...)
+}
+
+// Returns the values the watch expressions had when the process last
+// stopped, nil if it didn't run yet.
+func (dbp *DebuggedProcess) WatchValues() []WatchValue {
+	dbp.mu.RLock()
+	defer dbp.mu.RUnlock()
+	return dbp.watchValues
+}
+
+// Evaluates the watch expressions as the process just stopped, and
+// reports the stop as an EventStopped.
+func (dbp *DebuggedProcess) stopped() {
+	var values []WatchValue
+	for _, we := range dbp.WatchExpressions() {
+		wv := WatchValue{WatchExpression: we}
+		wv.Value, wv.Err = dbp.EvalSymbol(we.Expr)
+		values = append(values, wv)
+	}
+	dbp.mu.Lock()
+	dbp.watchValues = values
+	dbp.mu.Unlock()
+	dbp.emit(Event{Kind: EventStopped, Thread: dbp.CurrentThread.Id, Watches: values})
+}